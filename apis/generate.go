@@ -29,6 +29,9 @@ limitations under the License.
 // Generate crossplane-runtime methodsets (resource.Claim, etc)
 //go:generate go run -tags generate github.com/crossplane/crossplane-tools/cmd/angryjet generate-methodsets --header-file=../hack/boilerplate.go.txt ./...
 
+// Generate the gRPC messages and stubs of the admin API
+//go:generate go run github.com/bufbuild/buf/cmd/buf@v1.29.0 generate
+
 package apis
 
 import (
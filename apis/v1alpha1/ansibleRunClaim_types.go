@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A AnsibleRunClaimSpec defines the desired state of a AnsibleRunClaim.
+type AnsibleRunClaimSpec struct {
+	// ProviderConfigReference is the ProviderConfig of the AnsibleRun of
+	// the claim, which must allow the namespace of the claim in its
+	// claimNamespaces.
+	// +kubebuilder:default={"name": "default"}
+	// +optional
+	ProviderConfigReference *xpv1.Reference `json:"providerConfigRef,omitempty"`
+
+	// WriteConnectionSecretToReference is the Secret, in the namespace of
+	// the claim, the connection details of the AnsibleRun are written to.
+	// +optional
+	WriteConnectionSecretToReference *xpv1.LocalSecretReference `json:"writeConnectionSecretToRef,omitempty"`
+
+	// ForProvider are the parameters of the AnsibleRun of the claim. Their
+	// credentials must be read from Secrets of the namespace of the claim,
+	// and their ServiceAccount must be of the namespace of the claim.
+	ForProvider AnsibleRunParameters `json:"forProvider"`
+}
+
+// A AnsibleRunClaimStatus represents the observed state of a AnsibleRunClaim.
+type AnsibleRunClaimStatus struct {
+	xpv1.ConditionedStatus `json:",inline"`
+
+	// RunName is the name of the cluster-scoped AnsibleRun of the claim.
+	// +optional
+	RunName string `json:"runName,omitempty"`
+
+	// AtProvider is the observed state of the AnsibleRun of the claim.
+	// +optional
+	AtProvider AnsibleRunObservation `json:"atProvider,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AnsibleRunClaim is a namespaced AnsibleRun, through which the tenants of a
+// namespace run Ansible contents with the RBAC of the namespace. The claim
+// makes a cluster-scoped AnsibleRun and reports its status, like the claims
+// of the composite resources.
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="SYNCED",type="string",JSONPath=".status.conditions[?(@.type=='Synced')].status"
+// +kubebuilder:printcolumn:name="RUN",type="string",JSONPath=".status.runName"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.atProvider.phase"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:resource:scope=Namespaced
+type AnsibleRunClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AnsibleRunClaimSpec   `json:"spec"`
+	Status AnsibleRunClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AnsibleRunClaimList is a collection of AnsibleRunClaim.
+type AnsibleRunClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AnsibleRunClaim `json:"items"`
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// AnsibleRunTemplateMetadata is the metadata of the AnsibleRuns of an AnsibleRunSet.
+type AnsibleRunTemplateMetadata struct {
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations of the AnsibleRuns, e.g. ansible.crossplane.io/runPolicy.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// AnsibleRunTemplate is the template of the AnsibleRuns of an AnsibleRunSet.
+type AnsibleRunTemplate struct {
+	// +optional
+	Metadata AnsibleRunTemplateMetadata `json:"metadata,omitempty"`
+
+	Spec AnsibleRunSpec `json:"spec"`
+}
+
+// AnsibleRunSetItem is a target of an AnsibleRunSet, for which an AnsibleRun
+// is made from the template.
+type AnsibleRunSetItem struct {
+	// Name of the item. The AnsibleRun of the item is named after the
+	// AnsibleRunSet and the item, i.e. <set name>-<item name>.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// InventoryInline replaces the inline inventory of the template, e.g.
+	// with the host of the item.
+	// +optional
+	InventoryInline *string `json:"inventoryInline,omitempty"`
+
+	// Vars of the item, merged over the vars of the template: the top-level
+	// keys of the item replace the ones of the template.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Vars runtime.RawExtension `json:"vars,omitempty"`
+}
+
+// A AnsibleRunSetSpec defines the desired state of a AnsibleRunSet.
+type AnsibleRunSetSpec struct {
+	// Template of the AnsibleRuns.
+	Template AnsibleRunTemplate `json:"template"`
+
+	// Items are the targets of the AnsibleRunSet, one AnsibleRun being made
+	// for each of them. The AnsibleRuns of removed items are deleted.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Items []AnsibleRunSetItem `json:"items,omitempty"`
+}
+
+// A AnsibleRunSetStatus represents the observed state of a AnsibleRunSet.
+type AnsibleRunSetStatus struct {
+	xpv1.ConditionedStatus `json:",inline"`
+
+	// Runs is the number of AnsibleRuns of the AnsibleRunSet.
+	// +optional
+	Runs int `json:"runs,omitempty"`
+
+	// ReadyRuns is the number of AnsibleRuns of the AnsibleRunSet that are ready.
+	// +optional
+	ReadyRuns int `json:"readyRuns,omitempty"`
+
+	// DriftedRuns is the number of AnsibleRuns of the AnsibleRunSet whose
+	// hosts drifted from the contents and were not corrected yet.
+	// +optional
+	DriftedRuns int `json:"driftedRuns,omitempty"`
+
+	// FailedRuns is the number of AnsibleRuns of the AnsibleRunSet whose
+	// last run failed.
+	// +optional
+	FailedRuns int `json:"failedRuns,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AnsibleRunSet makes AnsibleRuns from a template for each of its items, like
+// a ReplicaSet for runs, e.g. to configure a fleet of identical hosts.
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="RUNS",type="integer",JSONPath=".status.runs"
+// +kubebuilder:printcolumn:name="READY-RUNS",type="integer",JSONPath=".status.readyRuns"
+// +kubebuilder:printcolumn:name="DRIFTED",type="integer",JSONPath=".status.driftedRuns"
+// +kubebuilder:printcolumn:name="FAILED",type="integer",JSONPath=".status.failedRuns"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:resource:scope=Cluster
+type AnsibleRunSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AnsibleRunSetSpec   `json:"spec"`
+	Status AnsibleRunSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AnsibleRunSetList is a collection of AnsibleRunSet.
+type AnsibleRunSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AnsibleRunSet `json:"items"`
+}
@@ -18,16 +18,249 @@ package v1alpha1
 
 import (
 	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// Condition of the preparation of the working directory of an AnsibleRun.
+const (
+	// TypePrepared tells whether the working directory of an AnsibleRun
+	// went through all the stages preparing it for the runs. The reason of
+	// a failure names the stage that failed, e.g. InstallDepsFailed.
+	TypePrepared xpv1.ConditionType = "Prepared"
+
+	// ReasonPrepared is the reason of a prepared working directory.
+	ReasonPrepared xpv1.ConditionReason = "Prepared"
+)
+
+// Condition of the teardown of an AnsibleRun being deleted.
+const (
+	// TypeTornDown tells whether the run deleting an AnsibleRun succeeded. A
+	// failed teardown keeps the finalizer of the AnsibleRun, unless it is
+	// skipped.
+	TypeTornDown xpv1.ConditionType = "TornDown"
+
+	// ReasonTearingDown is the reason of a teardown in progress.
+	ReasonTearingDown xpv1.ConditionReason = "TearingDown"
+	// ReasonTeardownFailed is the reason of a failed teardown.
+	ReasonTeardownFailed xpv1.ConditionReason = "TeardownFailed"
+	// ReasonTornDown is the reason of a successful teardown.
+	ReasonTornDown xpv1.ConditionReason = "TornDown"
+	// ReasonTeardownSkipped is the reason of a teardown given up, because
+	// its runs failed beyond the deletion retry limit or because the
+	// AnsibleRun is force deleted, letting the finalizer go.
+	ReasonTeardownSkipped xpv1.ConditionReason = "TeardownSkipped"
+)
+
+// Condition of the drift of the hosts found by the CheckWhenObserve policy.
+const (
+	// TypeDrifted tells whether the last check mode run found changes that
+	// were not applied yet. It is true until the run correcting them
+	// succeeds.
+	TypeDrifted xpv1.ConditionType = "Drifted"
+
+	// ReasonDriftDetected is the reason of the changes found by a check mode
+	// run.
+	ReasonDriftDetected xpv1.ConditionReason = "DriftDetected"
+	// ReasonNoDrift is the reason of a check mode run finding no changes.
+	ReasonNoDrift xpv1.ConditionReason = "NoDrift"
+	// ReasonDriftCorrected is the reason of a run applying the changes found
+	// by a check mode run.
+	ReasonDriftCorrected xpv1.ConditionReason = "DriftCorrected"
+)
+
+// Condition of the changes waiting for a maintenance window of the schedule.
+const (
+	// TypePendingChanges tells whether a run applying the contents waits for
+	// the next maintenance window of the schedule of the AnsibleRun.
+	TypePendingChanges xpv1.ConditionType = "PendingChanges"
+
+	// ReasonOutsideMaintenanceWindow is the reason of a run deferred to the
+	// next maintenance window.
+	ReasonOutsideMaintenanceWindow xpv1.ConditionReason = "OutsideMaintenanceWindow"
+	// ReasonWithinMaintenanceWindow is the reason of a deferred run that
+	// is no longer held back by the schedule.
+	ReasonWithinMaintenanceWindow xpv1.ConditionReason = "WithinMaintenanceWindow"
+)
+
+// Condition of the drift of the collections installed into the collections
+// cache of a ProviderConfig, shared by its AnsibleRuns.
+const (
+	// TypeDependencyDrift tells whether the collections installed into the
+	// collections cache no longer match the lockfile recorded when they were
+	// installed, i.e. the shared directory was mutated outside of the
+	// provider.
+	TypeDependencyDrift xpv1.ConditionType = "DependencyDrift"
+
+	// ReasonDependencyDrifted is the reason of installed collections that
+	// no longer match their lockfile.
+	ReasonDependencyDrifted xpv1.ConditionReason = "DependencyDrifted"
+	// ReasonDependencyReinstalled is the reason of drifted collections
+	// installed again.
+	ReasonDependencyReinstalled xpv1.ConditionReason = "DependencyReinstalled"
+	// ReasonNoDependencyDrift is the reason of installed collections
+	// matching their lockfile.
+	ReasonNoDependencyDrift xpv1.ConditionReason = "NoDependencyDrift"
+)
+
+// Condition of the queueing of the runs of the AnsibleRuns of a concurrency
+// group, or locking their hosts.
+const (
+	// TypeQueued tells whether a run of an AnsibleRun waits for the run of
+	// another AnsibleRun of its concurrency group, or on the same hosts, to
+	// finish.
+	TypeQueued xpv1.ConditionType = "Queued"
+
+	// ReasonWaitingForGroup is the reason of a run waiting for its
+	// concurrency group.
+	ReasonWaitingForGroup xpv1.ConditionReason = "WaitingForConcurrencyGroup"
+	// ReasonWaitingForHosts is the reason of a run waiting for the lock of
+	// its hosts.
+	ReasonWaitingForHosts xpv1.ConditionReason = "WaitingForHosts"
+	// ReasonDequeued is the reason of a run that got its turn in its
+	// concurrency group.
+	ReasonDequeued xpv1.ConditionReason = "Dequeued"
+)
+
+// Condition of the throttling of the runs while the provider is short of
+// disk space or memory.
+const (
+	// TypeThrottled tells whether a run of an AnsibleRun waits for the disk
+	// or memory pressure on the provider to relieve.
+	TypeThrottled xpv1.ConditionType = "Throttled"
+
+	// ReasonDiskPressure is the reason of a run waiting for disk space to
+	// be freed on the volume of the working directories.
+	ReasonDiskPressure xpv1.ConditionReason = "DiskPressure"
+	// ReasonMemoryPressure is the reason of a run waiting for memory to be
+	// freed in the provider container.
+	ReasonMemoryPressure xpv1.ConditionReason = "MemoryPressure"
+	// ReasonPressureRelieved is the reason of a run that no longer waits
+	// for the pressure to relieve.
+	ReasonPressureRelieved xpv1.ConditionReason = "PressureRelieved"
+)
+
 // Role is definition of Ansible content role
 type Role struct {
 	Name string `json:"name"`
 	Src  string `json:"src"`
 	// +optional
 	Version string `json:"version,omitempty"`
+
+	// Scm is the source control of the src of the role, when it is not
+	// prefixed by it, e.g. git.
+	// +kubebuilder:validation:Enum=git;hg
+	// +optional
+	Scm string `json:"scm,omitempty"`
+
+	// Token authenticates to the https git repository of the role, so that
+	// private roles can be fetched without a .git-credentials file.
+	// +optional
+	Token *SourceToken `json:"token,omitempty"`
+
+	// Vars of the role, taking precedence over the vars of the AnsibleRun
+	// while the role runs.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Vars runtime.RawExtension `json:"vars,omitempty"`
+
+	// Tags are added to all the tasks of the role, so that the tags and
+	// the skip tags of the AnsibleRun select or skip the role as a whole.
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+
+	// Become runs all the tasks of the role with privilege escalation, as
+	// root unless the vars of the role or of the AnsibleRun set another
+	// ansible_become_user.
+	// +optional
+	Become bool `json:"become,omitempty"`
+}
+
+// Collection is an Ansible collection installed along with the roles.
+type Collection struct {
+	// Name of the collection, or its url, path or repository depending on
+	// its type.
+	Name string `json:"name"`
+
+	// Source is the Galaxy server or Automation Hub to install the
+	// collection from, for the galaxy type.
+	// +optional
+	Source string `json:"source,omitempty"`
+
+	// Type of the source of the collection. Defaults to galaxy.
+	// +kubebuilder:validation:Enum=galaxy;git;url;file;dir;subdirs
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// Version of the collection, or a range of versions, e.g.
+	// ">=1.0.0,<2.0.0". A branch, tag or commit for the git type.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Token authenticates to the https git repository of the collection,
+	// for the git type.
+	// +optional
+	Token *SourceToken `json:"token,omitempty"`
+}
+
+// SourceToken is a token authenticating to an https git repository.
+type SourceToken struct {
+	// Username sent along with the token. Defaults to x-access-token, which
+	// most git hosting services accept with any token.
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// Source of the token.
+	// +kubebuilder:validation:Enum=None;Secret;InjectedIdentity;Environment;Filesystem
+	Source xpv1.CredentialsSource `json:"source"`
+
+	xpv1.CommonCredentialSelectors `json:",inline"`
+}
+
+// ResolvedRoleVersion is the commit the floating version of a role fetched
+// from git resolved to.
+type ResolvedRoleVersion struct {
+	Name string `json:"name"`
+	// Version of the role, a branch, or empty for the default branch
+	// +optional
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit"`
+}
+
+// A LibraryPlaybook selects a playbook of a library of contents.
+type LibraryPlaybook struct {
+	// Name of the library, the directory holding it under the libraries
+	// directory of the provider.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	Name string `json:"name"`
+
+	// Playbook is the path of the playbook relative to the root of the
+	// library. The roles next to it, in its roles directory, are found by
+	// Ansible as usual.
+	// +kubebuilder:validation:MinLength=1
+	Playbook string `json:"playbook"`
+}
+
+// A Playbook is one of the playbooks an AnsibleRun runs in order, either
+// written inline or found at a path of its project directory.
+type Playbook struct {
+	// Name of the playbook, unique within the AnsibleRun. It names the
+	// playbook a run failed in, in the status of the AnsibleRun.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	Name string `json:"name"`
+
+	// Inline is the content of the playbook. It is rendered as a template
+	// along with the other inline playbooks. This field is mutually
+	// exclusive with the “path” field.
+	// +optional
+	Inline *string `json:"inline,omitempty"`
+
+	// Path of the playbook relative to the project directory, e.g. written
+	// by the files of the AnsibleRun. This field is mutually exclusive with
+	// the “inline” field.
+	// +optional
+	Path string `json:"path,omitempty"`
 }
 
 // AnsibleRunParameters are the configurable fields of a AnsibleRun.
@@ -45,20 +278,358 @@ type AnsibleRunParameters struct {
 	// +optional
 	ExecutableInventory bool `json:"executableInventory"`
 
+	// InventoryPlugins enumerate the hosts of this AnsibleRun dynamically
+	// with inventory plugins, e.g. amazon.aws.aws_ec2 or
+	// kubernetes.core.k8s, along with the static inventories.
+	// +optional
+	InventoryPlugins []InventoryPlugin `json:"inventoryPlugins,omitempty"`
+
+	// KubernetesInventories enumerate the hosts of this AnsibleRun from the
+	// Nodes, Pods or Cluster API Machines selected by labels, e.g. to
+	// configure the nodes of a cluster. They are written into the hosts
+	// file after the inventories and before the inline inventory.
+	// +optional
+	KubernetesInventories []KubernetesInventory `json:"kubernetesInventories,omitempty"`
+
 	// The inline configuration of this AnsibleRun;  the content of a simple playbook.yml file may be written inline.
 	// This field is mutually exclusive with the “roles” field.
 	// +optional
 	PlaybookInline *string `json:"playbookInline"`
 
+	// CreatePlaybookInline replaces the inline playbook for the first run of
+	// this AnsibleRun, e.g. to run bootstrap-only tasks such as enrollment.
+	// The following runs converge with the inline playbook. Requires the
+	// “playbookInline” field.
+	// +optional
+	CreatePlaybookInline *string `json:"createPlaybookInline,omitempty"`
+
+	// Playbooks run in order in a single run, sharing the vars of this
+	// AnsibleRun, a host failing a playbook not running the following ones.
+	// This field is mutually exclusive with the “playbookInline”, “roles”
+	// and “library” fields.
+	// +optional
+	Playbooks []Playbook `json:"playbooks,omitempty"`
+
+	// CreateTags are added, along with the "all" tag, to the tags of the
+	// first run of this AnsibleRun, so that the tasks tagged with "never"
+	// and one of them only run on the first run.
+	// +optional
+	CreateTags []string `json:"createTags,omitempty"`
+
+	// Tags restricts the runs to the tasks tagged with any of them, passed
+	// to ansible-playbook as --tags. The create tags are added to them for
+	// the first run.
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+
+	// SkipTags skips the tasks tagged with any of them, passed to
+	// ansible-playbook as --skip-tags.
+	// +optional
+	SkipTags []string `json:"skipTags,omitempty"`
+
+	// Limit restricts the runs to the hosts matching this pattern, passed
+	// to ansible-playbook as --limit, e.g. "web:!web3".
+	// +optional
+	Limit string `json:"limit,omitempty"`
+
+	// Verbosity of the runs, from 0 to 4, passed to ansible-playbook as
+	// -v to -vvvv.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4
+	// +optional
+	Verbosity int `json:"verbosity,omitempty"`
+
+	// ExtraCmdlineArgs are passed as is to ansible-playbook after the other
+	// arguments, one argument per item, e.g. ["--forks", "20"]. The
+	// arguments the provider manages, such as the inventory, the check
+	// mode, the extra vars, the vault and the SSH credentials, are denied.
+	// +optional
+	ExtraCmdlineArgs []string `json:"extraCmdlineArgs,omitempty"`
+
+	// DeletePlaybookInline replaces the inline playbook, or the roles, for
+	// the run deleting this AnsibleRun, e.g. to explicitly tear down what the
+	// contents configured rather than relying on them honouring the absent
+	// state. This field is mutually exclusive with the “deleteRoles” field.
+	// +optional
+	DeletePlaybookInline *string `json:"deletePlaybookInline,omitempty"`
+
+	// TemplateInline renders the inline playbooks and the inline inventory
+	// of this AnsibleRun as Go templates before they are written, with the
+	// name and the claim namespace of this AnsibleRun and its vars as
+	// values. The actions are delimited by [[ and ]], so as not to clash
+	// with the Jinja expressions of Ansible, and only a subset of the sprig
+	// functions is available.
+	// +optional
+	TemplateInline bool `json:"templateInline,omitempty"`
+
+	// Library runs a playbook of a library of contents shipped by a
+	// Crossplane Configuration package and mounted into the provider pod,
+	// so that the contents version with the platform packages. This field
+	// is mutually exclusive with the “playbookInline” and “roles” fields.
+	// +optional
+	Library *LibraryPlaybook `json:"library,omitempty"`
+
+	// DeleteRoles replace the inline playbook, or the roles, for the run
+	// deleting this AnsibleRun. They are installed along with the roles, from
+	// Ansible Galaxy or from git repositories, and run in order on all the
+	// hosts of the inventory.
+	// +optional
+	DeleteRoles []Role `json:"deleteRoles,omitempty"`
+
 	// The remote configuration of this AnsibleRun; the content can be retrieved from Ansible Galaxy as community contents
 	// This field is mutually exclusive with the “Playbooks” and/or "PlaybookInline" fields.
+	// The roles run in order on all the hosts of the inventory, a host
+	// failing a role not running the following ones.
 	// +optional
 	Roles []Role `json:"roles"`
 
+	// Collections are installed along with the roles, in the same
+	// requirements file, e.g. the collections the roles or the playbook
+	// depend on.
+	// +optional
+	Collections []Collection `json:"collections,omitempty"`
+
 	// Configuration variables.
 	// +kubebuilder:pruning:PreserveUnknownFields
 	// +optional
 	Vars runtime.RawExtension `json:"vars,omitempty"`
+
+	// SensitiveVars marks the Vars as containing secrets, so that they are kept
+	// out of the artifacts of the runs.
+	// +optional
+	SensitiveVars bool `json:"sensitiveVars,omitempty"`
+
+	// VarsFrom are the Secrets and ConfigMaps whose data are passed as extra
+	// vars to the runs, e.g. rotated credentials. The Vars take precedence
+	// over them, and the later sources over the earlier ones, key by key.
+	// The contents run again when their data change. The data of the
+	// Secrets are kept out of the artifacts of the runs like SensitiveVars.
+	// +optional
+	VarsFrom []VarsFromSource `json:"varsFrom,omitempty"`
+
+	// Files are written into the project directory of the runs from the
+	// keys of Secrets and ConfigMaps, e.g. the certificates, kubeconfigs or
+	// templates the playbooks reference with relative paths. They are
+	// written again on each reconcile, and removed once no longer listed.
+	// +optional
+	Files []File `json:"files,omitempty"`
+
+	// StateVar is the name of the extra var through which the provider passes
+	// the requested state of this AnsibleRun, either "present" or "absent", to
+	// the Ansible contents. Defaults to crossplane_state.
+	// +optional
+	StateVar string `json:"stateVar,omitempty"`
+
+	// LegacyProviderMeta additionally passes the requested state as
+	// ansible_provider_meta.<metadata.name>.state, for Ansible contents written
+	// against earlier releases of the provider.
+	// +optional
+	LegacyProviderMeta bool `json:"legacyProviderMeta,omitempty"`
+
+	// RunPolicy is how the contents run along the lifecycle of this
+	// AnsibleRun, ObserveAndDelete, CheckWhenObserve or AlwaysApply. It
+	// takes precedence over the ansible.crossplane.io/runPolicy annotation,
+	// which is still honored when the field is unset. Defaults to
+	// ObserveAndDelete.
+	// +kubebuilder:validation:Enum=ObserveAndDelete;CheckWhenObserve;AlwaysApply
+	// +optional
+	RunPolicy string `json:"runPolicy,omitempty"`
+
+	// AdoptExisting runs the contents in check mode on the first run of this
+	// AnsibleRun, and marks it as available without applying them when no
+	// changes are needed. This allows to import already configured systems.
+	// Only the ObserveAndDelete policy runs the contents on the first run.
+	// +optional
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
+
+	// ProfileTasks is the number of slowest tasks of the last run to report in
+	// status.atProvider.slowestTasks, to help optimizing contents that run
+	// longer than expected. Task profiling is disabled when unset.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ProfileTasks int `json:"profileTasks,omitempty"`
+
+	// TaskEvents is the verbosity of the Kubernetes Events reporting the
+	// progress of the tasks while the contents run: None, Failures for the
+	// failed tasks and unreachable hosts, Changes adding the tasks that
+	// changed a host and the retried tasks, or Tasks adding the start of
+	// each task.
+	// +kubebuilder:validation:Enum=None;Failures;Changes;Tasks
+	// +kubebuilder:default=Failures
+	// +optional
+	TaskEvents string `json:"taskEvents,omitempty"`
+
+	// RoleVersionsCheckInterval is how often the floating versions of the
+	// roles fetched from git, i.e. omitted versions and branches, are
+	// resolved against their repository. When one moved to another commit,
+	// the role is installed again and the AnsibleRun is marked as not up to
+	// date, so that the contents are run again. Roles fetched from Ansible
+	// Galaxy are not checked. Disabled when unset.
+	// +optional
+	RoleVersionsCheckInterval *metav1.Duration `json:"roleVersionsCheckInterval,omitempty"`
+
+	// PollInterval is how often this AnsibleRun is observed, i.e. checked
+	// for drift by the CheckWhenObserve policy, instead of the poll
+	// interval of the provider, set by its --poll flag. The --failed-poll
+	// flag still applies when its last run failed.
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+
+	// Timeout bounds the runs of the Ansible contents of this AnsibleRun
+	// instead of the timeout of the provider, set by its --timeout flag, e.g.
+	// to give a long provisioning playbook more time. It cannot exceed the
+	// --max-timeout flag of the provider.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Timeouts bound the runs of the Ansible contents per step of the
+	// lifecycle of this AnsibleRun, taking precedence over the timeout. They
+	// cannot exceed the --max-timeout flag of the provider.
+	// +optional
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+
+	// RetryLimit is the number of times a failed run applying the contents
+	// is retried before the provider gives up on the current spec of this
+	// AnsibleRun; changing the spec starts over. Unlimited when unset.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RetryLimit *int32 `json:"retryLimit,omitempty"`
+
+	// DeletionRetryLimit is the number of times a failed run deleting this
+	// AnsibleRun is retried before the provider gives up the teardown and
+	// lets the AnsibleRun go, recording the skipped teardown. Retried until
+	// it succeeds when unset.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DeletionRetryLimit *int32 `json:"deletionRetryLimit,omitempty"`
+
+	// Backoff delays the retries of the failed runs applying the contents,
+	// which otherwise happen on each reconcile.
+	// +optional
+	Backoff *Backoff `json:"backoff,omitempty"`
+
+	// MinIntervalBetweenRuns is the minimum time between the starts of two
+	// runs applying the contents, whatever triggered them, e.g. a change of
+	// the spec, a drift or a retry, to protect the hosts from rapid repeated
+	// plays. The runs due within the interval are deferred to its end.
+	// Deletions are not deferred. Disabled when unset.
+	// +optional
+	MinIntervalBetweenRuns *metav1.Duration `json:"minIntervalBetweenRuns,omitempty"`
+
+	// Schedule restricts the runs applying the contents to maintenance
+	// windows, outside of which the AnsibleRun is only observed and the
+	// runs are deferred to the next window. Deletions are not deferred.
+	// Unrestricted when unset.
+	// +optional
+	Schedule *Schedule `json:"schedule,omitempty"`
+
+	// ConcurrencyGroup names the group of AnsibleRuns whose runs never
+	// execute in parallel, e.g. the AnsibleRuns targeting the same hosts.
+	// The runs of an AnsibleRun wait while another AnsibleRun of its group
+	// runs, then start once it finished. The runs of the AnsibleRuns of
+	// different groups, or of none, still execute in parallel.
+	// +optional
+	ConcurrencyGroup string `json:"concurrencyGroup,omitempty"`
+
+	// Passwords are the responses to the interactive prompts of this AnsibleRun,
+	// e.g. become or vault password prompts.
+	// +optional
+	Passwords []Password `json:"passwords,omitempty"`
+
+	// SkipProviderCredentials opts this AnsibleRun out of the provider
+	// credentials of its ProviderConfig used by the playbooks, for contents
+	// that don't need them. They are not written to its working directory,
+	// and the ones written before are removed. The credentials used by
+	// ansible-galaxy and git are still used to install the requirements.
+	// +optional
+	SkipProviderCredentials bool `json:"skipProviderCredentials,omitempty"`
+
+	// ServiceAccount is impersonated by the kubernetes.core modules of the
+	// runs, so that they change the cluster with its RBAC rather than the
+	// ones of the provider. A token of the ServiceAccount is requested for
+	// each run and passed to the modules through their K8S_AUTH_*
+	// environment variables.
+	// +optional
+	ServiceAccount *ServiceAccountImpersonation `json:"serviceAccount,omitempty"`
+
+	// ConnectionDetails are the results of the runs published in the
+	// connection secret of this AnsibleRun, e.g. the endpoint or the
+	// credentials of the system the contents configured. They are read from
+	// the job events of the runs applying the contents.
+	// +optional
+	ConnectionDetails []ConnectionDetail `json:"connectionDetails,omitempty"`
+
+	// ConnectionDetailsOnDelete is what happens to the connection secret of
+	// this AnsibleRun once the contents ran for its deletion, e.g. when they
+	// revoke the credentials they published. Keep leaves the secret as is
+	// until it is garbage collected along with the AnsibleRun, Remove
+	// empties it, and Tombstone replaces each of its values by "revoked",
+	// so that its consumers stop using the revoked credentials right away.
+	// Defaults to Keep.
+	// +kubebuilder:validation:Enum=Keep;Remove;Tombstone
+	// +optional
+	ConnectionDetailsOnDelete string `json:"connectionDetailsOnDelete,omitempty"`
+}
+
+// A ConnectionDetail is a result of the runs published in the connection
+// secret. Exactly one of its sources must be set.
+type ConnectionDetail struct {
+	// Name of the key of the connection detail in the connection secret.
+	Name string `json:"name"`
+
+	// FromStat is the field path of a custom stat set by the set_stats
+	// module, e.g. endpoint for the endpoint key of its data.
+	// +optional
+	FromStat *string `json:"fromStat,omitempty"`
+
+	// FromTaskResult selects a field of the result of a task, i.e. of the
+	// var the task would register.
+	// +optional
+	FromTaskResult *TaskResultSelector `json:"fromTaskResult,omitempty"`
+}
+
+// A TaskResultSelector selects a field of the result of a task.
+type TaskResultSelector struct {
+	// Task is the name of the task.
+	Task string `json:"task"`
+
+	// Host is the host the task ran on. Defaults to the last host reporting
+	// a result for the task.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// FieldPath of the field in the result, e.g. stdout or json.token.
+	FieldPath string `json:"fieldPath"`
+}
+
+// ServiceAccountImpersonation is a ServiceAccount impersonated by the
+// kubernetes.core modules of the runs.
+type ServiceAccountImpersonation struct {
+	// Name of the ServiceAccount.
+	Name string `json:"name"`
+
+	// Namespace of the ServiceAccount.
+	Namespace string `json:"namespace"`
+
+	// ExpirationSeconds is the lifetime of the requested tokens, which must
+	// outlast the runs. Defaults to 3600.
+	// +kubebuilder:validation:Minimum=600
+	// +optional
+	ExpirationSeconds *int64 `json:"expirationSeconds,omitempty"`
+}
+
+// Password is the response to an interactive prompt, written to the
+// ansible-runner env/passwords file.
+type Password struct {
+	// Prompt is a regular expression matching the prompt to respond to,
+	// e.g. "^BECOME password.*:\\s*?$".
+	Prompt string `json:"prompt"`
+
+	// Source of the response.
+	// +kubebuilder:validation:Enum=None;Secret;InjectedIdentity;Environment;Filesystem
+	Source xpv1.CredentialsSource `json:"source"`
+
+	xpv1.CommonCredentialSelectors `json:",inline"`
 }
 
 // Inventory required to configure ansible inventory.
@@ -70,10 +641,521 @@ type Inventory struct {
 	xpv1.CommonCredentialSelectors `json:",inline"`
 }
 
+// InventoryPlugin is the configuration of an inventory plugin.
+type InventoryPlugin struct {
+	// Name of the configuration file, with the suffix the plugin requires,
+	// e.g. aws_ec2.yml or k8s.yml.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+\.ya?ml$`
+	Name string `json:"name"`
+
+	// Config is the configuration of the plugin, naming it in its plugin
+	// key, e.g. plugin: amazon.aws.aws_ec2. The collection of the plugin is
+	// installed like the other collections.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Config runtime.RawExtension `json:"config"`
+
+	// Credentials are passed to the plugin as environment variables, e.g.
+	// AWS_ACCESS_KEY_ID or K8S_AUTH_API_KEY. They are passed to the runs as
+	// well, but neither to ansible-galaxy nor to the artifacts of the runs.
+	// +optional
+	Credentials []InventoryPluginCredentials `json:"credentials,omitempty"`
+}
+
+// A KubernetesInventoryKind is a kind of Kubernetes object the hosts of a
+// KubernetesInventory are enumerated from.
+type KubernetesInventoryKind string
+
+// The kinds of Kubernetes objects of the KubernetesInventories.
+const (
+	// KubernetesInventoryNode enumerates Nodes.
+	KubernetesInventoryNode KubernetesInventoryKind = "Node"
+
+	// KubernetesInventoryPod enumerates Pods.
+	KubernetesInventoryPod KubernetesInventoryKind = "Pod"
+
+	// KubernetesInventoryMachine enumerates Cluster API Machines.
+	KubernetesInventoryMachine KubernetesInventoryKind = "Machine"
+)
+
+// KubernetesInventory enumerates the hosts of an AnsibleRun from the
+// Kubernetes objects selected by labels, each one being a host named after
+// the object.
+type KubernetesInventory struct {
+	// Kind of the objects: Node, Pod, or Machine for the Machines of
+	// Cluster API, cluster.x-k8s.io/v1beta1.
+	// +kubebuilder:validation:Enum=Node;Pod;Machine
+	Kind KubernetesInventoryKind `json:"kind"`
+
+	// Namespace of the Pods or Machines. Nodes aren't namespaced.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Selector selects the objects by labels, all of them when empty.
+	// +optional
+	Selector metav1.LabelSelector `json:"selector,omitempty"`
+
+	// AddressType is the type of the address of the Nodes or Machines set
+	// as ansible_host. The IP of the Pods is always used. The hosts without
+	// an address of this type are connected to by name.
+	// +kubebuilder:validation:Enum=InternalIP;ExternalIP;Hostname;InternalDNS;ExternalDNS
+	// +kubebuilder:default=InternalIP
+	// +optional
+	AddressType string `json:"addressType,omitempty"`
+
+	// Group the hosts are added to, the ungrouped group when empty.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	// +optional
+	Group string `json:"group,omitempty"`
+
+	// GroupByLabels add the hosts to a group per value of these labels,
+	// named <label>_<value>, or <label> when the value is empty, with the
+	// characters invalid in group names replaced by underscores, e.g.
+	// node_role_kubernetes_io_worker for node-role.kubernetes.io/worker.
+	// +optional
+	GroupByLabels []string `json:"groupByLabels,omitempty"`
+}
+
+// InventoryPluginCredentials is an environment variable of an inventory
+// plugin read from a credentials source.
+type InventoryPluginCredentials struct {
+	// Name of the environment variable.
+	Name string `json:"name"`
+
+	// Source of the credentials.
+	// +kubebuilder:validation:Enum=None;Secret;InjectedIdentity;Environment;Filesystem
+	Source xpv1.CredentialsSource `json:"source"`
+
+	xpv1.CommonCredentialSelectors `json:",inline"`
+}
+
+// Timeouts are the timeouts of the runs of the Ansible contents.
+type Timeouts struct {
+	// Check bounds the check mode runs that observe the AnsibleRun. Drift
+	// checks are expected to be short.
+	// +optional
+	Check *metav1.Duration `json:"check,omitempty"`
+
+	// Apply bounds the runs that create or update the AnsibleRun.
+	// +optional
+	Apply *metav1.Duration `json:"apply,omitempty"`
+
+	// Destroy bounds the runs that delete the AnsibleRun. Teardowns often
+	// need a longer grace.
+	// +optional
+	Destroy *metav1.Duration `json:"destroy,omitempty"`
+}
+
+// Schedule is the maintenance windows during which the contents are applied.
+type Schedule struct {
+	// Windows are the maintenance windows, the contents being applied
+	// during any of them.
+	// +kubebuilder:validation:MinItems=1
+	Windows []MaintenanceWindow `json:"windows"`
+
+	// TimeZone of the windows, an IANA time zone name, e.g. Europe/Paris.
+	// Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// MaintenanceWindow is a recurring window during which the contents are
+// applied.
+type MaintenanceWindow struct {
+	// Start is the cron expression of the times the window opens, of the 5
+	// fields minute, hour, day of month, month and day of week, e.g.
+	// "0 22 * * 1-5" for 10 PM on weekdays.
+	Start string `json:"start"`
+
+	// Duration the window stays open for.
+	Duration metav1.Duration `json:"duration"`
+}
+
+// Backoff is the delay between the retries of the failed runs.
+type Backoff struct {
+	// Duration is the delay before the first retry.
+	Duration metav1.Duration `json:"duration"`
+
+	// Factor multiplies the delay after each failed retry.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=2
+	// +optional
+	Factor int32 `json:"factor,omitempty"`
+
+	// Cap is the longest delay between two retries. Uncapped when unset.
+	// +optional
+	Cap *metav1.Duration `json:"cap,omitempty"`
+}
+
+// FailedRuns counts the consecutive failed runs applying the contents of a
+// generation of an AnsibleRun.
+type FailedRuns struct {
+	// Generation is the generation of the AnsibleRun whose runs failed.
+	Generation int64 `json:"generation"`
+
+	// Count is the number of consecutive failed runs.
+	Count int32 `json:"count"`
+
+	// LastFailureTime is the time the last of them failed, from which the
+	// next retry backs off.
+	LastFailureTime metav1.Time `json:"lastFailureTime"`
+}
+
 // AnsibleRunObservation are the observable fields of a AnsibleRun.
 type AnsibleRunObservation struct {
 	// TODO(negz): Should we include outputs here? Or only in connection
 	// details.
+
+	// Phase is the status of the last run of the Ansible contents, updated
+	// while it runs so that long runs show progress.
+	// +kubebuilder:validation:Enum=starting;running;successful;failed;timeout;canceled
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// CurrentTask is the task the running run was running at its last heartbeat.
+	// +optional
+	CurrentTask string `json:"currentTask,omitempty"`
+
+	// LastHeartbeat is the last time the running run was seen alive. A run
+	// whose heartbeat keeps being updated on the same task is slow, not hung.
+	// +optional
+	LastHeartbeat *metav1.Time `json:"lastHeartbeat,omitempty"`
+
+	// Progress estimates how far the running run got, from its job events,
+	// updated along with the heartbeat.
+	// +optional
+	Progress *RunProgress `json:"progress,omitempty"`
+
+	// Warnings are the failures of the tasks with ignore_errors enabled
+	// during the last run. They don't fail the run but are reported here so
+	// that silently failing tasks are still visible.
+	// +optional
+	Warnings []string `json:"warnings,omitempty"`
+
+	// LastRun summarizes the last run of the contents, so that what happened
+	// can be told without reading the logs of the provider.
+	// +optional
+	LastRun *RunSummary `json:"lastRun,omitempty"`
+
+	// SlowestTasks are the slowest tasks of the last run, slowest first, when
+	// task profiling is enabled.
+	// +optional
+	SlowestTasks []TaskTiming `json:"slowestTasks,omitempty"`
+
+	// Created tells whether the first run of the contents succeeded, the
+	// create contents being run until it does.
+	// +optional
+	Created bool `json:"created,omitempty"`
+
+	// Drifted tells whether the last check mode run found changes that were
+	// not applied yet, i.e. the hosts drifted from the contents and the run
+	// correcting them did not succeed yet.
+	// +optional
+	Drifted bool `json:"drifted,omitempty"`
+
+	// Drift details the changes the last check mode run found, unset when
+	// it found none or once they are applied.
+	// +optional
+	Drift *Drift `json:"drift,omitempty"`
+
+	// VarsFromHash is the digest of the data of the VarsFrom sources of the
+	// last run, telling when they change.
+	// +optional
+	VarsFromHash string `json:"varsFromHash,omitempty"`
+
+	// ExtraVarsHash is the digest of the extraVars of the ProviderConfig of
+	// the last run, telling when they change.
+	// +optional
+	ExtraVarsHash string `json:"extraVarsHash,omitempty"`
+
+	// ContentHash is the digest of the contents, requirements and vars of
+	// the last run, to tell which version of the contents configured the
+	// hosts. It is also set, truncated, as the
+	// ansible.crossplane.io/content-hash label.
+	// +optional
+	ContentHash string `json:"contentHash,omitempty"`
+
+	// RoleVersions are the commits the floating versions of the roles
+	// resolved to at their last check.
+	// +optional
+	RoleVersions []ResolvedRoleVersion `json:"roleVersions,omitempty"`
+
+	// LastRoleVersionsCheck is the last time the floating versions of the
+	// roles were resolved.
+	// +optional
+	LastRoleVersionsCheck *metav1.Time `json:"lastRoleVersionsCheck,omitempty"`
+
+	// LastApplyTime is the last time a run applying the contents started.
+	// +optional
+	LastApplyTime *metav1.Time `json:"lastApplyTime,omitempty"`
+
+	// NextRunTime is the time a run deferred by minIntervalBetweenRuns, by
+	// the backoff of the retries or by the schedule is due, unset when no
+	// run is deferred.
+	// +optional
+	NextRunTime *metav1.Time `json:"nextRunTime,omitempty"`
+
+	// FailedRuns are the consecutive failed runs applying the contents of
+	// the current spec, unset once a run succeeds.
+	// +optional
+	FailedRuns *FailedRuns `json:"failedRuns,omitempty"`
+
+	// FailedTeardowns is the number of consecutive failed runs deleting
+	// this AnsibleRun.
+	// +optional
+	FailedTeardowns int32 `json:"failedTeardowns,omitempty"`
+
+	// DiskUsage is the disk space used by the working directory of this
+	// AnsibleRun in the provider, measured at the last observation.
+	// +optional
+	DiskUsage *DiskUsage `json:"diskUsage,omitempty"`
+
+	// ProtectedReferences are the Secrets and ConfigMaps protected from
+	// deletion while this AnsibleRun references them, when its
+	// ProviderConfig protects the references.
+	// +optional
+	ProtectedReferences []ProtectedReference `json:"protectedReferences,omitempty"`
+}
+
+// A VarsFromSource selects a Secret or a ConfigMap whose data are passed as
+// extra vars, either a single key of it or all its keys.
+type VarsFromSource struct {
+	// Kind of the object, Secret or ConfigMap.
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	Kind string `json:"kind"`
+
+	// Namespace of the object.
+	Namespace string `json:"namespace"`
+
+	// Name of the object.
+	Name string `json:"name"`
+
+	// Key selects a single key of the object, passed as the extra var named
+	// Var. All the keys of the object are passed, each as the extra var of
+	// the same name, when empty.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Var is the name of the extra var of the selected Key, the Key itself
+	// when empty.
+	// +optional
+	Var string `json:"var,omitempty"`
+
+	// Prefix prefixes the names of the extra vars of all the keys of the
+	// object, when no Key is selected.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Optional lets the contents run without the object or its selected
+	// Key, passing none of their extra vars.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+}
+
+// A File is written into the project directory of the runs from a key of a
+// Secret or a ConfigMap.
+type File struct {
+	// Path of the file, relative to the project directory, e.g.
+	// files/ca.crt. It may not leave the project directory nor replace the
+	// files the provider writes there, e.g. playbook.yml.
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+
+	// Kind of the object holding the file, Secret or ConfigMap.
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	Kind string `json:"kind"`
+
+	// Namespace of the object.
+	Namespace string `json:"namespace"`
+
+	// Name of the object.
+	Name string `json:"name"`
+
+	// Key of the object holding the content of the file.
+	Key string `json:"key"`
+
+	// Mode is the permissions of the file, 0600 by default, e.g. 0700 for
+	// a script. Written in decimal in JSON, or in octal in YAML.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=511
+	// +optional
+	Mode *int32 `json:"mode,omitempty"`
+}
+
+// A ProtectedReference is a Secret or a ConfigMap protected from deletion by
+// a finalizer while an AnsibleRun references it.
+type ProtectedReference struct {
+	// Kind of the object, Secret or ConfigMap.
+	Kind string `json:"kind"`
+
+	// Namespace of the object.
+	Namespace string `json:"namespace"`
+
+	// Name of the object.
+	Name string `json:"name"`
+}
+
+// Drift details the changes a check mode run found, i.e. what the run
+// correcting them will change.
+type Drift struct {
+	// Hosts are the hosts the changes apply to, by name, the first 100 only.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Tasks are the tasks that would change a host, in the order they ran,
+	// the first 50 only.
+	// +optional
+	Tasks []DriftedTask `json:"tasks,omitempty"`
+
+	// DetectedTime is the time the check mode run found the changes.
+	DetectedTime metav1.Time `json:"detectedTime"`
+
+	// Ref refers to the details of the changes when the drift storage of
+	// the ProviderConfig stores them out of the status, in which case the
+	// hosts and tasks are unset.
+	// +optional
+	Ref *DriftReference `json:"ref,omitempty"`
+}
+
+// DriftReference refers to the details of a drift stored out of the status.
+type DriftReference struct {
+	// Backend storing the details, ConfigMap or Artifacts.
+	Backend string `json:"backend"`
+
+	// Namespace of the ConfigMap.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the ConfigMap, or path of the file within the artifacts
+	// directory of the working directory, e.g. <run id>/drift.json.
+	Name string `json:"name"`
+
+	// Hosts and Tasks count the hosts and tasks of the drift.
+	Hosts int `json:"hosts"`
+	Tasks int `json:"tasks"`
+}
+
+// DriftedTask is a task that would change some hosts.
+type DriftedTask struct {
+	// Play of the task.
+	// +optional
+	Play string `json:"play,omitempty"`
+
+	// Task is the name of the task, prefixed by its role, if any, the way
+	// Ansible displays it, e.g. nginx : install.
+	Task string `json:"task"`
+
+	// Hosts the task would change, by name.
+	Hosts []string `json:"hosts"`
+}
+
+// DiskUsage is the disk space used by a working directory.
+type DiskUsage struct {
+	// WorkingDir is the space used by the whole working directory, holding
+	// the contents, the requirements and the artifacts of the runs.
+	WorkingDir resource.Quantity `json:"workingDir"`
+
+	// Artifacts is the space used by the artifacts of the runs kept in the
+	// working directory.
+	Artifacts resource.Quantity `json:"artifacts"`
+}
+
+// RunSummary summarizes a run of the contents.
+type RunSummary struct {
+	// ID is the ident of the run, naming its artifacts directory.
+	ID string `json:"id"`
+
+	// CheckMode tells whether the run was a check mode run.
+	// +optional
+	CheckMode bool `json:"checkMode,omitempty"`
+
+	// StartTime is the time the run started.
+	StartTime metav1.Time `json:"startTime"`
+
+	// EndTime is the time the run ended.
+	EndTime metav1.Time `json:"endTime"`
+
+	// Duration is the time the run took.
+	Duration metav1.Duration `json:"duration"`
+
+	// Hosts are the task results of the run per host, from its recap. Only
+	// the first hosts by name are reported when there are too many of them.
+	// +optional
+	Hosts []HostStats `json:"hosts,omitempty"`
+
+	// Tasks is the number of tasks the run started, counting each task once
+	// whatever its hosts.
+	// +optional
+	Tasks int `json:"tasks,omitempty"`
+
+	// Status is the final status of the run: successful, failed, timeout
+	// or canceled.
+	// +optional
+	Status string `json:"status,omitempty"`
+
+	// ExitCode is the exit code of ansible-runner, unset when it is
+	// unknown, e.g. when the run was interrupted by a signal.
+	// +optional
+	ExitCode *int `json:"exitCode,omitempty"`
+
+	// RC is the return code of ansible-playbook, as recorded by
+	// ansible-runner in the artifacts of the run.
+	// +optional
+	RC *int `json:"rc,omitempty"`
+
+	// ArtifactsPath is the artifacts directory of the run in the working
+	// directory of the AnsibleRun, until it is rotated out.
+	// +optional
+	ArtifactsPath string `json:"artifactsPath,omitempty"`
+
+	// ReportURL is the URL the JUnit report of the run was uploaded to.
+	// +optional
+	ReportURL string `json:"reportURL,omitempty"`
+
+	// FailedPlaybook is the name of the playbook of the playbooks of the
+	// AnsibleRun the run failed in.
+	// +optional
+	FailedPlaybook string `json:"failedPlaybook,omitempty"`
+}
+
+// RunProgress is a rough estimate of the progress of a run, for dashboards.
+type RunProgress struct {
+	// TasksStarted is the number of tasks the run started so far, counting
+	// each task once whatever its hosts.
+	TasksStarted int `json:"tasksStarted"`
+
+	// ExpectedTasks is the number of tasks the last successful run of the
+	// contents started, unset until a run succeeds.
+	// +optional
+	ExpectedTasks int `json:"expectedTasks,omitempty"`
+
+	// Percent is the started tasks over the expected ones, kept under 100
+	// until the run succeeds. It is unset when no task is expected, and is
+	// only an estimate: conditional tasks, loops over roles and changes to
+	// the contents make the runs start a different number of tasks.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Percent *int `json:"percent,omitempty"`
+}
+
+// HostStats counts the task results of a run on a host.
+type HostStats struct {
+	Host        string `json:"host"`
+	Ok          int    `json:"ok"`
+	Changed     int    `json:"changed"`
+	Failed      int    `json:"failed"`
+	Unreachable int    `json:"unreachable"`
+	Skipped     int    `json:"skipped,omitempty"`
+	Rescued     int    `json:"rescued,omitempty"`
+	Ignored     int    `json:"ignored,omitempty"`
+}
+
+// TaskTiming is the time a task took to run on a host.
+type TaskTiming struct {
+	Play     string          `json:"play,omitempty"`
+	Task     string          `json:"task"`
+	Host     string          `json:"host,omitempty"`
+	Duration metav1.Duration `json:"duration"`
 }
 
 // A AnsibleRunSpec defines the desired state of a AnsibleRun.
@@ -93,6 +1175,7 @@ type AnsibleRunStatus struct {
 // AnsibleRun represents a set of Ansible Playbooks.
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.atProvider.phase"
 // +kubebuilder:resource:scope=Cluster
 type AnsibleRun struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -17,15 +17,52 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
 )
 
 // A Var represents key/value variable.
 type Var struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	// Key is the name of the environment variable, e.g. ANSIBLE_FORCE_COLOR.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Key string `json:"key"`
+
+	// Value of the variable, ignored when ValueFrom is set.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// ValueFrom reads the value of the variable from a key of a Secret or of
+	// a ConfigMap, e.g. for sensitive values.
+	// +optional
+	ValueFrom *VarSource `json:"valueFrom,omitempty"`
+}
+
+// A VarSource selects the key of a Secret or of a ConfigMap holding the value
+// of a Var. Exactly one of them must be set.
+type VarSource struct {
+	// SecretKeyRef selects a key of a Secret.
+	// +optional
+	SecretKeyRef *xpv1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// ConfigMapKeyRef selects a key of a ConfigMap.
+	// +optional
+	ConfigMapKeyRef *ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// A ConfigMapKeySelector is a reference to a key of a ConfigMap in an
+// arbitrary namespace.
+type ConfigMapKeySelector struct {
+	// Name of the ConfigMap.
+	Name string `json:"name"`
+
+	// Namespace of the ConfigMap.
+	Namespace string `json:"namespace"`
+
+	// Key of the ConfigMap holding the value.
+	Key string `json:"key"`
 }
 
 // A ProviderConfigSpec defines the desired state of a ProviderConfig.
@@ -34,22 +71,328 @@ type ProviderConfigSpec struct {
 	// +optional
 	Credentials []ProviderCredentials `json:"credentials"`
 
-	// Requirements manage the necessary dependencies to run ansible collection.
-	// It is expressed as inline yaml.
-	// TODO support fetching Roles
+	// Requirements are the roles and collections installed with
+	// ansible-galaxy before the runs of the AnsibleRuns using this
+	// ProviderConfig, along with their own roles and collections.
+	// +optional
+	Requirements *Requirements `json:"requirements,omitempty"`
+
+	// Galaxy configures how ansible-galaxy installs the requirements.
 	// +optional
-	Requirements *string `json:"requirements,omitempty"`
+	Galaxy *GalaxyOptions `json:"galaxy,omitempty"`
 
 	// Vars are used to customize the provider default behavior.
 	// +optional
 	Vars []Var `json:"vars,omitempty"`
+
+	// ExtraVars are the default vars of the Ansible contents of the
+	// AnsibleRuns using this ProviderConfig, passed as extra vars along with
+	// the vars of each AnsibleRun. The vars of an AnsibleRun take precedence
+	// over them, key by key at the top level.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	ExtraVars runtime.RawExtension `json:"extraVars,omitempty"`
+
+	// StdoutCallback is the Ansible stdout callback plugin formatting the
+	// output of the runs in the provider logs, e.g. yaml or debug. It takes
+	// precedence over an ANSIBLE_STDOUT_CALLBACK var. The provider reads the
+	// results of the runs from their job events, so any callback may be used.
+	// +optional
+	StdoutCallback string `json:"stdoutCallback,omitempty"`
+
+	// VaultSecrets are the passwords decrypting the contents encrypted with
+	// ansible-vault, e.g. vars or files of the playbooks and roles, and the
+	// vars of the inventories.
+	// +optional
+	VaultSecrets []VaultSecret `json:"vaultSecrets,omitempty"`
+
+	// SSHCredentials are the credentials of the SSH connections of the runs
+	// to the hosts, instead of a private key file written by Credentials.
+	// The connection vars of the inventories take precedence over them.
+	// +optional
+	SSHCredentials *SSHCredentials `json:"sshCredentials,omitempty"`
+
+	// Policy restricts the Ansible contents the AnsibleRuns using this
+	// ProviderConfig are allowed to run.
+	// +optional
+	Policy *Policy `json:"policy,omitempty"`
+
+	// Executor runs the Ansible contents of the AnsibleRuns using this
+	// ProviderConfig: Local runs ansible-runner in the provider pod, Job
+	// runs it in a Kubernetes Job per run. The --executor flag of the
+	// provider is used when empty.
+	// +kubebuilder:validation:Enum=Local;Job
+	// +optional
+	Executor string `json:"executor,omitempty"`
+
+	// Job configures the Kubernetes Jobs of the Job executor, overriding
+	// the --job-* flags of the provider.
+	// +optional
+	Job *JobExecutor `json:"job,omitempty"`
+
+	// ProtectReferences protects the Secrets and ConfigMaps referenced by
+	// the AnsibleRuns using this ProviderConfig, and by this ProviderConfig,
+	// with a finalizer per AnsibleRun, so that they are not deleted while
+	// the AnsibleRuns depend on them. The finalizers are removed once the
+	// AnsibleRuns no longer reference them or are deleted.
+	// +optional
+	ProtectReferences bool `json:"protectReferences,omitempty"`
+
+	// LockHosts keeps the runs of the AnsibleRuns using this ProviderConfig
+	// from running on the same hosts at the same time, e.g. contending for
+	// the lock of a package manager. The hosts are the ones of the
+	// inventory of each run, by name, shared with the other ProviderConfigs
+	// locking hosts. A run waits until no other run holds or waited longer
+	// for any of its hosts.
+	// +optional
+	LockHosts bool `json:"lockHosts,omitempty"`
+
+	// ClaimNamespaces are the namespaces whose AnsibleRunClaims may use
+	// this ProviderConfig, "*" for all of them. The AnsibleRunClaims may
+	// not use it when empty.
+	// +optional
+	ClaimNamespaces []string `json:"claimNamespaces,omitempty"`
+
+	// Reports are the reports of the runs of the AnsibleRuns using this
+	// ProviderConfig in standard formats, for CI and compliance systems.
+	// +optional
+	Reports *Reports `json:"reports,omitempty"`
+
+	// DriftStorage configures where the details of the drift found by the
+	// check mode runs are stored, truncated in the status of the AnsibleRuns
+	// when unset.
+	// +optional
+	DriftStorage *DriftStorage `json:"driftStorage,omitempty"`
+}
+
+// DriftStorage configures where the details of the drift are stored, for the
+// statuses of the AnsibleRuns to stay within the etcd size limits.
+type DriftStorage struct {
+	// Backend storing the details of the drift. Status keeps them in the
+	// status of the AnsibleRuns, truncated to the first 100 hosts and 50
+	// tasks. ConfigMap stores them in full in a ConfigMap named after the
+	// AnsibleRun, <name>-drift, owned by the AnsibleRun and removed once
+	// the drift is corrected. Artifacts writes them in full into the
+	// artifacts of the check mode run, drift.json, kept along with them.
+	// With the last two, the status only refers to the stored details.
+	// +kubebuilder:validation:Enum=Status;ConfigMap;Artifacts
+	// +kubebuilder:default=Status
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// Namespace of the ConfigMaps holding the details of the drift,
+	// required by the ConfigMap backend.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Reports configures the reports of the runs.
+type Reports struct {
+	// JUnit writes a JUnit XML report of each run, junit.xml in its
+	// artifacts, with a test suite per play and a test case per task and
+	// host. Failed tasks are failures, unreachable hosts are errors, and
+	// failures ignored by ignore_errors pass.
+	// +optional
+	JUnit bool `json:"junit,omitempty"`
+
+	// UploadURL is the http(s) URL each report is POSTed to, e.g. of a test
+	// results collector. The reports are only kept in the artifacts when
+	// empty. Failed uploads are reported as warnings of the runs.
+	// +optional
+	UploadURL string `json:"uploadURL,omitempty"`
+}
+
+// A JobExecutor configures the Kubernetes Jobs running the Ansible contents.
+type JobExecutor struct {
+	// Image of the Jobs. It must provide ansible-runner at the same path
+	// as the provider image, along with the Python dependencies of the
+	// contents.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount of the pods of the Jobs.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Resources are the compute resources of the pods of the Jobs.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector schedules the pods of the Jobs onto the nodes with these
+	// labels, e.g. the nodes of a network zone reaching the hosts of the
+	// AnsibleRuns, like the instance groups of AWX.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the pods of the Jobs, e.g. of the taints reserving the
+	// nodes of a network zone to the runs.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity of the pods of the Jobs.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+}
+
+// Requirements are the roles and collections of a ProviderConfig, written to
+// the requirements file of ansible-galaxy.
+type Requirements struct {
+	// Roles are installed from Ansible Galaxy or from git repositories.
+	// +optional
+	Roles []RequiredRole `json:"roles,omitempty"`
+
+	// Collections are installed from Galaxy servers, Automation Hubs, git
+	// repositories, urls or files.
+	// +optional
+	Collections []Collection `json:"collections,omitempty"`
+}
+
+// RequiredRole is a role of the requirements of a ProviderConfig.
+type RequiredRole struct {
+	// Name of the role, e.g. namespace.role for the roles of Ansible Galaxy.
+	Name string `json:"name"`
+
+	// Src is the url of the role, or its Ansible Galaxy name, defaulting to
+	// the name.
+	// +optional
+	Src string `json:"src,omitempty"`
+
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Scm is the source control of the src of the role, when it is not
+	// prefixed by it, e.g. git.
+	// +kubebuilder:validation:Enum=git;hg
+	// +optional
+	Scm string `json:"scm,omitempty"`
+
+	// Token authenticates to the https git repository of the role.
+	// +optional
+	Token *SourceToken `json:"token,omitempty"`
+}
+
+// GalaxyOptions are the options of the ansible-galaxy installs.
+type GalaxyOptions struct {
+	// Force installs the requirements again on each reconcile, even when
+	// they are already installed, e.g. to pick new commits of branches.
+	// +optional
+	Force bool `json:"force,omitempty"`
+
+	// IgnoreCerts skips the verification of the TLS certificates of the
+	// Galaxy servers, e.g. for lab Automation Hubs with self-signed
+	// certificates. Not recommended in production.
+	// +optional
+	IgnoreCerts bool `json:"ignoreCerts,omitempty"`
+
+	// NoDeps doesn't install the dependencies of the requirements, e.g. when
+	// they are preinstalled in the provider image.
+	// +optional
+	NoDeps bool `json:"noDeps,omitempty"`
+
+	// Server is the url of the Galaxy server the requirements are installed
+	// from, e.g. of a private Automation Hub, instead of the servers
+	// configured by the vars or Ansible Galaxy.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	Server string `json:"server,omitempty"`
+
+	// TokenSecretRef selects the key of a Secret holding the token
+	// authenticating to the Server. It is only passed to ansible-galaxy.
+	// +optional
+	TokenSecretRef *xpv1.SecretKeySelector `json:"tokenSecretRef,omitempty"`
+
+	// CacheCollections installs the collections of the requirements once
+	// into a cache directory shared by the AnsibleRuns using this
+	// ProviderConfig, rather than along with the requirements of each
+	// AnsibleRun. They are only installed again when they change, or on
+	// each reconcile with Force.
+	// +optional
+	CacheCollections bool `json:"cacheCollections,omitempty"`
+
+	// ReinstallOnDrift installs the collections of the cache again when
+	// they no longer match the lockfile recorded when they were installed,
+	// rather than only reporting the DependencyDrift condition of the
+	// AnsibleRuns.
+	// +optional
+	ReinstallOnDrift bool `json:"reinstallOnDrift,omitempty"`
+}
+
+// A VaultSecret is a password of ansible-vault.
+type VaultSecret struct {
+	// ID is the vault ID the password decrypts, e.g. prod for contents
+	// encrypted with --vault-id prod@prompt. The password is tried on any
+	// encrypted content when empty.
+	// +optional
+	ID string `json:"id,omitempty"`
+
+	// Source of the password.
+	// +kubebuilder:validation:Enum=None;Secret;InjectedIdentity;Environment;Filesystem
+	Source xpv1.CredentialsSource `json:"source"`
+
+	xpv1.CommonCredentialSelectors `json:",inline"`
+}
+
+// SSHCredentials are the credentials of the SSH connections to the hosts.
+type SSHCredentials struct {
+	// PrivateKeySecretRef selects the key of a Secret holding the private
+	// key, passed to the runs with --private-key.
+	PrivateKeySecretRef xpv1.SecretKeySelector `json:"privateKeySecretRef"`
+
+	// User the runs connect as, passed with --user.
+	// +optional
+	User string `json:"user,omitempty"`
+
+	// Port the runs connect to, 22 when unset.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// KnownHosts are the public keys of the hosts, in the format of the
+	// known_hosts file of ssh.
+	// +optional
+	KnownHosts string `json:"knownHosts,omitempty"`
+
+	// StrictHostKeyChecking rejects the hosts whose key is not in
+	// KnownHosts. Defaults to true, as Ansible does. Disabling it exposes
+	// the runs to man-in-the-middle attacks.
+	// +optional
+	StrictHostKeyChecking *bool `json:"strictHostKeyChecking,omitempty"`
+}
+
+// A Policy restricts the Ansible contents allowed to run, as a guardrail for
+// multi-tenant platforms.
+type Policy struct {
+	// DeniedModules are the modules the contents may not use, by short name,
+	// e.g. shell, which denies it whatever its collection, or by fully
+	// qualified name, e.g. ansible.builtin.raw. The contents are scanned
+	// before each run, and violating runs are rejected.
+	// +optional
+	DeniedModules []string `json:"deniedModules,omitempty"`
+
+	// Admission asks an Open Policy Agent endpoint to admit each run before
+	// it starts, for guardrails beyond denied modules.
+	// +optional
+	Admission *Admission `json:"admission,omitempty"`
+}
+
+// An Admission configures the Open Policy Agent decision admitting runs.
+type Admission struct {
+	// URL of the decision in the OPA Data API, e.g.
+	// http://opa.opa-system:8181/v1/data/ansible/admission. The context of
+	// the run is POSTed as the input of the decision, whose result must be
+	// either a boolean or an object with an allow boolean and the reasons
+	// of a denial. Runs are rejected when the decision is undefined.
+	URL string `json:"url"`
 }
 
 // ProviderCredentials required to authenticate.
 type ProviderCredentials struct {
 
 	// Filename to which these provider credentials
-	// should be written.
+	// should be written. When the source is Filesystem and its path is a
+	// directory or a glob, e.g. a mounted .ssh directory, this is the name
+	// of the directory to which the matched files are written.
 	Filename string `json:"filename"`
 
 	// Source of the provider credentials.
@@ -57,8 +400,29 @@ type ProviderCredentials struct {
 	Source xpv1.CredentialsSource `json:"source"`
 
 	xpv1.CommonCredentialSelectors `json:",inline"`
+
+	// Usage restricts what the provider credentials are used for, so that
+	// they are not written to disk for the runs that don't need them. They
+	// are used for everything when empty, .git-credentials being used by git
+	// too.
+	// +optional
+	Usage []CredentialUsage `json:"usage,omitempty"`
 }
 
+// A CredentialUsage is what provider credentials are used for: Playbook
+// writes them next to the playbooks for the runs, Galaxy only while
+// ansible-galaxy installs the requirements, and Git to the directory of the
+// git credentials of the requirements fetched from git repositories.
+// +kubebuilder:validation:Enum=Playbook;Galaxy;Git
+type CredentialUsage string
+
+// Usages of the provider credentials.
+const (
+	CredentialUsagePlaybook CredentialUsage = "Playbook"
+	CredentialUsageGalaxy   CredentialUsage = "Galaxy"
+	CredentialUsageGit      CredentialUsage = "Git"
+)
+
 // A ProviderConfigStatus reflects the observed state of a ProviderConfig.
 type ProviderConfigStatus struct {
 	xpv1.ProviderConfigStatus `json:",inline"`
@@ -45,6 +45,22 @@ var (
 	AnsibleRunGroupVersionKind = SchemeGroupVersion.WithKind(AnsibleRunKind)
 )
 
+// AnsibleRunSet type metadata.
+var (
+	AnsibleRunSetKind             = reflect.TypeOf(AnsibleRunSet{}).Name()
+	AnsibleRunSetGroupKind        = schema.GroupKind{Group: Group, Kind: AnsibleRunSetKind}.String()
+	AnsibleRunSetKindAPIVersion   = AnsibleRunSetKind + "." + SchemeGroupVersion.String()
+	AnsibleRunSetGroupVersionKind = SchemeGroupVersion.WithKind(AnsibleRunSetKind)
+)
+
+// AnsibleRunClaim type metadata.
+var (
+	AnsibleRunClaimKind             = reflect.TypeOf(AnsibleRunClaim{}).Name()
+	AnsibleRunClaimGroupKind        = schema.GroupKind{Group: Group, Kind: AnsibleRunClaimKind}.String()
+	AnsibleRunClaimKindAPIVersion   = AnsibleRunClaimKind + "." + SchemeGroupVersion.String()
+	AnsibleRunClaimGroupVersionKind = SchemeGroupVersion.WithKind(AnsibleRunClaimKind)
+)
+
 // ProviderConfig type metadata.
 var (
 	ProviderConfigKind             = reflect.TypeOf(ProviderConfig{}).Name()
@@ -68,6 +84,8 @@ var (
 
 func init() {
 	SchemeBuilder.Register(&AnsibleRun{}, &AnsibleRunList{})
+	SchemeBuilder.Register(&AnsibleRunSet{}, &AnsibleRunSetList{})
+	SchemeBuilder.Register(&AnsibleRunClaim{}, &AnsibleRunClaimList{})
 	SchemeBuilder.Register(&ProviderConfig{}, &ProviderConfigList{})
 	SchemeBuilder.Register(&ProviderConfigUsage{}, &ProviderConfigUsageList{})
 }
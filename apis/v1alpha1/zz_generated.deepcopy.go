@@ -21,9 +21,27 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"github.com/crossplane/crossplane-runtime/apis/common/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Admission) DeepCopyInto(out *Admission) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Admission.
+func (in *Admission) DeepCopy() *Admission {
+	if in == nil {
+		return nil
+	}
+	out := new(Admission)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AnsibleRun) DeepCopyInto(out *AnsibleRun) {
 	*out = *in
@@ -51,6 +69,108 @@ func (in *AnsibleRun) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunClaim) DeepCopyInto(out *AnsibleRunClaim) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunClaim.
+func (in *AnsibleRunClaim) DeepCopy() *AnsibleRunClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AnsibleRunClaim) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunClaimList) DeepCopyInto(out *AnsibleRunClaimList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AnsibleRunClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunClaimList.
+func (in *AnsibleRunClaimList) DeepCopy() *AnsibleRunClaimList {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunClaimList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AnsibleRunClaimList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunClaimSpec) DeepCopyInto(out *AnsibleRunClaimSpec) {
+	*out = *in
+	if in.ProviderConfigReference != nil {
+		in, out := &in.ProviderConfigReference, &out.ProviderConfigReference
+		*out = new(v1.Reference)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WriteConnectionSecretToReference != nil {
+		in, out := &in.WriteConnectionSecretToReference, &out.WriteConnectionSecretToReference
+		*out = new(v1.LocalSecretReference)
+		**out = **in
+	}
+	in.ForProvider.DeepCopyInto(&out.ForProvider)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunClaimSpec.
+func (in *AnsibleRunClaimSpec) DeepCopy() *AnsibleRunClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunClaimStatus) DeepCopyInto(out *AnsibleRunClaimStatus) {
+	*out = *in
+	in.ConditionedStatus.DeepCopyInto(&out.ConditionedStatus)
+	in.AtProvider.DeepCopyInto(&out.AtProvider)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunClaimStatus.
+func (in *AnsibleRunClaimStatus) DeepCopy() *AnsibleRunClaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunClaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AnsibleRunList) DeepCopyInto(out *AnsibleRunList) {
 	*out = *in
@@ -86,6 +206,67 @@ func (in *AnsibleRunList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AnsibleRunObservation) DeepCopyInto(out *AnsibleRunObservation) {
 	*out = *in
+	if in.LastHeartbeat != nil {
+		in, out := &in.LastHeartbeat, &out.LastHeartbeat
+		*out = (*in).DeepCopy()
+	}
+	if in.Progress != nil {
+		in, out := &in.Progress, &out.Progress
+		*out = new(RunProgress)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Warnings != nil {
+		in, out := &in.Warnings, &out.Warnings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastRun != nil {
+		in, out := &in.LastRun, &out.LastRun
+		*out = new(RunSummary)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SlowestTasks != nil {
+		in, out := &in.SlowestTasks, &out.SlowestTasks
+		*out = make([]TaskTiming, len(*in))
+		copy(*out, *in)
+	}
+	if in.Drift != nil {
+		in, out := &in.Drift, &out.Drift
+		*out = new(Drift)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RoleVersions != nil {
+		in, out := &in.RoleVersions, &out.RoleVersions
+		*out = make([]ResolvedRoleVersion, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastRoleVersionsCheck != nil {
+		in, out := &in.LastRoleVersionsCheck, &out.LastRoleVersionsCheck
+		*out = (*in).DeepCopy()
+	}
+	if in.LastApplyTime != nil {
+		in, out := &in.LastApplyTime, &out.LastApplyTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextRunTime != nil {
+		in, out := &in.NextRunTime, &out.NextRunTime
+		*out = (*in).DeepCopy()
+	}
+	if in.FailedRuns != nil {
+		in, out := &in.FailedRuns, &out.FailedRuns
+		*out = new(FailedRuns)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DiskUsage != nil {
+		in, out := &in.DiskUsage, &out.DiskUsage
+		*out = new(DiskUsage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ProtectedReferences != nil {
+		in, out := &in.ProtectedReferences, &out.ProtectedReferences
+		*out = make([]ProtectedReference, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunObservation.
@@ -113,17 +294,165 @@ func (in *AnsibleRunParameters) DeepCopyInto(out *AnsibleRunParameters) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.InventoryPlugins != nil {
+		in, out := &in.InventoryPlugins, &out.InventoryPlugins
+		*out = make([]InventoryPlugin, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KubernetesInventories != nil {
+		in, out := &in.KubernetesInventories, &out.KubernetesInventories
+		*out = make([]KubernetesInventory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.PlaybookInline != nil {
 		in, out := &in.PlaybookInline, &out.PlaybookInline
 		*out = new(string)
 		**out = **in
 	}
+	if in.CreatePlaybookInline != nil {
+		in, out := &in.CreatePlaybookInline, &out.CreatePlaybookInline
+		*out = new(string)
+		**out = **in
+	}
+	if in.Playbooks != nil {
+		in, out := &in.Playbooks, &out.Playbooks
+		*out = make([]Playbook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.CreateTags != nil {
+		in, out := &in.CreateTags, &out.CreateTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SkipTags != nil {
+		in, out := &in.SkipTags, &out.SkipTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraCmdlineArgs != nil {
+		in, out := &in.ExtraCmdlineArgs, &out.ExtraCmdlineArgs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeletePlaybookInline != nil {
+		in, out := &in.DeletePlaybookInline, &out.DeletePlaybookInline
+		*out = new(string)
+		**out = **in
+	}
+	if in.Library != nil {
+		in, out := &in.Library, &out.Library
+		*out = new(LibraryPlaybook)
+		**out = **in
+	}
+	if in.DeleteRoles != nil {
+		in, out := &in.DeleteRoles, &out.DeleteRoles
+		*out = make([]Role, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Roles != nil {
 		in, out := &in.Roles, &out.Roles
 		*out = make([]Role, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Collections != nil {
+		in, out := &in.Collections, &out.Collections
+		*out = make([]Collection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	in.Vars.DeepCopyInto(&out.Vars)
+	if in.VarsFrom != nil {
+		in, out := &in.VarsFrom, &out.VarsFrom
+		*out = make([]VarsFromSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make([]File, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RoleVersionsCheckInterval != nil {
+		in, out := &in.RoleVersionsCheckInterval, &out.RoleVersionsCheckInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.PollInterval != nil {
+		in, out := &in.PollInterval, &out.PollInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Timeouts != nil {
+		in, out := &in.Timeouts, &out.Timeouts
+		*out = new(Timeouts)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RetryLimit != nil {
+		in, out := &in.RetryLimit, &out.RetryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.DeletionRetryLimit != nil {
+		in, out := &in.DeletionRetryLimit, &out.DeletionRetryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Backoff != nil {
+		in, out := &in.Backoff, &out.Backoff
+		*out = new(Backoff)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MinIntervalBetweenRuns != nil {
+		in, out := &in.MinIntervalBetweenRuns, &out.MinIntervalBetweenRuns
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(Schedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Passwords != nil {
+		in, out := &in.Passwords, &out.Passwords
+		*out = make([]Password, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ServiceAccount != nil {
+		in, out := &in.ServiceAccount, &out.ServiceAccount
+		*out = new(ServiceAccountImpersonation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConnectionDetails != nil {
+		in, out := &in.ConnectionDetails, &out.ConnectionDetails
+		*out = make([]ConnectionDetail, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunParameters.
@@ -137,51 +466,674 @@ func (in *AnsibleRunParameters) DeepCopy() *AnsibleRunParameters {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AnsibleRunSpec) DeepCopyInto(out *AnsibleRunSpec) {
+func (in *AnsibleRunSet) DeepCopyInto(out *AnsibleRunSet) {
 	*out = *in
-	in.ResourceSpec.DeepCopyInto(&out.ResourceSpec)
-	in.ForProvider.DeepCopyInto(&out.ForProvider)
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSpec.
-func (in *AnsibleRunSpec) DeepCopy() *AnsibleRunSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSet.
+func (in *AnsibleRunSet) DeepCopy() *AnsibleRunSet {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunSet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AnsibleRunSet) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunSetItem) DeepCopyInto(out *AnsibleRunSetItem) {
+	*out = *in
+	if in.InventoryInline != nil {
+		in, out := &in.InventoryInline, &out.InventoryInline
+		*out = new(string)
+		**out = **in
+	}
+	in.Vars.DeepCopyInto(&out.Vars)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSetItem.
+func (in *AnsibleRunSetItem) DeepCopy() *AnsibleRunSetItem {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunSetItem)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunSetList) DeepCopyInto(out *AnsibleRunSetList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AnsibleRunSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSetList.
+func (in *AnsibleRunSetList) DeepCopy() *AnsibleRunSetList {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunSetList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AnsibleRunSetList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunSetSpec) DeepCopyInto(out *AnsibleRunSetSpec) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AnsibleRunSetItem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSetSpec.
+func (in *AnsibleRunSetSpec) DeepCopy() *AnsibleRunSetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunSetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunSetStatus) DeepCopyInto(out *AnsibleRunSetStatus) {
+	*out = *in
+	in.ConditionedStatus.DeepCopyInto(&out.ConditionedStatus)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSetStatus.
+func (in *AnsibleRunSetStatus) DeepCopy() *AnsibleRunSetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunSetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunSpec) DeepCopyInto(out *AnsibleRunSpec) {
+	*out = *in
+	in.ResourceSpec.DeepCopyInto(&out.ResourceSpec)
+	in.ForProvider.DeepCopyInto(&out.ForProvider)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunSpec.
+func (in *AnsibleRunSpec) DeepCopy() *AnsibleRunSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunStatus) DeepCopyInto(out *AnsibleRunStatus) {
+	*out = *in
+	in.ResourceStatus.DeepCopyInto(&out.ResourceStatus)
+	in.AtProvider.DeepCopyInto(&out.AtProvider)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunStatus.
+func (in *AnsibleRunStatus) DeepCopy() *AnsibleRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunTemplate) DeepCopyInto(out *AnsibleRunTemplate) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunTemplate.
+func (in *AnsibleRunTemplate) DeepCopy() *AnsibleRunTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnsibleRunTemplateMetadata) DeepCopyInto(out *AnsibleRunTemplateMetadata) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunTemplateMetadata.
+func (in *AnsibleRunTemplateMetadata) DeepCopy() *AnsibleRunTemplateMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(AnsibleRunTemplateMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Backoff) DeepCopyInto(out *Backoff) {
+	*out = *in
+	out.Duration = in.Duration
+	if in.Cap != nil {
+		in, out := &in.Cap, &out.Cap
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Backoff.
+func (in *Backoff) DeepCopy() *Backoff {
+	if in == nil {
+		return nil
+	}
+	out := new(Backoff)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Collection) DeepCopyInto(out *Collection) {
+	*out = *in
+	if in.Token != nil {
+		in, out := &in.Token, &out.Token
+		*out = new(SourceToken)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Collection.
+func (in *Collection) DeepCopy() *Collection {
+	if in == nil {
+		return nil
+	}
+	out := new(Collection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapKeySelector) DeepCopyInto(out *ConfigMapKeySelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapKeySelector.
+func (in *ConfigMapKeySelector) DeepCopy() *ConfigMapKeySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapKeySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionDetail) DeepCopyInto(out *ConnectionDetail) {
+	*out = *in
+	if in.FromStat != nil {
+		in, out := &in.FromStat, &out.FromStat
+		*out = new(string)
+		**out = **in
+	}
+	if in.FromTaskResult != nil {
+		in, out := &in.FromTaskResult, &out.FromTaskResult
+		*out = new(TaskResultSelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionDetail.
+func (in *ConnectionDetail) DeepCopy() *ConnectionDetail {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionDetail)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiskUsage) DeepCopyInto(out *DiskUsage) {
+	*out = *in
+	out.WorkingDir = in.WorkingDir.DeepCopy()
+	out.Artifacts = in.Artifacts.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiskUsage.
+func (in *DiskUsage) DeepCopy() *DiskUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(DiskUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Drift) DeepCopyInto(out *Drift) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tasks != nil {
+		in, out := &in.Tasks, &out.Tasks
+		*out = make([]DriftedTask, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.DetectedTime.DeepCopyInto(&out.DetectedTime)
+	if in.Ref != nil {
+		in, out := &in.Ref, &out.Ref
+		*out = new(DriftReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Drift.
+func (in *Drift) DeepCopy() *Drift {
+	if in == nil {
+		return nil
+	}
+	out := new(Drift)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftReference) DeepCopyInto(out *DriftReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftReference.
+func (in *DriftReference) DeepCopy() *DriftReference {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftStorage) DeepCopyInto(out *DriftStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftStorage.
+func (in *DriftStorage) DeepCopy() *DriftStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftedTask) DeepCopyInto(out *DriftedTask) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftedTask.
+func (in *DriftedTask) DeepCopy() *DriftedTask {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftedTask)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailedRuns) DeepCopyInto(out *FailedRuns) {
+	*out = *in
+	in.LastFailureTime.DeepCopyInto(&out.LastFailureTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailedRuns.
+func (in *FailedRuns) DeepCopy() *FailedRuns {
+	if in == nil {
+		return nil
+	}
+	out := new(FailedRuns)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *File) DeepCopyInto(out *File) {
+	*out = *in
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new File.
+func (in *File) DeepCopy() *File {
+	if in == nil {
+		return nil
+	}
+	out := new(File)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GalaxyOptions) DeepCopyInto(out *GalaxyOptions) {
+	*out = *in
+	if in.TokenSecretRef != nil {
+		in, out := &in.TokenSecretRef, &out.TokenSecretRef
+		*out = new(v1.SecretKeySelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GalaxyOptions.
+func (in *GalaxyOptions) DeepCopy() *GalaxyOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(GalaxyOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostStats) DeepCopyInto(out *HostStats) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostStats.
+func (in *HostStats) DeepCopy() *HostStats {
+	if in == nil {
+		return nil
+	}
+	out := new(HostStats)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Inventory) DeepCopyInto(out *Inventory) {
+	*out = *in
+	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Inventory.
+func (in *Inventory) DeepCopy() *Inventory {
+	if in == nil {
+		return nil
+	}
+	out := new(Inventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InventoryPlugin) DeepCopyInto(out *InventoryPlugin) {
+	*out = *in
+	in.Config.DeepCopyInto(&out.Config)
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = make([]InventoryPluginCredentials, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InventoryPlugin.
+func (in *InventoryPlugin) DeepCopy() *InventoryPlugin {
+	if in == nil {
+		return nil
+	}
+	out := new(InventoryPlugin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InventoryPluginCredentials) DeepCopyInto(out *InventoryPluginCredentials) {
+	*out = *in
+	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InventoryPluginCredentials.
+func (in *InventoryPluginCredentials) DeepCopy() *InventoryPluginCredentials {
+	if in == nil {
+		return nil
+	}
+	out := new(InventoryPluginCredentials)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JobExecutor) DeepCopyInto(out *JobExecutor) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JobExecutor.
+func (in *JobExecutor) DeepCopy() *JobExecutor {
+	if in == nil {
+		return nil
+	}
+	out := new(JobExecutor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubernetesInventory) DeepCopyInto(out *KubernetesInventory) {
+	*out = *in
+	in.Selector.DeepCopyInto(&out.Selector)
+	if in.GroupByLabels != nil {
+		in, out := &in.GroupByLabels, &out.GroupByLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubernetesInventory.
+func (in *KubernetesInventory) DeepCopy() *KubernetesInventory {
+	if in == nil {
+		return nil
+	}
+	out := new(KubernetesInventory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LibraryPlaybook) DeepCopyInto(out *LibraryPlaybook) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LibraryPlaybook.
+func (in *LibraryPlaybook) DeepCopy() *LibraryPlaybook {
+	if in == nil {
+		return nil
+	}
+	out := new(LibraryPlaybook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Password) DeepCopyInto(out *Password) {
+	*out = *in
+	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Password.
+func (in *Password) DeepCopy() *Password {
+	if in == nil {
+		return nil
+	}
+	out := new(Password)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Playbook) DeepCopyInto(out *Playbook) {
+	*out = *in
+	if in.Inline != nil {
+		in, out := &in.Inline, &out.Inline
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Playbook.
+func (in *Playbook) DeepCopy() *Playbook {
 	if in == nil {
 		return nil
 	}
-	out := new(AnsibleRunSpec)
+	out := new(Playbook)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *AnsibleRunStatus) DeepCopyInto(out *AnsibleRunStatus) {
+func (in *Policy) DeepCopyInto(out *Policy) {
 	*out = *in
-	in.ResourceStatus.DeepCopyInto(&out.ResourceStatus)
-	out.AtProvider = in.AtProvider
+	if in.DeniedModules != nil {
+		in, out := &in.DeniedModules, &out.DeniedModules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Admission != nil {
+		in, out := &in.Admission, &out.Admission
+		*out = new(Admission)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnsibleRunStatus.
-func (in *AnsibleRunStatus) DeepCopy() *AnsibleRunStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Policy.
+func (in *Policy) DeepCopy() *Policy {
 	if in == nil {
 		return nil
 	}
-	out := new(AnsibleRunStatus)
+	out := new(Policy)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Inventory) DeepCopyInto(out *Inventory) {
+func (in *ProtectedReference) DeepCopyInto(out *ProtectedReference) {
 	*out = *in
-	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Inventory.
-func (in *Inventory) DeepCopy() *Inventory {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProtectedReference.
+func (in *ProtectedReference) DeepCopy() *ProtectedReference {
 	if in == nil {
 		return nil
 	}
-	out := new(Inventory)
+	out := new(ProtectedReference)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -257,14 +1209,59 @@ func (in *ProviderConfigSpec) DeepCopyInto(out *ProviderConfigSpec) {
 	}
 	if in.Requirements != nil {
 		in, out := &in.Requirements, &out.Requirements
-		*out = new(string)
-		**out = **in
+		*out = new(Requirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Galaxy != nil {
+		in, out := &in.Galaxy, &out.Galaxy
+		*out = new(GalaxyOptions)
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Vars != nil {
 		in, out := &in.Vars, &out.Vars
 		*out = make([]Var, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.ExtraVars.DeepCopyInto(&out.ExtraVars)
+	if in.VaultSecrets != nil {
+		in, out := &in.VaultSecrets, &out.VaultSecrets
+		*out = make([]VaultSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SSHCredentials != nil {
+		in, out := &in.SSHCredentials, &out.SSHCredentials
+		*out = new(SSHCredentials)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(Policy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Job != nil {
+		in, out := &in.Job, &out.Job
+		*out = new(JobExecutor)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClaimNamespaces != nil {
+		in, out := &in.ClaimNamespaces, &out.ClaimNamespaces
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Reports != nil {
+		in, out := &in.Reports, &out.Reports
+		*out = new(Reports)
+		**out = **in
+	}
+	if in.DriftStorage != nil {
+		in, out := &in.DriftStorage, &out.DriftStorage
+		*out = new(DriftStorage)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProviderConfigSpec.
@@ -355,6 +1352,11 @@ func (in *ProviderConfigUsageList) DeepCopyObject() runtime.Object {
 func (in *ProviderCredentials) DeepCopyInto(out *ProviderCredentials) {
 	*out = *in
 	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
+	if in.Usage != nil {
+		in, out := &in.Usage, &out.Usage
+		*out = make([]CredentialUsage, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProviderCredentials.
@@ -367,9 +1369,99 @@ func (in *ProviderCredentials) DeepCopy() *ProviderCredentials {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Reports) DeepCopyInto(out *Reports) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Reports.
+func (in *Reports) DeepCopy() *Reports {
+	if in == nil {
+		return nil
+	}
+	out := new(Reports)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RequiredRole) DeepCopyInto(out *RequiredRole) {
+	*out = *in
+	if in.Token != nil {
+		in, out := &in.Token, &out.Token
+		*out = new(SourceToken)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RequiredRole.
+func (in *RequiredRole) DeepCopy() *RequiredRole {
+	if in == nil {
+		return nil
+	}
+	out := new(RequiredRole)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Requirements) DeepCopyInto(out *Requirements) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]RequiredRole, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Collections != nil {
+		in, out := &in.Collections, &out.Collections
+		*out = make([]Collection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Requirements.
+func (in *Requirements) DeepCopy() *Requirements {
+	if in == nil {
+		return nil
+	}
+	out := new(Requirements)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResolvedRoleVersion) DeepCopyInto(out *ResolvedRoleVersion) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResolvedRoleVersion.
+func (in *ResolvedRoleVersion) DeepCopy() *ResolvedRoleVersion {
+	if in == nil {
+		return nil
+	}
+	out := new(ResolvedRoleVersion)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Role) DeepCopyInto(out *Role) {
 	*out = *in
+	if in.Token != nil {
+		in, out := &in.Token, &out.Token
+		*out = new(SourceToken)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Vars.DeepCopyInto(&out.Vars)
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Role.
@@ -382,9 +1474,210 @@ func (in *Role) DeepCopy() *Role {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RunProgress) DeepCopyInto(out *RunProgress) {
+	*out = *in
+	if in.Percent != nil {
+		in, out := &in.Percent, &out.Percent
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RunProgress.
+func (in *RunProgress) DeepCopy() *RunProgress {
+	if in == nil {
+		return nil
+	}
+	out := new(RunProgress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RunSummary) DeepCopyInto(out *RunSummary) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.EndTime.DeepCopyInto(&out.EndTime)
+	out.Duration = in.Duration
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]HostStats, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExitCode != nil {
+		in, out := &in.ExitCode, &out.ExitCode
+		*out = new(int)
+		**out = **in
+	}
+	if in.RC != nil {
+		in, out := &in.RC, &out.RC
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RunSummary.
+func (in *RunSummary) DeepCopy() *RunSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(RunSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SSHCredentials) DeepCopyInto(out *SSHCredentials) {
+	*out = *in
+	out.PrivateKeySecretRef = in.PrivateKeySecretRef
+	if in.Port != nil {
+		in, out := &in.Port, &out.Port
+		*out = new(int32)
+		**out = **in
+	}
+	if in.StrictHostKeyChecking != nil {
+		in, out := &in.StrictHostKeyChecking, &out.StrictHostKeyChecking
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSHCredentials.
+func (in *SSHCredentials) DeepCopy() *SSHCredentials {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHCredentials)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Schedule) DeepCopyInto(out *Schedule) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]MaintenanceWindow, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Schedule.
+func (in *Schedule) DeepCopy() *Schedule {
+	if in == nil {
+		return nil
+	}
+	out := new(Schedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceAccountImpersonation) DeepCopyInto(out *ServiceAccountImpersonation) {
+	*out = *in
+	if in.ExpirationSeconds != nil {
+		in, out := &in.ExpirationSeconds, &out.ExpirationSeconds
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceAccountImpersonation.
+func (in *ServiceAccountImpersonation) DeepCopy() *ServiceAccountImpersonation {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceAccountImpersonation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourceToken) DeepCopyInto(out *SourceToken) {
+	*out = *in
+	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourceToken.
+func (in *SourceToken) DeepCopy() *SourceToken {
+	if in == nil {
+		return nil
+	}
+	out := new(SourceToken)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskResultSelector) DeepCopyInto(out *TaskResultSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskResultSelector.
+func (in *TaskResultSelector) DeepCopy() *TaskResultSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskResultSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskTiming) DeepCopyInto(out *TaskTiming) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskTiming.
+func (in *TaskTiming) DeepCopy() *TaskTiming {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskTiming)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Timeouts) DeepCopyInto(out *Timeouts) {
+	*out = *in
+	if in.Check != nil {
+		in, out := &in.Check, &out.Check
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Apply != nil {
+		in, out := &in.Apply, &out.Apply
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Destroy != nil {
+		in, out := &in.Destroy, &out.Destroy
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Timeouts.
+func (in *Timeouts) DeepCopy() *Timeouts {
+	if in == nil {
+		return nil
+	}
+	out := new(Timeouts)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Var) DeepCopyInto(out *Var) {
 	*out = *in
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = new(VarSource)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Var.
@@ -396,3 +1689,59 @@ func (in *Var) DeepCopy() *Var {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VarSource) DeepCopyInto(out *VarSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		**out = **in
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(ConfigMapKeySelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VarSource.
+func (in *VarSource) DeepCopy() *VarSource {
+	if in == nil {
+		return nil
+	}
+	out := new(VarSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VarsFromSource) DeepCopyInto(out *VarsFromSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VarsFromSource.
+func (in *VarsFromSource) DeepCopy() *VarsFromSource {
+	if in == nil {
+		return nil
+	}
+	out := new(VarsFromSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultSecret) DeepCopyInto(out *VaultSecret) {
+	*out = *in
+	in.CommonCredentialSelectors.DeepCopyInto(&out.CommonCredentialSelectors)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultSecret.
+func (in *VaultSecret) DeepCopy() *VaultSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultSecret)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -21,6 +21,7 @@ import (
 	"path/filepath"
 
 	"github.com/crossplane-contrib/provider-ansible/apis"
+	"github.com/crossplane-contrib/provider-ansible/internal/audit"
 	ansible "github.com/crossplane-contrib/provider-ansible/internal/controller"
 	ansiblerun "github.com/crossplane-contrib/provider-ansible/internal/controller/ansibleRun"
 	"github.com/crossplane/crossplane-runtime/pkg/controller"
@@ -28,8 +29,10 @@ import (
 	"github.com/crossplane/crossplane-runtime/pkg/logging"
 	"github.com/crossplane/crossplane-runtime/pkg/ratelimiter"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/apimachinery/pkg/api/resource"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
@@ -41,10 +44,35 @@ func main() {
 		ansibleRolesPath       = app.Flag("ansible-roles-path", "Path where role(s) exists.").String()
 		syncPeriod             = app.Flag("sync", "Controller manager sync period such as 300ms, 1.5h, or 2h45m").Short('s').Default("1h").Duration()
 		pollInterval           = app.Flag("poll", "Poll interval controls how often an individual resource should be checked for drift.").Default("1m").Duration()
+		pollJitter             = app.Flag("poll-jitter", "Shifts the poll interval of each resource by a random duration of up to this either way, so that the resources created together are not checked for drift together.").Default("0s").Duration()
+		failedPollInterval     = app.Flag("failed-poll", "Poll interval used instead of --poll for resources whose last run failed in a way that may go away by itself, e.g. an unreachable host.").Default("15s").Duration()
 		timeout                = app.Flag("timeout", "Controls how long Ansible processes may run before they are killed.").Default("20m").Duration()
+		maxTimeout             = app.Flag("max-timeout", "The longest timeout AnsibleRuns may set in spec.forProvider.timeout(s), which may exceed --timeout. Defaults to --timeout.").Duration()
+		galaxyTimeout          = app.Flag("galaxy-timeout", "Controls how long ansible-galaxy may install the requirements before it is killed.").Default("5m").Duration()
 		leaderElection         = app.Flag("leader-election", "Use leader election for the controller manager.").Short('l').Default("false").OverrideDefaultFromEnvar("LEADER_ELECTION").Bool()
+		leaseDuration          = app.Flag("leader-election-lease-duration", "How long non-leader replicas wait before trying to acquire the leader election lease. Lower values speed up failovers.").Default("15s").Duration()
+		renewDeadline          = app.Flag("leader-election-renew-deadline", "How long the leader tries to renew the leader election lease before giving up leadership.").Default("10s").Duration()
+		retryPeriod            = app.Flag("leader-election-retry-period", "How often replicas try to acquire or renew the leader election lease. Higher values reduce the API server load.").Default("2s").Duration()
 		maxReconcileRate       = app.Flag("max-reconcile-rate", "The maximum number of concurrent reconciliation operations.").Default("1").Int()
 		artifactsHistoryLimit  = app.Flag("artifacts-history-limit", "Each attempt to run the playbook/role generates a set of artifacts on disk. This settings limits how many of these to keep.").Default("10").Int()
+		auditLog               = app.Flag("audit-log", "File path or http(s) URL of a collector to which a record of every run is appended, for auditing.").String()
+		executor               = app.Flag("executor", "Runs the Ansible contents in the provider pod (Local) or in a Kubernetes Job per run (Job), unless their ProviderConfig selects one, or simulates all the runs without Ansible (Fake).").Default("Local").OverrideDefaultFromEnvar("EXECUTOR").Enum("Local", "Job", "Fake")
+		jobNamespace           = app.Flag("job-namespace", "Namespace of the Kubernetes Jobs of the Job executor.").Default("crossplane-system").OverrideDefaultFromEnvar("POD_NAMESPACE").String()
+		jobImage               = app.Flag("job-image", "Image of the Kubernetes Jobs of the Job executor, providing ansible-runner at the same path as the provider image.").String()
+		jobServiceAccount      = app.Flag("job-service-account", "ServiceAccount of the Kubernetes Jobs of the Job executor.").String()
+		jobVolumeClaim         = app.Flag("job-volume-claim", "PersistentVolumeClaim mounted at the working directory of the provider, shared with the Kubernetes Jobs of the Job executor.").String()
+		healthProbeAddress     = app.Flag("health-probe-address", "Address the liveness and readiness probes are served on, /healthz and /readyz, from the start of the provider, even while it waits for the leader election lease. Disabled when empty.").Default(":8081").String()
+		adminAddress           = app.Flag("admin-address", "Address the gRPC admin API listens on, e.g. 127.0.0.1:8082, to list and cancel the active runs, force runs and flush the caches. Loopback addresses only, unless served over TLS. Disabled when empty.").String()
+		adminTokenFile         = app.Flag("admin-token-file", "File holding the bearer token authenticating the calls to the admin API, read on each call.").String()
+		adminTLSCertFile       = app.Flag("admin-tls-cert-file", "File holding the TLS certificate the admin API is served with, read on each handshake. No TLS on loopback addresses when empty.").String()
+		adminTLSKeyFile        = app.Flag("admin-tls-key-file", "File holding the private key of the TLS certificate of the admin API.").String()
+		workDirBudget          = app.Flag("workdir-budget", "Disk space the working directories may use, e.g. 10Gi, before the oldest artifacts of the runs are pruned. Unlimited when empty.").String()
+		minFreeDisk            = app.Flag("min-free-disk", "Free space the volume of the working directories keeps, e.g. 2Gi, under which no run starts until space is freed. Unchecked when empty.").String()
+		maxMemory              = app.Flag("max-memory", "Memory the provider container may use, e.g. 3Gi, short of its inactive page cache, over which no run starts until memory is freed. Unchecked when empty.").String()
+		artifactsDir           = app.Flag("artifacts-dir", "Directory the artifacts of the runs are written under, one subdirectory per AnsibleRun, e.g. on another volume than the credentials of the working directories. Within the working directories when empty.").String()
+		librariesDir           = app.Flag("libraries-dir", "Directory the libraries of Ansible contents shipped by Configuration packages are mounted under, one subdirectory per library. The libraries are unavailable when empty.").Default("/libraries").String()
+		workDirRetention       = app.Flag("workdir-retention", "How long the working directories of the deleted AnsibleRuns are kept before they are garbage collected. Never collected when 0.").Default("1h").Duration()
+		chaos                  = app.Flag("chaos", "Injects faults into the runs, for testing Compositions and alerts in development environments only, e.g. delay=30s,failure=0.2,unreachable=0.1,timeout=0.05,runs=web-*. Disabled when empty.").Hidden().OverrideDefaultFromEnvar("CHAOS").String()
 	)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
@@ -65,6 +93,12 @@ func main() {
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		LeaderElection:   *leaderElection,
 		LeaderElectionID: "crossplane-leader-election-provider-ansible",
+		LeaseDuration:    leaseDuration,
+		RenewDeadline:    renewDeadline,
+		RetryPeriod:      retryPeriod,
+		// served before the lease is acquired, so that the replicas waiting
+		// for it are ready
+		HealthProbeBindAddress: *healthProbeAddress,
 		Cache: cache.Options{
 			SyncPeriod: syncPeriod,
 		},
@@ -72,6 +106,8 @@ func main() {
 	kingpin.FatalIfError(err, "Cannot create controller manager")
 
 	kingpin.FatalIfError(apis.AddToScheme(mgr.GetScheme()), "Cannot add Ansible APIs to scheme")
+	kingpin.FatalIfError(mgr.AddHealthzCheck("ping", healthz.Ping), "Cannot add the liveness probe")
+	kingpin.FatalIfError(mgr.AddReadyzCheck("ping", healthz.Ping), "Cannot add the readiness probe")
 
 	o := controller.Options{
 		Logger:                  log,
@@ -81,11 +117,54 @@ func main() {
 		Features:                &feature.Flags{},
 	}
 
+	var budget int64
+	if *workDirBudget != "" {
+		q, err := resource.ParseQuantity(*workDirBudget)
+		kingpin.FatalIfError(err, "Cannot parse the disk budget of the working directories")
+		budget = q.Value()
+	}
+
+	var freeDisk, memory int64
+	if *minFreeDisk != "" {
+		q, err := resource.ParseQuantity(*minFreeDisk)
+		kingpin.FatalIfError(err, "Cannot parse the minimum free disk space")
+		freeDisk = q.Value()
+	}
+	if *maxMemory != "" {
+		q, err := resource.ParseQuantity(*maxMemory)
+		kingpin.FatalIfError(err, "Cannot parse the maximum memory")
+		memory = q.Value()
+	}
+
+	auditLogger, err := audit.New(*auditLog)
+	kingpin.FatalIfError(err, "Cannot open the audit log")
+
 	ansibleOpts := ansiblerun.SetupOptions{
 		AnsibleCollectionsPath: *ansibleCollectionsPath,
 		AnsibleRolesPath:       *ansibleRolesPath,
 		Timeout:                *timeout,
+		GalaxyTimeout:          *galaxyTimeout,
+		MaxTimeout:             *maxTimeout,
 		ArtifactsHistoryLimit:  *artifactsHistoryLimit,
+		FailedPollInterval:     *failedPollInterval,
+		PollJitter:             *pollJitter,
+		AuditLogger:            auditLogger,
+		Executor:               *executor,
+		JobNamespace:           *jobNamespace,
+		JobImage:               *jobImage,
+		JobServiceAccountName:  *jobServiceAccount,
+		JobVolumeClaim:         *jobVolumeClaim,
+		WorkDirBudget:          budget,
+		WorkDirRetention:       *workDirRetention,
+		ArtifactsDir:           *artifactsDir,
+		MinFreeDisk:            freeDisk,
+		MaxMemory:              memory,
+		AdminAddress:           *adminAddress,
+		AdminTokenFile:         *adminTokenFile,
+		AdminTLSCertFile:       *adminTLSCertFile,
+		AdminTLSKeyFile:        *adminTLSKeyFile,
+		LibrariesDir:           *librariesDir,
+		Chaos:                  *chaos,
 	}
 	kingpin.FatalIfError(ansible.Setup(mgr, o, ansibleOpts), "Cannot setup Ansible controllers")
 	kingpin.FatalIfError(mgr.Start(ctrl.SetupSignalHandler()), "Cannot start controller manager")
@@ -0,0 +1,237 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	errAdmission          = "cannot get the admission decision of the run"
+	errAdmissionDenied    = "run denied by the admission policy of the ProviderConfig"
+	errAdmissionUndefined = "admission decision is undefined"
+)
+
+// using a variable for the admission client allows for stubbing in tests
+var admissionClient = &http.Client{Timeout: 10 * time.Second}
+
+// AdmissionInput is the context of a run sent to the admission policy.
+type AdmissionInput struct {
+	// Name of the AnsibleRun
+	Name string `json:"name"`
+	// State requested by the provider, either present or absent
+	State string `json:"state"`
+	// CheckMode tells whether the contents are run in check mode
+	CheckMode bool `json:"checkMode"`
+	// ContentHash is the digest of the contents, requirements and vars
+	ContentHash string `json:"contentHash"`
+	// Vars are the vars of the AnsibleRun
+	Vars map[string]interface{} `json:"vars"`
+	// Hosts are the hosts of the static inventory of the AnsibleRun
+	Hosts []string `json:"hosts"`
+}
+
+// admissionDecision is the result of an admission decision, when it is an object.
+type admissionDecision struct {
+	Allow   bool     `json:"allow"`
+	Reasons []string `json:"reasons"`
+}
+
+// SetAdmissionURL sets the URL of the Open Policy Agent decision admitting
+// the runs, none when empty.
+func (r *Runner) SetAdmissionURL(url string) {
+	r.admissionURL = url
+}
+
+// admit asks the admission policy whether the next run may start, and returns
+// a policy violation RunError when it is denied.
+func (r *Runner) admit(ctx context.Context) error {
+	if r.admissionURL == "" {
+		return nil
+	}
+	in, err := r.admissionInput()
+	if err != nil {
+		return err
+	}
+	allowed, reasons, err := queryAdmission(ctx, r.admissionURL, in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errAdmission, err)
+	}
+	if allowed {
+		return nil
+	}
+	return &RunError{
+		Kind:   FailurePolicyViolation,
+		Reason: strings.Join(reasons, "; "),
+		Err:    errors.New(errAdmissionDenied),
+	}
+}
+
+// admissionInput returns the context of the next run, whose contents are
+// expected to be hashed already.
+func (r *Runner) admissionInput() (*AdmissionInput, error) {
+	hosts, err := inventoryHosts(filepath.Join(r.workDir, runnerutil.InventoryDir, runnerutil.Hosts))
+	if err != nil {
+		return nil, err
+	}
+	vars := r.extraVars
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	return &AdmissionInput{
+		Name:        r.name,
+		State:       r.state,
+		CheckMode:   r.checkMode,
+		ContentHash: r.contentHash,
+		Vars:        vars,
+		Hosts:       hosts,
+	}, nil
+}
+
+// queryAdmission POSTs the input to the OPA Data API decision at url, and
+// returns whether it allows the run, with the reasons of a denial.
+func queryAdmission(ctx context.Context, url string, in *AdmissionInput) (bool, []string, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": in})
+	if err != nil {
+		return false, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := admissionClient.Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return false, nil, err
+	}
+	if len(out.Result) == 0 || string(out.Result) == "null" {
+		return false, []string{errAdmissionUndefined}, nil
+	}
+	var allowed bool
+	if err := json.Unmarshal(out.Result, &allowed); err == nil {
+		return allowed, nil, nil
+	}
+	var d admissionDecision
+	if err := json.Unmarshal(out.Result, &d); err != nil {
+		return false, nil, fmt.Errorf("unexpected decision %s: %w", out.Result, err)
+	}
+	return d.Allow, d.Reasons, nil
+}
+
+// inventoryHosts returns the hosts of the YAML or INI inventory at path,
+// sorted. Executable inventories are dynamic, their hosts are not known
+// before the run, so none are returned for them.
+func inventoryHosts(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&0100 != 0 {
+		return nil, nil
+	}
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := map[string]struct{}{}
+	var inv map[string]interface{}
+	if yaml.Unmarshal(b, &inv) == nil && inv != nil {
+		yamlInventoryHosts(inv, hosts)
+	} else {
+		iniInventoryHosts(b, hosts)
+	}
+
+	l := make([]string, 0, len(hosts))
+	for h := range hosts {
+		l = append(l, h)
+	}
+	sort.Strings(l)
+	return l, nil
+}
+
+// yamlInventoryHosts adds the hosts of the groups of a YAML inventory, and
+// of their children.
+func yamlInventoryHosts(groups map[string]interface{}, hosts map[string]struct{}) {
+	for _, g := range groups {
+		group, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if h, ok := group["hosts"].(map[string]interface{}); ok {
+			for name := range h {
+				hosts[name] = struct{}{}
+			}
+		}
+		if c, ok := group["children"].(map[string]interface{}); ok {
+			yamlInventoryHosts(c, hosts)
+		}
+	}
+}
+
+// iniInventoryHosts adds the hosts of an INI inventory, skipping the
+// sections of group vars and children.
+func iniInventoryHosts(b []byte, hosts map[string]struct{}) {
+	var skip bool
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			skip = strings.HasSuffix(line, ":vars]") || strings.HasSuffix(line, ":children]")
+			continue
+		}
+		if !skip {
+			hosts[strings.Fields(line)[0]] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQueryAdmission(t *testing.T) {
+	cases := map[string]struct {
+		status      int
+		response    string
+		wantAllowed bool
+		wantReasons []string
+		wantErr     bool
+	}{
+		"Allowed": {
+			status:      http.StatusOK,
+			response:    `{"result": true}`,
+			wantAllowed: true,
+		},
+		"Denied": {
+			status:   http.StatusOK,
+			response: `{"result": false}`,
+		},
+		"DeniedWithReasons": {
+			status:      http.StatusOK,
+			response:    `{"result": {"allow": false, "reasons": ["prod hosts are frozen", "unsigned contents"]}}`,
+			wantReasons: []string{"prod hosts are frozen", "unsigned contents"},
+		},
+		"AllowedObject": {
+			status:      http.StatusOK,
+			response:    `{"result": {"allow": true}}`,
+			wantAllowed: true,
+		},
+		"Undefined": {
+			status:      http.StatusOK,
+			response:    `{}`,
+			wantReasons: []string{errAdmissionUndefined},
+		},
+		"UnexpectedResult": {
+			status:   http.StatusOK,
+			response: `{"result": "yes"}`,
+			wantErr:  true,
+		},
+		"ServerError": {
+			status:   http.StatusInternalServerError,
+			response: `{"code": "internal_error"}`,
+			wantErr:  true,
+		},
+	}
+
+	in := &AdmissionInput{Name: "test", State: StatePresent, ContentHash: "sha256:abc", Vars: map[string]interface{}{"foo": "bar"}, Hosts: []string{"host1"}}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				var body struct {
+					Input *AdmissionInput `json:"input"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					t.Errorf("Decoding request: %v", err)
+				}
+				if diff := cmp.Diff(in, body.Input); diff != "" {
+					t.Errorf("Unexpected input (-want +got):\n%s", diff)
+				}
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.response))
+			}))
+			defer srv.Close()
+
+			allowed, reasons, err := queryAdmission(context.Background(), srv.URL, in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if allowed != tc.wantAllowed {
+				t.Errorf("Allowed is %t, want %t", allowed, tc.wantAllowed)
+			}
+			if diff := cmp.Diff(tc.wantReasons, reasons); diff != "" {
+				t.Errorf("Unexpected reasons (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInventoryHosts(t *testing.T) {
+	cases := map[string]struct {
+		inventory string
+		perm      os.FileMode
+		want      []string
+	}{
+		"INI": {
+			inventory: `# hosts
+localhost ansible_connection=local
+[web]
+web1 ansible_host=10.0.0.1
+web2
+[web:vars]
+http_port=80
+[all:children]
+web
+`,
+			perm: 0600,
+			want: []string{"localhost", "web1", "web2"},
+		},
+		"YAML": {
+			inventory: `all:
+  hosts:
+    localhost:
+  children:
+    web:
+      hosts:
+        web1:
+          ansible_host: 10.0.0.1
+        web2:
+      vars:
+        http_port: 80
+`,
+			perm: 0600,
+			want: []string{"localhost", "web1", "web2"},
+		},
+		"Executable": {
+			inventory: "#!/bin/sh\necho '{}'\n",
+			perm:      0700,
+			want:      nil,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), runnerutil.Hosts)
+			if err := os.WriteFile(path, []byte(tc.inventory), tc.perm); err != nil {
+				t.Fatalf("Writing inventory: %v", err)
+			}
+			got, err := inventoryHosts(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected hosts (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunAdmissionDenied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"result": {"allow": false, "reasons": ["prod hosts are frozen"]}}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	runner := new(withWorkDir(dir), withContentPaths(dir), withCmdFunc(func(ctx context.Context, _ map[string]string) *exec.Cmd {
+		t.Fatalf("Denied runs should not be run")
+		return nil
+	}))
+	runner.SetAdmissionURL(srv.URL)
+
+	_, err := runner.Run(context.Background())
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Kind != FailurePolicyViolation {
+		t.Fatalf("Unexpected error %v, want a policy violation", err)
+	}
+	want := "run denied by the admission policy of the ProviderConfig: prod hosts are frozen"
+	if err.Error() != want {
+		t.Errorf("Unexpected error %q, want %q", err.Error(), want)
+	}
+}
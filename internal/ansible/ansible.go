@@ -27,6 +27,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -39,7 +40,9 @@ import (
 	"github.com/crossplane/crossplane-runtime/pkg/resource"
 	"github.com/google/uuid"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -49,11 +52,70 @@ const (
 	AnsibleCollectionsPath = "ANSIBLE_COLLECTION_PATH"
 	// AnsibleInventoryPath is key defined by the user
 	AnsibleInventoryPath = "ANSIBLE_INVENTORY"
+	// AnsibleStdoutCallback is the key selecting the stdout callback plugin
+	AnsibleStdoutCallback = "ANSIBLE_STDOUT_CALLBACK"
+	// CollectionsPathEnv configures the collections paths of Ansible, taking
+	// precedence over AnsibleCollectionsPath
+	CollectionsPathEnv = "ANSIBLE_COLLECTIONS_PATH"
+
+	// defaultCollectionsPath are the collections paths of Ansible by default
+	defaultCollectionsPath = "~/.ansible/collections:/usr/share/ansible/collections"
 )
 
 const (
+	// maxSummaryHosts is the number of hosts reported in the summary of a run
+	maxSummaryHosts = 100
+	// maxDriftTasks is the number of tasks reported in the drift of a check
+	// mode run
+	maxDriftTasks = 50
+)
+
+const (
+	errGalaxyTimeout      = "ansible-galaxy install timed out"
 	errMarshalContentVars = "cannot marshal ContentVars into yaml document"
 	errMkdir              = "cannot make directory"
+	errNoStats            = "cannot find the stats of the run in its job events"
+	errReservedExtraVar   = "extra var is reserved for the provider"
+	errRoleVars           = "cannot decode the vars of role"
+	errRolesPlaybook      = "cannot write the playbook running the roles"
+	errTimeout            = "run timed out"
+)
+
+const (
+	// ProviderMetaVar is the extra var under which the provider exposed the
+	// state of the AnsibleRun to the Ansible contents in earlier releases
+	ProviderMetaVar = "ansible_provider_meta"
+
+	// StatePresent is the state requested when the AnsibleRun exists
+	StatePresent = "present"
+	// StateAbsent is the state requested when the AnsibleRun is deleted
+	StateAbsent = "absent"
+
+	// DefaultStateVar is the default name of the extra var holding the state
+	// requested by the provider
+	DefaultStateVar = "crossplane_state"
+
+	// ansibleRolesPathEnv configures the roles path of ansible-playbook
+	ansibleRolesPathEnv = "ANSIBLE_ROLES_PATH"
+
+	// galaxyServerListEnv lists the Galaxy servers of ansible-galaxy, each
+	// configured by the environment variables prefixed by
+	// galaxyServerEnvPrefix and its name in upper case
+	galaxyServerListEnv   = "ANSIBLE_GALAXY_SERVER_LIST"
+	galaxyServerEnvPrefix = "ANSIBLE_GALAXY_SERVER_"
+	// galaxyServerName names the Galaxy server of the ProviderConfig
+	galaxyServerName = "provider_config"
+
+	// rolesPlaybookHeader documents the generated roles playbook
+	rolesPlaybookHeader = `# Playbook running the roles of the AnsibleRun in order, generated by
+# provider-ansible.
+`
+
+	// extraVarsHeader documents the generated env/extravars file
+	extraVarsHeader = `# Extra variables of the AnsibleRun, generated by provider-ansible.
+# The vars of the AnsibleRun are top-level keys, next to the vars holding the
+# state requested by the provider.
+`
 )
 
 // using a variable for uuid generator allows for stubbing in tests
@@ -63,6 +125,10 @@ const (
 	// AnnotationKeyPolicyRun is the name of an annotation which instructs
 	// the provider how to run the corresponding Ansible contents
 	AnnotationKeyPolicyRun = "ansible.crossplane.io/runPolicy"
+
+	// LabelKeyContentHash is the name of a label holding the hash of the
+	// contents of the last run, see ContentHashLabelValue
+	LabelKeyContentHash = "ansible.crossplane.io/content-hash"
 )
 
 // Parameters are minimal needed Parameters to initializes ansible command(s)
@@ -71,6 +137,9 @@ type Parameters struct {
 	GalaxyBinary string
 	// ansible-runner binary path.
 	RunnerBinary string
+	// ansible-inventory binary path, validating the inventory before each
+	// run. The inventory is not validated when it is empty.
+	InventoryBinary string
 	// WorkingDirPath in which to execute the ansible-runner binary.
 	WorkingDirPath  string
 	CollectionsPath string
@@ -78,6 +147,31 @@ type Parameters struct {
 	RolesPath string
 	// the limit on the number of artifact directories to keep for each run
 	ArtifactsHistoryLimit int
+	// ArtifactsDir is the directory the artifacts of the runs are written
+	// into, passed to ansible-runner as --artifact-dir, e.g. on another
+	// volume than the credentials of the working directory. The artifacts
+	// directory of the working directory is used when it is empty.
+	ArtifactsDir string
+	// RunnerVersion is the version of the ansible-runner binary, which
+	// selects the flags it is invoked with. The flags of the latest versions
+	// are used when it is unknown.
+	RunnerVersion *runnerutil.Version
+	// GalaxyTimeout bounds each ansible-galaxy install, so that a hung
+	// download doesn't block the reconcile until its own timeout. There is
+	// no bound when it is zero.
+	GalaxyTimeout time.Duration
+	// Timeout bounds the runs of the AnsibleRuns setting neither a timeout
+	// nor the timeout of their step. There is no bound when it is zero.
+	Timeout time.Duration
+	// Fake simulates the runs and the installs of the requirements instead
+	// of running ansible-runner and ansible-galaxy, see ExecutorFake.
+	Fake bool
+	// Chaos injects faults into the runs, none when nil.
+	Chaos *Chaos
+
+	// inventoryPlugins are the configuration files of the inventory plugins
+	// of the AnsibleRun, set by Init.
+	inventoryPlugins []string
 }
 
 // RunPolicy represents the run policies of Ansible.
@@ -89,6 +183,7 @@ type RunPolicy struct {
 // supports the following run policies:
 // - ObserveAndDelete
 // - CheckWhenObserve
+// - AlwaysApply
 // For more details about RunPolicy : https://github.com/multicloudlab/crossplane-provider-ansible/blob/main/docs/design.md#ansible-run-policy
 func newRunPolicy(rPolicy string) (*RunPolicy, error) {
 	switch rPolicy {
@@ -96,7 +191,7 @@ func newRunPolicy(rPolicy string) (*RunPolicy, error) {
 		if rPolicy == "" {
 			rPolicy = "ObserveAndDelete"
 		}
-	case "CheckWhenObserve":
+	case "CheckWhenObserve", "AlwaysApply":
 	default:
 		return nil, fmt.Errorf("run policy %q not supported", rPolicy)
 	}
@@ -110,6 +205,15 @@ func GetPolicyRun(o metav1.Object) string {
 	return o.GetAnnotations()[AnnotationKeyPolicyRun]
 }
 
+// RunPolicyOf returns the run policy of the supplied AnsibleRun, the one of
+// its spec or, when unset, the one of its annotation.
+func RunPolicyOf(cr *v1alpha1.AnsibleRun) string {
+	if p := cr.Spec.ForProvider.RunPolicy; p != "" {
+		return p
+	}
+	return GetPolicyRun(cr)
+}
+
 // SetPolicyRun sets the ansible run policy annotation of the resource.
 func SetPolicyRun(o metav1.Object, name string) {
 	meta.AddAnnotations(o, map[string]string{AnnotationKeyPolicyRun: name})
@@ -153,6 +257,112 @@ func withAnsibleRunPolicy(p *RunPolicy) runnerOption {
 	}
 }
 
+// withExtraVars sets the user defined extra vars of the runner.
+func withExtraVars(vars map[string]interface{}) runnerOption {
+	return func(r *Runner) {
+		r.extraVars = vars
+	}
+}
+
+// withStateVar sets how the runner passes the requested state of the named
+// AnsibleRun to the Ansible contents.
+func withStateVar(name, stateVar string, legacyProviderMeta bool) runnerOption {
+	return func(r *Runner) {
+		r.name = name
+		r.stateVar = stateVar
+		r.legacyProviderMeta = legacyProviderMeta
+	}
+}
+
+// withProfileTasks sets the number of slowest tasks the runner reports after each run.
+func withProfileTasks(n int) runnerOption {
+	return func(r *Runner) {
+		r.profileTasks = n
+	}
+}
+
+// withCreate sets the command and the tags of the first run, the command
+// of the following runs being used when it is nil.
+func withCreate(cmdFunc cmdFuncType, tags []string) runnerOption {
+	return func(r *Runner) {
+		r.createCmdFunc = cmdFunc
+		r.createTags = tags
+	}
+}
+
+// withDelete sets the command of the run deleting the AnsibleRun, the command
+// of the other runs being used when it is nil.
+func withDelete(cmdFunc cmdFuncType) runnerOption {
+	return func(r *Runner) {
+		r.deleteCmdFunc = cmdFunc
+	}
+}
+
+// withContentPaths sets the files and directories of the Ansible contents,
+// which are scanned against the denied modules before each run.
+func withContentPaths(paths ...string) runnerOption {
+	return func(r *Runner) {
+		r.contentPaths = paths
+	}
+}
+
+// withRequirementsPath sets the path of the requirements file, which is
+// hashed along with the contents.
+func withRequirementsPath(path string) runnerOption {
+	return func(r *Runner) {
+		r.requirementsPaths = append(r.requirementsPaths, path)
+	}
+}
+
+// withRunnerVersion sets the version of ansible-runner, nil when unknown.
+func withRunnerVersion(v *runnerutil.Version) runnerOption {
+	return func(r *Runner) {
+		r.runnerVersion = v
+	}
+}
+
+// runnerSupports tells whether the supplied ansible-runner version supports
+// the flags introduced in major.minor, assuming it does when the version is unknown.
+func runnerSupports(v *runnerutil.Version, major, minor int) bool {
+	return v == nil || v.AtLeast(major, minor)
+}
+
+// withRunTimeout sets the timeout of all the runs, overridden per step by
+// withTimeouts.
+func withRunTimeout(timeout time.Duration) runnerOption {
+	return func(r *Runner) {
+		r.checkTimeout = timeout
+		r.applyTimeout = timeout
+		r.destroyTimeout = timeout
+	}
+}
+
+// withTimeouts sets the timeouts of the check, apply and destroy runs.
+func withTimeouts(t *v1alpha1.Timeouts) runnerOption {
+	return func(r *Runner) {
+		if t == nil {
+			return
+		}
+		if t.Check != nil {
+			r.checkTimeout = t.Check.Duration
+		}
+		if t.Apply != nil {
+			r.applyTimeout = t.Apply.Duration
+		}
+		if t.Destroy != nil {
+			r.destroyTimeout = t.Destroy.Duration
+		}
+	}
+}
+
+// withArtifactsDir sets the directory the artifacts of the runs are written
+// into, the artifacts directory of the working directory when empty.
+func withArtifactsDir(dir string) runnerOption {
+	return func(r *Runner) {
+		r.artifactsDir = dir
+	}
+}
+
 // withArtifactsHistoryLimit sets the limit on the number of artifacts
 // directories to keep; each invocation of ansible-runner produces an artifacts directory.
 func withArtifactsHistoryLimit(limit int) runnerOption {
@@ -161,19 +371,16 @@ func withArtifactsHistoryLimit(limit int) runnerOption {
 	}
 }
 
-type cmdFuncType func(behaviorVars map[string]string, checkMode bool) *exec.Cmd
+type cmdFuncType func(ctx context.Context, behaviorVars map[string]string) *exec.Cmd
 
 // playbookCmdFunc mimics https://github.com/operator-framework/operator-sdk/blob/707240f006ecfc0bc86e5c21f6874d302992d598/internal/ansible/runner/runner.go#L75-L90
-func (p Parameters) playbookCmdFunc(ctx context.Context, playbookName string, path string) cmdFuncType {
-	return func(behaviorVars map[string]string, checkMode bool) *exec.Cmd {
-		cmdArgs := []string{"run", path}
+func (p Parameters) playbookCmdFunc(playbookName string) cmdFuncType {
+	return func(ctx context.Context, behaviorVars map[string]string) *exec.Cmd {
+		// the playbook name is relative to the project directory of the private data dir
+		cmdArgs := []string{"run", p.WorkingDirPath}
 		cmdOptions := []string{
 			"-p", playbookName,
 		}
-		// enable check mode via cmdline https://github.com/ansible/ansible-runner/issues/580
-		if checkMode {
-			cmdOptions = append(cmdOptions, "--cmdline", "\\--check")
-		}
 		// gosec is disabled here because of G204. We should pay attention that user can't
 		// make command injection via command argument
 		dc := exec.CommandContext(ctx, p.RunnerBinary, append(cmdArgs, cmdOptions...)...) //nolint:gosec
@@ -182,27 +389,27 @@ func (p Parameters) playbookCmdFunc(ctx context.Context, playbookName string, pa
 
 		// Provider dc with envVar, priority is for behaviorVarsSlice over os env vars
 		dc.Env = append(dc.Env, os.Environ()...)
+		dc.Env = append(dc.Env, p.isolationEnv()...)
 		dc.Env = append(dc.Env, behaviorVarsSlice...)
 
 		// override or omit envVar that may disturb the dc execution
-		dc.Env = append(dc.Env, fmt.Sprintf("%s=%s", AnsibleInventoryPath, runnerutil.Hosts))
+		dc.Env = append(dc.Env, fmt.Sprintf("%s=%s", AnsibleInventoryPath, strings.Join(p.inventorySources(), ",")))
 
 		return dc
 	}
 }
 
 // roleCmdFunc mimics https://github.com/operator-framework/operator-sdk/blob/707240f006ecfc0bc86e5c21f6874d302992d598/internal/ansible/runner/runner.go#L92-L118
-func (p Parameters) roleCmdFunc(ctx context.Context, roleName string, path string) cmdFuncType {
-	return func(behaviorVars map[string]string, checkMode bool) *exec.Cmd {
+func (p Parameters) roleCmdFunc(roleName string, path string) cmdFuncType {
+	return func(ctx context.Context, behaviorVars map[string]string) *exec.Cmd {
 		cmdArgs := []string{"run", p.WorkingDirPath}
 		cmdOptions := []string{
 			"--role", roleName,
 			"--roles-path", path,
-			"--project-dir", p.WorkingDirPath,
 		}
-		// enable check mode via cmdline https://github.com/ansible/ansible-runner/issues/580
-		if checkMode {
-			cmdOptions = append(cmdOptions, "--cmdline", "\\--check")
+		// earlier versions always use the project directory of the private data dir
+		if runnerSupports(p.RunnerVersion, 2, 0) {
+			cmdOptions = append(cmdOptions, "--project-dir", p.projectDir())
 		}
 		// gosec is disabled here because of G204. We should pay attention that user can't
 		// make command injection via command argument
@@ -212,18 +419,166 @@ func (p Parameters) roleCmdFunc(ctx context.Context, roleName string, path strin
 
 		// Provider dc with envVar, priority is for behaviorVarsSlice over os env vars
 		dc.Env = append(dc.Env, os.Environ()...)
+		dc.Env = append(dc.Env, p.isolationEnv()...)
 		dc.Env = append(dc.Env, behaviorVarsSlice...)
 
 		// override or omit envVar that may disturb the dc execution
 		// TODO: check if ANSIBLE_INVENTORY is useless when applying role ?
-		dc.Env = append(dc.Env, fmt.Sprintf("%s=%s", AnsibleInventoryPath, filepath.Join(p.WorkingDirPath, runnerutil.Hosts)))
+		dc.Env = append(dc.Env, fmt.Sprintf("%s=%s", AnsibleInventoryPath, strings.Join(p.inventorySources(), ",")))
+		return dc
+	}
+}
+
+// rolesPlaybookCmdFunc runs the supplied playbook generated to run several
+// roles, which are looked up in the supplied roles path.
+func (p Parameters) rolesPlaybookCmdFunc(playbookName string, rolesPath string) cmdFuncType {
+	playbookCmdFunc := p.playbookCmdFunc(playbookName)
+	return func(ctx context.Context, behaviorVars map[string]string) *exec.Cmd {
+		dc := playbookCmdFunc(ctx, behaviorVars)
+		// like the --roles-path of a single role
+		dc.Env = append(dc.Env, fmt.Sprintf("%s=%s", ansibleRolesPathEnv, rolesPath))
 		return dc
 	}
 }
 
-// GalaxyInstall Install non-exists collections/roles with ansible-galaxy cli
-func (p Parameters) GalaxyInstall(ctx context.Context, behaviorVars map[string]string, requirementsType string) error {
-	requirementsFilePath := runnerutil.GetFullPath(p.WorkingDirPath, galaxyutil.RequirementsFile)
+// writeRolesPlaybook writes the supplied playbook running the supplied roles
+// in order on all the hosts, along with their vars, in the project directory.
+func (p Parameters) writeRolesPlaybook(playbookName string, roles []v1alpha1.Role, stateVar string) error {
+	if stateVar == "" {
+		stateVar = DefaultStateVar
+	}
+	entries := make([]map[string]any, 0, len(roles))
+	for _, role := range roles {
+		vars, err := unmarshalContentVars(role.Vars, ProviderMetaVar, stateVar)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", errRoleVars, role.Name, err)
+		}
+		entry := map[string]any{"role": role.Name}
+		if len(vars) != 0 {
+			entry["vars"] = vars
+		}
+		if len(role.Tags) != 0 {
+			entry["tags"] = role.Tags
+		}
+		if role.Become {
+			entry["become"] = true
+		}
+		entries = append(entries, entry)
+	}
+	play := []map[string]any{{
+		"name":  "Run the roles of the AnsibleRun",
+		"hosts": "all",
+		"roles": entries,
+	}}
+	out, err := yaml.Marshal(play)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errRolesPlaybook, err)
+	}
+	if err := os.MkdirAll(p.projectDir(), 0700); resource.Ignore(os.IsExist, err) != nil {
+		return fmt.Errorf("%s: %s: %w", p.projectDir(), errMkdir, err)
+	}
+	if err := addFile(filepath.Join(p.projectDir(), playbookName), append([]byte(rolesPlaybookHeader), out...)); err != nil {
+		return fmt.Errorf("%s: %w", errRolesPlaybook, err)
+	}
+	return nil
+}
+
+// hasRoleVars tells whether the supplied role has vars of its own.
+func hasRoleVars(role v1alpha1.Role) bool {
+	return len(role.Vars.Raw) != 0 && string(role.Vars.Raw) != "null"
+}
+
+// hasRoleOptions tells whether the supplied role has vars, tags or privilege
+// escalation of its own, which ansible-runner cannot run a role with, so that
+// it runs from the generated roles playbook.
+func hasRoleOptions(role v1alpha1.Role) bool {
+	return hasRoleVars(role) || len(role.Tags) != 0 || role.Become
+}
+
+// projectDir returns the ansible-runner project directory, holding playbooks,
+// roles and the files they reference.
+func (p Parameters) projectDir() string {
+	return filepath.Join(p.WorkingDirPath, runnerutil.ProjectDir)
+}
+
+// inventoryPath returns the path of the inventory file.
+func (p Parameters) inventoryPath() string {
+	return filepath.Join(p.WorkingDirPath, runnerutil.InventoryDir, runnerutil.Hosts)
+}
+
+// inventorySources returns the inventory sources of the runs: the inventory
+// file, unless only inventory plugins enumerate the hosts, followed by the
+// configuration files of the inventory plugins.
+func (p Parameters) inventorySources() []string {
+	var sources []string
+	if _, err := os.Stat(p.inventoryPath()); err == nil || len(p.inventoryPlugins) == 0 {
+		sources = append(sources, p.inventoryPath())
+	}
+	for _, name := range p.inventoryPlugins {
+		sources = append(sources, filepath.Join(p.WorkingDirPath, runnerutil.InventoryDir, name))
+	}
+	return sources
+}
+
+// GalaxyOptions are the options of an ansible-galaxy install.
+type GalaxyOptions struct {
+	// Force installs all the requirements again
+	Force bool
+	// IgnoreCerts skips the verification of the TLS certificates of the servers
+	IgnoreCerts bool
+	// NoDeps doesn't install the dependencies of the requirements
+	NoDeps bool
+	// RequirementsDir holds the requirements file, the project directory
+	// when empty
+	RequirementsDir string
+	// CollectionsPath is the directory the collections are installed into,
+	// the first of the collections paths when empty
+	CollectionsPath string
+	// Server is the url of the Galaxy server the requirements are installed
+	// from, the configured servers when empty
+	Server string
+	// ServerToken authenticates to the Server, it is left out of the
+	// checksums of the installs
+	ServerToken string `json:"-"`
+}
+
+// serverEnv returns the environment variables configuring the Server as a
+// Galaxy server named galaxyServerName, after the servers configured by the
+// supplied behavior vars or by the provider environment, e.g. for the
+// collections whose source is one of them.
+func (o GalaxyOptions) serverEnv(behaviorVars map[string]string) []string {
+	if o.Server == "" {
+		return nil
+	}
+	list := galaxyServerName
+	if l := behaviorVars[galaxyServerListEnv]; l != "" {
+		list = l + "," + list
+	} else if l := os.Getenv(galaxyServerListEnv); l != "" {
+		list = l + "," + list
+	}
+	prefix := galaxyServerEnvPrefix + strings.ToUpper(galaxyServerName) + "_"
+	env := []string{galaxyServerListEnv + "=" + list, prefix + "URL=" + o.Server}
+	if o.ServerToken != "" {
+		env = append(env, prefix+"TOKEN="+o.ServerToken)
+	}
+	return env
+}
+
+// GalaxyInstall Install non-exists collections/roles with ansible-galaxy cli,
+// or all of them again when opts.Force is set
+func (p Parameters) GalaxyInstall(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts GalaxyOptions) error {
+	if p.Fake {
+		log.FromContext(ctx).V(1).Info("Simulating the install of the requirements with the Fake executor", "type", requirementsType)
+		return nil
+	}
+	ctx, cancel := withTimeout(ctx, p.GalaxyTimeout)
+	defer cancel()
+
+	requirementsDir := p.projectDir()
+	if opts.RequirementsDir != "" {
+		requirementsDir = opts.RequirementsDir
+	}
+	requirementsFilePath := runnerutil.GetFullPath(requirementsDir, galaxyutil.RequirementsFile)
 	var cmdArgs, cmdOptions []string
 	switch requirementsType {
 	case "collection":
@@ -231,6 +586,9 @@ func (p Parameters) GalaxyInstall(ctx context.Context, behaviorVars map[string]s
 		cmdOptions = []string{
 			"--requirements-file", requirementsFilePath,
 		}
+		if opts.CollectionsPath != "" {
+			cmdOptions = append(cmdOptions, "--collections-path", opts.CollectionsPath)
+		}
 	case "role":
 		cmdArgs = []string{"role", "install"}
 		cmdOptions = []string{
@@ -243,6 +601,18 @@ func (p Parameters) GalaxyInstall(ctx context.Context, behaviorVars map[string]s
 		cmdOptions = append(cmdOptions, []string{"--roles-path", rolePath}...)
 
 	}
+	if opts.Server != "" {
+		cmdOptions = append(cmdOptions, "--server", galaxyServerName)
+	}
+	if opts.Force {
+		cmdOptions = append(cmdOptions, "--force")
+	}
+	if opts.IgnoreCerts {
+		cmdOptions = append(cmdOptions, "--ignore-certs")
+	}
+	if opts.NoDeps {
+		cmdOptions = append(cmdOptions, "--no-deps")
+	}
 	// ansible-galaxy is by default verbose
 	cmdOptions = append(cmdOptions, "--verbose")
 
@@ -255,10 +625,25 @@ func (p Parameters) GalaxyInstall(ctx context.Context, behaviorVars map[string]s
 	// Provider dc with envVar, priority is for behaviorVarsSlice over os env vars
 	dc.Env = append(dc.Env, os.Environ()...)
 	dc.Env = append(dc.Env, behaviorVarsSlice...)
+	dc.Env = append(dc.Env, opts.serverEnv(behaviorVars)...)
+
+	// the output is streamed to the provider logs as it comes, and kept to
+	// report why the install failed
+	var out bytes.Buffer
+	dc.Stdout = io.MultiWriter(os.Stdout, &out)
+	dc.Stderr = io.MultiWriter(os.Stderr, &out)
+	// let ansible-galaxy shut down gracefully when the install times out or
+	// the reconcile ends, and kill it if it doesn't respond within 10s
+	dc.Cancel = func() error {
+		return dc.Process.Signal(os.Interrupt)
+	}
+	dc.WaitDelay = 10 * time.Second
 
-	out, err := dc.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to install galaxy collections/roles: %s: %w", out, err)
+	if err := dc.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%s: %w", errGalaxyTimeout, err)
+		}
+		return fmt.Errorf("failed to install galaxy collections/roles: %s: %w", out.String(), err)
 	}
 	return nil
 }
@@ -267,78 +652,292 @@ func (p Parameters) GalaxyInstall(ctx context.Context, behaviorVars map[string]s
 // nolint: gocyclo
 func (p Parameters) Init(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*Runner, error) {
 	var cmdFunc cmdFuncType
+	for _, plugin := range cr.Spec.ForProvider.InventoryPlugins {
+		p.inventoryPlugins = append(p.inventoryPlugins, plugin.Name)
+	}
 	/*
-		    path can be either the working Directory or an other folder:
-				- for inline mode, path is always the working directory
+		    path can be either the project directory or an other folder:
+				- for inline mode, path is always the project directory
 				- for remote mode, path can be different from working directory
 			working directory  should contains all ansible content that is 100% controllable (playbooks, roles, inventories)
 	*/
 	var path, ansibleEnvDir string
+	var contentPaths []string
+	var createCmdFunc, deleteCmdFunc cmdFuncType
 
+	library := cr.Spec.ForProvider.Library
 	switch {
-	case cr.Spec.ForProvider.PlaybookInline == nil && len(cr.Spec.ForProvider.Roles) == 0:
-		return nil, errors.New("at least a Playbook or Role should be provided")
+	case cr.Spec.ForProvider.PlaybookInline == nil && len(cr.Spec.ForProvider.Playbooks) == 0 && len(cr.Spec.ForProvider.Roles) == 0 && library == nil:
+		return nil, errors.New("at least a Playbook, Role or Library should be provided")
+	case len(cr.Spec.ForProvider.Playbooks) != 0 && (cr.Spec.ForProvider.PlaybookInline != nil || len(cr.Spec.ForProvider.Roles) != 0 || library != nil):
+		return nil, errors.New("cannot execute Playbooks along with an inline Playbook, Role(s) or a Library Playbook, please respect Mutual Exclusion")
 	case cr.Spec.ForProvider.PlaybookInline != nil && len(cr.Spec.ForProvider.Roles) != 0:
 		return nil, errors.New("cannot execute Playbook(s) and Role(s) at the same time, please respect Mutual Exclusion")
+	case library != nil && (cr.Spec.ForProvider.PlaybookInline != nil || len(cr.Spec.ForProvider.Roles) != 0):
+		return nil, errors.New("cannot execute a Library Playbook along with Playbook(s) or Role(s), please respect Mutual Exclusion")
+	case cr.Spec.ForProvider.CreatePlaybookInline != nil && cr.Spec.ForProvider.PlaybookInline == nil:
+		return nil, errors.New("a create Playbook requires a Playbook for the following runs")
+	case cr.Spec.ForProvider.DeletePlaybookInline != nil && len(cr.Spec.ForProvider.DeleteRoles) != 0:
+		return nil, errors.New("cannot execute delete Playbook(s) and Role(s) at the same time, please respect Mutual Exclusion")
 	case cr.Spec.ForProvider.PlaybookInline != nil:
 		// For inline mode playbook is stored in the predefined playbookYml file
-		path = p.WorkingDirPath
-		cmdFunc = p.playbookCmdFunc(ctx, runnerutil.PlaybookYml, path)
+		path = p.projectDir()
+		cmdFunc = p.playbookCmdFunc(runnerutil.PlaybookYml)
+		// the project directory holds the credentials too
+		contentPaths = []string{filepath.Join(path, runnerutil.PlaybookYml)}
+		if cr.Spec.ForProvider.CreatePlaybookInline != nil {
+			createCmdFunc = p.playbookCmdFunc(runnerutil.CreatePlaybookYml)
+			contentPaths = append(contentPaths, filepath.Join(path, runnerutil.CreatePlaybookYml))
+		}
+	case len(cr.Spec.ForProvider.Playbooks) != 0:
+		// ansible-runner runs a single playbook, the playbooks are imported
+		// in order by a playbook generated next to them
+		path = p.projectDir()
+		paths, err := p.writePlaybooksPlaybook(cr.Spec.ForProvider.Playbooks)
+		if err != nil {
+			return nil, err
+		}
+		cmdFunc = p.playbookCmdFunc(runnerutil.PlaybooksYml)
+		contentPaths = []string{filepath.Join(path, runnerutil.PlaybooksYml)}
+		for _, pbPath := range paths {
+			contentPaths = append(contentPaths, filepath.Join(path, pbPath))
+		}
+	case library != nil:
+		// the library is copied into the project directory, its playbook
+		// finding its roles next to it
+		path = p.projectDir()
+		libraryDir := filepath.Join(runnerutil.LibrariesDir, library.Name)
+		cmdFunc = p.playbookCmdFunc(filepath.Join(libraryDir, library.Playbook))
+		contentPaths = []string{filepath.Join(path, libraryDir)}
 	case len(cr.Spec.ForProvider.Roles) != 0:
 		var err error
 		path, err = selectRolePath(p, behaviorVars)
 		if err != nil {
 			return nil, err
 		}
-		// TODO support multiple roles execution
-		cmdFunc = p.roleCmdFunc(ctx, cr.Spec.ForProvider.Roles[0].Name, path)
+		roles := cr.Spec.ForProvider.Roles
+		if len(roles) == 1 && !hasRoleOptions(roles[0]) {
+			cmdFunc = p.roleCmdFunc(roles[0].Name, path)
+		} else {
+			// ansible-runner runs a single role, the others are run by a
+			// playbook including them in order
+			if err := p.writeRolesPlaybook(runnerutil.RolesPlaybookYml, roles, cr.Spec.ForProvider.StateVar); err != nil {
+				return nil, err
+			}
+			cmdFunc = p.rolesPlaybookCmdFunc(runnerutil.RolesPlaybookYml, path)
+			contentPaths = append(contentPaths, filepath.Join(p.projectDir(), runnerutil.RolesPlaybookYml))
+		}
+		for _, role := range roles {
+			contentPaths = append(contentPaths, filepath.Join(path, role.Name))
+		}
 	}
 
-	// init ansible env dir
-	ansibleEnvDir = filepath.Clean(filepath.Join(p.WorkingDirPath, "env"))
+	// the run deleting the AnsibleRun may run dedicated contents instead
+	switch {
+	case cr.Spec.ForProvider.DeletePlaybookInline != nil:
+		deleteCmdFunc = p.playbookCmdFunc(runnerutil.DeletePlaybookYml)
+		contentPaths = append(contentPaths, filepath.Join(p.projectDir(), runnerutil.DeletePlaybookYml))
+	case len(cr.Spec.ForProvider.DeleteRoles) != 0:
+		rolesPath, err := selectRolePath(p, behaviorVars)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.writeRolesPlaybook(runnerutil.DeleteRolesPlaybookYml, cr.Spec.ForProvider.DeleteRoles, cr.Spec.ForProvider.StateVar); err != nil {
+			return nil, err
+		}
+		deleteCmdFunc = p.rolesPlaybookCmdFunc(runnerutil.DeleteRolesPlaybookYml, rolesPath)
+		contentPaths = append(contentPaths, filepath.Join(p.projectDir(), runnerutil.DeleteRolesPlaybookYml))
+		for _, role := range cr.Spec.ForProvider.DeleteRoles {
+			contentPaths = append(contentPaths, filepath.Join(rolesPath, role.Name))
+		}
+	}
 
-	// prepare ansible runner extravars
-	// create extravars file even empty. We need the extravars file later to handle status variables
+	ansibleEnvDir = filepath.Clean(filepath.Join(p.WorkingDirPath, runnerutil.EnvDir))
+
+	// init ansible env dir, holding the extravars and settings of the runner
 	if err := os.MkdirAll(ansibleEnvDir, 0700); resource.Ignore(os.IsExist, err) != nil {
 		return nil, fmt.Errorf("%s: %s: %w", ansibleEnvDir, errMkdir, err)
 	}
-	contentVarsBytes, err := cr.Spec.ForProvider.Vars.MarshalJSON()
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", errMarshalContentVars, err)
+	stateVar := cr.Spec.ForProvider.StateVar
+	if stateVar == "" {
+		stateVar = DefaultStateVar
 	}
-	if string(contentVarsBytes) == "null" {
-		contentVarsBytes = nil
+	extraVars, err := unmarshalContentVars(cr.Spec.ForProvider.Vars, ProviderMetaVar, stateVar)
+	if err != nil {
+		return nil, err
 	}
-	if err := addFile(filepath.Join(ansibleEnvDir, "extravars"), contentVarsBytes); err != nil {
+
+	rPolicy, err := newRunPolicy(RunPolicyOf(cr))
+	if err != nil {
 		return nil, err
 	}
 
-	rPolicy, err := newRunPolicy(GetPolicyRun(cr))
+	cmdline, err := cmdlineOptionsOf(cr)
 	if err != nil {
 		return nil, err
 	}
 
+	runTimeout := p.Timeout
+	if cr.Spec.ForProvider.Timeout != nil {
+		runTimeout = cr.Spec.ForProvider.Timeout.Duration
+	}
+
 	r := new(withPath(path),
 		withCmdFunc(cmdFunc),
+		withCreate(createCmdFunc, cr.Spec.ForProvider.CreateTags),
+		withCmdline(cmdline),
+		withDelete(deleteCmdFunc),
 		withBehaviorVars(behaviorVars),
 		withAnsibleRunPolicy(rPolicy),
 		// TODO should be moved to connect() func
 		withWorkDir(p.WorkingDirPath),
 		withArtifactsHistoryLimit(p.ArtifactsHistoryLimit),
+		withArtifactsDir(p.ArtifactsDir),
+		withExtraVars(extraVars),
+		withStateVar(cr.GetName(), stateVar, cr.Spec.ForProvider.LegacyProviderMeta),
+		withResource(cr),
+		withProfileTasks(cr.Spec.ForProvider.ProfileTasks),
+		withTaskEvents(cr.Spec.ForProvider.TaskEvents),
+		withConnectionDetails(cr.Spec.ForProvider.ConnectionDetails),
+		withRunTimeout(runTimeout),
+		withTimeouts(cr.Spec.ForProvider.Timeouts),
+		withRunnerVersion(p.RunnerVersion),
+		withInventoryCheck(p.InventoryBinary, p.inventorySources()...),
+		withFake(p.Fake),
+		withChaos(p.Chaos),
+		withContentPaths(contentPaths...),
+		withRequirementsPath(runnerutil.GetFullPath(p.projectDir(), galaxyutil.RequirementsFile)),
 	)
 
+	// create extravars file even without vars. We need the extravars file later to handle status variables
+	if err := r.writeExtraVars(); err != nil {
+		return nil, err
+	}
+	if err := r.writeSettings(cr.Spec.ForProvider.SensitiveVars); err != nil {
+		return nil, err
+	}
+
 	return r, nil
 }
 
+// unmarshalContentVars decodes the vars of an AnsibleRun, keeping numbers
+// as written so that they are not turned into floats in env/extravars.
+func unmarshalContentVars(vars runtime.RawExtension, reservedVars ...string) (map[string]interface{}, error) {
+	contentVarsBytes, err := vars.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errMarshalContentVars, err)
+	}
+	extraVars := make(map[string]interface{})
+	if string(contentVarsBytes) == "null" {
+		return extraVars, nil
+	}
+	d := json.NewDecoder(bytes.NewReader(contentVarsBytes))
+	d.UseNumber()
+	if err := d.Decode(&extraVars); err != nil {
+		return nil, fmt.Errorf("%s: %w", errMarshalContentVars, err)
+	}
+	for _, reserved := range reservedVars {
+		if _, ok := extraVars[reserved]; ok {
+			return nil, fmt.Errorf("%s: %q", errReservedExtraVar, reserved)
+		}
+	}
+	return extraVars, nil
+}
+
+// Statuses of a run, named after the ones reported to the status handler of ansible-runner
+const (
+	StatusStarting   = "starting"
+	StatusRunning    = "running"
+	StatusSuccessful = "successful"
+	StatusFailed     = "failed"
+	StatusTimeout    = "timeout"
+	StatusCanceled   = "canceled"
+)
+
+// StatusHandler is notified of the status changes of a run.
+type StatusHandler func(ctx context.Context, status string)
+
+// Progress is how far a running run got, from its job events.
+type Progress struct {
+	// CurrentTask is the task being run, if any
+	CurrentTask string
+	// TasksStarted is the number of tasks started so far
+	TasksStarted int
+}
+
+// ProgressHandler is notified periodically while a run is running, with its
+// progress.
+type ProgressHandler func(ctx context.Context, p Progress)
+
 // Runner struct holds the configuration to run the cmdFunc
 type Runner struct {
 	Path                  string // absolute path on disk to a playbook or role depending on what cmdFunc expects
 	behaviorVars          map[string]string
 	cmdFunc               cmdFuncType // returns a Cmd that runs ansible-runner
 	workDir               string
+	fake                  bool
 	checkMode             bool
 	AnsibleRunPolicy      *RunPolicy
 	artifactsHistoryLimit int
+	artifactsDir          string
+	sharedDirs            []string
+	extraVars             map[string]interface{}
+	defaultVars           map[string]interface{}
+	fromVars              map[string]interface{}
+	providerVars          map[string]interface{}
+	providerMeta          map[string]interface{}
+	name                  string
+	stateVar              string
+	legacyProviderMeta    bool
+	warnings              []string
+	profileTasks          int
+	slowestTasks          []v1alpha1.TaskTiming
+	lastEvents            []jobEvent
+	lastRun               *v1alpha1.RunSummary
+	inventoryBinary       string
+	inventoryPaths        []string
+	connectionDetails     []v1alpha1.ConnectionDetail
+	statusHandler         StatusHandler
+	progressHandler       ProgressHandler
+	heartbeatInterval     time.Duration
+	taskEventHandler      TaskEventHandler
+	taskEvents            string
+	checkTimeout          time.Duration
+	applyTimeout          time.Duration
+	destroyTimeout        time.Duration
+	runnerVersion         *runnerutil.Version
+	contentPaths          []string
+	deniedModules         []string
+	admissionURL          string
+	reportJUnit           bool
+	reportUploadURL       string
+	state                 string
+	requirementsPaths     []string
+	contentHash           string
+	lastContentHash       string
+	createCmdFunc         cmdFuncType
+	createTags            []string
+	cmdlineOpts           cmdlineOptions
+	creating              bool
+	deleteCmdFunc         cmdFuncType
+	deleting              bool
+	uid                   string
+	generation            int64
+	specHash              string
+	trigger               string
+	jobExecutor           *JobExecutor
+	chaos                 *Chaos
+	vaultIDs              []VaultID
+	ssh                   *SSHOptions
+}
+
+// artifactsPath returns the artifacts directory of the run with the supplied
+// ident.
+func (r *Runner) artifactsPath(id string) string {
+	if r.artifactsDir != "" {
+		return filepath.Join(r.artifactsDir, id)
+	}
+	return filepath.Join(r.workDir, "artifacts", id)
 }
 
 // new returns a runner that will be used as ansible-runner client
@@ -353,41 +952,86 @@ func new(o ...runnerOption) *Runner {
 	return r
 }
 
+// SetStatusHandler sets the handler notified of the status changes of the runs.
+func (r *Runner) SetStatusHandler(h StatusHandler) {
+	r.statusHandler = h
+}
+
+// SetProgressHandler sets the handler notified every interval while a run is running.
+func (r *Runner) SetProgressHandler(h ProgressHandler, interval time.Duration) {
+	r.progressHandler = h
+	r.heartbeatInterval = interval
+}
+
+func (r *Runner) reportStatus(ctx context.Context, status string) {
+	if r.statusHandler != nil {
+		r.statusHandler(ctx, status)
+	}
+}
+
 // GetAnsibleRunPolicy to retrieve Ansible RunPolicy
 func (r *Runner) GetAnsibleRunPolicy() *RunPolicy {
 	return r.AnsibleRunPolicy
 }
 
 func (r *Runner) ansibleEnvDir() string {
-	return filepath.Clean(filepath.Join(r.workDir, "env"))
+	return filepath.Clean(filepath.Join(r.workDir, runnerutil.EnvDir))
 }
 
-// Run execute the appropriate cmdFunc
+// Run execute the appropriate cmdFunc. The output of the run is written to
+// os.Stdout in the format of the configured stdout callback, and the stdout of
+// check runs is returned as well. The provider reads the results of the run
+// from its job events only, whatever the stdout callback.
 func (r *Runner) Run(ctx context.Context) (io.Reader, error) {
-	var (
-		stdoutBuf                  bytes.Buffer
-		stdoutWriter, stderrWriter io.Writer
-	)
+	var stdoutBuf bytes.Buffer
 
-	dc := r.cmdFunc(r.behaviorVars, r.checkMode)
-	dc.Args = append(dc.Args, "--rotate-artifacts", strconv.Itoa(r.artifactsHistoryLimit))
+	r.lastContentHash = ""
+	r.lastEvents = nil
+	r.lastRun = nil
+	if err := r.checkPolicy(); err != nil {
+		return nil, err
+	}
+	if err := r.hashContents(); err != nil {
+		return nil, err
+	}
+	if err := r.admit(ctx); err != nil {
+		return nil, err
+	}
+	if err := r.validateInventory(ctx); err != nil {
+		return nil, err
+	}
+	r.warnings = nil
+	r.slowestTasks = nil
+	if err := r.writeCmdline(); err != nil {
+		return nil, err
+	}
+
+	cmdFunc := r.cmdFunc
+	switch {
+	case r.creating && r.createCmdFunc != nil:
+		cmdFunc = r.createCmdFunc
+	case r.deleting && r.deleteCmdFunc != nil:
+		cmdFunc = r.deleteCmdFunc
+	}
+	dc := cmdFunc(ctx, r.behaviorVars)
+	if runnerSupports(r.runnerVersion, 1, 4) {
+		dc.Args = append(dc.Args, "--rotate-artifacts", strconv.Itoa(r.artifactsHistoryLimit))
+	}
 
+	if r.artifactsDir != "" {
+		dc.Args = append(dc.Args, "--artifact-dir", r.artifactsDir)
+	}
 	id := generateUUID().String()
 	dc.Args = append(dc.Args, "--ident", id)
+	if err := r.writeMetadata(id, dc); err != nil {
+		return nil, err
+	}
 
-	if !r.checkMode {
-		// for disabled checkMode dc.Stdout and dc.Stderr are respectfully
-		// written to os.Stdout and os.Stdout for debugging purpose
-		stdoutWriter = os.Stdout
-		stderrWriter = os.Stderr
-	} else {
-		// dc.Stdout is buffered into stdoutBuf for stream result parsing purposes.
-		// ansible-runner dry-run execution stdout is written only to stdoutBuf
-		// and not os.Stdout (we cannot parse os.Stdout because the main process is writing to it)
-		stdoutWriter = io.Writer(&stdoutBuf)
+	dc.Stdout = os.Stdout
+	if r.checkMode {
+		dc.Stdout = io.MultiWriter(os.Stdout, &stdoutBuf)
 	}
-	dc.Stdout = stdoutWriter
-	dc.Stderr = stderrWriter
+	dc.Stderr = os.Stderr
 
 	// let the command shut down gracefully
 	dc.Cancel = func() error {
@@ -397,25 +1041,140 @@ func (r *Runner) Run(ctx context.Context) (io.Reader, error) {
 	// it's going to be forcefully shut down with SIGKILL
 	dc.WaitDelay = 10 * time.Second
 
-	err := dc.Start()
+	r.reportStatus(ctx, StatusStarting)
+	start := time.Now()
+	wait, err := r.start(ctx, dc, id)
 	if err != nil {
+		r.reportStatus(ctx, StatusFailed)
 		return nil, err
 	}
+	r.lastContentHash = r.contentHash
+	r.reportStatus(ctx, StatusRunning)
+
+	stopHeartbeat := r.startHeartbeat(ctx, id)
+	stopTaskEvents := r.startTaskEvents(ctx, id)
+	err = wait()
+	stopTaskEvents()
+	stopHeartbeat()
+	status := r.finalStatus(ctx, id, err)
+	r.reportStatus(ctx, status)
+	jobEventsDir := filepath.Clean(filepath.Join(r.artifactsPath(id), "job_events"))
+	evts, evtsErr := parseEvents(ctx, jobEventsDir)
+	if evtsErr != nil {
+		log.FromContext(ctx).V(1).Info("parsing job events", "err", evtsErr)
+	}
+	warnings, warningsErr := extractWarnings(evts)
+	if warningsErr != nil {
+		log.FromContext(ctx).V(1).Info("extracting ansible ignored failures", "err", warningsErr)
+	}
+	r.warnings = warnings
+	r.slowestTasks = slowestTasks(evts, r.profileTasks)
+	r.lastEvents = evts
+	r.lastRun = runSummary(id, r.checkMode, start, time.Now(), evts)
+	r.recordOutcome(r.lastRun, status, err)
+	if reportErr := r.writeReport(ctx, id, start, evts); reportErr != nil {
+		r.warnings = append(r.warnings, reportErr.Error())
+	}
 
-	if err := dc.Wait(); err != nil {
-		jobEventsDir := filepath.Clean(filepath.Join(r.workDir, "artifacts", id, "job_events"))
+	if err != nil {
+		r.lastRun.FailedPlaybook = failedPlaybook(evts)
+		runErr := &RunError{Kind: classifyFailure(err, evts), Reason: describeFailedPlaybook(r.lastRun.FailedPlaybook, ""), Err: err}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			runErr.Kind = FailureTimeout
+			runErr.Err = fmt.Errorf("%s: %w", errTimeout, err)
+		}
 		failureReason, reasonErr := extractFailureReason(ctx, jobEventsDir)
 		if reasonErr != nil {
 			log.FromContext(ctx).V(1).Info("extracting ansible failure message", "err", reasonErr)
-			return nil, err
+			return nil, runErr
 		}
+		runErr.Reason = describeFailedPlaybook(r.lastRun.FailedPlaybook, failureReason)
 
-		return nil, fmt.Errorf("%w: %s", err, failureReason)
+		return nil, runErr
 	}
 
 	return &stdoutBuf, nil
 }
 
+// startHeartbeat notifies the progress handler periodically with the progress
+// of the run with the supplied ident, until the returned func is called.
+func (r *Runner) startHeartbeat(ctx context.Context, id string) func() {
+	if r.progressHandler == nil || r.heartbeatInterval <= 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(r.heartbeatInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				r.progressHandler(ctx, r.progress(ctx, id))
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// progress returns the progress of the run with the supplied ident: the task
+// of its latest job event, empty when no task started yet, and the number of
+// tasks it started.
+func (r *Runner) progress(ctx context.Context, id string) Progress {
+	evts, err := parseEvents(ctx, filepath.Clean(filepath.Join(r.artifactsPath(id), "job_events")))
+	if err != nil {
+		return Progress{}
+	}
+	p := Progress{TasksStarted: tasksStarted(evts)}
+	for i := len(evts) - 1; i >= 0; i-- {
+		if task, ok := evts[i].EventData["task"].(string); ok && task != "" {
+			p.CurrentTask = task
+			break
+		}
+	}
+	return p
+}
+
+// tasksStarted counts the tasks started by a run, a task being started once
+// for all its hosts.
+func tasksStarted(evts []jobEvent) int {
+	var n int
+	for _, evt := range evts {
+		if evt.Event == eventTypeTaskStart {
+			n++
+		}
+	}
+	return n
+}
+
+// finalStatus determines the status of the finished run with the supplied ident.
+func (r *Runner) finalStatus(ctx context.Context, id string, err error) string {
+	switch {
+	case err == nil:
+		return StatusSuccessful
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return StatusTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		return StatusCanceled
+	}
+	// ansible-runner records the final status, which tells whether its
+	// own job timeout was hit, in the artifacts of the run
+	b, rerr := os.ReadFile(filepath.Clean(filepath.Join(r.artifactsPath(id), "status")))
+	if rerr == nil {
+		switch status := strings.TrimSpace(string(b)); status {
+		case StatusTimeout, StatusCanceled:
+			return status
+		}
+	}
+	return StatusFailed
+}
+
 func extractFailureReason(ctx context.Context, eventsDir string) (string, error) {
 	evts, err := parseEvents(ctx, eventsDir)
 	if err != nil {
@@ -426,19 +1185,19 @@ func extractFailureReason(ctx context.Context, eventsDir string) (string, error)
 	for _, evt := range evts {
 		switch evt.Event {
 		case eventTypeRunnerFailed:
-			m, err := runnerEventMessage(evt, "Failed")
+			m, ignored, err := runnerEventMessage(evt, "Failed")
 			if err != nil {
 				return "", err
 			}
-			if m != "" {
+			if !ignored {
 				msgs = append(msgs, m)
 			}
 		case eventTypeRunnerUnreachable:
-			m, err := runnerEventMessage(evt, "Unreachable")
+			m, ignored, err := runnerEventMessage(evt, "Unreachable")
 			if err != nil {
 				return "", err
 			}
-			if m != "" {
+			if !ignored {
 				msgs = append(msgs, m)
 			}
 		default:
@@ -448,6 +1207,188 @@ func extractFailureReason(ctx context.Context, eventsDir string) (string, error)
 	return strings.Join(msgs, "; "), nil
 }
 
+// extractWarnings returns the failures of the tasks that have ignore_errors
+// enabled, which don't fail the run but shouldn't go unnoticed either.
+func extractWarnings(evts []jobEvent) ([]string, error) {
+	var msgs []string
+	for _, evt := range evts {
+		if evt.Event != eventTypeRunnerFailed {
+			continue
+		}
+		m, ignored, err := runnerEventMessage(evt, "Ignored failure")
+		if err != nil {
+			return nil, err
+		}
+		if ignored {
+			msgs = append(msgs, m)
+		}
+	}
+
+	return msgs, nil
+}
+
+// extractStats returns the recap of a run, from its playbook_on_stats event,
+// in the shape of the results of the json stdout callback, along with the
+// plays of the tasks that changed a host.
+func extractStats(evts []jobEvent) (*results.AnsiblePlaybookJSONResults, error) {
+	for i := len(evts) - 1; i >= 0; i-- {
+		if evts[i].Event != eventTypePlaybookOnStats {
+			continue
+		}
+		var evtData statsEventData
+		if err := reunmarshal(evts[i].EventData, &evtData); err != nil {
+			return nil, err
+		}
+		plays, err := changedTasks(evts[:i])
+		if err != nil {
+			return nil, err
+		}
+		return &results.AnsiblePlaybookJSONResults{Plays: plays, Stats: evtData.hostStats()}, nil
+	}
+	return nil, errors.New(errNoStats)
+}
+
+// changedTasks returns the tasks that changed a host, by play, in the order
+// they ran, along with the hosts they changed.
+func changedTasks(evts []jobEvent) ([]results.AnsiblePlaybookJSONResultsPlay, error) {
+	var plays []results.AnsiblePlaybookJSONResultsPlay
+	type taskIndex struct{ play, task int }
+	tasks := map[string]taskIndex{}
+	for _, evt := range evts {
+		if evt.Event != eventTypeRunnerOk {
+			continue
+		}
+		var evtData runnerEventData
+		if err := reunmarshal(evt.EventData, &evtData); err != nil {
+			return nil, err
+		}
+		if !evtData.Result.Changed {
+			continue
+		}
+		name := evtData.Task
+		if evtData.Role != "" {
+			name = evtData.Role + " : " + name
+		}
+		key := evtData.Play + "\x00" + name
+		idx, ok := tasks[key]
+		if !ok {
+			idx.play = -1
+			for i := range plays {
+				if plays[i].Play.Name == evtData.Play {
+					idx.play = i
+				}
+			}
+			if idx.play == -1 {
+				plays = append(plays, results.AnsiblePlaybookJSONResultsPlay{Play: &results.AnsiblePlaybookJSONResultsPlaysPlay{Name: evtData.Play}})
+				idx.play = len(plays) - 1
+			}
+			plays[idx.play].Tasks = append(plays[idx.play].Tasks, results.AnsiblePlaybookJSONResultsPlayTask{
+				Task:  &results.AnsiblePlaybookJSONResultsPlayTaskItem{Name: name},
+				Hosts: map[string]*results.AnsiblePlaybookJSONResultsPlayTaskHostsItem{},
+			})
+			idx.task = len(plays[idx.play].Tasks) - 1
+			tasks[key] = idx
+		}
+		plays[idx.play].Tasks[idx.task].Hosts[evtData.Host] = &results.AnsiblePlaybookJSONResultsPlayTaskHostsItem{Changed: true}
+	}
+	return plays, nil
+}
+
+// runSummary summarizes the run with the supplied ident, with the task results
+// per host of its recap when it got that far.
+func runSummary(id string, checkMode bool, start, end time.Time, evts []jobEvent) *v1alpha1.RunSummary {
+	summary := &v1alpha1.RunSummary{
+		ID:        id,
+		CheckMode: checkMode,
+		StartTime: metav1.NewTime(start),
+		EndTime:   metav1.NewTime(end),
+		Duration:  metav1.Duration{Duration: end.Sub(start).Round(time.Millisecond)},
+		Tasks:     tasksStarted(evts),
+	}
+	res, err := extractStats(evts)
+	if err != nil {
+		return summary
+	}
+	for host, stats := range res.Stats {
+		summary.Hosts = append(summary.Hosts, v1alpha1.HostStats{
+			Host:        host,
+			Ok:          stats.Ok,
+			Changed:     stats.Changed,
+			Failed:      stats.Failures,
+			Unreachable: stats.Unreachable,
+			Skipped:     stats.Skipped,
+			Rescued:     stats.Rescued,
+			Ignored:     stats.Ignored,
+		})
+	}
+	sort.Slice(summary.Hosts, func(i, j int) bool {
+		return summary.Hosts[i].Host < summary.Hosts[j].Host
+	})
+	// keep the status of runs on fleets within the etcd size limits
+	if len(summary.Hosts) > maxSummaryHosts {
+		summary.Hosts = summary.Hosts[:maxSummaryHosts]
+	}
+	return summary
+}
+
+// recordOutcome records in the supplied summary of a run the supplied final
+// status and error of the run, along with its artifacts directory and the rc
+// ansible-runner recorded there.
+func (r *Runner) recordOutcome(summary *v1alpha1.RunSummary, status string, err error) {
+	summary.Status = status
+	summary.ArtifactsPath = r.artifactsPath(summary.ID)
+	var exitErr interface{ ExitCode() int }
+	switch {
+	case err == nil:
+		code := 0
+		summary.ExitCode = &code
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		code := exitErr.ExitCode()
+		summary.ExitCode = &code
+	}
+	b, rerr := os.ReadFile(filepath.Clean(filepath.Join(summary.ArtifactsPath, "rc")))
+	if rerr != nil {
+		return
+	}
+	if rc, rerr := strconv.Atoi(strings.TrimSpace(string(b))); rerr == nil {
+		summary.RC = &rc
+	}
+}
+
+// slowestTasks returns the n tasks that took the longest on a host, slowest first.
+func slowestTasks(evts []jobEvent, n int) []v1alpha1.TaskTiming {
+	if n <= 0 {
+		return nil
+	}
+
+	var timings []v1alpha1.TaskTiming
+	for _, evt := range evts {
+		switch evt.Event {
+		case eventTypeRunnerOk, eventTypeRunnerFailed, eventTypeRunnerUnreachable:
+		default:
+			continue
+		}
+		var evtData runnerEventData
+		if err := reunmarshal(evt.EventData, &evtData); err != nil {
+			continue
+		}
+		timings = append(timings, v1alpha1.TaskTiming{
+			Play:     evtData.Play,
+			Task:     evtData.Task,
+			Host:     evtData.Host,
+			Duration: metav1.Duration{Duration: time.Duration(evtData.Duration * float64(time.Second)).Round(time.Millisecond)},
+		})
+	}
+
+	sort.SliceStable(timings, func(i, j int) bool {
+		return timings[i].Duration.Duration > timings[j].Duration.Duration
+	})
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	return timings
+}
+
 func parseEvents(ctx context.Context, dir string) ([]jobEvent, error) {
 	files, err := os.ReadDir(dir)
 	if err != nil {
@@ -469,6 +1410,10 @@ func parseEvents(ctx context.Context, dir string) ([]jobEvent, error) {
 		}
 		evts = append(evts, evt)
 	}
+	// event files are named after their counter, which doesn't sort as a string
+	sort.SliceStable(evts, func(i, j int) bool {
+		return evts[i].Counter < evts[j].Counter
+	})
 
 	return evts, nil
 }
@@ -482,22 +1427,20 @@ func reunmarshal(data map[string]any, result any) error {
 	return json.Unmarshal(b, result)
 }
 
-func runnerEventMessage(evt jobEvent, reason string) (string, error) {
+// runnerEventMessage formats the message of a runner event and tells whether
+// the errors of its task are ignored.
+func runnerEventMessage(evt jobEvent, reason string) (string, bool, error) {
 	var evtData runnerEventData
 	if err := reunmarshal(evt.EventData, &evtData); err != nil {
-		return "", fmt.Errorf("unmarshaling job event %s as runner event: %w", evt.UUID, err)
-	}
-	if evtData.IgnoreErrors {
-		return "", nil
+		return "", false, fmt.Errorf("unmarshaling job event %s as runner event: %w", evt.UUID, err)
 	}
 
-	return fmt.Sprintf("%s on play %q, task %q, host %q: %s",
+	return fmt.Sprintf("%s on play %q, %s, host %q: %s",
 		reason,
 		evtData.Play,
-		evtData.Task,
+		evtData.describeTask(),
 		evtData.Host,
-		evtData.Result.Msg), nil
-
+		evtData.Result.Msg), evtData.IgnoreErrors, nil
 }
 
 // selectRolePath will determines the role path
@@ -535,6 +1478,25 @@ func selectRolePath(p Parameters, behaviorVars map[string]string) (string, error
 	return rolePath, nil
 }
 
+// CollectionsPath returns the collections paths of Ansible with the supplied
+// behavior vars, led by the supplied directory.
+func CollectionsPath(dir string, behaviorVars map[string]string) string {
+	/*
+		collections path lookup order:
+			1- behaviorVars
+			2- os environnement variables
+			3- Ansible default list of paths
+	*/
+	paths := defaultCollectionsPath
+	// like Ansible, the last of the variables set wins
+	for _, v := range []string{os.Getenv(AnsibleCollectionsPath), os.Getenv(CollectionsPathEnv), behaviorVars[AnsibleCollectionsPath], behaviorVars[CollectionsPathEnv]} {
+		if v != "" {
+			paths = v
+		}
+	}
+	return dir + ":" + expandHome(paths)
+}
+
 // addFile micmics https://github.com/operator-framework/operator-sdk/blob/master/internal/ansible/runner/internal/inputdir/inputdir.go#L55-L63
 func addFile(path string, content []byte) error {
 	if err := os.WriteFile(path, content, 0600); err != nil {
@@ -543,45 +1505,302 @@ func addFile(path string, content []byte) error {
 	return nil
 }
 
-// WriteExtraVar write extra var to env/extravars under working directory
-// it creates a non-existent env/extravars file
+// WriteExtraVar sets the provider meta exposed to the Ansible contents under
+// ansible_provider_meta and writes it to env/extravars under working directory,
+// next to the vars of the AnsibleRun
 func (r *Runner) WriteExtraVar(extraVar map[string]interface{}) error {
-	extraVarsPath := filepath.Join(r.ansibleEnvDir(), "extravars")
-	contentVars := make(map[string]interface{})
-	data, err := os.ReadFile(filepath.Clean(extraVarsPath))
+	r.providerMeta = extraVar
+	return r.writeExtraVars()
+}
+
+// WriteState passes the requested state of the AnsibleRun, either "present"
+// or "absent", to the Ansible contents through the state var, and through
+// ansible_provider_meta.<name>.state when the legacy provider meta is enabled
+func (r *Runner) WriteState(state string) error {
+	stateVar := r.stateVar
+	if stateVar == "" {
+		stateVar = DefaultStateVar
+	}
+	r.state = state
+	r.providerVars = map[string]interface{}{stateVar: state}
+	if r.legacyProviderMeta {
+		r.providerMeta = map[string]interface{}{r.name: map[string]string{"state": state}}
+	}
+	return r.writeExtraVars()
+}
+
+// AddRequirementsPath adds the path of a requirements file installed outside
+// of the project directory, e.g. into the collections cache of the
+// ProviderConfig, which is hashed along with the contents.
+func (r *Runner) AddRequirementsPath(path string) {
+	r.requirementsPaths = append(r.requirementsPaths, path)
+}
+
+// SetDefaultVars sets the default vars of the Ansible contents, from the
+// ProviderConfig, and writes them to env/extravars along with the vars of the
+// AnsibleRun, which take precedence over them.
+func (r *Runner) SetDefaultVars(vars runtime.RawExtension) error {
+	stateVar := r.stateVar
+	if stateVar == "" {
+		stateVar = DefaultStateVar
+	}
+	defaultVars, err := unmarshalContentVars(vars, ProviderMetaVar, stateVar)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
+		return err
+	}
+	if len(defaultVars) == 0 {
+		// env/extravars is written without them on Init
+		return nil
+	}
+	r.defaultVars = defaultVars
+	return r.writeExtraVars()
+}
+
+// SetVarsFrom sets the vars read from the Secrets and ConfigMaps of the
+// AnsibleRun and writes them to env/extravars, between the default vars and
+// the vars of the AnsibleRun. The vars read from Secrets are sensitive, so
+// that runner keeps env/extravars out of the artifacts.
+func (r *Runner) SetVarsFrom(vars map[string]string, sensitive bool) error {
+	if len(vars) == 0 {
+		// env/extravars is written without them on Init
+		return nil
+	}
+	stateVar := r.stateVar
+	if stateVar == "" {
+		stateVar = DefaultStateVar
+	}
+	for _, reserved := range []string{ProviderMetaVar, stateVar} {
+		if _, ok := vars[reserved]; ok {
+			return fmt.Errorf("%s: %q", errReservedExtraVar, reserved)
 		}
 	}
-	if len(data) != 0 {
-		if err := json.Unmarshal(data, &contentVars); err != nil {
+	r.fromVars = make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		r.fromVars[k] = v
+	}
+	if sensitive {
+		if err := r.writeSettings(true); err != nil {
 			return err
 		}
 	}
-	contentVars["ansible_provider_meta"] = extraVar
-	contentVarsB, err := json.Marshal(contentVars)
+	return r.writeExtraVars()
+}
+
+// userVars returns the vars of the Ansible contents set by the users: the
+// default vars, overridden key by key by the vars read from Secrets and
+// ConfigMaps, themselves overridden by the vars of the AnsibleRun.
+func (r *Runner) userVars() map[string]interface{} {
+	if len(r.defaultVars) == 0 && len(r.fromVars) == 0 {
+		return r.extraVars
+	}
+	vars := make(map[string]interface{}, len(r.defaultVars)+len(r.fromVars)+len(r.extraVars))
+	for _, layer := range []map[string]interface{}{r.defaultVars, r.fromVars, r.extraVars} {
+		for k, v := range layer {
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// writeExtraVars writes the extra vars of the runner as a YAML document to env/extravars
+func (r *Runner) writeExtraVars() error {
+	userVars := r.userVars()
+	contentVars := make(map[string]interface{}, len(userVars)+len(r.providerVars)+1)
+	for k, v := range userVars {
+		contentVars[k] = v
+	}
+	for k, v := range r.providerVars {
+		contentVars[k] = v
+	}
+	if r.providerMeta != nil {
+		contentVars[ProviderMetaVar] = r.providerMeta
+	}
+	contentVarsJSON, err := json.Marshal(contentVars)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w", errMarshalContentVars, err)
 	}
-	if err := os.WriteFile(extraVarsPath, contentVarsB, 0600); err != nil {
-		return err
+	contentVarsYAML, err := yaml.JSONToYAML(contentVarsJSON)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errMarshalContentVars, err)
 	}
-	return nil
+	return addFile(filepath.Join(r.ansibleEnvDir(), runnerutil.ExtraVars), append([]byte(extraVarsHeader), contentVarsYAML...))
+}
+
+// writeSettings writes the ansible-runner env/settings file. When the extra
+// vars are sensitive, runner is told not to copy env files, extravars
+// included, into the artifacts directory.
+func (r *Runner) writeSettings(sensitive bool) error {
+	settingsPath := filepath.Join(r.ansibleEnvDir(), runnerutil.Settings)
+	if !sensitive {
+		if err := os.Remove(settingsPath); resource.Ignore(os.IsNotExist, err) != nil {
+			return err
+		}
+		return nil
+	}
+	return addFile(settingsPath, []byte("suppress_env_files: true\n"))
 }
 
-// Diff parses `ansible-runner --check` json output to determine whether there is a diff between
-// the desired and the actual state of the configuration. It returns true if there is a diff.
-func Diff(res *results.AnsiblePlaybookJSONResults) bool {
-	var changes bool
+// Diff tells from the results of an `ansible-runner --check` run whether there is a diff between
+// the desired and the actual state of the configuration. It returns the drift, i.e. the hosts
+// and the tasks that would change, if there is a diff, and nil otherwise. The time the drift
+// was detected is left to the caller.
+func Diff(res *results.AnsiblePlaybookJSONResults) *v1alpha1.Drift {
+	return diff(res, maxSummaryHosts, maxDriftTasks)
+}
+
+// FullDiff is Diff without truncating the hosts and the tasks of the drift,
+// for the drift stored out of the status.
+func FullDiff(res *results.AnsiblePlaybookJSONResults) *v1alpha1.Drift {
+	return diff(res, 0, 0)
+}
+
+// diff returns the drift of the supplied results with at most maxHosts hosts
+// and maxTasks tasks, no limit when 0.
+func diff(res *results.AnsiblePlaybookJSONResults, maxHosts, maxTasks int) *v1alpha1.Drift {
+	var drift v1alpha1.Drift
 	// check changes for all hosts
-	for _, stats := range res.Stats {
+	for host, stats := range res.Stats {
 		if stats.Changed != 0 {
-			changes = true
-			break
+			drift.Hosts = append(drift.Hosts, host)
+		}
+	}
+	if len(drift.Hosts) == 0 {
+		return nil
+	}
+	sort.Strings(drift.Hosts)
+	// keep the status of runs on fleets within the etcd size limits
+	if maxHosts != 0 && len(drift.Hosts) > maxHosts {
+		drift.Hosts = drift.Hosts[:maxHosts]
+	}
+	for _, play := range res.Plays {
+		for _, task := range play.Tasks {
+			if maxTasks != 0 && len(drift.Tasks) == maxTasks {
+				return &drift
+			}
+			t := v1alpha1.DriftedTask{Task: task.Task.Name}
+			if play.Play != nil {
+				t.Play = play.Play.Name
+			}
+			for host, item := range task.Hosts {
+				if item.Changed {
+					t.Hosts = append(t.Hosts, host)
+				}
+			}
+			if len(t.Hosts) == 0 {
+				continue
+			}
+			sort.Strings(t.Hosts)
+			if maxHosts != 0 && len(t.Hosts) > maxHosts {
+				t.Hosts = t.Hosts[:maxHosts]
+			}
+			drift.Tasks = append(drift.Tasks, t)
+		}
+	}
+	return &drift
+}
+
+// cmdline returns the ansible-playbook arguments of the next run.
+func (r *Runner) cmdline() []string {
+	var args []string
+	if r.checkMode {
+		args = append(args, "--check")
+	}
+	args = append(args, r.vaultArgs()...)
+	args = append(args, r.sshArgs()...)
+	// the arguments of the AnsibleRun go last, its extra arguments may
+	// override the provider defaults, e.g. the SSH user
+	return append(args, r.cmdlineOpts.args(r.creating, r.createTags)...)
+}
+
+// writeCmdline writes the ansible-playbook arguments of the next run to
+// env/cmdline rather than passing them through the --cmdline flag, which
+// requires escaping them. The file is removed when there are no arguments
+// so that a previous check run doesn't leak into the next one.
+func (r *Runner) writeCmdline() error {
+	cmdlinePath := filepath.Join(r.ansibleEnvDir(), runnerutil.Cmdline)
+	args := r.cmdline()
+	if len(args) == 0 {
+		if err := os.Remove(cmdlinePath); resource.Ignore(os.IsNotExist, err) != nil {
+			return err
 		}
+		return nil
+	}
+	return addFile(cmdlinePath, []byte(runnerutil.ShellQuote(args)))
+}
+
+// Check runs the contents in check mode for the present state and returns the
+// parsed results, from which Diff tells whether the contents would change anything.
+func (r *Runner) Check(ctx context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+	ctx, cancel := withTimeout(ctx, r.checkTimeout)
+	defer cancel()
+	if err := r.WriteState(StatePresent); err != nil {
+		return nil, err
+	}
+	r.EnableCheckMode(true)
+	if _, err := r.Run(ctx); err != nil {
+		return nil, err
+	}
+	return extractStats(r.lastEvents)
+}
+
+// Apply runs the contents for the present state.
+func (r *Runner) Apply(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, r.applyTimeout)
+	defer cancel()
+	if err := r.WriteState(StatePresent); err != nil {
+		return err
+	}
+	r.EnableCheckMode(false)
+	_, err := r.Run(ctx)
+	return err
+}
+
+// Create runs the create contents for the present state, i.e. the create
+// playbook, if any, with the create tags.
+func (r *Runner) Create(ctx context.Context) error {
+	r.creating = true
+	defer func() { r.creating = false }()
+	return r.Apply(ctx)
+}
+
+// Destroy runs the delete contents, if any, or else the contents, for the
+// absent state.
+func (r *Runner) Destroy(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, r.destroyTimeout)
+	defer cancel()
+	r.deleting = true
+	defer func() { r.deleting = false }()
+	if err := r.WriteState(StateAbsent); err != nil {
+		return err
+	}
+	r.EnableCheckMode(false)
+	_, err := r.Run(ctx)
+	return err
+}
+
+// Warnings returns the failures of the tasks with ignore_errors enabled
+// during the last run.
+func (r *Runner) Warnings() []string {
+	return r.warnings
+}
+
+// LastRun returns the summary of the last run, nil when it didn't start.
+func (r *Runner) LastRun() *v1alpha1.RunSummary {
+	return r.lastRun
+}
+
+// SlowestTasks returns the slowest tasks of the last run when task profiling
+// is enabled.
+func (r *Runner) SlowestTasks() []v1alpha1.TaskTiming {
+	return r.slowestTasks
+}
+
+// withTimeout bounds ctx by the supplied timeout, if any.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return changes
+	return context.WithTimeout(ctx, timeout)
 }
 
 // EnableCheckMode enable the runner checkMode.
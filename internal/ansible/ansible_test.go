@@ -18,6 +18,7 @@ package ansible
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -25,13 +26,18 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/apenella/go-ansible/pkg/stdoutcallback/results"
 	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
 	"gotest.tools/v3/assert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -55,19 +61,20 @@ func prepareAnsibleContext(dir string) (string, error) {
 	}
 
 	ansibleDir := filepath.Join(tmpDir, dir)
-	if err := os.MkdirAll(ansibleDir, 0750); err != nil {
+	projectDir := filepath.Join(ansibleDir, "project")
+	if err := os.MkdirAll(projectDir, 0750); err != nil {
 		return "", err
 	}
 
-	if err = os.WriteFile(filepath.Join(ansibleDir, "requirements.yml"), []byte(requirements), 0644); err != nil {
+	if err = os.WriteFile(filepath.Join(projectDir, "requirements.yml"), []byte(requirements), 0644); err != nil {
 		return "", err
 	}
 
-	if err = os.WriteFile(filepath.Join(ansibleDir, "playbook.yml"), nil, 0644); err != nil {
+	if err = os.WriteFile(filepath.Join(projectDir, "playbook.yml"), nil, 0644); err != nil {
 		return "", err
 	}
 
-	roleDir := filepath.Join(ansibleDir, "roles")
+	roleDir := filepath.Join(projectDir, "roles")
 	if err := os.Mkdir(roleDir, 0750); err != nil {
 		return "", err
 	}
@@ -131,6 +138,41 @@ func TestAnsibleRunPolicyInit(t *testing.T) {
 	}
 }
 
+func TestRunPolicyOf(t *testing.T) {
+	cases := map[string]struct {
+		reason     string
+		spec       string
+		annotation string
+		want       string
+	}{
+		"Unset": {
+			reason: "An AnsibleRun without policy should have none, the default is applied later",
+		},
+		"Annotation": {
+			reason:     "The annotation should be honored when the spec sets no policy",
+			annotation: "CheckWhenObserve",
+			want:       "CheckWhenObserve",
+		},
+		"Spec": {
+			reason:     "The spec should take precedence over the annotation",
+			spec:       "CheckWhenObserve",
+			annotation: "ObserveAndDelete",
+			want:       "CheckWhenObserve",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{RunPolicy: tc.spec}}}
+			if tc.annotation != "" {
+				SetPolicyRun(cr, tc.annotation)
+			}
+			if got := RunPolicyOf(cr); got != tc.want {
+				t.Errorf("\n%s\nRunPolicyOf(...): want %q, got %q", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
 func TestInit(t *testing.T) {
 	dir := t.TempDir()
 
@@ -155,8 +197,8 @@ func TestInit(t *testing.T) {
 	}
 
 	expectedRunner := &Runner{
-		Path:                  dir,
-		cmdFunc:               params.playbookCmdFunc(context.Background(), "playbook.yml", dir),
+		Path:                  filepath.Join(dir, "project"),
+		cmdFunc:               params.playbookCmdFunc("playbook.yml"),
 		workDir:               dir,
 		AnsibleRunPolicy:      &RunPolicy{"ObserveAndDelete"},
 		artifactsHistoryLimit: 3,
@@ -181,8 +223,8 @@ func TestInit(t *testing.T) {
 		t.Errorf("Unexpected Runner.workDir %v expected %v", runner.workDir, expectedRunner.workDir)
 	}
 
-	expectedCmd := expectedRunner.cmdFunc(nil, false)
-	cmd := runner.cmdFunc(nil, false)
+	expectedCmd := expectedRunner.cmdFunc(context.Background(), nil)
+	cmd := runner.cmdFunc(context.Background(), nil)
 	if cmd.String() != expectedCmd.String() {
 		t.Errorf("Unexpected Runner.cmdFunc output %q expected %q", expectedCmd.String(), cmd.String())
 	}
@@ -190,13 +232,17 @@ func TestInit(t *testing.T) {
 
 func TestRun(t *testing.T) {
 	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+		t.Fatalf("Creating env dir: %v", err)
+	}
 
 	runner := &Runner{
-		Path: dir,
-		cmdFunc: func(_ map[string]string, _ bool) *exec.Cmd {
+		Path:    dir,
+		workDir: dir,
+		cmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
 			// echo works well for testing cause it will just print all the args and flags it doesn't recognize and return success,
 			// therefore checking its output also checks the args passed to it are correct
-			return exec.CommandContext(context.Background(), "echo")
+			return exec.CommandContext(ctx, "echo")
 		},
 		AnsibleRunPolicy:      &RunPolicy{"ObserveAndDelete"},
 		artifactsHistoryLimit: 3,
@@ -208,15 +254,17 @@ func TestRun(t *testing.T) {
 	generateUUID = func() uuid.UUID { return uuid.MustParse(expectedID) }
 
 	testCases := map[string]struct {
-		checkMode      bool
-		expectedOutput string
+		checkMode       bool
+		expectedOutput  string
+		expectedCmdline string
 	}{
 		"WithoutCheckMode": {
 			expectedOutput: "",
 		},
 		"WithCheckMode": {
-			checkMode:      true,
-			expectedOutput: strings.Join(expectedArgs, " ") + "\n",
+			checkMode:       true,
+			expectedOutput:  strings.Join(expectedArgs, " ") + "\n",
+			expectedCmdline: "--check",
 		},
 	}
 
@@ -236,10 +284,189 @@ func TestRun(t *testing.T) {
 			if string(out) != tc.expectedOutput {
 				t.Errorf("Unexpected output in the command buffer %q, want %q", string(out), tc.expectedOutput)
 			}
+
+			cmdline, err := os.ReadFile(filepath.Join(dir, "env", "cmdline"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatalf("Unexpected error reading env/cmdline: %v", err)
+			}
+			if string(cmdline) != tc.expectedCmdline {
+				t.Errorf("Unexpected env/cmdline %q, want %q", string(cmdline), tc.expectedCmdline)
+			}
+		})
+	}
+}
+
+func TestRunArtifactsDir(t *testing.T) {
+	dir := t.TempDir()
+	artifactsDir := filepath.Join(t.TempDir(), "uid")
+	if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+		t.Fatalf("Creating env dir: %v", err)
+	}
+
+	runner := &Runner{
+		Path:    dir,
+		workDir: dir,
+		cmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
+			return exec.CommandContext(ctx, "echo")
+		},
+		AnsibleRunPolicy:      &RunPolicy{"ObserveAndDelete"},
+		artifactsHistoryLimit: 3,
+		artifactsDir:          artifactsDir,
+		checkMode:             true,
+	}
+
+	id := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(id) }
+
+	outBuf, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected Run() error: %v", err)
+	}
+	out, err := io.ReadAll(outBuf)
+	if err != nil {
+		t.Fatalf("Unexpected error reading command buffer: %v", err)
+	}
+	expectedOutput := strings.Join([]string{"--rotate-artifacts", "3", "--artifact-dir", artifactsDir, "--ident", id}, " ") + "\n"
+	if string(out) != expectedOutput {
+		t.Errorf("Unexpected output in the command buffer %q, want %q", string(out), expectedOutput)
+	}
+	if want := filepath.Join(artifactsDir, id); runner.LastRun().ArtifactsPath != want {
+		t.Errorf("Unexpected artifacts path %q, want %q", runner.LastRun().ArtifactsPath, want)
+	}
+	if _, err := os.Stat(filepath.Join(artifactsDir, id, MetadataFile)); err != nil {
+		t.Errorf("Want the metadata of the run in the artifacts directory: %v", err)
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	id := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(id) }
+
+	testCases := map[string]struct {
+		script         string
+		runnerStatus   string
+		timeout        time.Duration
+		expectedStatus []string
+	}{
+		"Successful": {
+			script:         "exit 0",
+			expectedStatus: []string{StatusStarting, StatusRunning, StatusSuccessful},
+		},
+		"Failed": {
+			script:         "exit 2",
+			runnerStatus:   "failed",
+			expectedStatus: []string{StatusStarting, StatusRunning, StatusFailed},
+		},
+		"RunnerTimeout": {
+			script:         "exit 254",
+			runnerStatus:   "timeout\n",
+			expectedStatus: []string{StatusStarting, StatusRunning, StatusTimeout},
+		},
+		"ContextTimeout": {
+			script:         "exec sleep 10",
+			timeout:        100 * time.Millisecond,
+			expectedStatus: []string{StatusStarting, StatusRunning, StatusTimeout},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+				t.Fatalf("Creating env dir: %v", err)
+			}
+			if tc.runnerStatus != "" {
+				if err := os.MkdirAll(filepath.Join(dir, "artifacts", id), 0700); err != nil {
+					t.Fatalf("Creating artifacts dir: %v", err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, "artifacts", id, "status"), []byte(tc.runnerStatus), 0600); err != nil {
+					t.Fatalf("Writing status: %v", err)
+				}
+			}
+
+			ctx := context.Background()
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+
+			var statuses []string
+			runner := &Runner{
+				workDir: dir,
+				cmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
+					// the extra args are passed as positional parameters to the script
+					return exec.CommandContext(ctx, "sh", "-c", tc.script, "sh")
+				},
+			}
+			runner.SetStatusHandler(func(_ context.Context, status string) {
+				statuses = append(statuses, status)
+			})
+
+			_, _ = runner.Run(ctx)
+
+			if diff := cmp.Diff(tc.expectedStatus, statuses); diff != "" {
+				t.Errorf("Unexpected statuses (-want +got):\n%s", diff)
+			}
+			if !strings.HasPrefix(runner.ContentHash(), "sha256:") {
+				t.Errorf("Unexpected content hash %q of a started run", runner.ContentHash())
+			}
 		})
 	}
 }
 
+func TestHeartbeat(t *testing.T) {
+	id := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(id) }
+
+	dir := t.TempDir()
+	eventsDir := filepath.Join(dir, "artifacts", id, "job_events")
+	if err := os.MkdirAll(eventsDir, 0700); err != nil {
+		t.Fatalf("Creating job events dir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+		t.Fatalf("Creating env dir: %v", err)
+	}
+	evts := map[string]string{
+		"1-a.json":  `{"counter": 1, "event": "playbook_on_start", "event_data": {}}`,
+		"2-b.json":  `{"counter": 2, "event": "playbook_on_task_start", "event_data": {"task": "first"}}`,
+		"10-c.json": `{"counter": 10, "event": "playbook_on_task_start", "event_data": {"task": "second"}}`,
+	}
+	for name, evt := range evts {
+		if err := os.WriteFile(filepath.Join(eventsDir, name), []byte(evt), 0600); err != nil {
+			t.Fatalf("Writing test event to file: %v", err)
+		}
+	}
+
+	var progress []Progress
+	runner := &Runner{
+		workDir: dir,
+		cmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
+			return exec.CommandContext(ctx, "sh", "-c", "exec sleep 0.5", "sh")
+		},
+	}
+	runner.SetProgressHandler(func(_ context.Context, p Progress) {
+		progress = append(progress, p)
+	}, 100*time.Millisecond)
+
+	if _, err := runner.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected Run() error: %v", err)
+	}
+
+	if len(progress) == 0 {
+		t.Fatalf("Expected heartbeats while running")
+	}
+	want := Progress{CurrentTask: "second", TasksStarted: 2}
+	for _, p := range progress {
+		if p != want {
+			t.Errorf("Unexpected progress %+v, want %+v", p, want)
+		}
+	}
+	if got := runner.LastRun().Tasks; got != 2 {
+		t.Errorf("Unexpected tasks of the last run %d, want 2", got)
+	}
+}
+
 func TestExtractFailureReason(t *testing.T) {
 	playbookStartEvt := `
 	{
@@ -278,6 +505,32 @@ func TestExtractFailureReason(t *testing.T) {
 	}
 	`
 
+	runnerRolesFailedEvts := []string{`
+	{
+		"uuid": "0b0f6b1e-2d61-4b55-9a55-5b2f1f2c6d3e",
+		"event": "runner_on_failed",
+		"event_data": {
+			"play": "Run the roles of the AnsibleRun",
+			"role": "database",
+			"task": "install postgresql",
+			"host": "db1",
+			"res": {"msg": "No package matching 'postgresql16'"}
+		}
+	}
+	`, `
+	{
+		"uuid": "5b7b1f0c-8a6e-4a5e-b3a1-6f1b3d2e9c7a",
+		"event": "runner_on_failed",
+		"event_data": {
+			"play": "Run the roles of the AnsibleRun",
+			"role": "webserver",
+			"task": "start nginx",
+			"host": "web1",
+			"res": {"msg": "Unable to start service nginx"}
+		}
+	}
+	`}
+
 	runnerUnreachableEvt := `
 	{
 		"uuid": "ded6289b-e557-48c1-88e1-88eb630aec21",
@@ -292,8 +545,9 @@ func TestExtractFailureReason(t *testing.T) {
 	`
 
 	cases := map[string]struct {
-		events         []string
-		expectedReason string
+		events           []string
+		expectedReason   string
+		expectedWarnings []string
 	}{
 		"NoEvents": {},
 		"NoFailedEvents": {
@@ -303,9 +557,15 @@ func TestExtractFailureReason(t *testing.T) {
 			events:         []string{playbookStartEvt, runnerFailedEvt},
 			expectedReason: `Failed on play "test", task "file", host "testhost": fake error`,
 		},
+		"FailedRoles": {
+			events: append([]string{playbookStartEvt}, runnerRolesFailedEvts...),
+			expectedReason: `Failed on play "Run the roles of the AnsibleRun", role "database", task "install postgresql", host "db1": No package matching 'postgresql16'; ` +
+				`Failed on play "Run the roles of the AnsibleRun", role "webserver", task "start nginx", host "web1": Unable to start service nginx`,
+		},
 		"FailedEventWithIgnoreErrors": {
-			events:         []string{playbookStartEvt, runnerFailedIgnoreErrorsEvt},
-			expectedReason: "",
+			events:           []string{playbookStartEvt, runnerFailedIgnoreErrorsEvt},
+			expectedReason:   "",
+			expectedWarnings: []string{`Ignored failure on play "test", task "file", host "testhost": fake error`},
 		},
 		"UnreachableEvent": {
 			events:         []string{playbookStartEvt, runnerUnreachableEvt},
@@ -330,6 +590,1081 @@ func TestExtractFailureReason(t *testing.T) {
 			if reason != tc.expectedReason {
 				t.Errorf("Unexpected reason %v, expected %v", reason, tc.expectedReason)
 			}
+
+			evts, err := parseEvents(context.Background(), dir)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			warnings, err := extractWarnings(evts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.expectedWarnings, warnings); diff != "" {
+				t.Errorf("Unexpected warnings (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSlowestTasks(t *testing.T) {
+	evts := []jobEvent{
+		{Event: "playbook_on_start"},
+		{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "test", "task": "fast", "host": "testhost", "duration": 0.5}},
+		{Event: eventTypeRunnerFailed, EventData: map[string]any{"play": "test", "task": "slow", "host": "testhost", "duration": 12.25}},
+		{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "test", "task": "medium", "host": "testhost", "duration": 3}},
+	}
+
+	cases := map[string]struct {
+		n    int
+		want []v1alpha1.TaskTiming
+	}{
+		"Disabled": {},
+		"TopTwo": {
+			n: 2,
+			want: []v1alpha1.TaskTiming{
+				{Play: "test", Task: "slow", Host: "testhost", Duration: metav1.Duration{Duration: 12250 * time.Millisecond}},
+				{Play: "test", Task: "medium", Host: "testhost", Duration: metav1.Duration{Duration: 3 * time.Second}},
+			},
+		},
+		"MoreThanTasks": {
+			n: 10,
+			want: []v1alpha1.TaskTiming{
+				{Play: "test", Task: "slow", Host: "testhost", Duration: metav1.Duration{Duration: 12250 * time.Millisecond}},
+				{Play: "test", Task: "medium", Host: "testhost", Duration: metav1.Duration{Duration: 3 * time.Second}},
+				{Play: "test", Task: "fast", Host: "testhost", Duration: metav1.Duration{Duration: 500 * time.Millisecond}},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, slowestTasks(evts, tc.n)); diff != "" {
+				t.Errorf("Unexpected slowest tasks (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtractStats(t *testing.T) {
+	statsEvent := jobEvent{Event: eventTypePlaybookOnStats, EventData: map[string]any{
+		"changed":   map[string]any{"web1": 2},
+		"dark":      map[string]any{"web2": 1},
+		"ok":        map[string]any{"web1": 5},
+		"processed": map[string]any{"web1": 1, "web2": 1, "web3": 1},
+	}}
+
+	cases := map[string]struct {
+		evts []jobEvent
+		want *results.AnsiblePlaybookJSONResults
+		err  error
+	}{
+		"NoStats": {
+			evts: []jobEvent{{Event: "playbook_on_start"}},
+			err:  errors.New(errNoStats),
+		},
+		"Stats": {
+			evts: []jobEvent{{Event: "playbook_on_start"}, statsEvent},
+			want: &results.AnsiblePlaybookJSONResults{Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{
+				"web1": {Changed: 2, Ok: 5},
+				"web2": {Unreachable: 1},
+				"web3": {},
+			}},
+		},
+		"ChangedTasks": {
+			evts: []jobEvent{
+				{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "site", "role": "nginx", "task": "install", "host": "web1", "res": map[string]any{"changed": true}}},
+				{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "site", "task": "ping", "host": "web1", "res": map[string]any{"changed": false}}},
+				{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "site", "role": "nginx", "task": "install", "host": "web2", "res": map[string]any{"changed": true}}},
+				statsEvent,
+			},
+			want: &results.AnsiblePlaybookJSONResults{
+				Plays: []results.AnsiblePlaybookJSONResultsPlay{{
+					Play: &results.AnsiblePlaybookJSONResultsPlaysPlay{Name: "site"},
+					Tasks: []results.AnsiblePlaybookJSONResultsPlayTask{{
+						Task: &results.AnsiblePlaybookJSONResultsPlayTaskItem{Name: "nginx : install"},
+						Hosts: map[string]*results.AnsiblePlaybookJSONResultsPlayTaskHostsItem{
+							"web1": {Changed: true},
+							"web2": {Changed: true},
+						},
+					}},
+				}},
+				Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{
+					"web1": {Changed: 2, Ok: 5},
+					"web2": {Unreachable: 1},
+					"web3": {},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := extractStats(tc.evts)
+			if diff := cmp.Diff(tc.err, err, test.EquateErrors()); diff != "" {
+				t.Errorf("Unexpected error (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected stats (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	task := func(name string, hosts ...string) results.AnsiblePlaybookJSONResultsPlayTask {
+		t := results.AnsiblePlaybookJSONResultsPlayTask{
+			Task:  &results.AnsiblePlaybookJSONResultsPlayTaskItem{Name: name},
+			Hosts: map[string]*results.AnsiblePlaybookJSONResultsPlayTaskHostsItem{},
+		}
+		for _, h := range hosts {
+			t.Hosts[h] = &results.AnsiblePlaybookJSONResultsPlayTaskHostsItem{Changed: true}
+		}
+		return t
+	}
+
+	cases := map[string]struct {
+		res  *results.AnsiblePlaybookJSONResults
+		want *v1alpha1.Drift
+	}{
+		"NoChanges": {
+			res: &results.AnsiblePlaybookJSONResults{Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{"web1": {Ok: 3}}},
+		},
+		"Changes": {
+			res: &results.AnsiblePlaybookJSONResults{
+				Plays: []results.AnsiblePlaybookJSONResultsPlay{{
+					Play:  &results.AnsiblePlaybookJSONResultsPlaysPlay{Name: "site"},
+					Tasks: []results.AnsiblePlaybookJSONResultsPlayTask{task("nginx : install", "web2", "web1"), task("template")},
+				}},
+				Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{
+					"web1": {Changed: 1},
+					"web2": {Changed: 1},
+					"web3": {Ok: 2},
+				},
+			},
+			want: &v1alpha1.Drift{
+				Hosts: []string{"web1", "web2"},
+				Tasks: []v1alpha1.DriftedTask{{Play: "site", Task: "nginx : install", Hosts: []string{"web1", "web2"}}},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, Diff(tc.res)); diff != "" {
+				t.Errorf("Unexpected drift (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFullDiff(t *testing.T) {
+	stats := map[string]*results.AnsiblePlaybookJSONResultsStats{}
+	for i := 0; i < maxSummaryHosts+20; i++ {
+		stats[fmt.Sprintf("web%03d", i)] = &results.AnsiblePlaybookJSONResultsStats{Changed: 1}
+	}
+	res := &results.AnsiblePlaybookJSONResults{Stats: stats}
+
+	if got := len(Diff(res).Hosts); got != maxSummaryHosts {
+		t.Errorf("Diff(...): want %d hosts, got %d", maxSummaryHosts, got)
+	}
+	if got := len(FullDiff(res).Hosts); got != maxSummaryHosts+20 {
+		t.Errorf("FullDiff(...): want %d hosts, got %d", maxSummaryHosts+20, got)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Second)
+	statsEvent := jobEvent{Event: eventTypePlaybookOnStats, EventData: map[string]any{
+		"changed":   map[string]any{"web2": 1},
+		"failures":  map[string]any{"web1": 1},
+		"ok":        map[string]any{"web1": 3, "web2": 4},
+		"processed": map[string]any{"web1": 1, "web2": 1},
+	}}
+
+	cases := map[string]struct {
+		evts []jobEvent
+		want *v1alpha1.RunSummary
+	}{
+		"NoRecap": {
+			evts: []jobEvent{{Event: "playbook_on_start"}},
+			want: &v1alpha1.RunSummary{
+				ID:        "run",
+				CheckMode: true,
+				StartTime: metav1.NewTime(start),
+				EndTime:   metav1.NewTime(end),
+				Duration:  metav1.Duration{Duration: 90 * time.Second},
+			},
+		},
+		"Recap": {
+			evts: []jobEvent{{Event: "playbook_on_start"}, statsEvent},
+			want: &v1alpha1.RunSummary{
+				ID:        "run",
+				CheckMode: true,
+				StartTime: metav1.NewTime(start),
+				EndTime:   metav1.NewTime(end),
+				Duration:  metav1.Duration{Duration: 90 * time.Second},
+				Hosts: []v1alpha1.HostStats{
+					{Host: "web1", Ok: 3, Failed: 1},
+					{Host: "web2", Ok: 4, Changed: 1},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, runSummary("run", true, start, end, tc.evts)); diff != "" {
+				t.Errorf("Unexpected run summary (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteExtraVar(t *testing.T) {
+	cases := map[string]struct {
+		vars          string
+		defaults      string
+		varsFrom      map[string]string
+		fromSecrets   bool
+		sensitive     bool
+		meta          map[string]interface{}
+		state         string
+		stateVar      string
+		legacy        bool
+		wantErr       bool
+		wantExtraVars string
+		wantSettings  string
+	}{
+		"NoVars": {
+			wantExtraVars: extraVarsHeader + "{}\n",
+		},
+		"UserVarsAndProviderMeta": {
+			vars: `{"count": 1000000, "name": "test"}`,
+			meta: map[string]interface{}{"testApp": map[string]string{"state": "present"}},
+			wantExtraVars: extraVarsHeader + `ansible_provider_meta:
+  testApp:
+    state: present
+count: 1000000
+name: test
+`,
+		},
+		"ReservedVar": {
+			vars:    `{"ansible_provider_meta": {}}`,
+			wantErr: true,
+		},
+		"ReservedStateVar": {
+			vars:    `{"crossplane_state": "present"}`,
+			wantErr: true,
+		},
+		"DefaultVars": {
+			vars:          `{"count": 1, "tags": {"team": "a"}}`,
+			defaults:      `{"count": 2, "region": "eu", "tags": {"env": "prod"}}`,
+			wantExtraVars: extraVarsHeader + "count: 1\nregion: eu\ntags:\n  team: a\n",
+		},
+		"ReservedDefaultVar": {
+			defaults: `{"crossplane_state": "present"}`,
+			wantErr:  true,
+		},
+		"DefaultStateVar": {
+			vars:          `{"name": "test"}`,
+			state:         "present",
+			wantExtraVars: extraVarsHeader + "crossplane_state: present\nname: test\n",
+		},
+		"CustomStateVar": {
+			state:         "absent",
+			stateVar:      "bucket_state",
+			wantExtraVars: extraVarsHeader + "bucket_state: absent\n",
+		},
+		"LegacyProviderMeta": {
+			state:  "present",
+			legacy: true,
+			wantExtraVars: extraVarsHeader + `ansible_provider_meta:
+  testApp:
+    state: present
+crossplane_state: present
+`,
+		},
+		"VarsFrom": {
+			vars:          `{"count": 1}`,
+			defaults:      `{"count": 2, "region": "eu", "token": "default"}`,
+			varsFrom:      map[string]string{"count": "3", "token": "rotated"},
+			wantExtraVars: extraVarsHeader + "count: 1\nregion: eu\ntoken: rotated\n",
+		},
+		"VarsFromSecrets": {
+			varsFrom:      map[string]string{"password": "secret"},
+			fromSecrets:   true,
+			wantExtraVars: extraVarsHeader + "password: secret\n",
+			wantSettings:  "suppress_env_files: true\n",
+		},
+		"ReservedVarFrom": {
+			varsFrom: map[string]string{"crossplane_state": "present"},
+			wantErr:  true,
+		},
+		"SensitiveVars": {
+			vars:          `{"password": "secret"}`,
+			sensitive:     true,
+			wantExtraVars: extraVarsHeader + "password: secret\n",
+			wantSettings:  "suppress_env_files: true\n",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			fakePlaybook := "fake playbook"
+			run := &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "testApp"},
+				Spec: v1alpha1.AnsibleRunSpec{
+					ForProvider: v1alpha1.AnsibleRunParameters{
+						PlaybookInline:     &fakePlaybook,
+						SensitiveVars:      tc.sensitive,
+						StateVar:           tc.stateVar,
+						LegacyProviderMeta: tc.legacy,
+					},
+				},
+			}
+			if tc.vars != "" {
+				run.Spec.ForProvider.Vars.Raw = []byte(tc.vars)
+			}
+
+			runner, err := Parameters{WorkingDirPath: dir}.Init(context.Background(), run, nil)
+			if err == nil && tc.defaults != "" {
+				err = runner.SetDefaultVars(runtime.RawExtension{Raw: []byte(tc.defaults)})
+			}
+			if err == nil {
+				err = runner.SetVarsFrom(tc.varsFrom, tc.fromSecrets)
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Expected Init() error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected Init() error: %v", err)
+			}
+			if tc.meta != nil {
+				if err := runner.WriteExtraVar(tc.meta); err != nil {
+					t.Fatalf("Unexpected WriteExtraVar() error: %v", err)
+				}
+			}
+			if tc.state != "" {
+				if err := runner.WriteState(tc.state); err != nil {
+					t.Fatalf("Unexpected WriteState() error: %v", err)
+				}
+			}
+
+			extraVars, err := os.ReadFile(filepath.Join(dir, "env", "extravars"))
+			if err != nil {
+				t.Fatalf("Unexpected error reading env/extravars: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantExtraVars, string(extraVars)); diff != "" {
+				t.Errorf("Unexpected env/extravars -want, +got:\n%s\n", diff)
+			}
+
+			settings, err := os.ReadFile(filepath.Join(dir, "env", "settings"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatalf("Unexpected error reading env/settings: %v", err)
+			}
+			if string(settings) != tc.wantSettings {
+				t.Errorf("Unexpected env/settings %q, want %q", string(settings), tc.wantSettings)
+			}
+		})
+	}
+}
+
+func TestGalaxyInstall(t *testing.T) {
+	cases := map[string]struct {
+		script  string
+		opts    GalaxyOptions
+		timeout time.Duration
+		cancel  bool
+		wantErr bool
+		timeOut bool
+	}{
+		"Successful": {
+			script: "echo installed",
+		},
+		"Options": {
+			// fails unless given the flags of the options
+			script: `case "$*" in *"--force --ignore-certs --no-deps"*) echo installed ;; *) exit 1 ;; esac`,
+			opts:   GalaxyOptions{Force: true, IgnoreCerts: true, NoDeps: true},
+		},
+		"Cache": {
+			// fails unless given the requirements and the collections path
+			// of the cache
+			script: `case "$*" in *"--requirements-file /cache/requirements.yml --collections-path /cache/collections"*) echo installed ;; *) exit 1 ;; esac`,
+			opts:   GalaxyOptions{RequirementsDir: "/cache", CollectionsPath: "/cache/collections"},
+		},
+		"Server": {
+			// fails unless given the server and its token, outside of the
+			// command line
+			script: `case "$*" in *"--server provider_config"*) ;; *) exit 1 ;; esac
+[ "$ANSIBLE_GALAXY_SERVER_LIST" = provider_config ] || exit 1
+[ "$ANSIBLE_GALAXY_SERVER_PROVIDER_CONFIG_URL" = https://hub.example.com/api/galaxy/ ] || exit 1
+[ "$ANSIBLE_GALAXY_SERVER_PROVIDER_CONFIG_TOKEN" = secret ] || exit 1
+case "$*" in *secret*) exit 1 ;; esac
+echo installed`,
+			opts: GalaxyOptions{Server: "https://hub.example.com/api/galaxy/", ServerToken: "secret"},
+		},
+		"Failed": {
+			script:  "echo 'cannot download' >&2; exit 1",
+			wantErr: true,
+		},
+		"Timeout": {
+			script:  "exec sleep 10",
+			timeout: 100 * time.Millisecond,
+			wantErr: true,
+			timeOut: true,
+		},
+		"Canceled": {
+			script:  "exec sleep 10",
+			cancel:  true,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			galaxyBinary := filepath.Join(t.TempDir(), "ansible-galaxy")
+			if err := os.WriteFile(galaxyBinary, []byte("#!/bin/sh\n"+tc.script+"\n"), 0700); err != nil {
+				t.Fatalf("Writing fake ansible-galaxy: %v", err)
+			}
+			params := Parameters{
+				GalaxyBinary:   galaxyBinary,
+				WorkingDirPath: t.TempDir(),
+				GalaxyTimeout:  tc.timeout,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancel {
+				// the reconcile ends while the install is running
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			start := time.Now()
+			err := params.GalaxyInstall(ctx, nil, "collection", tc.opts)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unexpected GalaxyInstall() error: %v", err)
+			}
+			if got := err != nil && strings.Contains(err.Error(), errGalaxyTimeout); got != tc.timeOut {
+				t.Errorf("Unexpected timeout of GalaxyInstall(): %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("GalaxyInstall() took %s, want it interrupted", elapsed)
+			}
+		})
+	}
+}
+
+func TestGalaxyServerEnv(t *testing.T) {
+	cases := map[string]struct {
+		opts GalaxyOptions
+		vars map[string]string
+		want []string
+	}{
+		"NoServer": {},
+		"Server": {
+			opts: GalaxyOptions{Server: "https://hub.example.com/"},
+			want: []string{"ANSIBLE_GALAXY_SERVER_LIST=provider_config", "ANSIBLE_GALAXY_SERVER_PROVIDER_CONFIG_URL=https://hub.example.com/"},
+		},
+		"ConfiguredServers": {
+			opts: GalaxyOptions{Server: "https://hub.example.com/", ServerToken: "secret"},
+			vars: map[string]string{galaxyServerListEnv: "release_galaxy"},
+			want: []string{
+				"ANSIBLE_GALAXY_SERVER_LIST=release_galaxy,provider_config",
+				"ANSIBLE_GALAXY_SERVER_PROVIDER_CONFIG_URL=https://hub.example.com/",
+				"ANSIBLE_GALAXY_SERVER_PROVIDER_CONFIG_TOKEN=secret",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(galaxyServerListEnv, "")
+			if diff := cmp.Diff(tc.want, tc.opts.serverEnv(tc.vars)); diff != "" {
+				t.Errorf("serverEnv(...): -want, +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCollectionsPath(t *testing.T) {
+	cases := map[string]struct {
+		env  map[string]string
+		vars map[string]string
+		want string
+	}{
+		"Default": {
+			want: "/cache:/home/provider/.ansible/collections:/usr/share/ansible/collections",
+		},
+		"Home": {
+			vars: map[string]string{CollectionsPathEnv: "~/collections:/vars"},
+			want: "/cache:/home/provider/collections:/vars",
+		},
+		"Env": {
+			env:  map[string]string{CollectionsPathEnv: "/env"},
+			want: "/cache:/env",
+		},
+		"Vars": {
+			env:  map[string]string{CollectionsPathEnv: "/env"},
+			vars: map[string]string{AnsibleCollectionsPath: "/legacy"},
+			want: "/cache:/legacy",
+		},
+		"VarsPrecedence": {
+			vars: map[string]string{AnsibleCollectionsPath: "/legacy", CollectionsPathEnv: "/vars"},
+			want: "/cache:/vars",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			// the runs have their own home
+			t.Setenv("HOME", "/home/provider")
+			t.Setenv(AnsibleCollectionsPath, "")
+			t.Setenv(CollectionsPathEnv, "")
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			if diff := cmp.Diff(tc.want, CollectionsPath("/cache", tc.vars)); diff != "" {
+				t.Errorf("CollectionsPath(...): -want, +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInitLibrary(t *testing.T) {
+	dir := t.TempDir()
+	params := Parameters{
+		RunnerBinary:   "fake-runner",
+		WorkingDirPath: dir,
+	}
+	library := &v1alpha1.LibraryPlaybook{Name: "web", Playbook: "playbooks/site.yml"}
+
+	run := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{Library: library}}}
+	runner, err := params.Init(context.Background(), run, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+	expectedCmd := params.playbookCmdFunc(filepath.Join("libraries", "web", "playbooks", "site.yml"))(context.Background(), nil)
+	if cmd := runner.cmdFunc(context.Background(), nil); cmd.String() != expectedCmd.String() {
+		t.Errorf("Unexpected Runner.cmdFunc output %q expected %q", cmd.String(), expectedCmd.String())
+	}
+
+	playbook := "fake playbook"
+	run.Spec.ForProvider.PlaybookInline = &playbook
+	if _, err := params.Init(context.Background(), run, nil); err == nil {
+		t.Errorf("Init(): want an error running a library playbook along with an inline playbook")
+	}
+}
+
+func TestInitPlaybooks(t *testing.T) {
+	dir := t.TempDir()
+	params := Parameters{
+		RunnerBinary:   "fake-runner",
+		WorkingDirPath: dir,
+	}
+	inline := "- hosts: all"
+	playbooks := []v1alpha1.Playbook{{Name: "setup", Inline: &inline}, {Name: "deploy", Path: "site/deploy.yml"}}
+
+	run := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{Playbooks: playbooks}}}
+	runner, err := params.Init(context.Background(), run, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+	expectedCmd := params.playbookCmdFunc("playbooks.yml")(context.Background(), nil)
+	if cmd := runner.cmdFunc(context.Background(), nil); cmd.String() != expectedCmd.String() {
+		t.Errorf("Unexpected Runner.cmdFunc output %q expected %q", cmd.String(), expectedCmd.String())
+	}
+	project := filepath.Join(dir, "project")
+	expectedPaths := []string{filepath.Join(project, "playbooks.yml"), filepath.Join(project, "inline", "setup.yml"), filepath.Join(project, "site", "deploy.yml")}
+	if diff := cmp.Diff(expectedPaths, runner.contentPaths); diff != "" {
+		t.Errorf("Unexpected Runner.contentPaths -want, +got:\n%s", diff)
+	}
+
+	run.Spec.ForProvider.PlaybookInline = &inline
+	if _, err := params.Init(context.Background(), run, nil); err == nil {
+		t.Errorf("Init(): want an error running playbooks along with an inline playbook")
+	}
+}
+
+func TestRunRole(t *testing.T) {
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles")
+
+	// fake ansible-runner printing the stdout callback it was given along with its args
+	runnerBinary := filepath.Join(t.TempDir(), "ansible-runner")
+	if err := os.WriteFile(runnerBinary, []byte("#!/bin/sh\necho \"$ANSIBLE_STDOUT_CALLBACK $*\"\n"), 0700); err != nil {
+		t.Fatalf("Writing fake ansible-runner: %v", err)
+	}
+
+	expectedID := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(expectedID) }
+
+	run := &v1alpha1.AnsibleRun{
+		Spec: v1alpha1.AnsibleRunSpec{
+			ForProvider: v1alpha1.AnsibleRunParameters{
+				Roles: []v1alpha1.Role{{Name: "MyRole"}},
+			},
+		},
+	}
+	params := Parameters{
+		RunnerBinary:          runnerBinary,
+		WorkingDirPath:        dir,
+		RolesPath:             rolesPath,
+		ArtifactsHistoryLimit: 3,
+	}
+
+	// check runs keep the stdout callback selected by the user
+	runner, err := params.Init(context.Background(), run, map[string]string{AnsibleStdoutCallback: "yaml"})
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+	runner.EnableCheckMode(true)
+
+	outBuf, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected Run() error: %v", err)
+	}
+	out, err := io.ReadAll(outBuf)
+	if err != nil {
+		t.Fatalf("Unexpected error reading command buffer: %v", err)
+	}
+
+	expectedOutput := strings.Join([]string{
+		"yaml", "run", dir,
+		"--role", "MyRole",
+		"--roles-path", rolesPath,
+		"--project-dir", filepath.Join(dir, "project"),
+		"--rotate-artifacts", "3",
+		"--ident", expectedID,
+	}, " ") + "\n"
+	if string(out) != expectedOutput {
+		t.Errorf("Unexpected output in the command buffer %q, want %q", string(out), expectedOutput)
+	}
+
+	cmdline, err := os.ReadFile(filepath.Join(dir, "env", "cmdline"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading env/cmdline: %v", err)
+	}
+	if string(cmdline) != "--check" {
+		t.Errorf("Unexpected env/cmdline %q, want %q", string(cmdline), "--check")
+	}
+}
+
+func TestRunRoles(t *testing.T) {
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles")
+
+	// fake ansible-runner printing the roles path it was given along with its args
+	runnerBinary := filepath.Join(t.TempDir(), "ansible-runner")
+	if err := os.WriteFile(runnerBinary, []byte("#!/bin/sh\necho \"$ANSIBLE_ROLES_PATH $*\"\n"), 0700); err != nil {
+		t.Fatalf("Writing fake ansible-runner: %v", err)
+	}
+
+	expectedID := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(expectedID) }
+
+	run := &v1alpha1.AnsibleRun{
+		Spec: v1alpha1.AnsibleRunSpec{
+			ForProvider: v1alpha1.AnsibleRunParameters{
+				Roles: []v1alpha1.Role{
+					{Name: "database", Vars: runtime.RawExtension{Raw: []byte(`{"port": 5432}`)}},
+					{Name: "webserver", Tags: []string{"web"}, Become: true},
+				},
+			},
+		},
+	}
+	params := Parameters{
+		RunnerBinary:          runnerBinary,
+		WorkingDirPath:        dir,
+		RolesPath:             rolesPath,
+		ArtifactsHistoryLimit: 3,
+	}
+
+	runner, err := params.Init(context.Background(), run, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+	runner.EnableCheckMode(true)
+
+	outBuf, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected Run() error: %v", err)
+	}
+	out, err := io.ReadAll(outBuf)
+	if err != nil {
+		t.Fatalf("Unexpected error reading command buffer: %v", err)
+	}
+	expectedOutput := strings.Join([]string{
+		rolesPath, "run", dir,
+		"-p", "roles.yml",
+		"--rotate-artifacts", "3",
+		"--ident", expectedID,
+	}, " ") + "\n"
+	if string(out) != expectedOutput {
+		t.Errorf("Unexpected output in the command buffer %q, want %q", string(out), expectedOutput)
+	}
+
+	playbook, err := os.ReadFile(filepath.Join(dir, "project", "roles.yml"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading the roles playbook: %v", err)
+	}
+	expectedPlaybook := rolesPlaybookHeader + `- hosts: all
+  name: Run the roles of the AnsibleRun
+  roles:
+  - role: database
+    vars:
+      port: 5432
+  - become: true
+    role: webserver
+    tags:
+    - web
+`
+	if diff := cmp.Diff(expectedPlaybook, string(playbook)); diff != "" {
+		t.Errorf("Unexpected roles playbook (-want +got):\n%s", diff)
+	}
+
+	// a single role with options of its own runs from the roles playbook too
+	single := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+		Roles: []v1alpha1.Role{{Name: "webserver", Tags: []string{"web"}}},
+	}}}
+	runner, err = params.Init(context.Background(), single, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+	expectedCmd := params.rolesPlaybookCmdFunc("roles.yml", rolesPath)(context.Background(), nil)
+	if cmd := runner.cmdFunc(context.Background(), nil); cmd.String() != expectedCmd.String() {
+		t.Errorf("Unexpected Runner.cmdFunc output %q expected %q", cmd.String(), expectedCmd.String())
+	}
+
+	// the vars of the roles may not override the ones of the provider
+	run.Spec.ForProvider.Roles[1].Vars = runtime.RawExtension{Raw: []byte(`{"crossplane_state": "absent"}`)}
+	if _, err := params.Init(context.Background(), run, nil); err == nil {
+		t.Errorf("Init() should reject the reserved vars of the roles")
+	}
+}
+
+func TestRunDeleteRoles(t *testing.T) {
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles")
+
+	// fake ansible-runner printing the roles path it was given along with its args
+	runnerBinary := filepath.Join(t.TempDir(), "ansible-runner")
+	if err := os.WriteFile(runnerBinary, []byte("#!/bin/sh\necho \"$ANSIBLE_ROLES_PATH $*\"\n"), 0700); err != nil {
+		t.Fatalf("Writing fake ansible-runner: %v", err)
+	}
+
+	expectedID := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(expectedID) }
+
+	playbook := "fake playbook"
+	run := &v1alpha1.AnsibleRun{
+		Spec: v1alpha1.AnsibleRunSpec{
+			ForProvider: v1alpha1.AnsibleRunParameters{
+				PlaybookInline: &playbook,
+				DeleteRoles:    []v1alpha1.Role{{Name: "decommission"}},
+			},
+		},
+	}
+	params := Parameters{
+		RunnerBinary:          runnerBinary,
+		WorkingDirPath:        dir,
+		RolesPath:             rolesPath,
+		ArtifactsHistoryLimit: 3,
+	}
+
+	runner, err := params.Init(context.Background(), run, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+
+	runner.EnableCheckMode(true)
+
+	// the delete roles only replace the playbook of the run deleting the AnsibleRun
+	for _, deleting := range []bool{false, true} {
+		runner.deleting = deleting
+		outBuf, err := runner.Run(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected Run() error: %v", err)
+		}
+		out, err := io.ReadAll(outBuf)
+		if err != nil {
+			t.Fatalf("Unexpected error reading command buffer: %v", err)
+		}
+		expectedArgs := []string{"", "run", dir, "-p", "playbook.yml"}
+		if deleting {
+			expectedArgs = []string{rolesPath, "run", dir, "-p", "delete-roles.yml"}
+		}
+		expectedOutput := strings.Join(append(expectedArgs, "--rotate-artifacts", "3", "--ident", expectedID), " ") + "\n"
+		if string(out) != expectedOutput {
+			t.Errorf("Unexpected output in the command buffer %q, want %q", string(out), expectedOutput)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "project", "delete-roles.yml")); err != nil {
+		t.Errorf("Unexpected error reading the delete roles playbook: %v", err)
+	}
+
+	// the delete playbook and roles are mutually exclusive
+	run.Spec.ForProvider.DeletePlaybookInline = &playbook
+	if _, err := params.Init(context.Background(), run, nil); err == nil {
+		t.Errorf("Init() should reject a delete playbook along with delete roles")
+	}
+}
+
+func TestRunnerVersionFlags(t *testing.T) {
+	expectedID := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(expectedID) }
+
+	cases := map[string]struct {
+		version      string
+		expectedArgs []string
+	}{
+		"Unknown": {
+			version:      "unknown",
+			expectedArgs: []string{"--project-dir", "PROJECT", "--rotate-artifacts", "3", "--ident", expectedID},
+		},
+		"Runner2": {
+			version:      "2.3.4",
+			expectedArgs: []string{"--project-dir", "PROJECT", "--rotate-artifacts", "3", "--ident", expectedID},
+		},
+		"Runner1WithRotateArtifacts": {
+			version:      "1.4.7",
+			expectedArgs: []string{"--rotate-artifacts", "3", "--ident", expectedID},
+		},
+		"Runner1": {
+			version:      "1.3.4",
+			expectedArgs: []string{"--ident", expectedID},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			rolesPath := filepath.Join(dir, "roles")
+
+			// fake ansible-runner printing its version or its args
+			runnerBinary := filepath.Join(t.TempDir(), "ansible-runner")
+			script := "#!/bin/sh\nif [ \"$1\" = --version ]; then echo " + tc.version + "; exit 0; fi\necho \"$*\"\n"
+			if err := os.WriteFile(runnerBinary, []byte(script), 0700); err != nil {
+				t.Fatalf("Writing fake ansible-runner: %v", err)
+			}
+
+			params := Parameters{
+				RunnerBinary:          runnerBinary,
+				WorkingDirPath:        dir,
+				RolesPath:             rolesPath,
+				ArtifactsHistoryLimit: 3,
+			}
+			if v, err := runnerutil.RunnerVersion(runnerBinary); err == nil {
+				params.RunnerVersion = &v
+			}
+
+			run := &v1alpha1.AnsibleRun{
+				Spec: v1alpha1.AnsibleRunSpec{
+					ForProvider: v1alpha1.AnsibleRunParameters{
+						Roles: []v1alpha1.Role{{Name: "MyRole"}},
+					},
+				},
+			}
+			runner, err := params.Init(context.Background(), run, nil)
+			if err != nil {
+				t.Fatalf("Unexpected Init() error: %v", err)
+			}
+			runner.EnableCheckMode(true)
+
+			outBuf, err := runner.Run(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected Run() error: %v", err)
+			}
+			out, err := io.ReadAll(outBuf)
+			if err != nil {
+				t.Fatalf("Unexpected error reading command buffer: %v", err)
+			}
+
+			args := []string{"run", dir, "--role", "MyRole", "--roles-path", rolesPath}
+			for _, a := range tc.expectedArgs {
+				if a == "PROJECT" {
+					a = filepath.Join(dir, "project")
+				}
+				args = append(args, a)
+			}
+			if diff := cmp.Diff(strings.Join(args, " ")+"\n", string(out)); diff != "" {
+				t.Errorf("Unexpected args -want, +got:\n%s\n", diff)
+			}
+		})
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	expectedID := "217b3830-68fa-461b-90d1-1fb87c685010"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(expectedID) }
+	// the results are read from the job events, whatever the stdout of the run
+	statsEvent := `{"event": "playbook_on_stats", "counter": 1, "event_data": {"changed": {"localhost": 1}, "ok": {"localhost": 1}, "processed": {"localhost": 1}}}`
+
+	cases := map[string]struct {
+		run             func(ctx context.Context, r *Runner) error
+		expectedState   string
+		expectedCmdline string
+		expectedCreate  bool
+		expectedDelete  bool
+	}{
+		"Check": {
+			run: func(ctx context.Context, r *Runner) error {
+				res, err := r.Check(ctx)
+				if err != nil {
+					return err
+				}
+				if Diff(res) == nil {
+					return fmt.Errorf("expected Check() results to report changes")
+				}
+				return nil
+			},
+			expectedState:   StatePresent,
+			expectedCmdline: "--check",
+		},
+		"Apply": {
+			run:           func(ctx context.Context, r *Runner) error { return r.Apply(ctx) },
+			expectedState: StatePresent,
+		},
+		"Create": {
+			run:             func(ctx context.Context, r *Runner) error { return r.Create(ctx) },
+			expectedState:   StatePresent,
+			expectedCmdline: "--tags all,bootstrap",
+			expectedCreate:  true,
+		},
+		"Destroy": {
+			run:            func(ctx context.Context, r *Runner) error { return r.Destroy(ctx) },
+			expectedState:  StateAbsent,
+			expectedDelete: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			runner := &Runner{
+				workDir: dir,
+				cmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
+					// the runner args appended by Run are ignored by sh -c
+					eventsDir := filepath.Join(dir, "artifacts", expectedID, "job_events")
+					return exec.CommandContext(ctx, "sh", "-c", "echo 'human-friendly output' && mkdir -p "+eventsDir+" && echo '"+statsEvent+"' > "+filepath.Join(eventsDir, "1-stats.json"))
+				},
+				createCmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
+					return exec.CommandContext(ctx, "sh", "-c", "touch "+filepath.Join(dir, "created"))
+				},
+				createTags: []string{"bootstrap"},
+				deleteCmdFunc: func(ctx context.Context, _ map[string]string) *exec.Cmd {
+					return exec.CommandContext(ctx, "sh", "-c", "touch "+filepath.Join(dir, "deleted"))
+				},
+			}
+			if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+				t.Fatalf("Creating env dir: %v", err)
+			}
+			// a previous check run must not leak into the next one
+			if err := os.WriteFile(filepath.Join(dir, "env", "cmdline"), []byte("--check"), 0600); err != nil {
+				t.Fatalf("Writing env/cmdline: %v", err)
+			}
+
+			if err := tc.run(context.Background(), runner); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			extraVars, err := os.ReadFile(filepath.Join(dir, "env", "extravars"))
+			if err != nil {
+				t.Fatalf("Unexpected error reading env/extravars: %v", err)
+			}
+			expectedExtraVars := extraVarsHeader + "crossplane_state: " + tc.expectedState + "\n"
+			if diff := cmp.Diff(expectedExtraVars, string(extraVars)); diff != "" {
+				t.Errorf("Unexpected env/extravars -want, +got:\n%s\n", diff)
+			}
+
+			cmdline, err := os.ReadFile(filepath.Join(dir, "env", "cmdline"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatalf("Unexpected error reading env/cmdline: %v", err)
+			}
+			if string(cmdline) != tc.expectedCmdline {
+				t.Errorf("Unexpected env/cmdline %q, want %q", string(cmdline), tc.expectedCmdline)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "created")); os.IsNotExist(err) == tc.expectedCreate {
+				t.Errorf("Unexpected command, want the create command %t", tc.expectedCreate)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "deleted")); os.IsNotExist(err) == tc.expectedDelete {
+				t.Errorf("Unexpected command, want the delete command %t", tc.expectedDelete)
+			}
+		})
+	}
+}
+
+func TestLifecycleTimeouts(t *testing.T) {
+	timeout := &metav1.Duration{Duration: 50 * time.Millisecond}
+
+	cases := map[string]struct {
+		timeout     time.Duration
+		timeouts    *v1alpha1.Timeouts
+		run         func(ctx context.Context, r *Runner) error
+		wantTimeout bool
+	}{
+		"RunTimeout": {
+			timeout:     timeout.Duration,
+			run:         func(ctx context.Context, r *Runner) error { return r.Apply(ctx) },
+			wantTimeout: true,
+		},
+		"StepTimeoutOverridesRunTimeout": {
+			timeout:  timeout.Duration,
+			timeouts: &v1alpha1.Timeouts{Destroy: &metav1.Duration{Duration: time.Minute}},
+			run:      func(ctx context.Context, r *Runner) error { return r.Destroy(ctx) },
+		},
+		"CheckTimeout": {
+			timeouts: &v1alpha1.Timeouts{Check: timeout},
+			run: func(ctx context.Context, r *Runner) error {
+				_, err := r.Check(ctx)
+				return err
+			},
+			wantTimeout: true,
+		},
+		"ApplyTimeout": {
+			timeouts:    &v1alpha1.Timeouts{Apply: timeout},
+			run:         func(ctx context.Context, r *Runner) error { return r.Apply(ctx) },
+			wantTimeout: true,
+		},
+		"DestroyTimeout": {
+			timeouts:    &v1alpha1.Timeouts{Destroy: timeout},
+			run:         func(ctx context.Context, r *Runner) error { return r.Destroy(ctx) },
+			wantTimeout: true,
+		},
+		"OtherStepTimeout": {
+			timeouts: &v1alpha1.Timeouts{Check: timeout, Destroy: timeout},
+			run:      func(ctx context.Context, r *Runner) error { return r.Apply(ctx) },
+		},
+		"NoTimeouts": {
+			run: func(ctx context.Context, r *Runner) error { return r.Destroy(ctx) },
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+				t.Fatalf("Creating env dir: %v", err)
+			}
+			runner := new(withWorkDir(dir), withRunTimeout(tc.timeout), withTimeouts(tc.timeouts), withCmdFunc(func(ctx context.Context, _ map[string]string) *exec.Cmd {
+				return exec.CommandContext(ctx, "sh", "-c", "exec sleep 0.3", "sh")
+			}))
+
+			err := tc.run(context.Background(), runner)
+			var runErr *RunError
+			if gotTimeout := errors.As(err, &runErr) && runErr.Kind == FailureTimeout; gotTimeout != tc.wantTimeout {
+				t.Errorf("Unexpected error %v, want timeout %t", err, tc.wantTimeout)
+			}
+			if !tc.wantTimeout && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
 		})
 	}
 }
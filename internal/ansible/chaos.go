@@ -0,0 +1,238 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errParseChaos  = "cannot parse the faults to inject"
+	errChaosRun    = "cannot write the artifacts of the run failed by the injected fault"
+	errChaosFailed = "run failed by an injected fault"
+
+	// chaosTask is the task failed by the injected faults
+	chaosTask = "Failed by an injected fault"
+
+	// exit codes of ansible-playbook for the failed tasks and unreachable
+	// hosts
+	exitCodeFailed      = 2
+	exitCodeUnreachable = 4
+)
+
+// A Chaos injects faults into the runs, so that the Compositions and the
+// alerts relying on AnsibleRuns can be tested against the failures of
+// Ansible without breaking real hosts. It wraps any executor, including the
+// Fake one, and is meant for development and test environments only.
+type Chaos struct {
+	// Delay is added to every run before it starts, as if it were slow.
+	Delay time.Duration
+	// Failure is the share of the runs that fail a task on one of their
+	// hosts, the other hosts succeeding.
+	Failure float64
+	// Unreachable is the share of the runs that can't reach one of their
+	// hosts, the other hosts succeeding.
+	Unreachable float64
+	// Timeout is the share of the runs that hang until their timeout. The
+	// runs without a timeout never hang.
+	Timeout float64
+	// Runs is the pattern of the names of the AnsibleRuns whose runs are
+	// injected faults, all of them when empty.
+	Runs string
+
+	// random returns a number in [0, 1), rand.Float64 when nil.
+	random func() float64
+}
+
+// ParseChaos returns the Chaos injecting the faults of the supplied comma
+// separated key=value pairs, e.g. delay=30s,failure=0.2,runs=web-*, or nil
+// when they are empty. The keys are delay, failure, unreachable, timeout and
+// runs.
+func ParseChaos(s string) (*Chaos, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	c := &Chaos{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: want key=value, got %q", errParseChaos, kv)
+		}
+		var err error
+		switch k {
+		case "delay":
+			c.Delay, err = time.ParseDuration(v)
+		case "failure":
+			c.Failure, err = parseShare(v)
+		case "unreachable":
+			c.Unreachable, err = parseShare(v)
+		case "timeout":
+			c.Timeout, err = parseShare(v)
+		case "runs":
+			_, err = path.Match(v, "")
+			c.Runs = v
+		default:
+			err = fmt.Errorf("unknown key %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", errParseChaos, k, err)
+		}
+	}
+	if c.Failure+c.Unreachable+c.Timeout > 1 {
+		return nil, fmt.Errorf("%s: the shares of the runs failing add up to more than 1", errParseChaos)
+	}
+	return c, nil
+}
+
+// parseShare parses a share of the runs, between 0 and 1.
+func parseShare(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("want a share between 0 and 1, got %v", f)
+	}
+	return f, nil
+}
+
+// withChaos injects the faults of the supplied Chaos into the runs, none
+// when nil.
+func withChaos(c *Chaos) runnerOption {
+	return func(r *Runner) {
+		r.chaos = c
+	}
+}
+
+// A chaosError is the failure of a run injected by a Chaos, exiting with the
+// exit code of the fault.
+type chaosError struct {
+	code int
+}
+
+func (e *chaosError) Error() string {
+	return fmt.Sprintf("%s: exit code %d", errChaosFailed, e.code)
+}
+
+// ExitCode returns the exit code of the fault, like the one of an
+// exec.ExitError, so that the failures are classified alike.
+func (e *chaosError) ExitCode() int {
+	return e.code
+}
+
+// start starts the supplied ansible-runner command of the run with the
+// supplied ident with the supplied func, after the delay of the Chaos, or
+// fails the run with one of its faults instead.
+func (c *Chaos) start(ctx context.Context, r *Runner, dc *exec.Cmd, id string, start func() (func() error, error)) (func() error, error) {
+	if c.Runs != "" {
+		if ok, _ := path.Match(c.Runs, r.name); !ok {
+			return start()
+		}
+	}
+	random := c.random
+	if random == nil {
+		random = rand.Float64 //nolint:gosec // faults are no secrets
+	}
+	roll := random()
+	_, hasDeadline := ctx.Deadline()
+	return func() error {
+		// the delay shows in the progress of the run, as it started
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Delay):
+		}
+		switch {
+		case roll < c.Failure:
+			return c.fail(ctx, r, dc, id, eventTypeRunnerFailed, exitCodeFailed, random())
+		case roll < c.Failure+c.Unreachable:
+			return c.fail(ctx, r, dc, id, eventTypeRunnerUnreachable, exitCodeUnreachable, random())
+		case roll < c.Failure+c.Unreachable+c.Timeout && hasDeadline:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		wait, err := start()
+		if err != nil {
+			return err
+		}
+		return wait()
+	}, nil
+}
+
+// fail writes the artifacts of the run with the supplied ident failed by the
+// supplied event on one of its hosts, picked by the supplied number in
+// [0, 1), the others succeeding, and returns the error exiting with the
+// supplied exit code.
+func (c *Chaos) fail(ctx context.Context, r *Runner, dc *exec.Cmd, id, event string, code int, pick float64) error {
+	hosts, err := r.Hosts(ctx)
+	if err != nil || len(hosts) == 0 {
+		hosts = []string{fakeHost}
+	}
+	failed := hosts[int(pick*float64(len(hosts)))%len(hosts)]
+	play := r.name
+	evts := []jobEvent{{
+		Event:     eventTypeTaskStart,
+		Stdout:    fmt.Sprintf("TASK [%s] ***", chaosTask),
+		EventData: map[string]any{"play": play, "task": chaosTask},
+	}}
+	stats := map[string]map[string]int{"ok": {}, "processed": {}, "failures": {}, "dark": {}}
+	for _, h := range hosts {
+		stats["processed"][h] = 1
+		if h != failed {
+			stats["ok"][h] = 1
+			evts = append(evts, jobEvent{
+				Event:     eventTypeRunnerOk,
+				Stdout:    fmt.Sprintf("ok: [%s]", h),
+				EventData: map[string]any{"play": play, "task": chaosTask, "host": h, "res": map[string]any{"changed": false}},
+			})
+			continue
+		}
+		stdout := fmt.Sprintf("fatal: [%s]: FAILED!", h)
+		stats["failures"][h] = 1
+		if event == eventTypeRunnerUnreachable {
+			stdout = fmt.Sprintf("fatal: [%s]: UNREACHABLE!", h)
+			delete(stats["failures"], h)
+			stats["dark"][h] = 1
+		}
+		evts = append(evts, jobEvent{
+			Event:  event,
+			Stdout: stdout,
+			EventData: map[string]any{
+				"play": play,
+				"task": chaosTask,
+				"host": h,
+				"res":  map[string]any{"msg": fmt.Sprintf("%s: %s", chaosTask, strings.Join(dc.Args, " "))},
+			},
+		})
+	}
+	evts = append(evts, jobEvent{
+		Event:     eventTypePlaybookOnStats,
+		Stdout:    "PLAY RECAP ***",
+		EventData: map[string]any{"ok": stats["ok"], "processed": stats["processed"], "failures": stats["failures"], "dark": stats["dark"]},
+	})
+	if err := writeArtifacts(r.artifactsPath(id), evts, StatusFailed, code, dc.Stdout); err != nil {
+		return fmt.Errorf("%s: %w", errChaosRun, err)
+	}
+	return &chaosError{code: code}
+}
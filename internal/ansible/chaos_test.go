@@ -0,0 +1,155 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestParseChaos(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		s      string
+		want   *Chaos
+		err    bool
+	}{
+		"Disabled": {
+			reason: "We should inject no faults without any",
+		},
+		"Faults": {
+			reason: "We should parse all the faults",
+			s:      "delay=30s, failure=0.2,unreachable=0.1,timeout=0.05,runs=web-*",
+			want:   &Chaos{Delay: 30 * time.Second, Failure: 0.2, Unreachable: 0.1, Timeout: 0.05, Runs: "web-*"},
+		},
+		"UnknownKey": {
+			reason: "We should reject the unknown faults",
+			s:      "crash=0.1",
+			err:    true,
+		},
+		"ShareOutOfRange": {
+			reason: "We should reject the shares out of [0, 1]",
+			s:      "failure=1.5",
+			err:    true,
+		},
+		"SharesTooLarge": {
+			reason: "We should reject the shares of failing runs adding up to more than 1",
+			s:      "failure=0.6,timeout=0.6",
+			err:    true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseChaos(tc.s)
+			if gotErr := err != nil; gotErr != tc.err {
+				t.Fatalf("\n%s\nParseChaos(...): want error %t, got %v", tc.reason, tc.err, err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreUnexported(Chaos{})); diff != "" {
+				t.Errorf("\n%s\nParseChaos(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestChaos(t *testing.T) {
+	playbook := "- hosts: all\n  tasks: []\n"
+	cases := map[string]struct {
+		reason    string
+		chaos     Chaos
+		timeout   time.Duration
+		wantKind  FailureKind
+		wantHosts []v1alpha1.HostStats
+	}{
+		"Failure": {
+			reason:    "We should fail a task of the run",
+			chaos:     Chaos{Failure: 0.5},
+			wantKind:  FailureTask,
+			wantHosts: []v1alpha1.HostStats{{Host: fakeHost, Failed: 1}},
+		},
+		"Unreachable": {
+			reason:    "We should make a host of the run unreachable",
+			chaos:     Chaos{Failure: 0.1, Unreachable: 0.5},
+			wantKind:  FailureUnreachable,
+			wantHosts: []v1alpha1.HostStats{{Host: fakeHost, Unreachable: 1}},
+		},
+		"Timeout": {
+			reason:   "We should hang the run until its timeout",
+			chaos:    Chaos{Timeout: 0.5},
+			timeout:  100 * time.Millisecond,
+			wantKind: FailureTimeout,
+		},
+		"TimeoutWithoutDeadline": {
+			reason:    "We should not hang the runs without timeout",
+			chaos:     Chaos{Timeout: 0.5},
+			wantHosts: []v1alpha1.HostStats{{Host: fakeHost, Ok: 1}},
+		},
+		"OtherRuns": {
+			reason:    "We should not inject faults into the runs of the AnsibleRuns not matching",
+			chaos:     Chaos{Failure: 1, Runs: "db-*"},
+			wantHosts: []v1alpha1.HostStats{{Host: fakeHost, Ok: 1}},
+		},
+		"Delay": {
+			reason:    "We should delay the runs without failing them",
+			chaos:     Chaos{Delay: 10 * time.Millisecond, Failure: 0.1},
+			wantHosts: []v1alpha1.HostStats{{Host: fakeHost, Ok: 1}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			run := &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1"},
+				Spec:       v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{PlaybookInline: &playbook}},
+			}
+			chaos := tc.chaos
+			chaos.random = func() float64 { return 0.3 }
+			params := Parameters{WorkingDirPath: dir, RunnerBinary: filepath.Join(dir, "ansible-runner"), Fake: true, Chaos: &chaos, Timeout: tc.timeout}
+			runner, err := params.Init(context.Background(), run, nil)
+			if err != nil {
+				t.Fatalf("Unexpected Init() error: %v", err)
+			}
+
+			start := time.Now()
+			err = runner.Apply(context.Background())
+			var runErr *RunError
+			switch {
+			case tc.wantKind == "" && err != nil:
+				t.Fatalf("\n%s\nApply(): unexpected error: %v", tc.reason, err)
+			case tc.wantKind != "" && (!errors.As(err, &runErr) || runErr.Kind != tc.wantKind):
+				t.Fatalf("\n%s\nApply(): want a %s failure, got %v", tc.reason, tc.wantKind, err)
+			}
+			if elapsed := time.Since(start); elapsed < tc.chaos.Delay {
+				t.Errorf("\n%s\nApply(): want the run delayed by %s, took %s", tc.reason, tc.chaos.Delay, elapsed)
+			}
+			if tc.wantHosts == nil {
+				return
+			}
+			if diff := cmp.Diff(tc.wantHosts, runner.LastRun().Hosts); diff != "" {
+				t.Errorf("\n%s\nLastRun(): -want hosts, +got hosts:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+const errDeniedCmdlineArg = "denied extra cmdline argument, the provider manages it"
+
+// deniedCmdlineArgs are the ansible-playbook arguments the provider manages,
+// or that would prompt or keep the contents from running, which the extra
+// cmdline arguments of an AnsibleRun cannot pass.
+var deniedCmdlineArgs = map[string]bool{
+	"-i": true, "--inventory": true, "--inventory-file": true,
+	"-C": true, "--check": true, "--syntax-check": true,
+	"--list-hosts": true, "--list-tasks": true, "--list-tags": true,
+	"-e": true, "--extra-vars": true,
+	"--vault-id": true, "--vault-password-file": true, "--vault-pass-file": true,
+	"-J": true, "--ask-vault-password": true, "--ask-vault-pass": true,
+	"--private-key": true, "--key-file": true,
+	"-k": true, "--ask-pass": true, "-K": true, "--ask-become-pass": true,
+	"--step": true,
+}
+
+// cmdlineOptions are the ansible-playbook arguments an AnsibleRun passes to
+// its runs.
+type cmdlineOptions struct {
+	tags      []string
+	skipTags  []string
+	limit     string
+	verbosity int
+	extraArgs []string
+}
+
+// withCmdline sets the ansible-playbook arguments the AnsibleRun passes to its
+// runs.
+func withCmdline(o cmdlineOptions) runnerOption {
+	return func(r *Runner) {
+		r.cmdlineOpts = o
+	}
+}
+
+// cmdlineOptionsOf returns the ansible-playbook arguments the supplied
+// AnsibleRun passes to its runs, or an error when its extra arguments pass
+// one the provider manages.
+func cmdlineOptionsOf(cr *v1alpha1.AnsibleRun) (cmdlineOptions, error) {
+	p := cr.Spec.ForProvider
+	for _, arg := range p.ExtraCmdlineArgs {
+		flag, _, _ := strings.Cut(arg, "=")
+		if deniedCmdlineArgs[flag] {
+			return cmdlineOptions{}, fmt.Errorf("%s: %q", errDeniedCmdlineArg, arg)
+		}
+	}
+	return cmdlineOptions{
+		tags:      p.Tags,
+		skipTags:  p.SkipTags,
+		limit:     p.Limit,
+		verbosity: p.Verbosity,
+		extraArgs: p.ExtraCmdlineArgs,
+	}, nil
+}
+
+// args returns the ansible-playbook arguments of the options, the tags of the
+// first run being added to the tags of the options when creating.
+func (o cmdlineOptions) args(creating bool, createTags []string) []string {
+	var args []string
+	tags := o.tags
+	if creating && len(createTags) != 0 {
+		if len(tags) == 0 {
+			// "all" keeps running the untagged tasks and the other tagged ones
+			tags = []string{"all"}
+		}
+		tags = append(append([]string(nil), tags...), createTags...)
+	}
+	if len(tags) != 0 {
+		args = append(args, "--tags", strings.Join(tags, ","))
+	}
+	if len(o.skipTags) != 0 {
+		args = append(args, "--skip-tags", strings.Join(o.skipTags, ","))
+	}
+	if o.limit != "" {
+		args = append(args, "--limit", o.limit)
+	}
+	if o.verbosity > 0 {
+		args = append(args, "-"+strings.Repeat("v", min(o.verbosity, 4)))
+	}
+	return append(args, o.extraArgs...)
+}
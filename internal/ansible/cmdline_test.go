@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestCmdlineArgs(t *testing.T) {
+	opts := cmdlineOptions{
+		tags:      []string{"web"},
+		skipTags:  []string{"slow", "reboot"},
+		limit:     "web:!web3",
+		verbosity: 2,
+		extraArgs: []string{"--forks", "20"},
+	}
+
+	cases := map[string]struct {
+		reason     string
+		opts       cmdlineOptions
+		creating   bool
+		createTags []string
+		want       []string
+	}{
+		"NoArgs": {
+			reason: "We should pass no arguments without options",
+		},
+		"Options": {
+			reason: "We should pass the options of the AnsibleRun",
+			opts:   opts,
+			want:   []string{"--tags", "web", "--skip-tags", "slow,reboot", "--limit", "web:!web3", "-vv", "--forks", "20"},
+		},
+		"CreateTags": {
+			reason:     "We should add the create tags to all the tags on the first run",
+			creating:   true,
+			createTags: []string{"bootstrap"},
+			want:       []string{"--tags", "all,bootstrap"},
+		},
+		"CreateTagsWithTags": {
+			reason:     "We should add the create tags to the tags of the AnsibleRun on the first run",
+			opts:       cmdlineOptions{tags: []string{"web"}},
+			creating:   true,
+			createTags: []string{"bootstrap"},
+			want:       []string{"--tags", "web,bootstrap"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.opts.args(tc.creating, tc.createTags)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nargs(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestCmdlineOptionsOf(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		args    []string
+		wantErr bool
+	}{
+		"Allowed": {
+			reason: "We should pass the arguments the provider doesn't manage",
+			args:   []string{"--forks", "20", "--diff"},
+		},
+		"Denied": {
+			reason:  "We should deny the arguments the provider manages",
+			args:    []string{"--inventory", "/etc/hosts"},
+			wantErr: true,
+		},
+		"DeniedWithValue": {
+			reason:  "We should deny the arguments the provider manages along with their value",
+			args:    []string{"--extra-vars=@/etc/passwd"},
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{ExtraCmdlineArgs: tc.args}}}
+			_, err := cmdlineOptionsOf(cr)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("\n%s\ncmdlineOptionsOf(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+		})
+	}
+}
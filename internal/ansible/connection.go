@@ -0,0 +1,121 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/fieldpath"
+	"github.com/crossplane/crossplane-runtime/pkg/reconciler/managed"
+)
+
+const (
+	errConnectionDetailSource = "connection detail must have exactly one source"
+	errConnectionDetailValue  = "cannot find the value of connection detail"
+)
+
+// withConnectionDetails sets the connection details to read from the runs.
+func withConnectionDetails(details []v1alpha1.ConnectionDetail) runnerOption {
+	return func(r *Runner) {
+		r.connectionDetails = details
+	}
+}
+
+// ConnectionDetails returns the connection details found in the job events of
+// the last run, along with an error naming the ones that couldn't be found.
+func (r *Runner) ConnectionDetails() (managed.ConnectionDetails, error) {
+	if len(r.connectionDetails) == 0 {
+		return nil, nil
+	}
+	stats := customStats(r.lastEvents)
+	cd := make(managed.ConnectionDetails, len(r.connectionDetails))
+	var errs []error
+	for _, d := range r.connectionDetails {
+		v, err := connectionDetailValue(d, stats, r.lastEvents)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", d.Name, err))
+			continue
+		}
+		cd[d.Name] = v
+	}
+	return cd, errors.Join(errs...)
+}
+
+// connectionDetailValue returns the value of the supplied connection detail,
+// from the custom stats or the task results of the events.
+func connectionDetailValue(d v1alpha1.ConnectionDetail, stats map[string]any, evts []jobEvent) ([]byte, error) {
+	var (
+		v   any
+		err error
+	)
+	switch {
+	case d.FromStat != nil && d.FromTaskResult == nil:
+		v, err = fieldpath.Pave(stats).GetValue(*d.FromStat)
+	case d.FromTaskResult != nil && d.FromStat == nil:
+		res := taskResult(evts, d.FromTaskResult.Task, d.FromTaskResult.Host)
+		if res == nil {
+			return nil, fmt.Errorf("%s: no result of task %q", errConnectionDetailValue, d.FromTaskResult.Task)
+		}
+		v, err = fieldpath.Pave(res).GetValue(d.FromTaskResult.FieldPath)
+	default:
+		return nil, errors.New(errConnectionDetailSource)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errConnectionDetailValue, err)
+	}
+	// strings are published as is, other values as json
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(v)
+}
+
+// customStats returns the data set by the set_stats module during the run,
+// which ansible-runner reports in the playbook_on_stats event.
+func customStats(evts []jobEvent) map[string]any {
+	for i := len(evts) - 1; i >= 0; i-- {
+		if evts[i].Event != eventTypePlaybookOnStats {
+			continue
+		}
+		if data, ok := evts[i].EventData["artifact_data"].(map[string]any); ok {
+			return data
+		}
+		break
+	}
+	return map[string]any{}
+}
+
+// taskResult returns the last result of the named task, on the supplied host
+// if any, or nil when the task didn't run successfully.
+func taskResult(evts []jobEvent, task, host string) map[string]any {
+	for i := len(evts) - 1; i >= 0; i-- {
+		evt := evts[i]
+		if evt.Event != eventTypeRunnerOk || evt.EventData["task"] != task {
+			continue
+		}
+		if host != "" && evt.EventData["host"] != host {
+			continue
+		}
+		if res, ok := evt.EventData["res"].(map[string]any); ok {
+			return res
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/pkg/reconciler/managed"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestConnectionDetails(t *testing.T) {
+	evts := []jobEvent{
+		{Event: eventTypeRunnerOk, EventData: map[string]any{
+			"task": "get token", "host": "web1",
+			"res": map[string]any{"json": map[string]any{"token": "t1"}},
+		}},
+		{Event: eventTypeRunnerOk, EventData: map[string]any{
+			"task": "get token", "host": "web2",
+			"res": map[string]any{"json": map[string]any{"token": "t2"}},
+		}},
+		{Event: eventTypePlaybookOnStats, EventData: map[string]any{
+			"artifact_data": map[string]any{
+				"endpoint": "https://db.example.com",
+				"port":     float64(5432),
+			},
+		}},
+	}
+	stat := func(s string) *string { return &s }
+
+	cases := map[string]struct {
+		details []v1alpha1.ConnectionDetail
+		want    managed.ConnectionDetails
+		wantErr bool
+	}{
+		"None": {},
+		"FromStat": {
+			details: []v1alpha1.ConnectionDetail{
+				{Name: "endpoint", FromStat: stat("endpoint")},
+				{Name: "port", FromStat: stat("port")},
+			},
+			want: managed.ConnectionDetails{
+				"endpoint": []byte("https://db.example.com"),
+				"port":     []byte("5432"),
+			},
+		},
+		"FromTaskResult": {
+			details: []v1alpha1.ConnectionDetail{
+				{Name: "token", FromTaskResult: &v1alpha1.TaskResultSelector{Task: "get token", FieldPath: "json.token"}},
+				{Name: "web1-token", FromTaskResult: &v1alpha1.TaskResultSelector{Task: "get token", Host: "web1", FieldPath: "json.token"}},
+			},
+			want: managed.ConnectionDetails{
+				"token":      []byte("t2"),
+				"web1-token": []byte("t1"),
+			},
+		},
+		"Missing": {
+			details: []v1alpha1.ConnectionDetail{
+				{Name: "endpoint", FromStat: stat("endpoint")},
+				{Name: "password", FromStat: stat("password")},
+				{Name: "token", FromTaskResult: &v1alpha1.TaskResultSelector{Task: "other", FieldPath: "json.token"}},
+				{Name: "both", FromStat: stat("endpoint"), FromTaskResult: &v1alpha1.TaskResultSelector{Task: "get token", FieldPath: "json.token"}},
+			},
+			want: managed.ConnectionDetails{
+				"endpoint": []byte("https://db.example.com"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := &Runner{connectionDetails: tc.details, lastEvents: evts}
+			got, err := r.ConnectionDetails()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unexpected ConnectionDetails() error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected connection details (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
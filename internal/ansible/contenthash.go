@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	errContentHash = "cannot hash contents"
+
+	contentHashPrefix = "sha256:"
+	// maxLabelValueLength is the maximum length of a label value
+	maxLabelValueLength = 63
+)
+
+// ContentHash returns the digest of the contents, requirements and vars of
+// the last run, or an empty string when the last run did not start.
+func (r *Runner) ContentHash() string {
+	return r.lastContentHash
+}
+
+// ContentHashLabelValue returns the supplied content hash as a label value,
+// i.e. its hex digest truncated to the maximum length of a label value.
+func ContentHashLabelValue(hash string) string {
+	v := strings.TrimPrefix(hash, contentHashPrefix)
+	if len(v) > maxLabelValueLength {
+		v = v[:maxLabelValueLength]
+	}
+	return v
+}
+
+// hashContents sets the digest of the files of the contents and the
+// requirements of the runner, along with the vars of the AnsibleRun and the
+// default vars of its ProviderConfig.
+func (r *Runner) hashContents() error {
+	paths := r.contentPaths
+	if len(r.requirementsPaths) != 0 {
+		paths = append(append([]string(nil), paths...), r.requirementsPaths...)
+	}
+	h, err := contentHash(r.userVars(), paths...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errContentHash, err)
+	}
+	r.contentHash = h
+	return nil
+}
+
+// contentHash returns the sha256 digest of the supplied vars and of the files
+// under the supplied paths, files or directories, covering their relative
+// paths and contents. Paths that don't exist are skipped.
+func contentHash(vars map[string]interface{}, paths ...string) (string, error) {
+	h := sha256.New()
+	// maps are marshaled with sorted keys
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "vars\x00%d\x00", len(b))
+	h.Write(b)
+	for _, root := range paths {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil || rel == "." {
+				rel = filepath.Base(path)
+			}
+			b, err := os.ReadFile(filepath.Clean(path))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(b))
+			h.Write(b)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return contentHashPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	dir := t.TempDir()
+	playbook := filepath.Join(dir, "playbook.yml")
+	requirements := filepath.Join(dir, "requirements.yml")
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Writing %s: %v", path, err)
+		}
+	}
+	hash := func(vars map[string]interface{}) string {
+		h, err := contentHash(vars, playbook, requirements)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return h
+	}
+	write(playbook, "- hosts: all\n")
+
+	base := hash(map[string]interface{}{"a": 1, "b": 2})
+	if got := hash(map[string]interface{}{"b": 2, "a": 1}); got != base {
+		t.Errorf("Hash of the same contents changed from %s to %s", base, got)
+	}
+	if got := hash(map[string]interface{}{"a": 1, "b": 3}); got == base {
+		t.Errorf("Hash of changed vars is still %s", base)
+	}
+	write(requirements, "collections: []\n")
+	withRequirements := hash(map[string]interface{}{"a": 1, "b": 2})
+	if withRequirements == base {
+		t.Errorf("Hash with requirements is still %s", base)
+	}
+	write(playbook, "- hosts: web\n")
+	if got := hash(map[string]interface{}{"a": 1, "b": 2}); got == withRequirements {
+		t.Errorf("Hash of changed contents is still %s", withRequirements)
+	}
+}
+
+func TestContentHashLabelValue(t *testing.T) {
+	hash := "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a0"
+	if got := ContentHashLabelValue(hash); got != want {
+		t.Errorf("ContentHashLabelValue(...) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+)
+
+// FailureKind classifies why a run failed. It is used as the reason of the
+// Ready condition of the AnsibleRun.
+type FailureKind string
+
+const (
+	// FailureUnreachable means a host could not be reached, e.g. because of a network blip.
+	FailureUnreachable FailureKind = "HostUnreachable"
+	// FailureAuthentication means the credentials were rejected by a host,
+	// either to connect or to become another user.
+	FailureAuthentication FailureKind = "AuthenticationFailure"
+	// FailureSyntax means the contents or the command line could not be parsed.
+	FailureSyntax FailureKind = "SyntaxError"
+	// FailurePolicyViolation means the contents use modules denied by the
+	// ProviderConfig, so they were not run.
+	FailurePolicyViolation FailureKind = "PolicyViolation"
+	// FailureInventoryInvalid means the inventory could not be parsed, so
+	// the contents were not run.
+	FailureInventoryInvalid FailureKind = "InventoryInvalid"
+	// FailureTimeout means the run took longer than its timeout.
+	FailureTimeout FailureKind = "Timeout"
+	// FailureTask means a task failed on a host.
+	FailureTask FailureKind = "TaskFailure"
+	// FailureUnknown is any other failure.
+	FailureUnknown FailureKind = FailureKind(xpv1.ReasonUnavailable)
+)
+
+// exit codes of ansible-playbook, passed through by ansible-runner
+const (
+	exitCodeParserError  = 4
+	exitCodeOptionsError = 5
+)
+
+var authFailureRegexp = regexp.MustCompile(`(?i)permission denied \(|authentication fail|(incorrect|missing) (sudo |su |become )?password`)
+
+// Retryable tells whether running the contents again may succeed without any
+// change to the AnsibleRun or to its credentials.
+func (k FailureKind) Retryable() bool {
+	switch k {
+	case FailureAuthentication, FailureSyntax, FailurePolicyViolation, FailureInventoryInvalid:
+		return false
+	}
+	// unreachable hosts, timeouts, failed tasks, and any other failure
+	return true
+}
+
+// RunError is returned when ansible-runner exits with an error.
+type RunError struct {
+	// Kind classifies the failure
+	Kind FailureKind
+	// Reason are the failure messages of the job events of the run
+	Reason string
+	// Err is the error of the ansible-runner command
+	Err error
+}
+
+func (e *RunError) Error() string {
+	if e.Reason == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// classifyFailure determines the kind of failure of a run from its job events
+// and the exit code of ansible-runner.
+func classifyFailure(err error, evts []jobEvent) FailureKind {
+	var unreachable, failed bool
+	for _, evt := range evts {
+		switch evt.Event {
+		case eventTypeRunnerUnreachable, eventTypeRunnerFailed:
+		default:
+			continue
+		}
+		var evtData runnerEventData
+		if reunmarshal(evt.EventData, &evtData) != nil || evtData.IgnoreErrors {
+			continue
+		}
+		if authFailureRegexp.MatchString(evtData.Result.Msg) {
+			return FailureAuthentication
+		}
+		if evt.Event == eventTypeRunnerUnreachable {
+			unreachable = true
+		} else {
+			failed = true
+		}
+	}
+
+	switch {
+	case unreachable:
+		return FailureUnreachable
+	case failed:
+		return FailureTask
+	}
+
+	// parser errors are raised before or in between tasks, without any
+	// failed task; unreachable hosts share the same exit code
+	// failed Jobs of the Job executor report the exit code alike
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case exitCodeParserError, exitCodeOptionsError:
+			return FailureSyntax
+		}
+	}
+	return FailureUnknown
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	exitErr := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+	evt := func(event, msg string, ignoreErrors bool) jobEvent {
+		return jobEvent{Event: event, EventData: map[string]any{
+			"task":          "test",
+			"res":           map[string]any{"msg": msg},
+			"ignore_errors": ignoreErrors,
+		}}
+	}
+
+	cases := map[string]struct {
+		err  error
+		evts []jobEvent
+		want FailureKind
+	}{
+		"Unreachable": {
+			err:  exitErr("4"),
+			evts: []jobEvent{evt(eventTypeRunnerUnreachable, "Failed to connect to the host via ssh: Connection timed out", false)},
+			want: FailureUnreachable,
+		},
+		"SSHAuthentication": {
+			err:  exitErr("4"),
+			evts: []jobEvent{evt(eventTypeRunnerUnreachable, "Failed to connect to the host via ssh: user@host: Permission denied (publickey).", false)},
+			want: FailureAuthentication,
+		},
+		"BecomeAuthentication": {
+			err:  exitErr("2"),
+			evts: []jobEvent{evt(eventTypeRunnerFailed, "Incorrect sudo password", false)},
+			want: FailureAuthentication,
+		},
+		"TaskFailure": {
+			err:  exitErr("2"),
+			evts: []jobEvent{evt(eventTypeRunnerFailed, "fake error", false)},
+			want: FailureTask,
+		},
+		"SyntaxError": {
+			err:  exitErr("4"),
+			evts: []jobEvent{evt(eventTypeRunnerFailed, "fake error", true)},
+			want: FailureSyntax,
+		},
+		"OptionsError": {
+			err:  exitErr("5"),
+			want: FailureSyntax,
+		},
+		"Unknown": {
+			err:  errors.New("boom"),
+			want: FailureUnknown,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := classifyFailure(tc.err, tc.evts); got != tc.want {
+				t.Errorf("classifyFailure(...): want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	errBoom := errors.New("exit status 2")
+
+	withReason := &RunError{Kind: FailureTask, Reason: "fake error", Err: errBoom}
+	if got, want := withReason.Error(), "exit status 2: fake error"; got != want {
+		t.Errorf("Error(): want %q, got %q", want, got)
+	}
+	withoutReason := &RunError{Kind: FailureUnknown, Err: errBoom}
+	if got, want := withoutReason.Error(), "exit status 2"; got != want {
+		t.Errorf("Error(): want %q, got %q", want, got)
+	}
+	if !errors.Is(withReason, errBoom) {
+		t.Errorf("errors.Is(...): want the command error to be wrapped")
+	}
+	if FailureSyntax.Retryable() || FailureAuthentication.Retryable() || !FailureUnreachable.Retryable() || !FailureTask.Retryable() || !FailureUnknown.Retryable() {
+		t.Errorf("Retryable(): only syntax and authentication failures should be terminal")
+	}
+	if FailurePolicyViolation.Retryable() || FailureInventoryInvalid.Retryable() {
+		t.Errorf("Retryable(): contents that were not run because of their policy or inventory should be terminal")
+	}
+}
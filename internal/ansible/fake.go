@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	errFakeRun = "cannot write the artifacts of the simulated run"
+)
+
+const (
+	// fakeHost is the host of the runs simulated by the Fake executor
+	fakeHost = "localhost"
+	// fakeTask is the single task of the runs simulated by the Fake executor
+	fakeTask = "Simulated by the Fake executor"
+)
+
+// withFake simulates the runs instead of running ansible-runner, see
+// ExecutorFake.
+func withFake(fake bool) runnerOption {
+	return func(r *Runner) {
+		r.fake = fake
+	}
+}
+
+// fakeStart simulates the supplied ansible-runner command of the run with the
+// supplied ident: the contents are not run, the artifacts of a successful run
+// of a single task changing nothing on a single host are written instead, the
+// way ansible-runner writes them, so that the run is reported like any other.
+// The returned func doesn't wait for anything.
+func (r *Runner) fakeStart(dc *exec.Cmd, id string) (func() error, error) {
+	dir := r.artifactsPath(id)
+	play := r.name
+	evts := []jobEvent{
+		{
+			Event:     eventTypeTaskStart,
+			Stdout:    fmt.Sprintf("TASK [%s] ***", fakeTask),
+			EventData: map[string]any{"play": play, "task": fakeTask},
+		},
+		{
+			Event:  eventTypeRunnerOk,
+			Stdout: fmt.Sprintf("ok: [%s]", fakeHost),
+			EventData: map[string]any{
+				"play": play,
+				"task": fakeTask,
+				"host": fakeHost,
+				"res": map[string]any{
+					"changed": false,
+					"msg":     fmt.Sprintf("%s, state %q, check mode %t: %s", fakeTask, r.state, r.checkMode, strings.Join(dc.Args, " ")),
+				},
+			},
+		},
+		{
+			Event:  eventTypePlaybookOnStats,
+			Stdout: fmt.Sprintf("PLAY RECAP ***\n%s : ok=1 changed=0 unreachable=0 failed=0", fakeHost),
+			EventData: map[string]any{
+				"ok":        map[string]int{fakeHost: 1},
+				"processed": map[string]int{fakeHost: 1},
+			},
+		},
+	}
+	if err := writeArtifacts(dir, evts, StatusSuccessful, 0, dc.Stdout); err != nil {
+		return nil, fmt.Errorf("%s: %w", errFakeRun, err)
+	}
+	return func() error { return nil }, nil
+}
+
+// writeArtifacts writes the supplied job events of a run into its supplied
+// artifacts directory, along with its stdout, its final status and its exit
+// code, the way ansible-runner writes them, and copies its stdout to the
+// supplied writer, if any.
+func writeArtifacts(dir string, evts []jobEvent, status string, rc int, w io.Writer) error {
+	if err := os.MkdirAll(filepath.Join(dir, "job_events"), 0700); err != nil {
+		return err
+	}
+	var stdout strings.Builder
+	for i, evt := range evts {
+		evt.Counter = i + 1
+		evt.UUID = generateUUID().String()
+		b, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%d-%s.json", evt.Counter, evt.UUID)
+		if err := os.WriteFile(filepath.Join(dir, "job_events", name), b, 0600); err != nil {
+			return err
+		}
+		stdout.WriteString(evt.Stdout + "\n")
+	}
+	for name, content := range map[string]string{
+		"stdout": stdout.String(),
+		"status": status,
+		"rc":     strconv.Itoa(rc),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			return err
+		}
+	}
+	if w != nil {
+		_, _ = io.WriteString(w, stdout.String())
+	}
+	return nil
+}
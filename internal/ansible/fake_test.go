@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestFake(t *testing.T) {
+	dir := t.TempDir()
+	playbook := "- hosts: all\n  tasks: []\n"
+	run := &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "testApp"},
+		Spec: v1alpha1.AnsibleRunSpec{
+			ForProvider: v1alpha1.AnsibleRunParameters{PlaybookInline: &playbook},
+		},
+	}
+	// neither ansible-runner nor ansible-galaxy are installed
+	params := Parameters{
+		WorkingDirPath: dir,
+		RunnerBinary:   filepath.Join(dir, "ansible-runner"),
+		GalaxyBinary:   filepath.Join(dir, "ansible-galaxy"),
+		Fake:           true,
+	}
+	if err := params.GalaxyInstall(context.Background(), nil, "collection", GalaxyOptions{}); err != nil {
+		t.Fatalf("Unexpected GalaxyInstall() error: %v", err)
+	}
+	runner, err := params.Init(context.Background(), run, nil)
+	if err != nil {
+		t.Fatalf("Unexpected Init() error: %v", err)
+	}
+
+	res, err := runner.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected Check() error: %v", err)
+	}
+	if Diff(res) != nil {
+		t.Errorf("Check(): the simulated run should change nothing, got %v", Diff(res))
+	}
+	if err := runner.Apply(context.Background()); err != nil {
+		t.Fatalf("Unexpected Apply() error: %v", err)
+	}
+
+	last := runner.LastRun()
+	want := []v1alpha1.HostStats{{Host: fakeHost, Ok: 1}}
+	if diff := cmp.Diff(want, last.Hosts); diff != "" {
+		t.Errorf("LastRun(): -want hosts, +got hosts:\n%s", diff)
+	}
+	if last.Tasks != 1 {
+		t.Errorf("LastRun(): want 1 task, got %d", last.Tasks)
+	}
+	zero := 0
+	wantOutcome := v1alpha1.RunSummary{Status: StatusSuccessful, ExitCode: &zero, RC: &zero, ArtifactsPath: filepath.Join(dir, "artifacts", last.ID)}
+	gotOutcome := v1alpha1.RunSummary{Status: last.Status, ExitCode: last.ExitCode, RC: last.RC, ArtifactsPath: last.ArtifactsPath}
+	if diff := cmp.Diff(wantOutcome, gotOutcome); diff != "" {
+		t.Errorf("LastRun(): -want outcome, +got outcome:\n%s", diff)
+	}
+	status, err := os.ReadFile(filepath.Join(dir, "artifacts", last.ID, "status"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading the status of the run: %v", err)
+	}
+	if string(status) != StatusSuccessful {
+		t.Errorf("Unexpected status of the run %q, want %q", string(status), StatusSuccessful)
+	}
+}
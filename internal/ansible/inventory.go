@@ -0,0 +1,133 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+const (
+	errInventoryInvalid = "inventory of the run is invalid"
+	errListHosts        = "cannot list the hosts of the inventory"
+)
+
+// withInventoryCheck sets the ansible-inventory binary validating the
+// inventory sources at paths before each run, none when the binary is empty.
+func withInventoryCheck(binary string, paths ...string) runnerOption {
+	return func(r *Runner) {
+		r.inventoryBinary = binary
+		r.inventoryPaths = paths
+	}
+}
+
+// validateInventory parses the inventory of the next run with
+// ansible-inventory, and returns an inventory invalid RunError when it cannot
+// be parsed, e.g. because of bad YAML or INI, or a failing inventory script,
+// rather than letting the run fail midway.
+func (r *Runner) validateInventory(ctx context.Context) error {
+	_, err := r.listInventory(ctx, io.Discard)
+	return err
+}
+
+// Hosts returns the names of the hosts of the inventory of the next run,
+// sorted, none when it has no inventory or without ansible-inventory. It
+// returns an inventory invalid RunError when the inventory cannot be parsed.
+func (r *Runner) Hosts(ctx context.Context) ([]string, error) {
+	var stdout bytes.Buffer
+	listed, err := r.listInventory(ctx, &stdout)
+	if err != nil || !listed {
+		return nil, err
+	}
+	// the groups list their hosts, and _meta the vars of all the hosts
+	var inventory map[string]struct {
+		Hosts    []string                   `json:"hosts"`
+		HostVars map[string]json.RawMessage `json:"hostvars"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &inventory); err != nil {
+		return nil, fmt.Errorf("%s: %w", errListHosts, err)
+	}
+	seen := map[string]bool{}
+	for _, group := range inventory {
+		for _, h := range group.Hosts {
+			seen[h] = true
+		}
+		for h := range group.HostVars {
+			seen[h] = true
+		}
+	}
+	hosts := make([]string, 0, len(seen))
+	for h := range seen {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// listInventory writes the inventory of the next run, as listed by
+// ansible-inventory, to the supplied writer. It tells whether it was listed,
+// not without inventory or without ansible-inventory, and returns an
+// inventory invalid RunError when it cannot be parsed.
+func (r *Runner) listInventory(ctx context.Context, stdout io.Writer) (bool, error) {
+	if r.inventoryBinary == "" {
+		return false, nil
+	}
+	var args []string
+	for _, path := range r.inventoryPaths {
+		if _, err := os.Stat(path); err == nil {
+			args = append(args, "--inventory", path)
+		}
+	}
+	if len(args) == 0 {
+		// runs without inventory target the implicit localhost
+		return false, nil
+	}
+	// gosec is disabled here because of G204. The inventory paths are passed
+	// as arguments, not through a shell
+	// the inventory may hold vars encrypted with ansible-vault
+	args = append(append(args, "--list"), r.vaultArgs()...)
+	dc := exec.CommandContext(ctx, r.inventoryBinary, args...) //nolint:gosec
+	dc.Env = append(dc.Env, os.Environ()...)
+	dc.Env = append(dc.Env, runnerutil.ConvertMapToSlice(r.behaviorVars)...)
+	// unparsed inventories are only warned about by default
+	dc.Env = append(dc.Env, "ANSIBLE_INVENTORY_UNPARSED_FAILED=true", "ANSIBLE_INVENTORY_ANY_UNPARSED_IS_FAILED=true")
+	var stderr bytes.Buffer
+	dc.Stdout = stdout
+	dc.Stderr = &stderr
+	if err := dc.Run(); err != nil {
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = err.Error()
+		}
+		return false, &RunError{
+			Kind:   FailureInventoryInvalid,
+			Reason: reason,
+			Err:    errors.New(errInventoryInvalid),
+		}
+	}
+	return true, nil
+}
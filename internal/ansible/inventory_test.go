@@ -0,0 +1,217 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateInventory(t *testing.T) {
+	cases := map[string]struct {
+		script      string
+		noInventory bool
+		plugin      bool
+		noBinary    bool
+		wantInvalid bool
+		wantReason  string
+	}{
+		"Valid": {
+			script: `echo '{"all": {"hosts": ["web1"]}}'`,
+		},
+		"Invalid": {
+			script:      `echo "[WARNING]:  * Failed to parse hosts with ini plugin: Expected key=value host variable assignment" >&2; exit 1`,
+			wantInvalid: true,
+			wantReason:  "[WARNING]:  * Failed to parse hosts with ini plugin: Expected key=value host variable assignment",
+		},
+		"UnparsedFailed": {
+			// ansible-inventory only warns about unparsed inventories unless told otherwise
+			script:      `[ "$ANSIBLE_INVENTORY_UNPARSED_FAILED" = true ] && [ "$ANSIBLE_INVENTORY_ANY_UNPARSED_IS_FAILED" = true ] || exit 0; echo unparsed >&2; exit 1`,
+			wantInvalid: true,
+			wantReason:  "unparsed",
+		},
+		"NoInventory": {
+			// the implicit localhost is targeted
+			script:      "exit 1",
+			noInventory: true,
+		},
+		"Plugin": {
+			// the inventory plugins are parsed along with the inventory file
+			script: `case "$*" in *"--inventory $INVENTORY_DIR/hosts --inventory $INVENTORY_DIR/aws_ec2.yml --list") exit 0;; esac; echo "$*" >&2; exit 1`,
+			plugin: true,
+		},
+		"PluginOnly": {
+			script:      `case "$*" in *"--inventory $INVENTORY_DIR/aws_ec2.yml --list") exit 0;; esac; echo "$*" >&2; exit 1`,
+			noInventory: true,
+			plugin:      true,
+		},
+		"NoBinary": {
+			script:   "exit 1",
+			noBinary: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			inventoryBinary := filepath.Join(t.TempDir(), "ansible-inventory")
+			if err := os.WriteFile(inventoryBinary, []byte("#!/bin/sh\n"+tc.script+"\n"), 0700); err != nil {
+				t.Fatalf("Writing fake ansible-inventory: %v", err)
+			}
+			if tc.noBinary {
+				inventoryBinary = ""
+			}
+			inventoryDir := filepath.Join(dir, "inventory")
+			t.Setenv("INVENTORY_DIR", inventoryDir)
+			if err := os.MkdirAll(inventoryDir, 0700); err != nil {
+				t.Fatalf("Creating inventory dir: %v", err)
+			}
+			inventoryPaths := []string{filepath.Join(inventoryDir, "hosts")}
+			if !tc.noInventory {
+				if err := os.WriteFile(inventoryPaths[0], []byte("web1 ansible_host\n"), 0600); err != nil {
+					t.Fatalf("Writing inventory: %v", err)
+				}
+			}
+			if tc.plugin {
+				inventoryPaths = append(inventoryPaths, filepath.Join(inventoryDir, "aws_ec2.yml"))
+				if err := os.WriteFile(inventoryPaths[1], []byte("plugin: amazon.aws.aws_ec2\n"), 0600); err != nil {
+					t.Fatalf("Writing inventory plugin config: %v", err)
+				}
+			}
+
+			runner := new(withWorkDir(dir), withInventoryCheck(inventoryBinary, inventoryPaths...))
+
+			err := runner.validateInventory(context.Background())
+			var runErr *RunError
+			if got := errors.As(err, &runErr) && runErr.Kind == FailureInventoryInvalid; got != tc.wantInvalid {
+				t.Fatalf("Unexpected validateInventory() error %v, want invalid %t", err, tc.wantInvalid)
+			}
+			if tc.wantInvalid && runErr.Reason != tc.wantReason {
+				t.Errorf("Unexpected reason %q, want %q", runErr.Reason, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestHosts(t *testing.T) {
+	cases := map[string]struct {
+		script      string
+		noInventory bool
+		want        []string
+		wantErr     bool
+	}{
+		"Hosts": {
+			script: `cat <<'EOF'
+{"_meta": {"hostvars": {"web1": {"ansible_host": "10.0.0.1"}, "db1": {}}},
+ "all": {"children": ["ungrouped", "web"]},
+ "web": {"hosts": ["web1", "web2"]},
+ "ungrouped": {"hosts": ["db1"]}}
+EOF`,
+			want: []string{"db1", "web1", "web2"},
+		},
+		"NoInventory": {
+			// the implicit localhost is not locked
+			script:      "exit 1",
+			noInventory: true,
+		},
+		"Invalid": {
+			script:  "echo unparsed >&2; exit 1",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			inventoryBinary := filepath.Join(t.TempDir(), "ansible-inventory")
+			if err := os.WriteFile(inventoryBinary, []byte("#!/bin/sh\n"+tc.script+"\n"), 0700); err != nil {
+				t.Fatalf("Writing fake ansible-inventory: %v", err)
+			}
+			hosts := filepath.Join(dir, "hosts")
+			if !tc.noInventory {
+				if err := os.WriteFile(hosts, []byte("web1\n"), 0600); err != nil {
+					t.Fatalf("Writing inventory: %v", err)
+				}
+			}
+			runner := new(withWorkDir(dir), withInventoryCheck(inventoryBinary, hosts))
+
+			got, err := runner.Hosts(context.Background())
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("Unexpected Hosts() error %v, want error %t", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected hosts -want, +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunInvalidInventory(t *testing.T) {
+	dir := t.TempDir()
+	inventoryPath := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(inventoryPath, []byte("[web\n"), 0600); err != nil {
+		t.Fatalf("Writing inventory: %v", err)
+	}
+	inventoryBinary := filepath.Join(t.TempDir(), "ansible-inventory")
+	if err := os.WriteFile(inventoryBinary, []byte("#!/bin/sh\necho 'Invalid section entry' >&2\nexit 1\n"), 0700); err != nil {
+		t.Fatalf("Writing fake ansible-inventory: %v", err)
+	}
+
+	runner := new(withWorkDir(dir), withInventoryCheck(inventoryBinary, inventoryPath), withCmdFunc(func(ctx context.Context, _ map[string]string) *exec.Cmd {
+		t.Fatalf("Runs with an invalid inventory should not be run")
+		return nil
+	}))
+
+	_, err := runner.Run(context.Background())
+	want := "inventory of the run is invalid: Invalid section entry"
+	if err == nil || err.Error() != want {
+		t.Errorf("Unexpected error %v, want %q", err, want)
+	}
+}
+
+func TestInventorySources(t *testing.T) {
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "inventory", "hosts")
+	plugin := filepath.Join(dir, "inventory", "aws_ec2.yml")
+	p := Parameters{WorkingDirPath: dir}
+
+	// runs without inventory target the implicit localhost
+	if diff := cmp.Diff([]string{hosts}, p.inventorySources()); diff != "" {
+		t.Errorf("inventorySources(): -want, +got:\n%s", diff)
+	}
+
+	p.inventoryPlugins = []string{"aws_ec2.yml"}
+	if diff := cmp.Diff([]string{plugin}, p.inventorySources()); diff != "" {
+		t.Errorf("inventorySources(): -want, +got:\n%s", diff)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(hosts), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hosts, []byte("web1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{hosts, plugin}, p.inventorySources()); diff != "" {
+		t.Errorf("inventorySources(): -want, +got:\n%s", diff)
+	}
+}
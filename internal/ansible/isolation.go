@@ -0,0 +1,67 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+const (
+	// homeEnv is the home directory of the runs, holding the ~/.ansible
+	// caches of Ansible
+	homeEnv = "HOME"
+	// localTempEnv is the directory of the temporary files of Ansible on
+	// the controller
+	localTempEnv = "ANSIBLE_LOCAL_TEMP"
+	// remoteTempEnv is the directory of the temporary files of Ansible on
+	// the hosts
+	remoteTempEnv = "ANSIBLE_REMOTE_TMP"
+)
+
+// isolationEnv returns the environment isolating the runs of the private
+// data dir from the runs of the other AnsibleRuns executing in the same pod:
+// their home and temporary files are kept in the private data dir. The
+// temporary files on the hosts are kept in a directory of the AnsibleRun
+// under the home of the remote user, which is the private data dir with a
+// local connection. The behavior vars still override them.
+func (p Parameters) isolationEnv() []string {
+	return []string{
+		homeEnv + "=" + filepath.Join(p.WorkingDirPath, runnerutil.HomeDir),
+		localTempEnv + "=" + filepath.Join(p.WorkingDirPath, runnerutil.TmpDir),
+		remoteTempEnv + "=" + "~/.ansible/tmp/" + filepath.Base(p.WorkingDirPath),
+	}
+}
+
+// expandHome expands the ~ leading the supplied colon separated paths to the
+// home of the provider, as the runs have their own.
+func expandHome(paths string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return paths
+	}
+	list := filepath.SplitList(paths)
+	for i, path := range list {
+		if path == "~" || strings.HasPrefix(path, "~/") {
+			list[i] = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	return strings.Join(list, string(filepath.ListSeparator))
+}
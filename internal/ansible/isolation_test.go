@@ -0,0 +1,45 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsolationEnv(t *testing.T) {
+	p := Parameters{WorkingDirPath: "/ansibleDir/uid"}
+	r := &Runner{behaviorVars: map[string]string{localTempEnv: "/vars/tmp"}}
+	dc := p.playbookCmdFunc("playbook.yml")(context.Background(), r.behaviorVars)
+
+	want := map[string]string{
+		homeEnv:       "/ansibleDir/uid/home",
+		localTempEnv:  "/vars/tmp",
+		remoteTempEnv: "~/.ansible/tmp/uid",
+	}
+	// like exec.Cmd, the last value of a variable wins
+	env := jobEnv(dc.Env, r.jobEnvAllowList())
+	got := map[string]string{}
+	for k := range want {
+		got[k] = string(env[k])
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("playbookCmdFunc(...): -want env, +got env:\n%s", diff)
+	}
+}
@@ -0,0 +1,583 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+const (
+	// ExecutorLocal runs ansible-runner in the provider process
+	ExecutorLocal = "Local"
+	// ExecutorJob runs ansible-runner in a Kubernetes Job per run
+	ExecutorJob = "Job"
+	// ExecutorFake simulates the runs without running ansible-runner, nor
+	// installing the requirements, e.g. to try the configuration of the
+	// provider out in clusters where Ansible is not installed
+	ExecutorFake = "Fake"
+
+	// LabelKeyRunID is the name of a label holding the ident of the run of
+	// the Jobs of the Job executor, and of their pods
+	LabelKeyRunID = "ansible.crossplane.io/run-id"
+	// AnnotationKeyAnsibleRun is the name of an annotation holding the name
+	// of the AnsibleRun of the Jobs of the Job executor
+	AnnotationKeyAnsibleRun = "ansible.crossplane.io/ansiblerun"
+)
+
+const (
+	errCreateJob       = "cannot create the Job of the run"
+	errCreateJobSecret = "cannot create the Secret of the environment of the run"
+	errOwnJobSecret    = "cannot make the Job of the run own its Secret"
+	errReadCredentials = "cannot read the credentials of the run"
+	errGetJob          = "cannot get the Job of the run"
+	errJobFailed       = "Job of the run failed"
+)
+
+const (
+	jobContainerName  = "ansible-runner"
+	jobWorkDirVolume  = "workdir"
+	jobCredsVolume    = "credentials"
+	jobCredsKeyPrefix = "file."
+	jobNamePrefix     = "ansible-run-"
+	jobPollInterval   = 2 * time.Second
+	jobLogsWaitDelay  = 10 * time.Second
+	jobTTLAfterFinish = int32(3600)
+)
+
+// runnerEnv are the environment variables the runner sets on the
+// ansible-runner commands.
+var runnerEnv = []string{homeEnv, localTempEnv, remoteTempEnv, AnsibleInventoryPath, ansibleRolesPathEnv}
+
+// A JobExecutor runs ansible-runner in a Kubernetes Job per run instead of
+// the provider process, so that large runs get their own resources rather
+// than competing with the provider for them. The working directories are
+// shared with the Jobs through a PersistentVolumeClaim, which is mounted at
+// the same path in the provider and in the Jobs.
+type JobExecutor struct {
+	// Client creates the Jobs and streams the logs of their pods.
+	Client kubernetes.Interface
+	// Namespace of the Jobs.
+	Namespace string
+	// Image of the Jobs, which must provide ansible-runner at the same path
+	// as the provider image.
+	Image string
+	// ServiceAccountName of the pods of the Jobs, the default one when empty.
+	ServiceAccountName string
+	// Resources of the pods of the Jobs.
+	Resources *corev1.ResourceRequirements
+	// NodeSelector, Tolerations and Affinity schedule the pods of the Jobs,
+	// e.g. onto the nodes reaching the hosts.
+	NodeSelector map[string]string
+	Tolerations  []corev1.Toleration
+	Affinity     *corev1.Affinity
+	// VolumeClaim is the PersistentVolumeClaim holding the working directories.
+	VolumeClaim string
+	// MountPath of the VolumeClaim, in the provider and in the Jobs.
+	MountPath string
+	// PollInterval between the checks of the status of the Jobs, 2s when zero.
+	PollInterval time.Duration
+	// RunAsUser and RunAsGroup of the pods of the Jobs, the ones of the
+	// provider process when nil, which owns the files of the working
+	// directories. The group is also the fsGroup of the pods.
+	RunAsUser  *int64
+	RunAsGroup *int64
+}
+
+// A JobError is returned when the Job of a run fails.
+type JobError struct {
+	// Name of the Job
+	Name string
+	// Code is the exit code of ansible-runner, -1 when it is unknown, e.g.
+	// because the pod of the Job was evicted
+	Code int
+	// Message of the Failed condition of the Job
+	Message string
+}
+
+func (e *JobError) Error() string {
+	msg := fmt.Sprintf("%s %s: exit code %d", errJobFailed, e.Name, e.Code)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// ExitCode returns the exit code of ansible-runner, like the one of an
+// exec.ExitError, so that the failures of the Jobs are classified alike.
+func (e *JobError) ExitCode() int {
+	return e.Code
+}
+
+// SetJobExecutor sets the executor running ansible-runner in Kubernetes Jobs,
+// the runs being executed in the provider process when it is nil.
+func (r *Runner) SetJobExecutor(e *JobExecutor) {
+	r.jobExecutor = e
+}
+
+// AddSharedDir adds a directory outside of the working directory the runs
+// read, e.g. the collections cache of the ProviderConfig, which is mounted
+// read-only into their Jobs.
+func (r *Runner) AddSharedDir(dir string) {
+	r.sharedDirs = append(r.sharedDirs, dir)
+}
+
+// A jobRun is the run of a Runner a Job executes.
+type jobRun struct {
+	// id is the ident of the run
+	id string
+	// name is the name of the AnsibleRun
+	name string
+	// allowed are the variables of the environment passed to the Job
+	allowed map[string]bool
+	// credentials are the credential files mounted from the Secret of the
+	// Job
+	credentials []string
+	// dirs are the directories of the volume claim the run writes into,
+	// shared the ones it only reads
+	dirs   []string
+	shared []string
+}
+
+// jobRun returns the run with the supplied ident, as executed by a Job. Its
+// artifacts are kept in its working directory, since the Job executor cannot
+// write them to another directory.
+func (r *Runner) jobRun(id string) jobRun {
+	return jobRun{id: id, name: r.name, allowed: r.jobEnvAllowList(), credentials: r.credentialFiles(), dirs: []string{r.workDir}, shared: r.sharedDirs}
+}
+
+// start starts the supplied ansible-runner command of the run with the
+// supplied ident, and returns a func waiting for it to exit.
+func (r *Runner) start(ctx context.Context, dc *exec.Cmd, id string) (func() error, error) {
+	if r.chaos != nil {
+		return r.chaos.start(ctx, r, dc, id, func() (func() error, error) {
+			return r.startExecutor(ctx, dc, id)
+		})
+	}
+	return r.startExecutor(ctx, dc, id)
+}
+
+// startExecutor starts the supplied ansible-runner command of the run with
+// the supplied ident with the executor of the runner, and returns a func
+// waiting for it to exit.
+func (r *Runner) startExecutor(ctx context.Context, dc *exec.Cmd, id string) (func() error, error) {
+	if r.fake {
+		return r.fakeStart(dc, id)
+	}
+	if r.jobExecutor != nil {
+		return r.jobExecutor.start(ctx, dc, r.jobRun(id))
+	}
+	if err := dc.Start(); err != nil {
+		return nil, err
+	}
+	return dc.Wait, nil
+}
+
+// start creates the Job running the supplied ansible-runner command of the
+// supplied run, with the allowed variables of its environment and its
+// credential files in a Secret owned by the Job. The Secret is created first,
+// so that the pod of the Job never starts without it. The returned func
+// streams the logs of the Job to the stdout of the command until it
+// finishes, and deletes it.
+func (e *JobExecutor) start(ctx context.Context, dc *exec.Cmd, run jobRun) (func() error, error) {
+	id := run.id
+	env := jobEnv(dc.Env, run.allowed)
+	data := make(map[string][]byte, len(env)+len(run.credentials))
+	for k, v := range env {
+		data[k] = v
+	}
+	for i, path := range run.credentials {
+		b, err := os.ReadFile(path) //nolint:gosec // written by the provider
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errReadCredentials, err)
+		}
+		data[credentialsKey(i)] = b
+	}
+	job := e.job(dc, run, env)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: e.Namespace,
+			Labels:    job.Labels,
+		},
+		Data: data,
+	}
+	if _, err := e.Client.CoreV1().Secrets(e.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("%s: %w", errCreateJobSecret, err)
+	}
+	created, err := e.Client.BatchV1().Jobs(e.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		e.delete(ctx, job.Name)
+		return nil, fmt.Errorf("%s: %w", errCreateJob, err)
+	}
+	// owned by the Job, so that it is garbage collected along with it
+	// should the provider go away before deleting them
+	owner, err := json.Marshal(map[string]any{"metadata": map[string]any{"ownerReferences": []metav1.OwnerReference{{
+		APIVersion: batchv1.SchemeGroupVersion.String(),
+		Kind:       "Job",
+		Name:       created.Name,
+		UID:        created.UID,
+	}}}})
+	if err != nil {
+		e.delete(ctx, job.Name)
+		return nil, fmt.Errorf("%s: %w", errOwnJobSecret, err)
+	}
+	if _, err := e.Client.CoreV1().Secrets(e.Namespace).Patch(ctx, job.Name, types.MergePatchType, owner, metav1.PatchOptions{}); err != nil {
+		e.delete(ctx, job.Name)
+		return nil, fmt.Errorf("%s: %w", errOwnJobSecret, err)
+	}
+	stdout := dc.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	return func() error {
+		defer e.delete(ctx, job.Name)
+		return e.wait(ctx, job.Name, id, stdout)
+	}, nil
+}
+
+// volumeMounts returns the mounts of the supplied directories of the volume
+// claim at their paths in the provider. The directories outside of the
+// volume claim are left out.
+func (e *JobExecutor) volumeMounts(dirs []string, readOnly bool) []corev1.VolumeMount {
+	mounts := make([]corev1.VolumeMount, 0, len(dirs))
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(e.MountPath, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		mounts = append(mounts, corev1.VolumeMount{Name: jobWorkDirVolume, MountPath: dir, SubPath: rel, ReadOnly: readOnly})
+	}
+	return mounts
+}
+
+// credentialsKey returns the key of the Secret of a Job holding the content
+// of the credential file of the supplied index. The variables of the
+// environment cannot contain dots, so that the keys don't collide.
+func credentialsKey(i int) string {
+	return jobCredsKeyPrefix + strconv.Itoa(i)
+}
+
+// job returns the Job running the supplied ansible-runner command of the
+// supplied run, with the supplied environment and the credential files of the
+// run read from the Secret named after it. Only the directories of the run
+// are mounted from the volume claim, so that it cannot read the working
+// directories of the other AnsibleRuns. The credential files are mounted
+// over their paths in the working directory from a Secret volume, which is
+// backed by memory, so that the runs don't read them from the volume claim.
+func (e *JobExecutor) job(dc *exec.Cmd, run jobRun, env map[string][]byte) *batchv1.Job {
+	labels := map[string]string{LabelKeyRunID: run.id}
+	meta := metav1.ObjectMeta{
+		Name:        jobNamePrefix + run.id,
+		Namespace:   e.Namespace,
+		Labels:      labels,
+		Annotations: map[string]string{AnnotationKeyAnsibleRun: run.name},
+	}
+	container := corev1.Container{
+		Name:    jobContainerName,
+		Image:   e.Image,
+		Command: append([]string{dc.Path}, dc.Args[1:]...),
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: ptr.To(false),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+		VolumeMounts: append(e.volumeMounts(run.dirs, false), e.volumeMounts(run.shared, true)...),
+	}
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		container.Env = append(container.Env, corev1.EnvVar{Name: k, ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: meta.Name}, Key: k},
+		}})
+	}
+	volumes := []corev1.Volume{{
+		Name: jobWorkDirVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: e.VolumeClaim},
+		},
+	}}
+	if len(run.credentials) != 0 {
+		items := make([]corev1.KeyToPath, 0, len(run.credentials))
+		for i, path := range run.credentials {
+			key := credentialsKey(i)
+			items = append(items, corev1.KeyToPath{Key: key, Path: key})
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: jobCredsVolume, MountPath: path, SubPath: key, ReadOnly: true})
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: jobCredsVolume,
+			VolumeSource: corev1.VolumeSource{
+				// readable by the fsGroup of the pod only
+				Secret: &corev1.SecretVolumeSource{SecretName: meta.Name, Items: items, DefaultMode: ptr.To(int32(0400))},
+			},
+		})
+	}
+	if e.Resources != nil {
+		container.Resources = *e.Resources
+	}
+	spec := batchv1.JobSpec{
+		// the provider decides when to run again
+		BackoffLimit: ptr.To(int32(0)),
+		// in case the provider goes away before deleting it
+		TTLSecondsAfterFinished: ptr.To(jobTTLAfterFinish),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: meta.Annotations},
+			Spec: corev1.PodSpec{
+				RestartPolicy:      corev1.RestartPolicyNever,
+				ServiceAccountName: e.ServiceAccountName,
+				SecurityContext:    e.securityContext(),
+				NodeSelector:       e.NodeSelector,
+				Tolerations:        e.Tolerations,
+				Affinity:           e.Affinity,
+				Containers:         []corev1.Container{container},
+				Volumes:            volumes,
+			},
+		},
+	}
+	return &batchv1.Job{ObjectMeta: meta, Spec: spec}
+}
+
+// credentialFiles returns the credential files the provider wrote into the
+// working directory for the runs: the vault passwords, the SSH credentials,
+// and the responses to the interactive prompts.
+func (r *Runner) credentialFiles() []string {
+	var files []string
+	for _, dir := range []string{filepath.Join(r.workDir, runnerutil.VaultDir), filepath.Join(r.workDir, runnerutil.SSHDir)} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	passwords := filepath.Join(r.ansibleEnvDir(), runnerutil.Passwords)
+	if _, err := os.Stat(passwords); err == nil {
+		files = append(files, passwords)
+	}
+	return files
+}
+
+// securityContext returns the security context of the pods of the Jobs,
+// which run as the user owning the files of the working directories, so that
+// the credentials the provider writes there with mode 0600 can only be read
+// by the runs, not by the other users of the volume claim.
+func (e *JobExecutor) securityContext() *corev1.PodSecurityContext {
+	uid := ptr.To(int64(os.Getuid()))
+	if e.RunAsUser != nil {
+		uid = e.RunAsUser
+	}
+	gid := ptr.To(int64(os.Getgid()))
+	if e.RunAsGroup != nil {
+		gid = e.RunAsGroup
+	}
+	sc := &corev1.PodSecurityContext{
+		RunAsUser:  uid,
+		RunAsGroup: gid,
+		FSGroup:    gid,
+		// the files of the working directories keep their modes, unless
+		// the root of the volume isn't owned by the group
+		FSGroupChangePolicy: ptr.To(corev1.FSGroupChangeOnRootMismatch),
+	}
+	if *uid != 0 {
+		sc.RunAsNonRoot = ptr.To(true)
+	}
+	return sc
+}
+
+// jobEnv returns the environment of an ansible-runner command to pass to its
+// Job, restricted to the supplied allow-list of variables. The environment of
+// the provider process, e.g. its own credentials, is left out.
+func jobEnv(env []string, allowed map[string]bool) map[string][]byte {
+	data := map[string][]byte{}
+	for _, kv := range env {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !allowed[k] {
+			continue
+		}
+		// like exec.Cmd, the last value of a variable wins
+		data[k] = []byte(v)
+	}
+	return data
+}
+
+// jobEnvAllowList returns the environment variables passed to the Jobs of
+// the runs: the ones the runner sets on the ansible-runner commands, and the
+// behavior vars, which the ProviderConfig and the AnsibleRun declare for the
+// runs, e.g. the credentials of the inventory plugins.
+func (r *Runner) jobEnvAllowList() map[string]bool {
+	allowed := make(map[string]bool, len(runnerEnv)+len(r.behaviorVars))
+	for _, k := range runnerEnv {
+		allowed[k] = true
+	}
+	for k := range r.behaviorVars {
+		allowed[k] = true
+	}
+	return allowed
+}
+
+// wait waits for the named Job to finish, streaming the logs of its pod to
+// stdout, and returns a JobError when it fails.
+func (e *JobExecutor) wait(ctx context.Context, name, id string, stdout io.Writer) error {
+	logsCtx, cancelLogs := context.WithCancel(ctx)
+	defer cancelLogs()
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		e.streamLogs(logsCtx, id, stdout)
+	}()
+
+	interval := e.PollInterval
+	if interval <= 0 {
+		interval = jobPollInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		job, err := e.Client.BatchV1().Jobs(e.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("%s: %w", errGetJob, err)
+		}
+		failed, finished := jobFinished(job)
+		if !finished {
+			continue
+		}
+		// the logs end with the container, let them catch up
+		select {
+		case <-logsDone:
+		case <-time.After(jobLogsWaitDelay):
+		}
+		if failed == nil {
+			return nil
+		}
+		failed.Code = e.exitCode(ctx, id)
+		return failed
+	}
+}
+
+// jobFinished tells whether the supplied Job finished, returning a JobError
+// when it failed.
+func jobFinished(job *batchv1.Job) (*JobError, bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type { //nolint:exhaustive // other conditions don't finish Jobs
+		case batchv1.JobComplete:
+			return nil, true
+		case batchv1.JobFailed:
+			return &JobError{Name: job.Name, Code: -1, Message: c.Message}, true
+		}
+	}
+	return nil, false
+}
+
+// exitCode returns the exit code of ansible-runner in the pod of the run
+// with the supplied ident, or -1 when it is unknown.
+func (e *JobExecutor) exitCode(ctx context.Context, id string) int {
+	pods, err := e.Client.CoreV1().Pods(e.Namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelKeyRunID + "=" + id})
+	if err != nil {
+		return -1
+	}
+	for _, p := range pods.Items {
+		for _, s := range p.Status.ContainerStatuses {
+			if s.Name == jobContainerName && s.State.Terminated != nil {
+				return int(s.State.Terminated.ExitCode)
+			}
+		}
+	}
+	return -1
+}
+
+// streamLogs copies the logs of the pod of the run with the supplied ident to
+// stdout once it started, until its container exits or ctx is done.
+func (e *JobExecutor) streamLogs(ctx context.Context, id string, stdout io.Writer) {
+	interval := e.PollInterval
+	if interval <= 0 {
+		interval = jobPollInterval
+	}
+	for {
+		pods, err := e.Client.CoreV1().Pods(e.Namespace).List(ctx, metav1.ListOptions{LabelSelector: LabelKeyRunID + "=" + id})
+		if err == nil {
+			for _, p := range pods.Items {
+				if p.Status.Phase == corev1.PodPending || p.Status.Phase == "" {
+					continue
+				}
+				logs, err := e.Client.CoreV1().Pods(e.Namespace).GetLogs(p.Name, &corev1.PodLogOptions{Container: jobContainerName, Follow: true}).Stream(ctx)
+				if err != nil {
+					log.FromContext(ctx).V(1).Info("streaming the logs of the Job of the run", "err", err)
+					return
+				}
+				defer logs.Close() //nolint:errcheck // only read
+				if _, err := io.Copy(stdout, logs); err != nil && ctx.Err() == nil {
+					log.FromContext(ctx).V(1).Info("streaming the logs of the Job of the run", "err", err)
+				}
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// delete deletes the named Job along with its pods and its Secret, even
+// when ctx is done, e.g. because the run timed out. The Secret is deleted
+// explicitly, in case it isn't owned by the Job yet.
+func (e *JobExecutor) delete(ctx context.Context, name string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	err := e.Client.BatchV1().Jobs(e.Namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+	if err != nil && !kerrors.IsNotFound(err) {
+		log.FromContext(ctx).Info("cannot delete the Job of the run", "job", name, "err", err)
+	}
+	err = e.Client.CoreV1().Secrets(e.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		log.FromContext(ctx).Info("cannot delete the Secret of the Job of the run", "secret", name, "err", err)
+	}
+}
@@ -1,15 +1,28 @@
 package ansible
 
+import (
+	"fmt"
+
+	"github.com/apenella/go-ansible/pkg/stdoutcallback/results"
+)
+
 const (
 	// https://github.com/ansible/awx/blob/devel/docs/job_events.md#job-event-relationships
 	// outlines various event types and the relationships between them
+	eventTypeRunnerOk          = "runner_on_ok"
 	eventTypeRunnerFailed      = "runner_on_failed"
 	eventTypeRunnerUnreachable = "runner_on_unreachable"
+	eventTypeRunnerSkipped     = "runner_on_skipped"
+	eventTypePlaybookOnStats   = "playbook_on_stats"
+	eventTypeRunnerRetry       = "runner_retry"
+	eventTypeTaskStart         = "playbook_on_task_start"
+	eventTypePlayStart         = "playbook_on_play_start"
 )
 
 // jobEvent represents [ansible-runner's job events](https://ansible.readthedocs.io/projects/runner/en/stable/intro/#artifactevents)
 type jobEvent struct {
 	UUID      string         `json:"uuid"`
+	Counter   int            `json:"counter"`
 	Stdout    string         `json:"stdout"`
 	Event     string         `json:"event"`
 	EventData map[string]any `json:"event_data"`
@@ -17,12 +30,80 @@ type jobEvent struct {
 
 type runnerEventData struct {
 	Play         string       `json:"play"`
+	Role         string       `json:"role"`
 	Task         string       `json:"task"`
 	Host         string       `json:"host"`
 	Result       runnerResult `json:"res"`
 	IgnoreErrors bool         `json:"ignore_errors"`
+	// Duration is the time in seconds the task took on the host
+	Duration float64 `json:"duration"`
+}
+
+// describeTask names the task of the event, along with its role: the tasks
+// of the roles are told apart by their role, several roles running in the
+// same play.
+func (d runnerEventData) describeTask() string {
+	task := fmt.Sprintf("task %q", d.Task)
+	if d.Role != "" {
+		task = fmt.Sprintf("role %q, %s", d.Role, task)
+	}
+	return task
 }
 
 type runnerResult struct {
-	Msg string `json:"msg"`
+	Msg     string `json:"msg"`
+	Changed bool   `json:"changed"`
+	// Attempts and Retries count the attempts of a task retried until a
+	// condition is met
+	Attempts int `json:"attempts"`
+	Retries  int `json:"retries"`
+}
+
+// statsEventData is the recap of a run, counting the task results by host.
+type statsEventData struct {
+	Changed   map[string]int `json:"changed"`
+	Dark      map[string]int `json:"dark"`
+	Failures  map[string]int `json:"failures"`
+	Ignored   map[string]int `json:"ignored"`
+	Ok        map[string]int `json:"ok"`
+	Processed map[string]int `json:"processed"`
+	Rescued   map[string]int `json:"rescued"`
+	Skipped   map[string]int `json:"skipped"`
+}
+
+// hostStats returns the recap by host, the way the json stdout callback
+// reports it.
+func (d statsEventData) hostStats() map[string]*results.AnsiblePlaybookJSONResultsStats {
+	stats := make(map[string]*results.AnsiblePlaybookJSONResultsStats, len(d.Processed))
+	host := func(name string) *results.AnsiblePlaybookJSONResultsStats {
+		if stats[name] == nil {
+			stats[name] = &results.AnsiblePlaybookJSONResultsStats{}
+		}
+		return stats[name]
+	}
+	for name := range d.Processed {
+		host(name)
+	}
+	for name, n := range d.Changed {
+		host(name).Changed = n
+	}
+	for name, n := range d.Dark {
+		host(name).Unreachable = n
+	}
+	for name, n := range d.Failures {
+		host(name).Failures = n
+	}
+	for name, n := range d.Ignored {
+		host(name).Ignored = n
+	}
+	for name, n := range d.Ok {
+		host(name).Ok = n
+	}
+	for name, n := range d.Rescued {
+		host(name).Rescued = n
+	}
+	for name, n := range d.Skipped {
+		host(name).Skipped = n
+	}
+	return stats
 }
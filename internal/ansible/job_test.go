@@ -0,0 +1,289 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+func TestJobExecutor(t *testing.T) {
+	const id = "d0c5e1a6-0d5e-4d3c-9c1e-3f1e2b6a7c8d"
+	pod := func(exitCode int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "ansible-run-pod", Namespace: "crossplane-system", Labels: map[string]string{LabelKeyRunID: id}},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodFailed,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  jobContainerName,
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}},
+				}},
+			},
+		}
+	}
+	finished := func(condition batchv1.JobConditionType, message string) k8stesting.ReactionFunc {
+		return func(action k8stesting.Action) (bool, runtime.Object, error) {
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: jobNamePrefix + id}}
+			job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Message: message}}
+			return true, job, nil
+		}
+	}
+
+	cases := map[string]struct {
+		reason     string
+		pod        *corev1.Pod
+		verb       string
+		resource   string
+		reaction   k8stesting.ReactionFunc
+		wantErr    error
+		wantLogs   string
+		wantDelete bool
+	}{
+		"Complete": {
+			reason:     "We should stream the logs of the Job, and delete it once it completes",
+			pod:        pod(0),
+			verb:       "get",
+			reaction:   finished(batchv1.JobComplete, ""),
+			wantLogs:   "fake logs",
+			wantDelete: true,
+		},
+		"Failed": {
+			reason:     "We should return the exit code of ansible-runner when the Job fails",
+			pod:        pod(exitCodeParserError),
+			verb:       "get",
+			reaction:   finished(batchv1.JobFailed, "BackoffLimitExceeded"),
+			wantErr:    &JobError{Name: jobNamePrefix + id, Code: exitCodeParserError, Message: "BackoffLimitExceeded"},
+			wantLogs:   "fake logs",
+			wantDelete: true,
+		},
+		"CreateError": {
+			reason: "We should return the errors creating the Job",
+			verb:   "create",
+			reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, kerrors.NewForbidden(batchv1.Resource("jobs"), "", errors.New("boom"))
+			},
+			wantErr: errors.New(errCreateJob),
+		},
+		"SecretCreateError": {
+			reason:   "We should not create the Job when its Secret cannot be created",
+			verb:     "create",
+			resource: "secrets",
+			reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, kerrors.NewForbidden(corev1.Resource("secrets"), "", errors.New("boom"))
+			},
+			wantErr: errors.New(errCreateJobSecret),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset()
+			if tc.pod != nil {
+				cs = fake.NewSimpleClientset(tc.pod)
+			}
+			resource := tc.resource
+			if resource == "" {
+				resource = "jobs"
+			}
+			cs.PrependReactor(tc.verb, resource, tc.reaction)
+			e := &JobExecutor{
+				Client:       cs,
+				Namespace:    "crossplane-system",
+				Image:        "provider-ansible:test",
+				VolumeClaim:  "ansible-workdir",
+				MountPath:    "/ansibleDir",
+				PollInterval: time.Millisecond,
+				NodeSelector: map[string]string{"topology.kubernetes.io/zone": "dmz"},
+				Tolerations:  []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+				RunAsUser:    ptr.To(int64(65532)),
+				RunAsGroup:   ptr.To(int64(65532)),
+			}
+			var stdout bytes.Buffer
+			dc := exec.Command("/usr/local/bin/ansible-runner", "run", "/ansibleDir/uid", "-p", "playbook.yml")
+			dc.Env = []string{"PROVIDER_TOKEN=secret", "ANSIBLE_INVENTORY=/ansibleDir/uid/inventory/hosts"}
+			dc.Stdout = &stdout
+
+			key := filepath.Join(t.TempDir(), "id_rsa")
+			if err := os.WriteFile(key, []byte("private key"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			run := jobRun{
+				id:          id,
+				name:        "example",
+				allowed:     map[string]bool{AnsibleInventoryPath: true},
+				credentials: []string{key},
+				dirs:        []string{"/ansibleDir/uid"},
+				shared:      []string{"/ansibleDir/.cache/collections/default", "/opt/libraries"},
+			}
+
+			wait, err := e.start(context.Background(), dc, run)
+			if err == nil {
+				err = wait()
+			}
+			var jobErr *JobError
+			switch want := tc.wantErr; {
+			case errors.As(want, &jobErr):
+				if diff := cmp.Diff(want, err); diff != "" {
+					t.Errorf("\n%s\nUnexpected error (-want +got):\n%s", tc.reason, diff)
+				}
+				if got := classifyFailure(err, nil); got != FailureSyntax {
+					t.Errorf("\n%s\nUnexpected failure kind %q, want %q", tc.reason, got, FailureSyntax)
+				}
+			case want != nil:
+				if err == nil || !strings.HasPrefix(err.Error(), want.Error()) {
+					t.Errorf("\n%s\nUnexpected error %v, want %v", tc.reason, err, want)
+				}
+				// nothing is left behind
+				if _, err := cs.BatchV1().Jobs(e.Namespace).Get(context.Background(), jobNamePrefix+id, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+					t.Errorf("\n%s\nThe Job should not be left behind, got error %v", tc.reason, err)
+				}
+				if _, err := cs.CoreV1().Secrets(e.Namespace).Get(context.Background(), jobNamePrefix+id, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+					t.Errorf("\n%s\nThe Secret should not be left behind, got error %v", tc.reason, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("\n%s\nUnexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.wantLogs, stdout.String()); diff != "" {
+				t.Errorf("\n%s\nUnexpected logs (-want +got):\n%s", tc.reason, diff)
+			}
+
+			var created *batchv1.Job
+			var secret *corev1.Secret
+			deleted, owned := false, false
+			for _, a := range cs.Actions() {
+				switch a := a.(type) {
+				case k8stesting.CreateAction:
+					switch o := a.GetObject().(type) {
+					case *batchv1.Job:
+						if secret == nil {
+							t.Errorf("\n%s\nThe Secret should be created before the Job", tc.reason)
+						}
+						created = o
+					case *corev1.Secret:
+						secret = o
+					}
+				case k8stesting.PatchAction:
+					owned = owned || (a.GetResource().Resource == "secrets" && strings.Contains(string(a.GetPatch()), `"kind":"Job"`))
+				case k8stesting.DeleteAction:
+					deleted = deleted || a.GetResource().Resource == "jobs"
+				}
+			}
+			if !owned {
+				t.Errorf("\n%s\nThe Secret should be owned by the Job once it is created", tc.reason)
+			}
+			if created == nil || secret == nil {
+				t.Fatalf("\n%s\nThe Job and its Secret should be created", tc.reason)
+			}
+			c := created.Spec.Template.Spec.Containers[0]
+			if diff := cmp.Diff([]string{"/usr/local/bin/ansible-runner", "run", "/ansibleDir/uid", "-p", "playbook.yml"}, c.Command); diff != "" {
+				t.Errorf("\n%s\nUnexpected command (-want +got):\n%s", tc.reason, diff)
+			}
+			wantMounts := []corev1.VolumeMount{
+				{Name: jobWorkDirVolume, MountPath: "/ansibleDir/uid", SubPath: "uid"},
+				{Name: jobWorkDirVolume, MountPath: "/ansibleDir/.cache/collections/default", SubPath: ".cache/collections/default", ReadOnly: true},
+				{Name: jobCredsVolume, MountPath: key, SubPath: "file.0", ReadOnly: true},
+			}
+			if diff := cmp.Diff(wantMounts, c.VolumeMounts); diff != "" {
+				t.Errorf("\n%s\nUnexpected mounts (-want +got):\n%s", tc.reason, diff)
+			}
+			wantData := map[string][]byte{"ANSIBLE_INVENTORY": []byte("/ansibleDir/uid/inventory/hosts"), "file.0": []byte("private key")}
+			if diff := cmp.Diff(wantData, secret.Data); diff != "" {
+				t.Errorf("\n%s\nUnexpected Secret data (-want +got):\n%s", tc.reason, diff)
+			}
+			wantEnv := []corev1.EnvVar{{Name: "ANSIBLE_INVENTORY", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name}, Key: "ANSIBLE_INVENTORY"},
+			}}}
+			if diff := cmp.Diff(wantEnv, c.Env); diff != "" {
+				t.Errorf("\n%s\nUnexpected environment (-want +got):\n%s", tc.reason, diff)
+			}
+			ps := created.Spec.Template.Spec
+			if diff := cmp.Diff(e.NodeSelector, ps.NodeSelector); diff != "" {
+				t.Errorf("\n%s\nUnexpected node selector (-want +got):\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(e.Tolerations, ps.Tolerations); diff != "" {
+				t.Errorf("\n%s\nUnexpected tolerations (-want +got):\n%s", tc.reason, diff)
+			}
+			wantSC := &corev1.PodSecurityContext{
+				RunAsUser:           ptr.To(int64(65532)),
+				RunAsGroup:          ptr.To(int64(65532)),
+				RunAsNonRoot:        ptr.To(true),
+				FSGroup:             ptr.To(int64(65532)),
+				FSGroupChangePolicy: ptr.To(corev1.FSGroupChangeOnRootMismatch),
+			}
+			if diff := cmp.Diff(wantSC, ps.SecurityContext); diff != "" {
+				t.Errorf("\n%s\nUnexpected security context (-want +got):\n%s", tc.reason, diff)
+			}
+			if c.SecurityContext == nil || c.SecurityContext.AllowPrivilegeEscalation == nil || *c.SecurityContext.AllowPrivilegeEscalation {
+				t.Errorf("\n%s\nThe container should not allow privilege escalation", tc.reason)
+			}
+			if deleted != tc.wantDelete {
+				t.Errorf("\n%s\nJob deleted: %t, want %t", tc.reason, deleted, tc.wantDelete)
+			}
+		})
+	}
+}
+
+func TestJobEnv(t *testing.T) {
+	r := &Runner{behaviorVars: map[string]string{"AWS_ACCESS_KEY_ID": "key"}}
+	env := []string{"PROVIDER_TOKEN=secret", "HOME=/root", "AWS_ACCESS_KEY_ID=first", "AWS_ACCESS_KEY_ID=key", "HOME=/ansibleDir/uid/home"}
+	want := map[string][]byte{"AWS_ACCESS_KEY_ID": []byte("key"), "HOME": []byte("/ansibleDir/uid/home")}
+	if diff := cmp.Diff(want, jobEnv(env, r.jobEnvAllowList())); diff != "" {
+		t.Errorf("Unexpected environment (-want +got):\n%s", diff)
+	}
+}
+
+func TestCredentialFiles(t *testing.T) {
+	dir := t.TempDir()
+	r := &Runner{workDir: dir}
+	for _, f := range []string{filepath.Join(runnerutil.VaultDir, "prod"), filepath.Join(runnerutil.SSHDir, "id_rsa"), filepath.Join(runnerutil.EnvDir, runnerutil.Passwords), filepath.Join(runnerutil.EnvDir, "extravars")} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("secret"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		filepath.Join(dir, runnerutil.VaultDir, "prod"),
+		filepath.Join(dir, runnerutil.SSHDir, "id_rsa"),
+		filepath.Join(dir, runnerutil.EnvDir, runnerutil.Passwords),
+	}
+	if diff := cmp.Diff(want, r.credentialFiles()); diff != "" {
+		t.Errorf("Unexpected credential files (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+const (
+	errWriteMetadata = "cannot write the metadata of the run"
+
+	// MetadataFile is the file, in the artifacts directory of each run,
+	// tracing the run back to its AnsibleRun and to the reconcile that ran it.
+	MetadataFile = "crossplane-metadata.json"
+
+	// redacted replaces the sensitive values of the recorded command lines
+	// and environment
+	redacted = "<redacted>"
+)
+
+// sensitiveWords mark the environment variables and arguments whose values
+// are secrets, unless they name a file holding them.
+var sensitiveWords = []string{"PASSWORD", "PASSWD", "TOKEN", "SECRET", "ACCESS_KEY", "API_KEY"}
+
+// Triggers of the runs, telling why the provider ran the contents.
+const (
+	// TriggerObserve is the check mode run of the CheckWhenObserve policy
+	TriggerObserve = "observe"
+	// TriggerFirstRun is the first run of an AnsibleRun
+	TriggerFirstRun = "first-run"
+	// TriggerAdopt is the check mode run adopting an existing state
+	TriggerAdopt = "adopt"
+	// TriggerSpecChanged is a run following a change of the parameters
+	TriggerSpecChanged = "spec-changed"
+	// TriggerRolesMoved is a run following a move of the floating version
+	// of a role
+	TriggerRolesMoved = "roles-moved"
+	// TriggerVarsFromChanged is a run following a change of the Secrets and
+	// ConfigMaps the vars are read from
+	TriggerVarsFromChanged = "vars-from-changed"
+	// TriggerExtraVarsChanged is a run following a change of the extra vars
+	// of the ProviderConfig
+	TriggerExtraVarsChanged = "extra-vars-changed"
+	// TriggerRetry is a run retrying a failed run or reconcile
+	TriggerRetry = "retry"
+	// TriggerDrift is a run correcting the changes found by a check mode run
+	TriggerDrift = "drift"
+	// TriggerDeletion is the run of the absent state of a deleted AnsibleRun
+	TriggerDeletion = "deletion"
+	// TriggerAdmin is a run forced by an operator through the admin API
+	TriggerAdmin = "admin"
+	// TriggerAlwaysApply is a run of the AlwaysApply policy once its poll
+	// interval elapsed
+	TriggerAlwaysApply = "always-apply"
+)
+
+// RunMetadata traces the artifacts of a run, wherever they are found, back to
+// the AnsibleRun and to the reconcile that ran it.
+type RunMetadata struct {
+	// Name of the AnsibleRun
+	Name string `json:"name"`
+	// UID of the AnsibleRun
+	UID string `json:"uid"`
+	// Generation of the AnsibleRun that was run
+	Generation int64 `json:"generation"`
+	// SpecHash is the digest of the parameters of the AnsibleRun
+	SpecHash string `json:"specHash"`
+	// ContentHash is the digest of the contents, requirements and vars
+	ContentHash string `json:"contentHash,omitempty"`
+	// State requested by the provider, either present or absent
+	State string `json:"state"`
+	// CheckMode tells whether the contents were run in check mode
+	CheckMode bool `json:"checkMode"`
+	// Trigger tells why the provider ran the contents
+	Trigger string `json:"trigger,omitempty"`
+	// Command is the ansible-runner command line of the run, sanitized
+	Command []string `json:"command,omitempty"`
+	// Env are the environment variables of the run configuring Ansible,
+	// sanitized, leaving out the other ones, e.g. the credentials of the
+	// modules
+	Env map[string]string `json:"env,omitempty"`
+	// Cmdline are the ansible-playbook arguments of env/cmdline, sanitized
+	Cmdline string `json:"cmdline,omitempty"`
+	// Time the run started
+	Time time.Time `json:"time"`
+}
+
+// withResource sets the AnsibleRun the runs are traced back to.
+func withResource(cr *v1alpha1.AnsibleRun) runnerOption {
+	return func(r *Runner) {
+		r.uid = string(cr.GetUID())
+		r.generation = cr.GetGeneration()
+		r.specHash = specHash(cr.Spec.ForProvider)
+	}
+}
+
+// SetTrigger sets why the next runs are run, until it is set again.
+func (r *Runner) SetTrigger(trigger string) {
+	r.trigger = trigger
+}
+
+// writeMetadata writes the metadata of the run with the supplied ident and
+// command to its artifacts directory, which ansible-runner reuses when it
+// exists.
+func (r *Runner) writeMetadata(id string, dc *exec.Cmd) error {
+	dir := r.artifactsPath(id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("%s: %w", errWriteMetadata, err)
+	}
+	b, err := json.MarshalIndent(RunMetadata{
+		Name:        r.name,
+		UID:         r.uid,
+		Generation:  r.generation,
+		SpecHash:    r.specHash,
+		ContentHash: r.contentHash,
+		State:       r.state,
+		CheckMode:   r.checkMode,
+		Trigger:     r.trigger,
+		Command:     sanitizeArgs(dc.Args),
+		Env:         sanitizeEnv(dc.Env),
+		Cmdline:     runnerutil.ShellQuote(sanitizeArgs(r.cmdline())),
+		Time:        time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteMetadata, err)
+	}
+	if err := addFile(filepath.Join(dir, MetadataFile), append(b, '\n')); err != nil {
+		return fmt.Errorf("%s: %w", errWriteMetadata, err)
+	}
+	return nil
+}
+
+// specHash returns the sha256 digest of the supplied parameters, or an empty
+// string when they cannot be marshaled.
+func specHash(p v1alpha1.AnsibleRunParameters) string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return contentHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// sanitizeEnv returns the supplied environment of a run, the later variables
+// overriding the earlier ones, keeping only the ones configuring Ansible and
+// the home directory, with their secrets redacted.
+func sanitizeEnv(env []string) map[string]string {
+	vars := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if k != homeEnv && k != remoteTempEnv && !knownVars[k] && !hasKnownVarPrefix(k) {
+			continue
+		}
+		if sensitive(k) {
+			v = redacted
+		}
+		vars[k] = v
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	return vars
+}
+
+// sanitizeArgs returns a copy of the supplied arguments with the values of the
+// sensitive ones redacted, whether passed as name=value, --name=value or
+// --name value.
+func sanitizeArgs(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	sanitized := make([]string, len(args))
+	for i, arg := range args {
+		sanitized[i] = arg
+		if name, _, ok := strings.Cut(arg, "="); ok && sensitive(name) {
+			sanitized[i] = name + "=" + redacted
+			continue
+		}
+		if i > 0 && strings.HasPrefix(args[i-1], "-") && !strings.Contains(args[i-1], "=") && sensitive(args[i-1]) && !strings.HasPrefix(arg, "-") {
+			sanitized[i] = redacted
+		}
+	}
+	return sanitized
+}
+
+// sensitive tells whether the supplied variable or argument name holds a
+// secret, rather than the path of a file holding it.
+func sensitive(name string) bool {
+	n := strings.ToUpper(strings.TrimLeft(name, "-"))
+	n = strings.ReplaceAll(n, "-", "_")
+	if strings.HasSuffix(n, "_FILE") || strings.HasSuffix(n, "_PATH") || strings.HasSuffix(n, "_LIST") {
+		return false
+	}
+	for _, w := range sensitiveWords {
+		if strings.Contains(n, w) {
+			return true
+		}
+	}
+	return false
+}
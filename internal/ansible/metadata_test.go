@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+func TestRunMetadata(t *testing.T) {
+	id := "b2d3c1a0-3d3a-4c1e-9d2f-5a6b7c8d9e0f"
+	generateUUID = func() uuid.UUID { return uuid.MustParse(id) }
+	defer func() { generateUUID = uuid.New }()
+
+	playbook := "- hosts: all"
+	cr := &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", UID: "test-uid", Generation: 3},
+		Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+			PlaybookInline: &playbook,
+		}},
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "env"), 0700); err != nil {
+		t.Fatalf("Creating env dir: %v", err)
+	}
+	behaviorVars := map[string]string{
+		"ANSIBLE_FORKS":                   "5",
+		"ANSIBLE_GALAXY_SERVER_HUB_TOKEN": "s3cr3t",
+		"AWS_SECRET_ACCESS_KEY":           "s3cr3t",
+	}
+	runner := new(withWorkDir(dir), withStateVar(cr.GetName(), DefaultStateVar, false), withResource(cr),
+		withBehaviorVars(behaviorVars),
+		withCmdline(cmdlineOptions{extraArgs: []string{"--user", "deploy"}}),
+		withCmdFunc(func(ctx context.Context, vars map[string]string) *exec.Cmd {
+			dc := exec.CommandContext(ctx, "true")
+			dc.Env = append(dc.Env, runnerutil.ConvertMapToSlice(vars)...)
+			return dc
+		}))
+	runner.SetTrigger(TriggerSpecChanged)
+
+	if err := runner.Apply(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "artifacts", id, MetadataFile))
+	if err != nil {
+		t.Fatalf("Reading the metadata of the run: %v", err)
+	}
+	got := RunMetadata{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshaling the metadata of the run: %v", err)
+	}
+	want := RunMetadata{
+		Name:        "test",
+		UID:         "test-uid",
+		Generation:  3,
+		SpecHash:    specHash(cr.Spec.ForProvider),
+		ContentHash: runner.ContentHash(),
+		State:       StatePresent,
+		Trigger:     TriggerSpecChanged,
+		Command:     []string{"true", "--rotate-artifacts", "0", "--ident", id},
+		Env: map[string]string{
+			"ANSIBLE_FORKS":                   "5",
+			"ANSIBLE_GALAXY_SERVER_HUB_TOKEN": redacted,
+		},
+		Cmdline: "--user deploy",
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(RunMetadata{}, "Time")); diff != "" {
+		t.Errorf("Unexpected metadata (-want +got):\n%s", diff)
+	}
+	if got.Time.IsZero() {
+		t.Errorf("Expected the time of the run to be recorded")
+	}
+	if !strings.HasPrefix(got.SpecHash, contentHashPrefix) {
+		t.Errorf("Unexpected spec hash %q", got.SpecHash)
+	}
+}
+
+func TestSanitizeArgs(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		args   []string
+		want   []string
+	}{
+		"None": {
+			reason: "We should record no arguments without any",
+		},
+		"Plain": {
+			reason: "We should keep the arguments holding no secrets, including the paths of the files holding them",
+			args:   []string{"--user", "deploy", "--vault-password-file", "/vault", "--private-key", "/id_rsa", "ANSIBLE_HOST_KEY_CHECKING=false"},
+			want:   []string{"--user", "deploy", "--vault-password-file", "/vault", "--private-key", "/id_rsa", "ANSIBLE_HOST_KEY_CHECKING=false"},
+		},
+		"Sensitive": {
+			reason: "We should redact the values of the sensitive arguments, however they are passed",
+			args:   []string{"--api-token", "s3cr3t", "--api-token=s3cr3t", "db_password=s3cr3t", "--token", "--check"},
+			want:   []string{"--api-token", redacted, "--api-token=" + redacted, "db_password=" + redacted, "--token", "--check"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, sanitizeArgs(tc.args)); diff != "" {
+				t.Errorf("\n%s\nsanitizeArgs(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	errPlaybooksPlaybook = "cannot write the playbook running the playbooks"
+	errPlaybookName      = "duplicate playbook name"
+	errPlaybookSource    = "exactly one of inline or path must be set for playbook"
+	errPlaybookPath      = "invalid path of playbook"
+
+	// playbookMarker prefixes the names of the plays without tasks started
+	// before each playbook, so that the job events tell which playbook a
+	// task belongs to
+	playbookMarker = "Run playbook "
+
+	// playbooksPlaybookHeader documents the generated playbooks playbook
+	playbooksPlaybookHeader = `# Playbook running the playbooks of the AnsibleRun in order, generated by
+# provider-ansible.
+`
+)
+
+// InlinePlaybookPath returns the path, relative to the project directory, the
+// inline playbook with the supplied name is written to.
+func InlinePlaybookPath(name string) string {
+	return filepath.Join(runnerutil.InlinePlaybooksDir, name+".yml")
+}
+
+// playbookPaths returns the paths of the supplied playbooks relative to the
+// project directory, in order.
+func playbookPaths(playbooks []v1alpha1.Playbook) ([]string, error) {
+	paths := make([]string, 0, len(playbooks))
+	names := make(map[string]bool, len(playbooks))
+	for _, pb := range playbooks {
+		if names[pb.Name] {
+			return nil, fmt.Errorf("%s: %s", errPlaybookName, pb.Name)
+		}
+		names[pb.Name] = true
+		switch {
+		case (pb.Inline == nil) == (pb.Path == ""):
+			return nil, fmt.Errorf("%s %s", errPlaybookSource, pb.Name)
+		case pb.Inline != nil:
+			paths = append(paths, InlinePlaybookPath(pb.Name))
+		case !filepath.IsLocal(pb.Path):
+			return nil, fmt.Errorf("%s %s: %q", errPlaybookPath, pb.Name, pb.Path)
+		default:
+			paths = append(paths, filepath.Clean(pb.Path))
+		}
+	}
+	return paths, nil
+}
+
+// writePlaybooksPlaybook writes the playbook importing the supplied playbooks
+// in order into the project directory, each of them preceded by a play
+// without tasks naming it.
+func (p Parameters) writePlaybooksPlaybook(playbooks []v1alpha1.Playbook) ([]string, error) {
+	paths, err := playbookPaths(playbooks)
+	if err != nil {
+		return nil, err
+	}
+	plays := make([]map[string]any, 0, 2*len(playbooks))
+	for i, pb := range playbooks {
+		plays = append(plays, map[string]any{
+			"name":         playbookMarker + pb.Name,
+			"hosts":        "all",
+			"gather_facts": false,
+			"tasks":        []any{},
+		}, map[string]any{
+			"import_playbook": paths[i],
+		})
+	}
+	out, err := yaml.Marshal(plays)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errPlaybooksPlaybook, err)
+	}
+	if err := os.MkdirAll(p.projectDir(), 0700); resource.Ignore(os.IsExist, err) != nil {
+		return nil, fmt.Errorf("%s: %s: %w", p.projectDir(), errMkdir, err)
+	}
+	if err := addFile(filepath.Join(p.projectDir(), runnerutil.PlaybooksYml), append([]byte(playbooksPlaybookHeader), out...)); err != nil {
+		return nil, fmt.Errorf("%s: %w", errPlaybooksPlaybook, err)
+	}
+	return paths, nil
+}
+
+// failedPlaybook returns the name of the playbook the first failed task of a
+// run belongs to, from the play naming it started last before the task. It
+// is empty when the run didn't run several playbooks, or no task failed.
+func failedPlaybook(evts []jobEvent) string {
+	var playbook string
+	for _, evt := range evts {
+		switch evt.Event {
+		case eventTypePlayStart:
+			var evtData runnerEventData
+			if reunmarshal(evt.EventData, &evtData) != nil {
+				continue
+			}
+			if name, ok := strings.CutPrefix(evtData.Play, playbookMarker); ok {
+				playbook = name
+			}
+		case eventTypeRunnerFailed, eventTypeRunnerUnreachable:
+			var evtData runnerEventData
+			if reunmarshal(evt.EventData, &evtData) != nil || evtData.IgnoreErrors {
+				continue
+			}
+			return playbook
+		}
+	}
+	return ""
+}
+
+// describeFailedPlaybook prefixes the supplied failure reason of a run with
+// the playbook it failed in, if any.
+func describeFailedPlaybook(playbook, reason string) string {
+	if playbook == "" {
+		return reason
+	}
+	if reason == "" {
+		return fmt.Sprintf("playbook %q failed", playbook)
+	}
+	return fmt.Sprintf("playbook %q: %s", playbook, reason)
+}
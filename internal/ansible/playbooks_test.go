@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+func TestPlaybookPaths(t *testing.T) {
+	inline := "- hosts: all"
+
+	type want struct {
+		paths []string
+		err   bool
+	}
+
+	cases := map[string]struct {
+		reason    string
+		playbooks []v1alpha1.Playbook
+		want      want
+	}{
+		"InlineAndPath": {
+			reason:    "Inline playbooks should be found in their directory, the others at their path",
+			playbooks: []v1alpha1.Playbook{{Name: "setup", Inline: &inline}, {Name: "deploy", Path: "./site/deploy.yml"}},
+			want:      want{paths: []string{"inline/setup.yml", "site/deploy.yml"}},
+		},
+		"DuplicateName": {
+			reason:    "The names of the playbooks should be unique",
+			playbooks: []v1alpha1.Playbook{{Name: "setup", Inline: &inline}, {Name: "setup", Path: "setup.yml"}},
+			want:      want{err: true},
+		},
+		"InlineAndPathSet": {
+			reason:    "A playbook should not be both inline and at a path",
+			playbooks: []v1alpha1.Playbook{{Name: "setup", Inline: &inline, Path: "setup.yml"}},
+			want:      want{err: true},
+		},
+		"NeitherSet": {
+			reason:    "A playbook should be either inline or at a path",
+			playbooks: []v1alpha1.Playbook{{Name: "setup"}},
+			want:      want{err: true},
+		},
+		"OutsideProject": {
+			reason:    "The path of a playbook should not leave the project directory",
+			playbooks: []v1alpha1.Playbook{{Name: "setup", Path: "../setup.yml"}},
+			want:      want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			paths, err := playbookPaths(tc.playbooks)
+			if (err != nil) != tc.want.err {
+				t.Fatalf("\n%s\nplaybookPaths(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.paths, paths); diff != "" {
+				t.Errorf("\n%s\nplaybookPaths(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWritePlaybooksPlaybook(t *testing.T) {
+	p := Parameters{WorkingDirPath: t.TempDir()}
+	inline := "- hosts: all"
+	if _, err := p.writePlaybooksPlaybook([]v1alpha1.Playbook{{Name: "setup", Inline: &inline}, {Name: "deploy", Path: "deploy.yml"}}); err != nil {
+		t.Fatalf("writePlaybooksPlaybook(...): unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.projectDir(), runnerutil.PlaybooksYml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := yaml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	marker := func(name string) map[string]any {
+		return map[string]any{"name": "Run playbook " + name, "hosts": "all", "gather_facts": false, "tasks": []any{}}
+	}
+	want := []map[string]any{
+		marker("setup"),
+		{"import_playbook": "inline/setup.yml"},
+		marker("deploy"),
+		{"import_playbook": "deploy.yml"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("writePlaybooksPlaybook(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestFailedPlaybook(t *testing.T) {
+	play := func(name string) jobEvent {
+		return jobEvent{Event: eventTypePlayStart, EventData: map[string]any{"play": name}}
+	}
+	failed := func(ignoreErrors bool) jobEvent {
+		return jobEvent{Event: eventTypeRunnerFailed, EventData: map[string]any{"task": "test", "ignore_errors": ignoreErrors}}
+	}
+
+	cases := map[string]struct {
+		reason string
+		evts   []jobEvent
+		want   string
+	}{
+		"FailedPlaybook": {
+			reason: "The playbook started last before the failed task should have failed",
+			evts:   []jobEvent{play("Run playbook setup"), play("setup"), play("Run playbook deploy"), play("deploy"), failed(false)},
+			want:   "deploy",
+		},
+		"IgnoredFailure": {
+			reason: "Ignored failures should not fail the playbook",
+			evts:   []jobEvent{play("Run playbook setup"), failed(true), play("Run playbook deploy"), failed(false)},
+			want:   "deploy",
+		},
+		"NoFailure": {
+			reason: "No playbook should have failed without failed tasks",
+			evts:   []jobEvent{play("Run playbook setup")},
+		},
+		"SinglePlaybook": {
+			reason: "No playbook should be reported when the run didn't run several playbooks",
+			evts:   []jobEvent{play("setup"), failed(false)},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, failedPlaybook(tc.evts)); diff != "" {
+				t.Errorf("\n%s\nfailedPlaybook(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	errPolicyViolation = "contents violate the policy of the ProviderConfig"
+	errScanContents    = "cannot scan contents for denied modules"
+)
+
+// keys of plays and tasks holding lists of tasks, in their order of execution
+var taskListKeys = []string{"pre_tasks", "tasks", "post_tasks", "handlers", "block", "rescue", "always"}
+
+// SetDeniedModules sets the modules the contents are not allowed to use, by
+// short name, e.g. shell, or by fully qualified name, e.g. ansible.builtin.shell.
+func (r *Runner) SetDeniedModules(modules []string) {
+	r.deniedModules = modules
+}
+
+// checkPolicy scans the contents of the runner for tasks using a denied
+// module, and returns a policy violation RunError when it finds any.
+func (r *Runner) checkPolicy() error {
+	if len(r.deniedModules) == 0 {
+		return nil
+	}
+	var violations []string
+	for _, p := range r.contentPaths {
+		v, err := scanDeniedModules(p, r.deniedModules)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errScanContents, err)
+		}
+		violations = append(violations, v...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &RunError{
+		Kind:   FailurePolicyViolation,
+		Reason: strings.Join(violations, "; "),
+		Err:    errors.New(errPolicyViolation),
+	}
+}
+
+// scanDeniedModules statically scans the YAML files under root, a file or a
+// directory, and describes the tasks that use one of the denied modules.
+func scanDeniedModules(root string, denied []string) ([]string, error) {
+	var violations []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (filepath.Ext(path) != ".yml" && filepath.Ext(path) != ".yaml") {
+			return nil
+		}
+		b, err := os.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		var content interface{}
+		if err := yaml.Unmarshal(b, &content); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			rel = filepath.Base(path)
+		}
+		// playbooks and task files are lists, of plays or tasks
+		if items, ok := content.([]interface{}); ok {
+			violations = append(violations, scanTasks(rel, items, denied)...)
+		}
+		return nil
+	})
+	return violations, err
+}
+
+// scanTasks describes the tasks of the supplied list, and of the lists they
+// hold, that use one of the denied modules. Plays are scanned as tasks too,
+// none of their keys being a module.
+func scanTasks(file string, items []interface{}, denied []string) []string {
+	var violations []string
+	for _, item := range items {
+		task, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := task["name"].(string)
+		modules := make([]string, 0, len(task))
+		for k, v := range task {
+			switch k {
+			case "action", "local_action":
+				modules = append(modules, actionModule(v))
+			default:
+				modules = append(modules, k)
+			}
+		}
+		sort.Strings(modules)
+		for _, m := range modules {
+			if isDenied(m, denied) {
+				violations = append(violations, fmt.Sprintf("module %q is denied, used by task %q in %s", m, name, file))
+			}
+		}
+		for _, k := range taskListKeys {
+			if l, ok := task[k].([]interface{}); ok {
+				violations = append(violations, scanTasks(file, l, denied)...)
+			}
+		}
+	}
+	return violations
+}
+
+// actionModule returns the module of the supplied action or local_action
+// value, either "module args" or a map with a module key.
+func actionModule(v interface{}) string {
+	switch a := v.(type) {
+	case string:
+		if f := strings.Fields(a); len(f) > 0 {
+			return f[0]
+		}
+	case map[string]interface{}:
+		if m, ok := a["module"].(string); ok {
+			return m
+		}
+	}
+	return ""
+}
+
+// isDenied tells whether the module is denied. A short name denies the
+// module whatever its collection, e.g. shell denies ansible.builtin.shell.
+func isDenied(module string, denied []string) bool {
+	if module == "" {
+		return false
+	}
+	short := module[strings.LastIndex(module, ".")+1:]
+	for _, d := range denied {
+		if module == d || (!strings.Contains(d, ".") && short == d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScanDeniedModules(t *testing.T) {
+	playbook := `---
+- hosts: all
+  pre_tasks:
+    - name: prepare
+      ansible.builtin.shell: echo prepare
+  tasks:
+    - name: create user
+      ansible.builtin.user:
+        name: test
+        shell: /bin/bash
+    - block:
+        - name: fetch
+          uri:
+            url: https://example.com
+      rescue:
+        - name: report
+          action: raw echo failed
+    - name: notify
+      local_action:
+        module: ansible.builtin.command
+        cmd: echo done
+`
+	roleTasks := `---
+- name: role task
+  community.general.shell: echo role
+`
+
+	cases := map[string]struct {
+		files  map[string]string
+		denied []string
+		want   []string
+	}{
+		"NoViolation": {
+			files:  map[string]string{"playbook.yml": playbook},
+			denied: []string{"script"},
+		},
+		"ShortNames": {
+			files:  map[string]string{"playbook.yml": playbook},
+			denied: []string{"shell", "raw", "command"},
+			want: []string{
+				`module "ansible.builtin.shell" is denied, used by task "prepare" in playbook.yml`,
+				`module "raw" is denied, used by task "report" in playbook.yml`,
+				`module "ansible.builtin.command" is denied, used by task "notify" in playbook.yml`,
+			},
+		},
+		"FullyQualifiedNames": {
+			files:  map[string]string{"playbook.yml": playbook, "roles/myrole/tasks/main.yml": roleTasks},
+			denied: []string{"ansible.builtin.shell", "ansible.builtin.uri"},
+			want: []string{
+				`module "ansible.builtin.shell" is denied, used by task "prepare" in playbook.yml`,
+			},
+		},
+		"RoleTasks": {
+			files: map[string]string{
+				"roles/myrole/tasks/main.yml": roleTasks,
+				"roles/myrole/vars/main.yml":  "shell: /bin/sh\n",
+				"requirements.yml":            "collections: []\n",
+			},
+			denied: []string{"shell"},
+			want: []string{
+				`module "community.general.shell" is denied, used by task "role task" in roles/myrole/tasks/main.yml`,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for p, content := range tc.files {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0700); err != nil {
+					t.Fatalf("Creating dir: %v", err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, p), []byte(content), 0600); err != nil {
+					t.Fatalf("Writing %s: %v", p, err)
+				}
+			}
+
+			got, err := scanDeniedModules(dir, tc.denied)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Unexpected violations (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunPolicyViolation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "playbook.yml"), []byte("- hosts: all\n  tasks:\n    - shell: echo\n"), 0600); err != nil {
+		t.Fatalf("Writing playbook: %v", err)
+	}
+
+	runner := new(withWorkDir(dir), withContentPaths(filepath.Join(dir, "playbook.yml")), withCmdFunc(func(ctx context.Context, _ map[string]string) *exec.Cmd {
+		t.Fatalf("Violating contents should not be run")
+		return nil
+	}))
+	runner.SetDeniedModules([]string{"shell"})
+
+	_, err := runner.Run(context.Background())
+	var runErr *RunError
+	if !errors.As(err, &runErr) || runErr.Kind != FailurePolicyViolation {
+		t.Fatalf("Unexpected error %v, want a policy violation", err)
+	}
+	want := `contents violate the policy of the ProviderConfig: module "shell" is denied, used by task "" in playbook.yml`
+	if err.Error() != want {
+		t.Errorf("Unexpected error %q, want %q", err.Error(), want)
+	}
+}
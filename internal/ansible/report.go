@@ -0,0 +1,210 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	errWriteReport  = "cannot write the JUnit report of the run"
+	errUploadReport = "cannot upload the JUnit report of the run"
+
+	// ReportFile is the JUnit report of a run, in its artifacts directory.
+	ReportFile = "junit.xml"
+)
+
+// the uploads time out not to hold the reconciliation of the runs
+var reportClient = &http.Client{Timeout: 10 * time.Second}
+
+// SetReports sets whether the runs write a JUnit report, and the URL it is
+// uploaded to, none when empty.
+func (r *Runner) SetReports(junit bool, uploadURL string) {
+	r.reportJUnit = junit
+	r.reportUploadURL = uploadURL
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+// junitSeconds formats a duration in seconds the way JUnit reports do.
+func junitSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// junitReport returns the JUnit report of the run of the supplied AnsibleRun
+// started at start, from its job events: a test suite per play, and a test
+// case per task and host, named after the host and the task.
+func junitReport(name string, start time.Time, evts []jobEvent) ([]byte, error) {
+	report := junitTestSuites{Name: name}
+	suites := map[string]int{}
+	var seconds []float64
+	for _, evt := range evts {
+		switch evt.Event {
+		case eventTypeRunnerOk, eventTypeRunnerFailed, eventTypeRunnerUnreachable, eventTypeRunnerSkipped:
+		default:
+			continue
+		}
+		var d runnerEventData
+		if err := reunmarshal(evt.EventData, &d); err != nil {
+			return nil, err
+		}
+		classname := d.Play
+		if d.Role != "" {
+			classname = d.Play + "." + d.Role
+		}
+		tc := junitTestCase{
+			Name:      fmt.Sprintf("[%s] %s", d.Host, d.Task),
+			Classname: classname,
+			Time:      junitSeconds(d.Duration),
+		}
+		i, ok := suites[d.Play]
+		if !ok {
+			i = len(report.Suites)
+			suites[d.Play] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: d.Play, Timestamp: start.UTC().Format(time.RFC3339)})
+			seconds = append(seconds, 0)
+		}
+		suite := &report.Suites[i]
+		switch {
+		case evt.Event == eventTypeRunnerFailed && d.IgnoreErrors:
+			// the failures ignored by the contents don't fail the run
+			tc.SystemOut = fmt.Sprintf("failure ignored: %s", d.Result.Msg)
+		case evt.Event == eventTypeRunnerFailed:
+			tc.Failure = &junitProblem{Message: d.Result.Msg, Type: "failed"}
+			suite.Failures++
+		case evt.Event == eventTypeRunnerUnreachable:
+			tc.Error = &junitProblem{Message: d.Result.Msg, Type: "unreachable"}
+			suite.Errors++
+		case evt.Event == eventTypeRunnerSkipped:
+			tc.Skipped = &junitProblem{}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		seconds[i] += d.Duration
+	}
+	var total float64
+	for i := range report.Suites {
+		suite := &report.Suites[i]
+		suite.Time = junitSeconds(seconds[i])
+		total += seconds[i]
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+	}
+	report.Time = junitSeconds(total)
+	b, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// writeReport writes the JUnit report of the run with the supplied ident
+// into its artifacts, and uploads it, as the runner is configured to. The
+// report is written even when the run failed, since the failures are what
+// it reports.
+func (r *Runner) writeReport(ctx context.Context, id string, start time.Time, evts []jobEvent) error {
+	if !r.reportJUnit {
+		return nil
+	}
+	b, err := junitReport(r.name, start, evts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteReport, err)
+	}
+	if err := os.WriteFile(filepath.Join(r.artifactsPath(id), ReportFile), b, 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteReport, err)
+	}
+	if r.reportUploadURL == "" {
+		return nil
+	}
+	if err := uploadReport(ctx, r.reportUploadURL, r.name, r.uid, id, b); err != nil {
+		return fmt.Errorf("%s: %w", errUploadReport, err)
+	}
+	if r.lastRun != nil {
+		r.lastRun.ReportURL = r.reportUploadURL
+	}
+	return nil
+}
+
+// uploadReport POSTs the supplied JUnit report of the run with the supplied
+// ident of the supplied AnsibleRun to the supplied URL.
+func uploadReport(ctx context.Context, url, name, uid, id string, report []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(report))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("X-AnsibleRun-Name", name)
+	req.Header.Set("X-AnsibleRun-UID", uid)
+	req.Header.Set("X-Run-Ident", id)
+	resp, err := reportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
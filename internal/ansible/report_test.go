@@ -0,0 +1,166 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJUnitReport(t *testing.T) {
+	evts := []jobEvent{
+		{Event: eventTypeTaskStart, EventData: map[string]any{"play": "web", "task": "install"}},
+		{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "web", "task": "install", "host": "h1", "duration": 1.5}},
+		{Event: eventTypeRunnerFailed, EventData: map[string]any{"play": "web", "role": "nginx", "task": "start", "host": "h1", "duration": 0.5, "res": map[string]any{"msg": "no unit"}}},
+		{Event: eventTypeRunnerFailed, EventData: map[string]any{"play": "web", "task": "probe", "host": "h1", "ignore_errors": true, "res": map[string]any{"msg": "timed out"}}},
+		{Event: eventTypeRunnerUnreachable, EventData: map[string]any{"play": "db", "task": "install", "host": "h2", "res": map[string]any{"msg": "no route"}}},
+		{Event: eventTypeRunnerSkipped, EventData: map[string]any{"play": "db", "task": "migrate", "host": "h3"}},
+		{Event: eventTypePlaybookOnStats, EventData: map[string]any{}},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	b, err := junitReport("run", start, evts)
+	if err != nil {
+		t.Fatalf("junitReport(...): unexpected error: %v", err)
+	}
+	var got junitTestSuites
+	if err := xml.Unmarshal(b, &got); err != nil {
+		t.Fatalf("junitReport(...): invalid report: %v", err)
+	}
+
+	want := junitTestSuites{
+		XMLName:  xml.Name{Local: "testsuites"},
+		Name:     "run",
+		Tests:    5,
+		Failures: 1,
+		Errors:   1,
+		Skipped:  1,
+		Time:     "2.000",
+		Suites: []junitTestSuite{
+			{
+				Name: "web", Tests: 3, Failures: 1, Time: "2.000", Timestamp: "2024-01-01T00:00:00Z",
+				Cases: []junitTestCase{
+					{Name: "[h1] install", Classname: "web", Time: "1.500"},
+					{Name: "[h1] start", Classname: "web.nginx", Time: "0.500", Failure: &junitProblem{Message: "no unit", Type: "failed"}},
+					{Name: "[h1] probe", Classname: "web", Time: "0.000", SystemOut: "failure ignored: timed out"},
+				},
+			},
+			{
+				Name: "db", Tests: 2, Errors: 1, Skipped: 1, Time: "0.000", Timestamp: "2024-01-01T00:00:00Z",
+				Cases: []junitTestCase{
+					{Name: "[h2] install", Classname: "db", Time: "0.000", Error: &junitProblem{Message: "no route", Type: "unreachable"}},
+					{Name: "[h3] migrate", Classname: "db", Time: "0.000", Skipped: &junitProblem{}},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("junitReport(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	evts := []jobEvent{
+		{Event: eventTypeRunnerOk, EventData: map[string]any{"play": "web", "task": "install", "host": "h1"}},
+	}
+
+	cases := map[string]struct {
+		reason   string
+		junit    bool
+		status   int
+		upload   bool
+		wantFile bool
+		wantErr  bool
+	}{
+		"Disabled": {
+			reason: "No report should be written unless enabled",
+		},
+		"Written": {
+			reason:   "The report should be written into the artifacts of the run",
+			junit:    true,
+			wantFile: true,
+		},
+		"Uploaded": {
+			reason:   "The report should be uploaded when an upload URL is set",
+			junit:    true,
+			status:   http.StatusCreated,
+			upload:   true,
+			wantFile: true,
+		},
+		"UploadFailed": {
+			reason:   "A rejected upload should be an error, the report being written anyway",
+			junit:    true,
+			status:   http.StatusInternalServerError,
+			upload:   true,
+			wantFile: true,
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "artifacts", "id"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			var uploaded []byte
+			var headers http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				uploaded, _ = io.ReadAll(req.Body)
+				headers = req.Header
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			r := &Runner{workDir: dir, name: "run", uid: "uid"}
+			uploadURL := ""
+			if tc.upload {
+				uploadURL = srv.URL
+			}
+			r.SetReports(tc.junit, uploadURL)
+
+			err := r.writeReport(context.Background(), "id", time.Now(), evts)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("\n%s\nwriteReport(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			b, readErr := os.ReadFile(filepath.Join(dir, "artifacts", "id", ReportFile))
+			if gotFile := readErr == nil; gotFile != tc.wantFile {
+				t.Fatalf("\n%s\nwriteReport(...): want report %t, got %v", tc.reason, tc.wantFile, readErr)
+			}
+			if !tc.upload {
+				return
+			}
+			if diff := cmp.Diff(string(b), string(uploaded)); diff != "" {
+				t.Errorf("\n%s\nwriteReport(...): -written, +uploaded:\n%s", tc.reason, diff)
+			}
+			for k, want := range map[string]string{"Content-Type": "application/xml", "X-Ansiblerun-Name": "run", "X-Ansiblerun-Uid": "uid", "X-Run-Ident": "id"} {
+				if got := headers.Get(k); got != want {
+					t.Errorf("\n%s\nwriteReport(...): header %s: want %q, got %q", tc.reason, k, want, got)
+				}
+			}
+		})
+	}
+}
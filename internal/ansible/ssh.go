@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+// HostKeyCheckingEnv is the Ansible environment variable telling whether the
+// keys of the hosts of the SSH connections are checked.
+const HostKeyCheckingEnv = "ANSIBLE_HOST_KEY_CHECKING"
+
+// SSHOptions are the credentials of the SSH connections of the runs.
+type SSHOptions struct {
+	// PrivateKeyFile holds the private key
+	PrivateKeyFile string
+	// User the runs connect as, the one of the inventories or of ssh when
+	// empty
+	User string
+	// ConfigFile is the ssh configuration of the connections, e.g. their
+	// port and known hosts, none when empty
+	ConfigFile string
+}
+
+// SetSSH sets the credentials of the SSH connections of the runs, none when
+// nil.
+func (r *Runner) SetSSH(o *SSHOptions) {
+	r.ssh = o
+}
+
+// sshArgs returns the arguments passing the SSH credentials to
+// ansible-playbook.
+func (r *Runner) sshArgs() []string {
+	if r.ssh == nil {
+		return nil
+	}
+	args := []string{"--private-key", r.ssh.PrivateKeyFile}
+	if r.ssh.User != "" {
+		args = append(args, "--user", r.ssh.User)
+	}
+	if r.ssh.ConfigFile != "" {
+		// passed to scp and sftp as well
+		args = append(args, "--ssh-common-args", "-F "+r.ssh.ConfigFile)
+	}
+	return args
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSSHArgs(t *testing.T) {
+	cases := map[string]struct {
+		ssh  *SSHOptions
+		want []string
+	}{
+		"None": {},
+		"PrivateKey": {
+			ssh:  &SSHOptions{PrivateKeyFile: "/ansibleDir/uid/ssh/id"},
+			want: []string{"--private-key", "/ansibleDir/uid/ssh/id"},
+		},
+		"All": {
+			ssh: &SSHOptions{PrivateKeyFile: "/ansibleDir/uid/ssh/id", User: "deploy", ConfigFile: "/ansibleDir/uid/ssh/config"},
+			want: []string{
+				"--private-key", "/ansibleDir/uid/ssh/id",
+				"--user", "deploy",
+				"--ssh-common-args", "-F /ansibleDir/uid/ssh/config",
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := &Runner{}
+			r.SetSSH(tc.ssh)
+			if diff := cmp.Diff(tc.want, r.cmdline()); diff != "" {
+				t.Errorf("Unexpected cmdline (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
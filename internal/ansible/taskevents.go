@@ -0,0 +1,203 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Verbosities of the task events of the runs
+const (
+	TaskEventsNone     = "None"
+	TaskEventsFailures = "Failures"
+	TaskEventsChanges  = "Changes"
+	TaskEventsTasks    = "Tasks"
+)
+
+// Kinds of the task events of the runs
+const (
+	TaskStarted  = "TaskStarted"
+	TaskChanged  = "TaskChanged"
+	TaskRetrying = "TaskRetrying"
+	TaskFailed   = "TaskFailed"
+)
+
+// taskEventsPollInterval is how often the job events of a running run are
+// read for its task events
+const taskEventsPollInterval = 2 * time.Second
+
+// taskEventsVerbosity ranks the verbosities of the task events, an unset
+// verbosity reporting the failures.
+var taskEventsVerbosity = map[string]int{
+	TaskEventsNone:     0,
+	"":                 1,
+	TaskEventsFailures: 1,
+	TaskEventsChanges:  2,
+	TaskEventsTasks:    3,
+}
+
+// A TaskEvent reports the progress of a task of a run.
+type TaskEvent struct {
+	// Kind of the event, e.g. TaskFailed
+	Kind string
+	// Message describes the task, and its host and result when it ran on one
+	Message string
+}
+
+// TaskEventHandler is notified of the task events of a run while it runs.
+type TaskEventHandler func(ctx context.Context, e TaskEvent)
+
+// withTaskEvents sets the verbosity of the task events of the runs.
+func withTaskEvents(verbosity string) runnerOption {
+	return func(r *Runner) {
+		r.taskEvents = verbosity
+	}
+}
+
+// SetTaskEventHandler sets the handler notified of the task events of the runs.
+func (r *Runner) SetTaskEventHandler(h TaskEventHandler) {
+	r.taskEventHandler = h
+}
+
+// startTaskEvents notifies the task event handler of the task events of the
+// run with the supplied ident as its job events are written, until the
+// returned func is called. The job events written in the meantime are
+// notified before it returns.
+func (r *Runner) startTaskEvents(ctx context.Context, id string) func() {
+	if r.taskEventHandler == nil || taskEventsVerbosity[r.taskEvents] == 0 {
+		return func() {}
+	}
+	dir := filepath.Clean(filepath.Join(r.artifactsPath(id), "job_events"))
+	seen := make(map[string]bool)
+	notify := func() {
+		for _, evt := range newEvents(dir, seen) {
+			if e, ok := taskEvent(evt, r.taskEvents); ok {
+				r.taskEventHandler(ctx, e)
+			}
+		}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(taskEventsPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				notify()
+				return
+			case <-t.C:
+				notify()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// newEvents returns the job events of the supplied directory that are not in
+// seen, ordered by their counter, and adds them to seen. The events being
+// written, partial or not valid JSON yet, are left for the next call.
+func newEvents(dir string, seen map[string]bool) []jobEvent {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var evts []jobEvent
+	for _, file := range files {
+		name := file.Name()
+		if seen[name] || strings.HasSuffix(name, "-partial.json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Clean(filepath.Join(dir, name)))
+		if err != nil {
+			continue
+		}
+		var evt jobEvent
+		if err := json.Unmarshal(b, &evt); err != nil {
+			continue
+		}
+		seen[name] = true
+		evts = append(evts, evt)
+	}
+	sort.SliceStable(evts, func(i, j int) bool {
+		return evts[i].Counter < evts[j].Counter
+	})
+	return evts
+}
+
+// taskEvent returns the task event of the supplied job event, or false when
+// it has none or the supplied verbosity leaves it out.
+func taskEvent(evt jobEvent, verbosity string) (TaskEvent, bool) {
+	var kind string
+	var level int
+	switch evt.Event {
+	case eventTypeRunnerFailed, eventTypeRunnerUnreachable:
+		kind, level = TaskFailed, 1
+	case eventTypeRunnerRetry:
+		kind, level = TaskRetrying, 2
+	case eventTypeRunnerOk:
+		kind, level = TaskChanged, 2
+	case eventTypeTaskStart:
+		kind, level = TaskStarted, 3
+	default:
+		return TaskEvent{}, false
+	}
+	if taskEventsVerbosity[verbosity] < level {
+		return TaskEvent{}, false
+	}
+	var d runnerEventData
+	if err := reunmarshal(evt.EventData, &d); err != nil {
+		return TaskEvent{}, false
+	}
+
+	var msg string
+	switch evt.Event {
+	case eventTypeRunnerFailed, eventTypeRunnerUnreachable:
+		reason := "Failed"
+		if evt.Event == eventTypeRunnerUnreachable {
+			reason = "Unreachable"
+		}
+		// the ignored failures are reported as warnings after the run
+		m, ignored, err := runnerEventMessage(evt, reason)
+		if err != nil || ignored {
+			return TaskEvent{}, false
+		}
+		msg = m
+	case eventTypeRunnerRetry:
+		// worded like the retries in the output of ansible-playbook
+		msg = fmt.Sprintf("Retrying on play %q, %s, host %q: %d retries left", d.Play, d.describeTask(), d.Host, d.Result.Retries-d.Result.Attempts)
+	case eventTypeRunnerOk:
+		if !d.Result.Changed {
+			return TaskEvent{}, false
+		}
+		msg = fmt.Sprintf("Changed on play %q, %s, host %q", d.Play, d.describeTask(), d.Host)
+	case eventTypeTaskStart:
+		msg = fmt.Sprintf("Started on play %q, %s", d.Play, d.describeTask())
+	}
+	return TaskEvent{Kind: kind, Message: msg}, true
+}
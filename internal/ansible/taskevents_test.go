@@ -0,0 +1,160 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTaskEvent(t *testing.T) {
+	data := func(extra map[string]any) map[string]any {
+		d := map[string]any{"play": "site", "role": "nginx", "task": "install", "host": "web-1"}
+		for k, v := range extra {
+			d[k] = v
+		}
+		return d
+	}
+
+	cases := map[string]struct {
+		reason    string
+		evt       jobEvent
+		verbosity string
+		want      *TaskEvent
+	}{
+		"Failed": {
+			reason: "We should report the failed tasks by default",
+			evt:    jobEvent{Event: eventTypeRunnerFailed, EventData: data(map[string]any{"res": map[string]any{"msg": "boom"}})},
+			want:   &TaskEvent{Kind: TaskFailed, Message: `Failed on play "site", role "nginx", task "install", host "web-1": boom`},
+		},
+		"IgnoredFailure": {
+			reason:    "We should not report the failures of the tasks ignoring errors",
+			evt:       jobEvent{Event: eventTypeRunnerFailed, EventData: data(map[string]any{"ignore_errors": true})},
+			verbosity: TaskEventsTasks,
+		},
+		"Unreachable": {
+			reason:    "We should report the unreachable hosts as failures",
+			evt:       jobEvent{Event: eventTypeRunnerUnreachable, EventData: data(map[string]any{"res": map[string]any{"msg": "timed out"}})},
+			verbosity: TaskEventsFailures,
+			want:      &TaskEvent{Kind: TaskFailed, Message: `Unreachable on play "site", role "nginx", task "install", host "web-1": timed out`},
+		},
+		"NoEvents": {
+			reason:    "We should not report anything when the task events are disabled",
+			evt:       jobEvent{Event: eventTypeRunnerFailed, EventData: data(nil)},
+			verbosity: TaskEventsNone,
+		},
+		"ChangedLeftOut": {
+			reason:    "We should not report the changes when only reporting the failures",
+			evt:       jobEvent{Event: eventTypeRunnerOk, EventData: data(map[string]any{"res": map[string]any{"changed": true}})},
+			verbosity: TaskEventsFailures,
+		},
+		"Changed": {
+			reason:    "We should report the tasks that changed a host",
+			evt:       jobEvent{Event: eventTypeRunnerOk, EventData: data(map[string]any{"res": map[string]any{"changed": true}})},
+			verbosity: TaskEventsChanges,
+			want:      &TaskEvent{Kind: TaskChanged, Message: `Changed on play "site", role "nginx", task "install", host "web-1"`},
+		},
+		"Unchanged": {
+			reason:    "We should not report the tasks that left a host unchanged",
+			evt:       jobEvent{Event: eventTypeRunnerOk, EventData: data(nil)},
+			verbosity: TaskEventsTasks,
+		},
+		"Retrying": {
+			reason:    "We should report the retried tasks",
+			evt:       jobEvent{Event: eventTypeRunnerRetry, EventData: data(map[string]any{"res": map[string]any{"attempts": 2, "retries": 5}})},
+			verbosity: TaskEventsChanges,
+			want:      &TaskEvent{Kind: TaskRetrying, Message: `Retrying on play "site", role "nginx", task "install", host "web-1": 3 retries left`},
+		},
+		"StartedLeftOut": {
+			reason:    "We should not report the start of the tasks when only reporting the changes",
+			evt:       jobEvent{Event: eventTypeTaskStart, EventData: map[string]any{"play": "site", "task": "install"}},
+			verbosity: TaskEventsChanges,
+		},
+		"Started": {
+			reason:    "We should report the start of the tasks",
+			evt:       jobEvent{Event: eventTypeTaskStart, EventData: map[string]any{"play": "site", "task": "install"}},
+			verbosity: TaskEventsTasks,
+			want:      &TaskEvent{Kind: TaskStarted, Message: `Started on play "site", task "install"`},
+		},
+		"OtherEvent": {
+			reason:    "We should not report the events that are not about tasks",
+			evt:       jobEvent{Event: eventTypePlaybookOnStats},
+			verbosity: TaskEventsTasks,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got *TaskEvent
+			if e, ok := taskEvent(tc.evt, tc.verbosity); ok {
+				got = &e
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\ntaskEvent(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestStartTaskEvents(t *testing.T) {
+	const id = "run"
+	dir := t.TempDir()
+	eventsDir := filepath.Join(dir, "artifacts", id, "job_events")
+	if err := os.MkdirAll(eventsDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, evt jobEvent) {
+		b, err := json.Marshal(evt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(eventsDir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed := func(counter int, task string) jobEvent {
+		return jobEvent{Counter: counter, Event: eventTypeRunnerFailed, EventData: map[string]any{"play": "site", "task": task, "host": "web-1"}}
+	}
+	write("2-b.json", failed(2, "second"))
+	write("10-c.json", failed(10, "third"))
+	write("1-a.json", failed(1, "first"))
+	write("11-d-partial.json", failed(11, "partial"))
+	if err := os.WriteFile(filepath.Join(eventsDir, "12-e.json"), []byte(`{"counter": 12`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	r := &Runner{workDir: dir}
+	r.SetTaskEventHandler(func(_ context.Context, e TaskEvent) {
+		got = append(got, e.Message)
+	})
+	r.startTaskEvents(context.Background(), id)()
+
+	var want []string
+	for _, task := range []string{"first", "second", "third"} {
+		want = append(want, fmt.Sprintf(`Failed on play "site", task %q, host "web-1": `, task))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("startTaskEvents(...): -want, +got:\n%s", diff)
+	}
+}
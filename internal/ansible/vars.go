@@ -0,0 +1,139 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"sort"
+	"strings"
+)
+
+// ansibleVarPrefix prefixes the environment variables configuring Ansible.
+const ansibleVarPrefix = "ANSIBLE_"
+
+// knownVars are the environment variables configuring Ansible that behavior
+// vars commonly set.
+var knownVars = map[string]bool{
+	"ANSIBLE_ACTION_WARNINGS":                  true,
+	"ANSIBLE_ANY_ERRORS_FATAL":                 true,
+	"ANSIBLE_BECOME":                           true,
+	"ANSIBLE_BECOME_ASK_PASS":                  true,
+	"ANSIBLE_BECOME_EXE":                       true,
+	"ANSIBLE_BECOME_FLAGS":                     true,
+	"ANSIBLE_BECOME_METHOD":                    true,
+	"ANSIBLE_BECOME_PASSWORD_FILE":             true,
+	"ANSIBLE_BECOME_USER":                      true,
+	"ANSIBLE_CACHE_PLUGIN":                     true,
+	"ANSIBLE_CACHE_PLUGIN_CONNECTION":          true,
+	"ANSIBLE_CACHE_PLUGIN_TIMEOUT":             true,
+	"ANSIBLE_CALLBACKS_ENABLED":                true,
+	"ANSIBLE_COLLECTIONS_PATH":                 true,
+	"ANSIBLE_COLLECTIONS_SCAN_SYS_PATH":        true,
+	"ANSIBLE_COLLECTION_PATH":                  true,
+	"ANSIBLE_COMMAND_TIMEOUT":                  true,
+	"ANSIBLE_CONFIG":                           true,
+	"ANSIBLE_CONNECTION_PLUGINS":               true,
+	"ANSIBLE_CONNECT_TIMEOUT":                  true,
+	"ANSIBLE_DEBUG":                            true,
+	"ANSIBLE_DEPRECATION_WARNINGS":             true,
+	"ANSIBLE_DIFF_ALWAYS":                      true,
+	"ANSIBLE_DISPLAY_ARGS_TO_STDOUT":           true,
+	"ANSIBLE_DISPLAY_SKIPPED_HOSTS":            true,
+	"ANSIBLE_FILTER_PLUGINS":                   true,
+	"ANSIBLE_FORCE_COLOR":                      true,
+	"ANSIBLE_FORCE_HANDLERS":                   true,
+	"ANSIBLE_FORKS":                            true,
+	"ANSIBLE_GALAXY_IGNORE":                    true,
+	"ANSIBLE_GALAXY_SERVER":                    true,
+	"ANSIBLE_GALAXY_SERVER_LIST":               true,
+	"ANSIBLE_GALAXY_TOKEN_PATH":                true,
+	"ANSIBLE_GATHERING":                        true,
+	"ANSIBLE_GATHER_SUBSET":                    true,
+	"ANSIBLE_GATHER_TIMEOUT":                   true,
+	"ANSIBLE_HASH_BEHAVIOUR":                   true,
+	"ANSIBLE_HOST_KEY_CHECKING":                true,
+	"ANSIBLE_HOST_PATTERN_MISMATCH":            true,
+	"ANSIBLE_INVENTORY":                        true,
+	"ANSIBLE_INVENTORY_ANY_UNPARSED_IS_FAILED": true,
+	"ANSIBLE_INVENTORY_ENABLED":                true,
+	"ANSIBLE_INVENTORY_UNPARSED_FAILED":        true,
+	"ANSIBLE_JINJA2_NATIVE":                    true,
+	"ANSIBLE_KEEP_REMOTE_FILES":                true,
+	"ANSIBLE_LIBRARY":                          true,
+	"ANSIBLE_LOAD_CALLBACK_PLUGINS":            true,
+	"ANSIBLE_LOCAL_TEMP":                       true,
+	"ANSIBLE_LOG_PATH":                         true,
+	"ANSIBLE_LOOKUP_PLUGINS":                   true,
+	"ANSIBLE_MODULE_UTILS":                     true,
+	"ANSIBLE_NOCOLOR":                          true,
+	"ANSIBLE_NOCOWS":                           true,
+	"ANSIBLE_PIPELINING":                       true,
+	"ANSIBLE_PRIVATE_KEY_FILE":                 true,
+	"ANSIBLE_PYTHON_INTERPRETER":               true,
+	"ANSIBLE_REMOTE_PORT":                      true,
+	"ANSIBLE_REMOTE_TEMP":                      true,
+	"ANSIBLE_REMOTE_USER":                      true,
+	"ANSIBLE_RETRY_FILES_ENABLED":              true,
+	"ANSIBLE_ROLES_PATH":                       true,
+	"ANSIBLE_ROLE_PATH":                        true,
+	"ANSIBLE_SSH_ARGS":                         true,
+	"ANSIBLE_SSH_COMMON_ARGS":                  true,
+	"ANSIBLE_SSH_CONTROL_PATH_DIR":             true,
+	"ANSIBLE_SSH_EXTRA_ARGS":                   true,
+	"ANSIBLE_SSH_PIPELINING":                   true,
+	"ANSIBLE_SSH_RETRIES":                      true,
+	"ANSIBLE_STDOUT_CALLBACK":                  true,
+	"ANSIBLE_STRATEGY":                         true,
+	"ANSIBLE_SYSTEM_WARNINGS":                  true,
+	"ANSIBLE_TASK_TIMEOUT":                     true,
+	"ANSIBLE_TIMEOUT":                          true,
+	"ANSIBLE_TRANSPORT":                        true,
+	"ANSIBLE_VARS_PLUGINS":                     true,
+	"ANSIBLE_VAULT_IDENTITY_LIST":              true,
+	"ANSIBLE_VAULT_PASSWORD_FILE":              true,
+	"ANSIBLE_VERBOSITY":                        true,
+}
+
+// knownVarPrefixes prefix the environment variables named after the user
+// configuration, e.g. the Galaxy servers of ANSIBLE_GALAXY_SERVER_LIST.
+var knownVarPrefixes = []string{
+	"ANSIBLE_GALAXY_SERVER_",
+}
+
+// UnknownVars returns the keys of the supplied behavior vars that look like
+// the configuration of Ansible, being prefixed by ANSIBLE_, but are not known
+// to configure it, e.g. because of a typo, in order. Other vars, e.g. the
+// credentials of the modules, are never reported.
+func UnknownVars(vars map[string]string) []string {
+	var unknown []string
+	for k := range vars {
+		if !strings.HasPrefix(k, ansibleVarPrefix) || knownVars[k] || hasKnownVarPrefix(k) {
+			continue
+		}
+		unknown = append(unknown, k)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func hasKnownVarPrefix(k string) bool {
+	for _, p := range knownVarPrefixes {
+		if strings.HasPrefix(k, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnknownVars(t *testing.T) {
+	vars := map[string]string{
+		"ANSIBLE_FORKS":                     "10",
+		"ANSIBLE_FROKS":                     "10",
+		"ANSIBLE_HOST_KEY_CHEKING":          "False",
+		"ANSIBLE_GALAXY_SERVER_PRIVATE_URL": "https://galaxy.example.com",
+		"AWS_REGION":                        "eu-west-1",
+	}
+	want := []string{"ANSIBLE_FROKS", "ANSIBLE_HOST_KEY_CHEKING"}
+	if diff := cmp.Diff(want, UnknownVars(vars)); diff != "" {
+		t.Errorf("UnknownVars(...): -want, +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+// A VaultID is a password file of ansible-vault.
+type VaultID struct {
+	// Label is the vault ID the password decrypts, none when empty
+	Label string
+	// File holding the password
+	File string
+}
+
+// SetVaultIDs sets the vault password files decrypting the encrypted
+// contents and inventories of the runs.
+func (r *Runner) SetVaultIDs(ids []VaultID) {
+	r.vaultIDs = ids
+}
+
+// vaultArgs returns the arguments passing the vault password files to
+// ansible-playbook and ansible-inventory.
+func (r *Runner) vaultArgs() []string {
+	var args []string
+	for _, id := range r.vaultIDs {
+		if id.Label == "" {
+			args = append(args, "--vault-password-file", id.File)
+			continue
+		}
+		args = append(args, "--vault-id", id.Label+"@"+id.File)
+	}
+	return args
+}
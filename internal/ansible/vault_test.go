@@ -0,0 +1,39 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansible
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVaultArgs(t *testing.T) {
+	r := &Runner{checkMode: true}
+	r.SetVaultIDs([]VaultID{
+		{File: "/ansibleDir/uid/vault/0"},
+		{Label: "prod", File: "/ansibleDir/uid/vault/1"},
+	})
+	want := []string{
+		"--check",
+		"--vault-password-file", "/ansibleDir/uid/vault/0",
+		"--vault-id", "prod@/ansibleDir/uid/vault/1",
+	}
+	if diff := cmp.Diff(want, r.cmdline()); diff != "" {
+		t.Errorf("Unexpected cmdline (-want +got):\n%s", diff)
+	}
+}
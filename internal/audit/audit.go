@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records the runs of the provider in an append-only audit
+// log, independently of the retention of Kubernetes events.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	errOpenLog  = "cannot open audit log"
+	errWriteLog = "cannot write audit record"
+	errSendLog  = "cannot send audit record"
+)
+
+// Steps of the lifecycle of an AnsibleRun that run the Ansible contents.
+const (
+	StepCheck   = "check"
+	StepCreate  = "create"
+	StepApply   = "apply"
+	StepDestroy = "destroy"
+)
+
+// Results of the runs.
+const (
+	ResultSuccessful = "successful"
+	ResultFailed     = "failed"
+)
+
+// A Record is an entry of the audit log, describing a run of the Ansible
+// contents of an AnsibleRun.
+type Record struct {
+	// Time the run started
+	Time time.Time `json:"time"`
+	// Duration of the run
+	Duration string `json:"duration"`
+	// Resource is the name of the AnsibleRun
+	Resource string `json:"resource"`
+	// UID of the AnsibleRun
+	UID string `json:"uid"`
+	// ProviderConfig of the AnsibleRun
+	ProviderConfig string `json:"providerConfig,omitempty"`
+	// Annotations of the AnsibleRun, e.g. telling who requested it
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Step of the lifecycle of the AnsibleRun, either check, create, apply
+	// or destroy
+	Step string `json:"step"`
+	// ContentHash is the digest of the contents, requirements and vars
+	ContentHash string `json:"contentHash,omitempty"`
+	// Result of the run, either successful or failed
+	Result string `json:"result"`
+	// Error of a failed run
+	Error string `json:"error,omitempty"`
+}
+
+// A Logger appends records to an audit log.
+type Logger interface {
+	Log(ctx context.Context, r Record) error
+}
+
+// New returns a Logger appending records to the supplied sink, either the
+// http(s) URL of a collector to which they are POSTed, or the path of a file
+// to which they are written as JSON lines. No records are kept when the sink
+// is empty.
+func New(sink string) (Logger, error) {
+	switch {
+	case sink == "":
+		return NopLogger{}, nil
+	case strings.HasPrefix(sink, "http://"), strings.HasPrefix(sink, "https://"):
+		return &HTTPLogger{url: sink, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	default:
+		return NewFileLogger(sink)
+	}
+}
+
+// NopLogger keeps no records.
+type NopLogger struct{}
+
+// Log does nothing.
+func (NopLogger) Log(context.Context, Record) error { return nil }
+
+// A FileLogger appends records to a file as JSON lines.
+type FileLogger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileLogger returns a FileLogger appending records to the file at path,
+// which is created if needed but never truncated.
+func NewFileLogger(path string) (*FileLogger, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errOpenLog, err)
+	}
+	return &FileLogger{file: f}, nil
+}
+
+// Log appends the supplied record to the file.
+func (l *FileLogger) Log(_ context.Context, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteLog, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("%s: %w", errWriteLog, err)
+	}
+	return nil
+}
+
+// An HTTPLogger POSTs records to a collector, one JSON document per record.
+type HTTPLogger struct {
+	url    string
+	client *http.Client
+}
+
+// Log POSTs the supplied record to the collector.
+func (l *HTTPLogger) Log(ctx context.Context, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errSendLog, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("%s: %w", errSendLog, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errSendLog, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", errSendLog, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
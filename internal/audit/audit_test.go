@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var record = Record{
+	Time:        time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	Duration:    "1s",
+	Resource:    "test",
+	UID:         "uid",
+	Annotations: map[string]string{"requested-by": "alice"},
+	Step:        StepApply,
+	ContentHash: "sha256:abc",
+	Result:      ResultSuccessful,
+}
+
+func TestFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("{}\n"), 0600); err != nil {
+		t.Fatalf("Writing existing log: %v", err)
+	}
+
+	l, err := New(path)
+	if err != nil {
+		t.Fatalf("New(...): unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Log(context.Background(), record); err != nil {
+			t.Fatalf("l.Log(...): unexpected error: %v", err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Reading log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || lines[0] != "{}" {
+		t.Fatalf("Expected the records to be appended to the existing log, got:\n%s", b)
+	}
+	got := Record{}
+	if err := json.Unmarshal([]byte(lines[2]), &got); err != nil {
+		t.Fatalf("Unmarshaling record: %v", err)
+	}
+	if diff := cmp.Diff(record, got); diff != "" {
+		t.Errorf("Unexpected record (-want +got):\n%s", diff)
+	}
+}
+
+func TestHTTPLogger(t *testing.T) {
+	cases := map[string]struct {
+		status  int
+		wantErr bool
+	}{
+		"Accepted": {
+			status: http.StatusAccepted,
+		},
+		"ServerError": {
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				got := Record{}
+				if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+					t.Errorf("Decoding request: %v", err)
+				}
+				if diff := cmp.Diff(record, got); diff != "" {
+					t.Errorf("Unexpected record (-want +got):\n%s", diff)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			l, err := New(srv.URL)
+			if err != nil {
+				t.Fatalf("New(...): unexpected error: %v", err)
+			}
+			if err := l.Log(context.Background(), record); (err != nil) != tc.wantErr {
+				t.Errorf("l.Log(...): unexpected error %v", err)
+			}
+		})
+	}
+}
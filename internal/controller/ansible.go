@@ -18,6 +18,8 @@ package controller
 
 import (
 	ansiblerun "github.com/crossplane-contrib/provider-ansible/internal/controller/ansibleRun"
+	ansiblerunclaim "github.com/crossplane-contrib/provider-ansible/internal/controller/ansibleRunClaim"
+	ansiblerunset "github.com/crossplane-contrib/provider-ansible/internal/controller/ansibleRunSet"
 	"github.com/crossplane/crossplane-runtime/pkg/controller"
 	ctrl "sigs.k8s.io/controller-runtime"
 
@@ -35,5 +37,13 @@ func Setup(mgr ctrl.Manager, o controller.Options, s ansiblerun.SetupOptions) er
 		return err
 	}
 
+	if err := ansiblerunset.Setup(mgr, o); err != nil {
+		return err
+	}
+
+	if err := ansiblerunclaim.Setup(mgr, o, s.Executor); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,398 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+
+	adminv1alpha1 "github.com/crossplane-contrib/provider-ansible/apis/admin/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+)
+
+const (
+	errAdminNoToken      = "the admin API requires a token file"
+	errAdminReadToken    = "cannot read the token of the admin API"
+	errAdminUnauthorized = "missing or wrong bearer token"
+	errAdminNoName       = "the name of the AnsibleRun is required"
+	errAdminNotActive    = "AnsibleRun has no active run"
+	errAdminFlush        = "cannot flush the caches of the contents"
+	errAdminNotFound     = "AnsibleRun not found"
+	errAdminGetRun       = "cannot get the AnsibleRun"
+	errAdminInsecure     = "the admin API listens on loopback addresses only, unless served over TLS"
+	errAdminTLSPair      = "the admin API requires both a TLS certificate and key file, or neither"
+	errAdminLoadCert     = "cannot load the TLS certificate of the admin API"
+
+	// adminShutdownTimeout bounds the graceful shutdown of the admin API
+	adminShutdownTimeout = 5 * time.Second
+)
+
+// An admin serves the gRPC admin API of the provider, through which
+// operators act on the runs without annotating the AnsibleRuns or restarting
+// the provider: they list the active runs, cancel them, force the AnsibleRuns
+// to run again, and flush the caches of the contents. The calls are
+// authenticated with a bearer token read from a file, e.g. mounted from a
+// Secret, on each call so that it can be rotated. The bearer token would
+// travel in clear text without TLS, so the API is served over TLS, or only
+// on loopback addresses, e.g. reached through kubectl port-forward.
+type admin struct {
+	adminv1alpha1.UnimplementedAdminServiceServer
+
+	addr      string
+	tokenFile string
+	// certFile and keyFile hold the TLS certificate of the API, read on
+	// each handshake so that it can be rotated, no TLS when empty
+	certFile string
+	keyFile  string
+	kube     client.Reader
+	fs       afero.Afero
+	root     string
+	log      logging.Logger
+	// reruns enqueues the reconciles of the AnsibleRuns forced to run again
+	reruns chan ctrlevent.GenericEvent
+
+	mu     sync.Mutex
+	active map[string]*activeRun
+	forced map[string]bool
+}
+
+// An activeRun is a run of an AnsibleRun in progress.
+type activeRun struct {
+	Name  string
+	Phase string
+	Since time.Time
+
+	cancel context.CancelFunc
+}
+
+func newAdmin(addr, tokenFile, certFile, keyFile string, kube client.Reader, fs afero.Afero, root string, log logging.Logger) (*admin, error) {
+	if tokenFile == "" {
+		return nil, errors.New(errAdminNoToken)
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New(errAdminTLSPair)
+	}
+	if certFile == "" && !loopback(addr) {
+		return nil, fmt.Errorf("%s: %s", errAdminInsecure, addr)
+	}
+	return &admin{
+		addr:      addr,
+		tokenFile: tokenFile,
+		certFile:  certFile,
+		keyFile:   keyFile,
+		kube:      kube,
+		fs:        fs,
+		root:      root,
+		log:       log,
+		reruns:    make(chan ctrlevent.GenericEvent, 16),
+		active:    map[string]*activeRun{},
+		forced:    map[string]bool{},
+	}, nil
+}
+
+// loopback tells whether the supplied address listens on loopback addresses
+// only. The addresses without a host listen on all of them.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// started records the run of the supplied AnsibleRun, canceled by the
+// supplied func. A nil admin records nothing, like its other methods.
+func (a *admin) started(name string, cancel context.CancelFunc) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.active[name] = &activeRun{Name: name, Phase: ansible.StatusStarting, Since: time.Now(), cancel: cancel}
+}
+
+// phase records the phase of the run of the supplied AnsibleRun.
+func (a *admin) phase(name, phase string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r, ok := a.active[name]; ok {
+		r.Phase = phase
+	}
+}
+
+// finished forgets the run of the supplied AnsibleRun.
+func (a *admin) finished(name string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.active, name)
+}
+
+// runs returns the active runs, by name.
+func (a *admin) runs() []activeRun {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	runs := make([]activeRun, 0, len(a.active))
+	for _, r := range a.active {
+		runs = append(runs, *r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Name < runs[j].Name })
+	return runs
+}
+
+// cancel cancels the active run of the supplied AnsibleRun.
+func (a *admin) cancel(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.active[name]
+	if !ok {
+		return fmt.Errorf("%s: %s", errAdminNotActive, name)
+	}
+	r.cancel()
+	return nil
+}
+
+// rerun forces the supplied AnsibleRun to run again on its next reconcile,
+// which is enqueued unless ctx is done first. It returns an error when the
+// AnsibleRun doesn't exist, so that no name is forced until it is created.
+func (a *admin) rerun(ctx context.Context, name string) error {
+	if err := a.kube.Get(ctx, types.NamespacedName{Name: name}, &v1alpha1.AnsibleRun{}); err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("%s: %s: %w", errAdminNotFound, name, err)
+		}
+		return fmt.Errorf("%s: %w", errAdminGetRun, err)
+	}
+	a.mu.Lock()
+	a.forced[name] = true
+	a.mu.Unlock()
+	select {
+	case a.reruns <- ctrlevent.GenericEvent{Object: &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: name}}}:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// rerunForced tells whether the supplied AnsibleRun is forced to run again.
+func (a *admin) rerunForced(name string) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.forced[name]
+}
+
+// rerunDone forgets that the supplied AnsibleRun was forced to run again,
+// once it ran.
+func (a *admin) rerunDone(name string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.forced, name)
+}
+
+// flush removes the checksums of the contents fetched into the working
+// directories and the collections caches of the ProviderConfigs, so that
+// the contents are fetched and the requirements installed again on the next
+// reconciles. It takes the locks of all the working directories first, so
+// that it waits for their preparations and runs in progress, which read the
+// checksums and the collections caches, and keeps new ones from starting
+// until it is done.
+func (a *admin) flush() error {
+	workDirs, err := a.fs.ReadDir(a.root)
+	if resource.Ignore(os.IsNotExist, err) != nil {
+		return fmt.Errorf("%s: %w", errAdminFlush, err)
+	}
+	var dirs []string
+	for _, wd := range workDirs {
+		if wd.IsDir() {
+			dirs = append(dirs, filepath.Join(a.root, wd.Name()))
+		}
+	}
+	// sorted, so that concurrent flushes take them in the same order
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		defer workdirLocks.lock(dir)()
+	}
+	collectionsCacheMu.Lock()
+	defer collectionsCacheMu.Unlock()
+	if err := a.fs.RemoveAll(filepath.Join(a.root, collectionsCacheDir)); err != nil {
+		return fmt.Errorf("%s: %w", errAdminFlush, err)
+	}
+	for _, dir := range dirs {
+		if err := a.fs.RemoveAll(filepath.Join(dir, fetchedDir)); err != nil {
+			return fmt.Errorf("%s: %w", errAdminFlush, err)
+		}
+	}
+	return nil
+}
+
+// authenticate is a grpc.UnaryServerInterceptor rejecting the calls that
+// don't carry the token.
+func (a *admin) authenticate(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	token, err := a.fs.ReadFile(a.tokenFile)
+	if err != nil {
+		a.log.Info("Cannot authenticate a call to the admin API", "error", err)
+		return nil, status.Error(codes.Internal, errAdminReadToken)
+	}
+	want := strings.TrimSpace(string(token))
+	var got string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			if t, ok := strings.CutPrefix(v, "Bearer "); ok {
+				got = t
+			}
+		}
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, errAdminUnauthorized)
+	}
+	return handler(ctx, req)
+}
+
+// ListRuns lists the active runs.
+func (a *admin) ListRuns(_ context.Context, _ *adminv1alpha1.ListRunsRequest) (*adminv1alpha1.ListRunsResponse, error) {
+	runs := a.runs()
+	rsp := &adminv1alpha1.ListRunsResponse{Runs: make([]*adminv1alpha1.Run, 0, len(runs))}
+	for _, r := range runs {
+		rsp.Runs = append(rsp.Runs, &adminv1alpha1.Run{Name: r.Name, Phase: r.Phase, Since: timestamppb.New(r.Since)})
+	}
+	return rsp, nil
+}
+
+// CancelRun cancels the active run of an AnsibleRun.
+func (a *admin) CancelRun(_ context.Context, req *adminv1alpha1.CancelRunRequest) (*adminv1alpha1.CancelRunResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, errAdminNoName)
+	}
+	if err := a.cancel(req.GetName()); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	a.log.Info("Canceled a run through the admin API", "name", req.GetName())
+	return &adminv1alpha1.CancelRunResponse{}, nil
+}
+
+// ForceRun forces an AnsibleRun to run again.
+func (a *admin) ForceRun(ctx context.Context, req *adminv1alpha1.ForceRunRequest) (*adminv1alpha1.ForceRunResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, errAdminNoName)
+	}
+	if err := a.rerun(ctx, req.GetName()); err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	a.log.Info("Forced a run through the admin API", "name", req.GetName())
+	return &adminv1alpha1.ForceRunResponse{}, nil
+}
+
+// FlushCaches flushes the caches of the contents.
+func (a *admin) FlushCaches(_ context.Context, _ *adminv1alpha1.FlushCachesRequest) (*adminv1alpha1.FlushCachesResponse, error) {
+	if err := a.flush(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	a.log.Info("Flushed the caches of the contents through the admin API")
+	return &adminv1alpha1.FlushCachesResponse{}, nil
+}
+
+// server returns the gRPC server of the admin API, over TLS when it has a
+// certificate. Its reflection service lets grpcurl call it without the
+// definition of the API.
+func (a *admin) server() *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(a.authenticate)}
+	if a.certFile != "" {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				cert, err := tls.LoadX509KeyPair(a.certFile, a.keyFile)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", errAdminLoadCert, err)
+				}
+				return &cert, nil
+			},
+		})))
+	}
+	srv := grpc.NewServer(opts...)
+	adminv1alpha1.RegisterAdminServiceServer(srv, a)
+	reflection.Register(srv)
+	return srv
+}
+
+// Start serves the admin API until ctx is done.
+func (a *admin) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return err
+	}
+	srv := a.server()
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(adminShutdownTimeout):
+			srv.Stop()
+		}
+	}()
+	if err := srv.Serve(l); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
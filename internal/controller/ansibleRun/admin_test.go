@@ -0,0 +1,347 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	adminv1alpha1 "github.com/crossplane-contrib/provider-ansible/apis/admin/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+)
+
+const adminToken = "s3cr3t"
+
+func newTestAdmin(t *testing.T, fs afero.Afero) *admin {
+	t.Helper()
+	if err := fs.WriteFile("/token", []byte(adminToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	kube := fake.NewClientBuilder().WithScheme(s).WithObjects(&v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "run"}}).Build()
+	a, err := newAdmin("127.0.0.1:0", "/token", "", "", kube, fs, baseWorkingDir, logging.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// serveAdmin serves the admin API in memory, returning a client of it.
+func serveAdmin(t *testing.T, a *admin) adminv1alpha1.AdminServiceClient {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	srv := a.server()
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return adminv1alpha1.NewAdminServiceClient(conn)
+}
+
+// withToken returns a context carrying the supplied bearer token.
+func withToken(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestNewAdmin(t *testing.T) {
+	cases := map[string]struct {
+		reason   string
+		addr     string
+		token    string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		"NoToken": {
+			reason:  "The admin API requires a token",
+			addr:    "127.0.0.1:8082",
+			wantErr: true,
+		},
+		"Loopback": {
+			reason: "The admin API may be served without TLS on loopback addresses",
+			addr:   "localhost:8082",
+			token:  "/token",
+		},
+		"AllAddresses": {
+			reason:  "The admin API may not be served without TLS on all the addresses",
+			addr:    ":8082",
+			token:   "/token",
+			wantErr: true,
+		},
+		"PodAddress": {
+			reason:  "The admin API may not be served without TLS on a routable address",
+			addr:    "10.0.0.12:8082",
+			token:   "/token",
+			wantErr: true,
+		},
+		"TLS": {
+			reason:   "The admin API may be served over TLS on any address",
+			addr:     ":8082",
+			token:    "/token",
+			certFile: "/tls.crt",
+			keyFile:  "/tls.key",
+		},
+		"NoKey": {
+			reason:   "The admin API requires the key of its certificate",
+			addr:     ":8082",
+			token:    "/token",
+			certFile: "/tls.crt",
+			wantErr:  true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := newAdmin(tc.addr, tc.token, tc.certFile, tc.keyFile, nil, afero.Afero{Fs: afero.NewMemMapFs()}, baseWorkingDir, logging.NewNopLogger())
+			if got := err != nil; got != tc.wantErr {
+				t.Errorf("\n%s\nnewAdmin(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	c := serveAdmin(t, newTestAdmin(t, afero.Afero{Fs: afero.NewMemMapFs()}))
+	for name, token := range map[string]string{"None": "", "Wrong": "wrong"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.ListRuns(withToken(token), &adminv1alpha1.ListRunsRequest{})
+			if got := status.Code(err); got != codes.Unauthenticated {
+				t.Errorf("ListRuns(...): want code %s, got %s", codes.Unauthenticated, got)
+			}
+		})
+	}
+}
+
+func TestAdminCancel(t *testing.T) {
+	a := newTestAdmin(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	c := serveAdmin(t, a)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.started("run", cancel)
+	a.phase("run", ansible.StatusRunning)
+
+	rsp, err := c.ListRuns(withToken(adminToken), &adminv1alpha1.ListRunsRequest{})
+	if err != nil {
+		t.Fatalf("ListRuns(...): %v", err)
+	}
+	if runs := rsp.GetRuns(); len(runs) != 1 || runs[0].GetName() != "run" || runs[0].GetPhase() != ansible.StatusRunning || runs[0].GetSince() == nil {
+		t.Errorf("ListRuns(...): want the running run, got %v", runs)
+	}
+
+	for name, want := range map[string]codes.Code{"": codes.InvalidArgument, "other": codes.NotFound, "run": codes.OK} {
+		_, err := c.CancelRun(withToken(adminToken), &adminv1alpha1.CancelRunRequest{Name: name})
+		if got := status.Code(err); got != want {
+			t.Errorf("CancelRun(%q): want code %s, got %s", name, want, got)
+		}
+	}
+	if ctx.Err() == nil {
+		t.Error("CancelRun(...): expected the run to be canceled")
+	}
+
+	a.finished("run")
+	if diff := cmp.Diff([]activeRun{}, a.runs(), cmp.AllowUnexported(activeRun{})); diff != "" {
+		t.Errorf("runs(): -want, +got:\n%s", diff)
+	}
+}
+
+func TestAdminForceRun(t *testing.T) {
+	a := newTestAdmin(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	c := serveAdmin(t, a)
+	_, err := c.ForceRun(withToken(adminToken), &adminv1alpha1.ForceRunRequest{Name: "missing"})
+	if got := status.Code(err); got != codes.NotFound {
+		t.Errorf("ForceRun(missing): want code %s, got %s", codes.NotFound, got)
+	}
+	if a.rerunForced("missing") {
+		t.Error("rerunForced(...): want a missing AnsibleRun not forced")
+	}
+	if _, err := c.ForceRun(withToken(adminToken), &adminv1alpha1.ForceRunRequest{Name: "run"}); err != nil {
+		t.Fatalf("ForceRun(run): %v", err)
+	}
+	if !a.rerunForced("run") {
+		t.Error("rerunForced(...): want the AnsibleRun forced to run again")
+	}
+	select {
+	case evt := <-a.reruns:
+		if evt.Object.GetName() != "run" {
+			t.Errorf("ForceRun(run): want the reconcile of run enqueued, got %s", evt.Object.GetName())
+		}
+	default:
+		t.Error("ForceRun(run): want a reconcile enqueued")
+	}
+	a.rerunDone("run")
+	if a.rerunForced("run") {
+		t.Error("rerunForced(...): want the forced run done")
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	c := serveAdmin(t, newTestAdmin(t, fs))
+	fetched := filepath.Join(baseWorkingDir, string(uid), fetchedDir, sourceInline)
+	cache := filepath.Join(baseWorkingDir, collectionsCacheDir, "pc", "requirements.yml")
+	playbook := filepath.Join(baseWorkingDir, string(uid), "playbook.yml")
+	for _, f := range []string{fetched, cache, playbook} {
+		if err := fs.WriteFile(f, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the flush waits for the preparation or run in progress
+	unlock := workdirLocks.lock(filepath.Join(baseWorkingDir, string(uid)))
+	done := make(chan error)
+	go func() {
+		_, err := c.FlushCaches(withToken(adminToken), &adminv1alpha1.FlushCachesRequest{})
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("FlushCaches(...): want the flush to wait for the lock of the working directory")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if exists, _ := fs.Exists(fetched); !exists {
+		t.Errorf("FlushCaches(...): want %s kept while the working directory is locked", fetched)
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("FlushCaches(...): %v", err)
+	}
+	for f, want := range map[string]bool{fetched: false, cache: false, playbook: true} {
+		if got, _ := fs.Exists(f); got != want {
+			t.Errorf("FlushCaches(...): want %s existing %t, got %t", f, want, got)
+		}
+	}
+}
+
+func TestAdminTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"provider-ansible"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	a := newTestAdmin(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	a.certFile, a.keyFile = certFile, keyFile
+	l := bufconn.Listen(1 << 20)
+	srv := a.server()
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "provider-ansible", MinVersion: tls.VersionTLS12})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	if _, err := adminv1alpha1.NewAdminServiceClient(conn).ListRuns(withToken(adminToken), &adminv1alpha1.ListRunsRequest{}); err != nil {
+		t.Errorf("ListRuns(...): want the admin API served over TLS, got %v", err)
+	}
+}
+
+func TestAdminNil(t *testing.T) {
+	var a *admin
+	a.started("run", func() {})
+	a.phase("run", ansible.StatusRunning)
+	a.finished("run")
+	a.rerunDone("run")
+	if a.rerunForced("run") {
+		t.Error("rerunForced(...): a disabled admin API forces no run")
+	}
+}
+
+func TestObserveRerunForced(t *testing.T) {
+	a := newTestAdmin(t, afero.Afero{Fs: afero.NewMemMapFs()})
+	if err := a.rerun(context.Background(), "run"); err != nil {
+		t.Fatal(err)
+	}
+	<-a.reruns
+
+	cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "run"}}
+	c := &external{admin: a}
+	got, err := c.Observe(context.Background(), cr)
+	if err != nil {
+		t.Fatalf("Observe(...): unexpected error: %v", err)
+	}
+	if !got.ResourceExists || got.ResourceUpToDate {
+		t.Errorf("Observe(...): want an existing resource to update, got %+v", got)
+	}
+	if !c.forced {
+		t.Error("Observe(...): want the run forced")
+	}
+}
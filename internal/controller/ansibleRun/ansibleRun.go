@@ -22,17 +22,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"os"
+	"math"
+	"math/rand"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/apenella/go-ansible/pkg/stdoutcallback/results"
 	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
 	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane-contrib/provider-ansible/internal/audit"
 	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
 	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/statusutil"
 	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
 	"github.com/crossplane/crossplane-runtime/pkg/controller"
 	"github.com/crossplane/crossplane-runtime/pkg/event"
@@ -40,53 +43,157 @@ import (
 	"github.com/crossplane/crossplane-runtime/pkg/ratelimiter"
 	"github.com/crossplane/crossplane-runtime/pkg/reconciler/managed"
 	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/spf13/afero"
-	"gopkg.in/yaml.v2"
+	authv1 "k8s.io/api/authentication/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
-	errNotAnsibleRun       = "managed resource is not a AnsibleRun custom resource"
-	errTrackPCUsage        = "cannot track ProviderConfig usage"
-	errGetPC               = "cannot get ProviderConfig"
-	errGetCreds            = "cannot get credentials"
-	errGetInventory        = "cannot get Inventory"
-	errWriteGitCreds       = "cannot write .git-credentials to /tmp dir"
-	errWriteConfig         = "cannot write ansible collection requirements in" + galaxyutil.RequirementsFile
-	errWriteCreds          = "cannot write Playbook credentials"
-	errRemoteConfiguration = "cannot get remote AnsibleRun configuration"
-	errWriteAnsibleRun     = "cannot write AnsibleRun configuration in" + runnerutil.PlaybookYml
-	errWriteInventory      = "cannot write AnsibleRun inventory in"
-	errChmodInventory      = "cannot change permissions of inventory file"
-	errMarshalRoles        = "cannot marshal Roles into yaml document"
-	errMkdir               = "cannot make directory"
-	errInit                = "cannot initialize Ansible client"
-	gitCredentialsFilename = ".git-credentials"
+	errNotAnsibleRun           = "managed resource is not a AnsibleRun custom resource"
+	errTrackPCUsage            = "cannot track ProviderConfig usage"
+	errGetPC                   = "cannot get ProviderConfig"
+	errGetCreds                = "cannot get credentials"
+	errGetInventory            = "cannot get Inventory"
+	errGetInventoryPluginCreds = "cannot get the credentials of inventory plugin"
+	errWriteGitCreds           = "cannot write .git-credentials to /tmp dir"
+	errWriteConfig             = "cannot write ansible collection requirements in" + galaxyutil.RequirementsFile
+	errWriteCreds              = "cannot write Playbook credentials"
+	errRemoveCreds             = "cannot remove Playbook credentials"
+	errNoFsCreds               = "no credentials file matches"
+	errWriteAnsibleRun         = "cannot write AnsibleRun configuration in" + runnerutil.PlaybookYml
+	errWriteCreateAnsibleRun   = "cannot write AnsibleRun create configuration in" + runnerutil.CreatePlaybookYml
+	errWriteDeleteAnsibleRun   = "cannot write AnsibleRun delete configuration in" + runnerutil.DeletePlaybookYml
+	errWritePlaybooks          = "cannot write AnsibleRun playbooks in " + runnerutil.InlinePlaybooksDir
+	errWriteInventory          = "cannot write AnsibleRun inventory in"
+	errChmodInventory          = "cannot change permissions of inventory file"
+	errMarshalRoles            = "cannot marshal Roles into yaml document"
+	errMkdir                   = "cannot make directory"
+	errInit                    = "cannot initialize Ansible client"
+	errGetPassword             = "cannot get password"
+	errMarshalPasswords        = "cannot marshal Passwords into yaml document"
+	errWritePasswords          = "cannot write AnsibleRun passwords in"
+	errGetVaultPassword        = "cannot get vault password"
+	errWriteVaultPassword      = "cannot write vault password"
+	errGetSSHPrivateKey        = "cannot get the SSH private key"
+	errWriteSSHCredentials     = "cannot write the SSH credentials"
+	errRequestToken            = "cannot request a token of the ServiceAccount"
+	errWriteKubeCA             = "cannot write the CA of the Kubernetes API server"
+	errJobExecutorSetup        = "the Job executor requires the --job-volume-claim flag"
+	errArtifactsDirSetup       = "the --artifacts-dir flag cannot be set along with the --job-volume-claim flag, the Jobs mounting the working directories only"
+	errJobExecutorImage        = "the Job executor requires an image, from the ProviderConfig or the --job-image flag"
+	gitCredentialsFilename     = ".git-credentials"
 
 	errGetAnsibleRun     = "cannot get AnsibleRun"
 	errGetLastApplied    = "cannot get last applied"
 	errUnmarshalTemplate = "cannot unmarshal template"
+	errAdoptExisting     = "cannot check existing state"
+	errLabelContentHash  = "cannot label the hash of the contents"
+	errConnectionDetails = "cannot publish connection details"
+	errRevokeConnection  = "cannot revoke the connection details"
+	errRetryLimit        = "cannot retry the failed runs of the current spec beyond the retry limit"
+
+	errGetGalaxyToken = "cannot get the token of the Galaxy server"
+	errGetVar         = "cannot get the value of var"
+	errNoVarSource    = "valueFrom must select a key of a Secret or of a ConfigMap"
+	errNoConfigMapKey = "ConfigMap has no key"
+	errUnknownVar     = "ProviderConfig sets a var unknown to Ansible, check its spelling:"
+
+	// reasonUnknownVar is the reason of the warnings about the unknown vars
+	// of the ProviderConfigs
+	reasonUnknownVar = event.Reason("UnknownVar")
+
+	errTeardownSkipped = "skipped the teardown, the hosts may keep what the AnsibleRun applied"
+
+	// reasonTeardownSkipped is the reason of the warnings about the
+	// teardowns given up
+	reasonTeardownSkipped = event.Reason(v1alpha1.ReasonTeardownSkipped)
 )
 
+// AnnotationKeyForceDelete set to "true" on an AnsibleRun deletes it without
+// running its teardown, e.g. when the hosts it configured are already gone.
+const AnnotationKeyForceDelete = "ansible.crossplane.io/force-delete"
+
 const (
 	baseWorkingDir = "/ansibleDir"
+
+	// gitCredentialsRoot is the directory under which the .git-credentials
+	// of each working directory are written, outside of it
+	gitCredentialsRoot = "/tmp"
+	// gitConfigFile is the git config using the .git-credentials of a
+	// working directory, next to them
+	gitConfigFile = ".gitconfig"
+
+	// collectionsCacheDir is the subdirectory of the base working directory
+	// holding the cached collections of each ProviderConfig
+	collectionsCacheDir = ".cache/collections"
+
+	// fieldManager is the server-side apply field manager used for
+	// metadata owned by this provider.
+	fieldManager = "provider-ansible"
+
+	// heartbeatInterval is how often the status of running AnsibleRuns is
+	// updated with their current task.
+	heartbeatInterval = 30 * time.Second
+
+	// defaultTokenExpirationSeconds is the lifetime of the tokens of the
+	// impersonated ServiceAccounts, unless set in the AnsibleRun.
+	defaultTokenExpirationSeconds = 3600
+
+	// kubeCAFile is the file holding the CA of the Kubernetes API server
+	// for the kubernetes.core modules, when it is not already in a file.
+	kubeCAFile = "kube-ca.crt"
 )
 
 type params interface {
 	Init(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*ansible.Runner, error)
-	GalaxyInstall(ctx context.Context, behaviorVars map[string]string, requirementsType string) error
+	GalaxyInstall(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error
 }
 
+// using a variable for the role versions resolver allows for stubbing in tests
+var resolveFloatingVersion = galaxyutil.ResolveFloatingVersion
+
+// ansibleRunner executes the Ansible contents of an AnsibleRun for each step
+// of its lifecycle.
 type ansibleRunner interface {
 	GetAnsibleRunPolicy() *ansible.RunPolicy
-	WriteExtraVar(extraVar map[string]interface{}) error
-	EnableCheckMode(checkMode bool)
-	Run(ctx context.Context) (io.Reader, error)
+	// Check runs the contents in check mode for the present state.
+	Check(ctx context.Context) (*results.AnsiblePlaybookJSONResults, error)
+	// Apply runs the contents for the present state.
+	Apply(ctx context.Context) error
+	// Create runs the create contents for the present state.
+	Create(ctx context.Context) error
+	// Destroy runs the contents for the absent state.
+	Destroy(ctx context.Context) error
+	// Warnings returns the ignored failures of the last run.
+	Warnings() []string
+	// SlowestTasks returns the slowest tasks of the last run.
+	SlowestTasks() []v1alpha1.TaskTiming
+	// LastRun returns the summary of the last run, nil when it didn't start.
+	LastRun() *v1alpha1.RunSummary
+	// ContentHash returns the hash of the contents of the last run.
+	ContentHash() string
+	// Hosts returns the hosts of the inventory of the next runs.
+	Hosts(ctx context.Context) ([]string, error)
+	// SetTrigger sets why the next runs are run.
+	SetTrigger(trigger string)
+	// ConnectionDetails returns the connection details found in the last run.
+	ConnectionDetails() (managed.ConnectionDetails, error)
 }
 
 // SetupOptions constains settings specific to the ansible run controller.
@@ -95,6 +202,65 @@ type SetupOptions struct {
 	AnsibleRolesPath       string
 	Timeout                time.Duration
 	ArtifactsHistoryLimit  int
+	// GalaxyTimeout bounds each ansible-galaxy install, none when zero.
+	GalaxyTimeout time.Duration
+	// MaxTimeout bounds the reconciles instead of Timeout when longer, so
+	// that AnsibleRuns may set timeouts up to it, Timeout bounding the runs
+	// of the others.
+	MaxTimeout time.Duration
+	// FailedPollInterval is used instead of the poll interval for
+	// AnsibleRuns whose last reconcile failed, or whose last run failed in a
+	// way that may go away by itself.
+	FailedPollInterval time.Duration
+	// PollJitter shifts the poll interval of each reconcile by a random
+	// duration of up to it either way, none when zero.
+	PollJitter time.Duration
+	// AuditLogger records every run, none when nil.
+	AuditLogger audit.Logger
+	// Executor runs the contents of the AnsibleRuns whose ProviderConfig
+	// doesn't select one, Local when empty. The Fake executor simulates the
+	// runs of all the AnsibleRuns, whatever their ProviderConfig selects.
+	Executor string
+	// JobNamespace, JobImage and JobServiceAccountName configure the
+	// Kubernetes Jobs of the Job executor, unless their ProviderConfig does.
+	JobNamespace          string
+	JobImage              string
+	JobServiceAccountName string
+	// JobVolumeClaim is the PersistentVolumeClaim holding the working
+	// directories, shared with the Jobs. The Job executor is unavailable
+	// when it is empty.
+	JobVolumeClaim string
+	// WorkDirBudget is the disk space the working directories may use
+	// before the oldest artifacts of the runs are pruned, none when zero.
+	WorkDirBudget int64
+	// MinFreeDisk is the free space of the volume of the working
+	// directories, and MaxMemory the memory of the provider container, past
+	// which no run starts until the pressure relieves, unchecked when zero.
+	MinFreeDisk int64
+	MaxMemory   int64
+	// ArtifactsDir holds the artifacts of the runs, one directory per
+	// working directory, e.g. on another volume than the credentials. They
+	// are kept within the working directories when it is empty.
+	ArtifactsDir string
+	// WorkDirRetention is how long the working directories of the deleted
+	// AnsibleRuns are kept before they are garbage collected, never when
+	// zero.
+	WorkDirRetention time.Duration
+	// AdminAddress is the address the admin API listens on, disabled when
+	// empty. AdminTokenFile holds the token authenticating its requests.
+	// AdminTLSCertFile and AdminTLSKeyFile hold its TLS certificate, the API
+	// listening on loopback addresses only without them.
+	AdminAddress     string
+	AdminTokenFile   string
+	AdminTLSCertFile string
+	AdminTLSKeyFile  string
+	// LibrariesDir is the directory the libraries of contents shipped by
+	// Configuration packages are mounted under, the libraries being
+	// unavailable when it is empty.
+	LibrariesDir string
+	// Chaos are the faults injected into the runs, see ansible.ParseChaos,
+	// none when empty.
+	Chaos string
 }
 
 // Setup adds a controller that reconciles AnsibleRun managed resources.
@@ -103,43 +269,169 @@ func Setup(mgr ctrl.Manager, o controller.Options, s SetupOptions) error {
 
 	fs := afero.Afero{Fs: afero.NewOsFs()}
 
-	galaxyBinary, err := galaxyutil.GalaxyBinary()
+	chaos, err := ansible.ParseChaos(s.Chaos)
 	if err != nil {
 		return err
 	}
-	runnerBinary, err := runnerutil.RunnerBinary()
-	if err != nil {
-		return err
+	if chaos != nil {
+		o.Logger.Info("Injecting faults into the runs, for testing only", "chaos", s.Chaos)
 	}
 
+	// the Fake executor runs without Ansible
+	fake := s.Executor == ansible.ExecutorFake
+	var galaxyBinary, runnerBinary, inventoryBinary string
+	var runnerVersion *runnerutil.Version
+	if fake {
+		o.Logger.Info("Simulating the runs with the Fake executor, the Ansible contents are not run")
+	} else {
+		var err error
+		galaxyBinary, err = galaxyutil.GalaxyBinary()
+		if err != nil {
+			return err
+		}
+		runnerBinary, err = runnerutil.RunnerBinary()
+		if err != nil {
+			return err
+		}
+		// the inventories are not validated before the runs without ansible-inventory
+		inventoryBinary, err = runnerutil.InventoryBinary()
+		if err != nil {
+			o.Logger.Info("Cannot find ansible-inventory, the inventories are not validated before the runs", "error", err)
+		}
+		if v, err := runnerutil.RunnerVersion(runnerBinary); err != nil {
+			o.Logger.Info("Cannot detect the ansible-runner version, using the flags of the latest one", "error", err)
+		} else {
+			runnerVersion = &v
+		}
+	}
+
+	var jobExecutor *ansible.JobExecutor
+	switch {
+	case s.JobVolumeClaim != "" && s.ArtifactsDir != "":
+		return errors.New(errArtifactsDirSetup)
+	case s.JobVolumeClaim != "":
+		cs, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return err
+		}
+		jobExecutor = &ansible.JobExecutor{
+			Client:             cs,
+			Namespace:          s.JobNamespace,
+			Image:              s.JobImage,
+			ServiceAccountName: s.JobServiceAccountName,
+			VolumeClaim:        s.JobVolumeClaim,
+			MountPath:          baseWorkingDir,
+		}
+	case s.Executor == ansible.ExecutorJob:
+		return errors.New(errJobExecutorSetup)
+	}
+
+	var adm *admin
+	if s.AdminAddress != "" {
+		var err error
+		adm, err = newAdmin(s.AdminAddress, s.AdminTokenFile, s.AdminTLSCertFile, s.AdminTLSKeyFile, mgr.GetClient(), fs, baseWorkingDir, o.Logger)
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(adm); err != nil {
+			return err
+		}
+	}
+
+	groups := newRunGroups()
+	recorder := event.NewAPIRecorder(mgr.GetEventRecorderFor(name))
+	// the default poll interval of the managed reconciler when unset
+	pollInterval := o.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = time.Minute
+	}
 	c := &connector{
-		kube:  mgr.GetClient(),
-		usage: resource.NewProviderConfigUsageTracker(mgr.GetClient(), &v1alpha1.ProviderConfigUsage{}),
-		fs:    fs,
+		kube:          mgr.GetClient(),
+		usage:         resource.NewProviderConfigUsageTracker(mgr.GetClient(), &v1alpha1.ProviderConfigUsage{}),
+		fs:            fs,
+		kubeAPI:       mgr.GetConfig(),
+		audit:         s.AuditLogger,
+		executor:      s.Executor,
+		jobExecutor:   jobExecutor,
+		recorder:      recorder,
+		admin:         adm,
+		groups:        groups,
+		hosts:         newHostLocks(groups.wakeups),
+		pollInterval:  pollInterval,
+		pollJitter:    s.PollJitter,
+		librariesDir:  s.LibrariesDir,
+		artifactsRoot: s.ArtifactsDir,
 		ansible: func(dir string) params {
 			return ansible.Parameters{
 				WorkingDirPath:        dir,
+				ArtifactsDir:          separateArtifactsDir(s.ArtifactsDir, dir),
 				GalaxyBinary:          galaxyBinary,
 				RunnerBinary:          runnerBinary,
+				InventoryBinary:       inventoryBinary,
 				CollectionsPath:       s.AnsibleCollectionsPath,
 				RolesPath:             s.AnsibleRolesPath,
 				ArtifactsHistoryLimit: s.ArtifactsHistoryLimit,
+				RunnerVersion:         runnerVersion,
+				GalaxyTimeout:         s.GalaxyTimeout,
+				Timeout:               s.Timeout,
+				Fake:                  fake,
+				Chaos:                 chaos,
 			}
 		},
 	}
 
+	if s.MinFreeDisk > 0 || s.MaxMemory > 0 {
+		c.pressure = newPressureMonitor(baseWorkingDir, s.MinFreeDisk, s.MaxMemory, groups.wakeups, o.Logger)
+		if err := mgr.Add(c.pressure); err != nil {
+			return err
+		}
+	}
+	if err := mgr.Add(&diskBudget{fs: fs, root: baseWorkingDir, artifactsRoot: s.ArtifactsDir, limit: s.WorkDirBudget, log: o.Logger}); err != nil {
+		return err
+	}
+	if s.WorkDirRetention > 0 {
+		gc := &workDirGC{kube: mgr.GetClient(), fs: fs, root: baseWorkingDir, artifactsRoot: s.ArtifactsDir, retention: s.WorkDirRetention, log: o.Logger}
+		if err := mgr.Add(gc); err != nil {
+			return err
+		}
+	}
+
 	r := managed.NewReconciler(mgr,
 		resource.ManagedKind(v1alpha1.AnsibleRunGroupVersionKind),
 		managed.WithExternalConnecter(c),
 		managed.WithLogger(o.Logger.WithValues("controller", name)),
-		managed.WithTimeout(s.Timeout),
-		managed.WithRecorder(event.NewAPIRecorder(mgr.GetEventRecorderFor(name))))
+		managed.WithTimeout(max(s.Timeout, s.MaxTimeout)),
+		managed.WithPollInterval(pollInterval),
+		managed.WithPollIntervalHook(pollIntervalHook(s.FailedPollInterval, s.PollJitter)),
+		managed.WithFinalizer(&referencesFinalizer{Finalizer: resource.NewAPIFinalizer(mgr.GetClient(), managed.FinalizerName), kube: mgr.GetClient()}),
+		managed.WithRecorder(recorder))
 
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		Named(name).
 		WithOptions(o.ForControllerRuntime()).
-		For(&v1alpha1.AnsibleRun{}).
-		Complete(ratelimiter.NewReconciler(name, r, o.GlobalRateLimiter))
+		For(&v1alpha1.AnsibleRun{})
+	// the AnsibleRuns waiting for their concurrency group, their hosts, or
+	// the pressure to relieve, are reconciled once they are released
+	b = b.WatchesRawSource(&source.Channel{Source: groups.wakeups}, &handler.EnqueueRequestForObject{})
+	if adm != nil {
+		// the AnsibleRuns forced to run again are reconciled right away
+		b = b.WatchesRawSource(&source.Channel{Source: adm.reruns}, &handler.EnqueueRequestForObject{})
+	}
+	// the AnsibleRuns reading their vars from Secrets and ConfigMaps are
+	// reconciled when these change, e.g. when credentials are rotated
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.AnsibleRun{}, varsFromIndex, indexVarsFrom); err != nil {
+		return fmt.Errorf("%s: %w", errIndexVarsFrom, err)
+	}
+	b = b.Watches(&v1.Secret{}, handler.EnqueueRequestsFromMapFunc(runsReadingVarsFrom(mgr.GetClient(), kindSecret))).
+		Watches(&v1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(runsReadingVarsFrom(mgr.GetClient(), kindConfigMap)))
+	// the AnsibleRuns using a ProviderConfig are reconciled when its spec
+	// changes, e.g. its extra vars
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.AnsibleRun{}, providerConfigIndex, indexProviderConfig); err != nil {
+		return fmt.Errorf("%s: %w", errIndexProviderConfig, err)
+	}
+	b = b.Watches(&v1alpha1.ProviderConfig{}, handler.EnqueueRequestsFromMapFunc(runsUsingProviderConfig(mgr.GetClient())),
+		builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	return b.Complete(ratelimiter.NewReconciler(name, r, o.GlobalRateLimiter))
 }
 
 // A connector is expected to produce an ExternalClient when its Connect method
@@ -149,189 +441,477 @@ type connector struct {
 	usage   resource.Tracker
 	fs      afero.Afero
 	ansible func(dir string) params
+	// kubeAPI is the configuration of the Kubernetes API server the
+	// kubernetes.core modules of impersonating runs connect to
+	kubeAPI *rest.Config
+	audit   audit.Logger
+	// executor is used when the ProviderConfig doesn't select one
+	executor string
+	// jobExecutor runs the contents in Kubernetes Jobs, nil when unavailable
+	jobExecutor *ansible.JobExecutor
+	// recorder records the task events of the runs
+	recorder event.Recorder
+	// admin serves the admin API, nil when disabled
+	admin *admin
+	// groups schedules the runs of the AnsibleRuns of the same concurrency
+	// group one at a time
+	groups *runGroups
+	// hosts keeps the runs on the same hosts from running at the same
+	// time, for the ProviderConfigs locking hosts
+	hosts *hostLocks
+	// pressure throttles the runs while the provider is short of disk
+	// space or memory, nil when unchecked
+	pressure *pressureMonitor
+	// artifactsRoot holds the artifacts of the runs, one directory per
+	// working directory, within the working directories when empty
+	artifactsRoot string
+	// pollInterval and pollJitter space the runs of the AlwaysApply policy
+	pollInterval time.Duration
+	pollJitter   time.Duration
+	// librariesDir holds the libraries of contents, none when empty
+	librariesDir string
 }
 
-func (c *connector) Connect(ctx context.Context, mg resource.Managed) (managed.ExternalClient, error) { //nolint:gocyclo
-	// NOTE(negz): This method is slightly over our complexity goal, but I
-	// can't immediately think of a clean way to decompose it without
-	// affecting readability.
-
+func (c *connector) Connect(ctx context.Context, mg resource.Managed) (managed.ExternalClient, error) {
 	cr, ok := mg.(*v1alpha1.AnsibleRun)
 	if !ok {
 		return nil, errors.New(errNotAnsibleRun)
 	}
+	// as read by the managed reconciler, before Connect changes it
+	persisted := cr.DeepCopy()
 
 	// NOTE(negz): This directory will be garbage collected by the workdir
 	// garbage collector that is started in Setup.
-	dir := filepath.Join(baseWorkingDir, string(cr.GetUID()))
-	if err := c.fs.MkdirAll(dir, 0700); resource.Ignore(os.IsExist, err) != nil {
-		return nil, fmt.Errorf("%s: %s: %w", baseWorkingDir, errMkdir, err)
+	p := &preparation{}
+	if err := c.prepareWorkdir(cr, p); err != nil {
+		return nil, failStage(cr, stagePrepareWorkdir, err)
+	}
+	defer workdirLocks.lock(p.dir)()
+	if err := c.verifyWorkdir(cr, p); err != nil {
+		return nil, failStage(cr, stagePrepareWorkdir, err)
 	}
 
 	if err := c.usage.Track(ctx, mg); err != nil {
 		return nil, fmt.Errorf("%s: %w", errTrackPCUsage, err)
 	}
 
-	pc := &v1alpha1.ProviderConfig{}
-	if err := c.kube.Get(ctx, types.NamespacedName{Name: cr.GetProviderConfigReference().Name}, pc); err != nil {
+	p.pc = &v1alpha1.ProviderConfig{}
+	if err := c.kube.Get(ctx, types.NamespacedName{Name: cr.GetProviderConfigReference().Name}, p.pc); err != nil {
 		return nil, fmt.Errorf("%s: %w", errGetPC, err)
 	}
-	var inventoryPerm os.FileMode = 0600
-	if cr.Spec.ForProvider.ExecutableInventory {
-		inventoryPerm = 0700
+	// protected before they are read, so that they are not deleted while
+	// the runs depend on them
+	if err := c.protectReferences(ctx, cr, p.pc); err != nil {
+		return nil, err
+	}
+	vars, err := c.behaviorVars(ctx, p.pc)
+	if err != nil {
+		return nil, err
+	}
+	p.vars = vars
+	c.warnUnknownVars(cr, p.vars)
+
+	if err := c.writeInventory(ctx, cr, p); err != nil {
+		return nil, failStage(cr, stageWriteInventory, err)
 	}
-	// Saved inventory needed for ansible content hosts
-	var buff bytes.Buffer
-	for _, i := range cr.Spec.ForProvider.Inventories {
-		data, err := resource.CommonCredentialExtractor(ctx, i.Source, c.kube, i.CommonCredentialSelectors)
+	if err := c.writeCredentials(ctx, cr, p); err != nil {
+		return nil, failStage(cr, stageWriteCredentials, err)
+	}
+	if err := c.fetchContent(ctx, cr, p); err != nil {
+		return nil, failStage(cr, stageFetchContent, err)
+	}
+	p.ps = c.ansible(p.dir)
+	if err := c.installDeps(ctx, cr, p); err != nil {
+		return nil, failStage(cr, stageInstallDeps, err)
+	}
+	e, err := c.initRunner(ctx, cr, p)
+	if err != nil {
+		return nil, failStage(cr, stageInitRunner, err)
+	}
+	e.persisted = persisted
+	if err := writeWorkdirLock(c.fs, p.dir); err != nil {
+		return nil, failStage(cr, stagePrepareWorkdir, err)
+	}
+	cr.SetConditions(prepared())
+	return e, nil
+}
+
+// writeVaultPasswords writes the supplied vault passwords to files of the
+// vault directory, replacing the ones of the previous reconciles, and returns
+// the vault IDs passing them to the runs.
+func (c *connector) writeVaultPasswords(ctx context.Context, dir string, secrets []v1alpha1.VaultSecret) ([]ansible.VaultID, error) {
+	vaultDir := filepath.Join(dir, runnerutil.VaultDir)
+	if err := c.fs.RemoveAll(vaultDir); err != nil {
+		return nil, fmt.Errorf("%s: %w", errWriteVaultPassword, err)
+	}
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	if err := c.fs.MkdirAll(vaultDir, 0700); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", vaultDir, errMkdir, err)
+	}
+	ids := make([]ansible.VaultID, 0, len(secrets))
+	for i, vs := range secrets {
+		data, err := resource.CommonCredentialExtractor(ctx, vs.Source, c.kube, vs.CommonCredentialSelectors)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %w", errGetInventory, err)
+			return nil, fmt.Errorf("%s %d: %w", errGetVaultPassword, i, err)
 		}
-		if _, err := buff.WriteString(string(data) + "\n"); err != nil {
-			return nil, err
+		// the file is named after its index, vault IDs being free form
+		p := filepath.Join(vaultDir, strconv.Itoa(i))
+		if err := c.fs.WriteFile(p, data, 0600); err != nil {
+			return nil, fmt.Errorf("%s %d: %w", errWriteVaultPassword, i, err)
 		}
+		ids = append(ids, ansible.VaultID{Label: vs.ID, File: p})
+	}
+	return ids, nil
+}
+
+// writeSSHCredentials writes the supplied SSH credentials to the files of the
+// ssh directory, replacing the ones of the previous reconciles, and returns
+// the options passing them to the runs.
+func (c *connector) writeSSHCredentials(ctx context.Context, dir string, creds *v1alpha1.SSHCredentials) (*ansible.SSHOptions, error) {
+	sshDir := filepath.Join(dir, runnerutil.SSHDir)
+	if err := c.fs.RemoveAll(sshDir); err != nil {
+		return nil, fmt.Errorf("%s: %w", errWriteSSHCredentials, err)
+	}
+	if creds == nil {
+		return nil, nil
+	}
+	if err := c.fs.MkdirAll(sshDir, 0700); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", sshDir, errMkdir, err)
+	}
+	key, err := resource.CommonCredentialExtractor(ctx, xpv1.CredentialsSourceSecret, c.kube, xpv1.CommonCredentialSelectors{SecretRef: &creds.PrivateKeySecretRef})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errGetSSHPrivateKey, err)
+	}
+	// ssh rejects the keys missing their final newline, which is easily
+	// lost when the Secret is created
+	if !bytes.HasSuffix(key, []byte("\n")) {
+		key = append(key, '\n')
+	}
+	o := &ansible.SSHOptions{
+		PrivateKeyFile: filepath.Join(sshDir, "id"),
+		User:           creds.User,
+		ConfigFile:     filepath.Join(sshDir, "config"),
+	}
+	if err := c.fs.WriteFile(o.PrivateKeyFile, key, 0600); err != nil {
+		return nil, fmt.Errorf("%s: %w", errWriteSSHCredentials, err)
+	}
+
+	config := []string{"Host *"}
+	if creds.Port != nil {
+		config = append(config, fmt.Sprintf("  Port %d", *creds.Port))
 	}
-	if cr.Spec.ForProvider.InventoryInline != nil {
-		if _, err := buff.WriteString(*cr.Spec.ForProvider.InventoryInline + "\n"); err != nil {
-			return nil, err
+	if creds.KnownHosts != "" {
+		knownHosts := filepath.Join(sshDir, "known_hosts")
+		if err := c.fs.WriteFile(knownHosts, []byte(creds.KnownHosts), 0600); err != nil {
+			return nil, fmt.Errorf("%s: %w", errWriteSSHCredentials, err)
 		}
+		config = append(config, "  UserKnownHostsFile "+knownHosts)
 	}
-	if buff.Len() != 0 {
-		if err := c.fs.WriteFile(filepath.Join(dir, runnerutil.Hosts), buff.Bytes(), inventoryPerm); err != nil {
-			return nil, fmt.Errorf("%s %s: %w", errWriteInventory, runnerutil.Hosts, err)
+	if strictHostKeyChecking(creds) {
+		config = append(config, "  StrictHostKeyChecking yes")
+	} else {
+		config = append(config, "  StrictHostKeyChecking no")
+	}
+	if err := c.fs.WriteFile(o.ConfigFile, []byte(strings.Join(config, "\n")+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("%s: %w", errWriteSSHCredentials, err)
+	}
+	return o, nil
+}
+
+// strictHostKeyChecking tells whether the keys of the hosts are checked by
+// the SSH connections of the supplied credentials.
+func strictHostKeyChecking(creds *v1alpha1.SSHCredentials) bool {
+	return creds.StrictHostKeyChecking == nil || *creds.StrictHostKeyChecking
+}
+
+// jobExecutorOf returns the Job executor of the AnsibleRuns using the supplied
+// ProviderConfig, configured by it, or nil when they run in the provider.
+func (c *connector) jobExecutorOf(pc *v1alpha1.ProviderConfig) (*ansible.JobExecutor, error) {
+	if c.executor == ansible.ExecutorFake {
+		return nil, nil
+	}
+	executor := pc.Spec.Executor
+	if executor == "" {
+		executor = c.executor
+	}
+	if executor != ansible.ExecutorJob {
+		return nil, nil
+	}
+	if c.jobExecutor == nil {
+		return nil, errors.New(errJobExecutorSetup)
+	}
+	je := *c.jobExecutor
+	if j := pc.Spec.Job; j != nil {
+		if j.Image != "" {
+			je.Image = j.Image
 		}
-		// WriteFile only sets permissions for new files, do an explicit chmod to ensure changing permissions are updated
-		// on existing files
-		err := c.fs.Chmod(filepath.Join(dir, runnerutil.Hosts), inventoryPerm)
-		if err != nil {
-			return nil, fmt.Errorf("%s %s: %w", errChmodInventory, runnerutil.Hosts, err)
+		if j.ServiceAccountName != "" {
+			je.ServiceAccountName = j.ServiceAccountName
+		}
+		if j.Resources != nil {
+			je.Resources = j.Resources
+		}
+		if j.NodeSelector != nil {
+			je.NodeSelector = j.NodeSelector
+		}
+		if j.Tolerations != nil {
+			je.Tolerations = j.Tolerations
 		}
+		if j.Affinity != nil {
+			je.Affinity = j.Affinity
+		}
+	}
+	if je.Image == "" {
+		return nil, errors.New(errJobExecutorImage)
 	}
+	return &je, nil
+}
 
-	var requirementRoles []byte
-	if len(cr.Spec.ForProvider.Roles) != 0 {
-		// marshall cr.Spec.ForProvider.Roles entries into yaml document
-		rolesMap := make(map[string][]v1alpha1.Role)
-		rolesMap["roles"] = cr.Spec.ForProvider.Roles
-		var err error
-		requirementRoles, err = yaml.Marshal(&rolesMap)
-		if err != nil {
-			return nil, fmt.Errorf("%s: %w", errMarshalRoles, err)
-		}
-		// prepare git credentials for ansible-galaxy to fetch remote roles
-		// TODO(fahed) support other private remote repository
-		// NOTE(ytsarev): Retrieve .git-credentials from Spec to /tmp outside of AnsibleRun directory
-		gitCredDir := filepath.Clean(filepath.Join("/tmp", dir))
-		if err := c.fs.MkdirAll(gitCredDir, 0700); err != nil {
-			return nil, fmt.Errorf("%s: %w", errWriteGitCreds, err)
-		}
-		for _, cd := range pc.Spec.Credentials {
-			if cd.Filename != gitCredentialsFilename {
-				continue
-			}
-			data, err := resource.CommonCredentialExtractor(ctx, cd.Source, c.kube, cd.CommonCredentialSelectors)
-			if err != nil {
-				return nil, fmt.Errorf("%s: %w", errGetCreds, err)
-			}
-			p := filepath.Clean(filepath.Join(gitCredDir, filepath.Base(cd.Filename)))
-			if err := c.fs.WriteFile(p, data, 0600); err != nil {
-				return nil, fmt.Errorf("%s: %w", errWriteGitCreds, err)
-			}
-			// NOTE(ytsarev): Make go-getter pick up .git-credentials, see /.gitconfig in the container image
-			// TODO: check wether go-getter is used in the ansible case
-			err = os.Setenv("GIT_CRED_DIR", gitCredDir)
-			if err != nil {
-				return nil, fmt.Errorf("%s: %w", errRemoteConfiguration, err)
-			}
-		}
-	} else if cr.Spec.ForProvider.PlaybookInline != nil {
-		if err := c.fs.WriteFile(filepath.Join(dir, runnerutil.PlaybookYml), []byte(*cr.Spec.ForProvider.PlaybookInline), 0600); err != nil {
-			return nil, fmt.Errorf("%s: %w", errWriteAnsibleRun, err)
+// impersonationVars requests a token of the supplied ServiceAccount with the
+// TokenRequest API, and returns the environment variables through which the
+// kubernetes.core modules connect to the Kubernetes API server with it.
+func (c *connector) impersonationVars(ctx context.Context, dir string, sa *v1alpha1.ServiceAccountImpersonation) (map[string]string, error) {
+	expiration := int64(defaultTokenExpirationSeconds)
+	if sa.ExpirationSeconds != nil {
+		expiration = *sa.ExpirationSeconds
+	}
+	tr := &authv1.TokenRequest{Spec: authv1.TokenRequestSpec{ExpirationSeconds: &expiration}}
+	account := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: sa.Name, Namespace: sa.Namespace}}
+	if err := c.kube.SubResource("token").Create(ctx, account, tr); err != nil {
+		return nil, fmt.Errorf("%s %s/%s: %w", errRequestToken, sa.Namespace, sa.Name, err)
+	}
+
+	vars := map[string]string{
+		"K8S_AUTH_API_KEY": tr.Status.Token,
+		// don't fall back to the kubeconfig or the in-cluster identity of
+		// the provider
+		"K8S_AUTH_KUBECONFIG": "",
+	}
+	if c.kubeAPI == nil {
+		return vars, nil
+	}
+	vars["K8S_AUTH_HOST"] = c.kubeAPI.Host
+	switch {
+	case c.kubeAPI.TLSClientConfig.CAFile != "":
+		vars["K8S_AUTH_SSL_CA_CERT"] = c.kubeAPI.TLSClientConfig.CAFile
+	case len(c.kubeAPI.TLSClientConfig.CAData) != 0:
+		p := filepath.Join(dir, kubeCAFile)
+		if err := c.fs.WriteFile(p, c.kubeAPI.TLSClientConfig.CAData, 0600); err != nil {
+			return nil, fmt.Errorf("%s: %w", errWriteKubeCA, err)
 		}
+		vars["K8S_AUTH_SSL_CA_CERT"] = p
+	}
+	if c.kubeAPI.TLSClientConfig.Insecure {
+		vars["K8S_AUTH_VERIFY_SSL"] = "false"
 	}
+	return vars, nil
+}
 
-	// Saved credentials needed for ansible playbooks execution
-	for _, cd := range pc.Spec.Credentials {
-		data, err := resource.CommonCredentialExtractor(ctx, cd.Source, c.kube, cd.CommonCredentialSelectors)
+// checkRoleVersions resolves the floating versions of the roles of the
+// supplied AnsibleRun when their check is due, records them in its status,
+// and tells whether any of them moved since the last check. Roles are
+// recorded without being considered moved on their first check.
+func checkRoleVersions(ctx context.Context, cr *v1alpha1.AnsibleRun, env []string) bool {
+	interval := cr.Spec.ForProvider.RoleVersionsCheckInterval
+	if interval == nil || interval.Duration <= 0 || len(cr.Spec.ForProvider.Roles) == 0 {
+		return false
+	}
+	if last := cr.Status.AtProvider.LastRoleVersionsCheck; last != nil && time.Since(last.Time) < interval.Duration {
+		return false
+	}
+	var resolved []v1alpha1.ResolvedRoleVersion
+	for _, role := range cr.Spec.ForProvider.Roles {
+		commit, floating, err := resolveFloatingVersion(ctx, role.Src, role.Version, env)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %w", errGetCreds, err)
+			// an unreachable repository must not block the runs, the check
+			// is attempted again on the next reconcile
+			log.FromContext(ctx).V(1).Info("resolving the floating version of a role", "role", role.Name, "err", err)
+			return false
 		}
-		p := filepath.Clean(filepath.Join(dir, filepath.Base(cd.Filename)))
-		if err := c.fs.WriteFile(p, data, 0600); err != nil {
-			return nil, fmt.Errorf("%s: %w", errWriteCreds, err)
+		if floating {
+			resolved = append(resolved, v1alpha1.ResolvedRoleVersion{Name: role.Name, Version: role.Version, Commit: commit})
 		}
 	}
+	now := metav1.Now()
+	recorded := cr.Status.AtProvider.LastRoleVersionsCheck != nil
+	moved := recorded && !equality.Semantic.DeepEqual(cr.Status.AtProvider.RoleVersions, resolved)
+	cr.Status.AtProvider.RoleVersions = resolved
+	cr.Status.AtProvider.LastRoleVersionsCheck = &now
+	return moved
+}
 
-	ps := c.ansible(dir)
-
-	// prepare behavior vars
-	behaviorVars := addBehaviorVars(pc)
-
-	// Requirements is a list of collections/roles to be installed, it is stored in requirements file
-	requirementRolesStr := string(requirementRoles)
-	if pc.Spec.Requirements != nil || requirementRolesStr != "" {
-		var installCollections, installRoles bool
-		var reqSlice []string
-		if pc.Spec.Requirements != nil {
-			reqSlice = append(reqSlice, *pc.Spec.Requirements)
-			installCollections = true
-			installRoles = true
+// writeFsCredentialsDir copies the files matched by the path of Filesystem
+// credentials into the directory named after their filename in the project
+// directory, when the path is a directory or a glob, e.g. a whole .ssh
+// directory mounted into the provider pod. It returns false when the path is
+// a single file, which is written like any other credentials.
+func (c *connector) writeFsCredentialsDir(projectDir string, cd v1alpha1.ProviderCredentials) (bool, error) {
+	path := cd.Fs.Path
+	var matches []string
+	if strings.ContainsAny(path, "*?[") {
+		var err error
+		matches, err = afero.Glob(c.fs, path)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", errGetCreds, err)
 		}
-		if requirementRolesStr != "" {
-			reqSlice = append(reqSlice, requirementRolesStr)
-			installRoles = true
+		if len(matches) == 0 {
+			return false, fmt.Errorf("%s: %s %q", errGetCreds, errNoFsCreds, path)
 		}
-
-		// write requirements to requirements.yml
-		req := strings.Join(reqSlice, "\n")
-		if err := c.fs.WriteFile(filepath.Join(dir, galaxyutil.RequirementsFile), []byte(req), 0600); err != nil {
-			return nil, fmt.Errorf("%s: %w", errWriteConfig, err)
+	} else {
+		if fi, err := c.fs.Stat(path); err != nil || !fi.IsDir() {
+			return false, nil
 		}
-		// install ansible requirements using ansible-galaxy
-		if installCollections {
-			if err := ps.GalaxyInstall(ctx, behaviorVars, "collection"); err != nil {
-				return nil, err
-			}
+		infos, err := c.fs.ReadDir(path)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", errGetCreds, err)
 		}
-		if installRoles {
-			if err := ps.GalaxyInstall(ctx, behaviorVars, "role"); err != nil {
-				return nil, err
-			}
+		for _, fi := range infos {
+			matches = append(matches, filepath.Join(path, fi.Name()))
 		}
-
 	}
 
-	r, err := ps.Init(ctx, cr, behaviorVars)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", errInit, err)
-
+	dir := filepath.Clean(filepath.Join(projectDir, filepath.Base(cd.Filename)))
+	if err := c.fs.MkdirAll(dir, 0700); err != nil {
+		return false, fmt.Errorf("%s: %w", errWriteCreds, err)
 	}
-
-	return &external{runner: r, kube: c.kube}, nil
+	for _, m := range matches {
+		// skip the hidden entries of the atomic writer of mounted volumes,
+		// e.g. ..data, and the subdirectories
+		if strings.HasPrefix(filepath.Base(m), "..") {
+			continue
+		}
+		fi, err := c.fs.Stat(m)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", errGetCreds, err)
+		}
+		if fi.IsDir() {
+			continue
+		}
+		data, err := c.fs.ReadFile(m)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", errGetCreds, err)
+		}
+		if err := c.fs.WriteFile(filepath.Join(dir, filepath.Base(m)), data, 0600); err != nil {
+			return false, fmt.Errorf("%s: %w", errWriteCreds, err)
+		}
+	}
+	return true, nil
 }
 
 type external struct {
 	runner ansibleRunner
 	kube   client.Client
+	// rolesMoved tells whether the floating version of a role moved to
+	// another commit, which requires running the contents again
+	rolesMoved bool
+	// varsFromHash is the digest of the vars read from Secrets and
+	// ConfigMaps, which run the contents again when it changes
+	varsFromHash string
+	// extraVarsHash is the digest of the extra vars of the ProviderConfig,
+	// compared to the one of the last run
+	extraVarsHash string
+	audit         audit.Logger
+	fs            afero.Afero
+	// workDir is the working directory of the AnsibleRun
+	workDir string
+	// artifactsDir holds the artifacts of the runs of the AnsibleRun
+	artifactsDir string
+	recorder     event.Recorder
+	// admin lets operators cancel the runs and force new ones, nil when
+	// the admin API is disabled
+	admin *admin
+	// groups schedules the runs of the AnsibleRuns of the same concurrency
+	// group one at a time
+	groups *runGroups
+	// hosts keeps the runs on the same hosts from running at the same
+	// time, nil when the ProviderConfig doesn't lock hosts
+	hosts *hostLocks
+	// pressure throttles the runs while the provider is short of disk
+	// space or memory, nil when unchecked
+	pressure *pressureMonitor
+	// pollInterval and pollJitter space the runs of the AlwaysApply policy
+	pollInterval time.Duration
+	pollJitter   time.Duration
+	// driftStorage configures where the drift is stored, in the status
+	// when nil
+	driftStorage *v1alpha1.DriftStorage
+	// cancel cancels the context of the current reconcile
+	cancel context.CancelFunc
+	// forced tells whether the AnsibleRun is forced to run again through
+	// the admin API
+	forced bool
+	// persisted is the AnsibleRun as last read from or written to the API
+	// server, whose status the status updates are merged from on conflict
+	persisted *v1alpha1.AnsibleRun
+}
+
+// cancelable returns a context of the supplied reconcile context that the
+// admin API cancels, along with the func releasing it. The reconcile context
+// is returned as is when the admin API is disabled.
+func (c *external) cancelable(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.admin == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	return ctx, cancel
 }
 
-// nolint: gocyclo
-// TODO reduce cyclomatic complexity
 func (c *external) Observe(ctx context.Context, mg resource.Managed) (managed.ExternalObservation, error) {
 	cr, ok := mg.(*v1alpha1.AnsibleRun)
 	if !ok {
 		return managed.ExternalObservation{}, errors.New(errNotAnsibleRun)
 	}
+	lateInitialized := lateInitialize(cr)
+	defer workdirLocks.lock(c.workDir)()
+	// the AnsibleRun is updated, whatever its policy, when it is forced to
+	// run again
+	if c.admin.rerunForced(cr.GetName()) && !meta.WasDeleted(cr) {
+		c.forced = true
+		return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: false, ResourceLateInitialized: lateInitialized}, nil
+	}
+	ctx, cancel := c.cancelable(ctx)
+	defer cancel()
+	o, err := c.observe(ctx, cr)
+	if err != nil {
+		return o, err
+	}
+	// reported along with the status of the observation
+	if err := c.observeDiskUsage(cr); err != nil {
+		log.FromContext(ctx).V(1).Info("measuring the disk usage of the working directory", "err", err)
+	}
+	o.ResourceLateInitialized = o.ResourceLateInitialized || lateInitialized
+	return o, nil
+}
+
+// lateInitialize writes the defaults chosen by the provider for the unset
+// fields of the supplied AnsibleRun back into it, so that they are visible
+// and kept if the defaults change. It tells whether any field was set.
+func lateInitialize(cr *v1alpha1.AnsibleRun) bool {
+	var li bool
+	if ansible.RunPolicyOf(cr) == "" {
+		ansible.SetPolicyRun(cr, "ObserveAndDelete")
+		li = true
+	}
+	if cr.Spec.ForProvider.StateVar == "" {
+		cr.Spec.ForProvider.StateVar = ansible.DefaultStateVar
+		li = true
+	}
+	return li
+}
+
+// nolint: gocyclo
+// TODO reduce cyclomatic complexity
+func (c *external) observe(ctx context.Context, cr *v1alpha1.AnsibleRun) (managed.ExternalObservation, error) {
 	/* set Deletion Policy to Orphan as we cannot observe the external resource.
 	   So we won't wait for external resource deletion before attempting
 	   to delete the managed resource */
 	cr.SetDeletionPolicy(xpv1.DeletionOrphan)
 
 	switch c.runner.GetAnsibleRunPolicy().Name {
-	case "ObserveAndDelete", "":
-		if c.runner.GetAnsibleRunPolicy().Name == "" {
-			ansible.SetPolicyRun(cr, "ObserveAndDelete")
-		}
+	case "ObserveAndDelete", "AlwaysApply", "":
 		if meta.WasDeleted(cr) {
 			return managed.ExternalObservation{ResourceExists: true}, nil
 		}
@@ -353,23 +933,41 @@ func (c *external) Observe(ctx context.Context, mg resource.Managed) (managed.Ex
 		}
 		return c.handleLastApplied(ctx, lastParameters, cr)
 	case "CheckWhenObserve":
-		stateVar := make(map[string]string)
-		stateVar["state"] = "present"
-		nestedMap := make(map[string]interface{})
-		nestedMap[cr.GetName()] = stateVar
-		if err := c.runner.WriteExtraVar(nestedMap); err != nil {
-			return managed.ExternalObservation{}, err
-		}
-		c.runner.EnableCheckMode(true)
-		stdoutBuf, err := c.runner.Run(ctx)
+		release, ok, err := c.acquireGroup(ctx, cr)
 		if err != nil {
 			return managed.ExternalObservation{}, err
 		}
-		res, err := results.ParseJSONResultsStream(stdoutBuf)
+		if !ok {
+			return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
+		}
+		defer release()
+		c.runner.SetTrigger(ansible.TriggerObserve)
+		start := time.Now()
+		res, err := c.runner.Check(ctx)
+		c.auditRun(ctx, cr, audit.StepCheck, start, err)
+		setLastRun(cr, c.runner.LastRun())
 		if err != nil {
-			return managed.ExternalObservation{}, err
+			// a failed check run is reported in the Ready condition rather
+			// than returned, so that the poll interval hook decides when it
+			// runs again
+			var runErr *ansible.RunError
+			if !errors.As(err, &runErr) {
+				return managed.ExternalObservation{}, err
+			}
+			cr.SetConditions(runFailedCondition(err))
+			return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
+		}
+		drift := ansible.Diff(res)
+		changes := drift != nil
+		if !changes {
+			c.clearStoredDrift(ctx, cr)
+			pendingChanges(cr, false, time.Time{})
+		}
+		c.recordDrift(cr, drift)
+		setDrift(cr, drift, time.Now())
+		if changes {
+			c.storeDrift(ctx, cr, res)
 		}
-		changes := ansible.Diff(res)
 
 		// At this level, the ansible cannot detect the existence or not of the external resource
 		// due to the lack of the state in the ansible technology. So we consider that the externl resource
@@ -397,15 +995,54 @@ func (c *external) Update(ctx context.Context, mg resource.Managed) (managed.Ext
 	if !ok {
 		return managed.ExternalUpdate{}, errors.New(errNotAnsibleRun)
 	}
+	defer workdirLocks.lock(c.workDir)()
+
+	ctx, cancel := c.cancelable(ctx)
+	defer cancel()
 
-	// disable checkMode for real action
-	c.runner.EnableCheckMode(false)
-	if err := c.runAnsible(ctx, cr); err != nil {
+	// the runs forced by operators are neither limited nor deferred
+	switch {
+	case c.forced:
+		c.runner.SetTrigger(ansible.TriggerAdmin)
+	case retriesExhausted(cr):
+		return managed.ExternalUpdate{}, fmt.Errorf("%s: %d", errRetryLimit, *cr.Spec.ForProvider.RetryLimit)
+	default:
+		deferred, err := deferRun(cr, time.Now())
+		if err != nil {
+			return managed.ExternalUpdate{}, err
+		}
+		if deferred {
+			// the drift is found again by the check runs until the deferred run
+			if err := c.updateStatus(ctx, cr); err != nil {
+				return managed.ExternalUpdate{}, fmt.Errorf("updating status: %w", err)
+			}
+			return managed.ExternalUpdate{}, nil
+		}
+		// otherwise only the CheckWhenObserve policy updates, when its
+		// check run found changes
+		c.runner.SetTrigger(ansible.TriggerDrift)
+	}
+	// even the forced runs wait for their concurrency group
+	release, ok, err := c.acquireGroup(ctx, cr)
+	if err != nil {
+		return managed.ExternalUpdate{}, err
+	}
+	if !ok {
+		if err := c.updateStatus(ctx, cr); err != nil {
+			return managed.ExternalUpdate{}, fmt.Errorf("updating status: %w", err)
+		}
+		return managed.ExternalUpdate{}, nil
+	}
+	defer release()
+	if c.forced {
+		c.admin.rerunDone(cr.GetName())
+	}
+	cd, err := c.runAnsible(ctx, cr)
+	if err != nil {
 		return managed.ExternalUpdate{}, fmt.Errorf("running ansible: %w", err)
 	}
 
-	// TODO handle ConnectionDetails https://github.com/multicloudlab/crossplane-provider-ansible/pull/74#discussion_r888467991
-	return managed.ExternalUpdate{ConnectionDetails: nil}, nil
+	return managed.ExternalUpdate{ConnectionDetails: cd}, nil
 }
 
 func (c *external) Delete(ctx context.Context, mg resource.Managed) error {
@@ -413,23 +1050,170 @@ func (c *external) Delete(ctx context.Context, mg resource.Managed) error {
 	if !ok {
 		return errors.New(errNotAnsibleRun)
 	}
+	defer workdirLocks.lock(c.workDir)()
 
-	cr.Status.SetConditions(xpv1.Deleting())
+	ctx, cancel := c.cancelable(ctx)
+	defer cancel()
 
-	stateVar := make(map[string]string)
-	stateVar["state"] = "absent"
-	nestedMap := make(map[string]interface{})
-	nestedMap[cr.GetName()] = stateVar
-	if err := c.runner.WriteExtraVar(nestedMap); err != nil {
-		return err
+	// the AnsibleRuns force deleted neither run nor wait for their group
+	if forceDeleted(cr) {
+		c.skipTeardown(cr, fmt.Sprintf("the AnsibleRun has the %s annotation", AnnotationKeyForceDelete))
+		return c.deleted(ctx, cr)
 	}
-	_, err := c.runner.Run(ctx)
+
+	// the error keeps the finalizer until the concurrency group is released
+	release, ok, err := c.acquireGroup(ctx, cr)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		if throttledRun(cr) {
+			return errors.New(errThrottled)
+		}
+		return errors.New(errQueued)
+	}
+	defer release()
+
+	// the phase handler persists the teardown in progress once the run starts
+	cr.Status.SetConditions(xpv1.Deleting(), teardown(v1.ConditionFalse, v1alpha1.ReasonTearingDown, ""))
+
+	c.runner.SetTrigger(ansible.TriggerDeletion)
+	start := time.Now()
+	err = c.runner.Destroy(ctx)
+	c.auditRun(ctx, cr, audit.StepDestroy, start, err)
+	switch {
+	case err != nil && deletionRetriesExhausted(cr):
+		c.skipTeardown(cr, fmt.Sprintf("%d failed teardowns, the last one with: %s", cr.Status.AtProvider.FailedTeardowns, err))
+	case err != nil:
+		// the error keeps the finalizer, so that the teardown is retried
+		cr.Status.SetConditions(teardown(v1.ConditionFalse, v1alpha1.ReasonTeardownFailed, err.Error()))
+		return err
+	default:
+		cr.Status.SetConditions(teardown(v1.ConditionTrue, v1alpha1.ReasonTornDown, ""))
+	}
+	return c.deleted(ctx, cr)
+}
+
+// deleted cleans up after the teardown of the supplied AnsibleRun, run or
+// skipped, before its finalizer goes.
+func (c *external) deleted(ctx context.Context, cr *v1alpha1.AnsibleRun) error {
+	// the error keeps the finalizer, the teardown already ran though
+	if err := c.revokeConnectionDetails(ctx, cr); err != nil {
+		return fmt.Errorf("%s: %w", errRevokeConnection, err)
+	}
+	forgetDiskUsage(cr.GetName())
+	forgetDrift(cr.GetName())
 	return nil
 }
 
+// forceDeleted tells whether the supplied AnsibleRun is deleted without
+// running its teardown.
+func forceDeleted(cr *v1alpha1.AnsibleRun) bool {
+	return cr.GetAnnotations()[AnnotationKeyForceDelete] == "true"
+}
+
+// deletionRetriesExhausted counts a failed run deleting the supplied
+// AnsibleRun, and tells whether the failed runs used up its deletion retry
+// limit.
+func deletionRetriesExhausted(cr *v1alpha1.AnsibleRun) bool {
+	cr.Status.AtProvider.FailedTeardowns++
+	limit := cr.Spec.ForProvider.DeletionRetryLimit
+	// the first run is not a retry
+	return limit != nil && cr.Status.AtProvider.FailedTeardowns > *limit
+}
+
+// skipTeardown records that the teardown of the supplied AnsibleRun is given
+// up for the supplied reason, in its status and in a warning event, which
+// outlives the AnsibleRun.
+func (c *external) skipTeardown(cr *v1alpha1.AnsibleRun, reason string) {
+	cr.Status.SetConditions(xpv1.Deleting(), teardown(v1.ConditionFalse, v1alpha1.ReasonTeardownSkipped, reason))
+	if c.recorder != nil {
+		c.recorder.Event(cr, event.Warning(reasonTeardownSkipped, fmt.Errorf("%s: %s", errTeardownSkipped, reason)))
+	}
+}
+
+// Values of spec.forProvider.connectionDetailsOnDelete.
+const (
+	connectionDetailsKeep      = "Keep"
+	connectionDetailsRemove    = "Remove"
+	connectionDetailsTombstone = "Tombstone"
+
+	// connectionTombstone replaces the values of the connection secret of the
+	// deleted AnsibleRuns whose connection details are tombstoned
+	connectionTombstone = "revoked"
+)
+
+// revokeConnectionDetails empties the connection secret of the supplied
+// AnsibleRun torn down, or replaces its values by tombstones, as its
+// connectionDetailsOnDelete requests, so that its consumers don't keep using
+// the credentials the teardown revoked until the secret is garbage collected.
+// The secrets the AnsibleRun doesn't control are left as is.
+func (c *external) revokeConnectionDetails(ctx context.Context, cr *v1alpha1.AnsibleRun) error {
+	ref := cr.GetWriteConnectionSecretToReference()
+	policy := cr.Spec.ForProvider.ConnectionDetailsOnDelete
+	if ref == nil || policy == "" || policy == connectionDetailsKeep {
+		return nil
+	}
+	s := &v1.Secret{}
+	if err := c.kube.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, s); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(s, cr) {
+		return nil
+	}
+	switch policy {
+	case connectionDetailsRemove:
+		s.Data = nil
+	case connectionDetailsTombstone:
+		for k := range s.Data {
+			s.Data[k] = []byte(connectionTombstone)
+		}
+	}
+	return c.kube.Update(ctx, s)
+}
+
+// teardown returns the TornDown condition of an AnsibleRun being deleted.
+func teardown(status v1.ConditionStatus, reason xpv1.ConditionReason, msg string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypeTornDown,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            statusutil.Message(msg, statusutil.MaxMessageBytes),
+	}
+}
+
+// setDrift records the supplied drift found by a check mode run at now in the
+// status of the supplied AnsibleRun, no drift when nil. A drift found again
+// keeps the time it was first detected.
+func setDrift(cr *v1alpha1.AnsibleRun, drift *v1alpha1.Drift, now time.Time) {
+	if drift == nil {
+		cr.Status.AtProvider.Drifted = false
+		cr.Status.AtProvider.Drift = nil
+		cr.SetConditions(driftCondition(v1.ConditionFalse, v1alpha1.ReasonNoDrift, ""))
+		return
+	}
+	drift.DetectedTime = metav1.NewTime(now)
+	if last := cr.Status.AtProvider.Drift; last != nil {
+		drift.DetectedTime = last.DetectedTime
+	}
+	cr.Status.AtProvider.Drifted = true
+	cr.Status.AtProvider.Drift = drift
+	cr.SetConditions(driftCondition(v1.ConditionTrue, v1alpha1.ReasonDriftDetected, describeDrift(drift)))
+}
+
+// driftCondition returns the Drifted condition with the supplied status,
+// reason and message.
+func driftCondition(status v1.ConditionStatus, reason xpv1.ConditionReason, msg string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypeDrifted,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            msg,
+	}
+}
+
 func getLastAppliedParameters(observed *v1alpha1.AnsibleRun) (*v1alpha1.AnsibleRunParameters, error) {
 	lastApplied, ok := observed.GetAnnotations()[v1.LastAppliedConfigAnnotation]
 	if !ok {
@@ -444,41 +1228,110 @@ func getLastAppliedParameters(observed *v1alpha1.AnsibleRun) (*v1alpha1.AnsibleR
 }
 
 func (c *external) handleLastApplied(ctx context.Context, lastParameters *v1alpha1.AnsibleRunParameters, desired *v1alpha1.AnsibleRun) (managed.ExternalObservation, error) {
+	// parameters applied before being late initialized used the defaults
+	if lastParameters != nil && lastParameters.StateVar == "" {
+		lastParameters.StateVar = ansible.DefaultStateVar
+	}
+	// moving the run policy from the annotation to the spec, or changing
+	// it, doesn't change the contents to apply
+	if lastParameters != nil {
+		lastParameters.RunPolicy = desired.Spec.ForProvider.RunPolicy
+	}
 	// Mark as up-to-date if last is equal to desired
-	isUpToDate := (lastParameters != nil && equality.Semantic.DeepEqual(*lastParameters, desired.Spec.ForProvider))
+	specUnchanged := lastParameters != nil && equality.Semantic.DeepEqual(*lastParameters, desired.Spec.ForProvider)
+	// the AlwaysApply policy runs the contents again once per poll interval
+	applyDue := c.runner.GetAnsibleRunPolicy().Name == "AlwaysApply" && c.applyDue(desired, time.Now())
+	// the vars read from Secrets and ConfigMaps changed since the last run
+	varsChanged := c.varsFromHash != desired.Status.AtProvider.VarsFromHash
+	// the extra vars of the ProviderConfig changed since the last run
+	extraVarsChanged := c.extraVarsHash != desired.Status.AtProvider.ExtraVarsHash
+	isUpToDate := specUnchanged && !c.rolesMoved && !varsChanged && !extraVarsChanged && !applyDue
 
 	isLastSyncOK := (desired.GetCondition(xpv1.TypeSynced).Status == v1.ConditionTrue)
+	// the failed runs are retried like the failed reconciles
+	isLastRunOK := failedRuns(desired) == nil
 
-	if isUpToDate && isLastSyncOK {
+	if isUpToDate && isLastSyncOK && isLastRunOK {
+		desired.Status.AtProvider.NextRunTime = nil
+		pendingChanges(desired, false, time.Time{})
 		desired.SetConditions(xpv1.Available())
-		if err := c.kube.Status().Update(ctx, desired); err != nil {
+		if err := c.updateStatus(ctx, desired); err != nil {
 			return managed.ExternalObservation{}, fmt.Errorf("updating status: %w", err)
 		}
 		// nothing to do for this run
 		return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
 	}
 
+	// moved roles and changed vars are new contents rather than a retry
+	if !c.rolesMoved && !varsChanged && !extraVarsChanged && retriesExhausted(desired) {
+		return managed.ExternalObservation{}, fmt.Errorf("%s: %d", errRetryLimit, *desired.Spec.ForProvider.RetryLimit)
+	}
+
+	// the last applied parameters are only updated once the run starts, so
+	// that the deferred run still happens
+	deferred, err := deferRun(desired, time.Now())
+	if err != nil {
+		return managed.ExternalObservation{}, err
+	}
+	if deferred {
+		if err := c.updateStatus(ctx, desired); err != nil {
+			return managed.ExternalObservation{}, fmt.Errorf("updating status: %w", err)
+		}
+		return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
+	}
+
+	// the last applied parameters are only updated once the run starts, so
+	// that the queued run still happens
+	release, ok, err := c.acquireGroup(ctx, desired)
+	if err != nil {
+		return managed.ExternalObservation{}, err
+	}
+	if !ok {
+		if err := c.updateStatus(ctx, desired); err != nil {
+			return managed.ExternalObservation{}, fmt.Errorf("updating status: %w", err)
+		}
+		return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
+	}
+	defer release()
+
 	out, err := json.Marshal(desired.Spec.ForProvider)
 	if err != nil {
 		return managed.ExternalObservation{}, err
 	}
 	// set LastAppliedConfig Annotation to avoid useless cmd run
-	meta.AddAnnotations(desired, map[string]string{
+	if err := c.applyMetadata(ctx, desired, nil, map[string]string{
 		v1.LastAppliedConfigAnnotation: string(out),
-	})
-
-	if err := c.kube.Update(ctx, desired); err != nil {
+	}); err != nil {
 		return managed.ExternalObservation{}, err
 	}
-	stateVar := make(map[string]string)
-	stateVar["state"] = "present"
-	nestedMap := make(map[string]interface{})
-	nestedMap[desired.GetName()] = stateVar
-	if err := c.runner.WriteExtraVar(nestedMap); err != nil {
-		return managed.ExternalObservation{}, err
+	// recorded along with the last applied parameters, the status being
+	// updated by the run
+	desired.Status.AtProvider.VarsFromHash = c.varsFromHash
+	desired.Status.AtProvider.ExtraVarsHash = c.extraVarsHash
+
+	// adopt the external state as is when it already matches the desired state,
+	// instead of applying the contents on the first run
+	if lastParameters == nil && desired.Spec.ForProvider.AdoptExisting {
+		c.runner.SetTrigger(ansible.TriggerAdopt)
+		start := time.Now()
+		res, err := c.runner.Check(ctx)
+		c.auditRun(ctx, desired, audit.StepCheck, start, err)
+		setLastRun(desired, c.runner.LastRun())
+		if err != nil {
+			return managed.ExternalObservation{}, fmt.Errorf("%s: %w", errAdoptExisting, err)
+		}
+		if ansible.Diff(res) == nil {
+			desired.SetConditions(xpv1.Available())
+			if err := c.updateStatus(ctx, desired); err != nil {
+				return managed.ExternalObservation{}, fmt.Errorf("updating status: %w", err)
+			}
+			return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
+		}
 	}
 
-	if err := c.runAnsible(ctx, desired); err != nil {
+	c.runner.SetTrigger(lastAppliedTrigger(lastParameters, specUnchanged, c.rolesMoved, varsChanged, extraVarsChanged, applyDue))
+	cd, err := c.runAnsible(ctx, desired)
+	if err != nil {
 		return managed.ExternalObservation{}, fmt.Errorf("running ansible: %w", err)
 	}
 
@@ -486,30 +1339,633 @@ func (c *external) handleLastApplied(ctx context.Context, lastParameters *v1alph
 	// Nothing will notify us if and when the ansible content we manage
 	// changes, so we requeue a speculative reconcile after the specified poll
 	// interval in order to observe it and react accordingly.
-	return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true}, nil
+	return managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true, ConnectionDetails: cd}, nil
+}
+
+// lastAppliedTrigger tells why the ObserveAndDelete and AlwaysApply policies
+// run the contents, given the last applied parameters.
+func lastAppliedTrigger(lastParameters *v1alpha1.AnsibleRunParameters, specUnchanged, rolesMoved, varsChanged, extraVarsChanged, applyDue bool) string {
+	switch {
+	case lastParameters == nil:
+		return ansible.TriggerFirstRun
+	case !specUnchanged:
+		return ansible.TriggerSpecChanged
+	case rolesMoved:
+		return ansible.TriggerRolesMoved
+	case varsChanged:
+		return ansible.TriggerVarsFromChanged
+	case extraVarsChanged:
+		return ansible.TriggerExtraVarsChanged
+	case applyDue:
+		return ansible.TriggerAlwaysApply
+	default:
+		// up to date, but the last reconcile failed
+		return ansible.TriggerRetry
+	}
 }
 
-func (c *external) runAnsible(ctx context.Context, cr *v1alpha1.AnsibleRun) error {
-	_, err := c.runner.Run(ctx)
+// applyDue tells whether the AlwaysApply policy runs the contents of the
+// supplied AnsibleRun again at now, i.e. whether its poll interval elapsed
+// since its last run ended. The reconciles requeued early by the jitter of the
+// poll interval are due as well, while the ones following the updates of the
+// AnsibleRun by the last run are not.
+func (c *external) applyDue(cr *v1alpha1.AnsibleRun, now time.Time) bool {
+	last := cr.Status.AtProvider.LastRun
+	if last == nil {
+		return true
+	}
+	interval := c.pollInterval
+	if o := cr.Spec.ForProvider.PollInterval; o != nil && o.Duration > 0 {
+		interval = o.Duration
+	}
+	interval = max(interval-c.pollJitter, interval/2)
+	return now.Sub(last.EndTime.Time) >= interval
+}
+
+// runAnsible applies the contents of the supplied AnsibleRun and returns the
+// connection details found in the run. A failed run is reported in the Ready
+// condition of the AnsibleRun rather than returned, so that the poll interval
+// hook decides when it is retried; only failing to record the run is.
+func (c *external) runAnsible(ctx context.Context, cr *v1alpha1.AnsibleRun) (managed.ConnectionDetails, error) {
+	// the create contents are run until the first run succeeds
+	apply, step := c.runner.Apply, audit.StepApply
+	if !cr.Status.AtProvider.Created && hasCreateContents(cr) {
+		apply, step = c.runner.Create, audit.StepCreate
+	}
+	start := time.Now()
+	lastApply := metav1.NewTime(start)
+	cr.Status.AtProvider.LastApplyTime = &lastApply
+	err := apply(ctx)
+	c.auditRun(ctx, cr, step, start, err)
+	cr.Status.AtProvider.Warnings = c.runner.Warnings()
+	cr.Status.AtProvider.SlowestTasks = c.runner.SlowestTasks()
+	setLastRun(cr, c.runner.LastRun())
+	if h := c.runner.ContentHash(); h != "" {
+		cr.Status.AtProvider.ContentHash = h
+		if v := ansible.ContentHashLabelValue(h); cr.GetLabels()[ansible.LabelKeyContentHash] != v {
+			if lerr := c.applyMetadata(ctx, cr, map[string]string{ansible.LabelKeyContentHash: v}, nil); lerr != nil {
+				return nil, fmt.Errorf("%s: %w", errLabelContentHash, lerr)
+			}
+		}
+	}
+	var cd managed.ConnectionDetails
 	if err != nil {
-		cond := xpv1.Unavailable()
-		cond.Message = err.Error()
-		cr.SetConditions(cond)
+		cr.SetConditions(runFailedCondition(err))
+		recordFailedRun(cr, time.Now())
 	} else {
+		cr.Status.AtProvider.FailedRuns = nil
+		cr.Status.AtProvider.Created = true
+		completeProgress(cr, c.runner.LastRun())
+		if cr.Status.AtProvider.Drifted {
+			cr.SetConditions(driftCondition(v1.ConditionFalse, v1alpha1.ReasonDriftCorrected, ""))
+		}
+		c.clearStoredDrift(ctx, cr)
+		cr.Status.AtProvider.Drifted = false
+		cr.Status.AtProvider.Drift = nil
 		cr.SetConditions(xpv1.Available())
+		// the connection details that are found are published anyway, the
+		// missing ones are reported along with the ignored failures
+		var cdErr error
+		cd, cdErr = c.runner.ConnectionDetails()
+		if cdErr != nil {
+			cr.Status.AtProvider.Warnings = append(cr.Status.AtProvider.Warnings, fmt.Sprintf("%s: %s", errConnectionDetails, cdErr))
+		}
 	}
 
-	if err := c.kube.Status().Update(ctx, cr); err != nil {
-		return fmt.Errorf("updating status: %w", err)
+	if err := c.updateStatus(ctx, cr); err != nil {
+		return nil, fmt.Errorf("updating status: %w", err)
 	}
 
-	return err
+	return cd, nil
 }
 
-func addBehaviorVars(pc *v1alpha1.ProviderConfig) map[string]string {
+// deferRun tells whether the run applying the supplied AnsibleRun must wait
+// for the end of its minimum interval between runs, for the backoff of the
+// retries of its failed runs, or for the next maintenance window of its
+// schedule, and records when the run is due in its status.
+func deferRun(cr *v1alpha1.AnsibleRun, now time.Time) (bool, error) {
+	cr.Status.AtProvider.NextRunTime = nil
+	next := now
+	if minInterval, last := cr.Spec.ForProvider.MinIntervalBetweenRuns, cr.Status.AtProvider.LastApplyTime; minInterval != nil && last != nil {
+		if due := last.Add(minInterval.Duration); due.After(next) {
+			next = due
+		}
+	}
+	if b, f := cr.Spec.ForProvider.Backoff, failedRuns(cr); b != nil && f != nil {
+		if retry := f.LastFailureTime.Add(backoffDelay(b, f.Count)); retry.After(next) {
+			next = retry
+		}
+	}
+	if s := cr.Spec.ForProvider.Schedule; s != nil {
+		open, err := nextWindow(s, next)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", errSchedule, err)
+		}
+		pendingChanges(cr, open.After(next), open)
+		next = open
+	}
+	if !next.After(now) {
+		return false, nil
+	}
+	due := metav1.NewTime(next)
+	cr.Status.AtProvider.NextRunTime = &due
+	return true, nil
+}
+
+// failedRuns returns the consecutive failed runs applying the current spec
+// of the supplied AnsibleRun, nil when there are none.
+func failedRuns(cr *v1alpha1.AnsibleRun) *v1alpha1.FailedRuns {
+	if f := cr.Status.AtProvider.FailedRuns; f != nil && f.Generation == cr.GetGeneration() {
+		return f
+	}
+	return nil
+}
+
+// recordFailedRun counts a failed run applying the supplied AnsibleRun,
+// starting over when its spec changed since the previous failures.
+func recordFailedRun(cr *v1alpha1.AnsibleRun, now time.Time) {
+	f := failedRuns(cr)
+	if f == nil {
+		f = &v1alpha1.FailedRuns{Generation: cr.GetGeneration()}
+	}
+	f.Count++
+	f.LastFailureTime = metav1.NewTime(now)
+	cr.Status.AtProvider.FailedRuns = f
+}
+
+// retriesExhausted tells whether the failed runs applying the current spec of
+// the supplied AnsibleRun used up its retry limit.
+func retriesExhausted(cr *v1alpha1.AnsibleRun) bool {
+	limit, f := cr.Spec.ForProvider.RetryLimit, failedRuns(cr)
+	// the first run is not a retry
+	return limit != nil && f != nil && f.Count > *limit
+}
+
+// backoffDelay returns the delay of the retry following the supplied number
+// of consecutive failed runs.
+func backoffDelay(b *v1alpha1.Backoff, failed int32) time.Duration {
+	factor := time.Duration(b.Factor)
+	if factor < 1 {
+		factor = 2
+	}
+	d := b.Duration.Duration
+	for i := int32(1); i < failed && factor > 1; i++ {
+		if b.Cap != nil && d >= b.Cap.Duration {
+			break
+		}
+		if d > math.MaxInt64/factor {
+			d = math.MaxInt64
+			break
+		}
+		d *= factor
+	}
+	if b.Cap != nil && d > b.Cap.Duration {
+		d = b.Cap.Duration
+	}
+	return d
+}
+
+// setLastRun reports the supplied run as the last run of the AnsibleRun,
+// keeping the previous one when the run didn't start.
+func setLastRun(cr *v1alpha1.AnsibleRun, lastRun *v1alpha1.RunSummary) {
+	if lastRun != nil {
+		cr.Status.AtProvider.LastRun = lastRun
+	}
+}
+
+// hasCreateContents tells whether the first run of the supplied AnsibleRun
+// differs from the following ones.
+func hasCreateContents(cr *v1alpha1.AnsibleRun) bool {
+	return cr.Spec.ForProvider.CreatePlaybookInline != nil || len(cr.Spec.ForProvider.CreateTags) != 0
+}
+
+// auditRun records the run of the supplied step of the AnsibleRun, started
+// at start, in the audit log. The run already happened, so failing to record
+// it is logged rather than returned.
+func (c *external) auditRun(ctx context.Context, cr *v1alpha1.AnsibleRun, step string, start time.Time, err error) {
+	if c.audit == nil {
+		return
+	}
+	rec := audit.Record{
+		Time:        start,
+		Duration:    time.Since(start).Round(time.Millisecond).String(),
+		Resource:    cr.GetName(),
+		UID:         string(cr.GetUID()),
+		Step:        step,
+		ContentHash: c.runner.ContentHash(),
+		Result:      audit.ResultSuccessful,
+	}
+	if ref := cr.GetProviderConfigReference(); ref != nil {
+		rec.ProviderConfig = ref.Name
+	}
+	for k, v := range cr.GetAnnotations() {
+		// the last applied parameters are already summarized by the content hash
+		if k == v1.LastAppliedConfigAnnotation {
+			continue
+		}
+		if rec.Annotations == nil {
+			rec.Annotations = map[string]string{}
+		}
+		rec.Annotations[k] = v
+	}
+	if err != nil {
+		rec.Result = audit.ResultFailed
+		rec.Error = err.Error()
+	}
+	if err := c.audit.Log(ctx, rec); err != nil {
+		log.FromContext(ctx).Info("recording the run in the audit log", "err", err)
+	}
+}
+
+// runFailedCondition returns the Unavailable condition of an AnsibleRun whose
+// run failed with the supplied error, the kind of failure being its reason.
+func runFailedCondition(err error) xpv1.Condition {
+	cond := xpv1.Unavailable()
+	cond.Message = err.Error()
+	var runErr *ansible.RunError
+	if errors.As(err, &runErr) {
+		cond.Reason = xpv1.ConditionReason(runErr.Kind)
+	}
+	return cond
+}
+
+// phaseHandler returns an ansible.StatusHandler that reflects the phase of
+// the runs in the status of the supplied AnsibleRun. The status is persisted
+// as soon as a run is running, so that long runs don't appear hung; the other
+// phases are persisted along with the result of the run.
+func (c *external) phaseHandler(cr *v1alpha1.AnsibleRun) ansible.StatusHandler {
+	return func(ctx context.Context, status string) {
+		cr.Status.AtProvider.Phase = status
+		cr.Status.AtProvider.CurrentTask = ""
+		switch status {
+		case ansible.StatusStarting:
+			c.admin.started(cr.GetName(), c.cancel)
+		case ansible.StatusRunning:
+			c.admin.phase(cr.GetName(), status)
+		default:
+			c.admin.finished(cr.GetName())
+		}
+		if status != ansible.StatusRunning {
+			return
+		}
+		if err := c.updateStatus(ctx, cr); err != nil {
+			log.FromContext(ctx).V(1).Info("updating the phase of the run", "err", err)
+		}
+	}
+}
+
+// heartbeatHandler returns an ansible.ProgressHandler that persists the
+// current task, the progress and the heartbeat of the running runs in the
+// status of the supplied AnsibleRun, so that a slow task can be told from a
+// hung process.
+func (c *external) heartbeatHandler(cr *v1alpha1.AnsibleRun) ansible.ProgressHandler {
+	return func(ctx context.Context, p ansible.Progress) {
+		now := metav1.Now()
+		cr.Status.AtProvider.CurrentTask = p.CurrentTask
+		cr.Status.AtProvider.LastHeartbeat = &now
+		setProgress(cr, p.TasksStarted)
+		if err := c.updateStatus(ctx, cr); err != nil {
+			log.FromContext(ctx).V(1).Info("updating the heartbeat of the run", "err", err)
+		}
+	}
+}
+
+// setProgress records the supplied number of tasks started by the running
+// run in the progress of the supplied AnsibleRun, estimating its percentage
+// from the tasks expected by the last successful run.
+func setProgress(cr *v1alpha1.AnsibleRun, started int) {
+	p := cr.Status.AtProvider.Progress
+	if p == nil {
+		p = &v1alpha1.RunProgress{}
+		cr.Status.AtProvider.Progress = p
+	}
+	p.TasksStarted = started
+	p.Percent = nil
+	if p.ExpectedTasks > 0 {
+		// the run is not over until it succeeds, whatever it started
+		percent := min(started*100/p.ExpectedTasks, 99)
+		p.Percent = &percent
+	}
+}
+
+// completeProgress records the success of the supplied last run in the
+// progress of the supplied AnsibleRun, the tasks it started being expected
+// from the next runs.
+func completeProgress(cr *v1alpha1.AnsibleRun, lastRun *v1alpha1.RunSummary) {
+	if lastRun == nil {
+		return
+	}
+	percent := 100
+	cr.Status.AtProvider.Progress = &v1alpha1.RunProgress{
+		TasksStarted:  lastRun.Tasks,
+		ExpectedTasks: lastRun.Tasks,
+		Percent:       &percent,
+	}
+}
+
+// taskEventHandler returns an ansible.TaskEventHandler that records the task
+// events of the runs as Kubernetes Events of the supplied AnsibleRun, the
+// failed and retried tasks as warnings.
+func (c *external) taskEventHandler(cr *v1alpha1.AnsibleRun) ansible.TaskEventHandler {
+	return func(_ context.Context, e ansible.TaskEvent) {
+		if c.recorder == nil {
+			return
+		}
+		switch e.Kind {
+		case ansible.TaskFailed, ansible.TaskRetrying:
+			c.recorder.Event(cr, event.Warning(event.Reason(e.Kind), errors.New(e.Message)))
+		default:
+			c.recorder.Event(cr, event.Normal(event.Reason(e.Kind), e.Message))
+		}
+	}
+}
+
+// updateStatus persists the status of the supplied AnsibleRun. A conflict
+// only means that another writer changed the AnsibleRun since it was read, so
+// rather than failing the reconcile, or writing our stale copy over the
+// latest AnsibleRun, only the changes made to the status since it was last
+// persisted are applied onto the latest AnsibleRun, which keeps the changes
+// of the other writer.
+func (c *external) updateStatus(ctx context.Context, cr *v1alpha1.AnsibleRun) error {
+	truncateStatus(cr)
+	err := c.kube.Status().Update(ctx, cr)
+	if err == nil {
+		c.persisted = cr.DeepCopy()
+		return nil
+	}
+	if !kerrors.IsConflict(err) {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &v1alpha1.AnsibleRun{}
+		if err := c.kube.Get(ctx, types.NamespacedName{Namespace: cr.GetNamespace(), Name: cr.GetName()}, latest); err != nil {
+			return err
+		}
+		if err := mergeStatus(c.persisted, cr, latest); err != nil {
+			return err
+		}
+		if err := c.kube.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		cr.Status = *latest.Status.DeepCopy()
+		cr.SetResourceVersion(latest.GetResourceVersion())
+		c.persisted = cr.DeepCopy()
+		return nil
+	})
+}
+
+// mergeStatus applies the changes made to the status of the supplied
+// AnsibleRun since the supplied base onto the status of the supplied latest
+// AnsibleRun. The conditions are merged by type, and the other fields with a
+// JSON merge patch, so that the fields another writer changed are kept
+// unless the AnsibleRun changed them too. The whole status is applied when
+// there is no base.
+func mergeStatus(base, cr, latest *v1alpha1.AnsibleRun) error {
+	if base == nil {
+		latest.Status = *cr.Status.DeepCopy()
+		return nil
+	}
+	from, to := base.Status.DeepCopy(), cr.Status.DeepCopy()
+	from.Conditions, to.Conditions = nil, nil
+	fromJSON, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	toJSON, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+	latestJSON, err := json.Marshal(latest.Status)
+	if err != nil {
+		return err
+	}
+	patch, err := jsonpatch.CreateMergePatch(fromJSON, toJSON)
+	if err != nil {
+		return err
+	}
+	merged, err := jsonpatch.MergePatch(latestJSON, patch)
+	if err != nil {
+		return err
+	}
+	status := v1alpha1.AnsibleRunStatus{}
+	if err := json.Unmarshal(merged, &status); err != nil {
+		return err
+	}
+	status.Conditions = latest.Status.Conditions
+	latest.Status = status
+	for _, cond := range cr.Status.Conditions {
+		if !base.Status.GetCondition(cond.Type).Equal(cond) {
+			latest.SetConditions(cond)
+		}
+	}
+	return nil
+}
+
+// truncateStatus keeps the status of the supplied AnsibleRun within a size
+// budget, so that verbose runs cannot push the resource over the etcd limits.
+// The most recent warnings, the slowest tasks, the first hosts of the last
+// run and the first hosts and tasks of the drift are preserved.
+func truncateStatus(cr *v1alpha1.AnsibleRun) {
+	for i := range cr.Status.Conditions {
+		cr.Status.Conditions[i].Message = statusutil.Message(cr.Status.Conditions[i].Message, statusutil.MaxMessageBytes)
+	}
+	o := &cr.Status.AtProvider
+	o.CurrentTask = statusutil.Message(o.CurrentTask, statusutil.MaxMessageBytes)
+	o.Warnings = statusutil.Newest(o.Warnings, statusutil.MaxListBytes)
+	o.SlowestTasks = statusutil.Head(o.SlowestTasks, statusutil.MaxListItems)
+	for i := range o.SlowestTasks {
+		o.SlowestTasks[i].Task = statusutil.Message(o.SlowestTasks[i].Task, statusutil.MaxMessageBytes)
+	}
+	if o.LastRun != nil {
+		o.LastRun.Hosts = statusutil.Head(o.LastRun.Hosts, statusutil.MaxListItems)
+		o.LastRun.FailedPlaybook = statusutil.Message(o.LastRun.FailedPlaybook, statusutil.MaxMessageBytes)
+	}
+	if d := o.Drift; d != nil {
+		d.Hosts = statusutil.Head(d.Hosts, statusutil.MaxListItems)
+		d.Tasks = statusutil.Head(d.Tasks, statusutil.MaxListItems)
+		for i := range d.Tasks {
+			d.Tasks[i].Task = statusutil.Message(d.Tasks[i].Task, statusutil.MaxMessageBytes)
+			d.Tasks[i].Hosts = statusutil.Head(d.Tasks[i].Hosts, statusutil.MaxListItems)
+		}
+	}
+}
+
+// applyMetadata sets the supplied provider-owned labels and annotations on
+// the AnsibleRun using server-side apply. Only the metadata is sent, under a
+// dedicated field manager, so that concurrent changes made by other
+// controllers to the rest of the resource are left untouched. The
+// provider-owned metadata already set is sent again, since server-side apply
+// removes the fields its field manager stops sending.
+func (c *external) applyMetadata(ctx context.Context, cr *v1alpha1.AnsibleRun, labels, annotations map[string]string) error {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(v1alpha1.AnsibleRunGroupVersionKind)
+	u.SetNamespace(cr.GetNamespace())
+	u.SetName(cr.GetName())
+	u.SetLabels(ownedMetadata(cr.GetLabels(), labels, ansible.LabelKeyContentHash))
+	u.SetAnnotations(ownedMetadata(cr.GetAnnotations(), annotations, v1.LastAppliedConfigAnnotation))
+	if err := c.kube.Patch(ctx, u, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return err
+	}
+	meta.AddLabels(cr, labels)
+	meta.AddAnnotations(cr, annotations)
+	cr.SetResourceVersion(u.GetResourceVersion())
+	return nil
+}
+
+// ownedMetadata returns the supplied metadata along with the current values
+// of the provider-owned keys that it doesn't set.
+func ownedMetadata(current, set map[string]string, owned ...string) map[string]string {
+	m := make(map[string]string, len(set)+len(owned))
+	for _, k := range owned {
+		if v, ok := current[k]; ok {
+			m[k] = v
+		}
+	}
+	for k, v := range set {
+		m[k] = v
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// pollIntervalHook returns a managed.PollIntervalHook that requeues AnsibleRuns
+// whose last run or reconcile failed after the shorter failed interval, so
+// that recovery is detected sooner. Healthy AnsibleRuns keep the poll interval,
+// or their own one, and so do AnsibleRuns whose last run failed in a way that
+// running the contents again won't fix, like a syntax error. The interval is
+// shifted by up to jitter either way, so that the AnsibleRuns created together
+// don't keep being checked together. AnsibleRuns whose run is deferred by
+// their minimum interval between runs are requeued when it is due.
+func pollIntervalHook(failed, jitter time.Duration) managed.PollIntervalHook {
+	return func(mg resource.Managed, pollInterval time.Duration) time.Duration {
+		cr, ok := mg.(*v1alpha1.AnsibleRun)
+		if ok && cr.Spec.ForProvider.PollInterval != nil && cr.Spec.ForProvider.PollInterval.Duration > 0 {
+			pollInterval = cr.Spec.ForProvider.PollInterval.Duration
+		}
+		interval := withJitter(failedPollInterval(mg, pollInterval, failed), jitter)
+		// deferred runs are due at the end of the minimum interval between runs
+		if ok && cr.Status.AtProvider.NextRunTime != nil {
+			if until := time.Until(cr.Status.AtProvider.NextRunTime.Time); until > 0 && until < interval {
+				return until
+			}
+		}
+		return interval
+	}
+}
+
+// withJitter shifts the supplied interval by a random duration of up to
+// jitter either way, keeping it positive.
+func withJitter(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	shifted := interval + time.Duration((rand.Float64()-0.5)*2*float64(jitter)) //#nosec G404 -- no need for secure randomness
+	if shifted <= 0 {
+		return interval
+	}
+	return shifted
+}
+
+// failedPollInterval returns the failed interval when the last run or
+// reconcile of the supplied AnsibleRun failed in a way that may go away by
+// itself, and the poll interval otherwise.
+func failedPollInterval(mg resource.Managed, pollInterval, failed time.Duration) time.Duration {
+	if failed <= 0 || failed >= pollInterval {
+		return pollInterval
+	}
+	ready := mg.GetCondition(xpv1.TypeReady)
+	if ready.Status == v1.ConditionFalse && ready.Reason != xpv1.ReasonCreating && ready.Reason != xpv1.ReasonDeleting {
+		if ansible.FailureKind(ready.Reason).Retryable() {
+			return failed
+		}
+		return pollInterval
+	}
+	if mg.GetCondition(xpv1.TypeSynced).Reason == xpv1.ReasonReconcileError {
+		return failed
+	}
+	return pollInterval
+}
+
+// galaxyOptions returns the options of the ansible-galaxy installs set in the
+// supplied ProviderConfig.
+func galaxyOptions(pc *v1alpha1.ProviderConfig) ansible.GalaxyOptions {
+	if pc.Spec.Galaxy == nil {
+		return ansible.GalaxyOptions{}
+	}
+	return ansible.GalaxyOptions{
+		Force:       pc.Spec.Galaxy.Force,
+		IgnoreCerts: pc.Spec.Galaxy.IgnoreCerts,
+		NoDeps:      pc.Spec.Galaxy.NoDeps,
+		Server:      pc.Spec.Galaxy.Server,
+	}
+}
+
+// galaxyToken returns the token of the Galaxy server of the supplied
+// ProviderConfig, empty when it has none.
+func (c *connector) galaxyToken(ctx context.Context, pc *v1alpha1.ProviderConfig) (string, error) {
+	if pc.Spec.Galaxy == nil || pc.Spec.Galaxy.TokenSecretRef == nil {
+		return "", nil
+	}
+	data, err := resource.CommonCredentialExtractor(ctx, xpv1.CredentialsSourceSecret, c.kube, xpv1.CommonCredentialSelectors{SecretRef: pc.Spec.Galaxy.TokenSecretRef})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errGetGalaxyToken, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// behaviorVars returns the behavior vars of the supplied ProviderConfig, the
+// values of its vars being read from Secrets and ConfigMaps when they set
+// valueFrom.
+func (c *connector) behaviorVars(ctx context.Context, pc *v1alpha1.ProviderConfig) (map[string]string, error) {
 	behaviorVars := make(map[string]string, len(pc.Spec.Vars))
 	for _, v := range pc.Spec.Vars {
-		behaviorVars[v.Key] = v.Value
+		value, err := c.varValue(ctx, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", errGetVar, v.Key, err)
+		}
+		behaviorVars[v.Key] = value
+	}
+	if pc.Spec.StdoutCallback != "" {
+		behaviorVars[ansible.AnsibleStdoutCallback] = pc.Spec.StdoutCallback
+	}
+	return behaviorVars, nil
+}
+
+// varValue returns the value of the supplied var.
+func (c *connector) varValue(ctx context.Context, v v1alpha1.Var) (string, error) {
+	switch {
+	case v.ValueFrom == nil:
+		return v.Value, nil
+	case v.ValueFrom.SecretKeyRef != nil:
+		data, err := resource.CommonCredentialExtractor(ctx, xpv1.CredentialsSourceSecret, c.kube, xpv1.CommonCredentialSelectors{SecretRef: v.ValueFrom.SecretKeyRef})
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case v.ValueFrom.ConfigMapKeyRef != nil:
+		ref := v.ValueFrom.ConfigMapKeyRef
+		cm := &v1.ConfigMap{}
+		if err := c.kube.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, cm); err != nil {
+			return "", err
+		}
+		value, ok := cm.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("%s %s", errNoConfigMapKey, ref.Key)
+		}
+		return value, nil
+	}
+	return "", errors.New(errNoVarSource)
+}
+
+// warnUnknownVars records a warning event on the supplied AnsibleRun for each
+// supplied behavior var that is not known to configure Ansible.
+func (c *connector) warnUnknownVars(cr *v1alpha1.AnsibleRun, vars map[string]string) {
+	if c.recorder == nil {
+		return
+	}
+	for _, k := range ansible.UnknownVars(vars) {
+		c.recorder.Event(cr, event.Warning(reasonUnknownVar, fmt.Errorf("%s %s", errUnknownVar, k)))
 	}
-	return behaviorVars
 }
@@ -21,26 +21,45 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"errors"
 	"fmt"
-	"io"
+	"math"
 
+	"github.com/apenella/go-ansible/pkg/stdoutcallback/results"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/spf13/afero"
+	authv1 "k8s.io/api/authentication/v1"
 	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	kresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
 	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane-contrib/provider-ansible/internal/audit"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
 	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/statusutil"
 	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
 	"github.com/crossplane/crossplane-runtime/pkg/reconciler/managed"
 	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	rfake "github.com/crossplane/crossplane-runtime/pkg/resource/fake"
 	"github.com/crossplane/crossplane-runtime/pkg/test"
 )
 
@@ -79,7 +98,7 @@ func (e *ErrFs) Chmod(name string, mode os.FileMode) error {
 
 type MockPs struct {
 	MockInit          func(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*ansible.Runner, error)
-	MockGalaxyInstall func(ctx context.Context, behaviorVars map[string]string, requirementsType string) error
+	MockGalaxyInstall func(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error
 	MockAddFile       func(path string, content []byte) error
 }
 
@@ -87,8 +106,8 @@ func (ps MockPs) Init(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars
 	return ps.MockInit(ctx, cr, behaviorVars)
 }
 
-func (ps MockPs) GalaxyInstall(ctx context.Context, behaviorVars map[string]string, requirementsType string) error {
-	return ps.MockGalaxyInstall(ctx, behaviorVars, requirementsType)
+func (ps MockPs) GalaxyInstall(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
+	return ps.MockGalaxyInstall(ctx, behaviorVars, requirementsType, opts)
 }
 
 func (ps MockPs) AddFile(path string, content []byte) error {
@@ -96,37 +115,92 @@ func (ps MockPs) AddFile(path string, content []byte) error {
 }
 
 type MockRunner struct {
-	MockRun              func(ctx context.Context) (io.Reader, error)
-	MockWriteExtraVar    func(extraVar map[string]interface{}) error
-	MockAnsibleRunPolicy func() *ansible.RunPolicy
-	MockEnableCheckMode  func(checkMode bool)
-	MockFailureReason    func() (string, error)
+	MockAnsibleRunPolicy  func() *ansible.RunPolicy
+	MockCheck             func(ctx context.Context) (*results.AnsiblePlaybookJSONResults, error)
+	MockApply             func(ctx context.Context) error
+	MockCreate            func(ctx context.Context) error
+	MockDestroy           func(ctx context.Context) error
+	MockWarnings          func() []string
+	MockSlowestTasks      func() []v1alpha1.TaskTiming
+	MockContentHash       func() string
+	MockSetTrigger        func(trigger string)
+	MockConnectionDetails func() (managed.ConnectionDetails, error)
+	MockLastRun           func() *v1alpha1.RunSummary
+	MockHosts             func(ctx context.Context) ([]string, error)
 }
 
-func (r MockRunner) Run(ctx context.Context) (io.Reader, error) {
-	return r.MockRun(ctx)
-}
-
-func (r MockRunner) WriteExtraVar(extraVar map[string]interface{}) error {
-	return r.MockWriteExtraVar(extraVar)
+func (r MockRunner) Hosts(ctx context.Context) ([]string, error) {
+	if r.MockHosts == nil {
+		return nil, nil
+	}
+	return r.MockHosts(ctx)
 }
 
 func (r MockRunner) GetAnsibleRunPolicy() *ansible.RunPolicy {
 	return r.MockAnsibleRunPolicy()
 }
 
-func (r MockRunner) EnableCheckMode(checkMode bool) {
-	r.MockEnableCheckMode(checkMode)
+func (r MockRunner) Check(ctx context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+	return r.MockCheck(ctx)
+}
+
+func (r MockRunner) Apply(ctx context.Context) error {
+	return r.MockApply(ctx)
+}
+
+func (r MockRunner) Create(ctx context.Context) error {
+	return r.MockCreate(ctx)
+}
+
+func (r MockRunner) Destroy(ctx context.Context) error {
+	return r.MockDestroy(ctx)
+}
+
+func (r MockRunner) Warnings() []string {
+	if r.MockWarnings == nil {
+		return nil
+	}
+	return r.MockWarnings()
+}
+
+func (r MockRunner) SlowestTasks() []v1alpha1.TaskTiming {
+	if r.MockSlowestTasks == nil {
+		return nil
+	}
+	return r.MockSlowestTasks()
+}
+
+func (r MockRunner) LastRun() *v1alpha1.RunSummary {
+	if r.MockLastRun == nil {
+		return nil
+	}
+	return r.MockLastRun()
+}
+
+func (r MockRunner) SetTrigger(trigger string) {
+	if r.MockSetTrigger != nil {
+		r.MockSetTrigger(trigger)
+	}
+}
+
+func (r MockRunner) ConnectionDetails() (managed.ConnectionDetails, error) {
+	if r.MockConnectionDetails == nil {
+		return nil, nil
+	}
+	return r.MockConnectionDetails()
 }
 
-func (r MockRunner) FailureReason() (string, error) {
-	return r.MockFailureReason()
+func (r MockRunner) ContentHash() string {
+	if r.MockContentHash == nil {
+		return ""
+	}
+	return r.MockContentHash()
 }
 
 func TestConnect(t *testing.T) {
 	errBoom := errors.New("boom")
 	pbCreds := "credentials"
-	requirements := "fakeRequirements"
+	requirements := v1alpha1.Requirements{Collections: []v1alpha1.Collection{{Name: "community.general"}}}
 	inlineYaml := "IamYaml"
 	myRole := v1alpha1.Role{Name: "MyRole"}
 
@@ -171,7 +245,24 @@ func TestConnect(t *testing.T) {
 					ObjectMeta: metav1.ObjectMeta{UID: uid},
 				},
 			},
-			want: fmt.Errorf("%s: %s: %w", baseWorkingDir, errMkdir, errBoom),
+			want: &stageError{Stage: stagePrepareWorkdir, Err: fmt.Errorf("%s: %s: %w", baseWorkingDir, errMkdir, errBoom)},
+		},
+		"MakeProjectDirError": {
+			reason: "We should return any error encountered while laying out the private data dir",
+			fields: fields{
+				fs: afero.Afero{
+					Fs: &ErrFs{
+						Fs:        afero.NewMemMapFs(),
+						mkdirErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.ProjectDir): errBoom},
+					},
+				},
+			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{
+					ObjectMeta: metav1.ObjectMeta{UID: uid},
+				},
+			},
+			want: &stageError{Stage: stagePrepareWorkdir, Err: fmt.Errorf("%s: %s: %w", filepath.Join(baseWorkingDir, string(uid), runnerutil.ProjectDir), errMkdir, errBoom)},
 		},
 		"TrackUsageError": {
 			reason: "We should return any error encountered while tracking ProviderConfig usage",
@@ -237,7 +328,7 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s: %w", errGetCreds, errors.New("cannot extract from environment variable when none specified")),
+			want: &stageError{Stage: stageWriteCredentials, Err: fmt.Errorf("%s: %w", errGetCreds, errors.New("cannot extract from environment variable when none specified"))},
 		},
 		"WriteProviderConfigCredentialsError": {
 			reason: "We should return any error encountered while writing our ProviderConfig credentials to a file",
@@ -257,7 +348,7 @@ func TestConnect(t *testing.T) {
 				fs: afero.Afero{
 					Fs: &ErrFs{
 						Fs:        afero.NewMemMapFs(),
-						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), pbCreds): errBoom},
+						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.ProjectDir, pbCreds): errBoom},
 					},
 				},
 			},
@@ -271,7 +362,7 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s: %w", errWriteCreds, errBoom),
+			want: &stageError{Stage: stageWriteCredentials, Err: fmt.Errorf("%s: %w", errWriteCreds, errBoom)},
 		},
 		"WriteProviderGitCredentialsError": {
 			reason: "We should return any error encountered while writing our git credentials to a file",
@@ -294,6 +385,7 @@ func TestConnect(t *testing.T) {
 						writeErrs: map[string]error{filepath.Join("/tmp", baseWorkingDir, string(uid), ".git-credentials"): errBoom},
 					},
 				},
+				ansible: func(_ string) params { return MockPs{} },
 			},
 			args: args{
 				mg: &v1alpha1.AnsibleRun{
@@ -310,7 +402,7 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s: %w", errWriteGitCreds, errBoom),
+			want: &stageError{Stage: stageInstallDeps, Err: fmt.Errorf("%s: %w", errWriteGitCreds, errBoom)},
 		},
 		"WritePlaybookError": {
 			reason: "We should return any error encountered while writing our playbook.yml file",
@@ -322,9 +414,10 @@ func TestConnect(t *testing.T) {
 				fs: afero.Afero{
 					Fs: &ErrFs{
 						Fs:        afero.NewMemMapFs(),
-						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.PlaybookYml): errBoom},
+						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.ProjectDir, runnerutil.PlaybookYml): errBoom},
 					},
 				},
+				ansible: func(_ string) params { return MockPs{} },
 			},
 			args: args{
 				mg: &v1alpha1.AnsibleRun{
@@ -339,7 +432,7 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s: %w", errWriteAnsibleRun, errBoom),
+			want: &stageError{Stage: stageFetchContent, Err: fmt.Errorf("%s: %w", errWriteAnsibleRun, errBoom)},
 		},
 		"WriteInventoryError": {
 			reason: "We should return any error encountered while writing our Inventory file",
@@ -351,7 +444,7 @@ func TestConnect(t *testing.T) {
 				fs: afero.Afero{
 					Fs: &ErrFs{
 						Fs:        afero.NewMemMapFs(),
-						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.Hosts): errBoom},
+						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.InventoryDir, runnerutil.Hosts): errBoom},
 					},
 				},
 			},
@@ -368,7 +461,7 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s %s: %w", errWriteInventory, runnerutil.Hosts, errBoom),
+			want: &stageError{Stage: stageWriteInventory, Err: fmt.Errorf("%s %s: %w", errWriteInventory, runnerutil.Hosts, errBoom)},
 		},
 		"ChmodInventoryError": {
 			reason: "We should return any error encountered while changing permissions on our Inventory file",
@@ -380,7 +473,7 @@ func TestConnect(t *testing.T) {
 				fs: afero.Afero{
 					Fs: &ErrFs{
 						Fs:        afero.NewMemMapFs(),
-						chmodErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.Hosts): errBoom},
+						chmodErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.InventoryDir, runnerutil.Hosts): errBoom},
 					},
 				},
 			},
@@ -397,7 +490,66 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s %s: %w", errChmodInventory, runnerutil.Hosts, errBoom),
+			want: &stageError{Stage: stageWriteInventory, Err: fmt.Errorf("%s %s: %w", errChmodInventory, runnerutil.Hosts, errBoom)},
+		},
+		"GetPasswordError": {
+			reason: "We should return any error encountered while getting the responses to prompts",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet: test.NewMockGetFn(nil),
+				},
+				usage: resource.TrackerFn(func(_ context.Context, _ resource.Managed) error { return nil }),
+				fs:    afero.Afero{Fs: afero.NewMemMapFs()},
+			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{
+					ObjectMeta: metav1.ObjectMeta{UID: uid},
+					Spec: v1alpha1.AnsibleRunSpec{
+						ResourceSpec: xpv1.ResourceSpec{
+							ProviderConfigReference: &xpv1.Reference{},
+						},
+						ForProvider: v1alpha1.AnsibleRunParameters{
+							Passwords: []v1alpha1.Password{{
+								Prompt: "^BECOME password.*:\\s*?$",
+								Source: xpv1.CredentialsSourceEnvironment,
+							}},
+						},
+					},
+				},
+			},
+			want: &stageError{Stage: stageWriteCredentials, Err: fmt.Errorf("%s: %w", errGetPassword, errors.New("cannot extract from environment variable when none specified"))},
+		},
+		"WritePasswordsError": {
+			reason: "We should return any error encountered while writing the responses to prompts",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet: test.NewMockGetFn(nil),
+				},
+				usage: resource.TrackerFn(func(_ context.Context, _ resource.Managed) error { return nil }),
+				fs: afero.Afero{
+					Fs: &ErrFs{
+						Fs:        afero.NewMemMapFs(),
+						writeErrs: map[string]error{filepath.Join(baseWorkingDir, string(uid), runnerutil.EnvDir, runnerutil.Passwords): errBoom},
+					},
+				},
+			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{
+					ObjectMeta: metav1.ObjectMeta{UID: uid},
+					Spec: v1alpha1.AnsibleRunSpec{
+						ResourceSpec: xpv1.ResourceSpec{
+							ProviderConfigReference: &xpv1.Reference{},
+						},
+						ForProvider: v1alpha1.AnsibleRunParameters{
+							Passwords: []v1alpha1.Password{{
+								Prompt: "^BECOME password.*:\\s*?$",
+								Source: xpv1.CredentialsSourceNone,
+							}},
+						},
+					},
+				},
+			},
+			want: &stageError{Stage: stageWriteCredentials, Err: fmt.Errorf("%s %s: %w", errWritePasswords, runnerutil.Passwords, errBoom)},
 		},
 		"AnsibleInitError": {
 			reason: "We should return any error encountered while initializing ansible-runner cli",
@@ -412,7 +564,7 @@ func TestConnect(t *testing.T) {
 						MockInit: func(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*ansible.Runner, error) {
 							return nil, errBoom
 						},
-						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string) error {
+						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
 							return nil
 						},
 						MockAddFile: func(path string, content []byte) error {
@@ -431,7 +583,7 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: fmt.Errorf("%s: %w", errInit, errBoom),
+			want: &stageError{Stage: stageInitRunner, Err: fmt.Errorf("%s: %w", errInit, errBoom)},
 		},
 		"AnsibleGalaxyError": {
 			reason: "We should return any error encountered while installing ansible requirements",
@@ -449,9 +601,9 @@ func TestConnect(t *testing.T) {
 				ansible: func(_ string) params {
 					return MockPs{
 						MockInit: func(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*ansible.Runner, error) {
-							return nil, nil
+							return &ansible.Runner{}, nil
 						},
-						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string) error {
+						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
 							return errBoom
 						},
 						MockAddFile: func(path string, content []byte) error {
@@ -470,7 +622,47 @@ func TestConnect(t *testing.T) {
 					},
 				},
 			},
-			want: errBoom,
+			want: &stageError{Stage: stageInstallDeps, Err: errBoom},
+		},
+		"GalaxyOptions": {
+			reason: "We should install the requirements with the ansible-galaxy options of the ProviderConfig",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+						if pc, ok := obj.(*v1alpha1.ProviderConfig); ok {
+							pc.Spec.Requirements = &requirements
+							pc.Spec.Galaxy = &v1alpha1.GalaxyOptions{IgnoreCerts: true, NoDeps: true}
+						}
+						return nil
+					}),
+				},
+				usage: resource.TrackerFn(func(_ context.Context, _ resource.Managed) error { return nil }),
+				fs:    afero.Afero{Fs: afero.NewMemMapFs()},
+				ansible: func(_ string) params {
+					return MockPs{
+						MockInit: func(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*ansible.Runner, error) {
+							return &ansible.Runner{}, nil
+						},
+						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
+							if diff := cmp.Diff(ansible.GalaxyOptions{IgnoreCerts: true, NoDeps: true}, opts); diff != "" {
+								return fmt.Errorf("unexpected ansible-galaxy options (-want +got):\n%s", diff)
+							}
+							return nil
+						},
+					}
+				},
+			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{
+					ObjectMeta: metav1.ObjectMeta{UID: uid},
+					Spec: v1alpha1.AnsibleRunSpec{
+						ResourceSpec: xpv1.ResourceSpec{
+							ProviderConfigReference: &xpv1.Reference{},
+						},
+					},
+				},
+			},
+			want: nil,
 		},
 		"Success": {
 			reason: "We should not return an error when we successfully 'connect' to Ansible",
@@ -483,9 +675,9 @@ func TestConnect(t *testing.T) {
 				ansible: func(_ string) params {
 					return MockPs{
 						MockInit: func(ctx context.Context, cr *v1alpha1.AnsibleRun, behaviorVars map[string]string) (*ansible.Runner, error) {
-							return nil, nil
+							return &ansible.Runner{}, nil
 						},
-						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string) error {
+						MockGalaxyInstall: func(ctx context.Context, behaviorVars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
 							return nil
 						},
 						MockAddFile: func(path string, content []byte) error {
@@ -526,10 +718,24 @@ func TestConnect(t *testing.T) {
 
 func TestObserve(t *testing.T) {
 	errBoom := errors.New("boom")
+	policyViolation := &ansible.RunError{Kind: ansible.FailurePolicyViolation, Err: errBoom}
+	policyViolationCond := xpv1.Unavailable()
+	policyViolationCond.Reason = xpv1.ConditionReason(ansible.FailurePolicyViolation)
+	policyViolationCond.Message = errBoom.Error()
+	inventoryInvalid := &ansible.RunError{Kind: ansible.FailureInventoryInvalid, Err: errBoom}
+	inventoryInvalidCond := xpv1.Unavailable()
+	inventoryInvalidCond.Reason = xpv1.ConditionReason(ansible.FailureInventoryInvalid)
+	inventoryInvalidCond.Message = errBoom.Error()
+	availableCond := xpv1.Available()
+	unavailableCond := xpv1.Unavailable()
+	unavailableCond.Message = errBoom.Error()
 
 	type fields struct {
-		kube   client.Client
-		runner ansibleRunner
+		kube          client.Client
+		runner        ansibleRunner
+		rolesMoved    bool
+		varsFromHash  string
+		extraVarsHash string
 	}
 
 	type args struct {
@@ -538,9 +744,12 @@ func TestObserve(t *testing.T) {
 	}
 
 	type want struct {
-		o          managed.ExternalObservation
-		err        error
-		conditions []xpv1.Condition
+		o           managed.ExternalObservation
+		err         error
+		conditions  []xpv1.Condition
+		ready       *xpv1.Condition
+		drifted     bool
+		initialized *v1alpha1.AnsibleRun
 	}
 
 	testPlaybook := "fake playbook"
@@ -548,11 +757,13 @@ func TestObserve(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Annotations: map[string]string{
 				v1.LastAppliedConfigAnnotation: fmt.Sprintf(`{"playbookInline":"%s"}`, testPlaybook),
+				ansible.AnnotationKeyPolicyRun: "ObserveAndDelete",
 			},
 		},
 		Spec: v1alpha1.AnsibleRunSpec{
 			ForProvider: v1alpha1.AnsibleRunParameters{
 				PlaybookInline: &testPlaybook,
+				StateVar:       ansible.DefaultStateVar,
 			},
 		},
 	}
@@ -560,9 +771,33 @@ func TestObserve(t *testing.T) {
 	testRunWithReconcileSuccess := testRun.DeepCopy()
 	testRunWithReconcileSuccess.SetConditions(xpv1.ReconcileSuccess())
 
+	testRunWithExtraVars := testRunWithReconcileSuccess.DeepCopy()
+	testRunWithExtraVars.Status.AtProvider.ExtraVarsHash = "sha256:vars"
+
 	testRunWithReconcileError := testRun.DeepCopy()
 	testRunWithReconcileError.SetConditions(xpv1.ReconcileError(errors.New("fake error")))
 
+	testRunWithFailedRun := testRun.DeepCopy()
+	testRunWithFailedRun.SetConditions(xpv1.ReconcileSuccess(), unavailableCond)
+	testRunWithFailedRun.Status.AtProvider.FailedRuns = &v1alpha1.FailedRuns{Count: 1, LastFailureTime: metav1.Now()}
+
+	testRunAdoptExisting := testRun.DeepCopy()
+	delete(testRunAdoptExisting.Annotations, v1.LastAppliedConfigAnnotation)
+
+	testRunDeferred := testRunWithReconcileError.DeepCopy()
+	testRunDeferred.Spec.ForProvider.MinIntervalBetweenRuns = &metav1.Duration{Duration: time.Hour}
+	lastApply := metav1.Now()
+	testRunDeferred.Status.AtProvider.LastApplyTime = &lastApply
+
+	// initialized returns an AnsibleRun whose fields are all initialized
+	initialized := func(policy string) *v1alpha1.AnsibleRun {
+		return &v1alpha1.AnsibleRun{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ansible.AnnotationKeyPolicyRun: policy}},
+			Spec:       v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{StateVar: ansible.DefaultStateVar}},
+		}
+	}
+	testRunAdoptExisting.Spec.ForProvider.AdoptExisting = true
+
 	cases := map[string]struct {
 		reason string
 		fields fields
@@ -581,7 +816,7 @@ func TestObserve(t *testing.T) {
 		"PolicyNotSupported": {
 			reason: "We should do no action if the supplied AnsibleRunPolicy is not supported",
 			args: args{
-				mg: &v1alpha1.AnsibleRun{},
+				mg: initialized("LOL"),
 			},
 			fields: fields{
 				runner: &ansible.Runner{
@@ -592,6 +827,27 @@ func TestObserve(t *testing.T) {
 			},
 			want: want{},
 		},
+		"LateInitialize": {
+			reason: "We should write the defaults of the unset fields back into the AnsibleRun",
+			args: args{
+				mg: &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				runner: &ansible.Runner{
+					AnsibleRunPolicy: &ansible.RunPolicy{
+						Name: "LOL",
+					},
+				},
+			},
+			want: want{
+				o: managed.ExternalObservation{ResourceLateInitialized: true},
+				initialized: func() *v1alpha1.AnsibleRun {
+					cr := initialized("ObserveAndDelete")
+					cr.SetDeletionPolicy(xpv1.DeletionOrphan)
+					return cr
+				}(),
+			},
+		},
 		"GetObservedErrorWhenObserveAndDeletePolicy": {
 			reason: "We should return any error we encounter getting observed resource",
 			fields: fields{
@@ -617,7 +873,7 @@ func TestObserve(t *testing.T) {
 			fields: fields{
 				kube: &test.MockClient{
 					MockGet:          test.NewMockGetFn(nil),
-					MockUpdate:       test.NewMockUpdateFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
 					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
 				},
 				runner: &MockRunner{
@@ -626,11 +882,8 @@ func TestObserve(t *testing.T) {
 							Name: "ObserveAndDelete",
 						}
 					},
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
-						return nil
-					},
-					MockRun: func(ctx context.Context) (io.Reader, error) {
-						return nil, fmt.Errorf("run should not have been called")
+					MockApply: func(ctx context.Context) error {
+						return fmt.Errorf("apply should not have been called")
 					},
 				},
 			},
@@ -641,12 +894,12 @@ func TestObserve(t *testing.T) {
 				o: managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
 			},
 		},
-		"RetryFailedWithObserveAndDeletePolicy": {
-			reason: "We should run ansible when spec has not changed but last sync was unsuccessful",
+		"RolesMovedWithObserveAndDeletePolicy": {
+			reason: "We should run ansible when spec has not changed but the floating version of a role moved",
 			fields: fields{
 				kube: &test.MockClient{
 					MockGet:          test.NewMockGetFn(nil),
-					MockUpdate:       test.NewMockUpdateFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
 					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
 				},
 				runner: &MockRunner{
@@ -655,109 +908,54 @@ func TestObserve(t *testing.T) {
 							Name: "ObserveAndDelete",
 						}
 					},
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
+					MockApply: func(ctx context.Context) error {
 						return nil
 					},
-					MockRun: func(ctx context.Context) (io.Reader, error) {
-						cmd := exec.Command("ls")
-						cmd.Start()
-						return nil, cmd.Wait()
-					},
 				},
+				rolesMoved: true,
 			},
 			args: args{
-				mg: testRunWithReconcileError,
+				mg: testRunWithReconcileSuccess.DeepCopy(),
 			},
 			want: want{
-				o: managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+				ready: &availableCond,
 			},
 		},
-		"GetObservedErrorWhenCheckWhenObservePolicy": {
-			reason: "We should return any error we encounter getting observed resource",
+		"VarsFromChangedWithObserveAndDeletePolicy": {
+			reason: "We should run ansible when spec has not changed but the vars read from Secrets and ConfigMaps changed",
 			fields: fields{
+				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
 				runner: &MockRunner{
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
-							Name: "CheckWhenObserve",
+							Name: "ObserveAndDelete",
 						}
 					},
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
+					MockApply: func(ctx context.Context) error {
 						return nil
 					},
-					MockRun: func(context.Context) (io.Reader, error) {
-						return nil, errBoom
-					},
-					MockEnableCheckMode: func(checkMode bool) {
-
-					},
 				},
+				varsFromHash: "sha256:rotated",
 			},
 			args: args{
-				mg: &v1alpha1.AnsibleRun{},
-			},
-			want: want{
-				err: errBoom,
-			},
-		},
-	}
-
-	for name, tc := range cases {
-		t.Run(name, func(t *testing.T) {
-			e := external{runner: tc.fields.runner, kube: tc.fields.kube}
-			got, err := e.Observe(tc.args.ctx, tc.args.mg)
-			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
-				t.Errorf("\n%s\ne.Observe(...): -want error, +got error:\n%s\n", tc.reason, diff)
-			}
-			if diff := cmp.Diff(tc.want.o, got); diff != "" {
-				t.Errorf("\n%s\ne.Observe(...): -want, +got:\n%s\n", tc.reason, diff)
-			}
-		})
-	}
-}
-
-func TestCreateOrUpdate(t *testing.T) {
-	errBoom := errors.New("boom")
-	unavaliableCond := xpv1.Unavailable()
-	unavaliableCond.Message = errBoom.Error()
-
-	type fields struct {
-		kube   client.Client
-		runner ansibleRunner
-	}
-
-	type args struct {
-		ctx context.Context
-		mg  resource.Managed
-	}
-
-	type want struct {
-		o          managed.ExternalCreation
-		err        error
-		conditions []xpv1.Condition
-	}
-
-	cases := map[string]struct {
-		reason string
-		fields fields
-		args   args
-		want   want
-	}{
-		"NotAnAnsibleRunError": {
-			reason: "We should return an error if the supplied managed resource is not an AnsibleRun",
-			args: args{
-				mg: nil,
+				mg: testRunWithReconcileSuccess.DeepCopy(),
 			},
 			want: want{
-				err: errors.New(errNotAnsibleRun),
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+				ready: &availableCond,
 			},
 		},
-		"RunErrorWithObserveAndDeletePolicy": {
-			reason: "We should return any error we encounter when running the runner",
-			args: args{
-				mg: &v1alpha1.AnsibleRun{},
-			},
+		"ExtraVarsUnchangedWithObserveAndDeletePolicy": {
+			reason: "We should not run ansible when neither the spec nor the extra vars of the ProviderConfig changed",
 			fields: fields{
 				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
 					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
 				},
 				runner: &MockRunner{
@@ -766,25 +964,25 @@ func TestCreateOrUpdate(t *testing.T) {
 							Name: "ObserveAndDelete",
 						}
 					},
-					MockEnableCheckMode: func(checkMode bool) {},
-					MockRun: func(context.Context) (io.Reader, error) {
-						return nil, errBoom
+					MockApply: func(ctx context.Context) error {
+						return fmt.Errorf("apply should not have been called")
 					},
 				},
+				extraVarsHash: "sha256:vars",
+			},
+			args: args{
+				mg: testRunWithExtraVars.DeepCopy(),
 			},
 			want: want{
-				err:        fmt.Errorf("running ansible: %w", errBoom),
-				conditions: []xpv1.Condition{unavaliableCond},
+				o: managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
 			},
 		},
-		"SuccessObserveAndDelete": {
-			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
-			args: args{
-				ctx: context.Background(),
-				mg:  &v1alpha1.AnsibleRun{},
-			},
+		"ExtraVarsChangedWithObserveAndDeletePolicy": {
+			reason: "We should run ansible when spec has not changed but the extra vars of the ProviderConfig changed",
 			fields: fields{
 				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
 					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
 				},
 				runner: &MockRunner{
@@ -793,103 +991,326 @@ func TestCreateOrUpdate(t *testing.T) {
 							Name: "ObserveAndDelete",
 						}
 					},
-					MockEnableCheckMode: func(checkMode bool) {},
-					MockRun: func(ctx context.Context) (io.Reader, error) {
-						cmd := exec.CommandContext(ctx, "ls")
-						cmd.Start()
-						return nil, cmd.Wait()
+					MockApply: func(ctx context.Context) error {
+						return errBoom
 					},
 				},
+				extraVarsHash: "sha256:changed",
+			},
+			args: args{
+				mg: testRunWithExtraVars.DeepCopy(),
 			},
 			want: want{
-				conditions: []xpv1.Condition{xpv1.Available()},
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+				ready: &unavailableCond,
 			},
 		},
-		"RunErrorWithCheckWhenObservePolicy": {
-			reason: "We should return any error we encounter when running the runner",
-			args: args{
-				ctx: context.Background(),
-				mg:  &v1alpha1.AnsibleRun{},
-			},
+		"RetryFailedWithObserveAndDeletePolicy": {
+			reason: "We should run ansible when spec has not changed but last sync was unsuccessful",
 			fields: fields{
 				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
 					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
 				},
 				runner: &MockRunner{
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
-							Name: "CheckWhenObserve",
+							Name: "ObserveAndDelete",
 						}
 					},
-					MockEnableCheckMode: func(checkMode bool) {},
-					MockRun: func(context.Context) (io.Reader, error) {
-						return nil, errBoom
+					MockApply: func(context.Context) error {
+						cmd := exec.Command("ls")
+						cmd.Start()
+						return cmd.Wait()
 					},
 				},
 			},
+			args: args{
+				mg: testRunWithReconcileError,
+			},
 			want: want{
-				err:        fmt.Errorf("running ansible: %w", errBoom),
-				conditions: []xpv1.Condition{unavaliableCond},
+				o: managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
 			},
 		},
-		"SuccessCheckWhenObserve": {
-			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
+		"RetryFailedRunWithObserveAndDeletePolicy": {
+			reason: "We should run ansible again when spec has not changed but the last run failed",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(context.Context) error {
+						return errBoom
+					},
+				},
+			},
 			args: args{
-				ctx: context.Background(),
-				mg:  &v1alpha1.AnsibleRun{},
+				mg: testRunWithFailedRun.DeepCopy(),
+			},
+			want: want{
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+				ready: &unavailableCond,
+			},
+		},
+		"DeferredRunWithObserveAndDeletePolicy": {
+			reason: "We should neither run ansible nor update the last applied annotation within the minimum interval between runs",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(errBoom),
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(context.Context) error {
+						return errBoom
+					},
+				},
+			},
+			args: args{
+				mg: testRunDeferred,
+			},
+			want: want{
+				o: managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+			},
+		},
+		"ApplyLastAppliedErrorWithObserveAndDeletePolicy": {
+			reason: "We should server-side apply the last applied annotation and return any error we encounter doing so",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet: test.NewMockGetFn(nil),
+					MockPatch: func(_ context.Context, _ client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						if patch != client.Apply {
+							return fmt.Errorf("unexpected patch type %q", patch.Type())
+						}
+						po := &client.PatchOptions{}
+						po.ApplyOptions(opts)
+						if po.FieldManager != fieldManager {
+							return fmt.Errorf("unexpected field manager %q", po.FieldManager)
+						}
+						return errBoom
+					},
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+				},
+			},
+			args: args{
+				mg: testRunWithReconcileError,
+			},
+			want: want{
+				err: errBoom,
 			},
+		},
+		"AdoptExistingWithObserveAndDeletePolicy": {
+			reason: "We should not run ansible on the first run when adopting an external state that needs no changes",
+			fields: fields{
+				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+						return &results.AnsiblePlaybookJSONResults{
+							Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{"localhost": {Ok: 1}},
+						}, nil
+					},
+					MockApply: func(context.Context) error {
+						return fmt.Errorf("apply should not have been called")
+					},
+				},
+			},
+			args: args{
+				mg: testRunAdoptExisting.DeepCopy(),
+			},
+			want: want{
+				o: managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+			},
+		},
+		"AdoptExistingWithChangesWithObserveAndDeletePolicy": {
+			reason: "We should run ansible on the first run when the adopted external state needs changes",
 			fields: fields{
 				kube: &test.MockClient{
+					MockGet:          test.NewMockGetFn(nil),
+					MockPatch:        test.NewMockPatchFn(nil),
 					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
 				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+						return &results.AnsiblePlaybookJSONResults{
+							Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{"localhost": {Changed: 1}},
+						}, nil
+					},
+					MockApply: func(context.Context) error {
+						return errBoom
+					},
+				},
+			},
+			args: args{
+				mg: testRunAdoptExisting.DeepCopy(),
+			},
+			want: want{
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true},
+				ready: &unavailableCond,
+			},
+		},
+		"GetObservedErrorWhenCheckWhenObservePolicy": {
+			reason: "We should return any error we encounter getting observed resource",
+			fields: fields{
 				runner: &MockRunner{
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
 							Name: "CheckWhenObserve",
 						}
 					},
-					MockEnableCheckMode: func(checkMode bool) {},
-					MockRun: func(ctx context.Context) (io.Reader, error) {
-						cmd := exec.CommandContext(ctx, "ls")
-						cmd.Start()
-						return nil, cmd.Wait()
+					MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+						return nil, errBoom
 					},
 				},
 			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{},
+			},
 			want: want{
-				conditions: []xpv1.Condition{xpv1.Available()},
+				err: errBoom,
+			},
+		},
+		"PolicyViolationWhenCheckWhenObservePolicy": {
+			reason: "We should report contents violating the policy in the Ready condition",
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+						return nil, policyViolation
+					},
+				},
+			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{},
+			},
+			want: want{
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true, ResourceLateInitialized: true},
+				ready: &policyViolationCond,
+			},
+		},
+		"InventoryInvalidWhenCheckWhenObservePolicy": {
+			reason: "We should report an invalid inventory in the Ready condition",
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+						return nil, inventoryInvalid
+					},
+				},
+			},
+			args: args{
+				mg: &v1alpha1.AnsibleRun{},
+			},
+			want: want{
+				o:     managed.ExternalObservation{ResourceExists: true, ResourceUpToDate: true, ResourceLateInitialized: true},
+				ready: &inventoryInvalidCond,
+			},
+		},
+		"DriftWhenCheckWhenObservePolicy": {
+			reason: "We should report the changes found by the check mode run as a drift",
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+						return &results.AnsiblePlaybookJSONResults{
+							Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{"localhost": {Changed: 1}},
+						}, nil
+					},
+				},
+			},
+			args: args{
+				mg: initialized("CheckWhenObserve"),
+			},
+			want: want{
+				o:       managed.ExternalObservation{ResourceExists: true},
+				drifted: true,
 			},
 		},
 	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			e := external{runner: tc.fields.runner, kube: tc.fields.kube}
-			got, err := e.Create(tc.args.ctx, tc.args.mg)
+			e := external{runner: tc.fields.runner, kube: tc.fields.kube, rolesMoved: tc.fields.rolesMoved, varsFromHash: tc.fields.varsFromHash, extraVarsHash: tc.fields.extraVarsHash}
+			got, err := e.Observe(tc.args.ctx, tc.args.mg)
 			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
 				t.Errorf("\n%s\ne.Observe(...): -want error, +got error:\n%s\n", tc.reason, diff)
 			}
 			if diff := cmp.Diff(tc.want.o, got); diff != "" {
 				t.Errorf("\n%s\ne.Observe(...): -want, +got:\n%s\n", tc.reason, diff)
 			}
-
-			if tc.args.mg == nil {
+			if cr, ok := tc.args.mg.(*v1alpha1.AnsibleRun); ok && cr.Status.AtProvider.Drifted != tc.want.drifted {
+				t.Errorf("\n%s\nansiblerun drifted: want %t, got %t", tc.reason, tc.want.drifted, cr.Status.AtProvider.Drifted)
+			}
+			if tc.want.initialized != nil {
+				if diff := cmp.Diff(tc.want.initialized, tc.args.mg); diff != "" {
+					t.Errorf("\n%s\nansiblerun late initialized: (-want +got):\n%s", tc.reason, diff)
+				}
+			}
+			if tc.want.ready == nil {
 				return
 			}
-
 			if diff := cmp.Diff(
-				tc.want.conditions,
-				tc.args.mg.(*v1alpha1.AnsibleRun).Status.Conditions,
+				*tc.want.ready,
+				tc.args.mg.(*v1alpha1.AnsibleRun).GetCondition(xpv1.TypeReady),
 				cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime"),
 			); diff != "" {
-				t.Errorf("ansiblerun conditions: (-want +got):\n%s", diff)
+				t.Errorf("\n%s\nansiblerun ready condition: (-want +got):\n%s", tc.reason, diff)
 			}
 		})
 	}
 }
 
-func TestDelete(t *testing.T) {
+func TestCreateOrUpdate(t *testing.T) {
 	errBoom := errors.New("boom")
+	unavaliableCond := xpv1.Unavailable()
+	unavaliableCond.Message = errBoom.Error()
+	syntaxErrorCond := xpv1.Unavailable()
+	syntaxErrorCond.Reason = xpv1.ConditionReason(ansible.FailureSyntax)
+	syntaxErrorCond.Message = errBoom.Error()
 
 	type fields struct {
 		kube   client.Client
@@ -901,60 +1322,80 @@ func TestDelete(t *testing.T) {
 		mg  resource.Managed
 	}
 
+	type want struct {
+		o            managed.ExternalCreation
+		err          error
+		conditions   []xpv1.Condition
+		warnings     []string
+		slowestTasks []v1alpha1.TaskTiming
+		contentHash  string
+		labels       map[string]string
+		created      bool
+		lastRun      *v1alpha1.RunSummary
+	}
+
 	cases := map[string]struct {
 		reason string
 		fields fields
 		args   args
-		want   error
+		want   want
 	}{
 		"NotAnAnsibleRunError": {
 			reason: "We should return an error if the supplied managed resource is not an AnsibleRun",
 			args: args{
 				mg: nil,
 			},
-			want: errors.New(errNotAnsibleRun),
+			want: want{
+				err: errors.New(errNotAnsibleRun),
+			},
 		},
-		"writeExtraVarErrorWithObserveAndDeletePolicy": {
-			reason: "We should return any error we encounter writing env variable env/extravars",
+		"RunErrorWithObserveAndDeletePolicy": {
+			reason: "We should report a failed run in the Ready condition rather than return it",
 			args: args{
 				mg: &v1alpha1.AnsibleRun{},
 			},
 			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
 				runner: &MockRunner{
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
-						return errBoom
-					},
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
 							Name: "ObserveAndDelete",
 						}
 					},
+					MockApply: func(context.Context) error {
+						return errBoom
+					},
 				},
 			},
-			want: errBoom,
+			want: want{
+				conditions: []xpv1.Condition{unavaliableCond},
+			},
 		},
-		"RunErrorWithObserveAndDeletePolicy": {
-			reason: "We should return any error we encounter when running the runner",
+		"ClassifiedRunError": {
+			reason: "We should use the kind of failure of the run as the reason of the Ready condition",
 			args: args{
-				ctx: context.Background(),
-				mg:  &v1alpha1.AnsibleRun{},
+				mg: &v1alpha1.AnsibleRun{},
 			},
 			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
 				runner: &MockRunner{
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
-						return nil
-					},
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
 							Name: "ObserveAndDelete",
 						}
 					},
-					MockRun: func(context.Context) (io.Reader, error) {
-						return nil, errBoom
+					MockApply: func(context.Context) error {
+						return &ansible.RunError{Kind: ansible.FailureSyntax, Err: errBoom}
 					},
 				},
 			},
-			want: errBoom,
+			want: want{
+				conditions: []xpv1.Condition{syntaxErrorCond},
+			},
 		},
 		"SuccessObserveAndDelete": {
 			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
@@ -963,81 +1404,2328 @@ func TestDelete(t *testing.T) {
 				mg:  &v1alpha1.AnsibleRun{},
 			},
 			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
 				runner: &MockRunner{
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
-						return nil
-					},
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
 							Name: "ObserveAndDelete",
 						}
 					},
-					MockRun: func(ctx context.Context) (io.Reader, error) {
+					MockApply: func(ctx context.Context) error {
 						cmd := exec.CommandContext(ctx, "ls")
 						cmd.Start()
-						return nil, cmd.Wait()
+						return cmd.Wait()
 					},
 				},
 			},
-			want: nil,
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+			},
 		},
-		"RunErrorWithCheckWhenObservePolicy": {
-			reason: "We should return any error we encounter when running the runner",
+		"StatusUpdateConflict": {
+			reason: "We should update the status of the latest AnsibleRun when its update conflicts instead of failing the run",
 			args: args{
 				ctx: context.Background(),
 				mg:  &v1alpha1.AnsibleRun{},
 			},
 			fields: fields{
-				runner: &MockRunner{
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
+				kube: &test.MockClient{
+					MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+						obj.SetResourceVersion("2")
+						return nil
+					}),
+					MockStatusUpdate: func(_ context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+						// only the latest AnsibleRun is written
+						if obj.GetResourceVersion() != "2" {
+							return kerrors.NewConflict(schema.GroupResource{}, "", errBoom)
+						}
 						return nil
 					},
+				},
+				runner: &MockRunner{
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
-							Name: "CheckWhenObserve",
+							Name: "ObserveAndDelete",
 						}
 					},
-					MockRun: func(context.Context) (io.Reader, error) {
-						return nil, errBoom
+					MockApply: func(ctx context.Context) error {
+						return nil
 					},
 				},
 			},
-			want: errBoom,
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+			},
 		},
-		"SuccessCheckWhenObserve": {
-			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
+		"IgnoredFailures": {
+			reason: "We should report the ignored failures of the run as warnings",
 			args: args{
 				ctx: context.Background(),
 				mg:  &v1alpha1.AnsibleRun{},
 			},
 			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
 				runner: &MockRunner{
-					MockWriteExtraVar: func(extraVar map[string]interface{}) error {
-						return nil
-					},
 					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
 						return &ansible.RunPolicy{
-							Name: "CheckWhenObserve",
+							Name: "ObserveAndDelete",
 						}
 					},
-					MockRun: func(ctx context.Context) (io.Reader, error) {
-						cmd := exec.CommandContext(ctx, "ls")
-						cmd.Start()
-						return nil, cmd.Wait()
+					MockApply: func(ctx context.Context) error {
+						return nil
+					},
+					MockWarnings: func() []string {
+						return []string{`Ignored failure on play "test", task "file", host "testhost": fake error`}
 					},
 				},
 			},
-			want: nil,
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+				warnings:   []string{`Ignored failure on play "test", task "file", host "testhost": fake error`},
+			},
 		},
-	}
+		"SlowestTasks": {
+			reason: "We should report the slowest tasks of the run when task profiling is enabled",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						return nil
+					},
+					MockSlowestTasks: func() []v1alpha1.TaskTiming {
+						return []v1alpha1.TaskTiming{{Task: "slow", Duration: metav1.Duration{Duration: time.Minute}}}
+					},
+				},
+			},
+			want: want{
+				conditions:   []xpv1.Condition{xpv1.Available()},
+				created:      true,
+				slowestTasks: []v1alpha1.TaskTiming{{Task: "slow", Duration: metav1.Duration{Duration: time.Minute}}},
+			},
+		},
+		"ConnectionDetails": {
+			reason: "We should publish the connection details found in the run, and report the missing ones as warnings",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						return nil
+					},
+					MockConnectionDetails: func() (managed.ConnectionDetails, error) {
+						return managed.ConnectionDetails{"endpoint": []byte("https://db.example.com")}, errBoom
+					},
+				},
+			},
+			want: want{
+				o:          managed.ExternalCreation{ConnectionDetails: managed.ConnectionDetails{"endpoint": []byte("https://db.example.com")}},
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+				warnings:   []string{errConnectionDetails + ": " + errBoom.Error()},
+			},
+		},
+		"LastRun": {
+			reason: "We should report the summary of the run in the status",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						return nil
+					},
+					MockLastRun: func() *v1alpha1.RunSummary {
+						return &v1alpha1.RunSummary{ID: "run", Hosts: []v1alpha1.HostStats{{Host: "web1", Ok: 2, Changed: 1}}}
+					},
+				},
+			},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+				lastRun:    &v1alpha1.RunSummary{ID: "run", Hosts: []v1alpha1.HostStats{{Host: "web1", Ok: 2, Changed: 1}}},
+			},
+		},
+		"RunNotStarted": {
+			reason: "We should keep the summary of the previous run when the run didn't start",
+			args: args{
+				ctx: context.Background(),
+				mg: &v1alpha1.AnsibleRun{Status: v1alpha1.AnsibleRunStatus{AtProvider: v1alpha1.AnsibleRunObservation{
+					LastRun: &v1alpha1.RunSummary{ID: "previous"},
+				}}},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						return errBoom
+					},
+				},
+			},
+			want: want{
+				conditions: []xpv1.Condition{unavaliableCond},
+				lastRun:    &v1alpha1.RunSummary{ID: "previous"},
+			},
+		},
+		"ContentHash": {
+			reason: "We should record the hash of the contents of the run in the status and in a server-side applied label, applying the last applied annotation again",
+			args: args{
+				ctx: context.Background(),
+				mg: &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{v1.LastAppliedConfigAnnotation: "{}"},
+				}},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+					MockPatch: func(_ context.Context, obj client.Object, patch client.Patch, _ ...client.PatchOption) error {
+						if patch != client.Apply {
+							return fmt.Errorf("unexpected patch type %q", patch.Type())
+						}
+						if diff := cmp.Diff(map[string]string{ansible.LabelKeyContentHash: "abc"}, obj.GetLabels()); diff != "" {
+							return fmt.Errorf("unexpected labels (-want +got):\n%s", diff)
+						}
+						if diff := cmp.Diff(map[string]string{v1.LastAppliedConfigAnnotation: "{}"}, obj.GetAnnotations()); diff != "" {
+							return fmt.Errorf("unexpected annotations (-want +got):\n%s", diff)
+						}
+						return nil
+					},
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						return nil
+					},
+					MockContentHash: func() string {
+						return "sha256:abc"
+					},
+				},
+			},
+			want: want{
+				conditions:  []xpv1.Condition{xpv1.Available()},
+				created:     true,
+				contentHash: "sha256:abc",
+				labels:      map[string]string{ansible.LabelKeyContentHash: "abc"},
+			},
+		},
+		"FirstRunCreates": {
+			reason: "We should run the create contents until the first run succeeds",
+			args: args{
+				ctx: context.Background(),
+				mg: &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+					CreateTags: []string{"bootstrap"},
+				}}},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockCreate: func(ctx context.Context) error {
+						return nil
+					},
+				},
+			},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+			},
+		},
+		"CreatedRunApplies": {
+			reason: "We should run the contents once the first run succeeded",
+			args: args{
+				ctx: context.Background(),
+				mg: &v1alpha1.AnsibleRun{
+					Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+						CreateTags: []string{"bootstrap"},
+					}},
+					Status: v1alpha1.AnsibleRunStatus{AtProvider: v1alpha1.AnsibleRunObservation{Created: true}},
+				},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						return nil
+					},
+				},
+			},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+			},
+		},
+		"RunErrorWithCheckWhenObservePolicy": {
+			reason: "We should report a failed run in the Ready condition rather than return it",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockApply: func(context.Context) error {
+						return errBoom
+					},
+				},
+			},
+			want: want{
+				conditions: []xpv1.Condition{unavaliableCond},
+			},
+		},
+		"SuccessCheckWhenObserve": {
+			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				kube: &test.MockClient{
+					MockStatusUpdate: test.NewMockSubResourceUpdateFn(nil),
+				},
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockApply: func(ctx context.Context) error {
+						cmd := exec.CommandContext(ctx, "ls")
+						cmd.Start()
+						return cmd.Wait()
+					},
+				},
+			},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.Available()},
+				created:    true,
+			},
+		},
+	}
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
 			e := external{runner: tc.fields.runner, kube: tc.fields.kube}
-			err := e.Delete(tc.args.ctx, tc.args.mg)
-			if diff := cmp.Diff(tc.want, err, test.EquateErrors()); diff != "" {
-				t.Errorf("\n%s\ne.Delete(...): -want error, +got error:\n%s\n", tc.reason, diff)
+			got, err := e.Create(tc.args.ctx, tc.args.mg)
+			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\ne.Observe(...): -want error, +got error:\n%s\n", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.o, got); diff != "" {
+				t.Errorf("\n%s\ne.Observe(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+
+			if tc.args.mg == nil {
+				return
+			}
+
+			if diff := cmp.Diff(
+				tc.want.conditions,
+				tc.args.mg.(*v1alpha1.AnsibleRun).Status.Conditions,
+				cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime"),
+			); diff != "" {
+				t.Errorf("ansiblerun conditions: (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want.warnings, tc.args.mg.(*v1alpha1.AnsibleRun).Status.AtProvider.Warnings); diff != "" {
+				t.Errorf("ansiblerun warnings: (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want.slowestTasks, tc.args.mg.(*v1alpha1.AnsibleRun).Status.AtProvider.SlowestTasks); diff != "" {
+				t.Errorf("ansiblerun slowest tasks: (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want.contentHash, tc.args.mg.(*v1alpha1.AnsibleRun).Status.AtProvider.ContentHash); diff != "" {
+				t.Errorf("ansiblerun content hash: (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want.labels, tc.args.mg.(*v1alpha1.AnsibleRun).GetLabels()); diff != "" {
+				t.Errorf("ansiblerun labels: (-want +got):\n%s", diff)
+			}
+
+			if created := tc.args.mg.(*v1alpha1.AnsibleRun).Status.AtProvider.Created; created != tc.want.created {
+				t.Errorf("ansiblerun created: want %t, got %t", tc.want.created, created)
+			}
+
+			if diff := cmp.Diff(tc.want.lastRun, tc.args.mg.(*v1alpha1.AnsibleRun).Status.AtProvider.LastRun); diff != "" {
+				t.Errorf("ansiblerun last run: (-want +got):\n%s", diff)
 			}
 		})
 	}
 }
+
+func TestDelete(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	type fields struct {
+		kube   client.Client
+		runner ansibleRunner
+	}
+
+	type args struct {
+		ctx context.Context
+		mg  resource.Managed
+	}
+
+	withRetries := func(limit, failed int32) *v1alpha1.AnsibleRun {
+		cr := &v1alpha1.AnsibleRun{}
+		cr.Spec.ForProvider.DeletionRetryLimit = &limit
+		cr.Status.AtProvider.FailedTeardowns = failed
+		return cr
+	}
+	failingDestroy := &MockRunner{
+		MockDestroy: func(context.Context) error {
+			return errBoom
+		},
+	}
+
+	cases := map[string]struct {
+		reason     string
+		fields     fields
+		args       args
+		want       error
+		wantReason xpv1.ConditionReason
+	}{
+		"NotAnAnsibleRunError": {
+			reason: "We should return an error if the supplied managed resource is not an AnsibleRun",
+			args: args{
+				mg: nil,
+			},
+			want: errors.New(errNotAnsibleRun),
+		},
+		"RunErrorWithObserveAndDeletePolicy": {
+			reason: "We should return any error we encounter when running the runner",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockDestroy: func(context.Context) error {
+						return errBoom
+					},
+				},
+			},
+			want: errBoom,
+		},
+		"SuccessObserveAndDelete": {
+			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "ObserveAndDelete",
+						}
+					},
+					MockDestroy: func(ctx context.Context) error {
+						cmd := exec.CommandContext(ctx, "ls")
+						cmd.Start()
+						return cmd.Wait()
+					},
+				},
+			},
+			want: nil,
+		},
+		"RunErrorWithCheckWhenObservePolicy": {
+			reason: "We should return any error we encounter when running the runner",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockDestroy: func(context.Context) error {
+						return errBoom
+					},
+				},
+			},
+			want: errBoom,
+		},
+		"SuccessCheckWhenObserve": {
+			reason: "We should not return an error when we successfully delete the AnsibleRun resource",
+			args: args{
+				ctx: context.Background(),
+				mg:  &v1alpha1.AnsibleRun{},
+			},
+			fields: fields{
+				runner: &MockRunner{
+					MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+						return &ansible.RunPolicy{
+							Name: "CheckWhenObserve",
+						}
+					},
+					MockDestroy: func(ctx context.Context) error {
+						cmd := exec.CommandContext(ctx, "ls")
+						cmd.Start()
+						return cmd.Wait()
+					},
+				},
+			},
+			want: nil,
+		},
+		"DeletionRetriesLeft": {
+			reason: "We should keep retrying a failed teardown within the deletion retry limit",
+			args: args{
+				ctx: context.Background(),
+				mg:  withRetries(1, 0),
+			},
+			fields: fields{
+				runner: failingDestroy,
+			},
+			want: errBoom,
+		},
+		"DeletionRetriesExhausted": {
+			reason: "We should give up a failed teardown beyond the deletion retry limit, letting the finalizer go",
+			args: args{
+				ctx: context.Background(),
+				mg:  withRetries(1, 1),
+			},
+			fields: fields{
+				runner: failingDestroy,
+			},
+			wantReason: v1alpha1.ReasonTeardownSkipped,
+		},
+		"ForceDelete": {
+			reason: "We should skip the teardown of a force deleted AnsibleRun",
+			args: args{
+				ctx: context.Background(),
+				mg: &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{AnnotationKeyForceDelete: "true"},
+				}},
+			},
+			fields: fields{
+				runner: &MockRunner{
+					MockDestroy: func(context.Context) error {
+						t.Error("Destroy(...): want no teardown of a force deleted AnsibleRun")
+						return nil
+					},
+				},
+			},
+			wantReason: v1alpha1.ReasonTeardownSkipped,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := external{runner: tc.fields.runner, kube: tc.fields.kube}
+			err := e.Delete(tc.args.ctx, tc.args.mg)
+			if diff := cmp.Diff(tc.want, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\ne.Delete(...): -want error, +got error:\n%s\n", tc.reason, diff)
+			}
+			cr, ok := tc.args.mg.(*v1alpha1.AnsibleRun)
+			if !ok {
+				return
+			}
+			// a failed teardown is reported, and keeps the finalizer
+			wantReason := tc.wantReason
+			switch {
+			case wantReason != "":
+			case tc.want != nil:
+				wantReason = v1alpha1.ReasonTeardownFailed
+			default:
+				wantReason = v1alpha1.ReasonTornDown
+			}
+			if got := cr.GetCondition(v1alpha1.TypeTornDown).Reason; got != wantReason {
+				t.Errorf("\n%s\ne.Delete(...): want a %s TornDown condition, got %q", tc.reason, wantReason, got)
+			}
+		})
+	}
+}
+
+func TestPollIntervalHook(t *testing.T) {
+	unavailable := xpv1.Unavailable()
+	unavailable.Message = "boom"
+	unreachable := xpv1.Unavailable()
+	unreachable.Reason = xpv1.ConditionReason(ansible.FailureUnreachable)
+	syntaxError := xpv1.Unavailable()
+	syntaxError.Reason = xpv1.ConditionReason(ansible.FailureSyntax)
+
+	cases := map[string]struct {
+		reason     string
+		failed     time.Duration
+		conditions []xpv1.Condition
+		nextRun    time.Duration
+		override   time.Duration
+		jitter     time.Duration
+		want       time.Duration
+	}{
+		"Healthy": {
+			reason:     "We should keep the poll interval for healthy resources",
+			failed:     10 * time.Second,
+			conditions: []xpv1.Condition{xpv1.Available(), xpv1.ReconcileSuccess()},
+			want:       time.Minute,
+		},
+		"RunFailed": {
+			reason:     "We should use the failed interval when the last run failed",
+			failed:     10 * time.Second,
+			conditions: []xpv1.Condition{unavailable},
+			want:       10 * time.Second,
+		},
+		"RetryableRunFailure": {
+			reason:     "We should use the failed interval when the last run failed in a way that may be transient",
+			failed:     10 * time.Second,
+			conditions: []xpv1.Condition{unreachable},
+			want:       10 * time.Second,
+		},
+		"TerminalRunFailure": {
+			reason:     "We should keep the poll interval when running the contents again won't fix the last run",
+			failed:     10 * time.Second,
+			conditions: []xpv1.Condition{syntaxError, xpv1.ReconcileError(errors.New("boom"))},
+			want:       time.Minute,
+		},
+		"ReconcileError": {
+			reason:     "We should use the failed interval when the last reconcile failed",
+			failed:     10 * time.Second,
+			conditions: []xpv1.Condition{xpv1.ReconcileError(errors.New("boom"))},
+			want:       10 * time.Second,
+		},
+		"Disabled": {
+			reason:     "We should keep the poll interval when no failed interval is configured",
+			conditions: []xpv1.Condition{unavailable},
+			want:       time.Minute,
+		},
+		"FailedLongerThanPoll": {
+			reason:     "We should never requeue failed resources later than healthy ones",
+			failed:     time.Hour,
+			conditions: []xpv1.Condition{unavailable},
+			want:       time.Minute,
+		},
+		"DeferredRun": {
+			reason:     "We should requeue resources whose run is deferred when it is due",
+			conditions: []xpv1.Condition{xpv1.Available(), xpv1.ReconcileSuccess()},
+			nextRun:    30 * time.Second,
+			want:       30 * time.Second,
+		},
+		"DeferredRunAfterPoll": {
+			reason:     "We should keep the poll interval when the deferred run is due later",
+			conditions: []xpv1.Condition{xpv1.Available(), xpv1.ReconcileSuccess()},
+			nextRun:    time.Hour,
+			want:       time.Minute,
+		},
+		"Override": {
+			reason:     "We should use the poll interval of the AnsibleRun instead of the one of the provider",
+			conditions: []xpv1.Condition{xpv1.Available(), xpv1.ReconcileSuccess()},
+			override:   5 * time.Minute,
+			want:       5 * time.Minute,
+		},
+		"OverrideFailed": {
+			reason:     "We should use the failed interval when the last run of an AnsibleRun with its own poll interval failed",
+			failed:     10 * time.Second,
+			conditions: []xpv1.Condition{unavailable},
+			override:   5 * time.Minute,
+			want:       10 * time.Second,
+		},
+		"Jitter": {
+			reason:     "We should shift the poll interval by up to the jitter",
+			conditions: []xpv1.Condition{xpv1.Available(), xpv1.ReconcileSuccess()},
+			jitter:     10 * time.Second,
+			want:       time.Minute,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{}
+			cr.SetConditions(tc.conditions...)
+			if tc.nextRun != 0 {
+				next := metav1.NewTime(time.Now().Add(tc.nextRun))
+				cr.Status.AtProvider.NextRunTime = &next
+			}
+			if tc.override != 0 {
+				cr.Spec.ForProvider.PollInterval = &metav1.Duration{Duration: tc.override}
+			}
+			got := pollIntervalHook(tc.failed, tc.jitter)(cr, time.Minute)
+			// the time until the deferred run elapses while the test runs
+			if got > tc.want+tc.jitter || got < tc.want-tc.jitter-time.Second {
+				t.Errorf("\n%s\npollIntervalHook(...): want %s, got %s\n", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestReconcileFailedRun(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	cases := map[string]struct {
+		reason string
+		policy string
+		err    error
+		want   time.Duration
+		ready  xpv1.ConditionReason
+	}{
+		"Succeeded": {
+			reason: "We should requeue a successful run after the poll interval",
+			policy: "CheckWhenObserve",
+			want:   time.Minute,
+			ready:  xpv1.ReasonAvailable,
+		},
+		"RetryableFailure": {
+			reason: "We should requeue a run that failed in a way that may go away by itself after the failed interval",
+			policy: "CheckWhenObserve",
+			err:    &ansible.RunError{Kind: ansible.FailureUnreachable, Err: errBoom},
+			want:   10 * time.Second,
+			ready:  xpv1.ConditionReason(ansible.FailureUnreachable),
+		},
+		"NonRetryableFailure": {
+			reason: "We should requeue a run that failed in a way that running it again won't fix after the poll interval",
+			policy: "CheckWhenObserve",
+			err:    &ansible.RunError{Kind: ansible.FailureSyntax, Err: errBoom},
+			want:   time.Minute,
+			ready:  xpv1.ConditionReason(ansible.FailureSyntax),
+		},
+		"RetryableFailureWhenObserved": {
+			reason: "We should requeue a run of the observation that failed in a way that may go away by itself after the failed interval",
+			policy: "ObserveAndDelete",
+			err:    &ansible.RunError{Kind: ansible.FailureTimeout, Err: errBoom},
+			want:   10 * time.Second,
+			ready:  xpv1.ConditionReason(ansible.FailureTimeout),
+		},
+		"NonRetryableFailureWhenObserved": {
+			reason: "We should requeue a run of the observation that failed in a way that running it again won't fix after the poll interval",
+			policy: "ObserveAndDelete",
+			err:    &ansible.RunError{Kind: ansible.FailureAuthentication, Err: errBoom},
+			want:   time.Minute,
+			ready:  xpv1.ConditionReason(ansible.FailureAuthentication),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			kube := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&v1alpha1.AnsibleRun{}).
+				WithObjects(&v1alpha1.AnsibleRun{
+					ObjectMeta: metav1.ObjectMeta{Name: "run"},
+					Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+						RunPolicy: tc.policy,
+						StateVar:  ansible.DefaultStateVar,
+					}},
+				}).
+				// the fake client doesn't support server-side apply
+				WithInterceptorFuncs(interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						if patch.Type() == types.ApplyPatchType {
+							patch = client.Merge
+						}
+						return c.Patch(ctx, obj, patch)
+					},
+				}).Build()
+			runner := &MockRunner{
+				MockAnsibleRunPolicy: func() *ansible.RunPolicy {
+					return &ansible.RunPolicy{Name: tc.policy}
+				},
+				MockCheck: func(context.Context) (*results.AnsiblePlaybookJSONResults, error) {
+					return &results.AnsiblePlaybookJSONResults{
+						Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{"localhost": {Changed: 1}},
+					}, nil
+				},
+				MockApply: func(context.Context) error {
+					return tc.err
+				},
+			}
+			r := managed.NewReconciler(&rfake.Manager{Client: kube, Scheme: s},
+				resource.ManagedKind(v1alpha1.AnsibleRunGroupVersionKind),
+				managed.WithExternalConnecter(managed.ExternalConnectorFn(func(context.Context, resource.Managed) (managed.ExternalClient, error) {
+					return &external{kube: kube, runner: runner}, nil
+				})),
+				managed.WithPollInterval(time.Minute),
+				managed.WithPollIntervalHook(pollIntervalHook(10*time.Second, 0)))
+
+			got, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "run"}})
+			if err != nil {
+				t.Fatalf("\n%s\nr.Reconcile(...): %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(reconcile.Result{RequeueAfter: tc.want}, got); diff != "" {
+				t.Errorf("\n%s\nr.Reconcile(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			cr := &v1alpha1.AnsibleRun{}
+			if err := kube.Get(context.Background(), types.NamespacedName{Name: "run"}, cr); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.ready, cr.GetCondition(xpv1.TypeReady).Reason); diff != "" {
+				t.Errorf("\n%s\nr.Reconcile(...): -want ready reason, +got ready reason:\n%s", tc.reason, diff)
+			}
+			// a failed run is not a failed reconcile
+			if diff := cmp.Diff(xpv1.ReasonReconcileSuccess, cr.GetCondition(xpv1.TypeSynced).Reason); diff != "" {
+				t.Errorf("\n%s\nr.Reconcile(...): -want synced reason, +got synced reason:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusConflict(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	kube := fake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&v1alpha1.AnsibleRun{}).
+		WithObjects(&v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "run"}}).Build()
+	cr := &v1alpha1.AnsibleRun{}
+	if err := kube.Get(context.Background(), types.NamespacedName{Name: "run"}, cr); err != nil {
+		t.Fatal(err)
+	}
+	e := &external{kube: kube, persisted: cr.DeepCopy()}
+
+	// another writer changes the status while the AnsibleRun is reconciled
+	concurrent := cr.DeepCopy()
+	concurrent.Status.AtProvider.CurrentTask = "concurrent"
+	concurrent.SetConditions(xpv1.ReconcileSuccess())
+	if err := kube.Status().Update(context.Background(), concurrent); err != nil {
+		t.Fatal(err)
+	}
+
+	cr.Status.AtProvider.Phase = "Succeeded"
+	cr.SetConditions(xpv1.Available())
+	if err := e.updateStatus(context.Background(), cr); err != nil {
+		t.Fatalf("updateStatus(...): %v", err)
+	}
+
+	got := &v1alpha1.AnsibleRun{}
+	if err := kube.Get(context.Background(), types.NamespacedName{Name: "run"}, got); err != nil {
+		t.Fatal(err)
+	}
+	want := v1alpha1.AnsibleRunObservation{Phase: "Succeeded", CurrentTask: "concurrent"}
+	if diff := cmp.Diff(want, got.Status.AtProvider); diff != "" {
+		t.Errorf("\nupdateStatus(...): -want atProvider, +got atProvider:\n%s", diff)
+	}
+	for _, c := range []xpv1.Condition{xpv1.ReconcileSuccess(), xpv1.Available()} {
+		if diff := cmp.Diff(c, got.GetCondition(c.Type), test.EquateConditions()); diff != "" {
+			t.Errorf("\nupdateStatus(...): -want condition, +got condition:\n%s", diff)
+		}
+	}
+	if diff := cmp.Diff(got.Status, cr.Status); diff != "" {
+		t.Errorf("\nupdateStatus(...): -want status, +got status:\n%s", diff)
+	}
+}
+
+func TestTruncateStatus(t *testing.T) {
+	warnings := func(from, to int, size int) []string {
+		var l []string
+		for i := from; i < to; i++ {
+			w := fmt.Sprintf("warning %d", i)
+			l = append(l, w+strings.Repeat(".", size-len(w)))
+		}
+		return l
+	}
+	tasks := func(n int) []v1alpha1.TaskTiming {
+		var l []v1alpha1.TaskTiming
+		for i := 0; i < n; i++ {
+			l = append(l, v1alpha1.TaskTiming{Task: fmt.Sprintf("task %d", i)})
+		}
+		return l
+	}
+	hosts := func(n int) []string {
+		var l []string
+		for i := 0; i < n; i++ {
+			l = append(l, fmt.Sprintf("host%03d", i))
+		}
+		return l
+	}
+	drifted := func(n, hostsPerTask int) []v1alpha1.DriftedTask {
+		var l []v1alpha1.DriftedTask
+		for i := 0; i < n; i++ {
+			l = append(l, v1alpha1.DriftedTask{Task: fmt.Sprintf("task %d", i), Hosts: hosts(hostsPerTask)})
+		}
+		return l
+	}
+	stats := func(n int) []v1alpha1.HostStats {
+		var l []v1alpha1.HostStats
+		for _, h := range hosts(n) {
+			l = append(l, v1alpha1.HostStats{Host: h, Ok: 1})
+		}
+		return l
+	}
+	unavailable := func(msg string) xpv1.Condition {
+		c := xpv1.Unavailable()
+		c.Message = msg
+		return c
+	}
+
+	cases := map[string]struct {
+		reason string
+		status v1alpha1.AnsibleRunStatus
+		want   v1alpha1.AnsibleRunStatus
+	}{
+		"WithinBudget": {
+			reason: "We should not change a status within the budget",
+			status: v1alpha1.AnsibleRunStatus{
+				ResourceStatus: xpv1.ResourceStatus{ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{unavailable("boom")}}},
+				AtProvider:     v1alpha1.AnsibleRunObservation{Warnings: warnings(0, 2, 20), SlowestTasks: tasks(2)},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				ResourceStatus: xpv1.ResourceStatus{ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{unavailable("boom")}}},
+				AtProvider:     v1alpha1.AnsibleRunObservation{Warnings: warnings(0, 2, 20), SlowestTasks: tasks(2)},
+			},
+		},
+		"LongConditionMessage": {
+			reason: "We should truncate long condition messages without splitting characters",
+			status: v1alpha1.AnsibleRunStatus{
+				ResourceStatus: xpv1.ResourceStatus{ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{unavailable(strings.Repeat("é", statusutil.MaxMessageBytes))}}},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				ResourceStatus: xpv1.ResourceStatus{ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{unavailable(strings.Repeat("é", 1016) + "... (truncated)")}}},
+			},
+		},
+		"TooManyWarnings": {
+			reason: "We should keep the most recent warnings and note how many were dropped",
+			status: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{Warnings: warnings(0, 150, 20)},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{Warnings: append([]string{"51 earlier entries truncated"}, warnings(51, 150, 20)...)},
+			},
+		},
+		"WarningsOverSizeBudget": {
+			reason: "We should keep the most recent warnings that fit in the size budget",
+			status: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{Warnings: warnings(0, 20, statusutil.MaxMessageBytes)},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{Warnings: append([]string{"13 earlier entries truncated"}, warnings(13, 20, statusutil.MaxMessageBytes)...)},
+			},
+		},
+		"TooManySlowestTasks": {
+			reason: "We should keep the slowest tasks within the budget",
+			status: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{SlowestTasks: tasks(statusutil.MaxListItems + 10)},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{SlowestTasks: tasks(statusutil.MaxListItems)},
+			},
+		},
+		"LargeDrift": {
+			reason: "We should keep the first hosts and tasks of the drift, and the first hosts of each task, within the budget",
+			status: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{Drift: &v1alpha1.Drift{
+					Hosts: hosts(statusutil.MaxListItems + 10),
+					Tasks: drifted(statusutil.MaxListItems+10, statusutil.MaxListItems+10),
+				}},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{Drift: &v1alpha1.Drift{
+					Hosts: hosts(statusutil.MaxListItems),
+					Tasks: drifted(statusutil.MaxListItems, statusutil.MaxListItems),
+				}},
+			},
+		},
+		"LargeLastRun": {
+			reason: "We should keep the first hosts of the last run and its current task within the budget",
+			status: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{
+					CurrentTask: strings.Repeat("x", statusutil.MaxMessageBytes+1),
+					LastRun:     &v1alpha1.RunSummary{ID: "id", Hosts: stats(statusutil.MaxListItems + 10)},
+				},
+			},
+			want: v1alpha1.AnsibleRunStatus{
+				AtProvider: v1alpha1.AnsibleRunObservation{
+					CurrentTask: strings.Repeat("x", statusutil.MaxMessageBytes-len("... (truncated)")) + "... (truncated)",
+					LastRun:     &v1alpha1.RunSummary{ID: "id", Hosts: stats(statusutil.MaxListItems)},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{Status: tc.status}
+			truncateStatus(cr)
+			if diff := cmp.Diff(tc.want, cr.Status); diff != "" {
+				t.Errorf("\n%s\ntruncateStatus(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestPhaseHandler(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	cases := map[string]struct {
+		reason      string
+		status      string
+		updateErr   error
+		wantUpdates int
+	}{
+		"Running": {
+			reason:      "We should persist the phase as soon as the run is running",
+			status:      ansible.StatusRunning,
+			wantUpdates: 1,
+		},
+		"Successful": {
+			reason: "We should leave the final phase to be persisted with the result of the run",
+			status: ansible.StatusSuccessful,
+		},
+		"UpdateError": {
+			reason:      "We should not fail the run when the phase cannot be persisted",
+			status:      ansible.StatusRunning,
+			updateErr:   errBoom,
+			wantUpdates: 1,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			updates := 0
+			e := external{kube: &test.MockClient{
+				MockStatusUpdate: func(_ context.Context, _ client.Object, _ ...client.SubResourceUpdateOption) error {
+					updates++
+					return tc.updateErr
+				},
+			}}
+			cr := &v1alpha1.AnsibleRun{}
+			e.phaseHandler(cr)(context.Background(), tc.status)
+			if cr.Status.AtProvider.Phase != tc.status {
+				t.Errorf("\n%s\nphaseHandler(...): want phase %q, got %q\n", tc.reason, tc.status, cr.Status.AtProvider.Phase)
+			}
+			if updates != tc.wantUpdates {
+				t.Errorf("\n%s\nphaseHandler(...): want %d status updates, got %d\n", tc.reason, tc.wantUpdates, updates)
+			}
+		})
+	}
+}
+
+func TestHeartbeatHandler(t *testing.T) {
+	updates := 0
+	e := external{kube: &test.MockClient{
+		MockStatusUpdate: func(_ context.Context, _ client.Object, _ ...client.SubResourceUpdateOption) error {
+			updates++
+			return nil
+		},
+	}}
+	cr := &v1alpha1.AnsibleRun{}
+	e.heartbeatHandler(cr)(context.Background(), ansible.Progress{CurrentTask: "install packages", TasksStarted: 3})
+	if cr.Status.AtProvider.CurrentTask != "install packages" {
+		t.Errorf("heartbeatHandler(...): want current task %q, got %q", "install packages", cr.Status.AtProvider.CurrentTask)
+	}
+	if cr.Status.AtProvider.LastHeartbeat == nil {
+		t.Errorf("heartbeatHandler(...): want the last heartbeat to be set")
+	}
+	if diff := cmp.Diff(&v1alpha1.RunProgress{TasksStarted: 3}, cr.Status.AtProvider.Progress); diff != "" {
+		t.Errorf("heartbeatHandler(...): -want progress, +got progress:\n%s", diff)
+	}
+	if updates != 1 {
+		t.Errorf("heartbeatHandler(...): want 1 status update, got %d", updates)
+	}
+
+	e.phaseHandler(cr)(context.Background(), ansible.StatusSuccessful)
+	if cr.Status.AtProvider.CurrentTask != "" {
+		t.Errorf("phaseHandler(...): want the current task to be cleared when the run is over, got %q", cr.Status.AtProvider.CurrentTask)
+	}
+}
+
+type MockRecorder struct {
+	events []event.Event
+}
+
+func (r *MockRecorder) Event(_ runtime.Object, e event.Event) {
+	r.events = append(r.events, e)
+}
+
+func (r *MockRecorder) WithAnnotations(_ ...string) event.Recorder {
+	return r
+}
+
+func TestProgress(t *testing.T) {
+	percent := func(p int) *int { return &p }
+	cr := &v1alpha1.AnsibleRun{}
+
+	completeProgress(cr, &v1alpha1.RunSummary{Tasks: 8})
+	if diff := cmp.Diff(&v1alpha1.RunProgress{TasksStarted: 8, ExpectedTasks: 8, Percent: percent(100)}, cr.Status.AtProvider.Progress); diff != "" {
+		t.Errorf("completeProgress(...): -want, +got:\n%s", diff)
+	}
+
+	setProgress(cr, 2)
+	if diff := cmp.Diff(&v1alpha1.RunProgress{TasksStarted: 2, ExpectedTasks: 8, Percent: percent(25)}, cr.Status.AtProvider.Progress); diff != "" {
+		t.Errorf("setProgress(...): -want, +got:\n%s", diff)
+	}
+
+	// runs starting more tasks than expected are not over until they succeed
+	setProgress(cr, 10)
+	if diff := cmp.Diff(&v1alpha1.RunProgress{TasksStarted: 10, ExpectedTasks: 8, Percent: percent(99)}, cr.Status.AtProvider.Progress); diff != "" {
+		t.Errorf("setProgress(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestTaskEventHandler(t *testing.T) {
+	rec := &MockRecorder{}
+	e := external{recorder: rec}
+	h := e.taskEventHandler(&v1alpha1.AnsibleRun{})
+	h(context.Background(), ansible.TaskEvent{Kind: ansible.TaskChanged, Message: "changed"})
+	h(context.Background(), ansible.TaskEvent{Kind: ansible.TaskFailed, Message: "failed"})
+
+	want := []event.Event{
+		event.Normal(ansible.TaskChanged, "changed"),
+		event.Warning(ansible.TaskFailed, errors.New("failed")),
+	}
+	if diff := cmp.Diff(want, rec.events); diff != "" {
+		t.Errorf("taskEventHandler(...): -want events, +got events:\n%s", diff)
+	}
+}
+
+func TestWriteFsCredentialsDir(t *testing.T) {
+	fsCreds := func(filename, path string) v1alpha1.ProviderCredentials {
+		return v1alpha1.ProviderCredentials{
+			Filename: filename,
+			Source:   xpv1.CredentialsSourceFilesystem,
+			CommonCredentialSelectors: xpv1.CommonCredentialSelectors{
+				Fs: &xpv1.FsSelector{Path: path},
+			},
+		}
+	}
+
+	type want struct {
+		written bool
+		files   map[string]string
+		err     error
+	}
+
+	cases := map[string]struct {
+		reason string
+		cd     v1alpha1.ProviderCredentials
+		want   want
+	}{
+		"SingleFile": {
+			reason: "We should leave single files to be written like any other credentials",
+			cd:     fsCreds("id_rsa", "/mnt/ssh/id_rsa"),
+			want:   want{},
+		},
+		"Directory": {
+			reason: "We should copy the files of a directory, skipping the atomic writer entries and subdirectories",
+			cd:     fsCreds(".ssh", "/mnt/ssh"),
+			want: want{
+				written: true,
+				files: map[string]string{
+					"project/.ssh/id_rsa":      "private key",
+					"project/.ssh/id_rsa.pub":  "public key",
+					"project/.ssh/known_hosts": "known hosts",
+				},
+			},
+		},
+		"Glob": {
+			reason: "We should copy the files matched by a glob",
+			cd:     fsCreds(".ssh", "/mnt/ssh/id_*"),
+			want: want{
+				written: true,
+				files: map[string]string{
+					"project/.ssh/id_rsa":     "private key",
+					"project/.ssh/id_rsa.pub": "public key",
+				},
+			},
+		},
+		"NoMatch": {
+			reason: "We should return an error when a glob matches no file",
+			cd:     fsCreds("certs", "/mnt/certs/*.pem"),
+			want: want{
+				err: fmt.Errorf("%s: %s %q", errGetCreds, errNoFsCreds, "/mnt/certs/*.pem"),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			for p, content := range map[string]string{
+				"/mnt/ssh/id_rsa":                "private key",
+				"/mnt/ssh/id_rsa.pub":            "public key",
+				"/mnt/ssh/known_hosts":           "known hosts",
+				"/mnt/ssh/..2024_01_01/id_rsa":   "private key",
+				"/mnt/ssh/subdir/ignored":        "ignored",
+				"/mnt/ssh/..data/known_hosts":    "known hosts",
+				"/mnt/certs/not-a-certificate.1": "ignored",
+			} {
+				if err := fs.WriteFile(p, []byte(content), 0600); err != nil {
+					t.Fatalf("Writing test file: %v", err)
+				}
+			}
+
+			c := connector{fs: fs}
+			written, err := c.writeFsCredentialsDir("project", tc.cd)
+			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\nc.writeFsCredentialsDir(...): -want error, +got error:\n%s\n", tc.reason, diff)
+			}
+			if written != tc.want.written {
+				t.Errorf("\n%s\nc.writeFsCredentialsDir(...): want written %t, got %t\n", tc.reason, tc.want.written, written)
+			}
+			for p, content := range tc.want.files {
+				got, err := fs.ReadFile(p)
+				if err != nil {
+					t.Fatalf("Reading %s: %v", p, err)
+				}
+				if string(got) != content {
+					t.Errorf("\n%s\n%s: want %q, got %q\n", tc.reason, p, content, string(got))
+				}
+			}
+			if tc.want.written {
+				infos, err := fs.ReadDir(filepath.Join("project", tc.cd.Filename))
+				if err != nil {
+					t.Fatalf("Reading credentials dir: %v", err)
+				}
+				if len(infos) != len(tc.want.files) {
+					t.Errorf("\n%s\nc.writeFsCredentialsDir(...): want %d files, got %d\n", tc.reason, len(tc.want.files), len(infos))
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRoleVersions(t *testing.T) {
+	errBoom := errors.New("boom")
+	interval := &metav1.Duration{Duration: time.Hour}
+	recent := metav1.NewTime(time.Now().Add(-time.Minute))
+	old := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	roles := []v1alpha1.Role{
+		{Name: "floating", Src: "git+https://github.com/org/floating.git"},
+		{Name: "branch", Src: "git+https://github.com/org/branch.git", Version: "main"},
+		{Name: "pinned", Src: "git+https://github.com/org/pinned.git", Version: "v1.0.0"},
+		{Name: "galaxy", Src: "org.galaxy"},
+	}
+	resolved := []v1alpha1.ResolvedRoleVersion{
+		{Name: "floating", Commit: "c1"},
+		{Name: "branch", Version: "main", Commit: "c2"},
+	}
+	resolver := func(err error) func(context.Context, string, string, []string) (string, bool, error) {
+		return func(_ context.Context, src, version string, _ []string) (string, bool, error) {
+			if err != nil {
+				return "", false, err
+			}
+			switch src {
+			case roles[0].Src:
+				return "c1", true, nil
+			case roles[1].Src:
+				return "c2", true, nil
+			}
+			return "", false, nil
+		}
+	}
+
+	cases := map[string]struct {
+		reason       string
+		interval     *metav1.Duration
+		lastCheck    *metav1.Time
+		recorded     []v1alpha1.ResolvedRoleVersion
+		resolveErr   error
+		wantMoved    bool
+		wantRecorded []v1alpha1.ResolvedRoleVersion
+		wantChecked  bool
+	}{
+		"Disabled": {
+			reason: "We should not check the role versions when no interval is set",
+		},
+		"NotDue": {
+			reason:       "We should not check the role versions before the interval elapsed",
+			interval:     interval,
+			lastCheck:    &recent,
+			recorded:     []v1alpha1.ResolvedRoleVersion{{Name: "floating", Commit: "c0"}},
+			wantRecorded: []v1alpha1.ResolvedRoleVersion{{Name: "floating", Commit: "c0"}},
+		},
+		"FirstCheck": {
+			reason:       "We should record the role versions without considering them moved on their first check",
+			interval:     interval,
+			wantRecorded: resolved,
+			wantChecked:  true,
+		},
+		"Unchanged": {
+			reason:       "We should not consider role versions resolving to the same commits moved",
+			interval:     interval,
+			lastCheck:    &old,
+			recorded:     resolved,
+			wantRecorded: resolved,
+			wantChecked:  true,
+		},
+		"Moved": {
+			reason:       "We should consider the role versions moved when a branch points to another commit",
+			interval:     interval,
+			lastCheck:    &old,
+			recorded:     []v1alpha1.ResolvedRoleVersion{{Name: "floating", Commit: "c1"}, {Name: "branch", Version: "main", Commit: "c0"}},
+			wantMoved:    true,
+			wantRecorded: resolved,
+			wantChecked:  true,
+		},
+		"ResolveError": {
+			reason:       "We should skip the check when a repository cannot be reached",
+			interval:     interval,
+			lastCheck:    &old,
+			recorded:     resolved,
+			resolveErr:   errBoom,
+			wantRecorded: resolved,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			resolveFloatingVersion = resolver(tc.resolveErr)
+			defer func() { resolveFloatingVersion = galaxyutil.ResolveFloatingVersion }()
+
+			cr := &v1alpha1.AnsibleRun{}
+			cr.Spec.ForProvider.Roles = roles
+			cr.Spec.ForProvider.RoleVersionsCheckInterval = tc.interval
+			cr.Status.AtProvider.LastRoleVersionsCheck = tc.lastCheck
+			cr.Status.AtProvider.RoleVersions = tc.recorded
+
+			moved := checkRoleVersions(context.Background(), cr, nil)
+			if moved != tc.wantMoved {
+				t.Errorf("\n%s\ncheckRoleVersions(...): got moved %t, want %t", tc.reason, moved, tc.wantMoved)
+			}
+			if diff := cmp.Diff(tc.wantRecorded, cr.Status.AtProvider.RoleVersions); diff != "" {
+				t.Errorf("\n%s\nrole versions: (-want +got):\n%s", tc.reason, diff)
+			}
+			checked := cr.Status.AtProvider.LastRoleVersionsCheck != tc.lastCheck
+			if checked != tc.wantChecked {
+				t.Errorf("\n%s\ncheckRoleVersions(...): got checked %t, want %t", tc.reason, checked, tc.wantChecked)
+			}
+		})
+	}
+}
+
+func TestImpersonationVars(t *testing.T) {
+	errBoom := errors.New("boom")
+	expiration := int64(600)
+	sa := &v1alpha1.ServiceAccountImpersonation{Name: "tenant", Namespace: "team-a"}
+
+	requestToken := func(wantExpiration int64) test.MockSubResourceCreateFn {
+		return func(_ context.Context, obj, sub client.Object, _ ...client.SubResourceCreateOption) error {
+			if obj.GetName() != "tenant" || obj.GetNamespace() != "team-a" {
+				return fmt.Errorf("unexpected ServiceAccount %s/%s", obj.GetNamespace(), obj.GetName())
+			}
+			tr := sub.(*authv1.TokenRequest)
+			if got := *tr.Spec.ExpirationSeconds; got != wantExpiration {
+				return fmt.Errorf("unexpected expiration %d, want %d", got, wantExpiration)
+			}
+			tr.Status.Token = "tenant-token"
+			return nil
+		}
+	}
+
+	type want struct {
+		vars  map[string]string
+		files map[string]string
+		err   error
+	}
+
+	cases := map[string]struct {
+		reason  string
+		sa      *v1alpha1.ServiceAccountImpersonation
+		create  test.MockSubResourceCreateFn
+		kubeAPI *rest.Config
+		want    want
+	}{
+		"CAData": {
+			reason:  "We should pass the token with the API server and write its CA to a file",
+			sa:      sa,
+			create:  requestToken(defaultTokenExpirationSeconds),
+			kubeAPI: &rest.Config{Host: "https://10.0.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")}},
+			want: want{
+				vars: map[string]string{
+					"K8S_AUTH_API_KEY":     "tenant-token",
+					"K8S_AUTH_KUBECONFIG":  "",
+					"K8S_AUTH_HOST":        "https://10.0.0.1:443",
+					"K8S_AUTH_SSL_CA_CERT": filepath.Join("dir", kubeCAFile),
+				},
+				files: map[string]string{filepath.Join("dir", kubeCAFile): "ca"},
+			},
+		},
+		"CAFileInsecure": {
+			reason: "We should pass the CA file of the API server as is, and the requested expiration",
+			sa: &v1alpha1.ServiceAccountImpersonation{
+				Name:              "tenant",
+				Namespace:         "team-a",
+				ExpirationSeconds: &expiration,
+			},
+			create:  requestToken(expiration),
+			kubeAPI: &rest.Config{Host: "https://10.0.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAFile: "/ca.crt", Insecure: true}},
+			want: want{
+				vars: map[string]string{
+					"K8S_AUTH_API_KEY":     "tenant-token",
+					"K8S_AUTH_KUBECONFIG":  "",
+					"K8S_AUTH_HOST":        "https://10.0.0.1:443",
+					"K8S_AUTH_SSL_CA_CERT": "/ca.crt",
+					"K8S_AUTH_VERIFY_SSL":  "false",
+				},
+			},
+		},
+		"RequestTokenError": {
+			reason: "We should return any error we encounter requesting the token",
+			sa:     sa,
+			create: test.NewMockSubResourceCreateFn(errBoom),
+			want: want{
+				err: fmt.Errorf("%s team-a/tenant: %w", errRequestToken, errBoom),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			c := connector{
+				kube:    &test.MockClient{MockSubResourceCreate: tc.create},
+				fs:      fs,
+				kubeAPI: tc.kubeAPI,
+			}
+			got, err := c.impersonationVars(context.Background(), "dir", tc.sa)
+			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\nc.impersonationVars(...): -want error, +got error:\n%s\n", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.vars, got); diff != "" {
+				t.Errorf("\n%s\nc.impersonationVars(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+			for p, content := range tc.want.files {
+				data, err := fs.ReadFile(p)
+				if err != nil {
+					t.Fatalf("\n%s\nReading %s: %v", tc.reason, p, err)
+				}
+				if diff := cmp.Diff(content, string(data)); diff != "" {
+					t.Errorf("\n%s\n%s: -want, +got:\n%s\n", tc.reason, p, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestBehaviorVars(t *testing.T) {
+	errBoom := errors.New("boom")
+	get := test.NewMockGetFn(nil, func(obj client.Object) error {
+		switch o := obj.(type) {
+		case *v1.Secret:
+			o.Data = map[string][]byte{"token": []byte("secret-token")}
+		case *v1.ConfigMap:
+			o.Data = map[string]string{"forks": "10"}
+		}
+		return nil
+	})
+
+	type want struct {
+		vars map[string]string
+		err  error
+	}
+
+	cases := map[string]struct {
+		reason string
+		vars   []v1alpha1.Var
+		get    test.MockGetFn
+		want   want
+	}{
+		"ValueFrom": {
+			reason: "We should read the values of the vars from Secrets and ConfigMaps",
+			vars: []v1alpha1.Var{
+				{Key: "ANSIBLE_FORCE_COLOR", Value: "true"},
+				{Key: "GALAXY_TOKEN", ValueFrom: &v1alpha1.VarSource{SecretKeyRef: &xpv1.SecretKeySelector{Key: "token"}}},
+				{Key: "ANSIBLE_FORKS", ValueFrom: &v1alpha1.VarSource{ConfigMapKeyRef: &v1alpha1.ConfigMapKeySelector{Key: "forks"}}},
+			},
+			get: get,
+			want: want{vars: map[string]string{
+				"ANSIBLE_FORCE_COLOR": "true",
+				"GALAXY_TOKEN":        "secret-token",
+				"ANSIBLE_FORKS":       "10",
+			}},
+		},
+		"NoConfigMapKey": {
+			reason: "We should return an error when the ConfigMap lacks the key",
+			vars:   []v1alpha1.Var{{Key: "ANSIBLE_FORKS", ValueFrom: &v1alpha1.VarSource{ConfigMapKeyRef: &v1alpha1.ConfigMapKeySelector{Key: "nope"}}}},
+			get:    get,
+			want:   want{err: fmt.Errorf("%s ANSIBLE_FORKS: %w", errGetVar, fmt.Errorf("%s nope", errNoConfigMapKey))},
+		},
+		"NoSource": {
+			reason: "We should return an error when valueFrom selects nothing",
+			vars:   []v1alpha1.Var{{Key: "ANSIBLE_FORKS", ValueFrom: &v1alpha1.VarSource{}}},
+			want:   want{err: fmt.Errorf("%s ANSIBLE_FORKS: %w", errGetVar, errors.New(errNoVarSource))},
+		},
+		"GetError": {
+			reason: "We should return any error we encounter getting the ConfigMap",
+			vars:   []v1alpha1.Var{{Key: "ANSIBLE_FORKS", ValueFrom: &v1alpha1.VarSource{ConfigMapKeyRef: &v1alpha1.ConfigMapKeySelector{Key: "forks"}}}},
+			get:    test.NewMockGetFn(errBoom),
+			want:   want{err: fmt.Errorf("%s ANSIBLE_FORKS: %w", errGetVar, errBoom)},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := connector{kube: &test.MockClient{MockGet: tc.get}}
+			pc := &v1alpha1.ProviderConfig{Spec: v1alpha1.ProviderConfigSpec{Vars: tc.vars}}
+			got, err := c.behaviorVars(context.Background(), pc)
+			if diff := cmp.Diff(tc.want.err, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\nc.behaviorVars(...): -want error, +got error:\n%s\n", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.vars, got); diff != "" {
+				t.Errorf("\n%s\nc.behaviorVars(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWarnUnknownVars(t *testing.T) {
+	rec := &MockRecorder{}
+	c := connector{recorder: rec}
+	c.warnUnknownVars(&v1alpha1.AnsibleRun{}, map[string]string{"ANSIBLE_FROKS": "10", "ANSIBLE_FORKS": "10"})
+
+	want := []event.Event{event.Warning(reasonUnknownVar, fmt.Errorf("%s ANSIBLE_FROKS", errUnknownVar))}
+	if diff := cmp.Diff(want, rec.events, test.EquateErrors()); diff != "" {
+		t.Errorf("warnUnknownVars(...): -want events, +got events:\n%s", diff)
+	}
+}
+
+type MockAuditLogger struct {
+	records []audit.Record
+	err     error
+}
+
+func (l *MockAuditLogger) Log(_ context.Context, r audit.Record) error {
+	l.records = append(l.records, r)
+	return l.err
+}
+
+func TestAuditRun(t *testing.T) {
+	errBoom := errors.New("boom")
+	cr := &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  uid,
+			Annotations: map[string]string{
+				"requested-by":                 "alice",
+				v1.LastAppliedConfigAnnotation: "{}",
+			},
+		},
+		Spec: v1alpha1.AnsibleRunSpec{ResourceSpec: xpv1.ResourceSpec{
+			ProviderConfigReference: &xpv1.Reference{Name: "default"},
+		}},
+	}
+
+	cases := map[string]struct {
+		reason string
+		step   string
+		err    error
+		want   audit.Record
+	}{
+		"Successful": {
+			reason: "We should record the run with the annotations of the AnsibleRun, but its last applied parameters",
+			step:   audit.StepApply,
+			want: audit.Record{
+				Resource:       "test",
+				UID:            string(uid),
+				ProviderConfig: "default",
+				Annotations:    map[string]string{"requested-by": "alice"},
+				Step:           audit.StepApply,
+				ContentHash:    "sha256:abc",
+				Result:         audit.ResultSuccessful,
+			},
+		},
+		"Failed": {
+			reason: "We should record the error of failed runs",
+			step:   audit.StepDestroy,
+			err:    errBoom,
+			want: audit.Record{
+				Resource:       "test",
+				UID:            string(uid),
+				ProviderConfig: "default",
+				Annotations:    map[string]string{"requested-by": "alice"},
+				Step:           audit.StepDestroy,
+				ContentHash:    "sha256:abc",
+				Result:         audit.ResultFailed,
+				Error:          errBoom.Error(),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			l := &MockAuditLogger{err: errBoom}
+			e := external{
+				runner: &MockRunner{MockContentHash: func() string { return "sha256:abc" }},
+				audit:  l,
+			}
+			e.auditRun(context.Background(), cr, tc.step, time.Now(), tc.err)
+			if len(l.records) != 1 {
+				t.Fatalf("\n%s\ne.auditRun(...): want 1 record, got %d", tc.reason, len(l.records))
+			}
+			if diff := cmp.Diff(tc.want, l.records[0], cmpopts.IgnoreFields(audit.Record{}, "Time", "Duration")); diff != "" {
+				t.Errorf("\n%s\ne.auditRun(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestLastAppliedTrigger(t *testing.T) {
+	cases := map[string]struct {
+		lastParameters   *v1alpha1.AnsibleRunParameters
+		specUnchanged    bool
+		rolesMoved       bool
+		varsChanged      bool
+		extraVarsChanged bool
+		applyDue         bool
+		want             string
+	}{
+		"FirstRun": {
+			want: ansible.TriggerFirstRun,
+		},
+		"SpecChanged": {
+			lastParameters: &v1alpha1.AnsibleRunParameters{},
+			rolesMoved:     true,
+			want:           ansible.TriggerSpecChanged,
+		},
+		"RolesMoved": {
+			lastParameters: &v1alpha1.AnsibleRunParameters{},
+			specUnchanged:  true,
+			rolesMoved:     true,
+			want:           ansible.TriggerRolesMoved,
+		},
+		"VarsFromChanged": {
+			lastParameters: &v1alpha1.AnsibleRunParameters{},
+			specUnchanged:  true,
+			varsChanged:    true,
+			applyDue:       true,
+			want:           ansible.TriggerVarsFromChanged,
+		},
+		"ExtraVarsChanged": {
+			lastParameters:   &v1alpha1.AnsibleRunParameters{},
+			specUnchanged:    true,
+			extraVarsChanged: true,
+			applyDue:         true,
+			want:             ansible.TriggerExtraVarsChanged,
+		},
+		"AlwaysApply": {
+			lastParameters: &v1alpha1.AnsibleRunParameters{},
+			specUnchanged:  true,
+			applyDue:       true,
+			want:           ansible.TriggerAlwaysApply,
+		},
+		"Retry": {
+			lastParameters: &v1alpha1.AnsibleRunParameters{},
+			specUnchanged:  true,
+			want:           ansible.TriggerRetry,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := lastAppliedTrigger(tc.lastParameters, tc.specUnchanged, tc.rolesMoved, tc.varsChanged, tc.extraVarsChanged, tc.applyDue); got != tc.want {
+				t.Errorf("lastAppliedTrigger(...): want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyDue(t *testing.T) {
+	now := time.Now()
+	cases := map[string]struct {
+		reason   string
+		lastRun  time.Duration
+		override time.Duration
+		jitter   time.Duration
+		want     bool
+	}{
+		"NeverRan": {
+			reason: "An AnsibleRun that never ran should be applied",
+			want:   true,
+		},
+		"Elapsed": {
+			reason:  "An AnsibleRun whose poll interval elapsed since its last run should be applied again",
+			lastRun: 61 * time.Second,
+			want:    true,
+		},
+		"JustRan": {
+			reason:  "The reconcile following the updates of the last run should not apply the contents again",
+			lastRun: time.Second,
+		},
+		"Jitter": {
+			reason:  "A reconcile requeued early by the jitter should apply the contents again",
+			lastRun: 55 * time.Second,
+			jitter:  10 * time.Second,
+			want:    true,
+		},
+		"Override": {
+			reason:   "The poll interval of the AnsibleRun should space its runs",
+			lastRun:  2 * time.Minute,
+			override: 5 * time.Minute,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{}
+			if tc.lastRun != 0 {
+				cr.Status.AtProvider.LastRun = &v1alpha1.RunSummary{EndTime: metav1.NewTime(now.Add(-tc.lastRun))}
+			}
+			if tc.override != 0 {
+				cr.Spec.ForProvider.PollInterval = &metav1.Duration{Duration: tc.override}
+			}
+			e := &external{pollInterval: time.Minute, pollJitter: tc.jitter}
+			if got := e.applyDue(cr, now); got != tc.want {
+				t.Errorf("\n%s\ne.applyDue(...): want %t, got %t", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestJobExecutorOf(t *testing.T) {
+	flags := &ansible.JobExecutor{Namespace: "crossplane-system", Image: "flag:image", VolumeClaim: "workdir", MountPath: baseWorkingDir}
+	resources := &v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceMemory: kresource.MustParse("2Gi")}}
+	zone := map[string]string{"topology.kubernetes.io/zone": "dmz"}
+	tolerations := []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "ansible", Effect: v1.TaintEffectNoSchedule}}
+	affinity := &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{}}
+
+	cases := map[string]struct {
+		reason   string
+		executor string
+		job      *ansible.JobExecutor
+		spec     v1alpha1.ProviderConfigSpec
+		want     *ansible.JobExecutor
+		wantErr  error
+	}{
+		"Local": {
+			reason: "The contents should run in the provider by default",
+			job:    flags,
+		},
+		"FlagDefault": {
+			reason:   "The executor of the flags should be used when the ProviderConfig selects none",
+			executor: ansible.ExecutorJob,
+			job:      flags,
+			want:     flags,
+		},
+		"ProviderConfigOverride": {
+			reason: "The ProviderConfig should override the executor and the Jobs of the flags",
+			job:    flags,
+			spec: v1alpha1.ProviderConfigSpec{
+				Executor: ansible.ExecutorJob,
+				Job:      &v1alpha1.JobExecutor{Image: "pc:image", ServiceAccountName: "ansible", Resources: resources},
+			},
+			want: &ansible.JobExecutor{
+				Namespace: "crossplane-system", Image: "pc:image", ServiceAccountName: "ansible",
+				Resources: resources, VolumeClaim: "workdir", MountPath: baseWorkingDir,
+			},
+		},
+		"ProviderConfigScheduling": {
+			reason:   "The ProviderConfig should schedule its Jobs onto the nodes reaching its hosts",
+			executor: ansible.ExecutorJob,
+			job:      flags,
+			spec: v1alpha1.ProviderConfigSpec{
+				Job: &v1alpha1.JobExecutor{NodeSelector: zone, Tolerations: tolerations, Affinity: affinity},
+			},
+			want: &ansible.JobExecutor{
+				Namespace: "crossplane-system", Image: "flag:image", VolumeClaim: "workdir", MountPath: baseWorkingDir,
+				NodeSelector: zone, Tolerations: tolerations, Affinity: affinity,
+			},
+		},
+		"ProviderConfigLocal": {
+			reason:   "The ProviderConfig should be able to run its contents in the provider",
+			executor: ansible.ExecutorJob,
+			job:      flags,
+			spec:     v1alpha1.ProviderConfigSpec{Executor: ansible.ExecutorLocal},
+		},
+		"Fake": {
+			reason:   "The Fake executor should simulate the runs whatever the ProviderConfig selects",
+			executor: ansible.ExecutorFake,
+			spec:     v1alpha1.ProviderConfigSpec{Executor: ansible.ExecutorJob},
+		},
+		"NotSetUp": {
+			reason:  "The Job executor should not be selected without a volume claim",
+			spec:    v1alpha1.ProviderConfigSpec{Executor: ansible.ExecutorJob},
+			wantErr: errors.New(errJobExecutorSetup),
+		},
+		"NoImage": {
+			reason:  "The Job executor should not be selected without an image",
+			job:     &ansible.JobExecutor{VolumeClaim: "workdir"},
+			spec:    v1alpha1.ProviderConfigSpec{Executor: ansible.ExecutorJob},
+			wantErr: errors.New(errJobExecutorImage),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &connector{executor: tc.executor, jobExecutor: tc.job}
+			got, err := c.jobExecutorOf(&v1alpha1.ProviderConfig{Spec: tc.spec})
+			if diff := cmp.Diff(tc.wantErr, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\njobExecutorOf(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\njobExecutorOf(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWriteVaultPasswords(t *testing.T) {
+	errBoom := errors.New("boom")
+	dir := filepath.Join(baseWorkingDir, string(uid))
+	vaultDir := filepath.Join(dir, runnerutil.VaultDir)
+	secret := func(id string) v1alpha1.VaultSecret {
+		return v1alpha1.VaultSecret{
+			ID:     id,
+			Source: xpv1.CredentialsSourceSecret,
+			CommonCredentialSelectors: xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{
+				SecretReference: xpv1.SecretReference{Name: "vault", Namespace: "crossplane-system"},
+				Key:             "password",
+			}},
+		}
+	}
+
+	cases := map[string]struct {
+		reason    string
+		kube      client.Client
+		secrets   []v1alpha1.VaultSecret
+		want      []ansible.VaultID
+		wantFiles map[string]string
+		wantErr   error
+	}{
+		"NoSecrets": {
+			reason: "We should remove the vault passwords of the previous reconciles",
+		},
+		"Secrets": {
+			reason: "We should write each vault password to its own file",
+			kube: &test.MockClient{MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+				obj.(*v1.Secret).Data = map[string][]byte{"password": []byte("s3cr3t")}
+				return nil
+			})},
+			secrets: []v1alpha1.VaultSecret{secret(""), secret("prod")},
+			want: []ansible.VaultID{
+				{File: filepath.Join(vaultDir, "0")},
+				{Label: "prod", File: filepath.Join(vaultDir, "1")},
+			},
+			wantFiles: map[string]string{"0": "s3cr3t", "1": "s3cr3t"},
+		},
+		"GetPasswordError": {
+			reason:  "We should return any error encountered while getting a vault password",
+			kube:    &test.MockClient{MockGet: test.NewMockGetFn(errBoom)},
+			secrets: []v1alpha1.VaultSecret{secret("")},
+			wantErr: fmt.Errorf("%s %d: %w", errGetVaultPassword, 0, fmt.Errorf("cannot get credentials secret: %w", errBoom)),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			// a password of a previous reconcile
+			if err := fs.WriteFile(filepath.Join(vaultDir, "2"), []byte("stale"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			c := &connector{kube: tc.kube, fs: fs}
+			got, err := c.writeVaultPasswords(context.Background(), dir, tc.secrets)
+			if diff := cmp.Diff(tc.wantErr, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\nwriteVaultPasswords(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nwriteVaultPasswords(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			files := map[string]string{}
+			entries, _ := fs.ReadDir(vaultDir)
+			for _, e := range entries {
+				data, _ := fs.ReadFile(filepath.Join(vaultDir, e.Name()))
+				files[e.Name()] = string(data)
+			}
+			if diff := cmp.Diff(tc.wantFiles, files, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("\n%s\nwriteVaultPasswords(...): -want files, +got files:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWriteSSHCredentials(t *testing.T) {
+	errBoom := errors.New("boom")
+	dir := filepath.Join(baseWorkingDir, string(uid))
+	sshDir := filepath.Join(dir, runnerutil.SSHDir)
+	ref := xpv1.SecretKeySelector{
+		SecretReference: xpv1.SecretReference{Name: "ssh", Namespace: "crossplane-system"},
+		Key:             "id_ed25519",
+	}
+	kube := &test.MockClient{MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+		obj.(*v1.Secret).Data = map[string][]byte{"id_ed25519": []byte("KEY")}
+		return nil
+	})}
+
+	cases := map[string]struct {
+		reason    string
+		kube      client.Client
+		creds     *v1alpha1.SSHCredentials
+		want      *ansible.SSHOptions
+		wantFiles map[string]string
+		wantErr   error
+	}{
+		"NoCredentials": {
+			reason: "We should remove the SSH credentials of the previous reconciles",
+		},
+		"Defaults": {
+			reason: "We should write the private key with its final newline, and check the host keys by default",
+			kube:   kube,
+			creds:  &v1alpha1.SSHCredentials{PrivateKeySecretRef: ref},
+			want: &ansible.SSHOptions{
+				PrivateKeyFile: filepath.Join(sshDir, "id"),
+				ConfigFile:     filepath.Join(sshDir, "config"),
+			},
+			wantFiles: map[string]string{
+				"id":     "KEY\n",
+				"config": "Host *\n  StrictHostKeyChecking yes\n",
+			},
+		},
+		"All": {
+			reason: "We should write the known hosts and the ssh configuration of the connections",
+			kube:   kube,
+			creds: &v1alpha1.SSHCredentials{
+				PrivateKeySecretRef:   ref,
+				User:                  "deploy",
+				Port:                  ptr.To[int32](2222),
+				KnownHosts:            "web1 ssh-ed25519 AAAA\n",
+				StrictHostKeyChecking: ptr.To(false),
+			},
+			want: &ansible.SSHOptions{
+				PrivateKeyFile: filepath.Join(sshDir, "id"),
+				User:           "deploy",
+				ConfigFile:     filepath.Join(sshDir, "config"),
+			},
+			wantFiles: map[string]string{
+				"id":          "KEY\n",
+				"known_hosts": "web1 ssh-ed25519 AAAA\n",
+				"config":      "Host *\n  Port 2222\n  UserKnownHostsFile " + filepath.Join(sshDir, "known_hosts") + "\n  StrictHostKeyChecking no\n",
+			},
+		},
+		"GetPrivateKeyError": {
+			reason:  "We should return any error encountered while getting the private key",
+			kube:    &test.MockClient{MockGet: test.NewMockGetFn(errBoom)},
+			creds:   &v1alpha1.SSHCredentials{PrivateKeySecretRef: ref},
+			wantErr: fmt.Errorf("%s: %w", errGetSSHPrivateKey, fmt.Errorf("cannot get credentials secret: %w", errBoom)),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			// a key of a previous reconcile
+			if err := fs.WriteFile(filepath.Join(sshDir, "stale"), []byte("stale"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			c := &connector{kube: tc.kube, fs: fs}
+			got, err := c.writeSSHCredentials(context.Background(), dir, tc.creds)
+			if diff := cmp.Diff(tc.wantErr, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\nwriteSSHCredentials(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nwriteSSHCredentials(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			files := map[string]string{}
+			entries, _ := fs.ReadDir(sshDir)
+			for _, e := range entries {
+				data, _ := fs.ReadFile(filepath.Join(sshDir, e.Name()))
+				files[e.Name()] = string(data)
+			}
+			if diff := cmp.Diff(tc.wantFiles, files, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("\n%s\nwriteSSHCredentials(...): -want files, +got files:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestDeferRun(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(d))
+		return &t
+	}
+
+	backoff := &v1alpha1.Backoff{Duration: metav1.Duration{Duration: time.Minute}, Factor: 2}
+
+	cases := map[string]struct {
+		reason      string
+		minInterval *metav1.Duration
+		lastApply   *metav1.Time
+		backoff     *v1alpha1.Backoff
+		failedRuns  *v1alpha1.FailedRuns
+		want        bool
+		wantNextRun *metav1.Time
+	}{
+		"NoMinInterval": {
+			reason:    "We should not defer runs without a minimum interval between runs",
+			lastApply: at(-time.Second),
+		},
+		"FirstRun": {
+			reason:      "We should not defer the first run",
+			minInterval: &metav1.Duration{Duration: time.Hour},
+		},
+		"WithinInterval": {
+			reason:      "We should defer the runs due within the interval to its end",
+			minInterval: &metav1.Duration{Duration: time.Hour},
+			lastApply:   at(-10 * time.Minute),
+			want:        true,
+			wantNextRun: at(50 * time.Minute),
+		},
+		"AfterInterval": {
+			reason:      "We should not defer the runs due after the interval",
+			minInterval: &metav1.Duration{Duration: time.Hour},
+			lastApply:   at(-2 * time.Hour),
+		},
+		"WithinBackoff": {
+			reason:      "We should defer the retries of the failed runs to the end of their backoff",
+			backoff:     backoff,
+			failedRuns:  &v1alpha1.FailedRuns{Generation: 1, Count: 3, LastFailureTime: *at(-time.Minute)},
+			want:        true,
+			wantNextRun: at(3 * time.Minute),
+		},
+		"BackoffAfterInterval": {
+			reason:      "We should defer the runs to the later of the end of the interval and of the backoff",
+			minInterval: &metav1.Duration{Duration: time.Minute},
+			lastApply:   at(-2 * time.Minute),
+			backoff:     backoff,
+			failedRuns:  &v1alpha1.FailedRuns{Generation: 1, Count: 1, LastFailureTime: *at(-30 * time.Second)},
+			want:        true,
+			wantNextRun: at(30 * time.Second),
+		},
+		"SpecChangedSinceFailures": {
+			reason:     "We should not back off the runs of a spec that changed since the failed runs",
+			backoff:    backoff,
+			failedRuns: &v1alpha1.FailedRuns{Generation: 0, Count: 3, LastFailureTime: *at(-time.Minute)},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Generation: 1}}
+			cr.Spec.ForProvider.MinIntervalBetweenRuns = tc.minInterval
+			cr.Spec.ForProvider.Backoff = tc.backoff
+			cr.Status.AtProvider.LastApplyTime = tc.lastApply
+			cr.Status.AtProvider.FailedRuns = tc.failedRuns
+			// a run deferred by an earlier reconcile
+			cr.Status.AtProvider.NextRunTime = at(time.Minute)
+			got, err := deferRun(cr, now)
+			if err != nil {
+				t.Fatalf("\n%s\ndeferRun(...): unexpected error: %v", tc.reason, err)
+			}
+			if got != tc.want {
+				t.Errorf("\n%s\ndeferRun(...): want %t, got %t", tc.reason, tc.want, got)
+			}
+			if diff := cmp.Diff(tc.wantNextRun, cr.Status.AtProvider.NextRunTime); diff != "" {
+				t.Errorf("\n%s\ndeferRun(...): -want next run, +got next run:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestSetDrift(t *testing.T) {
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := first.Add(time.Hour)
+	drift := func() *v1alpha1.Drift {
+		return &v1alpha1.Drift{
+			Hosts: []string{"web1"},
+			Tasks: []v1alpha1.DriftedTask{{Play: "site", Task: "install", Hosts: []string{"web1"}}},
+		}
+	}
+	withDrift := func(d *v1alpha1.Drift) *v1alpha1.AnsibleRun {
+		cr := &v1alpha1.AnsibleRun{}
+		cr.Status.AtProvider.Drifted = d != nil
+		cr.Status.AtProvider.Drift = d
+		return cr
+	}
+	firstDrift := drift()
+	firstDrift.DetectedTime = metav1.NewTime(first)
+
+	cases := map[string]struct {
+		reason     string
+		cr         *v1alpha1.AnsibleRun
+		drift      *v1alpha1.Drift
+		wantTime   *metav1.Time
+		wantReason xpv1.ConditionReason
+		wantMsg    string
+	}{
+		"Detected": {
+			reason:     "We should record the drift found at now",
+			cr:         withDrift(nil),
+			drift:      drift(),
+			wantTime:   &metav1.Time{Time: now},
+			wantReason: v1alpha1.ReasonDriftDetected,
+			wantMsg:    "1 tasks would change 1 hosts",
+		},
+		"DetectedAgain": {
+			reason:     "We should keep the time the drift was first detected",
+			cr:         withDrift(firstDrift),
+			drift:      drift(),
+			wantTime:   &metav1.Time{Time: first},
+			wantReason: v1alpha1.ReasonDriftDetected,
+			wantMsg:    "1 tasks would change 1 hosts",
+		},
+		"NoDrift": {
+			reason:     "We should clear the drift when the check mode run finds no changes",
+			cr:         withDrift(firstDrift),
+			wantReason: v1alpha1.ReasonNoDrift,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			setDrift(tc.cr, tc.drift, now)
+			if got := tc.cr.Status.AtProvider.Drifted; got != (tc.drift != nil) {
+				t.Errorf("\n%s\nsetDrift(...): want drifted %t, got %t", tc.reason, tc.drift != nil, got)
+			}
+			var gotTime *metav1.Time
+			if d := tc.cr.Status.AtProvider.Drift; d != nil {
+				gotTime = &d.DetectedTime
+			}
+			if diff := cmp.Diff(tc.wantTime, gotTime); diff != "" {
+				t.Errorf("\n%s\nsetDrift(...): -want detected time, +got detected time:\n%s", tc.reason, diff)
+			}
+			c := tc.cr.GetCondition(v1alpha1.TypeDrifted)
+			if c.Reason != tc.wantReason || c.Message != tc.wantMsg {
+				t.Errorf("\n%s\nsetDrift(...): want condition %s %q, got %s %q", tc.reason, tc.wantReason, tc.wantMsg, c.Reason, c.Message)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		backoff v1alpha1.Backoff
+		failed  int32
+		want    time.Duration
+	}{
+		"FirstRetry": {
+			reason:  "We should wait for the duration before the first retry",
+			backoff: v1alpha1.Backoff{Duration: metav1.Duration{Duration: time.Minute}, Factor: 3},
+			failed:  1,
+			want:    time.Minute,
+		},
+		"FollowingRetries": {
+			reason:  "We should multiply the delay by the factor after each failed retry",
+			backoff: v1alpha1.Backoff{Duration: metav1.Duration{Duration: time.Minute}, Factor: 3},
+			failed:  3,
+			want:    9 * time.Minute,
+		},
+		"DefaultFactor": {
+			reason:  "We should double the delay when the factor is unset",
+			backoff: v1alpha1.Backoff{Duration: metav1.Duration{Duration: time.Minute}},
+			failed:  3,
+			want:    4 * time.Minute,
+		},
+		"Capped": {
+			reason:  "We should not exceed the cap of the delay",
+			backoff: v1alpha1.Backoff{Duration: metav1.Duration{Duration: time.Minute}, Factor: 2, Cap: &metav1.Duration{Duration: 5 * time.Minute}},
+			failed:  100,
+			want:    5 * time.Minute,
+		},
+		"Uncapped": {
+			reason:  "We should not overflow the delay of many failed retries",
+			backoff: v1alpha1.Backoff{Duration: metav1.Duration{Duration: time.Minute}, Factor: 2},
+			failed:  100,
+			want:    math.MaxInt64,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := backoffDelay(&tc.backoff, tc.failed); got != tc.want {
+				t.Errorf("\n%s\nbackoffDelay(...): want %s, got %s", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	limit := int32(1)
+	cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Generation: 1}}
+	cr.Spec.ForProvider.RetryLimit = &limit
+
+	// the first run and a single retry
+	for i := 0; i < 2; i++ {
+		if retriesExhausted(cr) {
+			t.Fatalf("retriesExhausted(...): want a run after %d failed runs", i)
+		}
+		recordFailedRun(cr, time.Now())
+	}
+	if !retriesExhausted(cr) {
+		t.Errorf("retriesExhausted(...): want no run after the retry limit")
+	}
+
+	// a new spec starts over
+	cr.SetGeneration(2)
+	if retriesExhausted(cr) {
+		t.Errorf("retriesExhausted(...): want a run of a new spec")
+	}
+	recordFailedRun(cr, time.Now())
+	if diff := cmp.Diff(int32(1), cr.Status.AtProvider.FailedRuns.Count); diff != "" {
+		t.Errorf("recordFailedRun(...): -want count, +got count:\n%s", diff)
+	}
+}
+
+func TestWriteGitCredentials(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	c := &connector{fs: fs}
+	gitCredDir := filepath.Join("/tmp", baseWorkingDir, string(uid))
+	p := &preparation{
+		dir: filepath.Join(baseWorkingDir, string(uid)),
+		pc: &v1alpha1.ProviderConfig{Spec: v1alpha1.ProviderConfigSpec{Credentials: []v1alpha1.ProviderCredentials{
+			{Filename: ".git-credentials", Source: xpv1.CredentialsSourceNone},
+			{Filename: "vault.json", Source: xpv1.CredentialsSourceNone},
+		}}},
+	}
+	if err := c.writeGitCredentials(context.Background(), p); err != nil {
+		t.Fatalf("writeGitCredentials(...): unexpected error: %v", err)
+	}
+
+	// the git config is only passed through the environment of the commands
+	wantEnv := map[string]string{
+		"GIT_CONFIG_GLOBAL": filepath.Join(gitCredDir, ".gitconfig"),
+		"GIT_CRED_DIR":      gitCredDir,
+	}
+	if diff := cmp.Diff(wantEnv, p.gitCredentialsEnv); diff != "" {
+		t.Errorf("writeGitCredentials(...): -want env, +got env:\n%s", diff)
+	}
+	if _, ok := os.LookupEnv("GIT_CRED_DIR"); ok {
+		t.Error("writeGitCredentials(...): want the environment of the provider left untouched")
+	}
+	config, err := fs.ReadFile(filepath.Join(gitCredDir, ".gitconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConfig := "[credential]\n\thelper = store --file=" + filepath.Join(gitCredDir, ".git-credentials") + "\n"
+	if diff := cmp.Diff(wantConfig, string(config)); diff != "" {
+		t.Errorf("writeGitCredentials(...): -want git config, +got git config:\n%s", diff)
+	}
+}
+
+func TestRevokeConnectionDetails(t *testing.T) {
+	cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "database", UID: uid}}
+	cr.SetWriteConnectionSecretToReference(&xpv1.SecretReference{Namespace: "default", Name: "database-connection"})
+	secret := func(controlled bool) *v1.Secret {
+		s := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "database-connection"},
+			Data:       map[string][]byte{"password": []byte("s3cr3t"), "endpoint": []byte("db:5432")},
+		}
+		if controlled {
+			s.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(cr, v1alpha1.AnsibleRunGroupVersionKind)}
+		}
+		return s
+	}
+
+	cases := map[string]struct {
+		reason     string
+		policy     string
+		controlled bool
+		want       map[string][]byte
+	}{
+		"Keep": {
+			reason:     "We should keep the connection details by default",
+			controlled: true,
+			want:       map[string][]byte{"password": []byte("s3cr3t"), "endpoint": []byte("db:5432")},
+		},
+		"Remove": {
+			reason:     "We should empty the connection secret",
+			policy:     connectionDetailsRemove,
+			controlled: true,
+		},
+		"Tombstone": {
+			reason:     "We should replace the connection details by tombstones",
+			policy:     connectionDetailsTombstone,
+			controlled: true,
+			want:       map[string][]byte{"password": []byte(connectionTombstone), "endpoint": []byte(connectionTombstone)},
+		},
+		"NotControlled": {
+			reason: "We should not touch a connection secret the AnsibleRun doesn't control",
+			policy: connectionDetailsRemove,
+			want:   map[string][]byte{"password": []byte("s3cr3t"), "endpoint": []byte("db:5432")},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			kube := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(secret(tc.controlled)).Build()
+			cr := cr.DeepCopy()
+			cr.Spec.ForProvider.ConnectionDetailsOnDelete = tc.policy
+			e := &external{kube: kube}
+			if err := e.revokeConnectionDetails(context.Background(), cr); err != nil {
+				t.Fatalf("\n%s\ne.revokeConnectionDetails(...): unexpected error: %v", tc.reason, err)
+			}
+			got := &v1.Secret{}
+			if err := kube.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "database-connection"}, got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got.Data, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("\n%s\ne.revokeConnectionDetails(...): -want data, +got data:\n%s", tc.reason, diff)
+			}
+		})
+	}
+
+	// a deleted connection secret has nothing left to revoke
+	e := &external{kube: fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()}
+	cr.Spec.ForProvider.ConnectionDetailsOnDelete = connectionDetailsRemove
+	if err := e.revokeConnectionDetails(context.Background(), cr); err != nil {
+		t.Errorf("e.revokeConnectionDetails(...): want no error without connection secret, got %v", err)
+	}
+}
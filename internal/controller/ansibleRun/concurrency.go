@@ -0,0 +1,176 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+const (
+	errQueued = "waiting for the concurrency group"
+
+	// groupWakeupsBuffer bounds the reconciles of the waiting AnsibleRuns
+	// enqueued at once, the ones beyond it wait for their poll interval
+	groupWakeupsBuffer = 1024
+)
+
+var queuedRuns = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "provider_ansible_queued_runs",
+	Help: "AnsibleRuns waiting for the run of another AnsibleRun of their concurrency group to finish.",
+}, []string{"concurrency_group"})
+
+func init() {
+	metrics.Registry.MustRegister(queuedRuns)
+}
+
+// A runGroups schedules the runs of the AnsibleRuns of the same concurrency
+// group one at a time. The runs waiting for their group don't hold a worker
+// of the controller, their AnsibleRuns are reconciled again once the run of
+// their group finished, so that the runs of the other groups still execute
+// in parallel.
+type runGroups struct {
+	// wakeups enqueues the reconciles of the AnsibleRuns waiting for a
+	// group that was released
+	wakeups chan ctrlevent.GenericEvent
+
+	mu sync.Mutex
+	// running is the AnsibleRun running, by group
+	running map[string]string
+	// waiting are the AnsibleRuns waiting, by group
+	waiting map[string]map[string]bool
+}
+
+func newRunGroups() *runGroups {
+	return &runGroups{
+		wakeups: make(chan ctrlevent.GenericEvent, groupWakeupsBuffer),
+		running: map[string]string{},
+		waiting: map[string]map[string]bool{},
+	}
+}
+
+// acquire lets the supplied AnsibleRun run when no other AnsibleRun of its
+// group does, and returns the func releasing its group once it ran.
+// Otherwise the AnsibleRun waits, and acquire returns the name of the
+// AnsibleRun running. A nil runGroups or an AnsibleRun of no group always
+// runs.
+func (g *runGroups) acquire(cr *v1alpha1.AnsibleRun) (release func(), running string) {
+	group, name := cr.Spec.ForProvider.ConcurrencyGroup, cr.GetName()
+	if g == nil || group == "" {
+		return func() {}, ""
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.running[group]; ok {
+		if g.waiting[group] == nil {
+			g.waiting[group] = map[string]bool{}
+		}
+		g.waiting[group][name] = true
+		queuedRuns.WithLabelValues(group).Set(float64(len(g.waiting[group])))
+		return nil, r
+	}
+	g.running[group] = name
+	delete(g.waiting[group], name)
+	queuedRuns.WithLabelValues(group).Set(float64(len(g.waiting[group])))
+	return func() { g.release(group) }, ""
+}
+
+// release lets the next run of the supplied group start, reconciling again
+// all the AnsibleRuns waiting for it. The first of them to run takes the
+// group, the others wait again.
+func (g *runGroups) release(group string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.running, group)
+	names := make([]string, 0, len(g.waiting[group]))
+	for name := range g.waiting[group] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case g.wakeups <- ctrlevent.GenericEvent{Object: &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: name}}}:
+		default:
+		}
+	}
+	// the ones still waiting record themselves again, so that the deleted
+	// AnsibleRuns and the ones that left the group are forgotten
+	delete(g.waiting, group)
+	queuedRuns.WithLabelValues(group).Set(0)
+}
+
+// acquireGroup lets the supplied AnsibleRun run, and returns the func
+// releasing its concurrency group and the lock of its hosts once it ran,
+// unless it waits for the pressure on the provider to relieve, for its group
+// or for its hosts, as recorded in its status. The group is released while
+// waiting for the hosts, so that the other runs of the group don't wait for
+// them too.
+func (c *external) acquireGroup(ctx context.Context, cr *v1alpha1.AnsibleRun) (release func(), ok bool, err error) {
+	if c.pressure.throttle(cr) {
+		return nil, false, nil
+	}
+	releaseGroup, running := c.groups.acquire(cr)
+	queued(cr, running)
+	if running != "" {
+		return nil, false, nil
+	}
+	releaseHosts, ok, err := c.acquireHosts(ctx, cr)
+	if err != nil || !ok {
+		releaseGroup()
+		return nil, false, err
+	}
+	return func() {
+		releaseHosts()
+		releaseGroup()
+	}, true, nil
+}
+
+// queued records in the status of the supplied AnsibleRun whether its run
+// waits for the supplied AnsibleRun of its concurrency group, none when
+// running is empty. Nothing is recorded for the AnsibleRuns that never
+// waited.
+func queued(cr *v1alpha1.AnsibleRun, running string) {
+	switch {
+	case running != "":
+		cr.SetConditions(queuedCondition(v1.ConditionTrue, v1alpha1.ReasonWaitingForGroup,
+			fmt.Sprintf("AnsibleRun %s of the concurrency group %s is running", running, cr.Spec.ForProvider.ConcurrencyGroup)))
+	case cr.GetCondition(v1alpha1.TypeQueued).Status == v1.ConditionTrue:
+		cr.SetConditions(queuedCondition(v1.ConditionFalse, v1alpha1.ReasonDequeued, ""))
+	}
+}
+
+// queuedCondition returns the Queued condition of an AnsibleRun.
+func queuedCondition(status v1.ConditionStatus, reason xpv1.ConditionReason, msg string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypeQueued,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            msg,
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+// inGroup returns an AnsibleRun of the supplied concurrency group.
+func inGroup(name, group string) *v1alpha1.AnsibleRun {
+	return &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{ConcurrencyGroup: group}},
+	}
+}
+
+func TestRunGroups(t *testing.T) {
+	g := newRunGroups()
+
+	releaseA, running := g.acquire(inGroup("a", "web"))
+	if running != "" {
+		t.Fatalf("acquire(a): want a to run, got it waiting for %s", running)
+	}
+	if _, running := g.acquire(inGroup("b", "web")); running != "a" {
+		t.Errorf("acquire(b): want b waiting for a, got %q", running)
+	}
+	// the other groups and the AnsibleRuns of no group still run in parallel
+	if _, running := g.acquire(inGroup("c", "db")); running != "" {
+		t.Errorf("acquire(c): want c of another group to run, got it waiting for %s", running)
+	}
+	if _, running := g.acquire(inGroup("d", "")); running != "" {
+		t.Errorf("acquire(d): want d of no group to run, got it waiting for %s", running)
+	}
+
+	releaseA()
+	select {
+	case e := <-g.wakeups:
+		if e.Object.GetName() != "b" {
+			t.Errorf("release(web): want b reconciled again, got %s", e.Object.GetName())
+		}
+	default:
+		t.Error("release(web): want the waiting AnsibleRuns reconciled again")
+	}
+	releaseB, running := g.acquire(inGroup("b", "web"))
+	if running != "" {
+		t.Fatalf("acquire(b): want b to run once a is done, got it waiting for %s", running)
+	}
+	releaseB()
+	if len(g.wakeups) != 0 {
+		t.Errorf("release(web): want no AnsibleRun reconciled again when none waits, got %d", len(g.wakeups))
+	}
+}
+
+func TestQueued(t *testing.T) {
+	cr := inGroup("b", "web")
+
+	queued(cr, "")
+	if c := cr.GetCondition(v1alpha1.TypeQueued); c.Status != v1.ConditionUnknown {
+		t.Errorf("queued(...): want no condition for a run that never waited, got %s %s", c.Status, c.Reason)
+	}
+	queued(cr, "a")
+	want := queuedCondition(v1.ConditionTrue, v1alpha1.ReasonWaitingForGroup, "AnsibleRun a of the concurrency group web is running")
+	if diff := cmp.Diff(want, cr.GetCondition(v1alpha1.TypeQueued), cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime")); diff != "" {
+		t.Errorf("queued(...): -want, +got:\n%s", diff)
+	}
+	queued(cr, "")
+	want = queuedCondition(v1.ConditionFalse, v1alpha1.ReasonDequeued, "")
+	if diff := cmp.Diff(want, cr.GetCondition(v1alpha1.TypeQueued), cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime")); diff != "" {
+		t.Errorf("queued(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestDeleteQueued(t *testing.T) {
+	g := newRunGroups()
+	release, _ := g.acquire(inGroup("a", "web"))
+	defer release()
+
+	c := &external{groups: g, runner: &MockRunner{MockDestroy: func(context.Context) error {
+		t.Error("Destroy(...): want no run while the concurrency group is running")
+		return nil
+	}}}
+	cr := inGroup("b", "web")
+	err := c.Delete(context.Background(), cr)
+	if diff := cmp.Diff(errors.New(errQueued), err, test.EquateErrors()); diff != "" {
+		t.Errorf("Delete(...): -want error, +got error:\n%s", diff)
+	}
+	if c := cr.GetCondition(v1alpha1.TypeQueued); c.Reason != v1alpha1.ReasonWaitingForGroup {
+		t.Errorf("Delete(...): want the AnsibleRun queued, got %s", c.Reason)
+	}
+}
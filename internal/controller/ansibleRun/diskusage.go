@@ -0,0 +1,272 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+	kresource "k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// artifactsDir is the subdirectory of the working directories in which
+	// ansible-runner writes the artifacts of the runs
+	artifactsDir = "artifacts"
+
+	// diskBudgetInterval is how often the disk budget is enforced
+	diskBudgetInterval = time.Minute
+)
+
+var (
+	workDirBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "provider_ansible_workdir_bytes",
+		Help: "Disk space used by the working directory of an AnsibleRun, and by the artifacts of its runs.",
+	}, []string{"name", "kind"})
+
+	workDirsBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "provider_ansible_workdirs_bytes",
+		Help: "Disk space used by the working directories of all the AnsibleRuns.",
+	})
+
+	prunedArtifacts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "provider_ansible_pruned_artifacts_total",
+		Help: "Artifacts directories of runs pruned to keep the working directories within their disk budget.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(workDirBytes, workDirsBytes, prunedArtifacts)
+}
+
+// dirSize returns the space used by the files under the supplied directory,
+// zero when it doesn't exist.
+func dirSize(fs afero.Afero, dir string) (int64, error) {
+	var size int64
+	err := fs.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// artifactsPath returns the directory holding the artifacts of the runs of
+// the supplied working directory, under the supplied artifacts root, or
+// within the working directory when the root is empty.
+func artifactsPath(root, workDir string) string {
+	if root == "" {
+		return filepath.Join(workDir, artifactsDir)
+	}
+	return filepath.Join(root, filepath.Base(workDir))
+}
+
+// separateArtifactsDir returns the directory holding the artifacts of the
+// runs of the supplied working directory under the supplied artifacts root,
+// none when the root is empty.
+func separateArtifactsDir(root, workDir string) string {
+	if root == "" {
+		return ""
+	}
+	return artifactsPath(root, workDir)
+}
+
+// diskUsage measures the disk usage of the supplied working directory, along
+// with the supplied directory of the artifacts of its runs, wherever it is.
+func diskUsage(fs afero.Afero, dir, artifactsDir string) (*v1alpha1.DiskUsage, error) {
+	total, err := dirSize(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := dirSize(fs, artifactsDir)
+	if err != nil {
+		return nil, err
+	}
+	// the artifacts on another volume count in the working directory too
+	if !strings.HasPrefix(artifactsDir, dir+string(filepath.Separator)) {
+		total += artifacts
+	}
+	return &v1alpha1.DiskUsage{
+		WorkingDir: *kresource.NewQuantity(total, kresource.BinarySI),
+		Artifacts:  *kresource.NewQuantity(artifacts, kresource.BinarySI),
+	}, nil
+}
+
+// runArtifactsDir returns the directory holding the artifacts of the runs of
+// the AnsibleRun, within its working directory unless set otherwise.
+func (c *external) runArtifactsDir() string {
+	if c.artifactsDir != "" {
+		return c.artifactsDir
+	}
+	return artifactsPath("", c.workDir)
+}
+
+// observeDiskUsage reports the disk usage of the working directory of the
+// supplied AnsibleRun in its status and in the metrics.
+func (c *external) observeDiskUsage(cr *v1alpha1.AnsibleRun) error {
+	if c.fs.Fs == nil || c.workDir == "" {
+		return nil
+	}
+	du, err := diskUsage(c.fs, c.workDir, c.runArtifactsDir())
+	if err != nil {
+		return err
+	}
+	cr.Status.AtProvider.DiskUsage = du
+	workDirBytes.WithLabelValues(cr.GetName(), "total").Set(float64(du.WorkingDir.Value()))
+	workDirBytes.WithLabelValues(cr.GetName(), "artifacts").Set(float64(du.Artifacts.Value()))
+	return nil
+}
+
+// forgetDiskUsage removes the metrics of the disk usage of the named AnsibleRun.
+func forgetDiskUsage(name string) {
+	workDirBytes.DeleteLabelValues(name, "total")
+	workDirBytes.DeleteLabelValues(name, "artifacts")
+}
+
+// A diskBudget prunes the oldest artifacts of the runs when the working
+// directories use more disk space than its limit, before the provider pod
+// gets evicted by disk pressure. The artifacts of the last run of each
+// working directory are always kept, as they may belong to a running run.
+type diskBudget struct {
+	fs   afero.Afero
+	root string
+	// artifactsRoot holds the artifacts of the runs, within the working
+	// directories when empty
+	artifactsRoot string
+	limit         int64
+	log           logging.Logger
+}
+
+// Start enforces the budget periodically until ctx is done.
+func (b *diskBudget) Start(ctx context.Context) error {
+	t := time.NewTicker(diskBudgetInterval)
+	defer t.Stop()
+	for {
+		if err := b.enforce(); err != nil {
+			b.log.Info("Cannot enforce the disk budget of the working directories", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// artifact is the artifacts directory of a run.
+type artifact struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// enforce prunes the oldest artifacts until the working directories fit in
+// the budget, or only the artifacts of the last runs are left.
+func (b *diskBudget) enforce() error {
+	total, err := dirSize(b.fs, b.root)
+	if err != nil {
+		return err
+	}
+	if b.artifactsRoot != "" {
+		artifacts, err := dirSize(b.fs, b.artifactsRoot)
+		if err != nil {
+			return err
+		}
+		total += artifacts
+	}
+	workDirsBytes.Set(float64(total))
+	if b.limit <= 0 || total <= b.limit {
+		return nil
+	}
+
+	prunable, err := b.prunableArtifacts()
+	if err != nil {
+		return err
+	}
+	for _, a := range prunable {
+		if total <= b.limit {
+			break
+		}
+		if err := b.fs.RemoveAll(a.path); err != nil {
+			return fmt.Errorf("cannot prune the artifacts %s: %w", a.path, err)
+		}
+		total -= a.size
+		prunedArtifacts.Inc()
+		b.log.Debug("Pruned the artifacts of a run to keep the working directories within their disk budget", "path", a.path)
+	}
+	workDirsBytes.Set(float64(total))
+	return nil
+}
+
+// prunableArtifacts returns the artifacts of the runs, oldest first, leaving
+// out the ones of the last run of each working directory.
+func (b *diskBudget) prunableArtifacts() ([]artifact, error) {
+	workDirs, err := b.fs.ReadDir(b.root)
+	if err != nil {
+		return nil, err
+	}
+	var prunable []artifact
+	for _, wd := range workDirs {
+		if !wd.IsDir() {
+			continue
+		}
+		dir := artifactsPath(b.artifactsRoot, filepath.Join(b.root, wd.Name()))
+		runs, err := b.fs.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var artifacts []artifact
+		for _, run := range runs {
+			if !run.IsDir() {
+				continue
+			}
+			p := filepath.Join(dir, run.Name())
+			size, err := dirSize(b.fs, p)
+			if err != nil {
+				return nil, err
+			}
+			artifacts = append(artifacts, artifact{path: p, modTime: run.ModTime(), size: size})
+		}
+		if len(artifacts) < 2 {
+			continue
+		}
+		sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].modTime.Before(artifacts[j].modTime) })
+		prunable = append(prunable, artifacts[:len(artifacts)-1]...)
+	}
+	sort.Slice(prunable, func(i, j int) bool { return prunable[i].modTime.Before(prunable[j].modTime) })
+	return prunable, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+	kresource "k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+// writeArtifacts writes the artifacts of a run of the supplied size, last
+// modified at the supplied time.
+func writeArtifacts(t *testing.T, fs afero.Afero, workDir, id string, size int, modTime time.Time) {
+	t.Helper()
+	dir := filepath.Join(workDir, artifactsDir, id)
+	if err := fs.WriteFile(filepath.Join(dir, "stdout"), []byte(strings.Repeat("x", size)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chtimes(dir, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	dir := filepath.Join(baseWorkingDir, string(uid))
+	if err := fs.WriteFile(filepath.Join(dir, "project", "playbook.yml"), []byte(strings.Repeat("x", 100)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeArtifacts(t, fs, dir, "run-1", 1000, time.Now())
+
+	cr := &v1alpha1.AnsibleRun{}
+	e := &external{fs: fs, workDir: dir}
+	if err := e.observeDiskUsage(cr); err != nil {
+		t.Fatalf("observeDiskUsage(...): unexpected error: %v", err)
+	}
+	want := &v1alpha1.DiskUsage{
+		WorkingDir: *kresource.NewQuantity(1100, kresource.BinarySI),
+		Artifacts:  *kresource.NewQuantity(1000, kresource.BinarySI),
+	}
+	if diff := cmp.Diff(want, cr.Status.AtProvider.DiskUsage); diff != "" {
+		t.Errorf("observeDiskUsage(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestDiskUsageArtifactsRoot(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	dir := filepath.Join(baseWorkingDir, string(uid))
+	if err := fs.WriteFile(filepath.Join(dir, "project", "playbook.yml"), []byte(strings.Repeat("x", 100)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	artifacts := artifactsPath("/artifacts", dir)
+	if err := fs.WriteFile(filepath.Join(artifacts, "run-1", "stdout"), []byte(strings.Repeat("x", 1000)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &v1alpha1.AnsibleRun{}
+	e := &external{fs: fs, workDir: dir, artifactsDir: artifacts}
+	if err := e.observeDiskUsage(cr); err != nil {
+		t.Fatalf("observeDiskUsage(...): unexpected error: %v", err)
+	}
+	// the artifacts on another volume count in the working directory too
+	want := &v1alpha1.DiskUsage{
+		WorkingDir: *kresource.NewQuantity(1100, kresource.BinarySI),
+		Artifacts:  *kresource.NewQuantity(1000, kresource.BinarySI),
+	}
+	if diff := cmp.Diff(want, cr.Status.AtProvider.DiskUsage); diff != "" {
+		t.Errorf("observeDiskUsage(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestDiskBudget(t *testing.T) {
+	now := time.Now()
+
+	cases := map[string]struct {
+		reason string
+		limit  int64
+		want   []string
+	}{
+		"WithinBudget": {
+			reason: "We should not prune anything while the working directories fit in the budget",
+			limit:  10000,
+			want:   []string{"a/artifacts/1", "a/artifacts/3", "b/artifacts/2", "b/artifacts/4"},
+		},
+		"Disabled": {
+			reason: "We should not prune anything without a budget",
+			want:   []string{"a/artifacts/1", "a/artifacts/3", "b/artifacts/2", "b/artifacts/4"},
+		},
+		"OverBudget": {
+			reason: "We should prune the oldest artifacts until the working directories fit in the budget",
+			limit:  3000,
+			want:   []string{"a/artifacts/3", "b/artifacts/2", "b/artifacts/4"},
+		},
+		"LastRuns": {
+			reason: "We should keep the artifacts of the last run of each working directory",
+			limit:  1,
+			want:   []string{"a/artifacts/3", "b/artifacts/4"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			root := "/ansibleDir"
+			writeArtifacts(t, fs, filepath.Join(root, "a"), "1", 1000, now.Add(-4*time.Hour))
+			writeArtifacts(t, fs, filepath.Join(root, "b"), "2", 1000, now.Add(-3*time.Hour))
+			writeArtifacts(t, fs, filepath.Join(root, "a"), "3", 1000, now.Add(-2*time.Hour))
+			writeArtifacts(t, fs, filepath.Join(root, "b"), "4", 1000, now.Add(-1*time.Hour))
+
+			b := &diskBudget{fs: fs, root: root, limit: tc.limit, log: logging.NewNopLogger()}
+			if err := b.enforce(); err != nil {
+				t.Fatalf("\n%s\nenforce(): unexpected error: %v", tc.reason, err)
+			}
+			var got []string
+			for _, wd := range []string{"a", "b"} {
+				runs, _ := fs.ReadDir(filepath.Join(root, wd, artifactsDir))
+				for _, r := range runs {
+					got = append(got, filepath.Join(wd, artifactsDir, r.Name()))
+				}
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nenforce(): -want artifacts, +got artifacts:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"fmt"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// reasonDriftDetected is the reason of the events about the drifts found by
+// the check mode runs
+const reasonDriftDetected = event.Reason(v1alpha1.ReasonDriftDetected)
+
+var driftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "provider_ansible_drift_detected_total",
+	Help: "Drifts of the hosts of an AnsibleRun found by the check mode runs of the CheckWhenObserve policy, before the run correcting them.",
+}, []string{"name"})
+
+func init() {
+	metrics.Registry.MustRegister(driftDetected)
+}
+
+// recordDrift reports the supplied drift found by a check mode run of the
+// supplied AnsibleRun in an event and in the metrics, once per drift: the
+// drift found again by the check runs until the run correcting it, e.g. a
+// deferred one, is not reported again.
+func (c *external) recordDrift(cr *v1alpha1.AnsibleRun, drift *v1alpha1.Drift) {
+	if drift == nil || cr.Status.AtProvider.Drifted {
+		return
+	}
+	driftDetected.WithLabelValues(cr.GetName()).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(cr, event.Normal(reasonDriftDetected, describeDrift(drift)))
+	}
+}
+
+// describeDrift describes the supplied drift.
+func describeDrift(drift *v1alpha1.Drift) string {
+	if len(drift.Tasks) != 0 {
+		return fmt.Sprintf("%d tasks would change %d hosts", len(drift.Tasks), len(drift.Hosts))
+	}
+	return fmt.Sprintf("%d hosts would be changed", len(drift.Hosts))
+}
+
+// forgetDrift removes the metrics of the drifts of the named AnsibleRun.
+func forgetDrift(name string) {
+	driftDetected.DeleteLabelValues(name)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestRecordDrift(t *testing.T) {
+	rec := &MockRecorder{}
+	e := external{recorder: rec}
+	cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "drifting"}}
+	defer forgetDrift(cr.GetName())
+	drift := &v1alpha1.Drift{
+		Hosts: []string{"web1", "web2"},
+		Tasks: []v1alpha1.DriftedTask{{Play: "site", Task: "install", Hosts: []string{"web1", "web2"}}},
+	}
+
+	e.recordDrift(cr, nil)
+	e.recordDrift(cr, drift)
+	// the drift found again until the run correcting it is not reported again
+	cr.Status.AtProvider.Drifted = true
+	e.recordDrift(cr, drift)
+
+	want := []event.Event{event.Normal(reasonDriftDetected, "1 tasks would change 2 hosts")}
+	if diff := cmp.Diff(want, rec.events); diff != "" {
+		t.Errorf("recordDrift(...): -want events, +got events:\n%s", diff)
+	}
+	if got := testutil.ToFloat64(driftDetected.WithLabelValues(cr.GetName())); got != 1 {
+		t.Errorf("recordDrift(...): want 1 drift detected, got %v", got)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/apenella/go-ansible/pkg/stdoutcallback/results"
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// the backends of the drift storage, besides the status
+	driftStorageConfigMap = "ConfigMap"
+	driftStorageArtifacts = "Artifacts"
+
+	// driftFile is the file holding the drift, in the artifacts of the check
+	// mode run and in the ConfigMap
+	driftFile = "drift.json"
+
+	// reasonDriftStorage is the reason of the warnings about the drift that
+	// cannot be stored as the ProviderConfig configures it
+	reasonDriftStorage = event.Reason("DriftStorage")
+
+	errStoreDrift       = "cannot store the drift, keeping it in the status"
+	errNoDriftNamespace = "the ConfigMap drift storage requires a namespace"
+	errNoLastRun        = "the check mode run has no artifacts"
+)
+
+// storeDrift stores the drift of the supplied results of a check mode run of
+// the supplied AnsibleRun as its drift storage is configured to, and replaces
+// the drift recorded in its status by a reference to it. The drift is kept in
+// the status when it cannot be stored.
+func (c *external) storeDrift(ctx context.Context, cr *v1alpha1.AnsibleRun, res *results.AnsiblePlaybookJSONResults) {
+	last := cr.Status.AtProvider.Drift
+	if last == nil || c.driftStorage == nil {
+		return
+	}
+	drift := ansible.FullDiff(res)
+	if drift == nil {
+		return
+	}
+	drift.DetectedTime = last.DetectedTime
+	var ref *v1alpha1.DriftReference
+	var err error
+	switch c.driftStorage.Backend {
+	case driftStorageConfigMap:
+		ref, err = c.storeDriftConfigMap(ctx, cr, drift)
+	case driftStorageArtifacts:
+		ref, err = c.storeDriftArtifacts(cr, drift)
+	default:
+		return
+	}
+	if err != nil {
+		if c.recorder != nil {
+			c.recorder.Event(cr, event.Warning(reasonDriftStorage, fmt.Errorf("%s: %w", errStoreDrift, err)))
+		}
+		return
+	}
+	ref.Hosts = len(drift.Hosts)
+	ref.Tasks = len(drift.Tasks)
+	cr.Status.AtProvider.Drift = &v1alpha1.Drift{DetectedTime: drift.DetectedTime, Ref: ref}
+}
+
+// storeDriftConfigMap stores the supplied drift of the supplied AnsibleRun in
+// the ConfigMap named after it, which the AnsibleRun owns so that it is
+// garbage collected along with it.
+func (c *external) storeDriftConfigMap(ctx context.Context, cr *v1alpha1.AnsibleRun, drift *v1alpha1.Drift) (*v1alpha1.DriftReference, error) {
+	if c.driftStorage.Namespace == "" {
+		return nil, errors.New(errNoDriftNamespace)
+	}
+	b, err := json.Marshal(drift)
+	if err != nil {
+		return nil, err
+	}
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: driftConfigMapName(cr), Namespace: c.driftStorage.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, c.kube, cm, func() error {
+		cm.Data = map[string]string{driftFile: string(b)}
+		return controllerutil.SetControllerReference(cr, cm, c.kube.Scheme())
+	}); err != nil {
+		return nil, err
+	}
+	return &v1alpha1.DriftReference{Backend: driftStorageConfigMap, Namespace: cm.Namespace, Name: cm.Name}, nil
+}
+
+// storeDriftArtifacts writes the supplied drift of the supplied AnsibleRun
+// into the artifacts of its last run, the check mode run that found it.
+func (c *external) storeDriftArtifacts(cr *v1alpha1.AnsibleRun, drift *v1alpha1.Drift) (*v1alpha1.DriftReference, error) {
+	lastRun := cr.Status.AtProvider.LastRun
+	if lastRun == nil || !lastRun.CheckMode {
+		return nil, errors.New(errNoLastRun)
+	}
+	b, err := json.Marshal(drift)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(lastRun.ID, driftFile)
+	if err := c.fs.WriteFile(filepath.Join(c.runArtifactsDir(), name), b, 0600); err != nil {
+		return nil, err
+	}
+	return &v1alpha1.DriftReference{Backend: driftStorageArtifacts, Name: name}, nil
+}
+
+// clearStoredDrift removes the ConfigMap holding the drift of the supplied
+// AnsibleRun, if any, once the drift is gone. The drift written into the
+// artifacts goes away along with them.
+func (c *external) clearStoredDrift(ctx context.Context, cr *v1alpha1.AnsibleRun) {
+	drift := cr.Status.AtProvider.Drift
+	if drift == nil || drift.Ref == nil || drift.Ref.Backend != driftStorageConfigMap {
+		return
+	}
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: drift.Ref.Name, Namespace: drift.Ref.Namespace}}
+	if err := c.kube.Delete(ctx, cm); err != nil && !kerrors.IsNotFound(err) {
+		log.FromContext(ctx).V(1).Info("removing the stored drift", "configMap", drift.Ref.Name, "err", err)
+	}
+}
+
+// driftConfigMapName returns the name of the ConfigMap holding the drift of
+// the supplied AnsibleRun.
+func driftConfigMapName(cr *v1alpha1.AnsibleRun) string {
+	return cr.GetName() + "-drift"
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apenella/go-ansible/pkg/stdoutcallback/results"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+)
+
+func TestStoreDrift(t *testing.T) {
+	detected := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	res := &results.AnsiblePlaybookJSONResults{
+		Plays: []results.AnsiblePlaybookJSONResultsPlay{{
+			Play: &results.AnsiblePlaybookJSONResultsPlaysPlay{Name: "site"},
+			Tasks: []results.AnsiblePlaybookJSONResultsPlayTask{{
+				Task:  &results.AnsiblePlaybookJSONResultsPlayTaskItem{Name: "install"},
+				Hosts: map[string]*results.AnsiblePlaybookJSONResultsPlayTaskHostsItem{"web1": {Changed: true}, "web2": {Changed: true}},
+			}},
+		}},
+		Stats: map[string]*results.AnsiblePlaybookJSONResultsStats{"web1": {Changed: 1}, "web2": {Changed: 1}},
+	}
+	full := &v1alpha1.Drift{
+		Hosts:        []string{"web1", "web2"},
+		Tasks:        []v1alpha1.DriftedTask{{Play: "site", Task: "install", Hosts: []string{"web1", "web2"}}},
+		DetectedTime: detected,
+	}
+	inline := func() *v1alpha1.Drift {
+		d := ansible.Diff(res)
+		d.DetectedTime = detected
+		return d
+	}
+
+	cases := map[string]struct {
+		reason  string
+		storage *v1alpha1.DriftStorage
+		want    *v1alpha1.Drift
+	}{
+		"Status": {
+			reason: "The drift should be kept in the status without a drift storage",
+			want:   inline(),
+		},
+		"ConfigMap": {
+			reason:  "The drift should be stored in a ConfigMap the status refers to",
+			storage: &v1alpha1.DriftStorage{Backend: driftStorageConfigMap, Namespace: "crossplane-system"},
+			want: &v1alpha1.Drift{DetectedTime: detected, Ref: &v1alpha1.DriftReference{
+				Backend: driftStorageConfigMap, Namespace: "crossplane-system", Name: "web-drift", Hosts: 2, Tasks: 1,
+			}},
+		},
+		"ConfigMapNoNamespace": {
+			reason:  "The drift should be kept in the status when it cannot be stored",
+			storage: &v1alpha1.DriftStorage{Backend: driftStorageConfigMap},
+			want:    inline(),
+		},
+		"Artifacts": {
+			reason:  "The drift should be written into the artifacts of the check mode run",
+			storage: &v1alpha1.DriftStorage{Backend: driftStorageArtifacts},
+			want: &v1alpha1.Drift{DetectedTime: detected, Ref: &v1alpha1.DriftReference{
+				Backend: driftStorageArtifacts, Name: filepath.Join("run", driftFile), Hosts: 2, Tasks: 1,
+			}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+				t.Fatal(err)
+			}
+			kube := fake.NewClientBuilder().WithScheme(s).Build()
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			e := &external{kube: kube, fs: fs, workDir: "/work", driftStorage: tc.storage}
+			cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "web", UID: uid}}
+			cr.Status.AtProvider.LastRun = &v1alpha1.RunSummary{ID: "run", CheckMode: true}
+			setDrift(cr, ansible.Diff(res), detected.Time)
+
+			e.storeDrift(context.Background(), cr, res)
+			if diff := cmp.Diff(tc.want, cr.Status.AtProvider.Drift); diff != "" {
+				t.Fatalf("\n%s\ne.storeDrift(...): -want, +got:\n%s", tc.reason, diff)
+			}
+
+			var stored []byte
+			ref := tc.want.Ref
+			switch {
+			case ref == nil:
+				return
+			case ref.Backend == driftStorageConfigMap:
+				cm := &v1.ConfigMap{}
+				if err := kube.Get(context.Background(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, cm); err != nil {
+					t.Fatal(err)
+				}
+				if !metav1.IsControlledBy(cm, cr) {
+					t.Errorf("\n%s\ne.storeDrift(...): the ConfigMap should be controlled by the AnsibleRun", tc.reason)
+				}
+				stored = []byte(cm.Data[driftFile])
+			case ref.Backend == driftStorageArtifacts:
+				b, err := fs.ReadFile(filepath.Join("/work", artifactsDir, ref.Name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				stored = b
+			}
+			got := &v1alpha1.Drift{}
+			if err := json.Unmarshal(stored, got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(full, got); diff != "" {
+				t.Errorf("\n%s\ne.storeDrift(...): -want stored, +got stored:\n%s", tc.reason, diff)
+			}
+
+			e.clearStoredDrift(context.Background(), cr)
+			if ref.Backend == driftStorageConfigMap {
+				err := kube.Get(context.Background(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &v1.ConfigMap{})
+				if !kerrors.IsNotFound(err) {
+					t.Errorf("\n%s\ne.clearStoredDrift(...): want the ConfigMap removed, got %v", tc.reason, err)
+				}
+			}
+		})
+	}
+}
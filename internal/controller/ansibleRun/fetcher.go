@@ -0,0 +1,422 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/spf13/afero"
+)
+
+const (
+	errChecksumContents = "cannot compute the checksum of the contents of"
+	errReadChecksum     = "cannot read the checksum of the fetched contents of"
+	errWriteChecksum    = "cannot write the checksum of the fetched contents of"
+
+	// fetchedDir is the subdirectory of the working directories holding the
+	// checksums of the contents fetched from each source
+	fetchedDir = ".fetched"
+
+	sourceInline = "inline"
+	sourceGalaxy = "galaxy"
+
+	// collectionsSubdir is the subdirectory of the collections cache of a
+	// ProviderConfig the collections are installed into
+	collectionsSubdir = "collections"
+)
+
+// collectionsCacheMu serializes the installs of the collections caches, which
+// the AnsibleRuns of a ProviderConfig share.
+var collectionsCacheMu sync.Mutex
+
+// A fetcher acquires the contents of an AnsibleRun from one of their sources
+// into its working directory.
+type fetcher interface {
+	// Source names the source of the contents.
+	Source() string
+
+	// Checksum returns a digest of the declaration of the contents. The
+	// contents are only fetched again when it changes, or on each reconcile
+	// when it is empty.
+	Checksum() (string, error)
+
+	// Fetch acquires the contents.
+	Fetch(ctx context.Context) error
+}
+
+// fetchContents fetches the contents of the supplied fetchers into the
+// supplied working directory, skipping the sources whose checksum didn't
+// change since their last successful fetch.
+func fetchContents(ctx context.Context, fs afero.Afero, dir string, fetchers ...fetcher) error {
+	for _, f := range fetchers {
+		sum, err := f.Checksum()
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", errChecksumContents, f.Source(), err)
+		}
+		p := filepath.Join(dir, fetchedDir, f.Source())
+		if sum != "" {
+			last, err := fs.ReadFile(p)
+			if resource.Ignore(os.IsNotExist, err) != nil {
+				return fmt.Errorf("%s %s: %w", errReadChecksum, f.Source(), err)
+			}
+			if string(last) == sum {
+				continue
+			}
+		}
+		// a fetch failing halfway must be attempted again on the next
+		// reconcile, so the checksum of the last fetch goes first
+		if err := fs.Remove(p); resource.Ignore(os.IsNotExist, err) != nil {
+			return fmt.Errorf("%s %s: %w", errWriteChecksum, f.Source(), err)
+		}
+		if err := f.Fetch(ctx); err != nil {
+			return err
+		}
+		if sum == "" {
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(p), 0700); resource.Ignore(os.IsExist, err) != nil {
+			return fmt.Errorf("%s %s: %w", errWriteChecksum, f.Source(), err)
+		}
+		if err := fs.WriteFile(p, []byte(sum), 0600); err != nil {
+			return fmt.Errorf("%s %s: %w", errWriteChecksum, f.Source(), err)
+		}
+	}
+	return nil
+}
+
+// checksum returns the sha256 digest of the JSON encoding of the supplied
+// declaration of contents.
+func checksum(declaration interface{}) (string, error) {
+	b, err := json.Marshal(declaration)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:]), nil
+}
+
+// contentFetchers returns the fetchers of the contents of the supplied
+// AnsibleRun itself, its inline playbooks and its library, written into the
+// supplied project directory.
+func (c *connector) contentFetchers(cr *v1alpha1.AnsibleRun, projectDir string) ([]fetcher, error) {
+	values, err := inlineTemplateValues(cr)
+	if err != nil {
+		return nil, err
+	}
+	f := &inlineFetcher{
+		values:         values,
+		fs:             c.fs,
+		projectDir:     projectDir,
+		deletePlaybook: normalizedInline(cr.Spec.ForProvider.DeletePlaybookInline),
+	}
+	if len(cr.Spec.ForProvider.Roles) == 0 && cr.Spec.ForProvider.PlaybookInline != nil {
+		f.playbook = normalizedInline(cr.Spec.ForProvider.PlaybookInline)
+		f.createPlaybook = normalizedInline(cr.Spec.ForProvider.CreatePlaybookInline)
+	}
+	for _, pb := range cr.Spec.ForProvider.Playbooks {
+		if pb.Inline != nil {
+			f.playbooks = append(f.playbooks, v1alpha1.Playbook{Name: pb.Name, Inline: normalizedInline(pb.Inline)})
+		}
+	}
+	var fetchers []fetcher
+	// the delete playbook may replace the roles too
+	if f.playbook != nil || len(f.playbooks) != 0 || f.deletePlaybook != nil {
+		fetchers = append(fetchers, f)
+	}
+	if l := cr.Spec.ForProvider.Library; l != nil {
+		lf, err := newLibraryFetcher(c.fs, c.librariesDir, projectDir, l.Name, l.Playbook)
+		if err != nil {
+			return nil, err
+		}
+		fetchers = append(fetchers, lf)
+	}
+	return fetchers, nil
+}
+
+// fetchCollectionsCache fetches the collections of the supplied fetcher into
+// the supplied collections cache, one cache at a time. It returns the files
+// of the collections that drifted from the lockfile of the cache since they
+// were installed, and installs them again from scratch when reinstall.
+func fetchCollectionsCache(ctx context.Context, fs afero.Afero, dir string, f fetcher, reinstall bool) ([]string, error) {
+	collectionsCacheMu.Lock()
+	defer collectionsCacheMu.Unlock()
+	if err := fs.MkdirAll(dir, 0700); resource.Ignore(os.IsExist, err) != nil {
+		return nil, fmt.Errorf("%s: %s: %w", dir, errMkdir, err)
+	}
+	drifted, err := driftedFiles(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(drifted) != 0 && reinstall {
+		if err := removeCachedCollections(fs, dir, f); err != nil {
+			return drifted, err
+		}
+	}
+	if err := fetchContents(ctx, fs, dir, &lockingFetcher{fetcher: f, fs: fs, cacheDir: dir}); err != nil {
+		return drifted, err
+	}
+	// the caches installed before their lockfiles were recorded are
+	// trusted as they are
+	if _, err := fs.Stat(filepath.Join(dir, collectionsLockfile)); os.IsNotExist(err) {
+		return drifted, writeLockfile(fs, dir)
+	}
+	return drifted, nil
+}
+
+// collectionsCacheFetcher returns the fetcher of the collections of the
+// ProviderConfig of the supplied preparation into its collections cache, and
+// records the cache in the preparation. It returns nil when the
+// ProviderConfig doesn't cache its collections, or has none.
+func (c *connector) collectionsCacheFetcher(p *preparation, tokenEnv map[string]string) (fetcher, error) {
+	pcReq := p.pc.Spec.Requirements
+	if p.pc.Spec.Galaxy == nil || !p.pc.Spec.Galaxy.CacheCollections || pcReq == nil || len(pcReq.Collections) == 0 {
+		return nil, nil
+	}
+	out, err := requirementsOf(&v1alpha1.Requirements{Collections: pcReq.Collections}, v1alpha1.AnsibleRunParameters{}).marshal()
+	if err != nil {
+		return nil, err
+	}
+	p.collectionsCache = filepath.Join(baseWorkingDir, collectionsCacheDir, p.pc.GetName())
+	opts := p.galaxyOptions()
+	opts.RequirementsDir = p.collectionsCache
+	opts.CollectionsPath = filepath.Join(p.collectionsCache, collectionsSubdir)
+	return &galaxyFetcher{
+		ps:           p.ps,
+		fs:           c.fs,
+		projectDir:   p.collectionsCache,
+		requirements: out,
+		vars:         p.vars,
+		tokenEnv:     tokenEnv,
+		opts:         opts,
+		collections:  true,
+	}, nil
+}
+
+// requirementsFetcher returns the fetcher of the requirements of the supplied
+// AnsibleRun and of its ProviderConfig, or nil when there are none. The
+// collections of the ProviderConfig are left out when they are cached.
+func (c *connector) requirementsFetcher(cr *v1alpha1.AnsibleRun, p *preparation, tokenEnv map[string]string) (fetcher, error) {
+	pcReq := p.pc.Spec.Requirements
+	if p.collectionsCache != "" {
+		pcReq = &v1alpha1.Requirements{Roles: pcReq.Roles}
+	}
+	req := requirementsOf(pcReq, cr.Spec.ForProvider)
+	if len(req.Roles) == 0 && len(req.Collections) == 0 {
+		return nil, nil
+	}
+	out, err := req.marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &galaxyFetcher{
+		ps:           p.ps,
+		fs:           c.fs,
+		projectDir:   p.projectDir(),
+		requirements: out,
+		vars:         p.vars,
+		tokenEnv:     tokenEnv,
+		opts:         p.galaxyOptions(),
+		collections:  len(req.Collections) != 0,
+		roles:        len(req.Roles) != 0,
+		roleVersions: cr.Status.AtProvider.RoleVersions,
+		rolesMoved:   p.rolesMoved,
+	}, nil
+}
+
+// An inlineFetcher writes the inline playbooks of an AnsibleRun into its
+// project directory. It holds the normalized playbooks, so that the ones
+// written with their Windows line endings are written again.
+type inlineFetcher struct {
+	fs             afero.Afero
+	projectDir     string
+	playbook       *string
+	createPlaybook *string
+	deletePlaybook *string
+	// playbooks are the inline playbooks of the playbooks run in order
+	playbooks []v1alpha1.Playbook
+	// values render the playbooks as templates, when set
+	values *templateValues
+}
+
+func (f *inlineFetcher) Source() string {
+	return sourceInline
+}
+
+func (f *inlineFetcher) Checksum() (string, error) {
+	return checksum(struct {
+		Playbook       *string             `json:"playbook,omitempty"`
+		CreatePlaybook *string             `json:"createPlaybook,omitempty"`
+		DeletePlaybook *string             `json:"deletePlaybook,omitempty"`
+		Playbooks      []v1alpha1.Playbook `json:"playbooks,omitempty"`
+		Values         *templateValues     `json:"values,omitempty"`
+	}{f.playbook, f.createPlaybook, f.deletePlaybook, f.playbooks, f.values})
+}
+
+func (f *inlineFetcher) Fetch(_ context.Context) error {
+	playbooks := make(map[string]string, 3)
+	for file, playbook := range map[string]*string{
+		runnerutil.PlaybookYml:       f.playbook,
+		runnerutil.CreatePlaybookYml: f.createPlaybook,
+		runnerutil.DeletePlaybookYml: f.deletePlaybook,
+	} {
+		if playbook == nil {
+			continue
+		}
+		rendered, err := renderInline(file, *playbook, f.values)
+		if err != nil {
+			return err
+		}
+		if err := validatePlaybook(file, rendered); err != nil {
+			return err
+		}
+		playbooks[file] = rendered
+	}
+	inline := make([]string, len(f.playbooks))
+	for i, pb := range f.playbooks {
+		file := ansible.InlinePlaybookPath(pb.Name)
+		rendered, err := renderInline(file, *pb.Inline, f.values)
+		if err != nil {
+			return err
+		}
+		if err := validatePlaybook(file, rendered); err != nil {
+			return err
+		}
+		inline[i] = rendered
+	}
+	if err := f.writePlaybooks(inline); err != nil {
+		return err
+	}
+	if playbook, ok := playbooks[runnerutil.PlaybookYml]; ok {
+		if err := f.fs.WriteFile(filepath.Join(f.projectDir, runnerutil.PlaybookYml), []byte(playbook), 0600); err != nil {
+			return fmt.Errorf("%s: %w", errWriteAnsibleRun, err)
+		}
+	}
+	if playbook, ok := playbooks[runnerutil.CreatePlaybookYml]; ok {
+		if err := f.fs.WriteFile(filepath.Join(f.projectDir, runnerutil.CreatePlaybookYml), []byte(playbook), 0600); err != nil {
+			return fmt.Errorf("%s: %w", errWriteCreateAnsibleRun, err)
+		}
+	}
+	if playbook, ok := playbooks[runnerutil.DeletePlaybookYml]; ok {
+		if err := f.fs.WriteFile(filepath.Join(f.projectDir, runnerutil.DeletePlaybookYml), []byte(playbook), 0600); err != nil {
+			return fmt.Errorf("%s: %w", errWriteDeleteAnsibleRun, err)
+		}
+	}
+	return nil
+}
+
+// writePlaybooks writes the supplied rendered inline playbooks of the
+// playbooks run in order, removing the ones of the previous fetches.
+func (f *inlineFetcher) writePlaybooks(rendered []string) error {
+	dir := filepath.Join(f.projectDir, runnerutil.InlinePlaybooksDir)
+	if err := f.fs.RemoveAll(dir); err != nil {
+		return fmt.Errorf("%s: %w", errWritePlaybooks, err)
+	}
+	if len(rendered) == 0 {
+		return nil
+	}
+	if err := f.fs.MkdirAll(dir, 0700); resource.Ignore(os.IsExist, err) != nil {
+		return fmt.Errorf("%s: %w", errWritePlaybooks, err)
+	}
+	for i, pb := range f.playbooks {
+		if err := f.fs.WriteFile(filepath.Join(f.projectDir, ansible.InlinePlaybookPath(pb.Name)), []byte(rendered[i]), 0600); err != nil {
+			return fmt.Errorf("%s: %w", errWritePlaybooks, err)
+		}
+	}
+	return nil
+}
+
+// A galaxyFetcher installs the roles and collections of an AnsibleRun, and
+// of the requirements of its ProviderConfig, with ansible-galaxy, from Galaxy
+// servers or from git repositories.
+type galaxyFetcher struct {
+	ps           params
+	fs           afero.Afero
+	projectDir   string
+	requirements []byte
+	vars         map[string]string
+	// tokenEnv authenticates to the git repositories of the requirements,
+	// it is left out of the checksum
+	tokenEnv     map[string]string
+	opts         ansible.GalaxyOptions
+	collections  bool
+	roles        bool
+	roleVersions []v1alpha1.ResolvedRoleVersion
+	rolesMoved   bool
+}
+
+func (f *galaxyFetcher) Source() string {
+	return sourceGalaxy
+}
+
+// Checksum covers the commits the floating versions of the roles resolved
+// to, so that the roles are installed again when they move. Requirements
+// forced by the ProviderConfig are installed on each reconcile.
+func (f *galaxyFetcher) Checksum() (string, error) {
+	if f.opts.Force {
+		return "", nil
+	}
+	return checksum(struct {
+		Requirements string                         `json:"requirements"`
+		Vars         map[string]string              `json:"vars,omitempty"`
+		Options      ansible.GalaxyOptions          `json:"options"`
+		Collections  bool                           `json:"collections"`
+		Roles        bool                           `json:"roles"`
+		RoleVersions []v1alpha1.ResolvedRoleVersion `json:"roleVersions,omitempty"`
+	}{string(f.requirements), f.vars, f.opts, f.collections, f.roles, f.roleVersions})
+}
+
+func (f *galaxyFetcher) Fetch(ctx context.Context) error {
+	if err := f.fs.WriteFile(filepath.Join(f.projectDir, galaxyutil.RequirementsFile), f.requirements, 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteConfig, err)
+	}
+	vars := f.vars
+	if len(f.tokenEnv) != 0 {
+		vars = make(map[string]string, len(f.vars)+len(f.tokenEnv))
+		for k, v := range f.vars {
+			vars[k] = v
+		}
+		for k, v := range f.tokenEnv {
+			vars[k] = v
+		}
+	}
+	opts := f.opts
+	if f.collections {
+		if err := f.ps.GalaxyInstall(ctx, vars, "collection", opts); err != nil {
+			return err
+		}
+	}
+	if f.roles {
+		// installed roles are skipped, unless their floating version moved
+		opts.Force = opts.Force || f.rolesMoved
+		if err := f.ps.GalaxyInstall(ctx, vars, "role", opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
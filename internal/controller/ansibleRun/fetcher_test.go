@@ -0,0 +1,256 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+type MockFetcher struct {
+	checksum string
+	err      error
+	fetches  int
+}
+
+func (f *MockFetcher) Source() string {
+	return "mock"
+}
+
+func (f *MockFetcher) Checksum() (string, error) {
+	return f.checksum, nil
+}
+
+func (f *MockFetcher) Fetch(_ context.Context) error {
+	f.fetches++
+	return f.err
+}
+
+func TestFetchContents(t *testing.T) {
+	errBoom := errors.New("boom")
+	dir := filepath.Join(baseWorkingDir, string(uid))
+
+	cases := map[string]struct {
+		reason      string
+		last        string
+		fetcher     *MockFetcher
+		want        error
+		wantFetches int
+		wantLast    string
+	}{
+		"FirstFetch": {
+			reason:      "We should fetch the contents of a source never fetched, and record their checksum",
+			fetcher:     &MockFetcher{checksum: "sha256:1"},
+			wantFetches: 1,
+			wantLast:    "sha256:1",
+		},
+		"Unchanged": {
+			reason:   "We should not fetch the contents of a source whose checksum didn't change",
+			last:     "sha256:1",
+			fetcher:  &MockFetcher{checksum: "sha256:1"},
+			wantLast: "sha256:1",
+		},
+		"Changed": {
+			reason:      "We should fetch the contents of a source whose checksum changed",
+			last:        "sha256:1",
+			fetcher:     &MockFetcher{checksum: "sha256:2"},
+			wantFetches: 1,
+			wantLast:    "sha256:2",
+		},
+		"NotCached": {
+			reason:      "We should fetch the contents of a source without checksum on each reconcile",
+			last:        "sha256:1",
+			fetcher:     &MockFetcher{},
+			wantFetches: 1,
+		},
+		"FetchError": {
+			reason:      "We should fetch the contents of a source again once their fetch failed",
+			last:        "sha256:1",
+			fetcher:     &MockFetcher{checksum: "sha256:2", err: errBoom},
+			want:        errBoom,
+			wantFetches: 1,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			p := filepath.Join(dir, fetchedDir, tc.fetcher.Source())
+			if tc.last != "" {
+				if err := fs.WriteFile(p, []byte(tc.last), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := fetchContents(context.Background(), fs, dir, tc.fetcher)
+			if diff := cmp.Diff(tc.want, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\nfetchContents(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.wantFetches, tc.fetcher.fetches); diff != "" {
+				t.Errorf("\n%s\nfetchContents(...): -want fetches, +got fetches:\n%s", tc.reason, diff)
+			}
+			last, _ := fs.ReadFile(p)
+			if diff := cmp.Diff(tc.wantLast, string(last)); diff != "" {
+				t.Errorf("\n%s\nfetchContents(...): -want checksum, +got checksum:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestInlineFetcher(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	playbook, createPlaybook, deletePlaybook := "apply", "create", "delete"
+	f := &inlineFetcher{fs: fs, projectDir: "/project", playbook: &playbook, createPlaybook: &createPlaybook, deletePlaybook: &deletePlaybook}
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch(...): unexpected error: %v", err)
+	}
+	for file, want := range map[string]string{runnerutil.PlaybookYml: "apply", runnerutil.CreatePlaybookYml: "create", runnerutil.DeletePlaybookYml: "delete"} {
+		got, _ := fs.ReadFile(filepath.Join("/project", file))
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("Fetch(...): -want %s, +got %s:\n%s", file, file, diff)
+		}
+	}
+
+	before, _ := f.Checksum()
+	createPlaybook = "create again"
+	if after, _ := f.Checksum(); after == before {
+		t.Errorf("Checksum(): the checksum should change along with the create playbook")
+	}
+}
+
+func TestInlineFetcherPlaybooks(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	stale := filepath.Join("/project", ansible.InlinePlaybookPath("removed"))
+	_ = fs.MkdirAll(filepath.Dir(stale), 0700)
+	_ = fs.WriteFile(stale, []byte("stale"), 0600)
+	first, second := "first", "second"
+	f := &inlineFetcher{fs: fs, projectDir: "/project", playbooks: []v1alpha1.Playbook{{Name: "first", Inline: &first}, {Name: "second", Inline: &second}}}
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch(...): unexpected error: %v", err)
+	}
+	for name, want := range map[string]string{"first": "first", "second": "second"} {
+		got, _ := fs.ReadFile(filepath.Join("/project", ansible.InlinePlaybookPath(name)))
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("Fetch(...): -want playbook %s, +got playbook %s:\n%s", name, name, diff)
+		}
+	}
+	if ok, _ := fs.Exists(stale); ok {
+		t.Errorf("Fetch(...): the inline playbooks no longer listed should be removed")
+	}
+
+	before, _ := f.Checksum()
+	second = "second again"
+	if after, _ := f.Checksum(); after == before {
+		t.Errorf("Checksum(): the checksum should change along with the playbooks")
+	}
+}
+
+func TestGalaxyFetcher(t *testing.T) {
+	versions := []v1alpha1.ResolvedRoleVersion{{Name: "role", Commit: "c0ffee"}}
+
+	type want struct {
+		checksumChanged bool
+		uncached        bool
+		installs        []string
+		opts            ansible.GalaxyOptions
+	}
+
+	cases := map[string]struct {
+		reason string
+		f      galaxyFetcher
+		want   want
+	}{
+		"Roles": {
+			reason: "We should install the roles with the tokens of their repositories, leaving the tokens out of the checksum",
+			f:      galaxyFetcher{roles: true, tokenEnv: map[string]string{"GIT_TOKEN": "rotated"}},
+			want:   want{installs: []string{"role"}},
+		},
+		"RolesMoved": {
+			reason: "We should force the install of roles whose floating version moved, and change the checksum",
+			f:      galaxyFetcher{roles: true, roleVersions: versions, rolesMoved: true},
+			want:   want{checksumChanged: true, installs: []string{"role"}, opts: ansible.GalaxyOptions{Force: true}},
+		},
+		"Forced": {
+			reason: "We should install the requirements forced by the ProviderConfig on each reconcile",
+			f:      galaxyFetcher{collections: true, roles: true, opts: ansible.GalaxyOptions{Force: true}},
+			want:   want{uncached: true, installs: []string{"collection", "role"}, opts: ansible.GalaxyOptions{Force: true}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			base := galaxyFetcher{roles: true, requirements: []byte("roles: []")}
+			baseSum, err := base.Checksum()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			var installs []string
+			f := tc.f
+			f.fs = fs
+			f.projectDir = "/project"
+			f.requirements = []byte("roles: []")
+			f.ps = MockPs{
+				MockGalaxyInstall: func(_ context.Context, vars map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
+					installs = append(installs, requirementsType)
+					if diff := cmp.Diff(tc.want.opts, opts); diff != "" {
+						t.Errorf("\n%s\nGalaxyInstall(...): -want options, +got options:\n%s", tc.reason, diff)
+					}
+					for k, v := range f.tokenEnv {
+						if vars[k] != v {
+							t.Errorf("\n%s\nGalaxyInstall(...): the token %s should be passed to ansible-galaxy", tc.reason, k)
+						}
+					}
+					return nil
+				},
+			}
+
+			sum, err := f.Checksum()
+			if err != nil {
+				t.Fatalf("\n%s\nChecksum(): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.uncached, sum == ""); diff != "" {
+				t.Errorf("\n%s\nChecksum(): -want uncached, +got uncached:\n%s", tc.reason, diff)
+			}
+			if !tc.want.uncached {
+				if diff := cmp.Diff(tc.want.checksumChanged, sum != baseSum); diff != "" {
+					t.Errorf("\n%s\nChecksum(): -want changed, +got changed:\n%s", tc.reason, diff)
+				}
+			}
+			if err := f.Fetch(context.Background()); err != nil {
+				t.Fatalf("\n%s\nFetch(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.installs, installs); diff != "" {
+				t.Errorf("\n%s\nFetch(...): -want installs, +got installs:\n%s", tc.reason, diff)
+			}
+			if req, _ := fs.ReadFile(filepath.Join("/project", galaxyutil.RequirementsFile)); string(req) != "roles: []" {
+				t.Errorf("\n%s\nFetch(...): the requirements should be written for ansible-galaxy, got %q", tc.reason, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+)
+
+const (
+	errInvalidFilePath    = "the path of the file must stay within the project directory and not replace the files of the provider"
+	errGetFile            = "cannot get the file"
+	errNoFileKey          = "cannot find the key of the file"
+	errWriteFile          = "cannot write the file"
+	errRemoveFile         = "cannot remove the file"
+	errReadFilesManifest  = "cannot read the files written by the last reconcile"
+	errWriteFilesManifest = "cannot record the files written"
+
+	// filesManifest is the file of the working directory listing the files
+	// written into the project directory, so that the ones no longer listed
+	// by the AnsibleRun are removed
+	filesManifest = ".files"
+
+	defaultFileMode os.FileMode = 0600
+)
+
+// reservedProjectPaths are the files and directories of the project
+// directory the provider writes, which the files of an AnsibleRun cannot
+// replace.
+var reservedProjectPaths = map[string]bool{
+	runnerutil.PlaybookYml:            true,
+	runnerutil.CreatePlaybookYml:      true,
+	runnerutil.RolesPlaybookYml:       true,
+	runnerutil.DeletePlaybookYml:      true,
+	runnerutil.DeleteRolesPlaybookYml: true,
+	runnerutil.PlaybooksYml:           true,
+	runnerutil.InlinePlaybooksDir:     true,
+	runnerutil.LibrariesDir:           true,
+	galaxyutil.RequirementsFile:       true,
+	"roles":                           true,
+	"collections":                     true,
+}
+
+// filePath returns the cleaned path of a file of an AnsibleRun, relative to
+// the project directory, or an error when it leaves the project directory
+// or replaces a file the provider writes there.
+func filePath(path string) (string, error) {
+	rel := filepath.Clean(path)
+	top, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	if rel == "." || !filepath.IsLocal(rel) || reservedProjectPaths[top] {
+		return "", fmt.Errorf("%s: %q", errInvalidFilePath, path)
+	}
+	return rel, nil
+}
+
+// writeFiles writes the files of the supplied AnsibleRun into the project
+// directory of the supplied working directory, with their permissions, and
+// removes the ones written by the previous reconciles it no longer lists.
+func (c *connector) writeFiles(ctx context.Context, cr *v1alpha1.AnsibleRun, dir string) error {
+	projectDir := filepath.Join(dir, runnerutil.ProjectDir)
+	written := make(map[string]bool, len(cr.Spec.ForProvider.Files))
+	for _, f := range cr.Spec.ForProvider.Files {
+		rel, err := filePath(f.Path)
+		if err != nil {
+			return err
+		}
+		data, err := c.objectData(ctx, f.Kind, f.Namespace, f.Name)
+		if err != nil {
+			return fmt.Errorf("%s %s from %s %s/%s: %w", errGetFile, f.Path, f.Kind, f.Namespace, f.Name, err)
+		}
+		content, ok := data[f.Key]
+		if !ok {
+			return fmt.Errorf("%s %s from %s %s/%s: %s", errNoFileKey, f.Path, f.Kind, f.Namespace, f.Name, f.Key)
+		}
+		mode := defaultFileMode
+		if f.Mode != nil {
+			mode = os.FileMode(*f.Mode) & os.ModePerm
+		}
+		path := filepath.Join(projectDir, rel)
+		if err := c.fs.MkdirAll(filepath.Dir(path), 0700); resource.Ignore(os.IsExist, err) != nil {
+			return fmt.Errorf("%s %s: %w", errWriteFile, f.Path, err)
+		}
+		if err := c.fs.WriteFile(path, []byte(content), mode); err != nil {
+			return fmt.Errorf("%s %s: %w", errWriteFile, f.Path, err)
+		}
+		// WriteFile only sets the permissions of new files
+		if err := c.fs.Chmod(path, mode); err != nil {
+			return fmt.Errorf("%s %s: %w", errWriteFile, f.Path, err)
+		}
+		written[rel] = true
+	}
+	return c.pruneFiles(dir, written)
+}
+
+// pruneFiles removes the files written into the project directory of the
+// supplied working directory by the previous reconciles that were not
+// written again, and records the written ones.
+func (c *connector) pruneFiles(dir string, written map[string]bool) error {
+	manifest := filepath.Join(dir, filesManifest)
+	var previous []string
+	b, err := c.fs.ReadFile(manifest)
+	if resource.Ignore(os.IsNotExist, err) != nil {
+		return fmt.Errorf("%s: %w", errReadFilesManifest, err)
+	}
+	if len(b) != 0 {
+		if err := json.Unmarshal(b, &previous); err != nil {
+			return fmt.Errorf("%s: %w", errReadFilesManifest, err)
+		}
+	}
+	for _, rel := range previous {
+		if written[rel] {
+			continue
+		}
+		if err := c.fs.Remove(filepath.Join(dir, runnerutil.ProjectDir, rel)); resource.Ignore(os.IsNotExist, err) != nil {
+			return fmt.Errorf("%s %s: %w", errRemoveFile, rel, err)
+		}
+	}
+	if len(written) == 0 {
+		if err := c.fs.Remove(manifest); resource.Ignore(os.IsNotExist, err) != nil {
+			return fmt.Errorf("%s: %w", errWriteFilesManifest, err)
+		}
+		return nil
+	}
+	paths := make([]string, 0, len(written))
+	for rel := range written {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	b, err = json.Marshal(paths)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteFilesManifest, err)
+	}
+	if err := c.fs.WriteFile(manifest, b, 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteFilesManifest, err)
+	}
+	return nil
+}
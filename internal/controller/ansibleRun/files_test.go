@@ -0,0 +1,136 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestFilePath(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		"Nested": {
+			reason: "We should accept the paths within the project directory",
+			path:   "files/../certs/./ca.crt",
+			want:   filepath.Join("certs", "ca.crt"),
+		},
+		"Absolute": {
+			reason:  "We should reject the absolute paths",
+			path:    "/etc/passwd",
+			wantErr: true,
+		},
+		"Parent": {
+			reason:  "We should reject the paths leaving the project directory",
+			path:    "../env/extravars",
+			wantErr: true,
+		},
+		"ProjectDir": {
+			reason:  "We should reject the project directory itself",
+			path:    "files/..",
+			wantErr: true,
+		},
+		"Reserved": {
+			reason:  "We should reject the paths replacing the files of the provider",
+			path:    "roles/nginx/tasks/main.yml",
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := filePath(tc.path)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("\n%s\nfilePath(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("\n%s\nfilePath(...): want %q, got %q", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	kube := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "cluster"}, Data: map[string][]byte{"config": []byte("kubeconfig")}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "scripts"}, Data: map[string]string{"check.sh": "#!/bin/sh\n"}},
+	).Build()
+	c := &connector{kube: kube, fs: fs}
+	dir := "/workdir"
+	project := filepath.Join(dir, "project")
+	cr := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{Files: []v1alpha1.File{
+		{Path: "files/kubeconfig", Kind: kindSecret, Namespace: "ns", Name: "cluster", Key: "config"},
+		{Path: "check.sh", Kind: kindConfigMap, Namespace: "ns", Name: "scripts", Key: "check.sh", Mode: ptr.To[int32](0700)},
+	}}}}
+
+	if err := c.writeFiles(context.Background(), cr, dir); err != nil {
+		t.Fatalf("writeFiles(...): unexpected error: %v", err)
+	}
+	for path, want := range map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"files/kubeconfig": {"kubeconfig", 0600},
+		"check.sh":         {"#!/bin/sh\n", 0700},
+	} {
+		got, err := fs.ReadFile(filepath.Join(project, path))
+		if err != nil {
+			t.Fatalf("writeFiles(...): cannot read %s: %v", path, err)
+		}
+		if string(got) != want.content {
+			t.Errorf("writeFiles(...): want %s %q, got %q", path, want.content, got)
+		}
+		info, err := fs.Stat(filepath.Join(project, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want.mode {
+			t.Errorf("writeFiles(...): want %s mode %v, got %v", path, want.mode, info.Mode().Perm())
+		}
+	}
+
+	// the files no longer listed are removed
+	cr.Spec.ForProvider.Files = cr.Spec.ForProvider.Files[1:]
+	if err := c.writeFiles(context.Background(), cr, dir); err != nil {
+		t.Fatalf("writeFiles(...): unexpected error: %v", err)
+	}
+	if ok, _ := fs.Exists(filepath.Join(project, "files", "kubeconfig")); ok {
+		t.Errorf("writeFiles(...): want the file no longer listed removed")
+	}
+	if ok, _ := fs.Exists(filepath.Join(project, "check.sh")); !ok {
+		t.Errorf("writeFiles(...): want the file still listed kept")
+	}
+
+	cr.Spec.ForProvider.Files = []v1alpha1.File{{Path: "token", Kind: kindSecret, Namespace: "ns", Name: "cluster", Key: "token"}}
+	if err := c.writeFiles(context.Background(), cr, dir); err == nil {
+		t.Errorf("writeFiles(...): want an error on a missing key")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+const (
+	errListHosts = "cannot list the hosts to lock"
+
+	// hostWaitExpiry forgets the AnsibleRuns that stopped waiting for their
+	// hosts without telling, e.g. deleted ones, so that they don't keep the
+	// ones that waited less from running
+	hostWaitExpiry = 10 * time.Minute
+)
+
+// A hostWaiter is an AnsibleRun waiting for the lock of its hosts.
+type hostWaiter struct {
+	hosts []string
+	// since is when it started waiting, the ones waiting longest going
+	// first
+	since time.Time
+	// seen is when it last tried to take the lock
+	seen time.Time
+}
+
+// A hostLocks keeps the runs of different AnsibleRuns from running on the
+// same hosts at the same time. A run takes the lock of all its hosts at once
+// or waits, without holding a worker of the controller, until no other run
+// holds any of them or waited longer for any of them, so that the runs of
+// many hosts are not starved by the runs of a few of them. The waiting
+// AnsibleRuns are reconciled again once a run releases their hosts.
+type hostLocks struct {
+	// wakeups enqueues the reconciles of the AnsibleRuns waiting for hosts
+	// that were released
+	wakeups chan<- ctrlevent.GenericEvent
+
+	mu sync.Mutex
+	// held is the AnsibleRun holding the lock, by host
+	held map[string]string
+	// waiting are the AnsibleRuns waiting, by name
+	waiting map[string]*hostWaiter
+}
+
+func newHostLocks(wakeups chan<- ctrlevent.GenericEvent) *hostLocks {
+	return &hostLocks{
+		wakeups: wakeups,
+		held:    map[string]string{},
+		waiting: map[string]*hostWaiter{},
+	}
+}
+
+// acquire takes the lock of the supplied hosts for the supplied AnsibleRun
+// at now, and returns the func releasing them once it ran. Otherwise the
+// AnsibleRun waits, and acquire returns the AnsibleRun holding or waiting
+// longer for one of its hosts, and that host.
+func (l *hostLocks) acquire(name string, hosts []string, now time.Time) (release func(), blocker, host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for n, w := range l.waiting {
+		if now.Sub(w.seen) > hostWaitExpiry {
+			delete(l.waiting, n)
+		}
+	}
+	since := now
+	if w, ok := l.waiting[name]; ok {
+		since = w.since
+	}
+	blocker, host = l.blocker(name, hosts, since)
+	if blocker != "" {
+		l.waiting[name] = &hostWaiter{hosts: hosts, since: since, seen: now}
+		return nil, blocker, host
+	}
+	delete(l.waiting, name)
+	for _, h := range hosts {
+		l.held[h] = name
+	}
+	return func() { l.release(name, hosts) }, "", ""
+}
+
+// blocker returns the AnsibleRun keeping the supplied one, waiting since the
+// supplied time, from taking the lock of the supplied hosts, and the host
+// they share. It returns none when the AnsibleRun may take the lock.
+func (l *hostLocks) blocker(name string, hosts []string, since time.Time) (string, string) {
+	for _, h := range hosts {
+		if holder, ok := l.held[h]; ok && holder != name {
+			return holder, h
+		}
+	}
+	// the AnsibleRuns that waited longer go first, sorted for determinism
+	names := make([]string, 0, len(l.waiting))
+	for n := range l.waiting {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		w := l.waiting[n]
+		if n == name || !(w.since.Before(since) || w.since.Equal(since) && n < name) {
+			continue
+		}
+		for _, h := range hosts {
+			for _, wh := range w.hosts {
+				if h == wh {
+					return n, h
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// release releases the lock of the supplied hosts held by the supplied
+// AnsibleRun, reconciling again all the AnsibleRuns waiting for them.
+func (l *hostLocks) release(name string, hosts []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	released := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		if l.held[h] == name {
+			delete(l.held, h)
+			released[h] = true
+		}
+	}
+	names := make([]string, 0, len(l.waiting))
+	for n, w := range l.waiting {
+		for _, h := range w.hosts {
+			if released[h] {
+				names = append(names, n)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		select {
+		case l.wakeups <- ctrlevent.GenericEvent{Object: &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: n}}}:
+		default:
+		}
+	}
+}
+
+// acquireHosts lets the supplied AnsibleRun run, and returns the func
+// releasing the lock of its hosts once it ran, unless it waits for them, as
+// recorded in its status. The AnsibleRuns whose ProviderConfig doesn't lock
+// hosts, and the runs without inventory, always run.
+func (c *external) acquireHosts(ctx context.Context, cr *v1alpha1.AnsibleRun) (release func(), ok bool, err error) {
+	if c.hosts == nil {
+		return func() {}, true, nil
+	}
+	hosts, err := c.runner.Hosts(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", errListHosts, err)
+	}
+	if len(hosts) == 0 {
+		return func() {}, true, nil
+	}
+	release, blocker, host := c.hosts.acquire(cr.GetName(), hosts, time.Now())
+	if blocker != "" {
+		cr.SetConditions(queuedCondition(v1.ConditionTrue, v1alpha1.ReasonWaitingForHosts,
+			fmt.Sprintf("AnsibleRun %s runs or waits longer on the host %s", blocker, host)))
+		return nil, false, nil
+	}
+	return release, true, nil
+}
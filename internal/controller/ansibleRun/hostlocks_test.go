@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestHostLocks(t *testing.T) {
+	wakeups := make(chan ctrlevent.GenericEvent, 8)
+	l := newHostLocks(wakeups)
+	now := time.Now()
+
+	releaseA, blocker, _ := l.acquire("a", []string{"web1", "web2"}, now)
+	if blocker != "" {
+		t.Fatalf("acquire(a): want a to run, got it waiting for %s", blocker)
+	}
+	if _, blocker, host := l.acquire("b", []string{"web2", "web3"}, now.Add(time.Second)); blocker != "a" || host != "web2" {
+		t.Errorf("acquire(b): want b waiting for a on web2, got %q on %q", blocker, host)
+	}
+	// the runs on other hosts still run in parallel
+	releaseD, blocker, _ := l.acquire("d", []string{"db1"}, now.Add(2*time.Second))
+	if blocker != "" {
+		t.Errorf("acquire(d): want d on other hosts to run, got it waiting for %s", blocker)
+	}
+	// the run waiting longer goes first, even though its other hosts are free
+	if _, blocker, host := l.acquire("c", []string{"web3"}, now.Add(3*time.Second)); blocker != "b" || host != "web3" {
+		t.Errorf("acquire(c): want c waiting for b on web3, got %q on %q", blocker, host)
+	}
+
+	releaseA()
+	select {
+	case e := <-wakeups:
+		if e.Object.GetName() != "b" {
+			t.Errorf("release(a): want b reconciled again, got %s", e.Object.GetName())
+		}
+	default:
+		t.Error("release(a): want the AnsibleRuns waiting for its hosts reconciled again")
+	}
+	if len(wakeups) != 0 {
+		t.Errorf("release(a): want only the AnsibleRuns waiting for its hosts reconciled again, got %d more", len(wakeups))
+	}
+	releaseB, blocker, _ := l.acquire("b", []string{"web2", "web3"}, now.Add(4*time.Second))
+	if blocker != "" {
+		t.Fatalf("acquire(b): want b to run once a is done, got it waiting for %s", blocker)
+	}
+	releaseB()
+	if e := <-wakeups; e.Object.GetName() != "c" {
+		t.Errorf("release(b): want c reconciled again, got %s", e.Object.GetName())
+	}
+	if _, blocker, _ := l.acquire("c", []string{"web3"}, now.Add(5*time.Second)); blocker != "" {
+		t.Errorf("acquire(c): want c to run once b is done, got it waiting for %s", blocker)
+	}
+	releaseD()
+}
+
+func TestHostLocksExpiry(t *testing.T) {
+	l := newHostLocks(make(chan ctrlevent.GenericEvent, 1))
+	now := time.Now()
+
+	release, _, _ := l.acquire("a", []string{"web1"}, now)
+	if _, blocker, _ := l.acquire("b", []string{"web1", "web2"}, now); blocker != "a" {
+		t.Fatalf("acquire(b): want b waiting for a, got %q", blocker)
+	}
+	release()
+	// b stopped trying to run, e.g. because it was deleted
+	if _, blocker, _ := l.acquire("c", []string{"web2"}, now.Add(hostWaitExpiry+time.Second)); blocker != "" {
+		t.Errorf("acquire(c): want c to run once b stopped waiting, got it waiting for %s", blocker)
+	}
+}
+
+func TestAcquireHosts(t *testing.T) {
+	l := newHostLocks(make(chan ctrlevent.GenericEvent, 1))
+	release, _, _ := l.acquire("a", []string{"web1"}, time.Now())
+	defer release()
+
+	runner := &MockRunner{MockHosts: func(context.Context) ([]string, error) {
+		return []string{"web1"}, nil
+	}}
+	cr := inGroup("b", "")
+	if _, ok, err := (&external{runner: runner}).acquireHosts(context.Background(), cr); !ok || err != nil {
+		t.Errorf("acquireHosts(...): want the runs of ProviderConfigs not locking hosts to run, got %t, %v", ok, err)
+	}
+	_, ok, err := (&external{runner: runner, hosts: l}).acquireHosts(context.Background(), cr)
+	if ok || err != nil {
+		t.Fatalf("acquireHosts(...): want b waiting for a, got %t, %v", ok, err)
+	}
+	want := "AnsibleRun a runs or waits longer on the host web1"
+	if c := cr.GetCondition(v1alpha1.TypeQueued); c.Status != v1.ConditionTrue || c.Reason != v1alpha1.ReasonWaitingForHosts || c.Message != want {
+		t.Errorf("acquireHosts(...): want the AnsibleRun queued with %q, got %s %s %q", want, c.Status, c.Reason, c.Message)
+	}
+}
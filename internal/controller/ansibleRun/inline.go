@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	errInvalidPlaybook  = "invalid inline playbook"
+	errInvalidInventory = "invalid inline inventory"
+
+	// byteOrderMark is the UTF-8 BOM some Windows editors start the files
+	// with
+	byteOrderMark = "\ufeff"
+)
+
+// normalizeInline returns the supplied inline content without the byte order
+// mark and the carriage returns of the files authored on Windows, which
+// Ansible fails to parse.
+func normalizeInline(s string) string {
+	s = strings.TrimPrefix(s, byteOrderMark)
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+// normalizedInline returns a normalized copy of the supplied inline content,
+// or nil without any.
+func normalizedInline(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	n := normalizeInline(*s)
+	return &n
+}
+
+// validatePlaybook returns an error when the supplied inline playbook, named
+// after its file, is not YAML.
+func validatePlaybook(file, playbook string) error {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(playbook), &v); err != nil {
+		return fmt.Errorf("%s %s: %w", errInvalidPlaybook, file, err)
+	}
+	return nil
+}
+
+// validateInventory returns an error when the supplied inline inventory is
+// neither a YAML inventory nor an INI one. Only its syntax is checked,
+// ansible-inventory validates the inventory before the runs.
+func validateInventory(inventory string) error {
+	var v map[string]interface{}
+	err := yaml.Unmarshal([]byte(inventory), &v)
+	if err == nil {
+		return nil
+	}
+	// INI hosts and sections are no YAML mappings, report the YAML error of
+	// the inventories that look like YAML only
+	if first := firstInventoryLine(inventory); strings.HasPrefix(first, "---") || strings.HasSuffix(first, ":") {
+		return fmt.Errorf("%s: %w", errInvalidInventory, err)
+	}
+	if err := validateINIInventory(inventory); err != nil {
+		return fmt.Errorf("%s: %w", errInvalidInventory, err)
+	}
+	return nil
+}
+
+// validateINIInventory returns an error on the malformed section headers and
+// variables of the supplied INI inventory.
+func validateINIInventory(inventory string) error {
+	vars := false
+	for i, line := range strings.Split(inventory, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") || strings.TrimSpace(line[1:len(line)-1]) == "" {
+				return fmt.Errorf("line %d: malformed section header %q", i+1, line)
+			}
+			vars = strings.HasSuffix(line, ":vars]")
+		case vars && !strings.Contains(line, "="):
+			return fmt.Errorf("line %d: want key=value in a vars section, got %q", i+1, line)
+		}
+	}
+	return nil
+}
+
+// firstInventoryLine returns the first line of the supplied inventory that is
+// neither blank nor a comment.
+func firstInventoryLine(inventory string) string {
+	for _, line := range strings.Split(inventory, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ";") {
+			return line
+		}
+	}
+	return ""
+}
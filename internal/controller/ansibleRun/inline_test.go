@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNormalizeInline(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		s      string
+		want   string
+	}{
+		"Unix": {
+			reason: "We should leave the contents authored on Unix as they are",
+			s:      "- hosts: all\n  tasks: []\n",
+			want:   "- hosts: all\n  tasks: []\n",
+		},
+		"Windows": {
+			reason: "We should remove the byte order mark and the carriage returns",
+			s:      "\ufeff- hosts: all\r\n  tasks: []\r\n",
+			want:   "- hosts: all\n  tasks: []\n",
+		},
+		"ClassicMac": {
+			reason: "We should turn the lone carriage returns into line feeds",
+			s:      "[web]\rweb1\r",
+			want:   "[web]\nweb1\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, normalizeInline(tc.s)); diff != "" {
+				t.Errorf("\n%s\nnormalizeInline(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestValidatePlaybook(t *testing.T) {
+	if err := validatePlaybook("playbook.yml", "- hosts: all\n  tasks: []\n"); err != nil {
+		t.Errorf("validatePlaybook(...): unexpected error: %v", err)
+	}
+	if err := validatePlaybook("playbook.yml", "- hosts: all\n\ttasks: []\n"); err == nil {
+		t.Errorf("validatePlaybook(...): want an error on a playbook indented with tabs")
+	}
+}
+
+func TestValidateInventory(t *testing.T) {
+	cases := map[string]struct {
+		reason    string
+		inventory string
+		wantErr   bool
+	}{
+		"INI": {
+			reason:    "We should accept an INI inventory",
+			inventory: "# web servers\n[web]\nweb1 ansible_host=10.0.0.1\n\n[web:vars]\nhttp_port=80\n",
+		},
+		"Hosts": {
+			reason:    "We should accept a list of hosts",
+			inventory: "web1\nweb2\n",
+		},
+		"YAML": {
+			reason:    "We should accept a YAML inventory",
+			inventory: "all:\n  hosts:\n    web1:\n",
+		},
+		"MalformedSection": {
+			reason:    "We should reject an unterminated section header",
+			inventory: "[web\nweb1\n",
+			wantErr:   true,
+		},
+		"MalformedVars": {
+			reason:    "We should reject a variable without a value",
+			inventory: "[web:vars]\nhttp_port\n",
+			wantErr:   true,
+		},
+		"MalformedYAML": {
+			reason:    "We should report the errors of a YAML inventory",
+			inventory: "all:\n  hosts:\n\tweb1:\n",
+			wantErr:   true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := validateInventory(tc.inventory)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("\n%s\nvalidateInventory(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	errInventoryConflict = "inventory sources set different values of the same vars of a host"
+
+	// reasonInventoryConflict is the reason of the warnings about the hosts
+	// whose vars the inventory sources of an AnsibleRun set differently
+	reasonInventoryConflict = event.Reason("InventoryConflict")
+
+	// maxInventoryConflicts bounds the vars warned about at each reconcile
+	maxInventoryConflicts = 10
+)
+
+// An inventorySource is an inventory written into the hosts file of an
+// AnsibleRun, named after its field.
+type inventorySource struct {
+	name      string
+	inventory string
+}
+
+// A hostConflict is a var of a host that different inventory sources set to
+// different values.
+type hostConflict struct {
+	host string
+	// name is the name of the var
+	name string
+	// sources are the sources setting it, in order, the last one winning
+	sources []string
+}
+
+// inventoryConflicts returns the vars of the hosts of the supplied inventory
+// sources that different sources set to different values, sorted by host and
+// var. The vars of the later sources take precedence, as they come later in
+// the hosts file.
+func inventoryConflicts(sources []inventorySource) []hostConflict {
+	// values are the values of each var of each host, by source
+	values := map[string]map[string]map[string]string{}
+	for _, s := range sources {
+		for host, vars := range inventoryHostVars(s.inventory) {
+			if values[host] == nil {
+				values[host] = map[string]map[string]string{}
+			}
+			for k, v := range vars {
+				if values[host][k] == nil {
+					values[host][k] = map[string]string{}
+				}
+				values[host][k][s.name] = v
+			}
+		}
+	}
+	var conflicts []hostConflict
+	for host, vars := range values {
+		for k, set := range vars {
+			distinct := map[string]bool{}
+			for _, v := range set {
+				distinct[v] = true
+			}
+			if len(distinct) < 2 {
+				continue
+			}
+			conflict := hostConflict{host: host, name: k}
+			for _, s := range sources {
+				if _, ok := set[s.name]; ok {
+					conflict.sources = append(conflict.sources, s.name)
+				}
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].host != conflicts[j].host {
+			return conflicts[i].host < conflicts[j].host
+		}
+		return conflicts[i].name < conflicts[j].name
+	})
+	return conflicts
+}
+
+// inventoryHostVars returns the vars the supplied YAML or INI inventory sets
+// on its hosts, by host. The vars of the groups are left out, and so are the
+// hosts of the inventories that cannot be parsed.
+func inventoryHostVars(inventory string) map[string]map[string]string {
+	var groups map[string]interface{}
+	if err := yaml.Unmarshal([]byte(inventory), &groups); err == nil {
+		hosts := map[string]map[string]string{}
+		for _, g := range groups {
+			yamlHostVars(g, hosts)
+		}
+		return hosts
+	}
+	return iniHostVars(inventory)
+}
+
+// yamlHostVars adds the vars of the hosts of the supplied YAML group, and of
+// its children, to the supplied ones.
+func yamlHostVars(group interface{}, hosts map[string]map[string]string) {
+	g, ok := group.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if members, ok := g["hosts"].(map[string]interface{}); ok {
+		for host, vars := range members {
+			if hosts[host] == nil {
+				hosts[host] = map[string]string{}
+			}
+			vars, _ := vars.(map[string]interface{})
+			for k, v := range vars {
+				hosts[host][k] = fmt.Sprint(v)
+			}
+		}
+	}
+	if children, ok := g["children"].(map[string]interface{}); ok {
+		for _, child := range children {
+			yamlHostVars(child, hosts)
+		}
+	}
+}
+
+// iniHostVars returns the vars the supplied INI inventory sets on its hosts,
+// by host.
+func iniHostVars(inventory string) map[string]map[string]string {
+	hosts := map[string]map[string]string{}
+	hostsSection := true
+	for _, line := range strings.Split(inventory, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "["):
+			hostsSection = !strings.HasSuffix(line, ":vars]") && !strings.HasSuffix(line, ":children]")
+		case hostsSection:
+			fields := iniFields(line)
+			host := fields[0]
+			if hosts[host] == nil {
+				hosts[host] = map[string]string{}
+			}
+			for _, f := range fields[1:] {
+				if k, v, ok := strings.Cut(f, "="); ok {
+					hosts[host][k] = v
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// iniFields splits the supplied INI host line on the spaces that are not
+// quoted, unquoting the values of its vars.
+func iniFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && (r == ' ' || r == '\t'):
+			if field.Len() != 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// warnInventoryConflicts records a warning event on the supplied AnsibleRun
+// for each var of a host its supplied inventory sources set differently.
+func (c *connector) warnInventoryConflicts(cr *v1alpha1.AnsibleRun, sources []inventorySource) {
+	if c.recorder == nil || len(sources) < 2 {
+		return
+	}
+	conflicts := inventoryConflicts(sources)
+	for i, conflict := range conflicts {
+		if i == maxInventoryConflicts {
+			c.recorder.Event(cr, event.Warning(reasonInventoryConflict, fmt.Errorf("%s: %d more vars", errInventoryConflict, len(conflicts)-i)))
+			return
+		}
+		c.recorder.Event(cr, event.Warning(reasonInventoryConflict, fmt.Errorf("%s: %s of host %s is set by %s, %s wins", errInventoryConflict,
+			conflict.name, conflict.host, strings.Join(conflict.sources, ", "), conflict.sources[len(conflict.sources)-1])))
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestInventoryConflicts(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		sources []inventorySource
+		want    []hostConflict
+	}{
+		"SameVars": {
+			reason: "We should not report the hosts the sources set the same way",
+			sources: []inventorySource{
+				{name: "inventories[0]", inventory: "[web]\nweb1 ansible_host=10.0.0.1\n"},
+				{name: "inventoryInline", inventory: "[db]\nweb1 ansible_host=10.0.0.1 role=db\n"},
+			},
+		},
+		"INI": {
+			reason: "We should report the vars of a host the INI sources set differently, in the order of the sources",
+			sources: []inventorySource{
+				{name: "inventories[0]", inventory: "web1 ansible_host=10.0.0.1 ansible_user='deploy user'\n[web:vars]\nansible_port=22\n"},
+				{name: "inventories[1]", inventory: "[web]\nweb1 ansible_host=10.0.0.2 ansible_user=\"deploy user\"\n"},
+				{name: "inventoryInline", inventory: "[web]\nweb1 ansible_host=10.0.0.3\n"},
+			},
+			want: []hostConflict{{host: "web1", name: "ansible_host", sources: []string{"inventories[0]", "inventories[1]", "inventoryInline"}}},
+		},
+		"YAML": {
+			reason: "We should report the vars of the hosts of the YAML sources, including the ones of the children groups",
+			sources: []inventorySource{
+				{name: "inventories[0]", inventory: "all:\n  children:\n    web:\n      hosts:\n        web1:\n          ansible_port: 22\n        web2:\n"},
+				{name: "inventoryInline", inventory: "[web]\nweb1 ansible_port=2222\nweb2 ansible_port=22\n"},
+			},
+			want: []hostConflict{{host: "web1", name: "ansible_port", sources: []string{"inventories[0]", "inventoryInline"}}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := inventoryConflicts(tc.sources)
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(hostConflict{})); diff != "" {
+				t.Errorf("\n%s\ninventoryConflicts(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWarnInventoryConflicts(t *testing.T) {
+	rec := &MockRecorder{}
+	c := connector{recorder: rec}
+	c.warnInventoryConflicts(&v1alpha1.AnsibleRun{}, []inventorySource{
+		{name: "inventories[0]", inventory: "web1 ansible_host=10.0.0.1\n"},
+		{name: "inventoryInline", inventory: "web1 ansible_host=10.0.0.2\n"},
+	})
+
+	want := []event.Event{
+		event.Warning(reasonInventoryConflict, errors.New(errInventoryConflict+": ansible_host of host web1 is set by inventories[0], inventoryInline, inventoryInline wins")),
+	}
+	if diff := cmp.Diff(want, rec.events); diff != "" {
+		t.Errorf("warnInventoryConflicts(...): -want events, +got events:\n%s", diff)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	errKubernetesInventorySelector  = "invalid label selector of the kubernetes inventory"
+	errKubernetesInventoryNamespace = "the namespace of the Pods or Machines of the kubernetes inventory must be set"
+	errKubernetesInventoryKind      = "unknown kind of the kubernetes inventory"
+	errListKubernetesInventory      = "cannot list the objects of the kubernetes inventory"
+
+	// ungroupedGroup is the group of the hosts of the kubernetes inventories
+	// without a group
+	ungroupedGroup = "ungrouped"
+)
+
+// machineListGroupVersionKind is the kind of the lists of the Machines of
+// Cluster API, listed without depending on its API.
+var machineListGroupVersionKind = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "MachineList"}
+
+// A kubernetesHost is a host enumerated from a Kubernetes object.
+type kubernetesHost struct {
+	name    string
+	address string
+	labels  map[string]string
+}
+
+// kubernetesInventory renders the hosts of the supplied kubernetes inventory
+// as an INI inventory, so that it can be written into the hosts file along
+// with the other inventories.
+func (c *connector) kubernetesInventory(ctx context.Context, inv v1alpha1.KubernetesInventory) (string, error) {
+	hosts, err := c.listKubernetesHosts(ctx, inv)
+	if err != nil {
+		return "", err
+	}
+	return renderKubernetesInventory(inv, hosts), nil
+}
+
+// listKubernetesHosts returns the hosts of the objects the supplied
+// kubernetes inventory selects, sorted by name.
+func (c *connector) listKubernetesHosts(ctx context.Context, inv v1alpha1.KubernetesInventory) ([]kubernetesHost, error) {
+	sel, err := metav1.LabelSelectorAsSelector(&inv.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errKubernetesInventorySelector, err)
+	}
+	if inv.Kind != v1alpha1.KubernetesInventoryNode && inv.Namespace == "" {
+		return nil, fmt.Errorf("%s: %s", errKubernetesInventoryNamespace, inv.Kind)
+	}
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: sel}}
+	if inv.Namespace != "" {
+		opts = append(opts, client.InNamespace(inv.Namespace))
+	}
+	addressType := inv.AddressType
+	if addressType == "" {
+		addressType = string(v1.NodeInternalIP)
+	}
+	var hosts []kubernetesHost
+	switch inv.Kind {
+	case v1alpha1.KubernetesInventoryNode:
+		l := &v1.NodeList{}
+		if err := c.kube.List(ctx, l, opts...); err != nil {
+			return nil, fmt.Errorf("%s: %w", errListKubernetesInventory, err)
+		}
+		for _, n := range l.Items {
+			h := kubernetesHost{name: n.GetName(), labels: n.GetLabels()}
+			for _, a := range n.Status.Addresses {
+				if string(a.Type) == addressType {
+					h.address = a.Address
+					break
+				}
+			}
+			hosts = append(hosts, h)
+		}
+	case v1alpha1.KubernetesInventoryPod:
+		l := &v1.PodList{}
+		if err := c.kube.List(ctx, l, opts...); err != nil {
+			return nil, fmt.Errorf("%s: %w", errListKubernetesInventory, err)
+		}
+		for _, p := range l.Items {
+			hosts = append(hosts, kubernetesHost{name: p.GetName(), address: p.Status.PodIP, labels: p.GetLabels()})
+		}
+	case v1alpha1.KubernetesInventoryMachine:
+		l := &unstructured.UnstructuredList{}
+		l.SetGroupVersionKind(machineListGroupVersionKind)
+		if err := c.kube.List(ctx, l, opts...); err != nil {
+			return nil, fmt.Errorf("%s: %w", errListKubernetesInventory, err)
+		}
+		for _, m := range l.Items {
+			h := kubernetesHost{name: m.GetName(), labels: m.GetLabels()}
+			addresses, _, _ := unstructured.NestedSlice(m.Object, "status", "addresses")
+			for _, a := range addresses {
+				a, ok := a.(map[string]interface{})
+				if ok && a["type"] == addressType {
+					h.address, _ = a["address"].(string)
+					break
+				}
+			}
+			hosts = append(hosts, h)
+		}
+	default:
+		return nil, fmt.Errorf("%s: %s", errKubernetesInventoryKind, inv.Kind)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].name < hosts[j].name })
+	return hosts, nil
+}
+
+// renderKubernetesInventory renders the supplied hosts of a kubernetes
+// inventory as an INI inventory: the hosts with their ansible_host in the
+// group of the inventory, followed by the groups of their labels.
+func renderKubernetesInventory(inv v1alpha1.KubernetesInventory, hosts []kubernetesHost) string {
+	group := inv.Group
+	if group == "" {
+		group = ungroupedGroup
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\n", group)
+	labelGroups := map[string][]string{}
+	for _, h := range hosts {
+		if h.address != "" {
+			fmt.Fprintf(&b, "%s ansible_host=%s\n", h.name, h.address)
+		} else {
+			fmt.Fprintf(&b, "%s\n", h.name)
+		}
+		for _, label := range inv.GroupByLabels {
+			v, ok := h.labels[label]
+			if !ok {
+				continue
+			}
+			g := labelGroupName(label, v)
+			labelGroups[g] = append(labelGroups[g], h.name)
+		}
+	}
+	names := make([]string, 0, len(labelGroups))
+	for g := range labelGroups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		fmt.Fprintf(&b, "[%s]\n%s\n", g, strings.Join(labelGroups[g], "\n"))
+	}
+	return b.String()
+}
+
+// labelGroupName returns the name of the group of the hosts whose supplied
+// label is set to the supplied value, with the characters invalid in group
+// names replaced by underscores.
+func labelGroupName(label, value string) string {
+	name := label
+	if value != "" {
+		name += "_" + value
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestKubernetesInventory(t *testing.T) {
+	node := func(name, role string, addresses ...v1.NodeAddress) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node-role.kubernetes.io/" + role: "", "topology.kubernetes.io/zone": "eu-west-1a"}},
+			Status:     v1.NodeStatus{Addresses: addresses},
+		}
+	}
+	machine := unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "md-0-abcde", "namespace": "clusters"},
+		"status": map[string]interface{}{"addresses": []interface{}{
+			map[string]interface{}{"type": "ExternalIP", "address": "203.0.113.10"},
+		}},
+	}}
+
+	type want struct {
+		inventory string
+		err       bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		inv    v1alpha1.KubernetesInventory
+		want   want
+	}{
+		"Nodes": {
+			reason: "The Nodes should be added to the group with their internal IP, and to the groups of their labels",
+			inv: v1alpha1.KubernetesInventory{
+				Kind:          v1alpha1.KubernetesInventoryNode,
+				Group:         "nodes",
+				GroupByLabels: []string{"node-role.kubernetes.io/worker", "topology.kubernetes.io/zone"},
+			},
+			want: want{inventory: "[nodes]\n" +
+				"cp-1 ansible_host=10.0.0.1\n" +
+				"worker-1\n" +
+				"worker-2 ansible_host=10.0.0.3\n" +
+				"[node_role_kubernetes_io_worker]\nworker-1\nworker-2\n" +
+				"[topology_kubernetes_io_zone_eu_west_1a]\ncp-1\nworker-1\nworker-2\n"},
+		},
+		"Pods": {
+			reason: "The Pods should be ungrouped without group, with their IP",
+			inv:    v1alpha1.KubernetesInventory{Kind: v1alpha1.KubernetesInventoryPod, Namespace: "default"},
+			want:   want{inventory: "[ungrouped]\nweb-0 ansible_host=10.1.0.5\n"},
+		},
+		"Machines": {
+			reason: "The Machines should be added with their address of the type",
+			inv:    v1alpha1.KubernetesInventory{Kind: v1alpha1.KubernetesInventoryMachine, Namespace: "clusters", AddressType: "ExternalIP", Group: "machines"},
+			want:   want{inventory: "[machines]\nmd-0-abcde ansible_host=203.0.113.10\n"},
+		},
+		"NoNamespace": {
+			reason: "The namespace of the Pods should be required",
+			inv:    v1alpha1.KubernetesInventory{Kind: v1alpha1.KubernetesInventoryPod},
+			want:   want{err: true},
+		},
+		"InvalidSelector": {
+			reason: "An invalid selector should be an error",
+			inv: v1alpha1.KubernetesInventory{Kind: v1alpha1.KubernetesInventoryNode, Selector: metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "role", Operator: "Near"}},
+			}},
+			want: want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &connector{kube: &test.MockClient{MockList: func(_ context.Context, obj client.ObjectList, _ ...client.ListOption) error {
+				switch l := obj.(type) {
+				case *v1.NodeList:
+					l.Items = []v1.Node{
+						node("worker-2", "worker", v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"}),
+						node("cp-1", "control-plane", v1.NodeAddress{Type: v1.NodeHostName, Address: "cp-1"}, v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"}),
+						node("worker-1", "worker"),
+					}
+				case *v1.PodList:
+					l.Items = []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "web-0"}, Status: v1.PodStatus{PodIP: "10.1.0.5"}}}
+				case *unstructured.UnstructuredList:
+					if diff := cmp.Diff(machineListGroupVersionKind, l.GroupVersionKind()); diff != "" {
+						t.Errorf("List(...): -want kind, +got kind:\n%s", diff)
+					}
+					l.Items = []unstructured.Unstructured{machine}
+				}
+				return nil
+			}}}
+			got, err := c.kubernetesInventory(context.Background(), tc.inv)
+			if (err != nil) != tc.want.err {
+				t.Fatalf("\n%s\nkubernetesInventory(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.inventory, got); diff != "" {
+				t.Errorf("\n%s\nkubernetesInventory(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
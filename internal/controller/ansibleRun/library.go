@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/spf13/afero"
+)
+
+const (
+	errNoLibraries     = "the libraries of contents are disabled, the --libraries-dir flag of the provider is empty"
+	errLibraryNotFound = "cannot find the library"
+	errLibraryPlaybook = "cannot find the playbook in the library"
+	errCopyLibrary     = "cannot copy the library"
+
+	sourceLibrary = "library"
+)
+
+// A libraryFetcher copies a library of contents, shipped by a Configuration
+// package and mounted into the provider pod, into the project directory of
+// an AnsibleRun.
+type libraryFetcher struct {
+	fs afero.Afero
+	// dir is the directory of the library, under the libraries directory
+	// of the provider
+	dir string
+	// dest is the directory of the library in the project directory
+	dest     string
+	playbook string
+}
+
+// newLibraryFetcher returns the fetcher of the supplied library, found under
+// the supplied libraries directory, into the supplied project directory.
+func newLibraryFetcher(fs afero.Afero, librariesDir, projectDir, name, playbook string) (*libraryFetcher, error) {
+	if librariesDir == "" {
+		return nil, errors.New(errNoLibraries)
+	}
+	// the name is validated by the CRD, the playbook must stay within the
+	// library
+	if !filepath.IsLocal(name) || !filepath.IsLocal(playbook) {
+		return nil, fmt.Errorf("%s %s: %s", errLibraryPlaybook, name, playbook)
+	}
+	return &libraryFetcher{
+		fs:       fs,
+		dir:      filepath.Join(librariesDir, name),
+		dest:     filepath.Join(projectDir, runnerutil.LibrariesDir, name),
+		playbook: playbook,
+	}, nil
+}
+
+func (f *libraryFetcher) Source() string {
+	return sourceLibrary
+}
+
+// Checksum covers the files of the library, so that it is copied again when
+// its package is upgraded.
+func (f *libraryFetcher) Checksum() (string, error) {
+	files, err := installedFiles(f.fs, f.dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("%s %s", errLibraryNotFound, f.dir)
+	}
+	if _, ok := files[f.playbook]; !ok {
+		return "", fmt.Errorf("%s %s: %s", errLibraryPlaybook, f.dir, f.playbook)
+	}
+	digests := make(map[string]string, len(files))
+	for rel := range files {
+		d, err := fileDigest(f.fs, filepath.Join(f.dir, rel))
+		if err != nil {
+			return "", err
+		}
+		digests[rel] = d
+	}
+	return checksum(struct {
+		Files    map[string]string `json:"files"`
+		Playbook string            `json:"playbook"`
+	}{digests, f.playbook})
+}
+
+// Fetch copies the regular files of the library, replacing its previous
+// copy so that the files removed from the library go away too.
+func (f *libraryFetcher) Fetch(_ context.Context) error {
+	files, err := installedFiles(f.fs, f.dir)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", errCopyLibrary, f.dir, err)
+	}
+	if err := f.fs.RemoveAll(f.dest); err != nil {
+		return fmt.Errorf("%s %s: %w", errCopyLibrary, f.dir, err)
+	}
+	for rel, info := range files {
+		if err := f.copyFile(rel, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("%s %s: %w", errCopyLibrary, f.dir, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file of the library at the supplied relative path,
+// keeping its permissions.
+func (f *libraryFetcher) copyFile(rel string, perm os.FileMode) error {
+	dest := filepath.Join(f.dest, rel)
+	if err := f.fs.MkdirAll(filepath.Dir(dest), 0700); resource.Ignore(os.IsExist, err) != nil {
+		return err
+	}
+	src, err := f.fs.Open(filepath.Join(f.dir, rel))
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint:errcheck
+	dst, err := f.fs.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
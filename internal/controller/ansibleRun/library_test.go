@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+)
+
+func TestLibraryFetcher(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	for file, content := range map[string]string{
+		"/libraries/web/site.yml":                         "- hosts: all\n  roles: [nginx]\n",
+		"/libraries/web/roles/nginx/tasks/main.yml":       "- debug: {}\n",
+		"/libraries/db/site.yml":                          "- hosts: db\n",
+		"/project/libraries/web/roles/removed/tasks/main": "stale",
+	} {
+		if err := fs.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := newLibraryFetcher(fs, "/libraries", "/project", "web", "site.yml")
+	if err != nil {
+		t.Fatalf("newLibraryFetcher(...): unexpected error: %v", err)
+	}
+	before, err := f.Checksum()
+	if err != nil {
+		t.Fatalf("Checksum(): unexpected error: %v", err)
+	}
+	if err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch(...): unexpected error: %v", err)
+	}
+	got, err := installedFiles(fs, "/project/libraries/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for rel := range got {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	want := []string{filepath.Join("roles", "nginx", "tasks", "main.yml"), "site.yml"}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Fetch(...): -want files, +got files:\n%s", diff)
+	}
+
+	if err := fs.WriteFile("/libraries/web/site.yml", []byte("- hosts: web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := f.Checksum(); after == before {
+		t.Errorf("Checksum(): the checksum should change along with the files of the library")
+	}
+
+	if f, _ := newLibraryFetcher(fs, "/libraries", "/project", "web", "missing.yml"); f != nil {
+		if _, err := f.Checksum(); err == nil {
+			t.Errorf("Checksum(): want an error on a playbook missing from the library")
+		}
+	}
+	if _, err := newLibraryFetcher(fs, "/libraries", "/project", "web", "../db/site.yml"); err == nil {
+		t.Errorf("newLibraryFetcher(...): want an error on a playbook out of the library")
+	}
+	if _, err := newLibraryFetcher(fs, "", "/project", "web", "site.yml"); err == nil {
+		t.Errorf("newLibraryFetcher(...): want an error without a libraries directory")
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/statusutil"
+)
+
+const (
+	errReadLockfile  = "cannot read the lockfile of the collections cache"
+	errWriteLockfile = "cannot write the lockfile of the collections cache"
+	errVerifyCache   = "cannot verify the collections cache against its lockfile"
+
+	// collectionsLockfile records the files installed into a collections
+	// cache, next to its collections subdirectory
+	collectionsLockfile = "collections.lock"
+
+	// maxDriftedFiles bounds the drifted files named by the DependencyDrift
+	// condition
+	maxDriftedFiles = 10
+)
+
+var (
+	dependencyDriftFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "provider_ansible_dependency_drift_files",
+		Help: "Files of the collections cache of a ProviderConfig that no longer match its lockfile at the last verification.",
+	}, []string{"provider_config"})
+
+	dependencyReinstalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "provider_ansible_dependency_reinstalls_total",
+		Help: "Installs of the collections cache of a ProviderConfig again after it drifted from its lockfile.",
+	}, []string{"provider_config"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(dependencyDriftFiles, dependencyReinstalls)
+}
+
+// A lockedFile is a file installed into a collections cache. Its digest is
+// only computed again when its size or modification time changed.
+type lockedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// fileDigest returns the sha256 digest of the content of the supplied file.
+func fileDigest(fs afero.Afero, path string) (string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// installedFiles returns the regular files under the supplied directory, by
+// path relative to it, none when it doesn't exist.
+func installedFiles(fs afero.Afero, dir string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	err := fs.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = info
+		return nil
+	})
+	return files, err
+}
+
+// writeLockfile records the files of the collections of the supplied
+// collections cache into its lockfile.
+func writeLockfile(fs afero.Afero, cacheDir string) error {
+	dir := filepath.Join(cacheDir, collectionsSubdir)
+	files, err := installedFiles(fs, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteLockfile, err)
+	}
+	lock := make(map[string]lockedFile, len(files))
+	for rel, info := range files {
+		sum, err := fileDigest(fs, filepath.Join(dir, rel))
+		if err != nil {
+			return fmt.Errorf("%s: %w", errWriteLockfile, err)
+		}
+		lock[rel] = lockedFile{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+	}
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteLockfile, err)
+	}
+	if err := fs.WriteFile(filepath.Join(cacheDir, collectionsLockfile), b, 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteLockfile, err)
+	}
+	return nil
+}
+
+// driftedFiles returns the files of the collections of the supplied
+// collections cache that were modified, added or removed since its lockfile
+// was written, sorted. It returns nil when there is no lockfile yet.
+func driftedFiles(fs afero.Afero, cacheDir string) ([]string, error) {
+	b, err := fs.ReadFile(filepath.Join(cacheDir, collectionsLockfile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errReadLockfile, err)
+	}
+	lock := map[string]lockedFile{}
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", errReadLockfile, err)
+	}
+	dir := filepath.Join(cacheDir, collectionsSubdir)
+	files, err := installedFiles(fs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errVerifyCache, err)
+	}
+	var drifted []string
+	for rel, info := range files {
+		l, ok := lock[rel]
+		switch {
+		case !ok:
+			drifted = append(drifted, rel)
+		case l.Size == info.Size() && l.ModTime.Equal(info.ModTime()):
+		default:
+			// touched files whose content is unchanged didn't drift
+			sum, err := fileDigest(fs, filepath.Join(dir, rel))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", errVerifyCache, err)
+			}
+			if sum != l.SHA256 {
+				drifted = append(drifted, rel)
+			}
+		}
+	}
+	for rel := range lock {
+		if _, ok := files[rel]; !ok {
+			drifted = append(drifted, rel)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted, nil
+}
+
+// A lockingFetcher records the lockfile of the collections it fetches into a
+// collections cache.
+type lockingFetcher struct {
+	fetcher
+	fs       afero.Afero
+	cacheDir string
+}
+
+func (f *lockingFetcher) Fetch(ctx context.Context) error {
+	if err := f.fetcher.Fetch(ctx); err != nil {
+		return err
+	}
+	return writeLockfile(f.fs, f.cacheDir)
+}
+
+// setDependencyDrift records the supplied files of the collections cache of
+// the supplied ProviderConfig found drifted from its lockfile in the status
+// of the supplied AnsibleRun and in the metrics, telling whether they were
+// installed again.
+func setDependencyDrift(cr *v1alpha1.AnsibleRun, pcName string, drifted []string, reinstalled bool) {
+	switch {
+	case len(drifted) == 0:
+		dependencyDriftFiles.WithLabelValues(pcName).Set(0)
+		cr.SetConditions(dependencyDriftCondition(v1.ConditionFalse, v1alpha1.ReasonNoDependencyDrift, ""))
+	case reinstalled:
+		dependencyDriftFiles.WithLabelValues(pcName).Set(0)
+		dependencyReinstalls.WithLabelValues(pcName).Inc()
+		cr.SetConditions(dependencyDriftCondition(v1.ConditionFalse, v1alpha1.ReasonDependencyReinstalled,
+			fmt.Sprintf("collections installed again, %s", driftMessage(drifted))))
+	default:
+		dependencyDriftFiles.WithLabelValues(pcName).Set(float64(len(drifted)))
+		cr.SetConditions(dependencyDriftCondition(v1.ConditionTrue, v1alpha1.ReasonDependencyDrifted, driftMessage(drifted)))
+	}
+}
+
+// driftMessage names the first drifted files.
+func driftMessage(drifted []string) string {
+	return fmt.Sprintf("%d files drifted from the lockfile of the collections cache: %s", len(drifted), fileNames(drifted))
+}
+
+// fileNames lists the first of the supplied files.
+func fileNames(files []string) string {
+	names := files
+	if len(names) > maxDriftedFiles {
+		names = names[:maxDriftedFiles]
+	}
+	list := strings.Join(names, ", ")
+	if len(files) > len(names) {
+		list += ", ..."
+	}
+	return list
+}
+
+// dependencyDriftCondition returns the DependencyDrift condition of an
+// AnsibleRun.
+func dependencyDriftCondition(status v1.ConditionStatus, reason xpv1.ConditionReason, msg string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypeDependencyDrift,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            statusutil.Message(msg, statusutil.MaxMessageBytes),
+	}
+}
+
+// removeCachedCollections removes the collections of the supplied collections
+// cache along with the checksum of their last fetch, so that the supplied
+// fetcher installs them again from scratch, ansible-galaxy skipping the
+// collections already installed.
+func removeCachedCollections(fs afero.Afero, cacheDir string, f fetcher) error {
+	if err := fs.RemoveAll(filepath.Join(cacheDir, collectionsSubdir)); err != nil {
+		return fmt.Errorf("%s: %w", errVerifyCache, err)
+	}
+	if err := fs.Remove(filepath.Join(cacheDir, fetchedDir, f.Source())); resource.Ignore(os.IsNotExist, err) != nil {
+		return fmt.Errorf("%s: %w", errVerifyCache, err)
+	}
+	return nil
+}
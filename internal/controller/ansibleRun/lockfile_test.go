@@ -0,0 +1,181 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestDriftedFiles(t *testing.T) {
+	cache := filepath.Join(baseWorkingDir, collectionsCacheDir, "default")
+	dir := filepath.Join(cache, collectionsSubdir, "ansible_collections", "community", "general")
+	later := time.Now().Add(time.Hour)
+
+	cases := map[string]struct {
+		reason string
+		mutate func(t *testing.T, fs afero.Afero)
+		want   []string
+	}{
+		"Unchanged": {
+			reason: "Collections matching the lockfile should not drift",
+			mutate: func(_ *testing.T, _ afero.Afero) {},
+		},
+		"Touched": {
+			reason: "Touched files whose content is unchanged should not drift",
+			mutate: func(t *testing.T, fs afero.Afero) {
+				if err := fs.Chtimes(filepath.Join(dir, "MANIFEST.json"), later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"Mutated": {
+			reason: "Modified, added and removed files should drift",
+			mutate: func(t *testing.T, fs afero.Afero) {
+				if err := fs.WriteFile(filepath.Join(dir, "MANIFEST.json"), []byte("{\"patched\": true}"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := fs.WriteFile(filepath.Join(dir, "plugins", "extra.py"), []byte("x"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := fs.Remove(filepath.Join(dir, "plugins", "module.py")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{
+				"ansible_collections/community/general/MANIFEST.json",
+				"ansible_collections/community/general/plugins/extra.py",
+				"ansible_collections/community/general/plugins/module.py",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			for f, content := range map[string]string{"MANIFEST.json": "{}", "plugins/module.py": "print()"} {
+				if err := fs.WriteFile(filepath.Join(dir, f), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeLockfile(fs, cache); err != nil {
+				t.Fatalf("writeLockfile(...): unexpected error: %v", err)
+			}
+			tc.mutate(t, fs)
+			got, err := driftedFiles(fs, cache)
+			if err != nil {
+				t.Fatalf("\n%s\ndriftedFiles(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\ndriftedFiles(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestFetchCollectionsCacheDrift(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	cache := filepath.Join(baseWorkingDir, collectionsCacheDir, "default")
+	file := filepath.Join(cache, collectionsSubdir, "ansible_collections", "community", "general", "MANIFEST.json")
+	if err := fs.WriteFile(file, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := &MockFetcher{checksum: "sha256:collections"}
+
+	// the lockfile is recorded by the first install
+	drifted, err := fetchCollectionsCache(context.Background(), fs, cache, f, false)
+	if err != nil || len(drifted) != 0 || f.fetches != 1 {
+		t.Fatalf("fetchCollectionsCache(...): want a first install without drift, got drifted %v, %d fetches, error %v", drifted, f.fetches, err)
+	}
+
+	// a mutated cache is only reported without reinstall
+	if err := fs.WriteFile(file, []byte("{\"patched\": true}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	drifted, err = fetchCollectionsCache(context.Background(), fs, cache, f, false)
+	if err != nil {
+		t.Fatalf("fetchCollectionsCache(...): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"ansible_collections/community/general/MANIFEST.json"}, drifted); diff != "" {
+		t.Errorf("fetchCollectionsCache(...): -want drifted, +got drifted:\n%s", diff)
+	}
+	if f.fetches != 1 {
+		t.Errorf("fetchCollectionsCache(...): want the drifted cache kept without reinstall, got %d fetches", f.fetches)
+	}
+
+	// and installed again from scratch with reinstall
+	if _, err := fetchCollectionsCache(context.Background(), fs, cache, f, true); err != nil {
+		t.Fatalf("fetchCollectionsCache(...): unexpected error: %v", err)
+	}
+	if f.fetches != 2 {
+		t.Errorf("fetchCollectionsCache(...): want the drifted cache installed again, got %d fetches", f.fetches)
+	}
+	if exists, _ := fs.Exists(file); exists {
+		t.Error("fetchCollectionsCache(...): want the drifted collections removed before their install")
+	}
+	drifted, err = fetchCollectionsCache(context.Background(), fs, cache, f, false)
+	if err != nil || len(drifted) != 0 {
+		t.Errorf("fetchCollectionsCache(...): want no drift once installed again, got drifted %v, error %v", drifted, err)
+	}
+}
+
+func TestSetDependencyDrift(t *testing.T) {
+	cases := map[string]struct {
+		reason      string
+		drifted     []string
+		reinstalled bool
+		want        v1.ConditionStatus
+		wantReason  string
+	}{
+		"NoDrift": {
+			reason:     "A cache matching its lockfile should not be drifted",
+			want:       v1.ConditionFalse,
+			wantReason: string(v1alpha1.ReasonNoDependencyDrift),
+		},
+		"Drifted": {
+			reason:     "A drifted cache kept as is should be drifted",
+			drifted:    []string{"MANIFEST.json"},
+			want:       v1.ConditionTrue,
+			wantReason: string(v1alpha1.ReasonDependencyDrifted),
+		},
+		"Reinstalled": {
+			reason:      "A drifted cache installed again should not be drifted anymore",
+			drifted:     []string{"MANIFEST.json"},
+			reinstalled: true,
+			want:        v1.ConditionFalse,
+			wantReason:  string(v1alpha1.ReasonDependencyReinstalled),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{}
+			setDependencyDrift(cr, "default", tc.drifted, tc.reinstalled)
+			c := cr.GetCondition(v1alpha1.TypeDependencyDrift)
+			if c.Status != tc.want || string(c.Reason) != tc.wantReason {
+				t.Errorf("\n%s\nsetDependencyDrift(...): want %s %s, got %s %s", tc.reason, tc.want, tc.wantReason, c.Status, c.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	kresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	errThrottled    = "waiting for the pressure on the provider to relieve"
+	errCgroupMemory = "cannot find the memory usage of the cgroup of the provider"
+
+	// pressureInterval is how often the free disk space and the memory
+	// used are measured
+	pressureInterval = 10 * time.Second
+)
+
+// cgroupMemory are the files holding the memory used by the cgroup of the
+// provider container, for cgroup v2 then v1, and the stat of its inactive
+// page cache, which the kernel reclaims before running out of memory.
+var cgroupMemory = []struct {
+	usage, stat, inactive string
+}{
+	{usage: "/sys/fs/cgroup/memory.current", stat: "/sys/fs/cgroup/memory.stat", inactive: "inactive_file"},
+	{usage: "/sys/fs/cgroup/memory/memory.usage_in_bytes", stat: "/sys/fs/cgroup/memory/memory.stat", inactive: "total_inactive_file"},
+}
+
+var throttledRuns = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "provider_ansible_throttled_runs",
+	Help: "AnsibleRuns waiting for the disk or memory pressure on the provider to relieve.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(throttledRuns)
+}
+
+// A pressureMonitor keeps the runs from starting while the volume of the
+// working directories is short of free space, or the provider container
+// uses too much memory, so that the runs don't fail one after the other, or
+// get the provider pod evicted. The throttled runs don't hold a worker of
+// the controller, their AnsibleRuns are reconciled again once the pressure
+// relieves.
+type pressureMonitor struct {
+	minFreeDisk int64
+	maxMemory   int64
+	freeDisk    func() (int64, error)
+	usedMemory  func() (int64, error)
+	// wakeups enqueues the reconciles of the throttled AnsibleRuns
+	wakeups chan ctrlevent.GenericEvent
+	log     logging.Logger
+
+	mu sync.Mutex
+	// reason and message tell why the runs are throttled, none when the
+	// reason is empty
+	reason  xpv1.ConditionReason
+	message string
+	// throttled are the AnsibleRuns throttled, by name
+	throttled map[string]bool
+}
+
+func newPressureMonitor(dir string, minFreeDisk, maxMemory int64, wakeups chan ctrlevent.GenericEvent, log logging.Logger) *pressureMonitor {
+	fs := afero.Afero{Fs: afero.NewOsFs()}
+	return &pressureMonitor{
+		minFreeDisk: minFreeDisk,
+		maxMemory:   maxMemory,
+		freeDisk:    func() (int64, error) { return freeDiskSpace(dir) },
+		usedMemory:  func() (int64, error) { return workingSetMemory(fs) },
+		wakeups:     wakeups,
+		log:         log,
+		throttled:   map[string]bool{},
+	}
+}
+
+// Start measures the pressure periodically until ctx is done.
+func (m *pressureMonitor) Start(ctx context.Context) error {
+	t := time.NewTicker(pressureInterval)
+	defer t.Stop()
+	for {
+		m.sample()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// sample measures the pressure, and reconciles again all the throttled
+// AnsibleRuns once it relieved.
+func (m *pressureMonitor) sample() {
+	reason, msg := m.measure()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reason, m.message = reason, msg
+	if reason != "" {
+		return
+	}
+	names := make([]string, 0, len(m.throttled))
+	for name := range m.throttled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case m.wakeups <- ctrlevent.GenericEvent{Object: &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: name}}}:
+		default:
+		}
+	}
+	m.throttled = map[string]bool{}
+	throttledRuns.Set(0)
+}
+
+// measure returns why the runs are throttled, none when the reason is
+// empty. The runs are not throttled when the pressure cannot be measured.
+func (m *pressureMonitor) measure() (xpv1.ConditionReason, string) {
+	if m.minFreeDisk > 0 {
+		free, err := m.freeDisk()
+		switch {
+		case err != nil:
+			m.log.Debug("Cannot measure the free disk space of the working directories", "error", err)
+		case free < m.minFreeDisk:
+			return v1alpha1.ReasonDiskPressure, fmt.Sprintf("%s free on the volume of the working directories, under the %s minimum",
+				kresource.NewQuantity(free, kresource.BinarySI), kresource.NewQuantity(m.minFreeDisk, kresource.BinarySI))
+		}
+	}
+	if m.maxMemory > 0 {
+		used, err := m.usedMemory()
+		switch {
+		case err != nil:
+			m.log.Debug("Cannot measure the memory used by the provider", "error", err)
+		case used > m.maxMemory:
+			return v1alpha1.ReasonMemoryPressure, fmt.Sprintf("%s of memory used by the provider, over the %s maximum",
+				kresource.NewQuantity(used, kresource.BinarySI), kresource.NewQuantity(m.maxMemory, kresource.BinarySI))
+		}
+	}
+	return "", ""
+}
+
+// throttle tells whether the run of the supplied AnsibleRun waits for the
+// pressure to relieve, and records it in its status. Nothing is recorded for
+// the AnsibleRuns that were never throttled. A nil pressureMonitor never
+// throttles.
+func (m *pressureMonitor) throttle(cr *v1alpha1.AnsibleRun) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reason == "" {
+		if throttledRun(cr) {
+			cr.SetConditions(throttledCondition(v1.ConditionFalse, v1alpha1.ReasonPressureRelieved, ""))
+		}
+		return false
+	}
+	m.throttled[cr.GetName()] = true
+	throttledRuns.Set(float64(len(m.throttled)))
+	cr.SetConditions(throttledCondition(v1.ConditionTrue, m.reason, m.message))
+	return true
+}
+
+// throttledRun tells whether the run of the supplied AnsibleRun waits for the
+// pressure to relieve.
+func throttledRun(cr *v1alpha1.AnsibleRun) bool {
+	return cr.GetCondition(v1alpha1.TypeThrottled).Status == v1.ConditionTrue
+}
+
+// throttledCondition returns the Throttled condition of an AnsibleRun.
+func throttledCondition(status v1.ConditionStatus, reason xpv1.ConditionReason, msg string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypeThrottled,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            msg,
+	}
+}
+
+// freeDiskSpace returns the space of the volume holding the supplied
+// directory available to the provider.
+func freeDiskSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil //nolint:unconvert // the types of the fields differ by platform
+}
+
+// workingSetMemory returns the memory used by the cgroup of the provider
+// container, the provider and its runs, short of its inactive page cache,
+// the way the kubelet measures it to evict pods.
+func workingSetMemory(fs afero.Afero) (int64, error) {
+	for _, f := range cgroupMemory {
+		b, err := fs.ReadFile(f.usage)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		usage, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", f.usage, err)
+		}
+		// the page cache is counted when the stat cannot be read
+		if stat, err := fs.ReadFile(f.stat); err == nil {
+			usage -= min(inactiveFile(stat, f.inactive), usage)
+		}
+		return usage, nil
+	}
+	return 0, fmt.Errorf("%s: %s", errCgroupMemory, cgroupMemory[0].usage)
+}
+
+// inactiveFile returns the inactive page cache of the supplied memory stat of
+// a cgroup, under the supplied key.
+func inactiveFile(stat []byte, key string) int64 {
+	s := bufio.NewScanner(bytes.NewReader(stat))
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), " ")
+		if !ok || k != key {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
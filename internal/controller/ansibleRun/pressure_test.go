@@ -0,0 +1,182 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	ctrlevent "sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+// pressure returns a pressureMonitor measuring the supplied free disk space
+// and memory used.
+func pressure(free, used *int64) *pressureMonitor {
+	return &pressureMonitor{
+		minFreeDisk: 100,
+		maxMemory:   100,
+		freeDisk:    func() (int64, error) { return *free, nil },
+		usedMemory:  func() (int64, error) { return *used, nil },
+		wakeups:     make(chan ctrlevent.GenericEvent, 10),
+		log:         logging.NewNopLogger(),
+		throttled:   map[string]bool{},
+	}
+}
+
+func TestPressureMonitor(t *testing.T) {
+	free, used := int64(50), int64(10)
+	m := pressure(&free, &used)
+	ignoreTime := cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime")
+
+	m.sample()
+	cr := inGroup("a", "")
+	if !m.throttle(cr) {
+		t.Fatalf("throttle(...): want the run throttled while the disk is short of free space")
+	}
+	want := throttledCondition(v1.ConditionTrue, v1alpha1.ReasonDiskPressure, "50 free on the volume of the working directories, under the 100 minimum")
+	if diff := cmp.Diff(want, cr.GetCondition(v1alpha1.TypeThrottled), ignoreTime); diff != "" {
+		t.Errorf("throttle(...): -want, +got:\n%s", diff)
+	}
+
+	free, used = 500, 200
+	m.sample()
+	if !m.throttle(cr) {
+		t.Fatalf("throttle(...): want the run throttled while the provider uses too much memory")
+	}
+	if c := cr.GetCondition(v1alpha1.TypeThrottled); c.Reason != v1alpha1.ReasonMemoryPressure {
+		t.Errorf("throttle(...): want the memory pressure reported, got %s", c.Reason)
+	}
+
+	used = 50
+	m.sample()
+	select {
+	case evt := <-m.wakeups:
+		if evt.Object.GetName() != "a" {
+			t.Errorf("sample(): want a reconciled again, got %s", evt.Object.GetName())
+		}
+	default:
+		t.Errorf("sample(): want the throttled AnsibleRuns reconciled again once the pressure relieved")
+	}
+	if m.throttle(cr) {
+		t.Fatalf("throttle(...): want the run started once the pressure relieved")
+	}
+	want = throttledCondition(v1.ConditionFalse, v1alpha1.ReasonPressureRelieved, "")
+	if diff := cmp.Diff(want, cr.GetCondition(v1alpha1.TypeThrottled), ignoreTime); diff != "" {
+		t.Errorf("throttle(...): -want, +got:\n%s", diff)
+	}
+
+	other := inGroup("b", "")
+	if m.throttle(other) {
+		t.Errorf("throttle(...): want the run started without pressure")
+	}
+	if c := other.GetCondition(v1alpha1.TypeThrottled); c.Status != v1.ConditionUnknown {
+		t.Errorf("throttle(...): want no condition for a run that was never throttled, got %s %s", c.Status, c.Reason)
+	}
+	if (*pressureMonitor)(nil).throttle(other) {
+		t.Errorf("throttle(...): want a nil pressureMonitor to never throttle")
+	}
+}
+
+func TestPressureMonitorUnmeasured(t *testing.T) {
+	m := pressure(nil, nil)
+	m.freeDisk = func() (int64, error) { return 0, errors.New("boom") }
+	m.usedMemory = func() (int64, error) { return 0, errors.New("boom") }
+	m.sample()
+	if m.throttle(inGroup("a", "")) {
+		t.Errorf("throttle(...): want the runs started when the pressure cannot be measured")
+	}
+}
+
+func TestDeleteThrottled(t *testing.T) {
+	free, used := int64(0), int64(0)
+	m := pressure(&free, &used)
+	m.sample()
+
+	c := &external{pressure: m, runner: &MockRunner{MockDestroy: func(context.Context) error {
+		t.Error("Destroy(...): want no run while the provider is under pressure")
+		return nil
+	}}}
+	err := c.Delete(context.Background(), inGroup("a", ""))
+	if diff := cmp.Diff(errors.New(errThrottled), err, test.EquateErrors()); diff != "" {
+		t.Errorf("Delete(...): -want error, +got error:\n%s", diff)
+	}
+}
+
+func TestWorkingSetMemory(t *testing.T) {
+	type want struct {
+		used int64
+		err  bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		files  map[string]string
+		want   want
+	}{
+		"CgroupV2": {
+			reason: "The inactive page cache should not count as used memory",
+			files: map[string]string{
+				"/sys/fs/cgroup/memory.current": "1000\n",
+				"/sys/fs/cgroup/memory.stat":    "anon 600\ninactive_file 300\nactive_file 100\n",
+			},
+			want: want{used: 700},
+		},
+		"CgroupV1": {
+			reason: "The memory of the cgroup v1 should be found too",
+			files: map[string]string{
+				"/sys/fs/cgroup/memory/memory.usage_in_bytes": "1000\n",
+				"/sys/fs/cgroup/memory/memory.stat":           "inactive_file 100\ntotal_inactive_file 400\n",
+			},
+			want: want{used: 600},
+		},
+		"NoStat": {
+			reason: "The page cache should be counted when the stat cannot be read",
+			files:  map[string]string{"/sys/fs/cgroup/memory.current": "1000"},
+			want:   want{used: 1000},
+		},
+		"NoCgroup": {
+			reason: "An error should be returned outside of a cgroup",
+			want:   want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			for path, content := range tc.files {
+				_ = fs.WriteFile(path, []byte(content), 0600)
+			}
+			used, err := workingSetMemory(fs)
+			if (err != nil) != tc.want.err {
+				t.Fatalf("\n%s\nworkingSetMemory(...): unexpected error: %v", tc.reason, err)
+			}
+			if used != tc.want.used {
+				t.Errorf("\n%s\nworkingSetMemory(...): want %d, got %d", tc.reason, tc.want.used, used)
+			}
+		})
+	}
+}
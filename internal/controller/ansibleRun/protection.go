@@ -0,0 +1,250 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+const (
+	errProtectReference = "cannot protect the reference"
+	errReleaseReference = "cannot release the reference"
+
+	// referenceFinalizerPrefix prefixes the finalizers protecting the
+	// Secrets and ConfigMaps referenced by an AnsibleRun, suffixed with its
+	// UID so that each AnsibleRun releases its own
+	referenceFinalizerPrefix = "in-use.ansible.crossplane.io/"
+
+	kindSecret    = "Secret"
+	kindConfigMap = "ConfigMap"
+)
+
+// referenceFinalizer returns the finalizer protecting the Secrets and
+// ConfigMaps referenced by the supplied AnsibleRun.
+func referenceFinalizer(cr *v1alpha1.AnsibleRun) string {
+	return referenceFinalizerPrefix + string(cr.GetUID())
+}
+
+// references returns the Secrets and ConfigMaps referenced by the supplied
+// AnsibleRun and ProviderConfig, sorted.
+func references(cr *v1alpha1.AnsibleRun, pc *v1alpha1.ProviderConfig) []v1alpha1.ProtectedReference {
+	seen := map[v1alpha1.ProtectedReference]bool{}
+	add := func(kind, namespace, name string) {
+		seen[v1alpha1.ProtectedReference{Kind: kind, Namespace: namespace, Name: name}] = true
+	}
+	addSelector := func(src xpv1.CredentialsSource, sel xpv1.CommonCredentialSelectors) {
+		if src == xpv1.CredentialsSourceSecret && sel.SecretRef != nil {
+			add(kindSecret, sel.SecretRef.Namespace, sel.SecretRef.Name)
+		}
+	}
+	addToken := func(token *v1alpha1.SourceToken) {
+		if token != nil {
+			addSelector(token.Source, token.CommonCredentialSelectors)
+		}
+	}
+
+	p := cr.Spec.ForProvider
+	for _, i := range p.Inventories {
+		addSelector(i.Source, i.CommonCredentialSelectors)
+	}
+	for _, plugin := range p.InventoryPlugins {
+		for _, cd := range plugin.Credentials {
+			addSelector(cd.Source, cd.CommonCredentialSelectors)
+		}
+	}
+	for _, pw := range p.Passwords {
+		addSelector(pw.Source, pw.CommonCredentialSelectors)
+	}
+	for _, roles := range [][]v1alpha1.Role{p.Roles, p.DeleteRoles} {
+		for _, r := range roles {
+			addToken(r.Token)
+		}
+	}
+	for _, col := range p.Collections {
+		addToken(col.Token)
+	}
+	for _, src := range p.VarsFrom {
+		add(src.Kind, src.Namespace, src.Name)
+	}
+	for _, f := range p.Files {
+		add(f.Kind, f.Namespace, f.Name)
+	}
+
+	for _, cd := range pc.Spec.Credentials {
+		addSelector(cd.Source, cd.CommonCredentialSelectors)
+	}
+	for _, vs := range pc.Spec.VaultSecrets {
+		addSelector(vs.Source, vs.CommonCredentialSelectors)
+	}
+	for _, v := range pc.Spec.Vars {
+		switch {
+		case v.ValueFrom == nil:
+		case v.ValueFrom.SecretKeyRef != nil:
+			add(kindSecret, v.ValueFrom.SecretKeyRef.Namespace, v.ValueFrom.SecretKeyRef.Name)
+		case v.ValueFrom.ConfigMapKeyRef != nil:
+			add(kindConfigMap, v.ValueFrom.ConfigMapKeyRef.Namespace, v.ValueFrom.ConfigMapKeyRef.Name)
+		}
+	}
+	if r := pc.Spec.Requirements; r != nil {
+		for _, role := range r.Roles {
+			addToken(role.Token)
+		}
+		for _, col := range r.Collections {
+			addToken(col.Token)
+		}
+	}
+	if ssh := pc.Spec.SSHCredentials; ssh != nil {
+		add(kindSecret, ssh.PrivateKeySecretRef.Namespace, ssh.PrivateKeySecretRef.Name)
+	}
+	if g := pc.Spec.Galaxy; g != nil && g.TokenSecretRef != nil {
+		add(kindSecret, g.TokenSecretRef.Namespace, g.TokenSecretRef.Name)
+	}
+
+	refs := make([]v1alpha1.ProtectedReference, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return refs
+}
+
+// referencedObject returns an empty object of the kind of the supplied
+// reference.
+func referencedObject(ref v1alpha1.ProtectedReference) client.Object {
+	if ref.Kind == kindConfigMap {
+		return &corev1.ConfigMap{}
+	}
+	return &corev1.Secret{}
+}
+
+// protectReferences adds the finalizer of the supplied AnsibleRun to the
+// Secrets and ConfigMaps it references when its ProviderConfig protects the
+// references, and removes it from the ones it no longer references. The
+// protected references are recorded in the status, so that they can be
+// released once the AnsibleRun is deleted. Missing references are not
+// protected, reading them fails the run instead.
+func (c *connector) protectReferences(ctx context.Context, cr *v1alpha1.AnsibleRun, pc *v1alpha1.ProviderConfig) error {
+	var refs []v1alpha1.ProtectedReference
+	if pc.Spec.ProtectReferences {
+		refs = references(cr, pc)
+	}
+	f := referenceFinalizer(cr)
+	protected := make([]v1alpha1.ProtectedReference, 0, len(refs))
+	keep := make(map[v1alpha1.ProtectedReference]bool, len(refs))
+	for _, ref := range refs {
+		keep[ref] = true
+		obj := referencedObject(ref)
+		if err := c.kube.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj); err != nil {
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("%s %s %s/%s: %w", errProtectReference, ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		if meta.FinalizerExists(obj, f) {
+			protected = append(protected, ref)
+			continue
+		}
+		meta.AddFinalizer(obj, f)
+		if err := c.kube.Update(ctx, obj); err != nil {
+			return fmt.Errorf("%s %s %s/%s: %w", errProtectReference, ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		protected = append(protected, ref)
+		// recorded right away, so that it is released even if protecting
+		// the next references fails
+		if !slices.Contains(cr.Status.AtProvider.ProtectedReferences, ref) {
+			cr.Status.AtProvider.ProtectedReferences = append(cr.Status.AtProvider.ProtectedReferences, ref)
+		}
+	}
+	if err := releaseReferences(ctx, c.kube, cr, keep); err != nil {
+		return err
+	}
+	if len(protected) == 0 {
+		protected = nil
+	}
+	cr.Status.AtProvider.ProtectedReferences = protected
+	return nil
+}
+
+// releaseReferences removes the finalizer of the supplied AnsibleRun from the
+// Secrets and ConfigMaps recorded in its status, except the ones to keep.
+func releaseReferences(ctx context.Context, kube client.Client, cr *v1alpha1.AnsibleRun, keep map[v1alpha1.ProtectedReference]bool) error {
+	f := referenceFinalizer(cr)
+	for _, ref := range cr.Status.AtProvider.ProtectedReferences {
+		if keep[ref] {
+			continue
+		}
+		obj := referencedObject(ref)
+		if err := kube.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj); err != nil {
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("%s %s %s/%s: %w", errReleaseReference, ref.Kind, ref.Namespace, ref.Name, err)
+		}
+		if !meta.FinalizerExists(obj, f) {
+			continue
+		}
+		meta.RemoveFinalizer(obj, f)
+		if err := kube.Update(ctx, obj); resource.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("%s %s %s/%s: %w", errReleaseReference, ref.Kind, ref.Namespace, ref.Name, err)
+		}
+	}
+	return nil
+}
+
+// A referencesFinalizer releases the Secrets and ConfigMaps protected for an
+// AnsibleRun before removing its finalizer, once it is deleted.
+type referencesFinalizer struct {
+	resource.Finalizer
+	kube client.Client
+}
+
+// RemoveFinalizer releases the references of the supplied AnsibleRun, then
+// removes its finalizer.
+func (f *referencesFinalizer) RemoveFinalizer(ctx context.Context, obj resource.Object) error {
+	cr, ok := obj.(*v1alpha1.AnsibleRun)
+	if !ok {
+		return errors.New(errNotAnsibleRun)
+	}
+	if err := releaseReferences(ctx, f.kube, cr, nil); err != nil {
+		return err
+	}
+	cr.Status.AtProvider.ProtectedReferences = nil
+	return f.Finalizer.RemoveFinalizer(ctx, obj)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+// finalizersOf gets the supplied object and returns its finalizers.
+func finalizersOf(t *testing.T, kube client.Client, obj client.Object, namespace, name string) []string {
+	t.Helper()
+	if err := kube.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj.GetFinalizers()
+}
+
+func TestProtectReferences(t *testing.T) {
+	f := referenceFinalizerPrefix + string(uid)
+	inventory := v1alpha1.ProtectedReference{Kind: kindSecret, Namespace: "ns", Name: "inventory"}
+	vars := v1alpha1.ProtectedReference{Kind: kindConfigMap, Namespace: "ns", Name: "vars"}
+	old := v1alpha1.ProtectedReference{Kind: kindSecret, Namespace: "ns", Name: "old"}
+
+	type want struct {
+		status    []v1alpha1.ProtectedReference
+		inventory []string
+		vars      []string
+		old       []string
+	}
+	cases := map[string]struct {
+		reason    string
+		protect   bool
+		protected []v1alpha1.ProtectedReference
+		want      want
+	}{
+		"Disabled": {
+			reason: "The references should not be protected unless the ProviderConfig protects them.",
+		},
+		"Protect": {
+			reason:  "The existing Secrets and ConfigMaps referenced by the AnsibleRun and its ProviderConfig should be protected and recorded.",
+			protect: true,
+			want: want{
+				status:    []v1alpha1.ProtectedReference{vars, inventory},
+				inventory: []string{f},
+				vars:      []string{f},
+			},
+		},
+		"ReleaseUnreferenced": {
+			reason:    "The references the AnsibleRun no longer references should be released.",
+			protect:   true,
+			protected: []v1alpha1.ProtectedReference{old, inventory},
+			want: want{
+				status:    []v1alpha1.ProtectedReference{vars, inventory},
+				inventory: []string{f},
+				vars:      []string{f},
+			},
+		},
+		"ReleaseDisabled": {
+			reason:    "All the references should be released once the ProviderConfig no longer protects them.",
+			protected: []v1alpha1.ProtectedReference{old, inventory},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			finalizers := func(ref v1alpha1.ProtectedReference) []string {
+				for _, p := range tc.protected {
+					if p == ref {
+						return []string{f}
+					}
+				}
+				return nil
+			}
+			kube := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "inventory", Finalizers: finalizers(inventory)}},
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "old", Finalizers: finalizers(old)}},
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vars"}},
+			).Build()
+			cr := &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{UID: uid},
+				Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+					Inventories: []v1alpha1.Inventory{
+						{Source: xpv1.CredentialsSourceSecret, CommonCredentialSelectors: xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{SecretReference: xpv1.SecretReference{Namespace: "ns", Name: "inventory"}, Key: "hosts"}}},
+						{Source: xpv1.CredentialsSourceSecret, CommonCredentialSelectors: xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{SecretReference: xpv1.SecretReference{Namespace: "ns", Name: "missing"}, Key: "hosts"}}},
+					},
+				}},
+				Status: v1alpha1.AnsibleRunStatus{AtProvider: v1alpha1.AnsibleRunObservation{ProtectedReferences: tc.protected}},
+			}
+			pc := &v1alpha1.ProviderConfig{Spec: v1alpha1.ProviderConfigSpec{
+				ProtectReferences: tc.protect,
+				Vars: []v1alpha1.Var{
+					{Key: "ANSIBLE_FORKS", ValueFrom: &v1alpha1.VarSource{ConfigMapKeyRef: &v1alpha1.ConfigMapKeySelector{Namespace: "ns", Name: "vars", Key: "forks"}}},
+				},
+			}}
+
+			c := &connector{kube: kube}
+			if err := c.protectReferences(context.Background(), cr, pc); err != nil {
+				t.Fatalf("\n%s\nprotectReferences(...): unexpected error: %v", tc.reason, err)
+			}
+			got := want{
+				status:    cr.Status.AtProvider.ProtectedReferences,
+				inventory: finalizersOf(t, kube, &corev1.Secret{}, "ns", "inventory"),
+				vars:      finalizersOf(t, kube, &corev1.ConfigMap{}, "ns", "vars"),
+				old:       finalizersOf(t, kube, &corev1.Secret{}, "ns", "old"),
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(want{})); diff != "" {
+				t.Errorf("\n%s\nprotectReferences(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestReferencesFinalizer(t *testing.T) {
+	f := referenceFinalizerPrefix + string(uid)
+	kube := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "inventory", Finalizers: []string{f, "other"}}},
+	).Build()
+	cr := &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{UID: uid},
+		Status: v1alpha1.AnsibleRunStatus{AtProvider: v1alpha1.AnsibleRunObservation{ProtectedReferences: []v1alpha1.ProtectedReference{
+			{Kind: kindSecret, Namespace: "ns", Name: "inventory"},
+			{Kind: kindConfigMap, Namespace: "ns", Name: "deleted"},
+		}}},
+	}
+	removed := false
+	rf := &referencesFinalizer{
+		Finalizer: resource.FinalizerFns{RemoveFinalizerFn: func(_ context.Context, _ resource.Object) error {
+			removed = true
+			return nil
+		}},
+		kube: kube,
+	}
+	if err := rf.RemoveFinalizer(context.Background(), cr); err != nil {
+		t.Fatalf("RemoveFinalizer(...): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"other"}, finalizersOf(t, kube, &corev1.Secret{}, "ns", "inventory")); diff != "" {
+		t.Errorf("RemoveFinalizer(...): -want, +got:\n%s", diff)
+	}
+	if !removed {
+		t.Error("RemoveFinalizer(...): want the finalizer of the AnsibleRun removed")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	errGetSourceToken      = "cannot get the token of"
+	errSourceTokenNotHTTPS = "tokens only authenticate to https git repositories, cannot use the token of"
+
+	// defaultTokenUsername is sent along with the tokens of the git
+	// repositories when no username is set.
+	defaultTokenUsername = "x-access-token"
+)
+
+// galaxyRole is a role in the requirements file of ansible-galaxy.
+type galaxyRole struct {
+	Name    string `yaml:"name"`
+	Src     string `yaml:"src,omitempty"`
+	Version string `yaml:"version"`
+	Scm     string `yaml:"scm,omitempty"`
+}
+
+// galaxyCollection is a collection in the requirements file of ansible-galaxy.
+type galaxyCollection struct {
+	Name    string `yaml:"name"`
+	Source  string `yaml:"source,omitempty"`
+	Type    string `yaml:"type,omitempty"`
+	Version string `yaml:"version,omitempty"`
+}
+
+// galaxyRequirements are the roles and collections of an AnsibleRun and of
+// its ProviderConfig, in the format of the requirements file of
+// ansible-galaxy.
+type galaxyRequirements struct {
+	Roles       []galaxyRole       `yaml:"roles,omitempty"`
+	Collections []galaxyCollection `yaml:"collections,omitempty"`
+}
+
+// allRoles returns the roles of the supplied parameters followed by their
+// delete roles, which are installed along with them, once each.
+func allRoles(p v1alpha1.AnsibleRunParameters) []v1alpha1.Role {
+	roles := p.Roles
+	for _, r := range p.DeleteRoles {
+		dup := false
+		for _, existing := range p.Roles {
+			dup = dup || existing.Name == r.Name
+		}
+		if !dup {
+			roles = append(roles[:len(roles):len(roles)], r)
+		}
+	}
+	return roles
+}
+
+// allCollections returns the collections of the supplied ProviderConfig
+// requirements, if any, followed by the ones of the supplied parameters.
+func allCollections(pcRequirements *v1alpha1.Requirements, p v1alpha1.AnsibleRunParameters) []v1alpha1.Collection {
+	if pcRequirements == nil || len(pcRequirements.Collections) == 0 {
+		return p.Collections
+	}
+	return append(append([]v1alpha1.Collection{}, pcRequirements.Collections...), p.Collections...)
+}
+
+// hasRequirements tells whether the supplied parameters declare roles or
+// collections to install.
+func hasRequirements(p v1alpha1.AnsibleRunParameters) bool {
+	return len(allRoles(p)) != 0 || len(p.Collections) != 0
+}
+
+// requirementsOf returns the requirements of the supplied ProviderConfig
+// requirements, if any, followed by the ones of the supplied parameters,
+// leaving their tokens out.
+func requirementsOf(pcRequirements *v1alpha1.Requirements, p v1alpha1.AnsibleRunParameters) galaxyRequirements {
+	var req galaxyRequirements
+	if pcRequirements != nil {
+		for _, r := range pcRequirements.Roles {
+			req.Roles = append(req.Roles, galaxyRole{Name: r.Name, Src: r.Src, Version: r.Version, Scm: r.Scm})
+		}
+	}
+	for _, r := range allRoles(p) {
+		req.Roles = append(req.Roles, galaxyRole{Name: r.Name, Src: r.Src, Version: r.Version, Scm: r.Scm})
+	}
+	for _, c := range allCollections(pcRequirements, p) {
+		req.Collections = append(req.Collections, galaxyCollection{Name: c.Name, Source: c.Source, Type: c.Type, Version: c.Version})
+	}
+	return req
+}
+
+// marshal returns the requirements file of the requirements.
+func (req galaxyRequirements) marshal() ([]byte, error) {
+	out, err := yaml.Marshal(&req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errMarshalRoles, err)
+	}
+	return out, nil
+}
+
+// gitTokenEnv returns the environment variables through which git
+// authenticates to the repositories of the roles and collections of the
+// supplied ProviderConfig requirements and parameters with their tokens.
+func (c *connector) gitTokenEnv(ctx context.Context, pcRequirements *v1alpha1.Requirements, p v1alpha1.AnsibleRunParameters) (map[string]string, error) {
+	credentials := map[string]galaxyutil.BasicAuth{}
+	add := func(name, src string, token *v1alpha1.SourceToken) error {
+		if token == nil {
+			return nil
+		}
+		url := galaxyutil.GitRepositoryURL(src)
+		if !strings.HasPrefix(url, "https://") {
+			return fmt.Errorf("%s %s", errSourceTokenNotHTTPS, name)
+		}
+		data, err := resource.CommonCredentialExtractor(ctx, token.Source, c.kube, token.CommonCredentialSelectors)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", errGetSourceToken, name, err)
+		}
+		username := token.Username
+		if username == "" {
+			username = defaultTokenUsername
+		}
+		credentials[url] = galaxyutil.BasicAuth{Username: username, Password: strings.TrimSpace(string(data))}
+		return nil
+	}
+	if pcRequirements != nil {
+		for _, r := range pcRequirements.Roles {
+			if err := add(r.Name, r.Src, r.Token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, r := range allRoles(p) {
+		if err := add(r.Name, r.Src, r.Token); err != nil {
+			return nil, err
+		}
+	}
+	for _, col := range allCollections(pcRequirements, p) {
+		if err := add(col.Name, col.Name, col.Token); err != nil {
+			return nil, err
+		}
+	}
+	return galaxyutil.GitTokenEnv(credentials), nil
+}
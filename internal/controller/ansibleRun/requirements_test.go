@@ -0,0 +1,185 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestRequirementsOf(t *testing.T) {
+	pcRequirements := &v1alpha1.Requirements{
+		Roles:       []v1alpha1.RequiredRole{{Name: "sample_namespace.sample_role", Version: "0.1.0"}},
+		Collections: []v1alpha1.Collection{{Name: "community.general", Version: "8.0.0"}},
+	}
+	params := v1alpha1.AnsibleRunParameters{
+		Roles: []v1alpha1.Role{{
+			Name:  "private",
+			Src:   "https://github.com/org/private.git",
+			Scm:   "git",
+			Token: &v1alpha1.SourceToken{Source: xpv1.CredentialsSourceSecret},
+		}},
+		Collections: []v1alpha1.Collection{{Name: "kubernetes.core", Version: ">=2.4.0,<3.0.0"}},
+	}
+
+	cases := map[string]struct {
+		reason         string
+		pcRequirements *v1alpha1.Requirements
+		params         v1alpha1.AnsibleRunParameters
+		want           string
+	}{
+		"AnsibleRunOnly": {
+			reason: "We should write the roles and collections of the AnsibleRun, leaving their tokens out",
+			params: params,
+			want: "roles:\n- name: private\n  src: https://github.com/org/private.git\n  version: \"\"\n  scm: git\n" +
+				"collections:\n- name: kubernetes.core\n  version: '>=2.4.0,<3.0.0'\n",
+		},
+		"ProviderConfigOnly": {
+			reason:         "We should write the roles and collections of the ProviderConfig",
+			pcRequirements: pcRequirements,
+			want: "roles:\n- name: sample_namespace.sample_role\n  version: 0.1.0\n" +
+				"collections:\n- name: community.general\n  version: 8.0.0\n",
+		},
+		"Merged": {
+			reason:         "We should write the roles and collections of the AnsibleRun after the ones of the ProviderConfig",
+			pcRequirements: pcRequirements,
+			params:         params,
+			want: "roles:\n- name: sample_namespace.sample_role\n  version: 0.1.0\n- name: private\n  src: https://github.com/org/private.git\n  version: \"\"\n  scm: git\n" +
+				"collections:\n- name: community.general\n  version: 8.0.0\n- name: kubernetes.core\n  version: '>=2.4.0,<3.0.0'\n",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := requirementsOf(tc.pcRequirements, tc.params).marshal()
+			if err != nil {
+				t.Fatalf("\n%s\nrequirementsOf(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nrequirementsOf(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestGitTokenEnv(t *testing.T) {
+	errBoom := errors.New("boom")
+	secretToken := func(username string) *v1alpha1.SourceToken {
+		return &v1alpha1.SourceToken{
+			Username: username,
+			Source:   xpv1.CredentialsSourceSecret,
+			CommonCredentialSelectors: xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{
+				SecretReference: xpv1.SecretReference{Name: "git", Namespace: "crossplane-system"},
+				Key:             "token",
+			}},
+		}
+	}
+	basic := func(userToken string) string {
+		return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(userToken))
+	}
+
+	cases := map[string]struct {
+		reason         string
+		kube           client.Client
+		pcRequirements *v1alpha1.Requirements
+		params         v1alpha1.AnsibleRunParameters
+		want           map[string]string
+		wantErr        error
+	}{
+		"NoTokens": {
+			reason: "We should not configure git when no token is set",
+			params: v1alpha1.AnsibleRunParameters{Roles: []v1alpha1.Role{{Name: "public", Src: "git+https://github.com/org/public.git"}}},
+		},
+		"Tokens": {
+			reason: "We should send the tokens in the Authorization header of the requests to their repositories only",
+			kube: &test.MockClient{MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+				obj.(*v1.Secret).Data = map[string][]byte{"token": []byte("s3cr3t\n")}
+				return nil
+			})},
+			params: v1alpha1.AnsibleRunParameters{
+				Roles: []v1alpha1.Role{
+					{Name: "private", Src: "git+https://github.com/org/private.git", Version: "main", Token: secretToken("")},
+					{Name: "public", Src: "git+https://github.com/org/public.git"},
+				},
+				Collections: []v1alpha1.Collection{
+					{Name: "https://gitlab.com/org/collection.git#/subdir,v1", Type: "git", Token: secretToken("oauth2")},
+				},
+			},
+			want: map[string]string{
+				"GIT_CONFIG_COUNT":   "2",
+				"GIT_CONFIG_KEY_0":   "http.https://github.com/org/private.git.extraHeader",
+				"GIT_CONFIG_VALUE_0": basic("x-access-token:s3cr3t"),
+				"GIT_CONFIG_KEY_1":   "http.https://gitlab.com/org/collection.git.extraHeader",
+				"GIT_CONFIG_VALUE_1": basic("oauth2:s3cr3t"),
+			},
+		},
+		"ProviderConfigTokens": {
+			reason: "We should send the tokens of the requirements of the ProviderConfig too",
+			kube: &test.MockClient{MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+				obj.(*v1.Secret).Data = map[string][]byte{"token": []byte("s3cr3t")}
+				return nil
+			})},
+			pcRequirements: &v1alpha1.Requirements{Roles: []v1alpha1.RequiredRole{
+				{Name: "shared", Src: "git+https://github.com/org/shared.git", Token: secretToken("")},
+			}},
+			want: map[string]string{
+				"GIT_CONFIG_COUNT":   "1",
+				"GIT_CONFIG_KEY_0":   "http.https://github.com/org/shared.git.extraHeader",
+				"GIT_CONFIG_VALUE_0": basic("x-access-token:s3cr3t"),
+			},
+		},
+		"NotHTTPS": {
+			reason: "We should reject tokens of repositories not accessed over https",
+			params: v1alpha1.AnsibleRunParameters{Roles: []v1alpha1.Role{
+				{Name: "ssh", Src: "git@github.com:org/ssh.git", Token: secretToken("")},
+			}},
+			wantErr: fmt.Errorf("%s %s", errSourceTokenNotHTTPS, "ssh"),
+		},
+		"GetTokenError": {
+			reason: "We should return any error encountered while getting a token",
+			kube:   &test.MockClient{MockGet: test.NewMockGetFn(errBoom)},
+			params: v1alpha1.AnsibleRunParameters{Roles: []v1alpha1.Role{
+				{Name: "private", Src: "git+https://github.com/org/private.git", Token: secretToken("")},
+			}},
+			wantErr: fmt.Errorf("%s %s: %w", errGetSourceToken, "private", fmt.Errorf("cannot get credentials secret: %w", errBoom)),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &connector{kube: tc.kube}
+			got, err := c.gitTokenEnv(context.Background(), tc.pcRequirements, tc.params)
+			if diff := cmp.Diff(tc.wantErr, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\ngitTokenEnv(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\ngitTokenEnv(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	// the time zones of the schedules don't depend on the image
+	_ "time/tzdata"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/cronutil"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	errSchedule         = "invalid schedule"
+	errWindowDuration   = "the duration of a maintenance window must be positive"
+	errScheduleNoWindow = "no maintenance window opens within 5 years"
+)
+
+// nextWindow returns t when a maintenance window of the supplied schedule is
+// open at t, and the time the next one opens otherwise.
+func nextWindow(s *v1alpha1.Schedule, t time.Time) (time.Time, error) {
+	loc := time.UTC
+	if s.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(s.TimeZone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	t = t.In(loc)
+	var next time.Time
+	for _, w := range s.Windows {
+		if w.Duration.Duration <= 0 {
+			return time.Time{}, errors.New(errWindowDuration)
+		}
+		cron, err := cronutil.Parse(w.Start)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// the first window opening after t-duration is either still open
+		// at t, or the next one
+		open := cron.Next(t.Add(-w.Duration.Duration))
+		switch {
+		case open.IsZero():
+			continue
+		case !open.After(t):
+			return t, nil
+		case next.IsZero() || open.Before(next):
+			next = open
+		}
+	}
+	if next.IsZero() {
+		return time.Time{}, errors.New(errScheduleNoWindow)
+	}
+	return next, nil
+}
+
+// pendingChanges records in the supplied AnsibleRun whether its run is held
+// back until the maintenance window opening at the supplied time.
+func pendingChanges(cr *v1alpha1.AnsibleRun, outside bool, open time.Time) {
+	switch {
+	case outside:
+		cr.SetConditions(pendingChangesCondition(v1.ConditionTrue, v1alpha1.ReasonOutsideMaintenanceWindow,
+			fmt.Sprintf("changes deferred to the maintenance window opening at %s", open.Format(time.RFC3339))))
+	case cr.GetCondition(v1alpha1.TypePendingChanges).Status == v1.ConditionTrue:
+		cr.SetConditions(pendingChangesCondition(v1.ConditionFalse, v1alpha1.ReasonWithinMaintenanceWindow, ""))
+	}
+}
+
+// pendingChangesCondition returns the PendingChanges condition of an
+// AnsibleRun.
+func pendingChangesCondition(status v1.ConditionStatus, reason xpv1.ConditionReason, msg string) xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypePendingChanges,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            msg,
+	}
+}
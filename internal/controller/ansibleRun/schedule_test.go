@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestNextWindow(t *testing.T) {
+	// a Wednesday
+	wed := func(hour, min int) time.Time { return time.Date(2024, 1, 3, hour, min, 0, 0, time.UTC) }
+	nightly := v1alpha1.MaintenanceWindow{Start: "0 22 * * 1-5", Duration: metav1.Duration{Duration: 2 * time.Hour}}
+
+	cases := map[string]struct {
+		reason   string
+		schedule *v1alpha1.Schedule
+		at       time.Time
+		want     time.Time
+		wantErr  bool
+	}{
+		"Open": {
+			reason:   "We should return the supplied time within a window",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}},
+			at:       wed(23, 30),
+			want:     wed(23, 30),
+		},
+		"Opening": {
+			reason:   "A window should be open at the time it opens",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}},
+			at:       wed(22, 0),
+			want:     wed(22, 0),
+		},
+		"Closed": {
+			reason:   "We should return the time the next window opens",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}},
+			at:       wed(10, 0),
+			want:     wed(22, 0),
+		},
+		"Closing": {
+			reason:   "A window should be closed at the end of its duration",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}},
+			at:       time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 4, 22, 0, 0, 0, time.UTC),
+		},
+		"Weekend": {
+			reason:   "We should skip the days the windows don't open",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}},
+			at:       time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 1, 8, 22, 0, 0, 0, time.UTC),
+		},
+		"EarliestWindow": {
+			reason: "We should return the window opening first",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{
+				nightly,
+				{Start: "30 12 * * *", Duration: metav1.Duration{Duration: 15 * time.Minute}},
+			}},
+			at:   wed(10, 0),
+			want: wed(12, 30),
+		},
+		"TimeZone": {
+			reason:   "The windows should open in the time zone of the schedule",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}, TimeZone: "Asia/Tokyo"},
+			at:       wed(10, 0),
+			// 10 PM in Tokyo
+			want: wed(13, 0),
+		},
+		"InvalidCron": {
+			reason:   "We should fail on an invalid cron expression",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{{Start: "0 25 * * *", Duration: metav1.Duration{Duration: time.Hour}}}},
+			at:       wed(10, 0),
+			wantErr:  true,
+		},
+		"InvalidTimeZone": {
+			reason:   "We should fail on an unknown time zone",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{nightly}, TimeZone: "Mars/Olympus"},
+			at:       wed(10, 0),
+			wantErr:  true,
+		},
+		"NoDuration": {
+			reason:   "We should fail on a window that never stays open",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{{Start: "0 22 * * *"}}},
+			at:       wed(10, 0),
+			wantErr:  true,
+		},
+		"NeverOpens": {
+			reason:   "We should fail on a schedule that never opens",
+			schedule: &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{{Start: "0 0 30 2 *", Duration: metav1.Duration{Duration: time.Hour}}}},
+			at:       wed(10, 0),
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := nextWindow(tc.schedule, tc.at)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("\n%s\nnextWindow(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("\n%s\nnextWindow(...): want %s, got %s", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDeferRunSchedule(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	schedule := &v1alpha1.Schedule{Windows: []v1alpha1.MaintenanceWindow{
+		{Start: "0 22 * * *", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+	}}
+
+	cases := map[string]struct {
+		reason      string
+		minInterval *metav1.Duration
+		lastApply   time.Time
+		pending     bool
+		want        bool
+		wantNextRun time.Time
+		wantPending v1.ConditionStatus
+	}{
+		"OutsideWindow": {
+			reason:      "We should defer the runs to the next window",
+			want:        true,
+			wantNextRun: time.Date(2024, 1, 3, 22, 0, 0, 0, time.UTC),
+			wantPending: v1.ConditionTrue,
+		},
+		"IntervalEndsOutsideWindow": {
+			reason:      "We should defer the runs to the first window after the end of the minimum interval",
+			minInterval: &metav1.Duration{Duration: 16 * time.Hour},
+			lastApply:   now.Add(-time.Hour),
+			want:        true,
+			wantNextRun: time.Date(2024, 1, 4, 22, 0, 0, 0, time.UTC),
+			wantPending: v1.ConditionTrue,
+		},
+		"WithinWindow": {
+			reason:      "We should clear the pending changes once the window opens",
+			minInterval: &metav1.Duration{Duration: 13*time.Hour + 30*time.Minute},
+			lastApply:   now.Add(-time.Hour),
+			pending:     true,
+			want:        true,
+			wantNextRun: time.Date(2024, 1, 3, 22, 30, 0, 0, time.UTC),
+			wantPending: v1.ConditionFalse,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cr := &v1alpha1.AnsibleRun{}
+			cr.Spec.ForProvider.Schedule = schedule
+			cr.Spec.ForProvider.MinIntervalBetweenRuns = tc.minInterval
+			if !tc.lastApply.IsZero() {
+				last := metav1.NewTime(tc.lastApply)
+				cr.Status.AtProvider.LastApplyTime = &last
+			}
+			if tc.pending {
+				cr.SetConditions(pendingChangesCondition(v1.ConditionTrue, v1alpha1.ReasonOutsideMaintenanceWindow, ""))
+			}
+			got, err := deferRun(cr, now)
+			if err != nil {
+				t.Fatalf("\n%s\ndeferRun(...): unexpected error: %v", tc.reason, err)
+			}
+			if got != tc.want {
+				t.Errorf("\n%s\ndeferRun(...): want %t, got %t", tc.reason, tc.want, got)
+			}
+			if next := cr.Status.AtProvider.NextRunTime; next == nil || !next.Time.Equal(tc.wantNextRun) {
+				t.Errorf("\n%s\ndeferRun(...): want next run %s, got %v", tc.reason, tc.wantNextRun, next)
+			}
+			if got := cr.GetCondition(v1alpha1.TypePendingChanges).Status; got != tc.wantPending {
+				t.Errorf("\n%s\ndeferRun(...): want PendingChanges %s, got %s", tc.reason, tc.wantPending, got)
+			}
+		})
+	}
+}
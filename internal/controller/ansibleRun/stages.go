@@ -0,0 +1,593 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/statusutil"
+)
+
+// Stages of Connect, preparing the working directory of an AnsibleRun for
+// its runs. A failed stage is reported by the Prepared condition of the
+// AnsibleRun, whose reason is the stage followed by "Failed".
+const (
+	stagePrepareWorkdir   = "PrepareWorkdir"
+	stageWriteInventory   = "WriteInventory"
+	stageWriteCredentials = "WriteCredentials"
+	stageFetchContent     = "FetchContent"
+	stageInstallDeps      = "InstallDeps"
+	stageInitRunner       = "InitRunner"
+)
+
+// A stageError is an error of a stage of Connect.
+type stageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *stageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.Err
+}
+
+// failStage reports the failure of the supplied stage in the Prepared
+// condition of the AnsibleRun, and returns the error of the stage.
+func failStage(cr *v1alpha1.AnsibleRun, stage string, err error) error {
+	cr.SetConditions(xpv1.Condition{
+		Type:               v1alpha1.TypePrepared,
+		Status:             v1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             xpv1.ConditionReason(stage + "Failed"),
+		Message:            statusutil.Message(err.Error(), statusutil.MaxMessageBytes),
+	})
+	return &stageError{Stage: stage, Err: err}
+}
+
+// prepared returns the Prepared condition of an AnsibleRun whose working
+// directory went through all the stages of Connect.
+func prepared() xpv1.Condition {
+	return xpv1.Condition{
+		Type:               v1alpha1.TypePrepared,
+		Status:             v1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             v1alpha1.ReasonPrepared,
+	}
+}
+
+// A preparation carries what the stages of Connect learn about an
+// AnsibleRun to the following stages.
+type preparation struct {
+	// dir is the working directory of the AnsibleRun
+	dir string
+	pc  *v1alpha1.ProviderConfig
+	ps  params
+	// vars are the behavior vars of the ProviderConfig
+	vars     map[string]string
+	vaultIDs []ansible.VaultID
+	// ssh are the SSH credentials of the ProviderConfig, none when nil
+	ssh *ansible.SSHOptions
+	// inventoryVars are the credentials of the inventory plugins of the
+	// AnsibleRun
+	inventoryVars map[string]string
+	// rolesMoved tells whether the floating version of a role moved to
+	// another commit
+	rolesMoved bool
+	// collectionsCache is the directory caching the collections of the
+	// ProviderConfig, empty when they are installed with the requirements
+	// of the AnsibleRun
+	collectionsCache string
+	// galaxyToken authenticates to the Galaxy server of the ProviderConfig
+	galaxyToken string
+	// gitCredentialsEnv are the environment variables through which git
+	// uses the .git-credentials of the ProviderConfig
+	gitCredentialsEnv map[string]string
+}
+
+func (p *preparation) projectDir() string {
+	return filepath.Join(p.dir, runnerutil.ProjectDir)
+}
+
+// galaxyOptions returns the options of the ansible-galaxy installs of the
+// ProviderConfig, authenticated to its Galaxy server.
+func (p *preparation) galaxyOptions() ansible.GalaxyOptions {
+	opts := galaxyOptions(p.pc)
+	opts.ServerToken = p.galaxyToken
+	return opts
+}
+
+// prepareWorkdir makes the working directory of the AnsibleRun, laid out as
+// an ansible-runner private data dir.
+func (c *connector) prepareWorkdir(cr *v1alpha1.AnsibleRun, p *preparation) error {
+	p.dir = filepath.Join(baseWorkingDir, string(cr.GetUID()))
+	if err := c.fs.MkdirAll(p.dir, 0700); resource.Ignore(os.IsExist, err) != nil {
+		return fmt.Errorf("%s: %s: %w", baseWorkingDir, errMkdir, err)
+	}
+	for _, d := range []string{p.projectDir(), filepath.Join(p.dir, runnerutil.InventoryDir), filepath.Join(p.dir, runnerutil.EnvDir),
+		filepath.Join(p.dir, runnerutil.HomeDir), filepath.Join(p.dir, runnerutil.TmpDir)} {
+		if err := c.fs.MkdirAll(d, 0700); resource.Ignore(os.IsExist, err) != nil {
+			return fmt.Errorf("%s: %s: %w", d, errMkdir, err)
+		}
+	}
+	return nil
+}
+
+// writeInventory writes the inventories of the AnsibleRun, the ones of its
+// sources and of its kubernetes inventories followed by its inline inventory,
+// into a single hosts file, and the configuration files of its inventory
+// plugins next to it. The vars of a host the later inventories set take
+// precedence, the ones set differently by several inventories are warned
+// about.
+func (c *connector) writeInventory(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) error {
+	if err := c.writeInventoryPlugins(ctx, cr, p); err != nil {
+		return err
+	}
+	var inventoryPerm os.FileMode = 0600
+	if cr.Spec.ForProvider.ExecutableInventory {
+		inventoryPerm = 0700
+	}
+	var buff bytes.Buffer
+	var sources []inventorySource
+	for n, i := range cr.Spec.ForProvider.Inventories {
+		data, err := resource.CommonCredentialExtractor(ctx, i.Source, c.kube, i.CommonCredentialSelectors)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errGetInventory, err)
+		}
+		inventory := normalizeInline(string(data))
+		sources = append(sources, inventorySource{name: fmt.Sprintf("inventories[%d]", n), inventory: inventory})
+		if _, err := buff.WriteString(inventory + "\n"); err != nil {
+			return err
+		}
+	}
+	for n, inv := range cr.Spec.ForProvider.KubernetesInventories {
+		inventory, err := c.kubernetesInventory(ctx, inv)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, inventorySource{name: fmt.Sprintf("kubernetesInventories[%d]", n), inventory: inventory})
+		if _, err := buff.WriteString(inventory + "\n"); err != nil {
+			return err
+		}
+	}
+	if cr.Spec.ForProvider.InventoryInline != nil {
+		values, err := inlineTemplateValues(cr)
+		if err != nil {
+			return err
+		}
+		inventory, err := renderInline(runnerutil.Hosts, normalizeInline(*cr.Spec.ForProvider.InventoryInline), values)
+		if err != nil {
+			return err
+		}
+		// the executable inventories are scripts
+		if !cr.Spec.ForProvider.ExecutableInventory {
+			if err := validateInventory(inventory); err != nil {
+				return err
+			}
+		}
+		sources = append(sources, inventorySource{name: "inventoryInline", inventory: inventory})
+		if _, err := buff.WriteString(inventory + "\n"); err != nil {
+			return err
+		}
+	}
+	// the executable inventories are scripts
+	if !cr.Spec.ForProvider.ExecutableInventory {
+		c.warnInventoryConflicts(cr, sources)
+	}
+	if buff.Len() == 0 {
+		return nil
+	}
+	hosts := filepath.Join(p.dir, runnerutil.InventoryDir, runnerutil.Hosts)
+	if err := c.fs.WriteFile(hosts, buff.Bytes(), inventoryPerm); err != nil {
+		return fmt.Errorf("%s %s: %w", errWriteInventory, runnerutil.Hosts, err)
+	}
+	// WriteFile only sets permissions for new files, do an explicit chmod to ensure changing permissions are updated
+	// on existing files
+	if err := c.fs.Chmod(hosts, inventoryPerm); err != nil {
+		return fmt.Errorf("%s %s: %w", errChmodInventory, runnerutil.Hosts, err)
+	}
+	return nil
+}
+
+// writeInventoryPlugins writes the configuration files of the inventory
+// plugins of the AnsibleRun, removing the ones of the plugins it no longer
+// uses, and gets the credentials of the plugins.
+func (c *connector) writeInventoryPlugins(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) error {
+	dir := filepath.Join(p.dir, runnerutil.InventoryDir)
+	plugins := make(map[string]bool, len(cr.Spec.ForProvider.InventoryPlugins))
+	for _, plugin := range cr.Spec.ForProvider.InventoryPlugins {
+		plugins[plugin.Name] = true
+		// JSON is YAML, the configuration is written as is
+		if err := c.fs.WriteFile(filepath.Join(dir, plugin.Name), plugin.Config.Raw, 0600); err != nil {
+			return fmt.Errorf("%s %s: %w", errWriteInventory, plugin.Name, err)
+		}
+		for _, cd := range plugin.Credentials {
+			data, err := resource.CommonCredentialExtractor(ctx, cd.Source, c.kube, cd.CommonCredentialSelectors)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", errGetInventoryPluginCreds, plugin.Name, err)
+			}
+			if p.inventoryVars == nil {
+				p.inventoryVars = make(map[string]string)
+			}
+			p.inventoryVars[cd.Name] = string(data)
+		}
+	}
+	files, err := c.fs.ReadDir(dir)
+	if resource.Ignore(os.IsNotExist, err) != nil {
+		return fmt.Errorf("%s %s: %w", errWriteInventory, runnerutil.InventoryDir, err)
+	}
+	for _, f := range files {
+		if ext := filepath.Ext(f.Name()); (ext != ".yml" && ext != ".yaml") || plugins[f.Name()] {
+			continue
+		}
+		if err := c.fs.Remove(filepath.Join(dir, f.Name())); resource.Ignore(os.IsNotExist, err) != nil {
+			return fmt.Errorf("%s %s: %w", errWriteInventory, f.Name(), err)
+		}
+	}
+	return nil
+}
+
+// writeCredentials writes the credentials of the ProviderConfig and the files
+// of the AnsibleRun next to the playbooks, so that they can be referenced
+// with relative paths, the responses to the interactive prompts of the runs,
+// and the vault passwords.
+func (c *connector) writeCredentials(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) error {
+	for _, cd := range p.pc.Spec.Credentials {
+		// the credentials the runs don't use are removed, in case they were
+		// used before
+		if !usedByRuns(cr, cd) {
+			if err := c.removeProviderCredentials(p.projectDir(), cd); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.writeProviderCredentials(ctx, p.projectDir(), cd); err != nil {
+			return err
+		}
+	}
+	if err := c.writeFiles(ctx, cr, p.dir); err != nil {
+		return err
+	}
+
+	if len(cr.Spec.ForProvider.Passwords) != 0 {
+		passwords := make(map[string]string, len(cr.Spec.ForProvider.Passwords))
+		for _, pw := range cr.Spec.ForProvider.Passwords {
+			data, err := resource.CommonCredentialExtractor(ctx, pw.Source, c.kube, pw.CommonCredentialSelectors)
+			if err != nil {
+				return fmt.Errorf("%s: %w", errGetPassword, err)
+			}
+			passwords[pw.Prompt] = string(data)
+		}
+		data, err := yaml.Marshal(passwords)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errMarshalPasswords, err)
+		}
+		if err := c.fs.WriteFile(filepath.Join(p.dir, runnerutil.EnvDir, runnerutil.Passwords), data, 0600); err != nil {
+			return fmt.Errorf("%s %s: %w", errWritePasswords, runnerutil.Passwords, err)
+		}
+	}
+
+	vaultIDs, err := c.writeVaultPasswords(ctx, p.dir, p.pc.Spec.VaultSecrets)
+	if err != nil {
+		return err
+	}
+	p.vaultIDs = vaultIDs
+
+	ssh, err := c.writeSSHCredentials(ctx, p.dir, p.pc.Spec.SSHCredentials)
+	if err != nil {
+		return err
+	}
+	p.ssh = ssh
+	return nil
+}
+
+// fetchContent fetches the contents of the AnsibleRun itself, e.g. its
+// inline playbooks.
+func (c *connector) fetchContent(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) error {
+	fetchers, err := c.contentFetchers(cr, p.projectDir())
+	if err != nil {
+		return err
+	}
+	return fetchContents(ctx, c.fs, p.dir, fetchers...)
+}
+
+// installDeps installs the roles and collections of the AnsibleRun and the
+// requirements of its ProviderConfig, installing the roles again when their
+// floating version moved.
+func (c *connector) installDeps(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) error {
+	if hasRequirements(cr.Spec.ForProvider) {
+		if err := c.writeGitCredentials(ctx, p); err != nil {
+			return err
+		}
+	}
+
+	// the tokens of the git repositories of the requirements and the
+	// .git-credentials are only passed to ansible-galaxy and git
+	gitTokenEnv, err := c.gitTokenEnv(ctx, p.pc.Spec.Requirements, cr.Spec.ForProvider)
+	if err != nil {
+		return err
+	}
+	if len(p.gitCredentialsEnv) != 0 {
+		env := make(map[string]string, len(gitTokenEnv)+len(p.gitCredentialsEnv))
+		for k, v := range gitTokenEnv {
+			env[k] = v
+		}
+		for k, v := range p.gitCredentialsEnv {
+			env[k] = v
+		}
+		gitTokenEnv = env
+	}
+	p.rolesMoved = checkRoleVersions(ctx, cr, runnerutil.ConvertMapToSlice(gitTokenEnv))
+	if p.galaxyToken, err = c.galaxyToken(ctx, p.pc); err != nil {
+		return err
+	}
+
+	cf, err := c.collectionsCacheFetcher(p, gitTokenEnv)
+	if err != nil {
+		return err
+	}
+	f, err := c.requirementsFetcher(cr, p, gitTokenEnv)
+	if err != nil {
+		return err
+	}
+	if cf == nil && f == nil {
+		return nil
+	}
+	remove, err := c.writeGalaxyCredentials(ctx, cr, p)
+	if err != nil {
+		return err
+	}
+	defer remove()
+	if cf != nil {
+		reinstall := p.pc.Spec.Galaxy.ReinstallOnDrift
+		drifted, err := fetchCollectionsCache(ctx, c.fs, p.collectionsCache, cf, reinstall)
+		if err != nil {
+			return err
+		}
+		setDependencyDrift(cr, p.pc.GetName(), drifted, reinstall)
+	}
+	if f == nil {
+		return nil
+	}
+	return fetchContents(ctx, c.fs, p.dir, f)
+}
+
+// writeProviderCredentials writes the supplied provider credentials into the
+// supplied project directory.
+func (c *connector) writeProviderCredentials(ctx context.Context, projectDir string, cd v1alpha1.ProviderCredentials) error {
+	if cd.Source == xpv1.CredentialsSourceFilesystem && cd.Fs != nil {
+		written, err := c.writeFsCredentialsDir(projectDir, cd)
+		if err != nil || written {
+			return err
+		}
+	}
+	data, err := resource.CommonCredentialExtractor(ctx, cd.Source, c.kube, cd.CommonCredentialSelectors)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errGetCreds, err)
+	}
+	path := filepath.Clean(filepath.Join(projectDir, filepath.Base(cd.Filename)))
+	if err := c.fs.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteCreds, err)
+	}
+	return nil
+}
+
+// removeProviderCredentials removes the supplied provider credentials from
+// the supplied project directory, if they are there.
+func (c *connector) removeProviderCredentials(projectDir string, cd v1alpha1.ProviderCredentials) error {
+	path := filepath.Clean(filepath.Join(projectDir, filepath.Base(cd.Filename)))
+	if err := c.fs.RemoveAll(path); err != nil {
+		return fmt.Errorf("%s: %w", errRemoveCreds, err)
+	}
+	return nil
+}
+
+// usedFor tells whether the supplied provider credentials are used for the
+// supplied usage. Credentials without usage are used for everything, but only
+// .git-credentials is used by git.
+func usedFor(cd v1alpha1.ProviderCredentials, usage v1alpha1.CredentialUsage) bool {
+	if len(cd.Usage) == 0 {
+		return usage != v1alpha1.CredentialUsageGit || cd.Filename == gitCredentialsFilename
+	}
+	for _, u := range cd.Usage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// usedByRuns tells whether the supplied provider credentials are written for
+// the runs of the supplied AnsibleRun.
+func usedByRuns(cr *v1alpha1.AnsibleRun, cd v1alpha1.ProviderCredentials) bool {
+	return usedFor(cd, v1alpha1.CredentialUsagePlaybook) && !cr.Spec.ForProvider.SkipProviderCredentials
+}
+
+// writeGalaxyCredentials writes the provider credentials used by
+// ansible-galaxy that are not written for the runs, and returns a function
+// removing them once the requirements are installed.
+func (c *connector) writeGalaxyCredentials(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) (func(), error) {
+	var written []v1alpha1.ProviderCredentials
+	remove := func() {
+		for _, cd := range written {
+			// a credential left behind is removed by the next reconcile
+			_ = c.removeProviderCredentials(p.projectDir(), cd)
+		}
+	}
+	for _, cd := range p.pc.Spec.Credentials {
+		if !usedFor(cd, v1alpha1.CredentialUsageGalaxy) || usedByRuns(cr, cd) {
+			continue
+		}
+		written = append(written, cd)
+		if err := c.writeProviderCredentials(ctx, p.projectDir(), cd); err != nil {
+			remove()
+			return nil, err
+		}
+	}
+	return remove, nil
+}
+
+// writeGitCredentials writes the .git-credentials of the ProviderConfig for
+// ansible-galaxy to fetch the remote roles, along with a git config using
+// them. They are only passed to the commands of the AnsibleRun through their
+// environment, so that concurrent reconciles don't see each other's.
+func (c *connector) writeGitCredentials(ctx context.Context, p *preparation) error {
+	// TODO(fahed) support other private remote repository
+	// NOTE(ytsarev): Retrieve .git-credentials from Spec to /tmp outside of AnsibleRun directory
+	gitCredDir := filepath.Clean(filepath.Join(gitCredentialsRoot, p.dir))
+	if err := c.fs.MkdirAll(gitCredDir, 0700); err != nil {
+		return fmt.Errorf("%s: %w", errWriteGitCreds, err)
+	}
+	var helpers []string
+	for _, cd := range p.pc.Spec.Credentials {
+		if !usedFor(cd, v1alpha1.CredentialUsageGit) {
+			continue
+		}
+		data, err := resource.CommonCredentialExtractor(ctx, cd.Source, c.kube, cd.CommonCredentialSelectors)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errGetCreds, err)
+		}
+		path := filepath.Clean(filepath.Join(gitCredDir, filepath.Base(cd.Filename)))
+		if err := c.fs.WriteFile(path, data, 0600); err != nil {
+			return fmt.Errorf("%s: %w", errWriteGitCreds, err)
+		}
+		helpers = append(helpers, fmt.Sprintf("\thelper = store --file=%s\n", path))
+	}
+	if len(helpers) == 0 {
+		return nil
+	}
+	config := filepath.Join(gitCredDir, gitConfigFile)
+	if err := c.fs.WriteFile(config, []byte("[credential]\n"+strings.Join(helpers, "")), 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteGitCreds, err)
+	}
+	p.gitCredentialsEnv = map[string]string{
+		"GIT_CONFIG_GLOBAL": config,
+		// NOTE(ytsarev): Make go-getter pick up .git-credentials
+		"GIT_CRED_DIR": gitCredDir,
+	}
+	return nil
+}
+
+// initRunner initializes the runner of the AnsibleRun, and returns the
+// ExternalClient running it.
+func (c *connector) initRunner(ctx context.Context, cr *v1alpha1.AnsibleRun, p *preparation) (*external, error) {
+	// the credentials of the inventory plugins and the token of the
+	// impersonated ServiceAccount are only passed to the runs, not to
+	// ansible-galaxy
+	runVars := p.vars
+	if len(p.inventoryVars) != 0 || cr.Spec.ForProvider.ServiceAccount != nil || p.collectionsCache != "" {
+		runVars = make(map[string]string, len(p.vars)+len(p.inventoryVars)+1)
+		for k, v := range p.vars {
+			runVars[k] = v
+		}
+		for k, v := range p.inventoryVars {
+			runVars[k] = v
+		}
+	}
+	// the runs find the cached collections first, while ansible-galaxy
+	// keeps installing the collections of the AnsibleRun in the first of
+	// the collections paths
+	if p.collectionsCache != "" {
+		runVars[ansible.CollectionsPathEnv] = ansible.CollectionsPath(filepath.Join(p.collectionsCache, collectionsSubdir), p.vars)
+	}
+	if ssh := p.pc.Spec.SSHCredentials; ssh != nil {
+		runVars[ansible.HostKeyCheckingEnv] = "False"
+		if strictHostKeyChecking(ssh) {
+			runVars[ansible.HostKeyCheckingEnv] = "True"
+		}
+	}
+	if cr.Spec.ForProvider.ServiceAccount != nil {
+		impersonationVars, err := c.impersonationVars(ctx, p.dir, cr.Spec.ForProvider.ServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range impersonationVars {
+			runVars[k] = v
+		}
+	}
+
+	r, err := p.ps.Init(ctx, cr, runVars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errInit, err)
+	}
+
+	je, err := c.jobExecutorOf(p.pc)
+	if err != nil {
+		return nil, err
+	}
+	r.SetJobExecutor(je)
+	r.SetVaultIDs(p.vaultIDs)
+	r.SetSSH(p.ssh)
+	if p.collectionsCache != "" {
+		r.AddRequirementsPath(filepath.Join(p.collectionsCache, galaxyutil.RequirementsFile))
+		r.AddSharedDir(p.collectionsCache)
+	}
+	if err := r.SetDefaultVars(p.pc.Spec.ExtraVars); err != nil {
+		return nil, fmt.Errorf("%s: %w", errInit, err)
+	}
+	varsFrom, fromSecrets, err := c.varsFrom(ctx, cr)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.SetVarsFrom(varsFrom, fromSecrets); err != nil {
+		return nil, fmt.Errorf("%s: %w", errInit, err)
+	}
+	varsFromHash, err := varsFromHash(varsFrom)
+	if err != nil {
+		return nil, err
+	}
+	extraVarsHash, err := extraVarsHash(p.pc.Spec.ExtraVars)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &external{runner: r, kube: c.kube, rolesMoved: p.rolesMoved, varsFromHash: varsFromHash, extraVarsHash: extraVarsHash, audit: c.audit, fs: c.fs, workDir: p.dir, artifactsDir: artifactsPath(c.artifactsRoot, p.dir), recorder: c.recorder, admin: c.admin, groups: c.groups,
+		pressure: c.pressure, pollInterval: c.pollInterval, pollJitter: c.pollJitter, driftStorage: p.pc.Spec.DriftStorage}
+	if p.pc.Spec.LockHosts {
+		e.hosts = c.hosts
+	}
+	r.SetStatusHandler(e.phaseHandler(cr))
+	r.SetProgressHandler(e.heartbeatHandler(cr), heartbeatInterval)
+	r.SetTaskEventHandler(e.taskEventHandler(cr))
+	if p.pc.Spec.Policy != nil {
+		r.SetDeniedModules(p.pc.Spec.Policy.DeniedModules)
+		if p.pc.Spec.Policy.Admission != nil {
+			r.SetAdmissionURL(p.pc.Spec.Policy.Admission.URL)
+		}
+	}
+	if p.pc.Spec.Reports != nil {
+		r.SetReports(p.pc.Spec.Reports.JUnit, p.pc.Spec.Reports.UploadURL)
+	}
+	return e, nil
+}
@@ -0,0 +1,431 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	"github.com/crossplane-contrib/provider-ansible/pkg/galaxyutil"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+func TestFailStage(t *testing.T) {
+	errBoom := errors.New("boom")
+	cr := &v1alpha1.AnsibleRun{}
+	cr.SetConditions(prepared())
+
+	err := failStage(cr, stageInstallDeps, errBoom)
+	var se *stageError
+	if !errors.As(err, &se) || se.Stage != stageInstallDeps {
+		t.Errorf("failStage(...): want a stageError of stage %s, got %v", stageInstallDeps, err)
+	}
+	if !errors.Is(err, errBoom) {
+		t.Errorf("failStage(...): want the error of the stage to be wrapped, got %v", err)
+	}
+	want := xpv1.Condition{Type: v1alpha1.TypePrepared, Status: v1.ConditionFalse, Reason: "InstallDepsFailed", Message: "boom"}
+	if diff := cmp.Diff(want, cr.GetCondition(v1alpha1.TypePrepared), cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime")); diff != "" {
+		t.Errorf("failStage(...): -want condition, +got condition:\n%s", diff)
+	}
+}
+
+func TestWriteInventory(t *testing.T) {
+	inline := "web-2"
+	windows := "\ufeff[web]\r\nweb-2\r\n"
+	cases := map[string]struct {
+		reason   string
+		params   v1alpha1.AnsibleRunParameters
+		want     string
+		wantPerm os.FileMode
+	}{
+		"NoInventory": {
+			reason: "We should not write a hosts file without inventories",
+		},
+		"Inventories": {
+			reason: "We should write the inventories of the sources followed by the inline inventory",
+			params: v1alpha1.AnsibleRunParameters{
+				Inventories:     []v1alpha1.Inventory{{Source: xpv1.CredentialsSourceNone}},
+				InventoryInline: &inline,
+			},
+			want:     "\nweb-2\n",
+			wantPerm: 0600,
+		},
+		"WindowsInventory": {
+			reason: "We should normalize the inline inventories authored on Windows",
+			params: v1alpha1.AnsibleRunParameters{
+				InventoryInline: &windows,
+			},
+			want:     "[web]\nweb-2\n\n",
+			wantPerm: 0600,
+		},
+		"ExecutableInventory": {
+			reason: "We should make the hosts file executable for the inventory scripts",
+			params: v1alpha1.AnsibleRunParameters{
+				InventoryInline:     &inline,
+				ExecutableInventory: true,
+			},
+			want:     "web-2\n",
+			wantPerm: 0700,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			c := &connector{fs: fs}
+			p := &preparation{dir: filepath.Join(baseWorkingDir, string(uid))}
+			cr := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: tc.params}}
+			if err := c.writeInventory(context.Background(), cr, p); err != nil {
+				t.Fatalf("\n%s\nwriteInventory(...): unexpected error: %v", tc.reason, err)
+			}
+			hosts := filepath.Join(p.dir, runnerutil.InventoryDir, runnerutil.Hosts)
+			got, err := fs.ReadFile(hosts)
+			if tc.want == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("\n%s\nwriteInventory(...): want no hosts file, got %q", tc.reason, got)
+				}
+				return
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nwriteInventory(...): -want hosts, +got hosts:\n%s", tc.reason, diff)
+			}
+			fi, err := fs.Stat(hosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.wantPerm, fi.Mode().Perm()); diff != "" {
+				t.Errorf("\n%s\nwriteInventory(...): -want permissions, +got permissions:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWriteInventoryPlugins(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	c := &connector{
+		fs: fs,
+		kube: &test.MockClient{
+			MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+				s := obj.(*v1.Secret)
+				s.Data = map[string][]byte{"key": []byte("secret")}
+				return nil
+			}),
+		},
+	}
+	p := &preparation{dir: filepath.Join(baseWorkingDir, string(uid))}
+	dir := filepath.Join(p.dir, runnerutil.InventoryDir)
+	stale := filepath.Join(dir, "k8s.yml")
+	if err := fs.WriteFile(stale, []byte("plugin: kubernetes.core.k8s\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cr := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+		InventoryPlugins: []v1alpha1.InventoryPlugin{{
+			Name:   "aws_ec2.yml",
+			Config: runtime.RawExtension{Raw: []byte(`{"plugin":"amazon.aws.aws_ec2"}`)},
+			Credentials: []v1alpha1.InventoryPluginCredentials{{
+				Name:   "AWS_SECRET_ACCESS_KEY",
+				Source: xpv1.CredentialsSourceSecret,
+				CommonCredentialSelectors: xpv1.CommonCredentialSelectors{
+					SecretRef: &xpv1.SecretKeySelector{Key: "key"},
+				},
+			}},
+		}},
+	}}}
+	if err := c.writeInventory(context.Background(), cr, p); err != nil {
+		t.Fatalf("writeInventory(...): unexpected error: %v", err)
+	}
+	got, err := fs.ReadFile(filepath.Join(dir, "aws_ec2.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`{"plugin":"amazon.aws.aws_ec2"}`, string(got)); diff != "" {
+		t.Errorf("writeInventory(...): -want plugin config, +got plugin config:\n%s", diff)
+	}
+	if _, err := fs.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("writeInventory(...): the config of the plugins no longer used should be removed")
+	}
+	if diff := cmp.Diff(map[string]string{"AWS_SECRET_ACCESS_KEY": "secret"}, p.inventoryVars); diff != "" {
+		t.Errorf("writeInventory(...): -want inventory vars, +got inventory vars:\n%s", diff)
+	}
+}
+
+func TestInstallDepsGalaxyServer(t *testing.T) {
+	var got ansible.GalaxyOptions
+	c := &connector{
+		fs: afero.Afero{Fs: afero.NewMemMapFs()},
+		kube: &test.MockClient{MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+			obj.(*v1.Secret).Data = map[string][]byte{"token": []byte("secret\n")}
+			return nil
+		})},
+	}
+	p := &preparation{
+		dir: filepath.Join(baseWorkingDir, string(uid)),
+		pc: &v1alpha1.ProviderConfig{Spec: v1alpha1.ProviderConfigSpec{Galaxy: &v1alpha1.GalaxyOptions{
+			Server:         "https://hub.example.com/api/galaxy/",
+			TokenSecretRef: &xpv1.SecretKeySelector{SecretReference: xpv1.SecretReference{Name: "hub", Namespace: "crossplane-system"}, Key: "token"},
+		}}},
+		ps: MockPs{
+			MockGalaxyInstall: func(_ context.Context, _ map[string]string, _ string, opts ansible.GalaxyOptions) error {
+				got = opts
+				return nil
+			},
+		},
+	}
+	cr := &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{UID: uid},
+		Spec:       v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{Collections: []v1alpha1.Collection{{Name: "community.general"}}}},
+	}
+	if err := c.installDeps(context.Background(), cr, p); err != nil {
+		t.Fatalf("installDeps(...): unexpected error: %v", err)
+	}
+	want := ansible.GalaxyOptions{Server: "https://hub.example.com/api/galaxy/", ServerToken: "secret"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("installDeps(...): -want options, +got options:\n%s", diff)
+	}
+}
+
+func TestInstallDepsCollectionsCache(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	var installs []string
+	c := &connector{fs: fs}
+	pc := &v1alpha1.ProviderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.ProviderConfigSpec{
+			Galaxy:       &v1alpha1.GalaxyOptions{CacheCollections: true},
+			Requirements: &v1alpha1.Requirements{Collections: []v1alpha1.Collection{{Name: "community.general"}}},
+		},
+	}
+	ps := MockPs{
+		MockGalaxyInstall: func(_ context.Context, _ map[string]string, requirementsType string, opts ansible.GalaxyOptions) error {
+			installs = append(installs, requirementsType+":"+opts.CollectionsPath)
+			return nil
+		},
+	}
+	cache := filepath.Join(baseWorkingDir, collectionsCacheDir, "default")
+
+	// the second AnsibleRun of the ProviderConfig finds its collections
+	// cached
+	for _, id := range []types.UID{"run-1", "run-2"} {
+		p := &preparation{dir: filepath.Join(baseWorkingDir, string(id)), pc: pc, ps: ps}
+		cr := &v1alpha1.AnsibleRun{
+			ObjectMeta: metav1.ObjectMeta{UID: id},
+			Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+				Collections: []v1alpha1.Collection{{Name: "amazon.aws"}},
+			}},
+		}
+		if err := c.installDeps(context.Background(), cr, p); err != nil {
+			t.Fatalf("installDeps(...): unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(cache, p.collectionsCache); diff != "" {
+			t.Errorf("installDeps(...): -want cache, +got cache:\n%s", diff)
+		}
+		req, err := fs.ReadFile(filepath.Join(p.projectDir(), galaxyutil.RequirementsFile))
+		if err != nil {
+			t.Fatalf("installDeps(...): the requirements file should be written: %v", err)
+		}
+		if diff := cmp.Diff("collections:\n- name: amazon.aws\n", string(req)); diff != "" {
+			t.Errorf("installDeps(...): -want requirements, +got requirements:\n%s", diff)
+		}
+	}
+
+	want := []string{"collection:" + filepath.Join(cache, collectionsSubdir), "collection:", "collection:"}
+	if diff := cmp.Diff(want, installs); diff != "" {
+		t.Errorf("installDeps(...): -want installs, +got installs:\n%s", diff)
+	}
+	req, err := fs.ReadFile(filepath.Join(cache, galaxyutil.RequirementsFile))
+	if err != nil {
+		t.Fatalf("installDeps(...): the requirements file of the cache should be written: %v", err)
+	}
+	if diff := cmp.Diff("collections:\n- name: community.general\n", string(req)); diff != "" {
+		t.Errorf("installDeps(...): -want cache requirements, +got cache requirements:\n%s", diff)
+	}
+}
+
+func TestUsedFor(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		cd     v1alpha1.ProviderCredentials
+		usage  v1alpha1.CredentialUsage
+		want   bool
+	}{
+		"NoUsage": {
+			reason: "Credentials without usage should be used by the playbooks",
+			cd:     v1alpha1.ProviderCredentials{Filename: "key"},
+			usage:  v1alpha1.CredentialUsagePlaybook,
+			want:   true,
+		},
+		"NoUsageGit": {
+			reason: "Credentials without usage should not be used by git, unless they are .git-credentials",
+			cd:     v1alpha1.ProviderCredentials{Filename: "key"},
+			usage:  v1alpha1.CredentialUsageGit,
+		},
+		"NoUsageGitCredentials": {
+			reason: ".git-credentials without usage should be used by git",
+			cd:     v1alpha1.ProviderCredentials{Filename: gitCredentialsFilename},
+			usage:  v1alpha1.CredentialUsageGit,
+			want:   true,
+		},
+		"OtherUsage": {
+			reason: "Credentials should only be used for their usages",
+			cd:     v1alpha1.ProviderCredentials{Filename: "token", Usage: []v1alpha1.CredentialUsage{v1alpha1.CredentialUsageGalaxy}},
+			usage:  v1alpha1.CredentialUsagePlaybook,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := usedFor(tc.cd, tc.usage); got != tc.want {
+				t.Errorf("\n%s\nusedFor(...): want %t, got %t", tc.reason, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteCredentialsUsage(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	c := &connector{
+		fs: fs,
+		kube: &test.MockClient{
+			MockGet: test.NewMockGetFn(nil, func(obj client.Object) error {
+				obj.(*v1.Secret).Data = map[string][]byte{"key": []byte("secret")}
+				return nil
+			}),
+		},
+	}
+	secret := xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{Key: "key"}}
+	p := &preparation{
+		dir: filepath.Join(baseWorkingDir, string(uid)),
+		pc: &v1alpha1.ProviderConfig{Spec: v1alpha1.ProviderConfigSpec{Credentials: []v1alpha1.ProviderCredentials{
+			{Filename: "playbook-key", Source: xpv1.CredentialsSourceSecret, CommonCredentialSelectors: secret},
+			{Filename: "galaxy-token", Source: xpv1.CredentialsSourceSecret, CommonCredentialSelectors: secret, Usage: []v1alpha1.CredentialUsage{v1alpha1.CredentialUsageGalaxy}},
+		}}},
+	}
+	exists := func(name string) bool {
+		_, err := fs.Stat(filepath.Join(p.projectDir(), name))
+		return err == nil
+	}
+	// written by a previous reconcile
+	if err := fs.WriteFile(filepath.Join(p.projectDir(), "galaxy-token"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &v1alpha1.AnsibleRun{}
+	if err := c.writeCredentials(context.Background(), cr, p); err != nil {
+		t.Fatalf("writeCredentials(...): unexpected error: %v", err)
+	}
+	if !exists("playbook-key") || exists("galaxy-token") {
+		t.Errorf("writeCredentials(...): only the credentials used by the playbooks should be written")
+	}
+
+	remove, err := c.writeGalaxyCredentials(context.Background(), cr, p)
+	if err != nil {
+		t.Fatalf("writeGalaxyCredentials(...): unexpected error: %v", err)
+	}
+	if !exists("galaxy-token") {
+		t.Errorf("writeGalaxyCredentials(...): the credentials used by ansible-galaxy should be written")
+	}
+	remove()
+	if exists("galaxy-token") || !exists("playbook-key") {
+		t.Errorf("writeGalaxyCredentials(...): only the credentials written for ansible-galaxy should be removed")
+	}
+
+	cr.Spec.ForProvider.SkipProviderCredentials = true
+	if err := c.writeCredentials(context.Background(), cr, p); err != nil {
+		t.Fatalf("writeCredentials(...): unexpected error: %v", err)
+	}
+	if exists("playbook-key") {
+		t.Errorf("writeCredentials(...): the credentials of the runs opting out should be removed")
+	}
+}
+
+func TestInstallDeps(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	cases := map[string]struct {
+		reason       string
+		params       v1alpha1.AnsibleRunParameters
+		galaxyErr    error
+		want         error
+		wantInstalls []string
+	}{
+		"NoRequirements": {
+			reason: "We should not run ansible-galaxy without requirements",
+		},
+		"Roles": {
+			reason:       "We should install the roles of the AnsibleRun",
+			params:       v1alpha1.AnsibleRunParameters{Roles: []v1alpha1.Role{{Name: "role", Src: "https://example.com/role.git"}}},
+			wantInstalls: []string{"role"},
+		},
+		"GalaxyError": {
+			reason:       "We should return the errors of ansible-galaxy",
+			params:       v1alpha1.AnsibleRunParameters{Collections: []v1alpha1.Collection{{Name: "community.general"}}},
+			galaxyErr:    errBoom,
+			want:         errBoom,
+			wantInstalls: []string{"collection"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			var installs []string
+			c := &connector{fs: fs}
+			p := &preparation{
+				dir: filepath.Join(baseWorkingDir, string(uid)),
+				pc:  &v1alpha1.ProviderConfig{},
+				ps: MockPs{
+					MockGalaxyInstall: func(_ context.Context, _ map[string]string, requirementsType string, _ ansible.GalaxyOptions) error {
+						installs = append(installs, requirementsType)
+						return tc.galaxyErr
+					},
+				},
+			}
+			cr := &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{UID: uid},
+				Spec:       v1alpha1.AnsibleRunSpec{ForProvider: tc.params},
+			}
+			err := c.installDeps(context.Background(), cr, p)
+			if diff := cmp.Diff(tc.want, err, test.EquateErrors()); diff != "" {
+				t.Errorf("\n%s\ninstallDeps(...): -want error, +got error:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.wantInstalls, installs); diff != "" {
+				t.Errorf("\n%s\ninstallDeps(...): -want installs, +got installs:\n%s", tc.reason, diff)
+			}
+			if len(tc.wantInstalls) != 0 {
+				if _, err := fs.Stat(filepath.Join(p.projectDir(), galaxyutil.RequirementsFile)); err != nil {
+					t.Errorf("\n%s\ninstallDeps(...): the requirements file should be written: %v", tc.reason, err)
+				}
+			}
+		})
+	}
+}
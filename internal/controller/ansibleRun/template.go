@@ -0,0 +1,190 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	errTemplateVars = "cannot decode the vars of the inline templates"
+	errTemplate     = "cannot render the inline template"
+	errRequired     = "required value missing"
+
+	// the delimiters of the actions of the inline templates, the Jinja
+	// expressions of Ansible taking {{ and }}
+	templateLeftDelim  = "[["
+	templateRightDelim = "]]"
+
+	// the claim namespace of the AnsibleRuns made by an AnsibleRunClaim, or
+	// composed by Crossplane
+	annotationKeyClaimNamespace = "ansible.crossplane.io/claim-namespace"
+	labelKeyClaimNamespace      = "crossplane.io/claim-namespace"
+)
+
+// templateValues are the values the inline templates of an AnsibleRun may
+// refer to, only the ones the provider knows of.
+type templateValues struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace,omitempty"`
+	Vars      map[string]interface{} `json:"vars,omitempty"`
+}
+
+// inlineTemplateValues returns the values of the inline templates of the
+// supplied AnsibleRun, or nil when its inline contents are no templates.
+func inlineTemplateValues(cr *v1alpha1.AnsibleRun) (*templateValues, error) {
+	if !cr.Spec.ForProvider.TemplateInline {
+		return nil, nil
+	}
+	v := &templateValues{Name: cr.GetName(), Namespace: cr.GetAnnotations()[annotationKeyClaimNamespace]}
+	if v.Namespace == "" {
+		v.Namespace = cr.GetLabels()[labelKeyClaimNamespace]
+	}
+	if raw := cr.Spec.ForProvider.Vars.Raw; len(raw) != 0 {
+		if err := json.Unmarshal(raw, &v.Vars); err != nil {
+			return nil, fmt.Errorf("%s: %w", errTemplateVars, err)
+		}
+	}
+	return v, nil
+}
+
+// renderInline renders the supplied inline content, named after its file, as
+// a template of the supplied values. The content is returned as is without
+// values.
+func renderInline(file, content string, values *templateValues) (string, error) {
+	if values == nil {
+		return content, nil
+	}
+	t, err := template.New(file).
+		Delims(templateLeftDelim, templateRightDelim).
+		Option("missingkey=error").
+		Funcs(templateFuncs).
+		Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", errTemplate, file, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("%s %s: %w", errTemplate, file, err)
+	}
+	return b.String(), nil
+}
+
+// templateFuncs are the functions of the inline templates, a subset of the
+// sprig ones with the same semantics. None of them reaches the environment,
+// the filesystem or the network of the provider.
+var templateFuncs = template.FuncMap{
+	"default":    defaultValue,
+	"empty":      empty,
+	"required":   required,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(o, n, s string) string { return strings.ReplaceAll(s, o, n) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"quote":      func(v interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(v)) },
+	"squote":     func(v interface{}) string { return "'" + fmt.Sprint(v) + "'" },
+	"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"indent":     indent,
+	"nindent":    func(n int, s string) string { return "\n" + indent(n, s) },
+	"toJson":     toJSON,
+	"toYaml":     toYAML,
+	"b64enc":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":     b64dec,
+}
+
+// defaultValue returns v, or d when v is empty.
+func defaultValue(d, v interface{}) interface{} {
+	if empty(v) {
+		return d
+	}
+	return v
+}
+
+// empty tells whether v is nil or the zero value of its type, an empty
+// collection being empty.
+func empty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return true
+	}
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	default:
+		return rv.IsZero()
+	}
+}
+
+// required returns v, or an error with the supplied message when v is empty.
+func required(msg string, v interface{}) (interface{}, error) {
+	if empty(v) {
+		if msg == "" {
+			msg = errRequired
+		}
+		return nil, errors.New(msg)
+	}
+	return v, nil
+}
+
+// join joins the elements of the supplied list with the supplied separator.
+func join(sep string, list interface{}) string {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Array && rv.Kind() != reflect.Slice {
+		return fmt.Sprint(list)
+	}
+	elems := make([]string, rv.Len())
+	for i := range elems {
+		elems[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(elems, sep)
+}
+
+// indent indents each line of s by n spaces.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func toYAML(v interface{}) (string, error) {
+	b, err := yaml.Marshal(v)
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
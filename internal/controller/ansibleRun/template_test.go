@@ -0,0 +1,148 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestInlineTemplateValues(t *testing.T) {
+	cases := map[string]struct {
+		reason  string
+		cr      *v1alpha1.AnsibleRun
+		want    *templateValues
+		wantErr bool
+	}{
+		"NoTemplate": {
+			reason: "We should not render the inline contents unless asked to",
+			cr:     &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		},
+		"Claim": {
+			reason: "We should take the namespace of the claim of the AnsibleRun",
+			cr: &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a-web", Annotations: map[string]string{annotationKeyClaimNamespace: "team-a"}},
+				Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+					TemplateInline: true,
+					Vars:           runtime.RawExtension{Raw: []byte(`{"port":8080}`)},
+				}},
+			},
+			want: &templateValues{Name: "team-a-web", Namespace: "team-a", Vars: map[string]interface{}{"port": float64(8080)}},
+		},
+		"Composed": {
+			reason: "We should take the namespace of the claim of the composite resource composing the AnsibleRun",
+			cr: &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-x7k2p", Labels: map[string]string{labelKeyClaimNamespace: "team-b"}},
+				Spec:       v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{TemplateInline: true}},
+			},
+			want: &templateValues{Name: "web-x7k2p", Namespace: "team-b"},
+		},
+		"InvalidVars": {
+			reason: "We should fail on vars that are not an object",
+			cr: &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{
+				TemplateInline: true,
+				Vars:           runtime.RawExtension{Raw: []byte(`["port"]`)},
+			}}},
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := inlineTemplateValues(tc.cr)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("\n%s\ninlineTemplateValues(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\ninlineTemplateValues(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRenderInline(t *testing.T) {
+	values := &templateValues{
+		Name:      "web",
+		Namespace: "team-a",
+		Vars:      map[string]interface{}{"port": float64(8080), "hosts": []interface{}{"web1", "web2"}},
+	}
+
+	cases := map[string]struct {
+		reason  string
+		content string
+		values  *templateValues
+		want    string
+		wantErr bool
+	}{
+		"NoValues": {
+			reason:  "We should leave the contents as they are without values",
+			content: "- hosts: [[ .Name ]]\n",
+			want:    "- hosts: [[ .Name ]]\n",
+		},
+		"Values": {
+			reason:  "We should render the values the provider injects",
+			content: "[[ .Namespace ]]-[[ .Name ]]:[[ .Vars.port ]]",
+			values:  values,
+			want:    "team-a-web:8080",
+		},
+		"Jinja": {
+			reason:  "We should leave the Jinja expressions to Ansible",
+			content: "msg: \"{{ inventory_hostname }} of [[ .Name | upper ]]\"",
+			values:  values,
+			want:    "msg: \"{{ inventory_hostname }} of WEB\"",
+		},
+		"Functions": {
+			reason:  "We should provide a subset of the sprig functions",
+			content: "[[ .Vars.hosts | join \",\" ]] [[ .Vars.user | default \"root\" | quote ]] [[ .Vars.hosts | toJson | b64enc ]]",
+			values:  &templateValues{Vars: map[string]interface{}{"hosts": values.Vars["hosts"], "user": ""}},
+			want:    `web1,web2 "root" WyJ3ZWIxIiwid2ViMiJd`,
+		},
+		"MissingValue": {
+			reason:  "We should fail on a value the provider doesn't inject",
+			content: "[[ .Vars.missing ]]",
+			values:  values,
+			wantErr: true,
+		},
+		"Required": {
+			reason:  "We should fail on a required empty value",
+			content: "[[ required \"the namespace is required\" .Namespace ]]",
+			values:  &templateValues{Name: "web"},
+			wantErr: true,
+		},
+		"UnknownFunction": {
+			reason:  "We should fail on the functions out of the subset",
+			content: "[[ env \"HOME\" ]]",
+			values:  values,
+			wantErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := renderInline("playbook.yml", tc.content, tc.values)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("\n%s\nrenderInline(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nrenderInline(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
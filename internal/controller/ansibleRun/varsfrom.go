@@ -0,0 +1,220 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	errGetVarsFrom    = "cannot get the vars from"
+	errNoVarsFromKey  = "cannot find the key of the vars from"
+	errHashVarsFrom   = "cannot hash the vars from"
+	errIndexVarsFrom  = "cannot index the vars from of the AnsibleRuns"
+	errListVarsFromOf = "cannot list the AnsibleRuns reading their vars from"
+
+	errHashExtraVars       = "cannot hash the extra vars of the ProviderConfig"
+	errIndexProviderConfig = "cannot index the ProviderConfigs of the AnsibleRuns"
+	errListProviderConfig  = "cannot list the AnsibleRuns using the ProviderConfig"
+
+	// varsFromIndex indexes the AnsibleRuns by the Secrets and ConfigMaps
+	// they read their vars from, so that they run again when these change
+	varsFromIndex = "spec.forProvider.varsFrom"
+
+	// providerConfigIndex indexes the AnsibleRuns by their ProviderConfig,
+	// so that they run again when its extra vars change
+	providerConfigIndex = "spec.providerConfigRef.name"
+)
+
+// varsFromKey returns the key of the supplied object in the varsFrom index.
+func varsFromKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// indexVarsFrom returns the keys of the Secrets and ConfigMaps the supplied
+// AnsibleRun reads its vars from.
+func indexVarsFrom(obj client.Object) []string {
+	cr, ok := obj.(*v1alpha1.AnsibleRun)
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(cr.Spec.ForProvider.VarsFrom))
+	for _, src := range cr.Spec.ForProvider.VarsFrom {
+		keys = append(keys, varsFromKey(src.Kind, src.Namespace, src.Name))
+	}
+	return keys
+}
+
+// runsReadingVarsFrom returns the requests reconciling the AnsibleRuns that
+// read their vars from the mapped object of the supplied kind.
+func runsReadingVarsFrom(kube client.Reader, kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		l := &v1alpha1.AnsibleRunList{}
+		if err := kube.List(ctx, l, client.MatchingFields{varsFromIndex: varsFromKey(kind, obj.GetNamespace(), obj.GetName())}); err != nil {
+			log.FromContext(ctx).Info(errListVarsFromOf, "kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName(), "err", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(l.Items))
+		for _, cr := range l.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: cr.GetName()}})
+		}
+		return reqs
+	}
+}
+
+// varsFrom returns the extra vars the supplied AnsibleRun reads from Secrets
+// and ConfigMaps, the later sources overriding the earlier ones, and whether
+// any of them is read from a Secret.
+func (c *connector) varsFrom(ctx context.Context, cr *v1alpha1.AnsibleRun) (map[string]string, bool, error) {
+	var vars map[string]string
+	fromSecrets := false
+	for _, src := range cr.Spec.ForProvider.VarsFrom {
+		data, err := c.objectData(ctx, src.Kind, src.Namespace, src.Name)
+		if kerrors.IsNotFound(err) && src.Optional {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("%s %s %s/%s: %w", errGetVarsFrom, src.Kind, src.Namespace, src.Name, err)
+		}
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		if src.Key != "" {
+			value, ok := data[src.Key]
+			if !ok {
+				if src.Optional {
+					continue
+				}
+				return nil, false, fmt.Errorf("%s %s %s/%s: %s", errNoVarsFromKey, src.Kind, src.Namespace, src.Name, src.Key)
+			}
+			name := src.Var
+			if name == "" {
+				name = src.Key
+			}
+			vars[name] = value
+		} else {
+			for k, v := range data {
+				vars[src.Prefix+k] = v
+			}
+		}
+		fromSecrets = fromSecrets || src.Kind != kindConfigMap
+	}
+	return vars, fromSecrets, nil
+}
+
+// objectData returns the data of the supplied Secret or ConfigMap, by key,
+// including the binary data of a ConfigMap.
+func (c *connector) objectData(ctx context.Context, kind, namespace, name string) (map[string]string, error) {
+	nn := types.NamespacedName{Namespace: namespace, Name: name}
+	if kind == kindConfigMap {
+		cm := &v1.ConfigMap{}
+		if err := c.kube.Get(ctx, nn, cm); err != nil {
+			return nil, err
+		}
+		data := make(map[string]string, len(cm.Data)+len(cm.BinaryData))
+		for k, v := range cm.BinaryData {
+			data[k] = string(v)
+		}
+		for k, v := range cm.Data {
+			data[k] = v
+		}
+		return data, nil
+	}
+	s := &v1.Secret{}
+	if err := c.kube.Get(ctx, nn, s); err != nil {
+		return nil, err
+	}
+	data := make(map[string]string, len(s.Data))
+	for k, v := range s.Data {
+		data[k] = string(v)
+	}
+	return data, nil
+}
+
+// varsFromHash returns the digest of the supplied vars, empty without vars
+// so that the AnsibleRuns not reading vars from don't record one.
+func varsFromHash(vars map[string]string) (string, error) {
+	if len(vars) == 0 {
+		return "", nil
+	}
+	h, err := checksum(vars)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errHashVarsFrom, err)
+	}
+	return h, nil
+}
+
+// indexProviderConfig returns the name of the ProviderConfig of the supplied
+// AnsibleRun.
+func indexProviderConfig(obj client.Object) []string {
+	cr, ok := obj.(*v1alpha1.AnsibleRun)
+	if !ok || cr.GetProviderConfigReference() == nil {
+		return nil
+	}
+	return []string{cr.GetProviderConfigReference().Name}
+}
+
+// runsUsingProviderConfig returns the requests reconciling the AnsibleRuns
+// using the mapped ProviderConfig.
+func runsUsingProviderConfig(kube client.Reader) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		l := &v1alpha1.AnsibleRunList{}
+		if err := kube.List(ctx, l, client.MatchingFields{providerConfigIndex: obj.GetName()}); err != nil {
+			log.FromContext(ctx).Info(errListProviderConfig, "name", obj.GetName(), "err", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(l.Items))
+		for _, cr := range l.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: cr.GetName()}})
+		}
+		return reqs
+	}
+}
+
+// extraVarsHash returns the digest of the supplied extra vars of a
+// ProviderConfig, empty without extra vars so that the AnsibleRuns of the
+// ProviderConfigs without them don't record one. The vars are decoded first,
+// so that the order of their keys doesn't matter.
+func extraVarsHash(raw runtime.RawExtension) (string, error) {
+	if len(raw.Raw) == 0 {
+		return "", nil
+	}
+	var vars map[string]interface{}
+	if err := json.Unmarshal(raw.Raw, &vars); err != nil {
+		return "", fmt.Errorf("%s: %w", errHashExtraVars, err)
+	}
+	if len(vars) == 0 {
+		return "", nil
+	}
+	h, err := checksum(vars)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errHashExtraVars, err)
+	}
+	return h, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"testing"
+
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestVarsFrom(t *testing.T) {
+	kube := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"}, Data: map[string][]byte{"user": []byte("admin"), "password": []byte("s3cr3t")}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "settings"}, Data: map[string]string{"region": "eu", "user": "app"}},
+	).Build()
+
+	type want struct {
+		vars        map[string]string
+		fromSecrets bool
+		err         bool
+	}
+	cases := map[string]struct {
+		reason   string
+		varsFrom []v1alpha1.VarsFromSource
+		want     want
+	}{
+		"None": {
+			reason: "We should read no vars without sources",
+		},
+		"WholeObjects": {
+			reason: "We should read all the keys of the objects, the later sources overriding the earlier ones",
+			varsFrom: []v1alpha1.VarsFromSource{
+				{Kind: kindSecret, Namespace: "ns", Name: "db"},
+				{Kind: kindConfigMap, Namespace: "ns", Name: "settings"},
+			},
+			want: want{
+				vars:        map[string]string{"user": "app", "password": "s3cr3t", "region": "eu"},
+				fromSecrets: true,
+			},
+		},
+		"Prefix": {
+			reason:   "We should prefix the names of the vars of all the keys of an object",
+			varsFrom: []v1alpha1.VarsFromSource{{Kind: kindConfigMap, Namespace: "ns", Name: "settings", Prefix: "app_"}},
+			want:     want{vars: map[string]string{"app_region": "eu", "app_user": "app"}},
+		},
+		"Key": {
+			reason:   "We should read a single key of an object into the named var",
+			varsFrom: []v1alpha1.VarsFromSource{{Kind: kindSecret, Namespace: "ns", Name: "db", Key: "password", Var: "db_password"}},
+			want:     want{vars: map[string]string{"db_password": "s3cr3t"}, fromSecrets: true},
+		},
+		"MissingKey": {
+			reason:   "We should fail to read a missing key",
+			varsFrom: []v1alpha1.VarsFromSource{{Kind: kindSecret, Namespace: "ns", Name: "db", Key: "token"}},
+			want:     want{err: true},
+		},
+		"MissingObject": {
+			reason:   "We should fail to read a missing object",
+			varsFrom: []v1alpha1.VarsFromSource{{Kind: kindSecret, Namespace: "ns", Name: "missing"}},
+			want:     want{err: true},
+		},
+		"Optional": {
+			reason: "We should skip the missing optional objects and keys",
+			varsFrom: []v1alpha1.VarsFromSource{
+				{Kind: kindSecret, Namespace: "ns", Name: "missing", Optional: true},
+				{Kind: kindSecret, Namespace: "ns", Name: "db", Key: "token", Optional: true},
+				{Kind: kindConfigMap, Namespace: "ns", Name: "settings", Key: "region"},
+			},
+			want: want{vars: map[string]string{"region": "eu"}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := &connector{kube: kube}
+			cr := &v1alpha1.AnsibleRun{Spec: v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{VarsFrom: tc.varsFrom}}}
+			vars, fromSecrets, err := c.varsFrom(context.Background(), cr)
+			if gotErr := err != nil; gotErr != tc.want.err {
+				t.Fatalf("\n%s\nvarsFrom(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+			if diff := cmp.Diff(tc.want.vars, vars); diff != "" {
+				t.Errorf("\n%s\nvarsFrom(...): -want vars, +got vars:\n%s", tc.reason, diff)
+			}
+			if fromSecrets != tc.want.fromSecrets {
+				t.Errorf("\n%s\nvarsFrom(...): want fromSecrets %t, got %t", tc.reason, tc.want.fromSecrets, fromSecrets)
+			}
+		})
+	}
+}
+
+func TestRunsReadingVarsFrom(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	run := func(name string, varsFrom ...v1alpha1.VarsFromSource) *v1alpha1.AnsibleRun {
+		return &v1alpha1.AnsibleRun{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.AnsibleRunSpec{ForProvider: v1alpha1.AnsibleRunParameters{VarsFrom: varsFrom}},
+		}
+	}
+	kube := fake.NewClientBuilder().WithScheme(s).
+		WithIndex(&v1alpha1.AnsibleRun{}, varsFromIndex, indexVarsFrom).
+		WithObjects(
+			run("web", v1alpha1.VarsFromSource{Kind: kindSecret, Namespace: "ns", Name: "db"}),
+			run("settings", v1alpha1.VarsFromSource{Kind: kindConfigMap, Namespace: "ns", Name: "db"}),
+			run("other"),
+		).Build()
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"}}
+	got := runsReadingVarsFrom(kube, kindSecret)(context.Background(), secret)
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "web"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("runsReadingVarsFrom(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestRunsUsingProviderConfig(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	run := func(name, pc string) *v1alpha1.AnsibleRun {
+		cr := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		cr.SetProviderConfigReference(&xpv1.Reference{Name: pc})
+		return cr
+	}
+	kube := fake.NewClientBuilder().WithScheme(s).
+		WithIndex(&v1alpha1.AnsibleRun{}, providerConfigIndex, indexProviderConfig).
+		WithObjects(run("web", "default"), run("db", "other")).
+		Build()
+
+	pc := &v1alpha1.ProviderConfig{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	got := runsUsingProviderConfig(kube)(context.Background(), pc)
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "web"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("runsUsingProviderConfig(...): -want, +got:\n%s", diff)
+	}
+}
+
+func TestExtraVarsHash(t *testing.T) {
+	hash := func(raw string) string {
+		h, err := extraVarsHash(runtime.RawExtension{Raw: []byte(raw)})
+		if err != nil {
+			t.Fatalf("extraVarsHash(%s): unexpected error: %v", raw, err)
+		}
+		return h
+	}
+	if hash("") != "" || hash("{}") != "" {
+		t.Errorf("extraVarsHash(...): want no digest without extra vars")
+	}
+	if hash(`{"region":"eu","env":"prod"}`) != hash(`{"env":"prod","region":"eu"}`) {
+		t.Errorf("extraVarsHash(...): the order of the keys should not change the digest")
+	}
+	if hash(`{"region":"eu"}`) == hash(`{"region":"us"}`) {
+		t.Errorf("extraVarsHash(...): changed extra vars should change the digest")
+	}
+}
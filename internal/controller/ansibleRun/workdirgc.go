@@ -0,0 +1,170 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+const (
+	errListAnsibleRuns = "cannot list the AnsibleRuns"
+
+	// workDirGCInterval is how often the orphaned working directories are
+	// collected
+	workDirGCInterval = 10 * time.Minute
+)
+
+var (
+	reclaimedWorkDirs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "provider_ansible_workdir_gc_removed_total",
+		Help: "Working directories of deleted AnsibleRuns removed by the garbage collector.",
+	})
+
+	reclaimedWorkDirBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "provider_ansible_workdir_gc_reclaimed_bytes_total",
+		Help: "Disk space reclaimed by the garbage collector of the working directories of deleted AnsibleRuns.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reclaimedWorkDirs, reclaimedWorkDirBytes)
+}
+
+// A workDirGC removes the working directories whose UID no longer belongs to
+// an AnsibleRun, e.g. of the AnsibleRuns deleted while the provider was down
+// or whose finalizer was removed by hand, once they were not modified for the
+// retention period. The retention also covers the AnsibleRuns created so
+// recently that the cache of the provider doesn't know them yet.
+type workDirGC struct {
+	kube client.Reader
+	fs   afero.Afero
+	root string
+	// artifactsRoot holds the artifacts of the runs, within the working
+	// directories when empty
+	artifactsRoot string
+	retention     time.Duration
+	log           logging.Logger
+}
+
+// Start collects the orphaned working directories periodically until ctx is
+// done.
+func (g *workDirGC) Start(ctx context.Context) error {
+	t := time.NewTicker(workDirGCInterval)
+	defer t.Stop()
+	for {
+		if err := g.collect(ctx, time.Now()); err != nil {
+			g.log.Info("Cannot collect the orphaned working directories", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// collect removes the working directories of no AnsibleRun that were not
+// modified for the retention period before now.
+func (g *workDirGC) collect(ctx context.Context, now time.Time) error {
+	l := &v1alpha1.AnsibleRunList{}
+	if err := g.kube.List(ctx, l); err != nil {
+		return fmt.Errorf("%s: %w", errListAnsibleRuns, err)
+	}
+	live := make(map[string]bool, len(l.Items))
+	for _, cr := range l.Items {
+		live[string(cr.GetUID())] = true
+	}
+
+	workDirs, err := g.fs.ReadDir(g.root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, wd := range workDirs {
+		// the caches shared by the AnsibleRuns are hidden
+		if !wd.IsDir() || strings.HasPrefix(wd.Name(), ".") || live[wd.Name()] {
+			continue
+		}
+		dir := filepath.Join(g.root, wd.Name())
+		modTime, err := lastModified(g.fs, dir)
+		if err != nil {
+			return err
+		}
+		if now.Sub(modTime) < g.retention {
+			continue
+		}
+		size, err := dirSize(g.fs, dir)
+		if err != nil {
+			return err
+		}
+		if g.artifactsRoot != "" {
+			artifacts := artifactsPath(g.artifactsRoot, dir)
+			artifactsSize, err := dirSize(g.fs, artifacts)
+			if err != nil {
+				return err
+			}
+			if err := g.fs.RemoveAll(artifacts); err != nil {
+				return fmt.Errorf("cannot remove the artifacts of the orphaned working directory %s: %w", dir, err)
+			}
+			size += artifactsSize
+		}
+		if err := g.fs.RemoveAll(dir); err != nil {
+			return fmt.Errorf("cannot remove the orphaned working directory %s: %w", dir, err)
+		}
+		if err := g.fs.RemoveAll(filepath.Join(gitCredentialsRoot, dir)); err != nil {
+			return fmt.Errorf("cannot remove the git credentials of the orphaned working directory %s: %w", dir, err)
+		}
+		reclaimedWorkDirs.Inc()
+		reclaimedWorkDirBytes.Add(float64(size))
+		g.log.Debug("Removed the orphaned working directory of a deleted AnsibleRun", "path", dir, "bytes", size)
+	}
+	return nil
+}
+
+// lastModified returns the last time a file under the supplied directory, or
+// the directory itself, was modified.
+func lastModified(fs afero.Afero, dir string) (time.Time, error) {
+	var last time.Time
+	err := fs.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last, err
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/crossplane/crossplane-runtime/pkg/test"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+)
+
+func TestWorkDirGC(t *testing.T) {
+	now := time.Now()
+	root := "/ansibleDir"
+
+	cases := map[string]struct {
+		reason    string
+		listErr   error
+		retention time.Duration
+		want      []string
+		wantCreds []string
+		wantErr   bool
+	}{
+		"Collect": {
+			reason:    "We should remove the working directories and git credentials of the deleted AnsibleRuns past the retention",
+			retention: time.Hour,
+			want:      []string{".cache", "live", "recent"},
+			wantCreds: []string{"live", "recent"},
+		},
+		"WithinRetention": {
+			reason:    "We should keep the working directories of the deleted AnsibleRuns modified within the retention",
+			retention: 24 * time.Hour,
+			want:      []string{".cache", "live", "old", "recent"},
+			wantCreds: []string{"live", "old", "recent"},
+		},
+		"ListError": {
+			reason:    "We should not remove anything when the AnsibleRuns cannot be listed",
+			listErr:   errors.New("boom"),
+			retention: time.Hour,
+			want:      []string{".cache", "live", "old", "recent"},
+			wantCreds: []string{"live", "old", "recent"},
+			wantErr:   true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			artifactsRoot := "/artifacts"
+			for wd, age := range map[string]time.Duration{
+				".cache": 48 * time.Hour,
+				"live":   48 * time.Hour,
+				"old":    2 * time.Hour,
+				"recent": 10 * time.Minute,
+			} {
+				file := filepath.Join(root, wd, "file")
+				if err := fs.WriteFile(file, []byte("content"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				for _, p := range []string{file, filepath.Dir(file)} {
+					if err := fs.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if wd == ".cache" {
+					continue
+				}
+				if err := fs.WriteFile(filepath.Join(artifactsRoot, wd, "run", "stdout"), []byte("output"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := fs.WriteFile(filepath.Join(gitCredentialsRoot, root, wd, ".git-credentials"), []byte("creds"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			kube := &test.MockClient{MockList: func(_ context.Context, obj client.ObjectList, _ ...client.ListOption) error {
+				if tc.listErr != nil {
+					return tc.listErr
+				}
+				obj.(*v1alpha1.AnsibleRunList).Items = []v1alpha1.AnsibleRun{{ObjectMeta: metav1.ObjectMeta{UID: "live"}}}
+				return nil
+			}}
+			g := &workDirGC{kube: kube, fs: fs, root: root, artifactsRoot: artifactsRoot, retention: tc.retention, log: logging.NewNopLogger()}
+			err := g.collect(context.Background(), now)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("\n%s\ncollect(...): want error %t, got %v", tc.reason, tc.wantErr, err)
+			}
+			names := func(dir string) []string {
+				infos, _ := fs.ReadDir(dir)
+				var got []string
+				for _, i := range infos {
+					got = append(got, i.Name())
+				}
+				sort.Strings(got)
+				return got
+			}
+			if diff := cmp.Diff(tc.want, names(root)); diff != "" {
+				t.Errorf("\n%s\ncollect(...): -want working directories, +got working directories:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.wantCreds, names(filepath.Join(gitCredentialsRoot, root))); diff != "" {
+				t.Errorf("\n%s\ncollect(...): -want git credentials, +got git credentials:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.wantCreds, names(artifactsRoot)); diff != "" {
+				t.Errorf("\n%s\ncollect(...): -want artifacts, +got artifacts:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/afero"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	errReadWorkdirLock   = "cannot read the lockfile of the working directory"
+	errWriteWorkdirLock  = "cannot write the lockfile of the working directory"
+	errVerifyWorkdir     = "cannot verify the working directory against its lockfile"
+	errRebuildWorkdir    = "cannot rebuild the working directory"
+	errWorkdirDrift      = "files of the working directory changed since they were written, rebuilding it"
+	reasonWorkdirRebuilt = event.Reason("WorkdirRebuilt")
+
+	// workdirLockfile records the files the provider wrote into the
+	// working directory of an AnsibleRun at the end of its last preparation
+	workdirLockfile = ".workdir.lock"
+)
+
+// lockedWorkdirDirs are the subdirectories of the working directories whose
+// content is entirely derived from the AnsibleRuns, their ProviderConfigs
+// and their Secrets: the contents, the inventories and the credentials.
+var lockedWorkdirDirs = []string{runnerutil.ProjectDir, runnerutil.InventoryDir, runnerutil.VaultDir, runnerutil.SSHDir}
+
+// workdirLocks serializes the preparations and the runs of each working
+// directory with the admin API flushing the caches they read.
+var workdirLocks = &workdirMutexes{held: map[string]*workdirMutex{}}
+
+// A workdirMutex is the lock of a working directory, along with the number of
+// its holders and waiters.
+type workdirMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// workdirMutexes are the locks of the working directories, forgotten once
+// no one holds or waits for them.
+type workdirMutexes struct {
+	mu   sync.Mutex
+	held map[string]*workdirMutex
+}
+
+// lock takes the lock of the supplied working directory, waiting until it is
+// released, and returns the func releasing it.
+func (m *workdirMutexes) lock(dir string) func() {
+	m.mu.Lock()
+	l, ok := m.held[dir]
+	if !ok {
+		l = &workdirMutex{}
+		m.held[dir] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(m.held, dir)
+		}
+	}
+}
+
+var workdirRebuilds = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "provider_ansible_workdir_rebuilds_total",
+	Help: "Rebuilds of the working directories of the AnsibleRuns whose files changed since they were written.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(workdirRebuilds)
+}
+
+// readWorkdirLock returns the lockfile of the supplied working directory, nil
+// when there is none.
+func readWorkdirLock(fs afero.Afero, dir string) (map[string]lockedFile, error) {
+	b, err := fs.ReadFile(filepath.Join(dir, workdirLockfile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errReadWorkdirLock, err)
+	}
+	lock := map[string]lockedFile{}
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", errReadWorkdirLock, err)
+	}
+	return lock, nil
+}
+
+// writeWorkdirLock records the files of the locked subdirectories of the
+// supplied working directory into its lockfile. The digests of the files
+// whose size and modification time didn't change since the last lockfile are
+// kept rather than computed again.
+func writeWorkdirLock(fs afero.Afero, dir string) error {
+	last, err := readWorkdirLock(fs, dir)
+	if err != nil {
+		// the lockfile is written again from scratch
+		last = nil
+	}
+	lock := map[string]lockedFile{}
+	for _, sub := range lockedWorkdirDirs {
+		files, err := installedFiles(fs, filepath.Join(dir, sub))
+		if err != nil {
+			return fmt.Errorf("%s: %w", errWriteWorkdirLock, err)
+		}
+		for rel, info := range files {
+			path := filepath.Join(sub, rel)
+			if l, ok := last[path]; ok && l.Size == info.Size() && l.ModTime.Equal(info.ModTime()) {
+				lock[path] = l
+				continue
+			}
+			sum, err := fileDigest(fs, filepath.Join(dir, path))
+			if err != nil {
+				return fmt.Errorf("%s: %w", errWriteWorkdirLock, err)
+			}
+			lock[path] = lockedFile{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+		}
+	}
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errWriteWorkdirLock, err)
+	}
+	if err := fs.WriteFile(filepath.Join(dir, workdirLockfile), b, 0600); err != nil {
+		return fmt.Errorf("%s: %w", errWriteWorkdirLock, err)
+	}
+	return nil
+}
+
+// workdirDrift returns the files of the lockfile of the supplied working
+// directory that were modified or removed since it was written, sorted. The
+// files added since are left out, the runs being free to write next to the
+// contents. It returns nil when there is no lockfile.
+func workdirDrift(fs afero.Afero, dir string) ([]string, error) {
+	lock, err := readWorkdirLock(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	var drifted []string
+	for path, l := range lock {
+		info, err := fs.Stat(filepath.Join(dir, path))
+		switch {
+		case os.IsNotExist(err):
+			drifted = append(drifted, path)
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", errVerifyWorkdir, err)
+		case l.Size == info.Size() && l.ModTime.Equal(info.ModTime()):
+		default:
+			// touched files whose content is unchanged didn't drift
+			sum, err := fileDigest(fs, filepath.Join(dir, path))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", errVerifyWorkdir, err)
+			}
+			if sum != l.SHA256 {
+				drifted = append(drifted, path)
+			}
+		}
+	}
+	sort.Strings(drifted)
+	return drifted, nil
+}
+
+// verifyWorkdir rebuilds the prepared working directory of the supplied
+// AnsibleRun when the files the provider wrote into it changed since its
+// last preparation, e.g. edited from a shell in the provider pod, or left
+// halfway written by a crash: its locked subdirectories and the checksums of
+// its fetched contents are removed, so that everything is written and
+// fetched again. The lockfile is removed until the preparation succeeds
+// again, since the files it records are about to be replaced.
+func (c *connector) verifyWorkdir(cr *v1alpha1.AnsibleRun, p *preparation) error {
+	drifted, err := workdirDrift(c.fs, p.dir)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.Remove(filepath.Join(p.dir, workdirLockfile)); resource.Ignore(os.IsNotExist, err) != nil {
+		return fmt.Errorf("%s: %w", errWriteWorkdirLock, err)
+	}
+	if len(drifted) == 0 {
+		return nil
+	}
+	workdirRebuilds.Inc()
+	if c.recorder != nil {
+		c.recorder.Event(cr, event.Warning(reasonWorkdirRebuilt, fmt.Errorf("%s: %s", errWorkdirDrift, fileNames(drifted))))
+	}
+	for _, sub := range append([]string{fetchedDir}, lockedWorkdirDirs...) {
+		if err := c.fs.RemoveAll(filepath.Join(p.dir, sub)); err != nil {
+			return fmt.Errorf("%s: %w", errRebuildWorkdir, err)
+		}
+	}
+	return c.prepareWorkdir(cr, p)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerun
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/afero"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/pkg/runnerutil"
+)
+
+func TestWorkdirDrift(t *testing.T) {
+	dir := filepath.Join(baseWorkingDir, string(uid))
+	later := time.Now().Add(time.Hour)
+
+	cases := map[string]struct {
+		reason string
+		mutate func(t *testing.T, fs afero.Afero)
+		want   []string
+	}{
+		"Unchanged": {
+			reason: "A working directory matching its lockfile should not drift",
+			mutate: func(_ *testing.T, _ afero.Afero) {},
+		},
+		"Touched": {
+			reason: "Touched files whose content is unchanged should not drift",
+			mutate: func(t *testing.T, fs afero.Afero) {
+				if err := fs.Chtimes(filepath.Join(dir, runnerutil.InventoryDir, runnerutil.Hosts), later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"Mutated": {
+			reason: "Modified and removed files should drift, added ones should not",
+			mutate: func(t *testing.T, fs afero.Afero) {
+				if err := fs.WriteFile(filepath.Join(dir, runnerutil.InventoryDir, runnerutil.Hosts), []byte("[web]\nattacker\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := fs.Remove(filepath.Join(dir, runnerutil.VaultDir, "0")); err != nil {
+					t.Fatal(err)
+				}
+				if err := fs.WriteFile(filepath.Join(dir, runnerutil.ProjectDir, "playbook.retry"), []byte("web1"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"inventory/hosts", "vault/0"},
+		},
+		"Unlocked": {
+			reason: "The files out of the locked directories should not drift",
+			mutate: func(t *testing.T, fs afero.Afero) {
+				if err := fs.WriteFile(filepath.Join(dir, runnerutil.EnvDir, runnerutil.Cmdline), []byte("--check"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fs := afero.Afero{Fs: afero.NewMemMapFs()}
+			for f, content := range map[string]string{
+				"project/playbook.yml": "- hosts: all",
+				"inventory/hosts":      "[web]\nweb1\n",
+				"vault/0":              "secret",
+				"env/cmdline":          "",
+			} {
+				if err := fs.WriteFile(filepath.Join(dir, f), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeWorkdirLock(fs, dir); err != nil {
+				t.Fatalf("writeWorkdirLock(...): unexpected error: %v", err)
+			}
+			tc.mutate(t, fs)
+			got, err := workdirDrift(fs, dir)
+			if err != nil {
+				t.Fatalf("\n%s\nworkdirDrift(...): unexpected error: %v", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nworkdirDrift(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestVerifyWorkdir(t *testing.T) {
+	fs := afero.Afero{Fs: afero.NewMemMapFs()}
+	c := &connector{fs: fs}
+	cr := &v1alpha1.AnsibleRun{}
+	cr.SetUID(types.UID(uid))
+	p := &preparation{}
+	if err := c.prepareWorkdir(cr, p); err != nil {
+		t.Fatal(err)
+	}
+	playbook := filepath.Join(p.projectDir(), runnerutil.PlaybookYml)
+	fetched := filepath.Join(p.dir, fetchedDir, sourceInline)
+	for _, f := range []string{playbook, fetched} {
+		if err := fs.WriteFile(f, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeWorkdirLock(fs, p.dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// an intact working directory is kept, its lockfile removed until it is
+	// prepared again
+	if err := c.verifyWorkdir(cr, p); err != nil {
+		t.Fatalf("verifyWorkdir(...): unexpected error: %v", err)
+	}
+	if ok, _ := fs.Exists(fetched); !ok {
+		t.Errorf("verifyWorkdir(...): the checksums of an intact working directory should be kept")
+	}
+	if ok, _ := fs.Exists(filepath.Join(p.dir, workdirLockfile)); ok {
+		t.Errorf("verifyWorkdir(...): the lockfile should be removed until the working directory is prepared again")
+	}
+
+	// a changed working directory is rebuilt
+	if err := writeWorkdirLock(fs, p.dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(playbook, []byte("- hosts: all\n  tasks: []"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verifyWorkdir(cr, p); err != nil {
+		t.Fatalf("verifyWorkdir(...): unexpected error: %v", err)
+	}
+	for _, f := range []string{playbook, fetched} {
+		if ok, _ := fs.Exists(f); ok {
+			t.Errorf("verifyWorkdir(...): %s should be removed from a changed working directory", f)
+		}
+	}
+	if ok, _ := fs.DirExists(p.projectDir()); !ok {
+		t.Errorf("verifyWorkdir(...): the project directory should be made again")
+	}
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerunclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/controller"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/ratelimiter"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	errGetClaim        = "cannot get AnsibleRunClaim"
+	errGetRun          = "cannot get the AnsibleRun of the AnsibleRunClaim"
+	errGetPC           = "cannot get ProviderConfig"
+	errApplyRun        = "cannot create or update AnsibleRun"
+	errDeleteRun       = "cannot delete AnsibleRun"
+	errFinalizer       = "cannot update the finalizers of the AnsibleRunClaim"
+	errUpdateStatus    = "cannot update the status of the AnsibleRunClaim"
+	errNotClaimed      = "AnsibleRun exists and is not made by the AnsibleRunClaim"
+	errPCNotAllowed    = "ProviderConfig doesn't allow the AnsibleRunClaims of namespace"
+	errPCNotJob        = "ProviderConfig must run the contents of the AnsibleRunClaims in Jobs, not with the executor"
+	errForeignSource   = "credentials must be read from a Secret of the namespace of the AnsibleRunClaim"
+	errForeignSecret   = "Secret is not in the namespace of the AnsibleRunClaim"
+	errForeignAccount  = "ServiceAccount is not in the namespace of the AnsibleRunClaim"
+	errForeignVarsFrom = "vars must be read from the namespace of the AnsibleRunClaim"
+	errForeignFile     = "files must be read from the namespace of the AnsibleRunClaim"
+	errForeignObjects  = "hosts must be enumerated from the Pods or Machines of the namespace of the AnsibleRunClaim"
+	errClaimNodes      = "hosts cannot be enumerated from the Nodes by an AnsibleRunClaim"
+	reasonApplyRun     = event.Reason("CannotApplyAnsibleRun")
+	reasonUnauthorized = event.Reason("UnauthorizedAnsibleRunClaim")
+
+	// finalizer keeps the AnsibleRunClaim until its AnsibleRun is deleted,
+	// which a cluster-scoped AnsibleRun cannot be garbage collected for
+	finalizer = "finalizer.ansible.crossplane.io/claim"
+
+	// allNamespaces allows the AnsibleRunClaims of all the namespaces to
+	// use a ProviderConfig
+	allNamespaces = "*"
+
+	// unauthorizedRequeue is how often an unauthorized AnsibleRunClaim is
+	// checked again, e.g. once its ProviderConfig allows its namespace
+	unauthorizedRequeue = time.Minute
+
+	reconcileTimeout = 1 * time.Minute
+)
+
+// Annotations of the AnsibleRun of an AnsibleRunClaim, naming the claim.
+const (
+	AnnotationKeyClaimNamespace = "ansible.crossplane.io/claim-namespace"
+	AnnotationKeyClaimName      = "ansible.crossplane.io/claim-name"
+)
+
+// Setup adds a controller that reconciles AnsibleRunClaims, by making a
+// cluster-scoped AnsibleRun for each of them. The supplied executor runs the
+// contents of the ProviderConfigs that don't select one.
+func Setup(mgr ctrl.Manager, o controller.Options, executor string) error {
+	name := "claim/" + v1alpha1.AnsibleRunClaimGroupKind
+
+	r := &Reconciler{
+		kube:     mgr.GetClient(),
+		executor: executor,
+		log:      o.Logger.WithValues("controller", name),
+		record:   event.NewAPIRecorder(mgr.GetEventRecorderFor(name)),
+	}
+
+	// a namespaced AnsibleRunClaim cannot own its cluster-scoped AnsibleRun,
+	// the AnsibleRun names its claim instead
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(name).
+		WithOptions(o.ForControllerRuntime()).
+		For(&v1alpha1.AnsibleRunClaim{}).
+		Watches(&v1alpha1.AnsibleRun{}, handler.EnqueueRequestsFromMapFunc(claimOf)).
+		Complete(ratelimiter.NewReconciler(name, r, o.GlobalRateLimiter))
+}
+
+// claimOf enqueues the AnsibleRunClaim of the supplied AnsibleRun, if any.
+func claimOf(_ context.Context, run client.Object) []reconcile.Request {
+	a := run.GetAnnotations()
+	if a[AnnotationKeyClaimName] == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: a[AnnotationKeyClaimNamespace], Name: a[AnnotationKeyClaimName]}}}
+}
+
+// A Reconciler reconciles AnsibleRunClaims.
+type Reconciler struct {
+	kube client.Client
+	// executor runs the contents of the ProviderConfigs that don't select
+	// one
+	executor string
+	log      logging.Logger
+	record   event.Recorder
+}
+
+// Reconcile makes the AnsibleRun of an AnsibleRunClaim, once the claim only
+// references the Secrets and the ServiceAccount of its namespace and its
+// ProviderConfig allows its namespace, and reports the status of the
+// AnsibleRun. The AnsibleRun is deleted along with the claim.
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := r.log.WithValues("request", req)
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+	defer cancel()
+
+	claim := &v1alpha1.AnsibleRunClaim{}
+	if err := r.kube.Get(ctx, req.NamespacedName, claim); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(fmt.Errorf("%s: %w", errGetClaim, err))
+	}
+	if meta.WasDeleted(claim) {
+		return reconcile.Result{}, r.deleteRun(ctx, claim)
+	}
+	if !meta.FinalizerExists(claim, finalizer) {
+		meta.AddFinalizer(claim, finalizer)
+		if err := r.kube.Update(ctx, claim); err != nil {
+			return reconcile.Result{}, fmt.Errorf("%s: %w", errFinalizer, err)
+		}
+	}
+
+	if err := r.authorize(ctx, claim); err != nil {
+		log.Debug("Unauthorized AnsibleRunClaim", "error", err)
+		r.record.Event(claim, event.Warning(reasonUnauthorized, err))
+		claim.Status.SetConditions(xpv1.ReconcileError(err))
+		return reconcile.Result{RequeueAfter: unauthorizedRequeue}, r.updateStatus(ctx, claim)
+	}
+	run, err := r.applyRun(ctx, claim)
+	if err != nil {
+		log.Debug("Cannot apply the AnsibleRun", "error", err)
+		r.record.Event(claim, event.Warning(reasonApplyRun, err))
+		claim.Status.SetConditions(xpv1.ReconcileError(err))
+		return reconcile.Result{}, r.updateStatus(ctx, claim)
+	}
+	claim.Status.RunName = run.GetName()
+	claim.Status.AtProvider = run.Status.AtProvider
+	claim.Status.SetConditions(xpv1.ReconcileSuccess())
+	claim.Status.SetConditions(run.Status.Conditions...)
+	return reconcile.Result{}, r.updateStatus(ctx, claim)
+}
+
+// runName returns the name of the AnsibleRun of the supplied AnsibleRunClaim.
+func runName(claim *v1alpha1.AnsibleRunClaim) string {
+	return claim.GetNamespace() + "-" + claim.GetName()
+}
+
+// claimedBy tells whether the supplied AnsibleRun is the one of the supplied
+// AnsibleRunClaim.
+func claimedBy(run *v1alpha1.AnsibleRun, claim *v1alpha1.AnsibleRunClaim) bool {
+	a := run.GetAnnotations()
+	return a[AnnotationKeyClaimNamespace] == claim.GetNamespace() && a[AnnotationKeyClaimName] == claim.GetName()
+}
+
+// authorize checks that the supplied AnsibleRunClaim only reads credentials,
+// vars and files from the Secrets and ConfigMaps of its namespace, only
+// impersonates a ServiceAccount of its namespace, and that its
+// ProviderConfig allows its namespace and runs the contents in Jobs, so that
+// the tenants of a namespace cannot reach the ones of the other namespaces
+// or the provider pod.
+func (r *Reconciler) authorize(ctx context.Context, claim *v1alpha1.AnsibleRunClaim) error {
+	ns := claim.GetNamespace()
+	if err := namespacedReferences(ns, claim.Spec.ForProvider); err != nil {
+		return err
+	}
+	pcName := "default"
+	if ref := claim.Spec.ProviderConfigReference; ref != nil {
+		pcName = ref.Name
+	}
+	pc := &v1alpha1.ProviderConfig{}
+	if err := r.kube.Get(ctx, types.NamespacedName{Name: pcName}, pc); err != nil {
+		return fmt.Errorf("%s %s: %w", errGetPC, pcName, err)
+	}
+	if !slices.Contains(pc.Spec.ClaimNamespaces, ns) && !slices.Contains(pc.Spec.ClaimNamespaces, allNamespaces) {
+		return fmt.Errorf("%s %s: %s", pcName, errPCNotAllowed, ns)
+	}
+	// the runs in the provider pod could read the working directories of
+	// all the AnsibleRuns, the Jobs only mount the ones of their run
+	executor := pc.Spec.Executor
+	if executor == "" {
+		executor = r.executor
+	}
+	if executor != ansible.ExecutorJob && executor != ansible.ExecutorFake {
+		return fmt.Errorf("%s %s: %s", pcName, errPCNotJob, executor)
+	}
+	return nil
+}
+
+// namespacedReferences checks that the supplied parameters only read
+// credentials from the Secrets of the supplied namespace, only read vars
+// and files from its Secrets and ConfigMaps, and only impersonate a
+// ServiceAccount of it. The other sources of credentials read
+// the environment or the files of the provider pod.
+func namespacedReferences(ns string, p v1alpha1.AnsibleRunParameters) error {
+	check := func(what string, src xpv1.CredentialsSource, sel xpv1.CommonCredentialSelectors) error {
+		switch {
+		case src == xpv1.CredentialsSourceNone:
+			return nil
+		case src != xpv1.CredentialsSourceSecret:
+			return fmt.Errorf("%s: %s", what, errForeignSource)
+		case sel.SecretRef != nil && sel.SecretRef.Namespace != ns:
+			return fmt.Errorf("%s: %s: %s", what, errForeignSecret, sel.SecretRef.Namespace)
+		}
+		return nil
+	}
+	checkToken := func(what string, token *v1alpha1.SourceToken) error {
+		if token == nil {
+			return nil
+		}
+		return check(what, token.Source, token.CommonCredentialSelectors)
+	}
+
+	var errs []error
+	for i, inv := range p.Inventories {
+		errs = append(errs, check(fmt.Sprintf("inventories[%d]", i), inv.Source, inv.CommonCredentialSelectors))
+	}
+	for _, plugin := range p.InventoryPlugins {
+		for _, cd := range plugin.Credentials {
+			errs = append(errs, check(fmt.Sprintf("inventoryPlugins[%s].credentials[%s]", plugin.Name, cd.Name), cd.Source, cd.CommonCredentialSelectors))
+		}
+	}
+	for i, pw := range p.Passwords {
+		errs = append(errs, check(fmt.Sprintf("passwords[%d]", i), pw.Source, pw.CommonCredentialSelectors))
+	}
+	for _, role := range p.Roles {
+		errs = append(errs, checkToken(fmt.Sprintf("roles[%s].token", role.Name), role.Token))
+	}
+	for _, role := range p.DeleteRoles {
+		errs = append(errs, checkToken(fmt.Sprintf("deleteRoles[%s].token", role.Name), role.Token))
+	}
+	for _, col := range p.Collections {
+		errs = append(errs, checkToken(fmt.Sprintf("collections[%s].token", col.Name), col.Token))
+	}
+	for i, src := range p.VarsFrom {
+		if src.Namespace != ns {
+			errs = append(errs, fmt.Errorf("varsFrom[%d]: %s: %s", i, errForeignVarsFrom, src.Namespace))
+		}
+	}
+	for _, f := range p.Files {
+		if f.Namespace != ns {
+			errs = append(errs, fmt.Errorf("files[%s]: %s: %s", f.Path, errForeignFile, f.Namespace))
+		}
+	}
+	for i, inv := range p.KubernetesInventories {
+		switch {
+		// Nodes aren't namespaced
+		case inv.Kind == v1alpha1.KubernetesInventoryNode:
+			errs = append(errs, fmt.Errorf("kubernetesInventories[%d]: %s", i, errClaimNodes))
+		case inv.Namespace != ns:
+			errs = append(errs, fmt.Errorf("kubernetesInventories[%d]: %s: %s", i, errForeignObjects, inv.Namespace))
+		}
+	}
+	if sa := p.ServiceAccount; sa != nil && sa.Namespace != ns {
+		errs = append(errs, fmt.Errorf("serviceAccount: %s: %s", errForeignAccount, sa.Namespace))
+	}
+	return errors.Join(errs...)
+}
+
+// applyRun creates the AnsibleRun of the supplied AnsibleRunClaim, or updates
+// the existing one with its spec and the ansible.crossplane.io annotations
+// of the claim, e.g. its run policy. The fields late initialized by the
+// AnsibleRun controller are left as is, so that both controllers don't fight
+// over them.
+func (r *Reconciler) applyRun(ctx context.Context, claim *v1alpha1.AnsibleRunClaim) (*v1alpha1.AnsibleRun, error) {
+	run := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: runName(claim)}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.kube, run, func() error {
+		if run.GetResourceVersion() != "" && !claimedBy(run, claim) {
+			return errors.New(errNotClaimed)
+		}
+		annotations := map[string]string{
+			AnnotationKeyClaimNamespace: claim.GetNamespace(),
+			AnnotationKeyClaimName:      claim.GetName(),
+		}
+		for k, v := range claim.GetAnnotations() {
+			if strings.HasPrefix(k, v1alpha1.Group+"/") {
+				annotations[k] = v
+			}
+		}
+		meta.AddAnnotations(run, annotations)
+		stateVar := run.Spec.ForProvider.StateVar
+		run.Spec.ForProvider = *claim.Spec.ForProvider.DeepCopy()
+		if run.Spec.ForProvider.StateVar == "" {
+			run.Spec.ForProvider.StateVar = stateVar
+		}
+		run.Spec.ProviderConfigReference = claim.Spec.ProviderConfigReference.DeepCopy()
+		run.Spec.WriteConnectionSecretToReference = nil
+		if ref := claim.Spec.WriteConnectionSecretToReference; ref != nil {
+			run.Spec.WriteConnectionSecretToReference = &xpv1.SecretReference{Namespace: claim.GetNamespace(), Name: ref.Name}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", errApplyRun, run.GetName(), err)
+	}
+	return run, nil
+}
+
+// deleteRun deletes the AnsibleRun of the supplied AnsibleRunClaim being
+// deleted, and removes the finalizer of the claim once the AnsibleRun is
+// gone, i.e. once its contents ran for the deletion. The AnsibleRunClaim is
+// reconciled again when its AnsibleRun is deleted.
+func (r *Reconciler) deleteRun(ctx context.Context, claim *v1alpha1.AnsibleRunClaim) error {
+	run := &v1alpha1.AnsibleRun{}
+	err := r.kube.Get(ctx, types.NamespacedName{Name: runName(claim)}, run)
+	switch {
+	case kerrors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("%s: %w", errGetRun, err)
+	case !claimedBy(run, claim):
+	case !meta.WasDeleted(run):
+		if err := r.kube.Delete(ctx, run); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("%s %s: %w", errDeleteRun, run.GetName(), err)
+		}
+		claim.Status.SetConditions(xpv1.Deleting())
+		return r.updateStatus(ctx, claim)
+	default:
+		// the AnsibleRun is being deleted
+		return nil
+	}
+	meta.RemoveFinalizer(claim, finalizer)
+	if err := r.kube.Update(ctx, claim); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("%s: %w", errFinalizer, err)
+	}
+	return nil
+}
+
+func (r *Reconciler) updateStatus(ctx context.Context, claim *v1alpha1.AnsibleRunClaim) error {
+	if err := r.kube.Status().Update(ctx, claim); err != nil && !kerrors.IsConflict(err) {
+		return fmt.Errorf("%s: %w", errUpdateStatus, err)
+	}
+	// a conflict means the AnsibleRunClaim changed, it is reconciled again anyway
+	return nil
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerunclaim
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	"github.com/crossplane-contrib/provider-ansible/internal/ansible"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	s := runtime.NewScheme()
+	if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+		t.Fatalf("Adding to scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).WithStatusSubresource(&v1alpha1.AnsibleRunClaim{}).Build()
+}
+
+func TestReconcile(t *testing.T) {
+	playbook := "- hosts: all"
+	claim := func(p v1alpha1.AnsibleRunParameters) *v1alpha1.AnsibleRunClaim {
+		return &v1alpha1.AnsibleRunClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "team-a",
+				Name:      "web",
+				Annotations: map[string]string{
+					"ansible.crossplane.io/runPolicy": "CheckWhenObserve",
+					"team":                            "infra",
+				},
+			},
+			Spec: v1alpha1.AnsibleRunClaimSpec{
+				WriteConnectionSecretToReference: &xpv1.LocalSecretReference{Name: "web-conn"},
+				ForProvider:                      p,
+			},
+		}
+	}
+	params := v1alpha1.AnsibleRunParameters{
+		PlaybookInline: &playbook,
+		Inventories: []v1alpha1.Inventory{{
+			Source:                    xpv1.CredentialsSourceSecret,
+			CommonCredentialSelectors: xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{Key: "hosts", SecretReference: xpv1.SecretReference{Namespace: "team-a", Name: "inventory"}}},
+		}},
+	}
+	pc := func(namespaces ...string) *v1alpha1.ProviderConfig {
+		return &v1alpha1.ProviderConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec:       v1alpha1.ProviderConfigSpec{ClaimNamespaces: namespaces},
+		}
+	}
+
+	type want struct {
+		run        *v1alpha1.AnsibleRunSpec
+		conditions []xpv1.Condition
+		runName    string
+	}
+
+	cases := map[string]struct {
+		reason  string
+		claim   *v1alpha1.AnsibleRunClaim
+		objects []client.Object
+		want    want
+	}{
+		"CreateRun": {
+			reason:  "We should create the AnsibleRun of an authorized AnsibleRunClaim and report its status",
+			claim:   claim(params),
+			objects: []client.Object{pc("team-a")},
+			want: want{
+				run: &v1alpha1.AnsibleRunSpec{
+					ResourceSpec: xpv1.ResourceSpec{
+						WriteConnectionSecretToReference: &xpv1.SecretReference{Namespace: "team-a", Name: "web-conn"},
+					},
+					ForProvider: params,
+				},
+				conditions: []xpv1.Condition{xpv1.ReconcileSuccess()},
+				runName:    "team-a-web",
+			},
+		},
+		"MirrorRunStatus": {
+			reason: "We should keep the fields late initialized in the AnsibleRun and report its conditions",
+			claim:  claim(params),
+			objects: func() []client.Object {
+				run := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{
+					Name:        "team-a-web",
+					Annotations: map[string]string{AnnotationKeyClaimNamespace: "team-a", AnnotationKeyClaimName: "web"},
+				}}
+				run.Spec.ForProvider.StateVar = "crossplane_state"
+				run.SetConditions(xpv1.Available(), xpv1.ReconcileError(errors.New("boom")))
+				return []client.Object{pc("*"), run}
+			}(),
+			want: want{
+				run: func() *v1alpha1.AnsibleRunSpec {
+					s := &v1alpha1.AnsibleRunSpec{
+						ResourceSpec: xpv1.ResourceSpec{
+							WriteConnectionSecretToReference: &xpv1.SecretReference{Namespace: "team-a", Name: "web-conn"},
+						},
+						ForProvider: *params.DeepCopy(),
+					}
+					s.ForProvider.StateVar = "crossplane_state"
+					return s
+				}(),
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.New("boom")), xpv1.Available()},
+				runName:    "team-a-web",
+			},
+		},
+		"ForeignSecret": {
+			reason: "We should not create the AnsibleRun of an AnsibleRunClaim reading the Secrets of another namespace",
+			claim: claim(v1alpha1.AnsibleRunParameters{
+				PlaybookInline: &playbook,
+				Passwords: []v1alpha1.Password{{
+					Source:                    xpv1.CredentialsSourceSecret,
+					CommonCredentialSelectors: xpv1.CommonCredentialSelectors{SecretRef: &xpv1.SecretKeySelector{SecretReference: xpv1.SecretReference{Namespace: "team-b", Name: "become"}}},
+				}},
+			}),
+			objects: []client.Object{pc("team-a")},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.Join(errors.New("passwords[0]: " + errForeignSecret + ": team-b")))},
+			},
+		},
+		"ForeignVarsFrom": {
+			reason: "We should not create the AnsibleRun of an AnsibleRunClaim reading its vars, files and hosts from another namespace",
+			claim: claim(v1alpha1.AnsibleRunParameters{
+				PlaybookInline: &playbook,
+				VarsFrom:       []v1alpha1.VarsFromSource{{Kind: "Secret", Namespace: "team-b", Name: "db"}},
+				Files:          []v1alpha1.File{{Path: "kubeconfig", Kind: "Secret", Namespace: "team-b", Name: "cluster", Key: "config"}},
+				KubernetesInventories: []v1alpha1.KubernetesInventory{
+					{Kind: v1alpha1.KubernetesInventoryPod, Namespace: "team-a"},
+					{Kind: v1alpha1.KubernetesInventoryPod, Namespace: "team-b"},
+					{Kind: v1alpha1.KubernetesInventoryNode},
+				},
+			}),
+			objects: []client.Object{pc("team-a")},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.Join(
+					errors.New("varsFrom[0]: "+errForeignVarsFrom+": team-b"),
+					errors.New("files[kubeconfig]: "+errForeignFile+": team-b"),
+					errors.New("kubernetesInventories[1]: "+errForeignObjects+": team-b"),
+					errors.New("kubernetesInventories[2]: "+errClaimNodes),
+				))},
+			},
+		},
+		"ForeignSource": {
+			reason: "We should not create the AnsibleRun of an AnsibleRunClaim reading the files of the provider",
+			claim: claim(v1alpha1.AnsibleRunParameters{
+				PlaybookInline: &playbook,
+				Inventories:    []v1alpha1.Inventory{{Source: xpv1.CredentialsSourceFilesystem}},
+				ServiceAccount: &v1alpha1.ServiceAccountImpersonation{Namespace: "kube-system", Name: "admin"},
+			}),
+			objects: []client.Object{pc("team-a")},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.Join(
+					errors.New("inventories[0]: "+errForeignSource),
+					errors.New("serviceAccount: "+errForeignAccount+": kube-system"),
+				))},
+			},
+		},
+		"NamespaceNotAllowed": {
+			reason:  "We should not create the AnsibleRun of an AnsibleRunClaim whose ProviderConfig doesn't allow its namespace",
+			claim:   claim(params),
+			objects: []client.Object{pc("team-b")},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.New("default " + errPCNotAllowed + ": team-a"))},
+			},
+		},
+		"LocalExecutor": {
+			reason: "We should not create the AnsibleRun of an AnsibleRunClaim whose ProviderConfig runs the contents in the provider pod",
+			claim:  claim(params),
+			objects: []client.Object{&v1alpha1.ProviderConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       v1alpha1.ProviderConfigSpec{ClaimNamespaces: []string{"team-a"}, Executor: ansible.ExecutorLocal},
+			}},
+			want: want{
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.New("default " + errPCNotJob + ": " + ansible.ExecutorLocal))},
+			},
+		},
+		"NotClaimed": {
+			reason: "We should not take over an existing AnsibleRun the AnsibleRunClaim didn't make",
+			claim:  claim(params),
+			objects: []client.Object{pc("team-a"), &v1alpha1.AnsibleRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a-web"},
+			}},
+			want: want{
+				run:        &v1alpha1.AnsibleRunSpec{},
+				conditions: []xpv1.Condition{xpv1.ReconcileError(errors.New(errApplyRun + " team-a-web: " + errNotClaimed))},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			kube := newClient(t, append(tc.objects, tc.claim)...)
+			r := &Reconciler{kube: kube, executor: ansible.ExecutorJob, log: logging.NewNopLogger(), record: event.NewNopRecorder()}
+
+			if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "web"}}); err != nil {
+				t.Fatalf("\n%s\nr.Reconcile(...): unexpected error: %v", tc.reason, err)
+			}
+
+			run := &v1alpha1.AnsibleRun{}
+			err := kube.Get(context.Background(), types.NamespacedName{Name: "team-a-web"}, run)
+			switch {
+			case tc.want.run == nil:
+				if !kerrors.IsNotFound(err) {
+					t.Errorf("\n%s\nwant no AnsibleRun, got error %v", tc.reason, err)
+				}
+			case err != nil:
+				t.Fatalf("Getting AnsibleRun: %v", err)
+			default:
+				if diff := cmp.Diff(*tc.want.run, run.Spec); diff != "" {
+					t.Errorf("\n%s\nAnsibleRun spec: -want, +got:\n%s", tc.reason, diff)
+				}
+			}
+			if tc.want.runName != "" {
+				want := map[string]string{
+					AnnotationKeyClaimNamespace:       "team-a",
+					AnnotationKeyClaimName:            "web",
+					"ansible.crossplane.io/runPolicy": "CheckWhenObserve",
+				}
+				if diff := cmp.Diff(want, run.GetAnnotations()); diff != "" {
+					t.Errorf("\n%s\nAnsibleRun annotations: -want, +got:\n%s", tc.reason, diff)
+				}
+			}
+
+			got := &v1alpha1.AnsibleRunClaim{}
+			if err := kube.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "web"}, got); err != nil {
+				t.Fatalf("Getting AnsibleRunClaim: %v", err)
+			}
+			if diff := cmp.Diff(tc.want.conditions, got.Status.Conditions, cmpopts.IgnoreFields(xpv1.Condition{}, "LastTransitionTime")); diff != "" {
+				t.Errorf("\n%s\nconditions: -want, +got:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.runName, got.Status.RunName); diff != "" {
+				t.Errorf("\n%s\nrunName: -want, +got:\n%s", tc.reason, diff)
+			}
+			if !cmp.Equal([]string{finalizer}, got.GetFinalizers()) {
+				t.Errorf("\n%s\nwant the finalizer %s, got %v", tc.reason, finalizer, got.GetFinalizers())
+			}
+		})
+	}
+}
+
+func TestReconcileDeletion(t *testing.T) {
+	now := metav1.Now()
+	claim := &v1alpha1.AnsibleRunClaim{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         "team-a",
+		Name:              "web",
+		Finalizers:        []string{finalizer},
+		DeletionTimestamp: &now,
+	}}
+	run := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-a-web",
+		Annotations: map[string]string{AnnotationKeyClaimNamespace: "team-a", AnnotationKeyClaimName: "web"},
+		// the finalizer of the AnsibleRun controller, until it ran the
+		// contents for the deletion
+		Finalizers: []string{"finalizer.managedresource.crossplane.io"},
+	}}
+	kube := newClient(t, claim, run)
+	r := &Reconciler{kube: kube, executor: ansible.ExecutorJob, log: logging.NewNopLogger(), record: event.NewNopRecorder()}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "web"}}
+
+	// the AnsibleRun is deleted first
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("r.Reconcile(...): unexpected error: %v", err)
+	}
+	if err := kube.Get(context.Background(), types.NamespacedName{Name: run.GetName()}, run); err != nil || run.GetDeletionTimestamp() == nil {
+		t.Fatalf("want the AnsibleRun being deleted, got %v, error %v", run.GetDeletionTimestamp(), err)
+	}
+	got := &v1alpha1.AnsibleRunClaim{}
+	if err := kube.Get(context.Background(), req.NamespacedName, got); err != nil {
+		t.Fatalf("want the AnsibleRunClaim kept while its AnsibleRun is being deleted, got error %v", err)
+	}
+
+	// then the AnsibleRunClaim, once its AnsibleRun is gone
+	run.SetFinalizers(nil)
+	if err := kube.Update(context.Background(), run); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("r.Reconcile(...): unexpected error: %v", err)
+	}
+	if err := kube.Get(context.Background(), req.NamespacedName, got); !kerrors.IsNotFound(err) {
+		t.Errorf("want the AnsibleRunClaim deleted once its AnsibleRun is gone, got error %v", err)
+	}
+}
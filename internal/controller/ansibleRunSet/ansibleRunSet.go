@@ -0,0 +1,271 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerunset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/controller"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/crossplane/crossplane-runtime/pkg/meta"
+	"github.com/crossplane/crossplane-runtime/pkg/ratelimiter"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	errGetRunSet     = "cannot get AnsibleRunSet"
+	errListRuns      = "cannot list the AnsibleRuns of the AnsibleRunSet"
+	errApplyRun      = "cannot create or update AnsibleRun"
+	errDeleteRun     = "cannot delete AnsibleRun"
+	errMergeVars     = "cannot merge the vars of item"
+	errUpdateStatus  = "cannot update the status of the AnsibleRunSet"
+	errNotControlled = "AnsibleRun exists and is not controlled by the AnsibleRunSet"
+
+	reasonApplyRun  = event.Reason("CannotApplyAnsibleRun")
+	reasonDeleteRun = event.Reason("CannotDeleteAnsibleRun")
+
+	reconcileTimeout = 1 * time.Minute
+)
+
+// LabelKeyRunSet is the name of a label holding the name of the AnsibleRunSet
+// of an AnsibleRun.
+const LabelKeyRunSet = "ansible.crossplane.io/runset"
+
+// Setup adds a controller that reconciles AnsibleRunSets, by making an
+// AnsibleRun for each of their items.
+func Setup(mgr ctrl.Manager, o controller.Options) error {
+	name := "runset/" + v1alpha1.AnsibleRunSetGroupKind
+
+	r := &Reconciler{
+		kube:   mgr.GetClient(),
+		log:    o.Logger.WithValues("controller", name),
+		record: event.NewAPIRecorder(mgr.GetEventRecorderFor(name)),
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(name).
+		WithOptions(o.ForControllerRuntime()).
+		For(&v1alpha1.AnsibleRunSet{}).
+		Owns(&v1alpha1.AnsibleRun{}).
+		Complete(ratelimiter.NewReconciler(name, r, o.GlobalRateLimiter))
+}
+
+// A Reconciler reconciles AnsibleRunSets.
+type Reconciler struct {
+	kube   client.Client
+	log    logging.Logger
+	record event.Recorder
+}
+
+// Reconcile makes the AnsibleRuns of the items of an AnsibleRunSet from its
+// template, deletes the ones of the removed items, and reports how many of
+// them are ready, drifted and failed.
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	log := r.log.WithValues("request", req)
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+	defer cancel()
+
+	set := &v1alpha1.AnsibleRunSet{}
+	if err := r.kube.Get(ctx, req.NamespacedName, set); err != nil {
+		// the AnsibleRuns are garbage collected through their owner reference
+		return reconcile.Result{}, client.IgnoreNotFound(fmt.Errorf("%s: %w", errGetRunSet, err))
+	}
+	if meta.WasDeleted(set) {
+		return reconcile.Result{}, nil
+	}
+
+	if err := r.reconcileRuns(ctx, set); err != nil {
+		log.Debug("Cannot reconcile the AnsibleRuns", "error", err)
+		set.Status.SetConditions(xpv1.ReconcileError(err))
+		return reconcile.Result{}, r.updateStatus(ctx, set)
+	}
+	set.Status.SetConditions(xpv1.ReconcileSuccess())
+	return reconcile.Result{}, r.updateStatus(ctx, set)
+}
+
+// reconcileRuns creates or updates the AnsibleRuns of the items of the
+// supplied AnsibleRunSet, deletes the others, and aggregates their status.
+func (r *Reconciler) reconcileRuns(ctx context.Context, set *v1alpha1.AnsibleRunSet) error {
+	desired := make(map[string]bool, len(set.Spec.Items))
+	for _, item := range set.Spec.Items {
+		run, err := desiredRun(set, item)
+		if err != nil {
+			return err
+		}
+		desired[run.GetName()] = true
+		if err := r.applyRun(ctx, set, run); err != nil {
+			r.record.Event(set, event.Warning(reasonApplyRun, err))
+			return fmt.Errorf("%s %s: %w", errApplyRun, run.GetName(), err)
+		}
+	}
+
+	runs := &v1alpha1.AnsibleRunList{}
+	if err := r.kube.List(ctx, runs, client.MatchingLabels{LabelKeyRunSet: set.GetName()}); err != nil {
+		return fmt.Errorf("%s: %w", errListRuns, err)
+	}
+	var owned []v1alpha1.AnsibleRun
+	for i := range runs.Items {
+		run := &runs.Items[i]
+		if !metav1.IsControlledBy(run, set) {
+			continue
+		}
+		if desired[run.GetName()] {
+			owned = append(owned, *run)
+			continue
+		}
+		if err := r.kube.Delete(ctx, run); client.IgnoreNotFound(err) != nil {
+			r.record.Event(set, event.Warning(reasonDeleteRun, err))
+			return fmt.Errorf("%s %s: %w", errDeleteRun, run.GetName(), err)
+		}
+	}
+
+	setRunsStatus(set, owned)
+	return nil
+}
+
+// applyRun creates the supplied AnsibleRun of the AnsibleRunSet, or updates
+// the existing one with its labels, annotations and spec. The deletion
+// policy and the unset fields late initialized by the AnsibleRun controller
+// are left as is, so that both controllers don't fight over them.
+func (r *Reconciler) applyRun(ctx context.Context, set *v1alpha1.AnsibleRunSet, desired *v1alpha1.AnsibleRun) error {
+	run := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: desired.GetName()}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.kube, run, func() error {
+		if run.GetResourceVersion() != "" && !metav1.IsControlledBy(run, set) {
+			return errors.New(errNotControlled)
+		}
+		meta.AddLabels(run, desired.GetLabels())
+		meta.AddAnnotations(run, desired.GetAnnotations())
+		deletionPolicy := run.Spec.DeletionPolicy
+		stateVar := run.Spec.ForProvider.StateVar
+		run.Spec = desired.Spec
+		if deletionPolicy != "" {
+			run.Spec.DeletionPolicy = deletionPolicy
+		}
+		if run.Spec.ForProvider.StateVar == "" {
+			run.Spec.ForProvider.StateVar = stateVar
+		}
+		return controllerutil.SetControllerReference(set, run, r.kube.Scheme())
+	})
+	return err
+}
+
+// desiredRun returns the AnsibleRun of the supplied item of the AnsibleRunSet.
+func desiredRun(set *v1alpha1.AnsibleRunSet, item v1alpha1.AnsibleRunSetItem) (*v1alpha1.AnsibleRun, error) {
+	tmpl := set.Spec.Template.DeepCopy()
+	run := &v1alpha1.AnsibleRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-%s", set.GetName(), item.Name),
+			Labels:      tmpl.Metadata.Labels,
+			Annotations: tmpl.Metadata.Annotations,
+		},
+		Spec: tmpl.Spec,
+	}
+	meta.AddLabels(run, map[string]string{LabelKeyRunSet: set.GetName()})
+	if item.InventoryInline != nil {
+		inventory := *item.InventoryInline
+		run.Spec.ForProvider.InventoryInline = &inventory
+	}
+	vars, err := mergeVars(run.Spec.ForProvider.Vars, item.Vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", errMergeVars, item.Name, err)
+	}
+	run.Spec.ForProvider.Vars = vars
+	return run, nil
+}
+
+// mergeVars returns the vars of the template with the top-level keys of the
+// vars of an item replacing its own.
+func mergeVars(tmpl, item runtime.RawExtension) (runtime.RawExtension, error) {
+	if len(item.Raw) == 0 {
+		return tmpl, nil
+	}
+	if len(tmpl.Raw) == 0 {
+		return item, nil
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(tmpl.Raw, &merged); err != nil {
+		return runtime.RawExtension{}, err
+	}
+	itemVars := map[string]json.RawMessage{}
+	if err := json.Unmarshal(item.Raw, &itemVars); err != nil {
+		return runtime.RawExtension{}, err
+	}
+	for k, v := range itemVars {
+		merged[k] = v
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return runtime.RawExtension{}, err
+	}
+	return runtime.RawExtension{Raw: b}, nil
+}
+
+// setRunsStatus reports how many of the supplied AnsibleRuns of the
+// AnsibleRunSet are ready, drifted and failed, the AnsibleRunSet being ready
+// when all are ready.
+func setRunsStatus(set *v1alpha1.AnsibleRunSet, runs []v1alpha1.AnsibleRun) {
+	var ready, drifted, failed int
+	for _, run := range runs {
+		if run.Status.AtProvider.Drifted {
+			drifted++
+		}
+		switch c := run.GetCondition(xpv1.TypeReady); {
+		case c.Status == corev1.ConditionTrue:
+			ready++
+		case c.Status == corev1.ConditionFalse && c.Reason != xpv1.ReasonCreating && c.Reason != xpv1.ReasonDeleting:
+			// the AnsibleRun controller reports failed runs as unavailable,
+			// with the kind of failure as the reason
+			failed++
+		}
+	}
+	set.Status.Runs = len(runs)
+	set.Status.ReadyRuns = ready
+	set.Status.DriftedRuns = drifted
+	set.Status.FailedRuns = failed
+	if ready == len(set.Spec.Items) {
+		set.Status.SetConditions(xpv1.Available())
+		return
+	}
+	cond := xpv1.Unavailable()
+	cond.Message = fmt.Sprintf("%d of %d AnsibleRuns are ready", ready, len(set.Spec.Items))
+	if failed > 0 {
+		cond.Message += fmt.Sprintf(", %d failed", failed)
+	}
+	set.Status.SetConditions(cond)
+}
+
+func (r *Reconciler) updateStatus(ctx context.Context, set *v1alpha1.AnsibleRunSet) error {
+	if err := r.kube.Status().Update(ctx, set); err != nil && !kerrors.IsConflict(err) {
+		return fmt.Errorf("%s: %w", errUpdateStatus, err)
+	}
+	// a conflict means the AnsibleRunSet changed, it is reconciled again anyway
+	return nil
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ansiblerunset
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/crossplane-contrib/provider-ansible/apis/v1alpha1"
+	xpv1 "github.com/crossplane/crossplane-runtime/apis/common/v1"
+	"github.com/crossplane/crossplane-runtime/pkg/event"
+	"github.com/crossplane/crossplane-runtime/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestMergeVars(t *testing.T) {
+	cases := map[string]struct {
+		tmpl string
+		item string
+		want string
+	}{
+		"NoItemVars": {
+			tmpl: `{"a":1}`,
+			want: `{"a":1}`,
+		},
+		"NoTemplateVars": {
+			item: `{"b":2}`,
+			want: `{"b":2}`,
+		},
+		"TopLevelKeysReplaced": {
+			tmpl: `{"a":1,"nested":{"x":1,"y":2}}`,
+			item: `{"b":2,"nested":{"x":3}}`,
+			want: `{"a":1,"b":2,"nested":{"x":3}}`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := mergeVars(runtime.RawExtension{Raw: []byte(tc.tmpl)}, runtime.RawExtension{Raw: []byte(tc.item)})
+			if err != nil {
+				t.Fatalf("mergeVars(...): unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, string(got.Raw)); diff != "" {
+				t.Errorf("mergeVars(...): -want, +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	inventory := "web1"
+	playbook := "- hosts: all"
+	set := func(items ...v1alpha1.AnsibleRunSetItem) *v1alpha1.AnsibleRunSet {
+		return &v1alpha1.AnsibleRunSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "fleet", UID: "fleet-uid"},
+			Spec: v1alpha1.AnsibleRunSetSpec{
+				Template: v1alpha1.AnsibleRunTemplate{
+					Metadata: v1alpha1.AnsibleRunTemplateMetadata{
+						Labels:      map[string]string{"team": "infra"},
+						Annotations: map[string]string{"ansible.crossplane.io/runPolicy": "CheckWhenObserve"},
+					},
+					Spec: v1alpha1.AnsibleRunSpec{
+						ForProvider: v1alpha1.AnsibleRunParameters{
+							PlaybookInline: &playbook,
+							Vars:           runtime.RawExtension{Raw: []byte(`{"env":"prod"}`)},
+						},
+					},
+				},
+				Items: items,
+			},
+		}
+	}
+	owned := func(s *v1alpha1.AnsibleRunSet, name string, ready bool) *v1alpha1.AnsibleRun {
+		run := &v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Labels:          map[string]string{LabelKeyRunSet: s.GetName()},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(s, v1alpha1.AnsibleRunSetGroupVersionKind)},
+		}}
+		if ready {
+			run.SetConditions(xpv1.Available())
+		}
+		return run
+	}
+	unavailable := func(msg string) xpv1.Condition {
+		c := xpv1.Unavailable()
+		c.Message = msg
+		return c
+	}
+	failed := unavailable("boom")
+	failed.Reason = "TaskFailure"
+
+	type want struct {
+		runs       []string
+		status     v1alpha1.AnsibleRunSetStatus
+		web1Vars   string
+		web1Labels map[string]string
+		// web1StateVar is the stateVar late initialized by the AnsibleRun
+		// controller, which should be kept
+		web1StateVar string
+	}
+
+	cases := map[string]struct {
+		reason  string
+		set     *v1alpha1.AnsibleRunSet
+		objects func(s *v1alpha1.AnsibleRunSet) []client.Object
+		want    want
+	}{
+		"CreateRuns": {
+			reason: "We should create an AnsibleRun from the template for each item",
+			set: set(
+				v1alpha1.AnsibleRunSetItem{Name: "web1", InventoryInline: &inventory, Vars: runtime.RawExtension{Raw: []byte(`{"port":80}`)}},
+				v1alpha1.AnsibleRunSetItem{Name: "web2"},
+			),
+			want: want{
+				runs: []string{"fleet-web1", "fleet-web2"},
+				status: v1alpha1.AnsibleRunSetStatus{
+					ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{unavailable("0 of 2 AnsibleRuns are ready"), xpv1.ReconcileSuccess()}},
+					Runs:              2,
+				},
+				web1Vars:   `{"env":"prod","port":80}`,
+				web1Labels: map[string]string{"team": "infra", LabelKeyRunSet: "fleet"},
+			},
+		},
+		"DeleteRemovedItems": {
+			reason: "We should delete the AnsibleRuns of removed items, but not the AnsibleRuns the AnsibleRunSet doesn't control",
+			set:    set(v1alpha1.AnsibleRunSetItem{Name: "web1"}),
+			objects: func(s *v1alpha1.AnsibleRunSet) []client.Object {
+				foreign := owned(s, "foreign", false)
+				foreign.OwnerReferences = nil
+				web1 := owned(s, "fleet-web1", true)
+				web1.Spec.ForProvider.StateVar = "crossplane_state"
+				return []client.Object{web1, owned(s, "fleet-web2", true), foreign}
+			},
+			want: want{
+				runs: []string{"fleet-web1", "foreign"},
+				status: v1alpha1.AnsibleRunSetStatus{
+					ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{xpv1.Available(), xpv1.ReconcileSuccess()}},
+					Runs:              1,
+					ReadyRuns:         1,
+				},
+				web1Vars:     `{"env":"prod"}`,
+				web1Labels:   map[string]string{"team": "infra", LabelKeyRunSet: "fleet"},
+				web1StateVar: "crossplane_state",
+			},
+		},
+		"AggregateStatus": {
+			reason: "We should report how many AnsibleRuns are ready, drifted and failed",
+			set: set(
+				v1alpha1.AnsibleRunSetItem{Name: "web1"},
+				v1alpha1.AnsibleRunSetItem{Name: "web2"},
+				v1alpha1.AnsibleRunSetItem{Name: "web3"},
+				v1alpha1.AnsibleRunSetItem{Name: "web4"},
+			),
+			objects: func(s *v1alpha1.AnsibleRunSet) []client.Object {
+				drifted := owned(s, "fleet-web2", false)
+				drifted.Status.AtProvider.Drifted = true
+				drifted.SetConditions(failed)
+				creating := owned(s, "fleet-web3", false)
+				creating.SetConditions(xpv1.Creating())
+				return []client.Object{owned(s, "fleet-web1", true), drifted, creating, owned(s, "fleet-web4", false)}
+			},
+			want: want{
+				runs: []string{"fleet-web1", "fleet-web2", "fleet-web3", "fleet-web4"},
+				status: v1alpha1.AnsibleRunSetStatus{
+					ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{unavailable("1 of 4 AnsibleRuns are ready, 1 failed"), xpv1.ReconcileSuccess()}},
+					Runs:              4,
+					ReadyRuns:         1,
+					DriftedRuns:       1,
+					FailedRuns:        1,
+				},
+			},
+		},
+		"NotControlled": {
+			reason: "We should not take over an existing AnsibleRun the AnsibleRunSet doesn't control",
+			set:    set(v1alpha1.AnsibleRunSetItem{Name: "web1"}),
+			objects: func(s *v1alpha1.AnsibleRunSet) []client.Object {
+				return []client.Object{&v1alpha1.AnsibleRun{ObjectMeta: metav1.ObjectMeta{Name: "fleet-web1"}}}
+			},
+			want: want{
+				runs: []string{"fleet-web1"},
+				status: v1alpha1.AnsibleRunSetStatus{
+					ConditionedStatus: xpv1.ConditionedStatus{Conditions: []xpv1.Condition{
+						xpv1.ReconcileError(errors.New(errApplyRun + " fleet-web1: " + errNotControlled)),
+					}},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			if err := v1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+				t.Fatalf("Adding to scheme: %v", err)
+			}
+			objs := []client.Object{tc.set}
+			if tc.objects != nil {
+				objs = append(objs, tc.objects(tc.set)...)
+			}
+			kube := fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).WithStatusSubresource(&v1alpha1.AnsibleRunSet{}).Build()
+			r := &Reconciler{kube: kube, log: logging.NewNopLogger(), record: event.NewNopRecorder()}
+
+			if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: tc.set.GetName()}}); err != nil {
+				t.Fatalf("\n%s\nr.Reconcile(...): unexpected error: %v", tc.reason, err)
+			}
+
+			runs := &v1alpha1.AnsibleRunList{}
+			if err := kube.List(context.Background(), runs); err != nil {
+				t.Fatalf("Listing AnsibleRuns: %v", err)
+			}
+			var names []string
+			for _, run := range runs.Items {
+				names = append(names, run.GetName())
+				if run.GetName() != "fleet-web1" || tc.want.web1Labels == nil {
+					continue
+				}
+				if diff := cmp.Diff(tc.want.web1Vars, string(run.Spec.ForProvider.Vars.Raw)); diff != "" {
+					t.Errorf("\n%s\nvars: -want, +got:\n%s", tc.reason, diff)
+				}
+				if diff := cmp.Diff(tc.want.web1Labels, run.GetLabels()); diff != "" {
+					t.Errorf("\n%s\nlabels: -want, +got:\n%s", tc.reason, diff)
+				}
+				if diff := cmp.Diff(tc.want.web1StateVar, run.Spec.ForProvider.StateVar); diff != "" {
+					t.Errorf("\n%s\nstateVar: -want, +got:\n%s", tc.reason, diff)
+				}
+				if !metav1.IsControlledBy(&run, tc.set) {
+					t.Errorf("\n%s\nAnsibleRun %s is not controlled by the AnsibleRunSet", tc.reason, run.GetName())
+				}
+			}
+			if diff := cmp.Diff(tc.want.runs, names); diff != "" {
+				t.Errorf("\n%s\nAnsibleRuns: -want, +got:\n%s", tc.reason, diff)
+			}
+
+			got := &v1alpha1.AnsibleRunSet{}
+			if err := kube.Get(context.Background(), types.NamespacedName{Name: tc.set.GetName()}, got); err != nil {
+				t.Fatalf("Getting AnsibleRunSet: %v", err)
+			}
+			if diff := cmp.Diff(tc.want.status, got.Status); diff != "" {
+				t.Errorf("\n%s\nstatus: -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cronutil
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	type want struct {
+		domAny, dowAny bool
+		err            string
+	}
+	cases := map[string]struct {
+		reason string
+		spec   string
+		want   want
+	}{
+		"Unrestricted": {
+			reason: "We should not restrict the days when both day fields are *",
+			spec:   "0 0 * * *",
+			want:   want{domAny: true, dowAny: true},
+		},
+		"StepOfOne": {
+			reason: "We should not restrict the days when a day field is */1",
+			spec:   "0 0 */1 * 1",
+			want:   want{domAny: true},
+		},
+		"Step": {
+			reason: "We should restrict the days when a day field is */n",
+			spec:   "0 0 */2 * */2",
+		},
+		"Fields": {
+			reason: "We should reject an expression without 5 fields",
+			spec:   "0 0 * *",
+			want:   want{err: `"0 0 * *": ` + errFields},
+		},
+		"InvalidStep": {
+			reason: "We should reject a step below 1",
+			spec:   "*/0 * * * *",
+			want:   want{err: `"*/0 * * * *": invalid step in "*/0"`},
+		},
+		"InvalidRange": {
+			reason: "We should reject a range that isn't made of two values",
+			spec:   "0 a-b * * *",
+			want:   want{err: `"0 a-b * * *": invalid range "a-b"`},
+		},
+		"InvalidValue": {
+			reason: "We should reject a value that isn't a number",
+			spec:   "0 0 * jan *",
+			want:   want{err: `"0 0 * jan *": invalid value "jan"`},
+		},
+		"OutOfRange": {
+			reason: "We should reject a value out of the range of its field",
+			spec:   "60 0 * * *",
+			want:   want{err: `"60 0 * * *": "60" out of range 0-59`},
+		},
+		"ReversedRange": {
+			reason: "We should reject a range whose start is after its end",
+			spec:   "0 0 * * 5-1",
+			want:   want{err: `"0 0 * * 5-1": "5-1" out of range 0-7`},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := Parse(tc.spec)
+			var got want
+			if err != nil {
+				got.err = err.Error()
+			} else {
+				got.domAny, got.dowAny = s.domAny, s.dowAny
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(want{})); diff != "" {
+				t.Errorf("\n%s\nParse(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the clocks go forward at midnight
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// the times of the DST overlap are given as offsets
+	in := func(loc *time.Location) func(string) time.Time {
+		return func(s string) time.Time {
+			v, err := time.Parse("2006-01-02 15:04 -0700", s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v.In(loc)
+		}
+	}
+	local, chile := in(ny), in(santiago)
+
+	cases := map[string]struct {
+		reason string
+		spec   string
+		t      time.Time
+		want   time.Time
+	}{
+		"EveryMinute": {
+			reason: "We should return the next minute, strictly after the supplied time",
+			spec:   "* * * * *",
+			t:      utc("2024-01-01 10:00"),
+			want:   utc("2024-01-01 10:01"),
+		},
+		"List": {
+			reason: "We should match any value of a list",
+			spec:   "0,30 9,17 * * *",
+			t:      utc("2024-01-01 09:10"),
+			want:   utc("2024-01-01 09:30"),
+		},
+		"ListNextDay": {
+			reason: "We should match the first values of a list on the next day after the last ones",
+			spec:   "0,30 9,17 * * *",
+			t:      utc("2024-01-01 17:30"),
+			want:   utc("2024-01-02 09:00"),
+		},
+		"Range": {
+			reason: "We should match any value of a range",
+			spec:   "0 9-17 * * 1-5",
+			t:      utc("2024-01-05 18:00"),
+			want:   utc("2024-01-08 09:00"),
+		},
+		"Step": {
+			reason: "We should match every n values of */n",
+			spec:   "*/15 * * * *",
+			t:      utc("2024-01-01 10:16"),
+			want:   utc("2024-01-01 10:30"),
+		},
+		"RangeStep": {
+			reason: "We should match every n values of a range from its start",
+			spec:   "0 8-18/4 * * *",
+			t:      utc("2024-01-01 12:01"),
+			want:   utc("2024-01-01 16:00"),
+		},
+		"ValueStep": {
+			reason: "We should match every n values from a up to the maximum for a/n",
+			spec:   "5/20 * * * *",
+			t:      utc("2024-01-01 10:26"),
+			want:   utc("2024-01-01 10:45"),
+		},
+		"DayOfMonth": {
+			reason: "We should match the day of month alone when the day of week is unrestricted",
+			spec:   "0 0 13 * *",
+			t:      utc("2024-01-01 00:00"),
+			want:   utc("2024-01-13 00:00"),
+		},
+		"DayOfWeek": {
+			reason: "We should match the day of week alone when the day of month is unrestricted",
+			spec:   "0 0 * * 5",
+			t:      utc("2024-01-01 00:00"),
+			want:   utc("2024-01-05 00:00"),
+		},
+		"DayOfMonthOrDayOfWeek": {
+			reason: "We should match either day field when both are restricted, here the day of week",
+			spec:   "0 0 13 * 5",
+			t:      utc("2024-01-01 00:00"),
+			want:   utc("2024-01-05 00:00"),
+		},
+		"DayOfWeekOrDayOfMonth": {
+			reason: "We should match either day field when both are restricted, here the day of month",
+			spec:   "0 0 13 * 5",
+			t:      utc("2024-01-12 00:00"),
+			want:   utc("2024-01-13 00:00"),
+		},
+		"DayOfMonthStep": {
+			reason: "We should consider a day of month with a step restricted, matching either day field",
+			spec:   "0 0 */10 * 1",
+			t:      utc("2024-01-01 00:00"),
+			want:   utc("2024-01-08 00:00"),
+		},
+		"SundayAsZero": {
+			reason: "We should match Sunday as 0",
+			spec:   "0 0 * * 0",
+			t:      utc("2024-01-01 00:00"),
+			want:   utc("2024-01-07 00:00"),
+		},
+		"SundayAsSeven": {
+			reason: "We should match Sunday as 7",
+			spec:   "0 0 * * 7",
+			t:      utc("2024-01-01 00:00"),
+			want:   utc("2024-01-07 00:00"),
+		},
+		"RangeToSeven": {
+			reason: "We should match Sunday at the end of a range ending on 7",
+			spec:   "0 0 * * 6-7",
+			t:      utc("2024-01-06 00:00"),
+			want:   utc("2024-01-07 00:00"),
+		},
+		"Month": {
+			reason: "We should skip the months that don't match",
+			spec:   "0 0 1 3 *",
+			t:      utc("2024-01-15 00:00"),
+			want:   utc("2024-03-01 00:00"),
+		},
+		"LeapDay": {
+			reason: "We should find the next leap day",
+			spec:   "0 0 29 2 *",
+			t:      utc("2024-03-01 00:00"),
+			want:   utc("2028-02-29 00:00"),
+		},
+		"Never": {
+			reason: "We should return the zero time when the schedule never matches",
+			spec:   "0 0 30 2 *",
+			t:      utc("2024-01-01 00:00"),
+		},
+		"Location": {
+			reason: "We should match the schedule in the location of the supplied time",
+			spec:   "0 9 * * *",
+			t:      local("2024-01-01 10:00 -0500"),
+			want:   local("2024-01-02 09:00 -0500"),
+		},
+		"DSTGap": {
+			reason: "We should skip a time that doesn't exist when the clocks go forward",
+			spec:   "30 2 * * *",
+			t:      local("2024-03-10 00:00 -0500"),
+			want:   local("2024-03-11 02:30 -0400"),
+		},
+		"DSTGapHourly": {
+			reason: "We should match the first hour after the clocks go forward",
+			spec:   "0 * * * *",
+			t:      local("2024-03-10 01:30 -0500"),
+			want:   local("2024-03-10 03:00 -0400"),
+		},
+		"DSTGapAtMidnight": {
+			reason: "We should match the next day when the clocks go forward at its midnight",
+			spec:   "0 12 * * *",
+			t:      chile("2024-09-07 13:00 -0400"),
+			want:   chile("2024-09-08 12:00 -0300"),
+		},
+		"DSTGapAtMidnightFirstHour": {
+			reason: "We should match the first hour of a day whose midnight is skipped",
+			spec:   "0 * * * *",
+			t:      chile("2024-09-07 23:30 -0400"),
+			want:   chile("2024-09-08 01:00 -0300"),
+		},
+		"DSTOverlapFirst": {
+			reason: "We should match the first occurrence of a time repeated when the clocks go back",
+			spec:   "30 1 * * *",
+			t:      local("2024-11-03 00:00 -0400"),
+			want:   local("2024-11-03 01:30 -0400"),
+		},
+		"DSTOverlapSecond": {
+			reason: "We should match the second occurrence of a time repeated when the clocks go back",
+			spec:   "30 1 * * *",
+			t:      local("2024-11-03 01:30 -0400"),
+			want:   local("2024-11-03 01:30 -0500"),
+		},
+		"DSTOverlapNextHour": {
+			reason: "We should match the hour following the repeated hour once",
+			spec:   "0 2 * * *",
+			t:      local("2024-11-03 01:30 -0400"),
+			want:   local("2024-11-03 02:00 -0500"),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := Parse(tc.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tc.spec, err)
+			}
+			got := s.Next(tc.t)
+			if !got.Equal(tc.want) {
+				t.Errorf("\n%s\nNext(%s): want %s, got %s", tc.reason, tc.t, tc.want, got)
+			}
+			if !got.IsZero() && got.Location() != tc.t.Location() {
+				t.Errorf("\n%s\nNext(%s): want the location %s, got %s", tc.reason, tc.t, tc.t.Location(), got.Location())
+			}
+		})
+	}
+}
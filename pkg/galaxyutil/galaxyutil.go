@@ -17,7 +17,15 @@ limitations under the License.
 package galaxyutil
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -29,3 +37,88 @@ const (
 func GalaxyBinary() (string, error) {
 	return exec.LookPath("ansible-galaxy")
 }
+
+// commitRegexp matches abbreviated and full git commit hashes
+var commitRegexp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// GitRepository returns the git repository of the src of a role, and whether
+// the role is fetched from git rather than from Ansible Galaxy.
+func GitRepository(src string) (string, bool) {
+	switch {
+	case strings.HasPrefix(src, "git+"):
+		return strings.TrimPrefix(src, "git+"), true
+	case strings.HasPrefix(src, "git@"), strings.HasSuffix(src, ".git"):
+		return src, true
+	}
+	return src, false
+}
+
+// ResolveFloatingVersion returns the commit the version of a role fetched
+// from the git repository at src currently points to, when the version
+// floats, i.e. it is omitted or names a branch. Tags and commits are not
+// expected to move, so they are not resolved and floating is false. The
+// supplied environment variables are added to the ones of git.
+func ResolveFloatingVersion(ctx context.Context, src, version string, env []string) (commit string, floating bool, err error) {
+	repo, ok := GitRepository(src)
+	if !ok || commitRegexp.MatchString(version) {
+		return "", false, nil
+	}
+	ref := "HEAD"
+	if version != "" {
+		ref = "refs/heads/" + version
+	}
+	// gosec is disabled here because of G204. The repository and the ref are
+	// passed as arguments after the options, not through a shell
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--", repo, ref) //nolint:gosec
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false, fmt.Errorf("cannot list the refs of %s: %w", repo, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		// the version is a tag
+		return "", false, nil
+	}
+	return fields[0], true, nil
+}
+
+// GitRepositoryURL returns the url of the git repository of a requirement,
+// without its scm prefix, version and subdirectory.
+func GitRepositoryURL(src string) string {
+	repo, _ := GitRepository(src)
+	if i := strings.IndexAny(repo, ",#"); i != -1 {
+		repo = repo[:i]
+	}
+	return repo
+}
+
+// BasicAuth are the credentials of a git repository accessed over https.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// GitTokenEnv returns the environment variables configuring git to send the
+// supplied credentials, by repository url, in the Authorization header of its
+// https requests. Unlike credentials embedded in the urls, they are neither
+// written to the requirements file nor logged.
+func GitTokenEnv(credentials map[string]BasicAuth) map[string]string {
+	if len(credentials) == 0 {
+		return nil
+	}
+	urls := make([]string, 0, len(credentials))
+	for url := range credentials {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	env := map[string]string{"GIT_CONFIG_COUNT": strconv.Itoa(len(urls))}
+	for i, url := range urls {
+		userToken := credentials[url].Username + ":" + credentials[url].Password
+		env[fmt.Sprintf("GIT_CONFIG_KEY_%d", i)] = "http." + url + ".extraHeader"
+		env[fmt.Sprintf("GIT_CONFIG_VALUE_%d", i)] = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(userToken))
+	}
+	return env
+}
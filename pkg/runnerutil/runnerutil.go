@@ -20,14 +20,85 @@ import (
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 const (
 	// PlaybookYml contains the inline playbook(s)
 	PlaybookYml = "playbook.yml"
 
+	// CreatePlaybookYml contains the inline playbook(s) of the first run
+	CreatePlaybookYml = "create.yml"
+
+	// RolesPlaybookYml contains the playbook generated to run several roles
+	RolesPlaybookYml = "roles.yml"
+
+	// DeletePlaybookYml contains the inline playbook(s) of the run deleting
+	// the AnsibleRun
+	DeletePlaybookYml = "delete.yml"
+
+	// DeleteRolesPlaybookYml contains the playbook generated to run the
+	// delete roles
+	DeleteRolesPlaybookYml = "delete-roles.yml"
+
+	// PlaybooksYml contains the playbook generated to run several
+	// playbooks in order
+	PlaybooksYml = "playbooks.yml"
+
+	// InlinePlaybooksDir is the subdirectory of the project directory the
+	// inline playbooks of the playbooks run in order are written into
+	InlinePlaybooksDir = "inline"
+
+	// LibrariesDir is the subdirectory of the project directory the
+	// libraries of contents are copied into
+	LibrariesDir = "libraries"
+
 	// Hosts is the inventory filename
 	Hosts = "hosts"
+
+	// Passwords is the filename of the prompt responses in the env directory
+	Passwords = "passwords"
+
+	// ExtraVars is the filename of the extra vars in the env directory
+	ExtraVars = "extravars"
+
+	// Settings is the filename of the runner settings in the env directory
+	Settings = "settings"
+
+	// Cmdline is the filename of the ansible-playbook arguments in the env directory
+	Cmdline = "cmdline"
+)
+
+// Subdirectories of an ansible-runner private data dir, see
+// https://ansible.readthedocs.io/projects/runner/en/stable/intro/#runner-input-directory-hierarchy
+const (
+	// ProjectDir holds the playbooks, roles and any file they reference.
+	ProjectDir = "project"
+
+	// InventoryDir holds the inventory files.
+	InventoryDir = "inventory"
+
+	// EnvDir holds the runner settings, such as extravars.
+	EnvDir = "env"
+
+	// VaultDir holds the vault password files written by the provider. It
+	// is not part of the ansible-runner hierarchy.
+	VaultDir = "vault"
+
+	// SSHDir holds the private key, known hosts and configuration of the
+	// SSH connections written by the provider. It is not part of the
+	// ansible-runner hierarchy.
+	SSHDir = "ssh"
+
+	// HomeDir is the home directory of the runs, holding their ~/.ansible
+	// caches. It is not part of the ansible-runner hierarchy.
+	HomeDir = "home"
+
+	// TmpDir holds the temporary files of the runs on the controller. It is
+	// not part of the ansible-runner hierarchy.
+	TmpDir = "tmp"
 )
 
 // RunnerBinary searches for ansible-runner binary in the directories named by the PATH environment variable
@@ -35,6 +106,51 @@ func RunnerBinary() (string, error) {
 	return exec.LookPath("ansible-runner")
 }
 
+// InventoryBinary searches for ansible-inventory binary in the directories named by the PATH environment variable
+func InventoryBinary() (string, error) {
+	return exec.LookPath("ansible-inventory")
+}
+
+// Version is a version of ansible-runner
+type Version struct {
+	Major int
+	Minor int
+}
+
+// AtLeast tells whether the version is major.minor or later
+func (v Version) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// versionRegexp matches the major and minor versions printed by ansible-runner --version
+var versionRegexp = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// RunnerVersion returns the version of the supplied ansible-runner binary
+func RunnerVersion(binary string) (Version, error) {
+	out, err := exec.Command(binary, "--version").Output() //nolint:gosec
+	if err != nil {
+		return Version{}, err
+	}
+	return ParseVersion(string(out))
+}
+
+// ParseVersion parses the output of ansible-runner --version, e.g. 2.3.4
+func ParseVersion(s string) (Version, error) {
+	m := versionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("cannot parse ansible-runner version %q", strings.TrimSpace(s))
+	}
+	major, err := strconv.Atoi(m[1])
+	if err != nil {
+		return Version{}, err
+	}
+	minor, err := strconv.Atoi(m[2])
+	if err != nil {
+		return Version{}, err
+	}
+	return Version{Major: major, Minor: minor}, nil
+}
+
 // GetFullPath returns the absolute path of role/playbook in working directory
 func GetFullPath(workingDir, path string) string {
 	return filepath.Join(workingDir, path)
@@ -48,3 +164,20 @@ func ConvertMapToSlice(values map[string]string) []string {
 	}
 	return result
 }
+
+// unquoted matches arguments that need no quoting in a shell command line
+var unquoted = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote joins args into a command line that splits back into args, as
+// ansible-runner does with shlex.split when reading env/cmdline
+func ShellQuote(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		if unquoted.MatchString(a) {
+			quoted = append(quoted, a)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(a, "'", `'"'"'`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statusutil
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// MaxMessageBytes is the size budget of a single status message
+	MaxMessageBytes = 2048
+
+	// MaxListBytes is the size budget of all the messages of a status list
+	MaxListBytes = 16 * 1024
+
+	// MaxListItems is the budget on the number of items of a status list
+	MaxListItems = 100
+
+	truncatedNote = "... (truncated)"
+)
+
+// Message truncates s to at most max bytes, without splitting a UTF-8
+// character, and notes the truncation at the end of the message.
+func Message(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len(truncatedNote)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + truncatedNote
+}
+
+// Newest keeps the most recent messages of l, the last ones, that fit in the
+// budget of max bytes, each message being truncated to MaxMessageBytes first.
+// When messages are dropped, a note telling how many replaces the first one.
+func Newest(l []string, max int) []string {
+	if len(l) == 0 {
+		return l
+	}
+	kept := make([]string, 0, len(l))
+	size := 0
+	for i := len(l) - 1; i >= 0; i-- {
+		m := Message(l[i], MaxMessageBytes)
+		if size+len(m) > max || len(kept) == MaxListItems {
+			break
+		}
+		size += len(m)
+		kept = append(kept, m)
+	}
+	if dropped := len(l) - len(kept); dropped > 0 {
+		if len(kept) > 0 {
+			// make room for the note
+			kept = kept[:len(kept)-1]
+			dropped++
+		}
+		kept = append(kept, fmt.Sprintf("%d earlier entries truncated", dropped))
+	}
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	return kept
+}
+
+// Head keeps the first max items of l, which are expected to be sorted by
+// decreasing severity.
+func Head[T any](l []T, max int) []T {
+	if len(l) <= max {
+		return l
+	}
+	return l[:max]
+}
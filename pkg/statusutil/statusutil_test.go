@@ -0,0 +1,186 @@
+/*
+Copyright 2020 The Crossplane Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statusutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMessage(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		s      string
+		max    int
+		want   string
+	}{
+		"Empty": {
+			reason: "We should not change an empty message",
+			max:    10,
+		},
+		"WithinLimit": {
+			reason: "We should not change a message shorter than the limit",
+			s:      "boom",
+			max:    10,
+			want:   "boom",
+		},
+		"ExactLimit": {
+			reason: "We should not change a message of exactly the limit",
+			s:      strings.Repeat("x", 20),
+			max:    20,
+			want:   strings.Repeat("x", 20),
+		},
+		"OverLimit": {
+			reason: "We should truncate a message over the limit and note it, within the limit",
+			s:      strings.Repeat("x", 21),
+			max:    20,
+			want:   "xxxxx" + truncatedNote,
+		},
+		"Multibyte": {
+			reason: "We should not split a multibyte character",
+			// each é is 2 bytes, the cut at byte 5 falls in the third one
+			s:    strings.Repeat("é", 20),
+			max:  len(truncatedNote) + 5,
+			want: "éé" + truncatedNote,
+		},
+		"NoteOverLimit": {
+			reason: "We should only keep the note when it is longer than the limit",
+			s:      strings.Repeat("x", 20),
+			max:    len(truncatedNote) - 5,
+			want:   truncatedNote,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := Message(tc.s, tc.max)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nMessage(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("\n%s\nMessage(...): invalid UTF-8 %q", tc.reason, got)
+			}
+		})
+	}
+}
+
+func TestNewest(t *testing.T) {
+	messages := func(from, to, size int) []string {
+		var l []string
+		for i := from; i < to; i++ {
+			m := fmt.Sprintf("message %d", i)
+			l = append(l, m+strings.Repeat(".", size-len(m)))
+		}
+		return l
+	}
+	cases := map[string]struct {
+		reason string
+		l      []string
+		max    int
+		want   []string
+	}{
+		"Nil": {
+			reason: "We should not change a nil list",
+			max:    100,
+		},
+		"WithinBudget": {
+			reason: "We should keep all the messages within the budget",
+			l:      messages(0, 3, 20),
+			max:    100,
+			want:   messages(0, 3, 20),
+		},
+		"ExactBudget": {
+			reason: "We should keep all the messages filling exactly the budget",
+			l:      messages(0, 5, 20),
+			max:    100,
+			want:   messages(0, 5, 20),
+		},
+		"OverBudget": {
+			reason: "We should keep the newest messages, the first one replaced by a note of how many were dropped",
+			l:      messages(0, 6, 20),
+			max:    100,
+			want:   append([]string{"2 earlier entries truncated"}, messages(2, 6, 20)...),
+		},
+		"TooManyItems": {
+			reason: "We should keep at most MaxListItems messages",
+			l:      messages(0, MaxListItems+10, 20),
+			max:    MaxListBytes,
+			want:   append([]string{"11 earlier entries truncated"}, messages(11, MaxListItems+10, 20)...),
+		},
+		"LongMessage": {
+			reason: "We should truncate each message to MaxMessageBytes first",
+			l:      []string{strings.Repeat("x", MaxMessageBytes+1)},
+			max:    MaxListBytes,
+			want:   []string{strings.Repeat("x", MaxMessageBytes-len(truncatedNote)) + truncatedNote},
+		},
+		"NothingFits": {
+			reason: "We should only keep the note when no message fits in the budget",
+			l:      messages(0, 2, 20),
+			max:    10,
+			want:   []string{"2 earlier entries truncated"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, Newest(tc.l, tc.max)); diff != "" {
+				t.Errorf("\n%s\nNewest(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestHead(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		l      []int
+		max    int
+		want   []int
+	}{
+		"Nil": {
+			reason: "We should not change a nil list",
+			max:    2,
+		},
+		"WithinLimit": {
+			reason: "We should keep all the items within the limit",
+			l:      []int{1},
+			max:    2,
+			want:   []int{1},
+		},
+		"ExactLimit": {
+			reason: "We should keep all the items of a list of exactly the limit",
+			l:      []int{1, 2},
+			max:    2,
+			want:   []int{1, 2},
+		},
+		"OverLimit": {
+			reason: "We should keep the first items",
+			l:      []int{1, 2, 3},
+			max:    2,
+			want:   []int{1, 2},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, Head(tc.l, tc.max)); diff != "" {
+				t.Errorf("\n%s\nHead(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}